@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/handlers"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+var auditJSONOutput bool
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Audit log maintenance",
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the audit log has not been tampered with",
+	Long: `Recomputes the audit log hash chain and checks it against every signed checkpoint.
+Exits with status 1 if an entry was modified, removed or reordered.
+
+Examples:
+  openid-server audit verify
+  openid-server audit verify --json`,
+	Run: runAuditVerify,
+}
+
+var auditCheckpointCmd = &cobra.Command{
+	Use:   "checkpoint",
+	Short: "Sign a checkpoint of the current audit log head",
+	Run:   runAuditCheckpoint,
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditVerifyCmd, auditCheckpointCmd)
+	auditVerifyCmd.Flags().BoolVar(&auditJSONOutput, "json", false, "print the result as JSON")
+}
+
+// openConfiguredStorage opens the storage named by the server configuration
+func openConfiguredStorage() (storage.Storage, error) {
+	configData, err := loadServerConfig()
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewStorage(configData)
+}
+
+// loadServerConfig loads the configuration of a server that has been set up
+func loadServerConfig() (*configstore.ConfigData, error) {
+	ctx := context.Background()
+	loaderCfg := configstore.LoaderConfig{
+		MongoURIEnv:      "MONGODB_URI",
+		MongoDatabaseEnv: "MONGODB_DATABASE",
+		ConfigFileEnv:    "OPENID_CONFIG_FILE",
+		SecretsDirEnv:    "OPENID_SECRETS_DIR",
+		JSONFilePath:     "data/config.json",
+	}
+	configStoreInstance, initialized, err := configstore.AutoLoadConfigStore(ctx, loaderCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config store: %w", err)
+	}
+	defer func() { _ = configStoreInstance.Close() }()
+	if !initialized {
+		return nil, fmt.Errorf("server is not configured; run setup first")
+	}
+
+	configData, err := configStoreInstance.GetConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	return configData, nil
+}
+
+func runAuditVerify(cmd *cobra.Command, args []string) {
+	store, err := openConfiguredStorage()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	defer func() { _ = store.Close() }()
+
+	result, err := handlers.VerifyAuditChain(store)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to verify audit log: %v\n", err)
+		os.Exit(1)
+	}
+
+	if auditJSONOutput {
+		out, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		fmt.Printf("Entries:      %d (seq %d to %d, %d redacted)\n", result.Entries, result.FirstSeq, result.LastSeq, result.Redacted)
+		fmt.Printf("Checkpoints:  %d verified, %d before retained history\n", result.CheckpointsVerified, result.CheckpointsPruned)
+		for _, w := range result.Warnings {
+			fmt.Printf("⚠️  %s\n", w)
+		}
+		for _, p := range result.Problems {
+			fmt.Printf("❌ %s\n", p)
+		}
+		if result.Valid() {
+			fmt.Println("✅ Audit log is intact")
+		}
+	}
+	if !result.Valid() {
+		os.Exit(1)
+	}
+}
+
+func runAuditCheckpoint(cmd *cobra.Command, args []string) {
+	store, err := openConfiguredStorage()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	defer func() { _ = store.Close() }()
+
+	checkpoint, err := handlers.CheckpointAuditChain(store)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to checkpoint audit log: %v\n", err)
+		os.Exit(1)
+	}
+	if checkpoint == nil {
+		fmt.Println("Audit log head is already checkpointed")
+		return
+	}
+	fmt.Printf("✅ Signed checkpoint at entry %d with key %s\n", checkpoint.Seq, checkpoint.SigningKeyID)
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// stripBasePath lets routes be registered at the root while the server is mounted
+// under basePath. Requests without the prefix are passed through unchanged, so a
+// reverse proxy that already strips it keeps working.
+func stripBasePath(basePath string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if path := req.URL.Path; path == basePath || strings.HasPrefix(path, basePath+"/") {
+				req.URL.Path = strings.TrimPrefix(path, basePath)
+				if req.URL.Path == "" {
+					req.URL.Path = "/"
+				}
+				req.URL.RawPath = ""
+			}
+			return next(c)
+		}
+	}
+}
+
+// basePathFS serves the admin UI under a base path. The UI is built for the root,
+// so its index.html gets its root-relative asset URLs prefixed and learns the base
+// path through window.__BASE_PATH__.
+type basePathFS struct {
+	fs.FS
+	basePath string
+}
+
+func (b basePathFS) Open(name string) (fs.File, error) {
+	f, err := b.FS.Open(name)
+	if err != nil || name != "index.html" {
+		return f, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	html := strings.ReplaceAll(string(raw), `="/`, `="`+b.basePath+`/`)
+	script := `<script>window.__BASE_PATH__=` + strconv.Quote(b.basePath) + `</script>`
+	if i := strings.Index(html, "</head>"); i >= 0 {
+		html = html[:i] + script + html[i:]
+	} else {
+		html = script + html
+	}
+	return &memFile{Reader: bytes.NewReader([]byte(html)), name: info.Name(), modTime: info.ModTime()}, nil
+}
+
+// memFile is an in-memory fs.File that supports seeking, as http.ServeContent requires
+type memFile struct {
+	*bytes.Reader
+	name    string
+	modTime time.Time
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f, nil }
+func (f *memFile) Close() error               { return nil }
+
+func (f *memFile) Name() string       { return f.name }
+func (f *memFile) Size() int64        { return f.Reader.Size() }
+func (f *memFile) Mode() fs.FileMode  { return 0444 }
+func (f *memFile) ModTime() time.Time { return f.modTime }
+func (f *memFile) IsDir() bool        { return false }
+func (f *memFile) Sys() interface{}   { return nil }
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/prasenjit-net/openid-golang/pkg/handlers"
+)
+
+var (
+	breakGlassReason string
+	breakGlassTTL    time.Duration
+)
+
+var breakGlassCmd = &cobra.Command{
+	Use:   "break-glass",
+	Short: "Issue a one-time code for emergency admin access",
+	Long: `Issues a one-time code that can be exchanged for a short-lived admin token, for
+when no administrator can sign in (for example after losing an MFA device).
+The code works once and stops working when the access window ends. Redeeming it
+and every request made with the resulting token are written to the audit log.
+
+Examples:
+  openid-server break-glass --reason "admin MFA device lost"
+  openid-server break-glass --reason "IdP outage" --ttl 5m`,
+	Run: runBreakGlass,
+}
+
+func init() {
+	rootCmd.AddCommand(breakGlassCmd)
+	breakGlassCmd.Flags().StringVar(&breakGlassReason, "reason", "", "why emergency access is needed (recorded in the audit log)")
+	breakGlassCmd.Flags().DurationVar(&breakGlassTTL, "ttl", handlers.DefaultBreakGlassTTL, "how long the code and its admin token stay valid")
+	_ = breakGlassCmd.MarkFlagRequired("reason")
+}
+
+func runBreakGlass(cmd *cobra.Command, args []string) {
+	configData, err := loadServerConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	code, expiresAt, err := handlers.IssueBreakGlassCode(configData, breakGlassReason, breakGlassIssuer(), breakGlassTTL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to issue break-glass code: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("⚠️  Emergency admin access code (works once):")
+	fmt.Println()
+	fmt.Println(code)
+	fmt.Println()
+	fmt.Printf("Valid until %s. Exchange it for an admin token with:\n", expiresAt.Local().Format(time.RFC1123))
+	fmt.Printf("  curl -X POST %s/api/admin/break-glass -H 'Content-Type: application/json' -d '{\"code\":\"<code>\"}'\n",
+		strings.TrimSuffix(configData.Issuer, "/"))
+}
+
+// breakGlassIssuer names the operating system user and host issuing a code
+func breakGlassIssuer() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		name += "@" + host
+	}
+	return name
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/prasenjit-net/openid-golang/pkg/setup"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+var (
+	migrateApply      bool
+	migrateJSONOutput bool
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Migrate data from older versions of the server",
+}
+
+var migrateLegacySQLiteCmd = &cobra.Command{
+	Use:   "legacy-sqlite <database>",
+	Short: "Copy users and clients from a database of the old internal/ SQLite storage",
+	Long: `Reads the users and clients of a deployment created by the old internal/
+storage layer (DB_TYPE=sqlite, ./openid.db by default) and creates them in the
+configured storage. Users keep their IDs and passwords and get the user role;
+clients keep their IDs and secrets. Tokens and sessions are not copied, so users
+sign in again. Records already in the storage, and users whose username or email
+address is taken, are skipped and reported.
+
+The database is opened read-only. Reading it needs a build with cgo enabled
+(CGO_ENABLED=1); the release binaries are built without it.
+
+Without --apply, only reports what would be copied.
+
+Examples:
+  openid-server migrate legacy-sqlite ./openid.db
+  openid-server migrate legacy-sqlite ./openid.db --apply`,
+	Args: cobra.ExactArgs(1),
+	Run:  runMigrateLegacySQLite,
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+	migrateCmd.AddCommand(migrateLegacySQLiteCmd)
+	migrateLegacySQLiteCmd.Flags().BoolVar(&migrateApply, "apply", false, "write the records instead of only reporting them")
+	migrateLegacySQLiteCmd.Flags().BoolVar(&migrateJSONOutput, "json", false, "print the result as JSON")
+}
+
+func runMigrateLegacySQLite(cmd *cobra.Command, args []string) {
+	legacy, err := setup.OpenLegacySQLite(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to open legacy database: %v\n", err)
+		os.Exit(1)
+	}
+	defer func() { _ = legacy.Close() }()
+
+	configData, err := loadServerConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	store, err := storage.NewStorage(configData)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to open storage: %v\n", err)
+		os.Exit(1)
+	}
+	defer func() { _ = store.Close() }()
+
+	result, err := setup.MigrateLegacySQLite(legacy, store, migrateApply)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to migrate legacy data: %v\n", err)
+		os.Exit(1)
+	}
+
+	if migrateJSONOutput {
+		out, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	verb := "Would copy"
+	if migrateApply {
+		verb = "Copied"
+	}
+	fmt.Printf("%s %d of %d users and %d of %d clients\n", verb, result.MigratedUsers, result.Users, result.MigratedClients, result.Clients)
+	for _, s := range result.Skipped {
+		fmt.Printf("⚠️  Skipping %s %s: %s\n", s.Kind, s.ID, s.Reason)
+	}
+	if !migrateApply && (result.MigratedUsers > 0 || result.MigratedClients > 0) {
+		fmt.Println("Run again with --apply to copy these records")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build cgo
+
+package cmd
+
+// The SQLite driver reads databases of the old internal/ storage layer for
+// `migrate legacy-sqlite`. It needs cgo, so builds without it leave it out and
+// the command reports that it is unavailable.
+import _ "github.com/mattn/go-sqlite3"
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/outbound"
+)
+
+// configWatchInterval is how often mounted config files are checked for changes.
+// Kubernetes updates ConfigMap and Secret volumes by swapping a symlink, so polling
+// the resolved files is more reliable than inotify on the mount.
+const configWatchInterval = 10 * time.Second
+
+// logEffectiveConfig logs the running configuration with secrets redacted
+func logEffectiveConfig(configData *configstore.ConfigData) {
+	data, err := json.Marshal(configData.Redacted())
+	if err != nil {
+		log.Printf("Warning: Failed to render effective config: %v", err)
+		return
+	}
+	log.Printf("Effective config: %s", data)
+}
+
+// reloadConfig re-reads configuration from the store and applies the settings that
+// can change at runtime. Changes that need a restart are logged and ignored.
+func reloadConfig(store configstore.ConfigStore, configData *configstore.ConfigData) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	next, err := store.GetConfig(ctx)
+	if err != nil {
+		log.Printf("Config reload failed, keeping current config: %v", err)
+		return
+	}
+	if err := next.Validate(); err != nil {
+		log.Printf("Reloaded config is invalid, keeping current config: %v", err)
+		return
+	}
+	if restart := configData.ApplyReload(next); len(restart) > 0 {
+		log.Printf("Config reloaded; changes to %v require a restart to take effect", restart)
+	} else {
+		log.Println("Config reloaded")
+	}
+	outbound.Configure(configData.OutboundHTTPState())
+	logEffectiveConfig(configData)
+}
+
+// watchConfigFiles polls the given files and signals on the returned channel when
+// any of them is created, removed or modified. It returns nil when there is
+// nothing to watch.
+func watchConfigFiles(ctx context.Context, paths []string, interval time.Duration) <-chan struct{} {
+	if len(paths) == 0 {
+		return nil
+	}
+	snapshot := func() map[string]time.Time {
+		mtimes := make(map[string]time.Time, len(paths))
+		for _, p := range paths {
+			if info, err := os.Stat(p); err == nil {
+				mtimes[p] = info.ModTime()
+			}
+		}
+		return mtimes
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last := snapshot()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				current := snapshot()
+				if !sameModTimes(last, current) {
+					last = current
+					select {
+					case changes <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+	return changes
+}
+
+// sameModTimes reports whether two file snapshots are identical
+func sameModTimes(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for p, t := range a {
+		if other, ok := b[p]; !ok || !other.Equal(t) {
+			return false
+		}
+	}
+	return true
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,10 +16,14 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/spf13/cobra"
 
+	"github.com/prasenjit-net/openid-golang/pkg/chaos"
 	"github.com/prasenjit-net/openid-golang/pkg/configstore"
 	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/events"
 	"github.com/prasenjit-net/openid-golang/pkg/handlers"
+	"github.com/prasenjit-net/openid-golang/pkg/logging"
 	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/outbound"
 	"github.com/prasenjit-net/openid-golang/pkg/session"
 	"github.com/prasenjit-net/openid-golang/pkg/storage"
 )
@@ -41,6 +46,8 @@ func runServe(cmd *cobra.Command, args []string) {
 	loaderCfg := configstore.LoaderConfig{
 		MongoURIEnv:      "MONGODB_URI",
 		MongoDatabaseEnv: "MONGODB_DATABASE",
+		ConfigFileEnv:    "OPENID_CONFIG_FILE",
+		SecretsDirEnv:    "OPENID_SECRETS_DIR",
 		JSONFilePath:     "data/config.json",
 	}
 
@@ -54,6 +61,11 @@ func runServe(cmd *cobra.Command, args []string) {
 		}
 	}()
 
+	// A mounted config cannot be written by the setup wizard
+	if _, mounted := configStoreInstance.(*configstore.MountedConfigStore); mounted && !initialized {
+		log.Fatalf("Mounted config from %s is incomplete: issuer and JWT private key are required", os.Getenv(loaderCfg.ConfigFileEnv))
+	}
+
 	// If not initialized, start in setup mode with hot-reload capability
 	if !initialized {
 		log.Println("Configuration not found. Starting in setup mode...")
@@ -69,7 +81,7 @@ func runServe(cmd *cobra.Command, args []string) {
 	}
 
 	// Start normal server with full OpenID functionality
-	runNormalMode(configData)
+	runNormalMode(configData, configStoreInstance)
 }
 
 // runSetupModeWithReload starts the server in setup mode and transitions to normal mode after initialization
@@ -85,7 +97,12 @@ func runSetupModeWithReload(configStoreInstance configstore.ConfigStore, loaderC
 	e.HidePort = true
 
 	// Middleware
-	e.Use(requestLogger())
+	accessLog, err := logging.AccessLog(configstore.LogSinkConfig{})
+	if err != nil {
+		log.Fatalf("Failed to set up access log: %v", err)
+	}
+	e.Use(middleware.RequestID())
+	e.Use(accessLog)
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORS())
 
@@ -103,6 +120,15 @@ func runSetupModeWithReload(configStoreInstance configstore.ConfigStore, loaderC
 	e.GET("/api/setup/status", bootstrapHandler.CheckInitialized)
 	e.POST("/api/setup/initialize", bootstrapHandler.Initialize)
 
+	// Stepwise setup, for driving a non-interactive install
+	e.GET("/api/setup/steps", bootstrapHandler.GetSetupSteps)
+	e.PUT("/api/setup/issuer", bootstrapHandler.SetSetupIssuer)
+	e.POST("/api/setup/keys", bootstrapHandler.GenerateSetupKeys)
+	e.PUT("/api/setup/storage", bootstrapHandler.ConfigureSetupStorage)
+	e.PUT("/api/setup/admin", bootstrapHandler.CreateSetupAdmin)
+	e.POST("/api/setup/demo-data", bootstrapHandler.CreateSetupDemoData)
+	e.POST("/api/setup/complete", bootstrapHandler.CompleteSetup)
+
 	// Redirect root to setup
 	e.GET("/", func(c echo.Context) error {
 		return c.Redirect(http.StatusFound, "/setup")
@@ -148,7 +174,7 @@ func runSetupModeWithReload(configStoreInstance configstore.ConfigStore, loaderC
 		}
 
 		log.Println("Restarting in NORMAL mode with full functionality...")
-		runNormalMode(configData)
+		runNormalMode(configData, configStoreInstance)
 
 	case <-quit:
 		log.Println("Shutting down server...")
@@ -163,7 +189,19 @@ func runSetupModeWithReload(configStoreInstance configstore.ConfigStore, loaderC
 }
 
 // runNormalMode starts the server in normal mode with full OpenID functionality
-func runNormalMode(configData *configstore.ConfigData) {
+func runNormalMode(configData *configstore.ConfigData, configStoreInstance configstore.ConfigStore) {
+	if err := logging.SetupApplication(configData.Logging.Application); err != nil {
+		log.Fatalf("Failed to set up application log: %v", err)
+	}
+	accessLog, err := logging.AccessLog(configData.Logging.Access)
+	if err != nil {
+		log.Fatalf("Failed to set up access log: %v", err)
+	}
+	logEffectiveConfig(configData)
+	if err := configData.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
 	// Initialize storage
 	store, err := storage.NewStorage(configData)
 	if err != nil {
@@ -175,6 +213,29 @@ func runNormalMode(configData *configstore.ConfigData) {
 		}
 	}()
 
+	// Limits on the HTTP requests the server makes to other hosts
+	outbound.Configure(configData.OutboundHTTP)
+
+	// Fault injection for resilience testing, only in -tags chaos builds
+	store = chaos.Apply(store, configData.Chaos)
+
+	// Refuse requests quickly while the database cannot be reached
+	storageBreaker := storage.NewBreaker(store, configData.Storage.Breaker)
+	if storageBreaker != nil {
+		storageBreaker.Start()
+		defer storageBreaker.Stop()
+	}
+
+	// Stream security events to the configured SIEM collectors
+	eventStream, err := events.NewStream(configData.Events, getVersion())
+	if err != nil {
+		log.Printf("Warning: Failed to start event exporters: %v", err)
+	} else if eventStream != nil {
+		defer eventStream.Close()
+		store = events.Wrap(store, eventStream)
+		log.Printf("Streaming security events to %d exporter(s)", len(configData.Events.Exporters))
+	}
+
 	// Ensure admin-ui client exists
 	adminClient, err := store.GetClientByID("admin-ui")
 	if err != nil || adminClient == nil {
@@ -184,13 +245,23 @@ func runNormalMode(configData *configstore.ConfigData) {
 		} else {
 			log.Println("Created admin-ui client")
 		}
+	} else if models.UpgradeAdminUIClient(adminClient) {
+		if updateErr := store.UpdateClient(adminClient); updateErr != nil {
+			log.Printf("Warning: Failed to switch admin-ui client to the authorization code flow: %v", updateErr)
+		} else {
+			log.Println("Switched admin-ui client to the authorization code flow")
+		}
 	}
 
 	// Ensure at least one signing key exists
 	existingKeys, err := store.GetAllSigningKeys()
+	hasSigningKey := false
+	for _, key := range existingKeys {
+		hasSigningKey = hasSigningKey || key.IsSigningKey()
+	}
 	if err != nil {
 		log.Printf("Warning: Failed to check existing signing keys: %v", err)
-	} else if len(existingKeys) == 0 {
+	} else if !hasSigningKey {
 		// No keys exist, create initial signing key from config
 		initialKey := &models.SigningKey{
 			ID:         uuid.New().String(),
@@ -223,28 +294,66 @@ func runNormalMode(configData *configstore.ConfigData) {
 	// Create session manager
 	sessionConfig := session.DefaultConfig(store)
 	sessionConfig.CookieSecure = configData.Server.Port == 443 // Secure cookies for HTTPS
+	if configData.RememberMe.ACR != "" {
+		sessionConfig.PersistentSessionACR = configData.RememberMe.ACR
+	}
+	basePath := configData.BasePath() // e.g. "/auth" when the issuer is https://example.com/auth
+	if basePath != "" {
+		sessionConfig.CookiePath = basePath
+	}
+	if configData.SessionCookies.Encrypt {
+		if err := session.EnsureCookieKey(store); err != nil {
+			log.Printf("Warning: Failed to create session cookie key: %v", err)
+		}
+		sessionConfig.CookieCodec = session.NewKeyringCodec(store, session.DefaultCookieKeyRefresh)
+	}
 	sessionManager := session.NewManager(sessionConfig)
 
 	// Create Echo instance
 	e := echo.New()
 	e.HideBanner = true
 	e.HidePort = true
+	ipExtractor, err := clientIPExtractor(configData.Server.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Failed to configure client addresses: %v", err)
+	}
+	e.IPExtractor = ipExtractor
 
 	// Middleware
-	e.Use(requestLogger())
+	if basePath != "" {
+		e.Pre(stripBasePath(basePath))
+	}
+	e.Use(middleware.RequestID())
+	e.Use(accessLog)
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORS())
 	e.Use(sessionManager.Middleware()) // Add session middleware
 
 	// Initialize handlers
 	h := handlers.NewHandlers(store, jwtManager, configData, sessionManager, publicFS)
+	e.Use(h.PayloadLogger())    // Redacted payload logging for debug-enabled clients
+	e.Use(h.DrainConnections()) // Close keep-alive connections once shutdown starts
+	e.Use(h.ErrorReferences())  // error_uri references for server errors and security rejections
+	h.SetStorageBreaker(storageBreaker)
+	h.StartRegistrationCleanup(1 * time.Hour)
+	h.StartRetentionCleanup()
+	h.StartReportScheduler()
+	h.StartAuditCheckpoints(1 * time.Hour)
+	if err := h.EnsureEncryptionKey(); err != nil {
+		log.Printf("Warning: Failed to create encryption key: %v", err)
+	}
+	h.StartEncryptionKeyRotation(24 * time.Hour)
+	h.StartCookieKeyRotation(24 * time.Hour)
+	h.StartKeyPurge(24 * time.Hour)
+	h.StartRedirectURIScan(24 * time.Hour)
 
 	// Register routes (without /setup - it's disabled in normal mode)
-	registerRoutes(e, h, configData)
+	registerRoutes(e, h, configData, configStoreInstance)
 
 	// Start server
 	addr := fmt.Sprintf("%s:%d", configData.Server.Host, configData.Server.Port)
-	log.Printf("Starting OpenID Connect Server v%s", getVersion())
+	handlers.Version = getVersion()
+	log.Printf("Starting OpenID Connect Server v%s (%s)", handlers.Version, handlers.BuildCommit)
 	log.Printf("Using %s storage", configData.Storage.Type)
 	log.Printf("Starting OpenID Connect server on %s", addr)
 	log.Printf("Issuer: %s", configData.Issuer)
@@ -256,10 +365,39 @@ func runNormalMode(configData *configstore.ConfigData) {
 		}
 	}()
 
-	// Wait for interrupt signal
+	// Reload config on SIGHUP or when mounted config files change
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	var changes <-chan struct{}
+	if watched, ok := configStoreInstance.(interface{ WatchPaths() []string }); ok {
+		changes = watchConfigFiles(watchCtx, watched.WatchPaths(), configWatchInterval)
+	}
+
+	// Wait for interrupt or termination signal
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
-	<-quit
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	for running := true; running; {
+		select {
+		case <-reload:
+			log.Println("Received SIGHUP, reloading config...")
+			reloadConfig(configStoreInstance, configData)
+		case <-changes:
+			log.Println("Mounted config changed, reloading config...")
+			reloadConfig(configStoreInstance, configData)
+		case <-quit:
+			running = false
+		}
+	}
+
+	// Fail the readiness probe and let load balancers move traffic away
+	// before in-flight requests are drained by Shutdown
+	h.BeginDrain()
+	if drain := configData.MaintenanceState().DrainSeconds; drain > 0 {
+		log.Printf("Draining connections for %ds...", drain)
+		time.Sleep(time.Duration(drain) * time.Second)
+	}
 
 	log.Println("Shutting down server...")
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -271,66 +409,156 @@ func runNormalMode(configData *configstore.ConfigData) {
 	log.Println("Server stopped")
 }
 
-func registerRoutes(e *echo.Echo, h *handlers.Handlers, cfg *configstore.ConfigData) {
+func registerRoutes(e *echo.Echo, h *handlers.Handlers, cfg *configstore.ConfigData, configStore configstore.ConfigStore) {
 	// OpenID Connect Discovery
 	e.GET("/.well-known/openid-configuration", h.Discovery)
 	e.GET("/.well-known/jwks.json", h.JWKS)
+	e.GET("/.well-known/openid-federation", h.FederationEntityConfiguration)
+	e.GET("/.well-known/token-metadata", h.TokenMetadata)
 
-	// OAuth/OpenID endpoints
-	e.GET("/authorize", h.Authorize)
-	e.POST("/token", h.Token)
-	e.POST("/revoke", h.Revoke)
-	e.POST("/introspect", h.Introspect)
-	e.GET("/userinfo", h.UserInfo)
-	e.POST("/userinfo", h.UserInfo)
+	// Readiness probe, failing while the server drains before shutdown
+	e.GET("/readyz", h.Ready)
 
-	// Dynamic Client Registration (if enabled)
-	if cfg.Registration.Enabled {
-		e.POST(cfg.Registration.Endpoint, h.Register)
-		e.GET(cfg.Registration.Endpoint+"/:client_id", h.GetClientConfiguration)
-		e.PUT(cfg.Registration.Endpoint+"/:client_id", h.UpdateClientConfiguration)
-		e.DELETE(cfg.Registration.Endpoint+"/:client_id", h.DeleteClientConfiguration)
+	// OAuth/OpenID endpoints
+	e.GET("/authorize", h.Authorize, h.MaintenanceGuard(), h.StorageGuard())
+	e.POST("/authorize", h.Authorize, h.MaintenanceGuard(), h.StorageGuard())
+	e.POST("/token", h.Token, h.MaintenanceGuard(), h.StorageGuard())
+	e.POST("/api/auth/login", h.FirstPartyLogin, h.MaintenanceGuard(), h.StorageGuard())
+	e.POST("/revoke", h.Revoke, h.StorageGuard())
+	e.POST("/introspect", h.Introspect, h.StorageGuard())
+	e.GET("/userinfo", h.UserInfo, h.StorageGuard())
+	e.POST("/userinfo", h.UserInfo, h.StorageGuard())
+	e.POST("/device_authorization", h.DeviceAuthorization, h.MaintenanceGuard(), h.StorageGuard())
+
+	// Uploaded avatars, and uploads by the user of a profile-scoped access token
+	e.GET("/avatars/:key", h.Avatar)
+	e.PUT("/account/avatar", h.UploadAvatar, h.StorageGuard())
+	e.DELETE("/account/avatar", h.DeleteAvatar, h.StorageGuard())
+
+	// Hosted page behind the error_uri of server errors and security rejections
+	e.GET("/errors/:reference", h.ErrorDetail)
+
+	// Dynamic Client Registration, on the configured endpoint while enabled
+	h.MountRegistration(e)
+
+	// Secret scanning alerts for leaked tokens (if enabled)
+	if cfg.SecretScanning.Enabled {
+		e.POST(cfg.SecretScanning.Endpoint, h.SecretScanningVerify)
 	}
 
 	// Login and consent pages
-	e.GET("/login", h.Login)
-	e.POST("/login", h.Login)
-	e.GET("/consent", h.Consent)
-	e.POST("/consent", h.Consent)
+	e.GET("/login", h.Login, h.StorageGuard())
+	e.POST("/login", h.Login, h.StorageGuard())
+	e.GET("/login/magic", h.MagicLinkLogin, h.StorageGuard())
+	e.GET("/consent", h.Consent, h.StorageGuard())
+	e.POST("/consent", h.Consent, h.StorageGuard())
+	e.GET("/consent/receipts", h.ExportConsentReceipts, h.StorageGuard())
+	e.GET("/sessions", h.ListSessions, h.StorageGuard())
+	e.GET("/logout", h.Logout, h.StorageGuard())
+	e.POST("/logout", h.Logout, h.StorageGuard())
+
+	// Device verification page, also served at the configured short path
+	e.GET("/device", h.DeviceVerification, h.StorageGuard())
+	e.GET("/device/:user_code", h.DeviceVerification, h.StorageGuard())
+	e.POST("/device", h.DeviceVerification, h.StorageGuard())
+	if path, ok := h.DeviceVerificationPath(); ok {
+		e.GET(path, h.DeviceVerification, h.StorageGuard())
+		e.GET(path+"/:user_code", h.DeviceVerification, h.StorageGuard())
+	}
 
 	// Admin API
-	adminAPIHandler := handlers.NewAdminHandler(h.GetStorage(), cfg)
-	api := e.Group("/api/admin")
+	adminAPIHandler := handlers.NewAdminHandler(h.GetStorage(), cfg, h.GetSessionManager())
+	adminAPIHandler.SetStorageBreaker(h.StorageBreaker())
+	adminAPIHandler.SetMailer(h.Mailer())
+	adminAPIHandler.SetAvatarStore(h.AvatarStore())
+	adminAPIHandler.SetConfigStore(configStore)
+	api := e.Group("/api/admin", adminAPIHandler.PolicyGuard())
 
 	// Setup endpoints (no auth required)
 	api.GET("/setup/status", adminAPIHandler.GetSetupStatus)
 
+	// Admin sign-in for API clients, returning a bearer token valid for 24 hours
+	api.POST("/login", adminAPIHandler.Login)
+
+	// Emergency access: a code from `openid-server break-glass` for a short-lived admin token
+	api.POST("/break-glass", adminAPIHandler.RedeemBreakGlass)
+
 	// Stats and management (should be authenticated in production)
 	api.GET("/stats", adminAPIHandler.GetStats)
+	api.GET("/version", adminAPIHandler.GetVersion)
 	api.GET("/users", adminAPIHandler.ListUsers)
 	api.GET("/users/:id", adminAPIHandler.GetUser)
 	api.POST("/users", adminAPIHandler.CreateUser)
 	api.PUT("/users/:id", adminAPIHandler.UpdateUser)
 	api.DELETE("/users/:id", adminAPIHandler.DeleteUser)
+	api.POST("/users/:id/enable", adminAPIHandler.EnableUser)
+	api.POST("/users/:id/disable", adminAPIHandler.DisableUser)
+	api.GET("/users/:id/export", adminAPIHandler.ExportUserData)
+	api.GET("/users/:id/activity", adminAPIHandler.GetUserActivity)
+	api.POST("/users/:id/erase", adminAPIHandler.EraseUser)
+	api.POST("/users/:id/avatar", adminAPIHandler.UploadUserAvatar)
+	api.DELETE("/users/:id/avatar", adminAPIHandler.DeleteUserAvatar)
+	api.GET("/service-accounts", adminAPIHandler.ListServiceAccounts)
+	api.POST("/service-accounts", adminAPIHandler.CreateServiceAccount)
+	api.GET("/service-accounts/:id/keys", adminAPIHandler.ListAPIKeys)
+	api.POST("/service-accounts/:id/keys", adminAPIHandler.CreateAPIKey)
+	api.DELETE("/service-accounts/:id/keys/:key_id", adminAPIHandler.DeleteAPIKey)
+	api.GET("/consent-receipts", adminAPIHandler.ExportConsentReceipts)
 	api.GET("/clients", adminAPIHandler.ListClients)
+	api.GET("/clients/pending", adminAPIHandler.ListPendingClients)
+	api.GET("/clients/dormant", adminAPIHandler.ListDormantClients)
+	api.GET("/clients/redirect-findings", adminAPIHandler.ListRedirectURIFindings)
 	api.GET("/clients/:id", adminAPIHandler.GetClient)
 	api.POST("/clients", adminAPIHandler.CreateClient)
 	api.POST("/clients/:id/regenerate-secret", adminAPIHandler.RegenerateClientSecret)
+	api.POST("/clients/:id/secret/reveal-token", adminAPIHandler.RequestSecretReveal)
+	api.POST("/clients/:id/secret/reveal", adminAPIHandler.RevealClientSecret)
+	api.POST("/clients/:id/approve", adminAPIHandler.ApproveClient)
+	api.POST("/clients/:id/reject", adminAPIHandler.RejectClient)
+	api.POST("/clients/:id/enable", adminAPIHandler.EnableClient)
+	api.POST("/clients/:id/disable", adminAPIHandler.DisableClient)
+	api.GET("/clients/:id/instances", adminAPIHandler.ListClientInstances)
+	api.DELETE("/clients/:id/instances/:instance_id", adminAPIHandler.DeleteClientInstance)
 	api.PUT("/clients/:id", adminAPIHandler.UpdateClient)
 	api.DELETE("/clients/:id", adminAPIHandler.DeleteClient)
+	api.POST("/registration/bootstrap", adminAPIHandler.CreateRegistrationBootstrap)
 	api.GET("/settings", adminAPIHandler.GetSettings)
 	api.PUT("/settings", adminAPIHandler.UpdateSettings)
+	api.GET("/maintenance", adminAPIHandler.GetMaintenance)
+	api.PUT("/maintenance", adminAPIHandler.UpdateMaintenance)
+	api.GET("/retention", adminAPIHandler.GetRetention)
+	api.PUT("/retention", adminAPIHandler.UpdateRetention)
+	api.GET("/reports", adminAPIHandler.ListReports)
+	api.GET("/reports/analytics", adminAPIHandler.ExportAnalytics)
+	api.POST("/reports/:name/run", adminAPIHandler.RunReport)
+	api.GET("/features", adminAPIHandler.ListFeatures)
+	api.PUT("/features/:name", adminAPIHandler.UpdateFeature)
 	api.GET("/keys", adminAPIHandler.GetKeys)
 	api.POST("/settings/rotate-keys", adminAPIHandler.RotateKeys)
+	api.POST("/settings/rotate-encryption-keys", adminAPIHandler.RotateEncryptionKeys)
+	api.POST("/settings/rotate-cookie-keys", adminAPIHandler.RotateCookieKeys)
+	api.GET("/keys/history", adminAPIHandler.GetKeyHistory)
+	api.DELETE("/keys/:id", adminAPIHandler.DeleteKey)
 	api.GET("/keys/:id/csr", adminAPIHandler.GenerateKeyCSR)
 	api.POST("/keys/:id/import-cert", adminAPIHandler.ImportKeyCert)
 
 	// Audit log endpoint
 	api.GET("/audit", adminAPIHandler.GetAuditLogs)
+	api.GET("/audit/verify", adminAPIHandler.VerifyAuditLog)
+	api.GET("/errors/:reference", adminAPIHandler.GetErrorReport)
+
+	// Runtime diagnostics; pprof keeps its usual path so `go tool pprof` URLs work as documented
+	api.GET("/runtime", adminAPIHandler.GetRuntimeStats)
+	e.GET("/debug/pprof/*", adminAPIHandler.Pprof)
+	e.POST("/debug/pprof/symbol", adminAPIHandler.Pprof)
 
 	// Token management endpoints
 	api.GET("/tokens", adminAPIHandler.ListTokens)
 	api.DELETE("/tokens/:id", adminAPIHandler.RevokeToken)
+	api.POST("/tokens/test", adminAPIHandler.MintTestToken)
+
+	// Session management endpoints
+	api.DELETE("/sessions/:id", adminAPIHandler.TerminateSession)
 
 	// Profile endpoints
 	api.GET("/profile", adminAPIHandler.GetProfile)
@@ -343,6 +571,9 @@ func registerRoutes(e *echo.Echo, h *handlers.Handlers, cfg *configstore.ConfigD
 	if err != nil {
 		panic(err)
 	}
+	if basePath := cfg.BasePath(); basePath != "" {
+		adminSubFS = basePathFS{FS: adminSubFS, basePath: basePath}
+	}
 	e.Use(middleware.StaticWithConfig(middleware.StaticConfig{
 		Root:       "/",
 		Index:      "index.html",
@@ -354,43 +585,27 @@ func registerRoutes(e *echo.Echo, h *handlers.Handlers, cfg *configstore.ConfigD
 			path := c.Request().URL.Path
 			return path == "/authorize" ||
 				path == "/token" ||
+				path == "/revoke" ||
+				path == "/introspect" ||
 				path == "/userinfo" ||
 				path == "/login" ||
+				path == "/login/magic" ||
+				path == "/readyz" ||
 				path == "/consent" ||
+				path == "/logout" ||
+				path == cfg.SecretScanning.Endpoint ||
+				h.IsRegistrationRequest(c) ||
 				len(path) >= 4 && path[:4] == "/api" ||
 				len(path) >= 12 && path[:12] == "/.well-known"
 		},
 	}))
 }
 
+// getVersion returns the server version. The VERSION environment variable
+// overrides the version linked into the handlers package.
 func getVersion() string {
-	version := os.Getenv("VERSION")
-	if version == "" {
-		version = "dev"
+	if version := os.Getenv("VERSION"); version != "" {
+		return version
 	}
-	return version
-}
-
-// requestLogger returns an Echo middleware that logs each request in a
-// structured JSON format using the non-deprecated RequestLoggerWithConfig API.
-func requestLogger() echo.MiddlewareFunc {
-	return middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
-		LogURI:       true,
-		LogMethod:    true,
-		LogStatus:    true,
-		LogError:     true,
-		LogLatency:   true,
-		LogRemoteIP:  true,
-		LogUserAgent: true,
-		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
-			errStr := ""
-			if v.Error != nil {
-				errStr = v.Error.Error()
-			}
-			log.Printf(`{"time":%q,"remote_ip":%q,"method":%q,"uri":%q,"status":%d,"latency_human":%q,"error":%q}`,
-				v.StartTime.Format("2006-01-02T15:04:05.000000Z07:00"),
-				v.RemoteIP, v.Method, v.URI, v.Status, v.Latency, errStr)
-			return nil
-		},
-	})
+	return handlers.Version
 }
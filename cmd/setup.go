@@ -6,15 +6,11 @@ import (
 	"fmt"
 	"os"
 	"strings"
-	"time"
 
-	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 
 	"github.com/prasenjit-net/openid-golang/pkg/configstore"
-	"github.com/prasenjit-net/openid-golang/pkg/crypto"
-	"github.com/prasenjit-net/openid-golang/pkg/models"
-	"github.com/prasenjit-net/openid-golang/pkg/storage"
+	"github.com/prasenjit-net/openid-golang/pkg/setup"
 )
 
 var (
@@ -22,6 +18,8 @@ var (
 	adminUsername  string
 	adminPassword  string
 	nonInteractive bool
+	withDemoData   bool
+	demoSeed       setup.SeedOptions
 )
 
 var setupCmd = &cobra.Command{
@@ -41,6 +39,13 @@ Examples:
   # With admin user
   openid-server setup --issuer http://localhost:8080 --admin-user admin --admin-pass secret123
 
+  # With a demo client and user for trying out sign-in
+  openid-server setup --issuer http://localhost:8080 --demo-data --non-interactive
+
+  # With synthetic data for load testing
+  openid-server setup --issuer http://localhost:8080 --demo-data --demo-users 5000 --demo-clients 50 \
+    --demo-consents 20000 --demo-tokens 50000 --non-interactive
+
   # Using environment variables
   ISSUER_URL=http://localhost:8080 ADMIN_USER=admin ADMIN_PASS=secret123 openid-server setup --non-interactive
 `,
@@ -52,6 +57,12 @@ func init() {
 	setupCmd.Flags().StringVar(&issuerURL, "issuer", "", "Issuer URL (e.g., http://localhost:8080)")
 	setupCmd.Flags().StringVar(&adminUsername, "admin-user", "", "Admin username (optional)")
 	setupCmd.Flags().StringVar(&adminPassword, "admin-pass", "", "Admin password (optional)")
+	setupCmd.Flags().BoolVar(&withDemoData, "demo-data", false, "Create a demo client and user")
+	setupCmd.Flags().IntVar(&demoSeed.Users, "demo-users", 0, "Synthetic users to add with --demo-data")
+	setupCmd.Flags().IntVar(&demoSeed.Clients, "demo-clients", 0, "Synthetic clients to add with --demo-data")
+	setupCmd.Flags().IntVar(&demoSeed.Consents, "demo-consents", 0, "Synthetic consents to add with --demo-data")
+	setupCmd.Flags().IntVar(&demoSeed.Tokens, "demo-tokens", 0, "Synthetic access tokens to add with --demo-data")
+	setupCmd.Flags().Int64Var(&demoSeed.Seed, "demo-seed", setup.DefaultSeed, "Seed for the synthetic data; the same seed gives the same records")
 	setupCmd.Flags().BoolVar(&nonInteractive, "non-interactive", false, "Non-interactive mode (use flags or env vars)")
 }
 
@@ -105,18 +116,23 @@ func runSetup(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
-	// Initialize config store
-	fmt.Println("\n� Initializing configuration...")
-	if err := configstore.InitializeMinimalConfig(ctx, configStoreInstance, issuerURL); err != nil {
-		fmt.Printf("❌ Failed to initialize configuration: %v\n", err)
+	// Run the same steps as the setup wizard API
+	wizard := setup.NewWizard(configStoreInstance)
+	if err := wizard.SetIssuer(issuerURL); err != nil {
+		fmt.Printf("❌ Invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("\n🔑 Generating JWT keys...")
+	if _, err := wizard.GenerateKeys(false); err != nil {
+		fmt.Printf("❌ Failed to generate keys: %v\n", err)
 		os.Exit(1)
 	}
-	fmt.Println("✓ Configuration initialized with auto-generated JWT keys")
 
 	// Create admin user if provided
 	if adminUsername != "" && adminPassword != "" {
 		fmt.Println("\n👤 Creating admin user...")
-		if err := createAdminUserCLI(ctx, configStoreInstance); err != nil {
+		if _, err := wizard.CreateAdmin(adminUsername, adminPassword); err != nil {
 			fmt.Printf("⚠️  Failed to create admin user: %v\n", err)
 			fmt.Println("You can create users later via the web UI or API")
 		} else {
@@ -124,6 +140,27 @@ func runSetup(cmd *cobra.Command, args []string) {
 		}
 	}
 
+	var demo *setup.DemoData
+	if withDemoData {
+		fmt.Println("\n🧪 Creating demo data...")
+		if demo, err = wizard.CreateDemoData(demoSeed); err != nil {
+			fmt.Printf("⚠️  Failed to create demo data: %v\n", err)
+		} else {
+			fmt.Println("✓ Demo client and user created")
+			if seeded := demo.Seeded; seeded != nil {
+				fmt.Printf("✓ Added %d users, %d clients, %d consents and %d tokens (seed %d)\n",
+					seeded.Users, seeded.Clients, seeded.Consents, seeded.Tokens, demoSeed.Seed)
+			}
+		}
+	}
+
+	fmt.Println("\n💾 Saving configuration...")
+	if err := wizard.Complete(ctx); err != nil {
+		fmt.Printf("❌ Failed to initialize configuration: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("✓ Configuration initialized with auto-generated JWT keys")
+
 	fmt.Println("\n✅ Setup completed successfully!")
 	fmt.Println("\nConfiguration stored in:", getStorageLocation(loaderCfg))
 	fmt.Println("\nYou can now start the server with:")
@@ -133,6 +170,10 @@ func runSetup(cmd *cobra.Command, args []string) {
 	if adminUsername != "" {
 		fmt.Printf("\nLogin with:\n  Username: %s\n  Password: %s\n", adminUsername, adminPassword)
 	}
+	if demo != nil {
+		fmt.Printf("\nDemo client:\n  Client ID: %s\n  Client Secret: %s\n  Redirect URI: %s\n", demo.ClientID, demo.ClientSecret, demo.RedirectURI)
+		fmt.Printf("Demo user:\n  Username: %s\n  Password: %s\n", demo.Username, demo.Password)
+	}
 }
 
 // gatherConfiguration collects configuration from flags, env vars, or interactive prompts
@@ -190,50 +231,16 @@ func validateConfiguration() error {
 		return fmt.Errorf("admin username is required when password is provided")
 	}
 
-	if adminPassword != "" && len(adminPassword) < 6 {
-		return fmt.Errorf("admin password must be at least 6 characters long")
-	}
-
-	return nil
-}
-
-// createAdminUserCLI creates an admin user using the config store
-func createAdminUserCLI(ctx context.Context, configStoreInstance configstore.ConfigStore) error {
-	// Load the config from store
-	configData, err := configStoreInstance.GetConfig(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to load configuration: %w", err)
-	}
-
-	// Initialize storage directly with ConfigData
-	store, err := storage.NewStorage(configData)
-	if err != nil {
-		return fmt.Errorf("failed to initialize storage: %w", err)
-	}
-	defer func() {
-		_ = store.Close() // Best effort close
-	}()
-
-	// Hash password
-	hashedPassword, err := crypto.HashPassword(adminPassword)
-	if err != nil {
-		return fmt.Errorf("failed to hash password: %w", err)
+	if adminPassword != "" && len(adminPassword) < setup.MinPasswordLength {
+		return fmt.Errorf("admin password must be at least %d characters long", setup.MinPasswordLength)
 	}
 
-	// Create admin user
-	adminUser := &models.User{
-		ID:           uuid.New().String(),
-		Username:     adminUsername,
-		PasswordHash: hashedPassword,
-		Email:        adminUsername + "@local",
-		Role:         "admin",
-		Name:         "Administrator",
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
+	if !demoSeed.Empty() && !withDemoData {
+		return fmt.Errorf("synthetic data counts require --demo-data")
 	}
 
-	if createErr := store.CreateUser(adminUser); createErr != nil {
-		return fmt.Errorf("failed to create user: %w", createErr)
+	if err := demoSeed.Validate(); err != nil {
+		return fmt.Errorf("invalid demo data: %w", err)
 	}
 
 	return nil
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/prasenjit-net/openid-golang/pkg/setup"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+var (
+	usersApply      bool
+	usersJSONOutput bool
+)
+
+var usersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "User maintenance",
+}
+
+var usersNormalizeEmailsCmd = &cobra.Command{
+	Use:   "normalize-emails",
+	Short: "Rewrite user email addresses in canonical form and resolve duplicates",
+	Long: `Lowercases every user's email address, and strips plus tags when
+email_normalization.strip_plus_tag is set. When several users share an address
+in canonical form, one keeps it and the others are disabled for review.
+
+Without --apply, only reports what would change.
+
+Examples:
+  openid-server users normalize-emails
+  openid-server users normalize-emails --apply`,
+	Run: runUsersNormalizeEmails,
+}
+
+func init() {
+	rootCmd.AddCommand(usersCmd)
+	usersCmd.AddCommand(usersNormalizeEmailsCmd)
+	usersNormalizeEmailsCmd.Flags().BoolVar(&usersApply, "apply", false, "write the changes instead of only reporting them")
+	usersNormalizeEmailsCmd.Flags().BoolVar(&usersJSONOutput, "json", false, "print the result as JSON")
+}
+
+func runUsersNormalizeEmails(cmd *cobra.Command, args []string) {
+	configData, err := loadServerConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	store, err := storage.NewStorage(configData)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to open storage: %v\n", err)
+		os.Exit(1)
+	}
+	defer func() { _ = store.Close() }()
+
+	result, err := setup.NormalizeUserEmails(store, configData.EmailNormalizationState().StripPlusTag, usersApply)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to normalize email addresses: %v\n", err)
+		os.Exit(1)
+	}
+
+	if usersJSONOutput {
+		out, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	verb := "Would rewrite"
+	if usersApply {
+		verb = "Rewrote"
+	}
+	fmt.Printf("%s %d of %d email addresses\n", verb, result.Normalized, result.Users)
+	for _, d := range result.Duplicates {
+		fmt.Printf("⚠️  %s is shared: user %s keeps it, disabling %v\n", d.Email, d.Kept, d.Disabled)
+	}
+	if !usersApply && (result.Normalized > 0 || len(result.Duplicates) > 0) {
+		fmt.Println("Run again with --apply to make these changes")
+	}
+}
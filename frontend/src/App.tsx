@@ -25,36 +25,13 @@ import Setup from './pages/Setup';
 import SignIn from './pages/SignIn';
 import OAuthCallback from './pages/OAuthCallback';
 import AuditLog from './pages/AuditLog';
+import { BASE_PATH } from './lib/basePath';
+import { startSignIn } from './lib/oauth';
 
 // Component to initiate OAuth flow for unauthenticated users
 function OAuthRedirect() {
   useEffect(() => {
-    const generateRandomString = (length: number): string => {
-      const array = new Uint8Array(length);
-      window.crypto.getRandomValues(array);
-      return Array.from(array, byte => byte.toString(16).padStart(2, '0')).join('');
-    };
-
-    // Generate state and nonce for security
-    const state = generateRandomString(16);
-    const nonce = generateRandomString(16);
-
-    // Save state and nonce in session storage for verification
-    sessionStorage.setItem('oauth_state', state);
-    sessionStorage.setItem('oauth_nonce', nonce);
-
-    // Build authorization URL
-    const authParams = new URLSearchParams({
-      client_id: 'admin-ui',
-      redirect_uri: `${window.location.origin}/admin/callback`,
-      response_type: 'id_token',
-      scope: 'openid profile email',
-      state: state,
-      nonce: nonce,
-    });
-
-    // Redirect to authorization endpoint
-    window.location.href = `/authorize?${authParams.toString()}`;
+    startSignIn();
   }, []);
 
   return (
@@ -123,7 +100,7 @@ function AppContent() {
 
   return (
     <ConfigProvider theme={configTheme}>
-      <BrowserRouter>
+      <BrowserRouter basename={BASE_PATH}>
         <Routes>
             {/* OAuth callback route - always accessible */}
             <Route path="/admin/callback" element={<OAuthCallback />} />
@@ -21,6 +21,7 @@ import {
 } from '@ant-design/icons';
 import { useTheme } from '../../context/ThemeContext';
 import { Logo } from '../Logo';
+import { useVersion } from '../../hooks/useApi';
 
 const NAV_ITEMS = [
   { key: '/dashboard', label: 'Dashboard', icon: <DashboardOutlined /> },
@@ -54,6 +55,7 @@ function SidebarContent({ collapsed, onCollapse: _onCollapse }: SidebarProps) {
   const navigate = useNavigate();
   const location = useLocation();
   const userInfo = getUserInfo();
+  const { data: versionInfo } = useVersion();
 
   const isActive = (key: string) => {
     if (key === '/dashboard') return location.pathname === '/dashboard' || location.pathname === '/';
@@ -165,7 +167,9 @@ function SidebarContent({ collapsed, onCollapse: _onCollapse }: SidebarProps) {
             <div style={{ fontSize: 12, fontWeight: 600, color: 'rgba(203,213,225,0.9)', whiteSpace: 'nowrap', overflow: 'hidden', textOverflow: 'ellipsis' }}>
               {userInfo.name || userInfo.username}
             </div>
-            <div style={{ fontSize: 10, color: 'var(--sidebar-text-muted)', letterSpacing: '0.06em', textTransform: 'uppercase' }}>Admin</div>
+            <div style={{ fontSize: 10, color: 'var(--sidebar-text-muted)', letterSpacing: '0.06em', textTransform: 'uppercase' }}>
+              Admin{versionInfo && <span title={versionInfo.commit}> · v{versionInfo.version}</span>}
+            </div>
           </div>
         )}
       </div>
@@ -1,12 +1,41 @@
 import { createContext, useContext, useState, useEffect } from 'react';
 import type { ReactNode } from 'react';
 import { queryClient } from '../lib/queryClient';
+import { BASE_PATH } from '../lib/basePath';
+import { decodeJWT, refreshTokens, revokeRefreshToken } from '../lib/oauth';
+
+// Tokens are refreshed this long before they expire
+const REFRESH_MARGIN_MS = 60 * 1000;
+
+// tokenExpiry returns when a JWT expires, in milliseconds, or 0 when it can't be read
+const tokenExpiry = (token: string): number => {
+  try {
+    return Number(decodeJWT(token).exp) * 1000 || 0;
+  } catch {
+    return 0;
+  }
+};
+
+// silentRefresh trades the refresh token for a new ID token, or returns null
+const silentRefresh = async (): Promise<string | null> => {
+  try {
+    const tokens = await refreshTokens();
+    if (!tokens.id_token) {
+      return null;
+    }
+    localStorage.setItem('admin_token', tokens.id_token);
+    return tokens.id_token;
+  } catch {
+    localStorage.removeItem('admin_refresh_token');
+    return null;
+  }
+};
 
 interface AuthContextType {
   isAuthenticated: boolean;
   isSetupComplete: boolean;
   loading: boolean;
-  login: (token: string) => void;
+  login: (token: string, refreshToken?: string) => void;
   logout: () => void;
   checkAuth: () => Promise<void>;
 }
@@ -17,35 +46,29 @@ export const AuthProvider = ({ children }: { children: ReactNode }) => {
   const [isAuthenticated, setIsAuthenticated] = useState(false);
   const [isSetupComplete, setIsSetupComplete] = useState(true);
   const [loading, setLoading] = useState(true);
+  const [tokenVersion, setTokenVersion] = useState(0);
 
   const checkAuth = async () => {
     try {
       // First check if we have a token in localStorage
-      const token = localStorage.getItem('admin_token');
-      if (token) {
-        // Validate token is not expired
-        try {
-          const payload = JSON.parse(atob(token.split('.')[1]));
-          const exp = payload.exp * 1000; // Convert to milliseconds
-          if (exp > Date.now()) {
-            setIsAuthenticated(true);
-            setIsSetupComplete(true);
-            setLoading(false);
-            return;
-          } else {
-            // Token expired, remove it
-            localStorage.removeItem('admin_token');
-            localStorage.removeItem('user_info');
-          }
-        } catch {
-          // Invalid token, remove it
+      let token = localStorage.getItem('admin_token');
+      if (token && tokenExpiry(token) <= Date.now()) {
+        // Token expired, try to renew it silently
+        token = await silentRefresh();
+        if (!token) {
           localStorage.removeItem('admin_token');
           localStorage.removeItem('user_info');
         }
       }
+      if (token) {
+        setIsAuthenticated(true);
+        setIsSetupComplete(true);
+        setLoading(false);
+        return;
+      }
 
       // No valid token, check setup status
-      const response = await fetch('/api/admin/setup/status');
+      const response = await fetch(`${BASE_PATH}/api/admin/setup/status`);
       const data = await response.json();
       setIsSetupComplete(data.setupComplete);
       setIsAuthenticated(false);
@@ -57,12 +80,16 @@ export const AuthProvider = ({ children }: { children: ReactNode }) => {
     }
   };
 
-  const login = (token: string) => {
+  const login = (token: string, refreshToken?: string) => {
     localStorage.setItem('admin_token', token);
+    if (refreshToken) {
+      localStorage.setItem('admin_refresh_token', refreshToken);
+    }
     setIsAuthenticated(true);
   };
 
   const logout = () => {
+    revokeRefreshToken();
     localStorage.removeItem('admin_token');
     localStorage.removeItem('user_info');
     sessionStorage.clear();
@@ -74,6 +101,27 @@ export const AuthProvider = ({ children }: { children: ReactNode }) => {
     checkAuth();
   }, []);
 
+  // Renew the short-lived token shortly before it expires while signed in
+  useEffect(() => {
+    if (!isAuthenticated) {
+      return;
+    }
+    const token = localStorage.getItem('admin_token');
+    const delay = token ? tokenExpiry(token) - Date.now() - REFRESH_MARGIN_MS : 0;
+    const timer = window.setTimeout(async () => {
+      if (!(await silentRefresh())) {
+        localStorage.removeItem('admin_token');
+        localStorage.removeItem('user_info');
+        setIsAuthenticated(false);
+        queryClient.clear();
+        return;
+      }
+      // Re-arm the timer for the new token
+      setTokenVersion(v => v + 1);
+    }, Math.max(delay, 0));
+    return () => window.clearTimeout(timer);
+  }, [isAuthenticated, tokenVersion]);
+
   return (
     <AuthContext.Provider value={{ isAuthenticated, isSetupComplete, loading, login, logout, checkAuth }}>
       {children}
@@ -1,7 +1,8 @@
 import { useQuery, useMutation, useQueryClient } from '@tanstack/react-query'
+import { BASE_PATH } from '../lib/basePath'
 
 // API base URL
-const API_BASE = '/api/admin'
+const API_BASE = `${BASE_PATH}/api/admin`
 
 // Types
 interface CreateClientRequest {
@@ -23,6 +24,21 @@ export interface TokenEntry {
   is_active: boolean
 }
 
+export interface UserActivityItem {
+  time: string
+  type: 'audit' | 'token' | 'consent' | 'session'
+  action: string
+  client_id?: string
+  status?: string
+  id: string
+}
+
+export interface UserActivity {
+  user_id: string
+  username: string
+  timeline: UserActivityItem[]
+}
+
 export interface TokenFilter {
   active?: boolean
   client_id?: string
@@ -44,8 +60,10 @@ interface UpdateSettingsRequest {
 // Query keys
 export const queryKeys = {
   stats: ['stats'] as const,
+  version: ['version'] as const,
   users: ['users'] as const,
   user: (id: string) => ['user', id] as const,
+  userActivity: (id: string) => ['user', id, 'activity'] as const,
   clients: ['clients'] as const,
   client: (id: string) => ['client', id] as const,
   settings: ['settings'] as const,
@@ -60,6 +78,18 @@ function getAuthHeaders(): Record<string, string> {
   return token ? { Authorization: `Bearer ${token}` } : {};
 }
 
+// Updates name the version they are based on; the server refuses them with 409
+// once someone else has changed the user, client or settings
+function ifMatchHeader(version: number | string | undefined): Record<string, string> {
+  return { 'If-Match': version === undefined ? '*' : `"${version}"` };
+}
+
+export class ConflictError extends Error {
+  constructor() {
+    super('Someone else changed this since it was loaded. Reload to see their changes and try again.')
+  }
+}
+
 // Stats
 export function useStats() {
   return useQuery({
@@ -76,6 +106,33 @@ export function useStats() {
   })
 }
 
+// Server version and capabilities
+export interface VersionInfo {
+  version: string
+  commit: string
+  build_time?: string
+  go_version: string
+  storage_backend: string
+  key_algorithm: string
+  features: Record<string, boolean>
+}
+
+export function useVersion() {
+  return useQuery<VersionInfo>({
+    queryKey: queryKeys.version,
+    queryFn: async () => {
+      const res = await fetch(`${API_BASE}/version`, {
+        headers: {
+          ...getAuthHeaders(),
+        },
+      })
+      if (!res.ok) throw new Error('Failed to fetch version')
+      return res.json()
+    },
+    staleTime: Infinity,
+  })
+}
+
 // Users
 export function useUsers() {
   return useQuery({
@@ -92,6 +149,21 @@ export function useUsers() {
   })
 }
 
+export interface UsernameViolation {
+  rule: 'length' | 'pattern' | 'reserved' | 'blocked_word'
+  message: string
+}
+
+// Error from creating a user; violations lists the username policy rules the username broke
+export class UsernamePolicyError extends Error {
+  violations: UsernameViolation[]
+
+  constructor(message: string, violations: UsernameViolation[]) {
+    super(message)
+    this.violations = violations
+  }
+}
+
 export function useCreateUser() {
   const queryClient = useQueryClient()
   return useMutation({
@@ -101,7 +173,10 @@ export function useCreateUser() {
         headers: { 'Content-Type': 'application/json', ...getAuthHeaders() },
         body: JSON.stringify(user),
       })
-      if (!res.ok) throw new Error('Failed to create user')
+      if (!res.ok) {
+        const err = await res.json().catch(() => ({}))
+        throw new UsernamePolicyError(err.error ?? 'Failed to create user', err.violations ?? [])
+      }
       return res.json()
     },
     onSuccess: () => {
@@ -193,12 +268,13 @@ export function useSettings() {
 export function useUpdateSettings() {
   const queryClient = useQueryClient()
   return useMutation({
-    mutationFn: async (settings: UpdateSettingsRequest) => {
+    mutationFn: async ({ version, ...settings }: UpdateSettingsRequest & { version?: string }) => {
       const res = await fetch(`${API_BASE}/settings`, {
         method: 'PUT',
-        headers: { 'Content-Type': 'application/json', ...getAuthHeaders() },
+        headers: { 'Content-Type': 'application/json', ...getAuthHeaders(), ...ifMatchHeader(version) },
         body: JSON.stringify(settings),
       })
+      if (res.status === 409) throw new ConflictError()
       if (!res.ok) throw new Error('Failed to update settings')
       return res.json()
     },
@@ -304,15 +380,35 @@ export function useUser(id: string) {
   })
 }
 
+export function useUserActivity(id: string) {
+  return useQuery<UserActivity>({
+    queryKey: queryKeys.userActivity(id),
+    queryFn: async () => {
+      const res = await fetch(`${API_BASE}/users/${id}/activity`, {
+        headers: { ...getAuthHeaders() },
+      })
+      if (!res.ok) throw new Error('Failed to fetch user activity')
+      return res.json()
+    },
+    enabled: !!id,
+  })
+}
+
 export function useUpdateUser() {
   const queryClient = useQueryClient()
   return useMutation({
-    mutationFn: async ({ id, ...user }: { id: string; username?: string; email?: string; name?: string; password?: string; role?: string; [key: string]: unknown }) => {
+    mutationFn: async ({ id, version, ...user }: { id: string; version?: number; username?: string; email?: string; name?: string; password?: string; role?: string; [key: string]: unknown }) => {
       const res = await fetch(`${API_BASE}/users/${id}`, {
         method: 'PUT',
-        headers: { 'Content-Type': 'application/json', ...getAuthHeaders() },
+        headers: { 'Content-Type': 'application/json', ...getAuthHeaders(), ...ifMatchHeader(version) },
         body: JSON.stringify(user),
       })
+      if (res.status === 409) {
+        const err = await res.json().catch(() => ({}))
+        // Email conflicts are answered with 409 too, but without a version
+        if (err.version !== undefined) throw new ConflictError()
+        throw new Error(err.error ?? 'Failed to update user')
+      }
       if (!res.ok) throw new Error('Failed to update user')
       return res.json()
     },
@@ -324,6 +420,29 @@ export function useUpdateUser() {
   })
 }
 
+// Upload a user's avatar; the server crops and resizes it and points the picture claim at it
+export function useUploadUserAvatar() {
+  const queryClient = useQueryClient()
+  return useMutation({
+    mutationFn: async ({ id, file }: { id: string; file: File }) => {
+      const body = new FormData()
+      body.append('avatar', file)
+      const res = await fetch(`${API_BASE}/users/${id}/avatar`, {
+        method: 'POST',
+        headers: { ...getAuthHeaders() },
+        body,
+      })
+      const data = await res.json().catch(() => ({}))
+      if (!res.ok) throw new Error(data.error || 'Failed to upload avatar')
+      return data as { picture: string }
+    },
+    onSuccess: (_data, { id }) => {
+      queryClient.invalidateQueries({ queryKey: queryKeys.users })
+      queryClient.invalidateQueries({ queryKey: queryKeys.user(id) })
+    },
+  })
+}
+
 // Individual Client
 export function useClient(id: string) {
   return useQuery({
@@ -342,12 +461,13 @@ export function useClient(id: string) {
 export function useUpdateClient() {
   const queryClient = useQueryClient()
   return useMutation({
-    mutationFn: async ({ id, ...client }: { id: string; name?: string; redirect_uris?: string[]; grant_types?: string[]; response_types?: string[]; scope?: string; application_type?: string }) => {
+    mutationFn: async ({ id, version, ...client }: { id: string; version?: number; name?: string; redirect_uris?: string[]; grant_types?: string[]; response_types?: string[]; scope?: string; application_type?: string }) => {
       const res = await fetch(`${API_BASE}/clients/${id}`, {
         method: 'PUT',
-        headers: { 'Content-Type': 'application/json', ...getAuthHeaders() },
+        headers: { 'Content-Type': 'application/json', ...getAuthHeaders(), ...ifMatchHeader(version) },
         body: JSON.stringify(client),
       })
+      if (res.status === 409) throw new ConflictError()
       if (!res.ok) throw new Error('Failed to update client')
       return res.json()
     },
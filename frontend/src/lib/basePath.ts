@@ -0,0 +1,9 @@
+declare global {
+  interface Window {
+    __BASE_PATH__?: string;
+  }
+}
+
+// Path prefix the server is mounted under (e.g. "/auth"), injected into index.html
+// by the server. Empty when the server runs at the root of the domain.
+export const BASE_PATH = window.__BASE_PATH__ ?? '';
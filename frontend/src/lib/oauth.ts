@@ -0,0 +1,114 @@
+import { BASE_PATH } from './basePath';
+
+// The admin UI signs in as the public "admin-ui" client with the authorization
+// code flow and PKCE. Access tokens are short-lived; the refresh token keeps the
+// session going and dies with the server-side login session.
+const CLIENT_ID = 'admin-ui';
+const SCOPE = 'openid profile email';
+
+const redirectURI = () => `${window.location.origin}${BASE_PATH}/admin/callback`;
+
+export interface TokenResponse {
+  access_token: string;
+  id_token?: string;
+  refresh_token?: string;
+  expires_in?: number;
+}
+
+const randomString = (length: number): string => {
+  const array = new Uint8Array(length);
+  window.crypto.getRandomValues(array);
+  return Array.from(array, byte => byte.toString(16).padStart(2, '0')).join('');
+};
+
+const base64URL = (bytes: ArrayBuffer): string =>
+  btoa(String.fromCharCode(...new Uint8Array(bytes)))
+    .replace(/\+/g, '-')
+    .replace(/\//g, '_')
+    .replace(/=+$/, '');
+
+// Decodes the claims of a JWT without verifying it; the server checks its tokens
+export const decodeJWT = (token: string): Record<string, unknown> => {
+  const payload = token.split('.')[1].replace(/-/g, '+').replace(/_/g, '/');
+  return JSON.parse(atob(payload));
+};
+
+// startSignIn redirects to /authorize with a fresh state, nonce and S256 code challenge
+export const startSignIn = async () => {
+  const state = randomString(16);
+  const nonce = randomString(16);
+  const verifier = randomString(32);
+  const challenge = base64URL(await window.crypto.subtle.digest('SHA-256', new TextEncoder().encode(verifier)));
+
+  sessionStorage.setItem('oauth_state', state);
+  sessionStorage.setItem('oauth_nonce', nonce);
+  sessionStorage.setItem('oauth_code_verifier', verifier);
+
+  const authParams = new URLSearchParams({
+    client_id: CLIENT_ID,
+    redirect_uri: redirectURI(),
+    response_type: 'code',
+    scope: SCOPE,
+    state,
+    nonce,
+    code_challenge: challenge,
+    code_challenge_method: 'S256',
+  });
+  window.location.href = `${BASE_PATH}/authorize?${authParams.toString()}`;
+};
+
+const requestTokens = async (params: Record<string, string>): Promise<TokenResponse> => {
+  const response = await fetch(`${BASE_PATH}/token`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
+    body: new URLSearchParams({ client_id: CLIENT_ID, ...params }),
+  });
+  const data = await response.json();
+  if (!response.ok) {
+    throw new Error(data.error_description || data.error || 'Token request failed');
+  }
+  return data as TokenResponse;
+};
+
+// exchangeCode redeems the authorization code with the verifier saved by startSignIn
+export const exchangeCode = async (code: string): Promise<TokenResponse> => {
+  const verifier = sessionStorage.getItem('oauth_code_verifier') ?? '';
+  sessionStorage.removeItem('oauth_code_verifier');
+  return requestTokens({
+    grant_type: 'authorization_code',
+    code,
+    redirect_uri: redirectURI(),
+    code_verifier: verifier,
+  });
+};
+
+// refreshTokens uses the stored refresh token; refresh tokens rotate on every use
+export const refreshTokens = async (): Promise<TokenResponse> => {
+  const refreshToken = localStorage.getItem('admin_refresh_token');
+  if (!refreshToken) {
+    throw new Error('No refresh token');
+  }
+  const tokens = await requestTokens({ grant_type: 'refresh_token', refresh_token: refreshToken });
+  if (tokens.refresh_token) {
+    localStorage.setItem('admin_refresh_token', tokens.refresh_token);
+  }
+  return tokens;
+};
+
+// revokeRefreshToken ends the refresh token on sign-out; failures are ignored
+export const revokeRefreshToken = async () => {
+  const refreshToken = localStorage.getItem('admin_refresh_token');
+  localStorage.removeItem('admin_refresh_token');
+  if (!refreshToken) {
+    return;
+  }
+  try {
+    await fetch(`${BASE_PATH}/revoke`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
+      body: new URLSearchParams({ client_id: CLIENT_ID, token: refreshToken, token_type_hint: 'refresh_token' }),
+    });
+  } catch {
+    // The token expires with the login session anyway
+  }
+};
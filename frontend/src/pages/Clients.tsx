@@ -13,7 +13,7 @@ import {
 } from 'antd';
 import { PlusOutlined, EditOutlined, DeleteOutlined, AppstoreOutlined, CopyOutlined } from '@ant-design/icons';
 import type { ColumnsType } from 'antd/es/table';
-import { useClients, useCreateClient, useUpdateClient, useDeleteClient } from '../hooks/useApi';
+import { useClients, useCreateClient, useUpdateClient, useDeleteClient, ConflictError } from '../hooks/useApi';
 
 const { TextArea } = Input;
 
@@ -26,6 +26,7 @@ interface Client {
   grant_types?: string[];
   response_types?: string[];
   scope?: string;
+  version: number;
   created_at: string;
 }
 
@@ -53,7 +54,7 @@ const Clients = () => {
         redirect_uris: values.redirect_uris.split('\n').filter((uri: string) => uri.trim()),
       };
       if (editingClient) {
-        await updateClientMutation.mutateAsync({ id: editingClient.client_id, ...payload });
+        await updateClientMutation.mutateAsync({ id: editingClient.client_id, version: editingClient.version, ...payload });
         message.success('Client updated successfully');
       } else {
         await createClientMutation.mutateAsync(payload);
@@ -63,7 +64,11 @@ const Clients = () => {
       setEditingClient(null);
       form.resetFields();
     } catch (error) {
-      message.error(editingClient ? 'Failed to update client' : 'Failed to create client');
+      if (error instanceof ConflictError) {
+        message.error(error.message);
+      } else {
+        message.error(editingClient ? 'Failed to update client' : 'Failed to create client');
+      }
       console.error('Failed to save client:', error);
     }
   };
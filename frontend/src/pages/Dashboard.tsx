@@ -10,6 +10,10 @@ import {
   PlusOutlined,
   AuditOutlined,
   ReloadOutlined,
+  WarningOutlined,
+  LockOutlined,
+  StopOutlined,
+  ThunderboltOutlined,
 } from '@ant-design/icons';
 import { useNavigate } from 'react-router-dom';
 import { useStats, useSettings } from '../hooks/useApi';
@@ -123,6 +127,42 @@ const STATS_CONFIG = [
   },
 ];
 
+// Counts from stats.security, computed from the audit log over the last 24 hours
+const SECURITY_STATS_CONFIG = [
+  {
+    key: 'failed_logins',
+    icon: <WarningOutlined />,
+    iconBg: 'rgba(239,68,68,0.1)',
+    iconColor: '#EF4444',
+    label: 'Failed Logins',
+    badge: 'Last 24 hours',
+  },
+  {
+    key: 'locked_accounts',
+    icon: <LockOutlined />,
+    iconBg: 'rgba(245,158,11,0.1)',
+    iconColor: '#F59E0B',
+    label: 'Locked Accounts',
+    badge: 'Currently locked',
+  },
+  {
+    key: 'client_auth_failures',
+    icon: <StopOutlined />,
+    iconBg: 'rgba(236,72,153,0.1)',
+    iconColor: '#EC4899',
+    label: 'Rejected Client Auth',
+    badge: 'Last 24 hours',
+  },
+  {
+    key: 'rate_limited',
+    icon: <ThunderboltOutlined />,
+    iconBg: 'rgba(99,102,241,0.1)',
+    iconColor: '#6366F1',
+    label: 'Rate-Limit Trips',
+    badge: 'Last 24 hours',
+  },
+];
+
 const QUICK_ACTIONS = [
   { icon: <PlusOutlined />, label: 'Add User', path: '/users', color: '#0D9488', bg: 'rgba(13,148,136,0.08)' },
   { icon: <AppstoreOutlined />, label: 'Register Client', path: '/clients', color: '#F59E0B', bg: 'rgba(245,158,11,0.08)' },
@@ -195,6 +235,42 @@ const Dashboard = () => {
         ))}
       </div>
 
+      {/* Security */}
+      {stats?.security && (
+        <div style={{ marginBottom: 28 }}>
+          <h2 style={{ margin: '0 0 12px', fontSize: 'var(--text-lg)', fontWeight: 'var(--font-semibold)', color: 'var(--text-primary)' }}>
+            Security
+          </h2>
+          <div
+            style={{
+              display: 'grid',
+              gridTemplateColumns: 'repeat(auto-fill, minmax(240px, 1fr))',
+              gap: 16,
+            }}
+          >
+            {SECURITY_STATS_CONFIG.map((cfg) => (
+              <StatCard
+                key={cfg.key}
+                icon={cfg.icon}
+                iconBg={cfg.iconBg}
+                iconColor={cfg.iconColor}
+                value={stats.security[cfg.key] ?? 0}
+                label={cfg.label}
+                badge={cfg.badge}
+              />
+            ))}
+          </div>
+          {stats.security.top_sources?.length > 0 && (
+            <div style={{ marginTop: 12, fontSize: 'var(--text-sm)', color: 'var(--text-secondary)' }}>
+              Most failures from:{' '}
+              {stats.security.top_sources
+                .map((src: { ip: string; failures: number }) => `${src.ip} (${src.failures})`)
+                .join(', ')}
+            </div>
+          )}
+        </div>
+      )}
+
       {/* Quick Actions */}
       <div style={{ marginBottom: 28 }}>
         <h2 style={{ margin: '0 0 12px', fontSize: 'var(--text-lg)', fontWeight: 'var(--font-semibold)', color: 'var(--text-primary)' }}>
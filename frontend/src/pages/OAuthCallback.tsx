@@ -1,21 +1,24 @@
-import { useEffect, useState, useCallback } from 'react';
+import { useEffect, useState, useCallback, useRef } from 'react';
 import { useNavigate } from 'react-router-dom';
 import { Spin, Alert, Card } from 'antd';
 import { LoadingOutlined, WarningOutlined } from '@ant-design/icons';
 import { useAuth } from '../context/AuthContext';
+import { decodeJWT, exchangeCode } from '../lib/oauth';
 
 const OAuthCallback = () => {
   const navigate = useNavigate();
   const { login } = useAuth();
   const [error, setError] = useState('');
 
-  const handleCallback = useCallback(() => {
+  // The code is single use; StrictMode runs effects twice in development
+  const handled = useRef(false);
+
+  const handleCallback = useCallback(async () => {
     try {
-      // Extract token from URL fragment
-      const hash = window.location.hash.substring(1);
-      const params = new URLSearchParams(hash);
-      
-      const idToken = params.get('id_token');
+      // The authorization response arrives in the query string
+      const params = new URLSearchParams(window.location.search);
+
+      const code = params.get('code');
       const state = params.get('state');
       const error = params.get('error');
       const errorDescription = params.get('error_description');
@@ -26,8 +29,8 @@ const OAuthCallback = () => {
         return;
       }
 
-      if (!idToken) {
-        setError('No ID token received');
+      if (!code) {
+        setError('No authorization code received');
         setTimeout(() => navigate('/login'), 3000);
         return;
       }
@@ -40,13 +43,24 @@ const OAuthCallback = () => {
         return;
       }
 
-      // Decode JWT to extract user info and check role
-      const payload = JSON.parse(atob(idToken.split('.')[1]));
-      
-      // Store the token and user info
+      const tokens = await exchangeCode(code);
+      if (!tokens.id_token) {
+        setError('No ID token received');
+        setTimeout(() => navigate('/login'), 3000);
+        return;
+      }
+
+      // The ID token must answer this sign-in request
+      const payload = decodeJWT(tokens.id_token);
+      if (payload.nonce !== sessionStorage.getItem('oauth_nonce')) {
+        setError('Invalid nonce in ID token');
+        setTimeout(() => navigate('/login'), 3000);
+        return;
+      }
+
+      // Store the tokens and user info
       localStorage.setItem('user_info', JSON.stringify(payload));
-      login(idToken);  // This will update auth context
-      console.log('OAuthCallback: idToken set as admin_token', idToken);
+      login(tokens.id_token, tokens.refresh_token);
       // Clean up
       sessionStorage.removeItem('oauth_state');
       sessionStorage.removeItem('oauth_nonce');
@@ -61,6 +75,10 @@ const OAuthCallback = () => {
   }, [navigate, login]);
 
   useEffect(() => {
+    if (handled.current) {
+      return;
+    }
+    handled.current = true;
     handleCallback();
   }, [handleCallback]);
 
@@ -1,33 +1,12 @@
-import { useEffect, useCallback } from 'react';
+import { useEffect } from 'react';
 import { Spin } from 'antd';
 import { LockOutlined } from '@ant-design/icons';
+import { startSignIn } from '../lib/oauth';
 
 const SignIn = () => {
-  const generateRandomString = (length: number): string => {
-    const array = new Uint8Array(length);
-    window.crypto.getRandomValues(array);
-    return Array.from(array, byte => byte.toString(16).padStart(2, '0')).join('');
-  };
-
-  const initiateOAuthFlow = useCallback(() => {
-    const state = generateRandomString(16);
-    const nonce = generateRandomString(16);
-    sessionStorage.setItem('oauth_state', state);
-    sessionStorage.setItem('oauth_nonce', nonce);
-    const authParams = new URLSearchParams({
-      client_id: 'admin-ui',
-      redirect_uri: `${window.location.origin}/admin/callback`,
-      response_type: 'id_token',
-      scope: 'openid profile email',
-      state,
-      nonce,
-    });
-    window.location.href = `/authorize?${authParams.toString()}`;
-  }, []);
-
   useEffect(() => {
-    initiateOAuthFlow();
-  }, [initiateOAuthFlow]);
+    startSignIn();
+  }, []);
 
   return (
     <div
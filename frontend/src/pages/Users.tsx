@@ -12,7 +12,7 @@ import {
 } from 'antd';
 import { PlusOutlined, EditOutlined, DeleteOutlined, UserOutlined, SearchOutlined } from '@ant-design/icons';
 import type { ColumnsType } from 'antd/es/table';
-import { useUsers, useCreateUser, useUpdateUser, useDeleteUser } from '../hooks/useApi';
+import { useUsers, useCreateUser, useUpdateUser, useDeleteUser, ConflictError } from '../hooks/useApi';
 
 interface User {
   id: string;
@@ -20,6 +20,7 @@ interface User {
   email: string;
   name: string;
   role?: string;
+  version: number;
   created_at: string;
 }
 
@@ -45,7 +46,7 @@ const Users = () => {
   const handleSubmit = async (values: { username: string; email: string; password: string; name: string; role: string }) => {
     try {
       if (editingUser) {
-        await updateUserMutation.mutateAsync({ id: editingUser.id, ...values });
+        await updateUserMutation.mutateAsync({ id: editingUser.id, version: editingUser.version, ...values });
         message.success('User updated successfully');
       } else {
         await createUserMutation.mutateAsync(values);
@@ -55,7 +56,11 @@ const Users = () => {
       setEditingUser(null);
       form.resetFields();
     } catch (error) {
-      message.error(editingUser ? 'Failed to update user' : 'Failed to create user');
+      if (error instanceof ConflictError) {
+        message.error(error.message);
+      } else {
+        message.error(editingUser ? 'Failed to update user' : 'Failed to create user');
+      }
       console.error('Failed to save user:', error);
     }
   };
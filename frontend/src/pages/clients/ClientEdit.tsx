@@ -11,7 +11,7 @@ import {
   Select,
 } from 'antd';
 import { ArrowLeftOutlined, AppstoreOutlined, LinkOutlined, SettingOutlined } from '@ant-design/icons';
-import { useClient, useUpdateClient } from '../../hooks/useApi';
+import { useClient, useUpdateClient, ConflictError } from '../../hooks/useApi';
 
 const { TextArea } = Input;
 
@@ -49,12 +49,12 @@ const ClientEdit = () => {
         .map((uri: string) => uri.trim())
         .filter((uri: string) => uri.length > 0);
 
-      const payload = { id, name: values.name, redirect_uris };
+      const payload = { id, version: client?.version, name: values.name, redirect_uris };
       await updateClientMutation.mutateAsync(payload);
       message.success('Client updated successfully');
       navigate(`/clients/${id}`);
     } catch (error) {
-      message.error('Failed to update client');
+      message.error(error instanceof ConflictError ? error.message : 'Failed to update client');
       console.error('Failed to update client:', error);
     }
   };
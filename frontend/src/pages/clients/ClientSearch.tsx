@@ -23,6 +23,7 @@ import {
   CopyOutlined,
 } from '@ant-design/icons';
 import type { ColumnsType } from 'antd/es/table';
+import { BASE_PATH } from '../../lib/basePath';
 
 const { Title, Text } = Typography;
 
@@ -55,7 +56,7 @@ const ClientSearch = () => {
       if (searchParams.name) params.append('name', searchParams.name);
 
       const queryString = params.toString();
-      const url = queryString ? `/api/admin/clients?${queryString}` : '/api/admin/clients';
+      const url = queryString ? `${BASE_PATH}/api/admin/clients?${queryString}` : `${BASE_PATH}/api/admin/clients`;
 
       const response = await fetch(url);
       if (!response.ok) throw new Error('Failed to search clients');
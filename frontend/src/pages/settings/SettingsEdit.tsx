@@ -2,7 +2,7 @@ import { useState, useEffect } from 'react';
 import { useNavigate } from 'react-router-dom';
 import { Form, Input, InputNumber, Button, Space, message, Select, Spin, Alert } from 'antd';
 import { GlobalOutlined, DatabaseOutlined, SafetyOutlined, SaveOutlined } from '@ant-design/icons';
-import { useSettings, useUpdateSettings } from '../../hooks/useApi';
+import { useSettings, useUpdateSettings, ConflictError } from '../../hooks/useApi';
 
 const FormSection = ({ icon, title, children }: { icon: React.ReactNode; title: string; children: React.ReactNode }) => (
   <div style={{ background: 'var(--surface)', borderRadius: 12, border: '1px solid var(--border)', boxShadow: 'var(--shadow-card)', overflow: 'hidden', marginBottom: 24 }}>
@@ -46,14 +46,14 @@ const SettingsEdit = () => {
     jwt_expiry_minutes: number;
   }) => {
     try {
-      const result = await updateSettingsMutation.mutateAsync(values);
+      const result = await updateSettingsMutation.mutateAsync({ ...values, version: settings?.version });
       message.success('Settings updated successfully');
       if (result.message && result.message.includes('not persisted')) {
         message.warning('Changes are in memory only. Restart may revert changes.', 5);
       }
       navigate('/settings');
     } catch (error) {
-      message.error('Failed to update settings');
+      message.error(error instanceof ConflictError ? error.message : 'Failed to update settings');
       console.error('Failed to update settings:', error);
     }
   };
@@ -7,7 +7,7 @@ import {
   PhoneOutlined,
   HomeOutlined,
 } from '@ant-design/icons';
-import { useCreateUser } from '../../hooks/useApi';
+import { useCreateUser, UsernamePolicyError } from '../../hooks/useApi';
 
 const { TextArea } = Input;
 
@@ -30,7 +30,11 @@ const UserCreate = () => {
       message.success('User created successfully');
       navigate(`/users/${data.id}`);
     } catch (error) {
-      message.error('Failed to create user');
+      if (error instanceof UsernamePolicyError && error.violations.length > 0) {
+        form.setFields([{ name: 'username', errors: error.violations.map((v) => `Username ${v.message}`) }]);
+        return;
+      }
+      message.error(error instanceof Error ? error.message : 'Failed to create user');
       console.error('Failed to create user:', error);
     }
   };
@@ -1,5 +1,5 @@
 import { useNavigate, useParams } from 'react-router-dom';
-import { Button, Space, Tag, Spin, Alert, message, Popconfirm } from 'antd';
+import { Button, Space, Tag, Spin, Alert, message, Popconfirm, Timeline } from 'antd';
 import {
   EditOutlined,
   ArrowLeftOutlined,
@@ -9,8 +9,10 @@ import {
   ClockCircleOutlined,
   TagsOutlined,
   IdcardOutlined,
+  HistoryOutlined,
 } from '@ant-design/icons';
-import { useUser, useDeleteUser } from '../../hooks/useApi';
+import { useUser, useDeleteUser, useUserActivity } from '../../hooks/useApi';
+import type { UserActivityItem } from '../../hooks/useApi';
 
 const InfoRow = ({ label, children }: { label: string; children: React.ReactNode }) => (
   <div style={{ display: 'flex', padding: '10px 20px', borderBottom: '1px solid var(--border-subtle)' }}>
@@ -29,12 +31,33 @@ const InfoCard = ({ icon, title, children }: { icon: React.ReactNode; title: str
   </div>
 );
 
+const activityColors: Record<UserActivityItem['type'], string> = {
+  audit: 'gray',
+  token: 'blue',
+  consent: 'green',
+  session: 'purple',
+};
+
+const activityLabel = (item: UserActivityItem) => {
+  switch (item.type) {
+    case 'token':
+      return `Token issued to ${item.client_id}`;
+    case 'consent':
+      return `Consent granted to ${item.client_id}`;
+    case 'session':
+      return 'Signed in';
+    default:
+      return item.status && item.status !== 'success' ? `${item.action} (${item.status})` : item.action;
+  }
+};
+
 const UserDetail = () => {
   const { id } = useParams<{ id: string }>();
   const navigate = useNavigate();
 
   const { data: user, isLoading: loading, error: queryError } = useUser(id || '');
   const deleteUserMutation = useDeleteUser();
+  const { data: activity, isLoading: activityLoading } = useUserActivity(id || '');
 
   const handleDelete = async () => {
     try {
@@ -202,6 +225,31 @@ const UserDetail = () => {
           )}
         </div>
       </div>
+
+      <div style={{ marginTop: 24 }}>
+        <InfoCard icon={<HistoryOutlined />} title="Recent Activity">
+          <div style={{ padding: '16px 20px 0' }}>
+            {activityLoading ? (
+              <Spin />
+            ) : activity?.timeline.length ? (
+              <Timeline
+                items={activity.timeline.map((item) => ({
+                  key: `${item.type}-${item.id}`,
+                  color: activityColors[item.type],
+                  children: (
+                    <>
+                      <div style={{ fontSize: 13, color: 'var(--text-primary)' }}>{activityLabel(item)}</div>
+                      <div style={{ fontSize: 12, color: 'var(--text-muted)' }}>{new Date(item.time).toLocaleString()}</div>
+                    </>
+                  ),
+                }))}
+              />
+            ) : (
+              <div style={{ fontSize: 13, color: 'var(--text-muted)', paddingBottom: 16 }}>No recent activity</div>
+            )}
+          </div>
+        </InfoCard>
+      </div>
     </>
   );
 };
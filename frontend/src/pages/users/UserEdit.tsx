@@ -1,14 +1,15 @@
 import { useEffect } from 'react';
 import { useNavigate, useParams } from 'react-router-dom';
-import { Form, Input, Select, Button, Space, Spin, Alert, message, Row, Col } from 'antd';
+import { Form, Input, Select, Button, Space, Spin, Alert, message, Row, Col, Upload } from 'antd';
 import {
   ArrowLeftOutlined,
   UserOutlined,
   IdcardOutlined,
   PhoneOutlined,
   HomeOutlined,
+  UploadOutlined,
 } from '@ant-design/icons';
-import { useUser, useUpdateUser } from '../../hooks/useApi';
+import { useUser, useUpdateUser, useUploadUserAvatar, ConflictError } from '../../hooks/useApi';
 
 const { TextArea } = Input;
 
@@ -27,6 +28,7 @@ const UserEdit = () => {
 
   const { data: user, isLoading: loading } = useUser(id || '');
   const updateUserMutation = useUpdateUser();
+  const uploadAvatarMutation = useUploadUserAvatar();
 
   useEffect(() => {
     if (user) {
@@ -36,15 +38,25 @@ const UserEdit = () => {
 
   const handleSubmit = async (values: { username: string; email: string; name: string; role: string }) => {
     try {
-      await updateUserMutation.mutateAsync({ id: id!, ...values });
+      await updateUserMutation.mutateAsync({ id: id!, version: user?.version, ...values });
       message.success('User updated successfully');
       navigate(`/users/${id}`);
     } catch (error) {
-      message.error('Failed to update user');
+      message.error(error instanceof ConflictError ? error.message : 'Failed to update user');
       console.error('Failed to update user:', error);
     }
   };
 
+  const handleAvatarUpload = async (file: File) => {
+    try {
+      const { picture } = await uploadAvatarMutation.mutateAsync({ id: id!, file });
+      form.setFieldValue('picture', picture);
+      message.success('Avatar uploaded');
+    } catch (error) {
+      message.error(error instanceof Error ? error.message : 'Failed to upload avatar');
+    }
+  };
+
   if (loading) {
     return (
       <div style={{ display: 'flex', justifyContent: 'center', padding: '50px' }}>
@@ -149,7 +161,23 @@ const UserEdit = () => {
                 <Form.Item label="Profile URL" name="profile"><Input placeholder="https://example.com/profile" /></Form.Item>
               </Col>
               <Col span={12}>
-                <Form.Item label="Picture URL" name="picture"><Input placeholder="https://example.com/avatar.jpg" /></Form.Item>
+                <Form.Item
+                  label="Picture URL"
+                  name="picture"
+                  extra={
+                    <Upload
+                      accept="image/png,image/jpeg,image/gif"
+                      showUploadList={false}
+                      beforeUpload={(file) => { void handleAvatarUpload(file); return false; }}
+                    >
+                      <Button size="small" icon={<UploadOutlined />} loading={uploadAvatarMutation.isPending} style={{ marginTop: 8 }}>
+                        Upload image
+                      </Button>
+                    </Upload>
+                  }
+                >
+                  <Input placeholder="https://example.com/avatar.jpg" />
+                </Form.Item>
               </Col>
             </Row>
             <Row gutter={16}>
@@ -21,6 +21,7 @@ import {
   ClearOutlined,
 } from '@ant-design/icons';
 import type { ColumnsType } from 'antd/es/table';
+import { BASE_PATH } from '../../lib/basePath';
 
 const { Title } = Typography;
 
@@ -57,7 +58,7 @@ const UserSearch = () => {
       if (searchParams.role) params.append('role', searchParams.role);
 
       const queryString = params.toString();
-      const url = queryString ? `/api/admin/users?${queryString}` : '/api/admin/users';
+      const url = queryString ? `${BASE_PATH}/api/admin/users?${queryString}` : `${BASE_PATH}/api/admin/users`;
 
       const response = await fetch(url);
       if (!response.ok) throw new Error('Failed to search users');
@@ -18,6 +18,7 @@ export interface Client {
   jwks?: Record<string, unknown>;
   token_endpoint_auth_method?: string;
   client_id_issued_at?: number;
+  version: number;
   created_at: string;
   updated_at: string;
 }
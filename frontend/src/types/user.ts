@@ -20,6 +20,7 @@ export interface User {
   family_name?: string;
   picture?: string;
   address?: Address;
+  version: number;
   created_at: string;
   updated_at: string;
 }
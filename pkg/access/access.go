@@ -0,0 +1,201 @@
+// Package access evaluates conditional-access policies when an authorization is
+// about to complete. Policies see the client, the user, the network and the
+// strength of the sign-in, and allow it, deny it or require a step-up to a
+// stronger ACR.
+package access
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+)
+
+const (
+	defaultPolicyTimeout = 3 * time.Second
+
+	// PolicyTypeREST posts the request to an HTTP endpoint returning a Decision
+	PolicyTypeREST = "rest"
+	// PolicyTypeOPA queries an Open Policy Agent data API, wrapping the request as
+	// {"input": ...} and reading the Decision from "result"
+	PolicyTypeOPA = "opa"
+)
+
+// Decisions a policy can return
+const (
+	DecisionAllow  = "allow"
+	DecisionDeny   = "deny"
+	DecisionStepUp = "step_up"
+)
+
+// Request describes an authorization about to complete
+type Request struct {
+	ClientID   string    `json:"client_id"`
+	ClientName string    `json:"client_name,omitempty"`
+	UserID     string    `json:"user_id"`
+	Username   string    `json:"username"`
+	Email      string    `json:"email,omitempty"`
+	Role       string    `json:"role,omitempty"`
+	Scope      string    `json:"scope"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	ACR        string    `json:"acr,omitempty"`        // ACR of the user's session
+	AMR        []string  `json:"amr,omitempty"`        // Methods the user signed in with
+	ACRValues  []string  `json:"acr_values,omitempty"` // ACRs the client requested
+	AuthTime   time.Time `json:"auth_time"`
+}
+
+// Decision is a policy's answer. ACR names the sign-in strength a step-up must
+// reach; Reason is recorded in the audit log.
+type Decision struct {
+	Decision string `json:"decision"`
+	ACR      string `json:"acr,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Policy decides whether an authorization may complete. External policy engines
+// are usually reached through the REST or OPA policies, but custom checks such as
+// device posture lookups can implement this interface directly.
+type Policy interface {
+	Name() string
+	Evaluate(ctx context.Context, req *Request) (*Decision, error)
+}
+
+// rule wraps a policy with the settings that control when it is consulted
+type rule struct {
+	policy   Policy
+	clients  map[string]bool // Only consulted for these clients; empty = all
+	timeout  time.Duration
+	failOpen bool // Allow when the policy is unavailable instead of denying
+}
+
+// Evaluator runs its policies in order. A denial ends the evaluation; otherwise
+// the first step-up asked for is returned.
+type Evaluator struct {
+	rules []rule
+}
+
+// NewEvaluator builds an evaluator from the configured policies
+func NewEvaluator(configs []configstore.AccessPolicyConfig) (*Evaluator, error) {
+	e := &Evaluator{}
+	for _, cfg := range configs {
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("access policy %q: url is required", cfg.Name)
+		}
+		var policy Policy
+		switch cfg.Type {
+		case PolicyTypeREST, "":
+			policy = NewRESTPolicy(cfg.Name, cfg.URL, cfg.AuthHeader)
+		case PolicyTypeOPA:
+			policy = NewOPAPolicy(cfg.Name, cfg.URL, cfg.AuthHeader)
+		default:
+			return nil, fmt.Errorf("access policy %q: unsupported type %q", cfg.Name, cfg.Type)
+		}
+
+		r := rule{
+			policy:   policy,
+			timeout:  time.Duration(cfg.TimeoutSeconds) * time.Second,
+			failOpen: cfg.FailOpen,
+		}
+		if r.timeout <= 0 {
+			r.timeout = defaultPolicyTimeout
+		}
+		if len(cfg.Clients) > 0 {
+			r.clients = make(map[string]bool, len(cfg.Clients))
+			for _, clientID := range cfg.Clients {
+				r.clients[clientID] = true
+			}
+		}
+		e.rules = append(e.rules, r)
+	}
+	return e, nil
+}
+
+// AddPolicy registers a custom policy, consulted for every client. With failOpen,
+// errors from the policy are ignored; otherwise they deny the authorization.
+func (e *Evaluator) AddPolicy(policy Policy, failOpen bool) {
+	e.rules = append(e.rules, rule{policy: policy, timeout: defaultPolicyTimeout, failOpen: failOpen})
+}
+
+// Enabled reports whether any policy is configured
+func (e *Evaluator) Enabled() bool {
+	return e != nil && len(e.rules) > 0
+}
+
+// Evaluate returns the combined decision of the policies that apply to the
+// request's client. Without policies every authorization is allowed.
+func (e *Evaluator) Evaluate(ctx context.Context, req *Request) Decision {
+	if !e.Enabled() {
+		return Decision{Decision: DecisionAllow}
+	}
+
+	var stepUp *Decision
+	for _, r := range e.rules {
+		if len(r.clients) > 0 && !r.clients[req.ClientID] {
+			continue
+		}
+		decision, err := r.evaluate(ctx, req)
+		if err != nil {
+			log.Printf("Access policy %s failed for client %s: %v", r.policy.Name(), req.ClientID, err)
+			if r.failOpen {
+				continue
+			}
+			return Decision{Decision: DecisionDeny, Reason: fmt.Sprintf("policy %s is unavailable", r.policy.Name())}
+		}
+		switch decision.Decision {
+		case DecisionDeny:
+			if decision.Reason == "" {
+				decision.Reason = "denied by policy " + r.policy.Name()
+			}
+			return *decision
+		case DecisionStepUp:
+			if stepUp == nil {
+				stepUp = decision
+			}
+		}
+	}
+	if stepUp != nil {
+		return *stepUp
+	}
+	return Decision{Decision: DecisionAllow}
+}
+
+// evaluate asks one policy for its decision and checks that it is well-formed
+func (r rule) evaluate(ctx context.Context, req *Request) (*Decision, error) {
+	evalCtx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	decision, err := r.policy.Evaluate(evalCtx, req)
+	if err != nil {
+		return nil, err
+	}
+	if decision == nil {
+		return nil, fmt.Errorf("no decision")
+	}
+	switch decision.Decision {
+	case DecisionAllow, DecisionDeny:
+	case DecisionStepUp:
+		if decision.ACR == "" {
+			return nil, fmt.Errorf("step_up decision without acr")
+		}
+	default:
+		return nil, fmt.Errorf("unknown decision %q", decision.Decision)
+	}
+	return decision, nil
+}
+
+// denyAll denies every authorization
+type denyAll string
+
+// DenyAll returns a policy named name that denies every authorization, used in
+// place of policies that could not be set up
+func DenyAll(name string) Policy {
+	return denyAll(name)
+}
+
+func (d denyAll) Name() string { return string(d) }
+
+func (d denyAll) Evaluate(context.Context, *Request) (*Decision, error) {
+	return &Decision{Decision: DecisionDeny, Reason: "access policies are misconfigured"}, nil
+}
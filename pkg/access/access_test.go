@@ -0,0 +1,115 @@
+package access
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+)
+
+func TestEvaluatorOPAPolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer opa-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var body struct {
+			Input Request `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result := Decision{Decision: DecisionAllow}
+		switch {
+		case body.Input.IP == "203.0.113.9":
+			result = Decision{Decision: DecisionDeny, Reason: "blocked network"}
+		case body.Input.ACR != "gold":
+			result = Decision{Decision: DecisionStepUp, ACR: "gold"}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": result})
+	}))
+	defer server.Close()
+
+	evaluator, err := NewEvaluator([]configstore.AccessPolicyConfig{{
+		Name: "opa", Type: PolicyTypeOPA, URL: server.URL, AuthHeader: "Bearer opa-token", Clients: []string{"payroll"},
+	}})
+	if err != nil {
+		t.Fatalf("NewEvaluator failed: %v", err)
+	}
+	ctx := context.Background()
+
+	if got := evaluator.Evaluate(ctx, &Request{ClientID: "wiki", IP: "203.0.113.9"}); got.Decision != DecisionAllow {
+		t.Errorf("Policy should only apply to its clients, got %+v", got)
+	}
+	if got := evaluator.Evaluate(ctx, &Request{ClientID: "payroll", IP: "203.0.113.9"}); got.Decision != DecisionDeny || got.Reason != "blocked network" {
+		t.Errorf("Expected a denial, got %+v", got)
+	}
+	if got := evaluator.Evaluate(ctx, &Request{ClientID: "payroll", ACR: "bronze"}); got.Decision != DecisionStepUp || got.ACR != "gold" {
+		t.Errorf("Expected a step-up to gold, got %+v", got)
+	}
+	if got := evaluator.Evaluate(ctx, &Request{ClientID: "payroll", ACR: "gold"}); got.Decision != DecisionAllow {
+		t.Errorf("Expected the stepped-up sign-in to be allowed, got %+v", got)
+	}
+}
+
+func TestEvaluatorUnavailablePolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	closed, err := NewEvaluator([]configstore.AccessPolicyConfig{{Name: "posture", URL: server.URL}})
+	if err != nil {
+		t.Fatalf("NewEvaluator failed: %v", err)
+	}
+	if got := closed.Evaluate(context.Background(), &Request{ClientID: "c"}); got.Decision != DecisionDeny {
+		t.Errorf("An unavailable policy should deny by default, got %+v", got)
+	}
+
+	open, err := NewEvaluator([]configstore.AccessPolicyConfig{{Name: "posture", URL: server.URL, FailOpen: true}})
+	if err != nil {
+		t.Fatalf("NewEvaluator failed: %v", err)
+	}
+	if got := open.Evaluate(context.Background(), &Request{ClientID: "c"}); got.Decision != DecisionAllow {
+		t.Errorf("A fail-open policy should allow when unavailable, got %+v", got)
+	}
+
+	if _, err := NewEvaluator([]configstore.AccessPolicyConfig{{Name: "bad", Type: "ldap", URL: server.URL}}); err == nil {
+		t.Error("Unsupported policy types should be rejected")
+	}
+}
+
+// stubPolicy returns a fixed decision
+type stubPolicy Decision
+
+func (s stubPolicy) Name() string { return "stub" }
+
+func (s stubPolicy) Evaluate(context.Context, *Request) (*Decision, error) {
+	d := Decision(s)
+	return &d, nil
+}
+
+func TestEvaluatorCombinesPolicies(t *testing.T) {
+	evaluator := &Evaluator{}
+	evaluator.AddPolicy(stubPolicy{Decision: DecisionStepUp, ACR: "silver"}, false)
+	evaluator.AddPolicy(stubPolicy{Decision: DecisionStepUp, ACR: "gold"}, false)
+	if got := evaluator.Evaluate(context.Background(), &Request{}); got.ACR != "silver" {
+		t.Errorf("Expected the first step-up, got %+v", got)
+	}
+
+	evaluator.AddPolicy(stubPolicy{Decision: DecisionDeny}, false)
+	if got := evaluator.Evaluate(context.Background(), &Request{}); got.Decision != DecisionDeny {
+		t.Errorf("A denial should win over step-ups, got %+v", got)
+	}
+
+	// A step-up without a target ACR is a broken policy
+	broken := &Evaluator{}
+	broken.AddPolicy(stubPolicy{Decision: DecisionStepUp}, false)
+	if got := broken.Evaluate(context.Background(), &Request{}); got.Decision != DecisionDeny {
+		t.Errorf("Malformed decisions should deny, got %+v", got)
+	}
+}
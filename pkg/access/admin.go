@@ -0,0 +1,110 @@
+package access
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/prasenjit-net/openid-golang/pkg/outbound"
+)
+
+// AdminRequest describes an admin API call. Action is "<resource type>.<verb>",
+// e.g. "users.create", "clients.approve" or "keys.delete"; Method and Route allow
+// rules on the exact endpoint.
+type AdminRequest struct {
+	Subject  AdminSubject  `json:"subject"`
+	Action   string        `json:"action"`
+	Method   string        `json:"method"`
+	Route    string        `json:"route"` // Route pattern, e.g. /api/admin/users/:id
+	Resource AdminResource `json:"resource"`
+}
+
+// AdminSubject is the caller of an admin API request. Requests without a valid
+// admin token are sent with Authenticated false.
+type AdminSubject struct {
+	Authenticated bool   `json:"authenticated"`
+	Username      string `json:"username,omitempty"`
+	UserID        string `json:"user_id,omitempty"`
+	Role          string `json:"role,omitempty"`
+	BreakGlass    bool   `json:"break_glass,omitempty"` // Emergency access issued from the command line
+}
+
+// AdminResource is the object an admin API request acts on
+type AdminResource struct {
+	Type   string            `json:"type"`
+	ID     string            `json:"id,omitempty"`
+	Params map[string]string `json:"params,omitempty"` // All route parameters
+}
+
+// AdminAuthorizer decides whether an admin API request may proceed. Deployments
+// embedding the server can implement it with an in-process policy engine, such
+// as the OPA Go SDK loaded with a rego bundle.
+type AdminAuthorizer interface {
+	AuthorizeAdmin(ctx context.Context, req *AdminRequest) (allowed bool, reason string, err error)
+}
+
+// OPAAdminAuthorizer queries an Open Policy Agent data API with {"input": <request>}.
+// The decision document is either a boolean or {"allow": bool, "reason": "..."}.
+type OPAAdminAuthorizer struct {
+	url        string
+	authHeader string
+	client     *http.Client
+}
+
+// NewOPAAdminAuthorizer creates an authorizer for the decision at endpoint, e.g.
+// http://localhost:8181/v1/data/openid/admin. authHeader, if set, is sent as the
+// Authorization header.
+func NewOPAAdminAuthorizer(endpoint, authHeader string) *OPAAdminAuthorizer {
+	return &OPAAdminAuthorizer{url: endpoint, authHeader: authHeader, client: outbound.NewClient(outbound.Configured, 0)}
+}
+
+// AuthorizeAdmin asks OPA for a decision
+func (a *OPAAdminAuthorizer) AuthorizeAdmin(ctx context.Context, req *AdminRequest) (bool, string, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": req})
+	if err != nil {
+		return false, "", err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return false, "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json")
+	if a.authHeader != "" {
+		httpReq.Header.Set("Authorization", a.authHeader)
+	}
+
+	resp, err := a.client.Do(httpReq)
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, "", fmt.Errorf("policy returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, restMaxResponseSize)).Decode(&result); err != nil {
+		return false, "", fmt.Errorf("invalid policy response: %w", err)
+	}
+	if len(result.Result) == 0 {
+		return false, "", fmt.Errorf("policy decision is undefined")
+	}
+	var allowed bool
+	if err := json.Unmarshal(result.Result, &allowed); err == nil {
+		return allowed, "", nil
+	}
+	var decision struct {
+		Allow  bool   `json:"allow"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(result.Result, &decision); err != nil {
+		return false, "", fmt.Errorf("invalid policy decision: %w", err)
+	}
+	return decision.Allow, decision.Reason, nil
+}
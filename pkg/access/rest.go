@@ -0,0 +1,95 @@
+package access
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/prasenjit-net/openid-golang/pkg/outbound"
+)
+
+const restMaxResponseSize = 1 << 20
+
+// RESTPolicy posts the Request as JSON to an endpoint and reads a Decision from
+// the response. As an OPA policy, the request is sent as {"input": <request>} to
+// a data API path such as http://opa:8181/v1/data/oidc/authorize and the decision
+// is read from {"result": <decision>}.
+type RESTPolicy struct {
+	name       string
+	url        string
+	authHeader string
+	opa        bool
+	client     *http.Client
+}
+
+// NewRESTPolicy creates a REST access policy. authHeader, if set, is sent as the
+// Authorization header.
+func NewRESTPolicy(name, endpoint, authHeader string) *RESTPolicy {
+	return &RESTPolicy{name: name, url: endpoint, authHeader: authHeader, client: outbound.NewClient(outbound.Configured, 0)}
+}
+
+// NewOPAPolicy creates a policy backed by an Open Policy Agent data API
+func NewOPAPolicy(name, endpoint, authHeader string) *RESTPolicy {
+	p := NewRESTPolicy(name, endpoint, authHeader)
+	p.opa = true
+	return p
+}
+
+// Name returns the policy name
+func (p *RESTPolicy) Name() string {
+	return p.name
+}
+
+// Evaluate asks the endpoint for a decision
+func (p *RESTPolicy) Evaluate(ctx context.Context, req *Request) (*Decision, error) {
+	var payload interface{} = req
+	if p.opa {
+		payload = map[string]interface{}{"input": req}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json")
+	if p.authHeader != "" {
+		httpReq.Header.Set("Authorization", p.authHeader)
+	}
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("policy returned status %d", resp.StatusCode)
+	}
+
+	decoder := json.NewDecoder(io.LimitReader(resp.Body, restMaxResponseSize))
+	if p.opa {
+		var result struct {
+			Result *Decision `json:"result"`
+		}
+		if err := decoder.Decode(&result); err != nil {
+			return nil, fmt.Errorf("invalid policy response: %w", err)
+		}
+		if result.Result == nil {
+			return nil, fmt.Errorf("policy decision is undefined")
+		}
+		return result.Result, nil
+	}
+	var decision Decision
+	if err := decoder.Decode(&decision); err != nil {
+		return nil, fmt.Errorf("invalid policy response: %w", err)
+	}
+	return &decision, nil
+}
@@ -0,0 +1,327 @@
+// Package adminclient is a Go client for the server's admin API (/api/admin). It
+// signs in with an admin's credentials or uses a given bearer token, retries
+// requests the server turned away while busy, and decodes responses into typed
+// values, so platform teams can automate user, client, key and session
+// management without writing REST calls by hand.
+//
+//	admin, err := adminclient.New(adminclient.Config{
+//		BaseURL:  "https://id.example.com",
+//		Username: "admin",
+//		Password: os.Getenv("OPENID_ADMIN_PASSWORD"),
+//	})
+//	users, err := admin.ListUsers(ctx, adminclient.UserFilter{Role: "admin"})
+package adminclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout    = 30 * time.Second
+	defaultMaxRetries = 3
+	// tokenLifetime is how long an admin token is used before signing in again;
+	// the server issues them for 24 hours
+	tokenLifetime = 23 * time.Hour
+	maxRetryWait  = 30 * time.Second
+)
+
+// initialRetryWait is the pause before the first retry, doubling for each
+// further one unless the server sends Retry-After; shortened in tests
+var initialRetryWait = 500 * time.Millisecond
+
+// Config configures a Client
+type Config struct {
+	// BaseURL is the server's address, including the base path if it has one,
+	// e.g. "https://id.example.com" or "https://example.com/openid"
+	BaseURL string
+
+	// Token is an admin bearer token. When it is empty the client signs in with
+	// Username and Password, and signs in again when the token expires.
+	Token    string
+	Username string
+	Password string
+
+	// HTTPClient sends the requests; defaults to a client with a 30 second timeout
+	HTTPClient *http.Client
+	// MaxRetries is how often a failed request is retried; defaults to 3, and a
+	// negative value disables retries
+	MaxRetries int
+	// UserAgent is sent with every request
+	UserAgent string
+}
+
+// Client calls the admin API. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	maxRetries int
+	userAgent  string
+	username   string
+	password   string
+
+	mu         sync.Mutex
+	token      string
+	tokenUntil time.Time // Zero for a token given in Config
+}
+
+// New returns a client for the server at cfg.BaseURL
+func New(cfg Config) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(cfg.BaseURL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("adminclient: invalid base URL %q", cfg.BaseURL)
+	}
+	if cfg.Token == "" && (cfg.Username == "" || cfg.Password == "") {
+		return nil, errors.New("adminclient: a token or a username and password are required")
+	}
+	c := &Client{
+		baseURL:    base,
+		httpClient: cfg.HTTPClient,
+		maxRetries: cfg.MaxRetries,
+		userAgent:  cfg.UserAgent,
+		username:   cfg.Username,
+		password:   cfg.Password,
+		token:      cfg.Token,
+	}
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+	if c.maxRetries == 0 {
+		c.maxRetries = defaultMaxRetries
+	} else if c.maxRetries < 0 {
+		c.maxRetries = 0
+	}
+	if c.userAgent == "" {
+		c.userAgent = "openid-golang-adminclient"
+	}
+	return c, nil
+}
+
+// APIError is an error answer from the server
+type APIError struct {
+	StatusCode int
+	Message    string // The "error" member of the response, or its status text
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("admin API: %d %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 answer
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// IsConflict reports whether err is a 409 answer, e.g. for an email already in use
+// or an update based on a version that has changed since it was read
+func IsConflict(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict
+}
+
+// Login signs in with the configured username and password. Other methods sign
+// in when needed, so calling it is only useful to check the credentials early.
+func (c *Client) Login(ctx context.Context) error {
+	if c.username == "" {
+		return errors.New("adminclient: no username and password configured")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.login(ctx)
+}
+
+// login gets a new token; c.mu must be held
+func (c *Client) login(ctx context.Context) error {
+	body := map[string]string{"username": c.username, "password": c.password}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := c.send(ctx, http.MethodPost, "/api/admin/login", "", nil, body, &resp, ""); err != nil {
+		return err
+	}
+	if resp.Token == "" {
+		return errors.New("adminclient: login returned no token")
+	}
+	c.token = resp.Token
+	c.tokenUntil = time.Now().Add(tokenLifetime)
+	return nil
+}
+
+// bearer returns the token to send, signing in first if there is none or it expired
+func (c *Client) bearer(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.username != "" && (c.token == "" || time.Now().After(c.tokenUntil)) {
+		if err := c.login(ctx); err != nil {
+			return "", err
+		}
+	}
+	return c.token, nil
+}
+
+// dropToken forgets a token the server refused, unless another request already
+// replaced it
+func (c *Client) dropToken(token string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.username == "" {
+		return false
+	}
+	if c.token == token {
+		c.token = ""
+	}
+	return true
+}
+
+// do sends an authenticated request to path below /api/admin. A 401 answer makes a
+// client with credentials sign in again and repeat the request once.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	return c.doIfMatch(ctx, method, path, "", query, body, out)
+}
+
+// doIfMatch is do for updates based on a version of the resource, which the server
+// refuses with 409 Conflict once it has changed
+func (c *Client) doIfMatch(ctx context.Context, method, path, version string, query url.Values, body, out interface{}) error {
+	path = "/api/admin" + path
+	for attempt := 0; ; attempt++ {
+		token, err := c.bearer(ctx)
+		if err != nil {
+			return err
+		}
+		err = c.send(ctx, method, path, version, query, body, out, token)
+		var apiErr *APIError
+		if attempt == 0 && errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized && c.dropToken(token) {
+			continue
+		}
+		return err
+	}
+}
+
+// send makes a request, retrying it while the server is unavailable, and decodes
+// a JSON answer into out. A non-empty version is sent in If-Match.
+func (c *Client) send(ctx context.Context, method, path, version string, query url.Values, body, out interface{}, token string) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("adminclient: failed to encode request: %w", err)
+		}
+	}
+	target := *c.baseURL
+	target.Path += path
+	target.RawQuery = query.Encode()
+
+	wait := initialRetryWait
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("adminclient: %w", err)
+		}
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("User-Agent", c.userAgent)
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if version != "" {
+			req.Header.Set("If-Match", `"`+version+`"`)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		retryAfter := time.Duration(0)
+		if err == nil {
+			if !retryableStatus(method, resp.StatusCode) || attempt >= c.maxRetries {
+				return decodeResponse(resp, out)
+			}
+			retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+			_ = resp.Body.Close() // Best effort close
+		} else if !idempotent(method) || attempt >= c.maxRetries || ctx.Err() != nil {
+			return fmt.Errorf("adminclient: %s %s: %w", method, path, err)
+		}
+
+		delay := wait
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+		if delay > maxRetryWait {
+			delay = maxRetryWait
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("adminclient: %s %s: %w", method, path, ctx.Err())
+		case <-time.After(delay):
+		}
+		wait *= 2
+	}
+}
+
+// retryableStatus reports whether a request may be repeated after the answer. 429
+// and 503 are sent before the server acts on a request, e.g. in maintenance mode
+// or while storage is unavailable, so any request can be repeated. Gateway errors
+// leave it unknown whether the request was carried out.
+func retryableStatus(method string, status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent(method)
+	}
+	return false
+}
+
+// idempotent reports whether repeating a request has the same effect as sending it once
+func idempotent(method string) bool {
+	return method == http.MethodGet || method == http.MethodPut || method == http.MethodDelete
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as a date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+// decodeResponse turns an error answer into an *APIError and decodes a
+// successful one into out
+func decodeResponse(resp *http.Response, out interface{}) error {
+	defer func() {
+		_ = resp.Body.Close() // Best effort close
+	}()
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := &APIError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		var body struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body) == nil && body.Error != "" {
+			apiErr.Message = body.Error
+		}
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("adminclient: failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package adminclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/handlers"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+// newAdminServer serves the admin API routes the client uses, backed by a JSON
+// store with one admin user
+func newAdminServer(t *testing.T) (*httptest.Server, storage.Storage) {
+	store, err := storage.NewJSONStorage(filepath.Join(t.TempDir(), "store.json"))
+	require.NoError(t, err)
+	hash, err := crypto.HashPassword("admin-pass")
+	require.NoError(t, err)
+	require.NoError(t, store.CreateUser(&models.User{ID: "admin-1", Username: "root", Email: "root@example.com",
+		PasswordHash: hash, Role: models.RoleAdmin}))
+
+	admin := handlers.NewAdminHandler(store, &configstore.ConfigData{Issuer: "https://example.com"}, nil)
+	e := echo.New()
+	api := e.Group("/api/admin", admin.PolicyGuard())
+	api.POST("/login", admin.Login)
+	api.GET("/users", admin.ListUsers)
+	api.GET("/users/:id", admin.GetUser)
+	api.POST("/users", admin.CreateUser)
+	api.PUT("/users/:id", admin.UpdateUser)
+	api.DELETE("/users/:id", admin.DeleteUser)
+	api.POST("/users/:id/disable", admin.DisableUser)
+	api.GET("/users/:id/activity", admin.GetUserActivity)
+	api.GET("/clients", admin.ListClients)
+	api.GET("/clients/:id", admin.GetClient)
+	api.POST("/clients", admin.CreateClient)
+	api.PUT("/clients/:id", admin.UpdateClient)
+	api.POST("/clients/:id/regenerate-secret", admin.RegenerateClientSecret)
+	api.POST("/clients/:id/disable", admin.DisableClient)
+	api.DELETE("/sessions/:id", admin.TerminateSession)
+
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+	return server, store
+}
+
+func TestUsersAndClients(t *testing.T) {
+	server, store := newAdminServer(t)
+	admin, err := New(Config{BaseURL: server.URL, Username: "root", Password: "admin-pass"})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	created, err := admin.CreateUser(ctx, UserCreate{Username: "alice", Email: "alice@example.com", Password: "pw-123456"})
+	require.NoError(t, err)
+	assert.Equal(t, models.RoleUser, created.Role)
+	_, err = admin.CreateUser(ctx, UserCreate{Username: "alice2", Email: "alice@example.com", Password: "pw-123456"})
+	assert.True(t, IsConflict(err))
+
+	user, err := admin.GetUser(ctx, created.ID)
+	require.NoError(t, err)
+	user.GivenName = "Alice"
+	updated, err := admin.UpdateUser(ctx, user, "")
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", updated.GivenName)
+	assert.Equal(t, user.Version+1, updated.Version)
+	_, err = admin.UpdateUser(ctx, user, "")
+	assert.True(t, IsConflict(err), "the update is based on a version that has changed")
+
+	require.NoError(t, admin.DisableUser(ctx, created.ID))
+	users, err := admin.ListUsers(ctx, UserFilter{Username: "ali"})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.True(t, users[0].Disabled)
+
+	require.NoError(t, store.CreateUserSession(&models.UserSession{ID: "s-1", UserID: created.ID}))
+	sessions, err := admin.ListUserSessions(ctx, created.ID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.NoError(t, admin.TerminateSession(ctx, "s-1"))
+
+	require.NoError(t, admin.DeleteUser(ctx, created.ID, true))
+	_, err = admin.GetUser(ctx, created.ID)
+	assert.True(t, IsNotFound(err))
+
+	client, err := admin.CreateClient(ctx, ClientCreate{Name: "Billing", RedirectURIs: []string{"https://billing.example.com/cb"}})
+	require.NoError(t, err)
+	assert.NotEmpty(t, client.ClientSecret)
+	pkce := true
+	require.NoError(t, admin.UpdateClient(ctx, client.ClientID, ClientUpdate{RequirePKCE: &pkce}))
+	require.NoError(t, admin.DisableClient(ctx, client.ClientID))
+	secret, err := admin.RegenerateClientSecret(ctx, client.ClientID)
+	require.NoError(t, err)
+	assert.NotEqual(t, client.ClientSecret, secret)
+
+	got, err := admin.GetClient(ctx, client.ClientID)
+	require.NoError(t, err)
+	assert.Equal(t, "Billing", got.Name)
+	assert.True(t, got.RequirePKCE)
+	assert.True(t, got.Disabled)
+	assert.Empty(t, got.ClientSecret)
+	assert.Equal(t, 3, got.Version, "the update, disabling and the new secret are all stored changes")
+	assert.True(t, IsConflict(admin.UpdateClient(ctx, client.ClientID, ClientUpdate{Name: "Stale"})))
+
+	clients, err := admin.ListClients(ctx, ClientFilter{Name: "bill"})
+	require.NoError(t, err)
+	assert.Len(t, clients, 1)
+
+	// The audit log names the admin the client signed in as
+	logs, err := store.GetAuditLogs(models.AuditFilter{Action: models.AuditActionAdminClientCreated})
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "root", logs[0].Actor)
+}
+
+func TestLoginErrors(t *testing.T) {
+	server, _ := newAdminServer(t)
+	admin, err := New(Config{BaseURL: server.URL, Username: "root", Password: "wrong"})
+	require.NoError(t, err)
+	err = admin.Login(context.Background())
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+	assert.Equal(t, "Invalid credentials", apiErr.Message)
+
+	_, err = New(Config{BaseURL: server.URL})
+	assert.Error(t, err)
+	_, err = New(Config{BaseURL: "ftp://example.com", Token: "t"})
+	assert.Error(t, err)
+}
+
+func TestSignsInAgainWhenTokenIsRefused(t *testing.T) {
+	var logins, calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/admin/login":
+			n := logins.Add(1)
+			_, _ = w.Write([]byte(`{"token": "token-` + string(rune('0'+n)) + `"}`))
+		default:
+			calls.Add(1)
+			if r.Header.Get("Authorization") != "Bearer token-2" {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"error": "Invalid token"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	admin, err := New(Config{BaseURL: server.URL, Username: "root", Password: "pw"})
+	require.NoError(t, err)
+	_, err = admin.ListKeys(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), logins.Load())
+	assert.Equal(t, int32(2), calls.Load())
+
+	// A fixed token can't be renewed
+	fixed, err := New(Config{BaseURL: server.URL, Token: "token-1"})
+	require.NoError(t, err)
+	_, err = fixed.ListKeys(context.Background())
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+}
+
+func TestRetries(t *testing.T) {
+	initialRetryWait = time.Millisecond
+	t.Cleanup(func() { initialRetryWait = 500 * time.Millisecond })
+
+	var calls atomic.Int32
+	var status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(int(status.Load()))
+			return
+		}
+		_, _ = w.Write([]byte(`{"client_id": "c", "client_secret": "s"}`))
+	}))
+	defer server.Close()
+	admin, err := New(Config{BaseURL: server.URL, Token: "t"})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// 503 is answered before the request is acted on, so even a POST is repeated
+	secret, err := admin.RegenerateClientSecret(ctx, "c")
+	require.NoError(t, err)
+	assert.Equal(t, "s", secret)
+	assert.Equal(t, int32(3), calls.Load())
+
+	// A gateway timeout may hide a request that was carried out
+	calls.Store(0)
+	status.Store(http.StatusGatewayTimeout)
+	_, err = admin.RegenerateClientSecret(ctx, "c")
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+	calls.Store(0)
+	_, err = admin.GetClient(ctx, "c")
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), calls.Load())
+
+	// Retries stop after MaxRetries
+	calls.Store(-10)
+	once, err := New(Config{BaseURL: server.URL, Token: "t", MaxRetries: 1})
+	require.NoError(t, err)
+	_, err = once.GetClient(ctx, "c")
+	assert.Error(t, err)
+	assert.Equal(t, int32(-8), calls.Load())
+}
@@ -0,0 +1,169 @@
+package adminclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// OAuthClient is a client application registered with the server. ListClients
+// fills in the registration fields only; GetClient returns every setting.
+type OAuthClient struct {
+	ClientID                string    `json:"client_id"`
+	ClientSecret            string    `json:"client_secret,omitempty"` // Only returned by CreateClient and RegenerateClientSecret
+	Name                    string    `json:"name"`
+	RedirectURIs            []string  `json:"redirect_uris"`
+	GrantTypes              []string  `json:"grant_types"`
+	ResponseTypes           []string  `json:"response_types"`
+	Scope                   string    `json:"scope"`
+	ApplicationType         string    `json:"application_type"`
+	Contacts                []string  `json:"contacts,omitempty"`
+	ClientURI               string    `json:"client_uri,omitempty"`
+	LogoURI                 string    `json:"logo_uri,omitempty"`
+	PolicyURI               string    `json:"policy_uri,omitempty"`
+	TosURI                  string    `json:"tos_uri,omitempty"`
+	JWKSURI                 string    `json:"jwks_uri,omitempty"`
+	TokenEndpointAuthMethod string    `json:"token_endpoint_auth_method,omitempty"`
+	Status                  string    `json:"status,omitempty"` // Registration review state, empty = active
+	Disabled                bool      `json:"disabled"`
+	Version                 int       `json:"version"` // Counts updates; see ClientUpdate
+	CreatedAt               time.Time `json:"created_at"`
+
+	DebugLogging               bool              `json:"debug_logging,omitempty"`
+	AuthFlow                   string            `json:"auth_flow,omitempty"`
+	SigningKeyID               string            `json:"signing_key_id,omitempty"`
+	ResourceServer             bool              `json:"resource_server,omitempty"`
+	ResourceScopes             []string          `json:"resource_scopes,omitempty"`
+	RequirePKCE                bool              `json:"require_pkce,omitempty"`
+	MinimalIDToken             bool              `json:"minimal_id_token,omitempty"`
+	FirstParty                 bool              `json:"first_party,omitempty"`
+	FirstPartyScopes           []string          `json:"first_party_scopes,omitempty"`
+	InstanceBinding            string            `json:"instance_binding,omitempty"`
+	InstanceAttestation        []string          `json:"instance_attestation,omitempty"`
+	TrustAnchor                string            `json:"trust_anchor,omitempty"`
+	RedirectURIFindings        []string          `json:"redirect_uri_findings,omitempty"`
+	BindRefreshTokensToSession bool              `json:"bind_refresh_tokens_to_session,omitempty"`
+	RefreshTokenMaxUses        int               `json:"refresh_token_max_uses,omitempty"`
+	RefreshTokenIdleTimeout    int               `json:"refresh_token_idle_timeout,omitempty"`
+	UserInfoCacheSeconds       int               `json:"userinfo_cache_seconds,omitempty"`
+	TokenResponseParams        map[string]string `json:"token_response_params,omitempty"`
+}
+
+// ClientCreate is a client application to register. Grant types, response types,
+// scope and application type get the server's defaults when empty.
+type ClientCreate struct {
+	Name            string   `json:"name"`
+	RedirectURIs    []string `json:"redirect_uris"`
+	GrantTypes      []string `json:"grant_types,omitempty"`
+	ResponseTypes   []string `json:"response_types,omitempty"`
+	Scope           string   `json:"scope,omitempty"`
+	ApplicationType string   `json:"application_type,omitempty"`
+}
+
+// ClientUpdate changes the settings of a client. Empty and nil fields are left
+// as they are.
+type ClientUpdate struct {
+	Version int `json:"-"` // The version of the client the update is based on, from GetClient
+
+	Name            string   `json:"name,omitempty"`
+	RedirectURIs    []string `json:"redirect_uris,omitempty"`
+	GrantTypes      []string `json:"grant_types,omitempty"`
+	ResponseTypes   []string `json:"response_types,omitempty"`
+	Scope           string   `json:"scope,omitempty"`
+	ApplicationType string   `json:"application_type,omitempty"`
+
+	DebugLogging               *bool             `json:"debug_logging,omitempty"`
+	AuthFlow                   *string           `json:"auth_flow,omitempty"`
+	SigningKeyID               *string           `json:"signing_key_id,omitempty"`
+	ResourceServer             *bool             `json:"resource_server,omitempty"`
+	ResourceScopes             []string          `json:"resource_scopes,omitempty"`
+	RequirePKCE                *bool             `json:"require_pkce,omitempty"`
+	MinimalIDToken             *bool             `json:"minimal_id_token,omitempty"`
+	FirstParty                 *bool             `json:"first_party,omitempty"`
+	FirstPartyScopes           []string          `json:"first_party_scopes,omitempty"`
+	InstanceBinding            *string           `json:"instance_binding,omitempty"`
+	InstanceAttestation        []string          `json:"instance_attestation,omitempty"`
+	BindRefreshTokensToSession *bool             `json:"bind_refresh_tokens_to_session,omitempty"`
+	RefreshTokenMaxUses        *int              `json:"refresh_token_max_uses,omitempty"`
+	RefreshTokenIdleTimeout    *int              `json:"refresh_token_idle_timeout,omitempty"`
+	UserInfoCacheSeconds       *int              `json:"userinfo_cache_seconds,omitempty"`
+	TokenResponseParams        map[string]string `json:"token_response_params,omitempty"`
+}
+
+// ClientFilter narrows ListClients. Fields match case-insensitively anywhere in
+// the value.
+type ClientFilter struct {
+	ClientID string
+	Name     string
+}
+
+// ListClients returns the clients matching filter
+func (c *Client) ListClients(ctx context.Context, filter ClientFilter) ([]*OAuthClient, error) {
+	query := url.Values{}
+	setIf(query, "client_id", filter.ClientID)
+	setIf(query, "name", filter.Name)
+	var clients []*OAuthClient
+	err := c.do(ctx, http.MethodGet, "/clients", query, nil, &clients)
+	return clients, err
+}
+
+// GetClient returns a client with all its settings
+func (c *Client) GetClient(ctx context.Context, clientID string) (*OAuthClient, error) {
+	var client OAuthClient
+	if err := c.do(ctx, http.MethodGet, "/clients/"+url.PathEscape(clientID), nil, nil, &client); err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// CreateClient registers a client. The returned client carries its secret, which
+// the server does not show again.
+func (c *Client) CreateClient(ctx context.Context, client ClientCreate) (*OAuthClient, error) {
+	var created OAuthClient
+	if err := c.do(ctx, http.MethodPost, "/clients", nil, client, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// UpdateClient changes the settings of a client. It fails with a conflict if the
+// client has changed since update.Version was read.
+func (c *Client) UpdateClient(ctx context.Context, clientID string, update ClientUpdate) error {
+	return c.doIfMatch(ctx, http.MethodPut, "/clients/"+url.PathEscape(clientID), strconv.Itoa(update.Version), nil, update, nil)
+}
+
+// DeleteClient removes a client
+func (c *Client) DeleteClient(ctx context.Context, clientID string) error {
+	return c.do(ctx, http.MethodDelete, "/clients/"+url.PathEscape(clientID), nil, nil, nil)
+}
+
+// RegenerateClientSecret replaces a client's secret and returns the new one
+func (c *Client) RegenerateClientSecret(ctx context.Context, clientID string) (string, error) {
+	var resp struct {
+		ClientSecret string `json:"client_secret"`
+	}
+	err := c.do(ctx, http.MethodPost, "/clients/"+url.PathEscape(clientID)+"/regenerate-secret", nil, struct{}{}, &resp)
+	return resp.ClientSecret, err
+}
+
+// EnableClient lifts a client suspension
+func (c *Client) EnableClient(ctx context.Context, clientID string) error {
+	return c.do(ctx, http.MethodPost, "/clients/"+url.PathEscape(clientID)+"/enable", nil, struct{}{}, nil)
+}
+
+// DisableClient suspends a client, keeping its configuration, secret and consents
+func (c *Client) DisableClient(ctx context.Context, clientID string) error {
+	return c.do(ctx, http.MethodPost, "/clients/"+url.PathEscape(clientID)+"/disable", nil, struct{}{}, nil)
+}
+
+// ApproveClient activates a dynamically registered client held for review
+func (c *Client) ApproveClient(ctx context.Context, clientID string) error {
+	return c.do(ctx, http.MethodPost, "/clients/"+url.PathEscape(clientID)+"/approve", nil, struct{}{}, nil)
+}
+
+// RejectClient refuses a dynamically registered client held for review
+func (c *Client) RejectClient(ctx context.Context, clientID string) error {
+	return c.do(ctx, http.MethodPost, "/clients/"+url.PathEscape(clientID)+"/reject", nil, struct{}{}, nil)
+}
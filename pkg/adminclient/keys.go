@@ -0,0 +1,85 @@
+package adminclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// SigningKey is a key held by the server: a token signing key, a request object
+// encryption key or a cookie key
+type SigningKey struct {
+	ID        string           `json:"id"`
+	KID       string           `json:"kid"`
+	Algorithm string           `json:"algorithm"`
+	IsActive  bool             `json:"is_active"`
+	CreatedAt time.Time        `json:"created_at"`
+	ExpiresAt time.Time        `json:"expires_at,omitempty"`
+	Status    string           `json:"status"` // "active", "expired" or "inactive"
+	Use       string           `json:"use"`    // "sig", "enc" or "cookie"
+	Cert      *CertificateInfo `json:"cert,omitempty"`
+	HasCSR    bool             `json:"has_csr"`
+}
+
+// CertificateInfo describes the certificate of a key
+type CertificateInfo struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Serial      string    `json:"serial"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	Fingerprint string    `json:"fingerprint"` // x5t#S256
+	SelfSigned  bool      `json:"self_signed"`
+}
+
+// KeyHistoryEntry is a current or purged key and how many tokens it signed
+type KeyHistoryEntry struct {
+	ID              string     `json:"id,omitempty"`
+	KID             string     `json:"kid"`
+	Use             string     `json:"use"`
+	Status          string     `json:"status"` // "active", "inactive", "expired" or "purged"
+	CreatedAt       time.Time  `json:"created_at"`
+	ExpiresAt       time.Time  `json:"expires_at,omitempty"`
+	TokensSigned    int        `json:"tokens_signed"`
+	UnexpiredTokens int        `json:"unexpired_tokens"`
+	PinnedClients   int        `json:"pinned_clients"`
+	PurgeAfter      *time.Time `json:"purge_after,omitempty"`
+	PurgedAt        *time.Time `json:"purged_at,omitempty"`
+}
+
+// ListKeys returns the keys the server holds
+func (c *Client) ListKeys(ctx context.Context) ([]*SigningKey, error) {
+	var keys []*SigningKey
+	err := c.do(ctx, http.MethodGet, "/keys", nil, nil, &keys)
+	return keys, err
+}
+
+// KeyHistory returns current keys with their token usage, newest first, followed
+// by keys already purged
+func (c *Client) KeyHistory(ctx context.Context) ([]*KeyHistoryEntry, error) {
+	var history []*KeyHistoryEntry
+	err := c.do(ctx, http.MethodGet, "/keys/history", nil, nil, &history)
+	return history, err
+}
+
+// RotateKeys makes a new signing key with a self-signed certificate valid for
+// validityDays (90 when zero) the active one, and returns its kid. The previous
+// key stays published until it expires.
+func (c *Client) RotateKeys(ctx context.Context, validityDays int) (string, error) {
+	body := map[string]int{}
+	if validityDays > 0 {
+		body["validity_days"] = validityDays
+	}
+	var resp struct {
+		NewKeyID string `json:"new_key_id"`
+	}
+	err := c.do(ctx, http.MethodPost, "/settings/rotate-keys", nil, body, &resp)
+	return resp.NewKeyID, err
+}
+
+// DeleteKey removes an inactive key that no unexpired token or pinned client
+// depends on; the server answers 409 otherwise
+func (c *Client) DeleteKey(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/keys/"+url.PathEscape(id), nil, nil, nil)
+}
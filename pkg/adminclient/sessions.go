@@ -0,0 +1,68 @@
+package adminclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// Token describes an issued token. Token values are never returned.
+type Token struct {
+	ID                  string    `json:"id"`
+	AccessTokenPrefix   string    `json:"access_token_prefix"`
+	RefreshTokenPresent bool      `json:"refresh_token_present"`
+	TokenType           string    `json:"token_type"`
+	ClientID            string    `json:"client_id"`
+	UserID              string    `json:"user_id"`
+	Username            string    `json:"username"`
+	Scope               string    `json:"scope"`
+	ExpiresAt           time.Time `json:"expires_at"`
+	CreatedAt           time.Time `json:"created_at"`
+	IsActive            bool      `json:"is_active"`
+}
+
+// TokenFilter narrows ListTokens
+type TokenFilter struct {
+	ClientID       string
+	UserID         string
+	IncludeExpired bool
+}
+
+// ListUserSessions returns the sign-in sessions of a user, newest first
+func (c *Client) ListUserSessions(ctx context.Context, userID string) ([]*models.UserSession, error) {
+	var activity struct {
+		Sessions []*models.UserSession `json:"sessions"`
+	}
+	query := url.Values{"limit": {strconv.Itoa(200)}}
+	err := c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(userID)+"/activity", query, nil, &activity)
+	return activity.Sessions, err
+}
+
+// TerminateSession ends a user session and revokes the refresh tokens bound to it
+func (c *Client) TerminateSession(ctx context.Context, sessionID string) error {
+	return c.do(ctx, http.MethodDelete, "/sessions/"+url.PathEscape(sessionID), nil, nil, nil)
+}
+
+// ListTokens returns the tokens matching filter
+func (c *Client) ListTokens(ctx context.Context, filter TokenFilter) ([]*Token, error) {
+	query := url.Values{}
+	setIf(query, "client_id", filter.ClientID)
+	setIf(query, "user_id", filter.UserID)
+	if filter.IncludeExpired {
+		query.Set("active", "false")
+	}
+	var resp struct {
+		Tokens []*Token `json:"tokens"`
+	}
+	err := c.do(ctx, http.MethodGet, "/tokens", query, nil, &resp)
+	return resp.Tokens, err
+}
+
+// RevokeToken revokes a token by its ID
+func (c *Client) RevokeToken(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/tokens/"+url.PathEscape(id), nil, nil, nil)
+}
@@ -0,0 +1,113 @@
+package adminclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// UserFilter narrows ListUsers. Text fields match case-insensitively anywhere in
+// the value.
+type UserFilter struct {
+	Username       string
+	Email          string
+	Name           string
+	Role           string
+	IncludeDeleted bool
+}
+
+// UserCreate is a user to create
+type UserCreate struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Name     string `json:"name,omitempty"`
+	Role     string `json:"role,omitempty"` // "user" when empty
+}
+
+// ListUsers returns the users matching filter. List entries carry the account
+// fields only; GetUser returns the full profile.
+func (c *Client) ListUsers(ctx context.Context, filter UserFilter) ([]*models.User, error) {
+	query := url.Values{}
+	setIf(query, "username", filter.Username)
+	setIf(query, "email", filter.Email)
+	setIf(query, "name", filter.Name)
+	setIf(query, "role", filter.Role)
+	if filter.IncludeDeleted {
+		query.Set("include_deleted", "true")
+	}
+	var users []*models.User
+	err := c.do(ctx, http.MethodGet, "/users", query, nil, &users)
+	return users, err
+}
+
+// GetUser returns a user with their full profile
+func (c *Client) GetUser(ctx context.Context, id string) (*models.User, error) {
+	var user models.User
+	if err := c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(id), nil, nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CreateUser creates a user and returns it
+func (c *Client) CreateUser(ctx context.Context, user UserCreate) (*models.User, error) {
+	var created models.User
+	if err := c.do(ctx, http.MethodPost, "/users", nil, user, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// UpdateUser replaces the profile of user.ID with user, so fields left empty are
+// cleared; start from GetUser to change a few. A non-empty password is set as
+// the user's new password. The update fails with a conflict if the user has
+// changed since user.Version was read.
+func (c *Client) UpdateUser(ctx context.Context, user *models.User, password string) (*models.User, error) {
+	body := struct {
+		*models.User
+		Password string `json:"password,omitempty"`
+	}{user, password}
+	var updated models.User
+	if err := c.doIfMatch(ctx, http.MethodPut, "/users/"+url.PathEscape(user.ID), strconv.Itoa(user.Version), nil, body, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// DeleteUser soft-deletes a user, who can be restored with EnableUser. With hard
+// the user is removed permanently.
+func (c *Client) DeleteUser(ctx context.Context, id string, hard bool) error {
+	query := url.Values{}
+	if hard {
+		query.Set("hard", "true")
+	}
+	return c.do(ctx, http.MethodDelete, "/users/"+url.PathEscape(id), query, nil, nil)
+}
+
+// EnableUser lets a disabled, locked or soft-deleted user sign in again
+func (c *Client) EnableUser(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/users/"+url.PathEscape(id)+"/enable", nil, struct{}{}, nil)
+}
+
+// DisableUser blocks sign-in and token refresh for a user until EnableUser
+func (c *Client) DisableUser(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/users/"+url.PathEscape(id)+"/disable", nil, struct{}{}, nil)
+}
+
+// LockUser blocks sign-in for a user until the given time
+func (c *Client) LockUser(ctx context.Context, id string, until time.Time) error {
+	body := map[string]time.Time{"locked_until": until}
+	return c.do(ctx, http.MethodPost, "/users/"+url.PathEscape(id)+"/disable", nil, body, nil)
+}
+
+// setIf sets a query parameter when value is not empty
+func setIf(query url.Values, key, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
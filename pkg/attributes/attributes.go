@@ -0,0 +1,161 @@
+// Package attributes fetches live user attributes, such as directory group
+// memberships, from upstream providers at userinfo time.
+package attributes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+const (
+	defaultProviderTimeout = 3 * time.Second
+	defaultCacheTTL        = 5 * time.Minute
+
+	// ProviderTypeREST fetches attributes from an HTTP endpoint returning a JSON object
+	ProviderTypeREST = "rest"
+)
+
+// Provider looks up attributes for a user in an upstream system. Directories such
+// as LDAP or Active Directory are usually exposed through a REST bridge, but can
+// also be supported by implementing this interface directly.
+type Provider interface {
+	Name() string
+	Attributes(ctx context.Context, user *models.User) (map[string]interface{}, error)
+}
+
+// source wraps a provider with the settings that control when it is consulted
+type source struct {
+	provider Provider
+	scope    string          // Only consulted when the access token carries this scope
+	claims   map[string]bool // Claims the provider may contribute; empty = all
+	timeout  time.Duration
+	cacheTTL time.Duration
+}
+
+type cacheEntry struct {
+	attributes map[string]interface{}
+	fetchedAt  time.Time
+}
+
+// Resolver merges attributes from its providers, caching each result and falling
+// back to the last known value while a provider is unavailable
+type Resolver struct {
+	sources []source
+
+	mu    sync.Mutex // Guards cache
+	cache map[string]cacheEntry
+}
+
+// NewResolver builds a resolver from the configured providers
+func NewResolver(configs []configstore.AttributeProviderConfig) (*Resolver, error) {
+	r := &Resolver{cache: make(map[string]cacheEntry)}
+	for _, cfg := range configs {
+		var provider Provider
+		switch cfg.Type {
+		case ProviderTypeREST, "":
+			if cfg.URL == "" {
+				return nil, fmt.Errorf("attribute provider %q: url is required", cfg.Name)
+			}
+			provider = NewRESTProvider(cfg.Name, cfg.URL, cfg.AuthHeader)
+		default:
+			return nil, fmt.Errorf("attribute provider %q: unsupported type %q", cfg.Name, cfg.Type)
+		}
+
+		src := source{
+			provider: provider,
+			scope:    cfg.Scope,
+			timeout:  time.Duration(cfg.TimeoutSeconds) * time.Second,
+			cacheTTL: time.Duration(cfg.CacheTTLSeconds) * time.Second,
+		}
+		if src.timeout <= 0 {
+			src.timeout = defaultProviderTimeout
+		}
+		if src.cacheTTL <= 0 {
+			src.cacheTTL = defaultCacheTTL
+		}
+		if len(cfg.Claims) > 0 {
+			src.claims = make(map[string]bool, len(cfg.Claims))
+			for _, claim := range cfg.Claims {
+				src.claims[claim] = true
+			}
+		}
+		r.sources = append(r.sources, src)
+	}
+	return r, nil
+}
+
+// AddProvider registers a custom provider, consulted for tokens with the given scope
+// (or always, if scope is empty)
+func (r *Resolver) AddProvider(provider Provider, scope string, cacheTTL time.Duration) {
+	if cacheTTL <= 0 {
+		cacheTTL = defaultCacheTTL
+	}
+	r.sources = append(r.sources, source{
+		provider: provider,
+		scope:    scope,
+		timeout:  defaultProviderTimeout,
+		cacheTTL: cacheTTL,
+	})
+}
+
+// Resolve returns the attributes of all providers applicable to the granted scope.
+// Providers are consulted in order; later providers do not override earlier ones.
+// A failing provider contributes its last cached attributes, or nothing.
+func (r *Resolver) Resolve(ctx context.Context, user *models.User, scope string) map[string]interface{} {
+	if r == nil || len(r.sources) == 0 {
+		return nil
+	}
+
+	granted := make(map[string]bool)
+	for _, s := range strings.Fields(scope) {
+		granted[s] = true
+	}
+
+	merged := make(map[string]interface{})
+	for _, src := range r.sources {
+		if src.scope != "" && !granted[src.scope] {
+			continue
+		}
+		for name, value := range r.lookup(ctx, src, user) {
+			if len(src.claims) > 0 && !src.claims[name] {
+				continue
+			}
+			if _, exists := merged[name]; !exists {
+				merged[name] = value
+			}
+		}
+	}
+	return merged
+}
+
+// lookup returns a provider's attributes for user from the cache or the provider
+func (r *Resolver) lookup(ctx context.Context, src source, user *models.User) map[string]interface{} {
+	key := src.provider.Name() + "\x00" + user.ID
+
+	r.mu.Lock()
+	entry, cached := r.cache[key]
+	r.mu.Unlock()
+	if cached && time.Since(entry.fetchedAt) < src.cacheTTL {
+		return entry.attributes
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, src.timeout)
+	defer cancel()
+	attrs, err := src.provider.Attributes(fetchCtx, user)
+	if err != nil {
+		log.Printf("Attribute provider %s failed for user %s: %v", src.provider.Name(), user.ID, err)
+		return entry.attributes // Stale value, or nil if never fetched
+	}
+
+	r.mu.Lock()
+	r.cache[key] = cacheEntry{attributes: attrs, fetchedAt: time.Now()}
+	r.mu.Unlock()
+	return attrs
+}
@@ -0,0 +1,88 @@
+package attributes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestResolverRESTProvider(t *testing.T) {
+	var calls atomic.Int32
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if failing.Load() {
+			http.Error(w, "directory unavailable", http.StatusBadGateway)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer bridge-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"groups":     []string{"engineering", "vpn-users"},
+			"department": "R&D",
+			"sub":        r.URL.Query().Get("sub"),
+		})
+	}))
+	defer server.Close()
+
+	resolver, err := NewResolver([]configstore.AttributeProviderConfig{{
+		Name:       "directory",
+		URL:        server.URL,
+		AuthHeader: "Bearer bridge-token",
+		Scope:      "groups",
+		Claims:     []string{"groups", "department"},
+	}})
+	if err != nil {
+		t.Fatalf("NewResolver failed: %v", err)
+	}
+	user := &models.User{ID: "user-1", Username: "alice"}
+
+	if attrs := resolver.Resolve(context.Background(), user, "openid profile"); len(attrs) != 0 {
+		t.Errorf("Provider should not be consulted without its scope, got %v", attrs)
+	}
+
+	attrs := resolver.Resolve(context.Background(), user, "openid groups")
+	if attrs["department"] != "R&D" {
+		t.Errorf("Expected department from provider, got %v", attrs)
+	}
+	if _, ok := attrs["sub"]; ok {
+		t.Error("Claims outside the provider's allow-list should be dropped")
+	}
+
+	// Cached results are served without calling the provider again
+	resolver.Resolve(context.Background(), user, "openid groups")
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Expected 1 provider call, got %d", got)
+	}
+
+	// Once the cache is stale, a failing provider falls back to the last known value
+	resolver.sources[0].cacheTTL = 0
+	failing.Store(true)
+	attrs = resolver.Resolve(context.Background(), user, "openid groups")
+	if attrs["department"] != "R&D" {
+		t.Errorf("Expected stale attributes while the provider fails, got %v", attrs)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("Expected the provider to be retried, got %d calls", got)
+	}
+
+	other := &models.User{ID: "user-2", Username: "bob"}
+	if attrs := resolver.Resolve(context.Background(), other, "openid groups"); len(attrs) != 0 {
+		t.Errorf("Expected no attributes for an uncached user while the provider fails, got %v", attrs)
+	}
+}
+
+func TestNewResolverRejectsUnknownType(t *testing.T) {
+	_, err := NewResolver([]configstore.AttributeProviderConfig{{Name: "ad", Type: "ldap", URL: "ldap://dc"}})
+	if err == nil {
+		t.Error("Expected an error for an unsupported provider type")
+	}
+}
@@ -0,0 +1,80 @@
+package attributes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/outbound"
+)
+
+const restMaxResponseSize = 1 << 20
+
+// RESTProvider fetches attributes with GET <url>?sub=<id>&username=<username>&email=<email>.
+// The endpoint must respond with a JSON object of claim names to values.
+type RESTProvider struct {
+	name       string
+	url        string
+	authHeader string
+	client     *http.Client
+}
+
+// NewRESTProvider creates a REST attribute provider. authHeader, if set, is sent
+// as the Authorization header.
+func NewRESTProvider(name, endpoint, authHeader string) *RESTProvider {
+	return &RESTProvider{
+		name:       name,
+		url:        endpoint,
+		authHeader: authHeader,
+		client:     outbound.NewClient(outbound.Configured, 0),
+	}
+}
+
+// Name returns the provider name
+func (p *RESTProvider) Name() string {
+	return p.name
+}
+
+// Attributes fetches the user's attributes from the endpoint
+func (p *RESTProvider) Attributes(ctx context.Context, user *models.User) (map[string]interface{}, error) {
+	endpoint, err := url.Parse(p.url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid provider url: %w", err)
+	}
+	query := endpoint.Query()
+	query.Set("sub", user.ID)
+	query.Set("username", user.Username)
+	if user.Email != "" {
+		query.Set("email", user.Email)
+	}
+	endpoint.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if p.authHeader != "" {
+		req.Header.Set("Authorization", p.authHeader)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("provider returned status %d", resp.StatusCode)
+	}
+
+	var attrs map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, restMaxResponseSize)).Decode(&attrs); err != nil {
+		return nil, fmt.Errorf("invalid provider response: %w", err)
+	}
+	return attrs, nil
+}
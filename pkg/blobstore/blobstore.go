@@ -0,0 +1,123 @@
+// Package blobstore keeps uploaded files, such as user avatars, in a pluggable
+// backend. The server ships a local filesystem store and an S3 store; other
+// backends register themselves with Register.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+)
+
+// ErrNotFound is returned by Get for a key that holds no object
+var ErrNotFound = errors.New("blob not found")
+
+// Object is a stored file
+type Object struct {
+	Data        []byte
+	ContentType string
+	ModTime     time.Time
+}
+
+// Store keeps objects under slash-separated keys such as "avatars/ab12.jpg".
+// Keys are limited to letters, digits, ".", "_", "-" and "/", and may not hold
+// "." or ".." segments.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get returns ErrNotFound when nothing is stored under key
+	Get(ctx context.Context, key string) (*Object, error)
+	// Delete succeeds when nothing is stored under key
+	Delete(ctx context.Context, key string) error
+}
+
+// Factory opens a blob store from its configuration
+type Factory func(cfg configstore.BlobStoreConfig) (Store, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Factory)
+)
+
+func init() {
+	Register("filesystem", newFilesystemFromConfig)
+	Register("s3", newS3FromConfig)
+}
+
+// Register makes a blob store available as type name. Backends built outside
+// this package call it from an init function and are compiled in with a blank
+// import. It panics if name is empty or already registered, or factory is nil.
+func Register(name string, factory Factory) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if name == "" {
+		panic("blobstore: Register called with an empty driver name")
+	}
+	if factory == nil {
+		panic("blobstore: Register factory is nil for driver " + name)
+	}
+	if _, dup := drivers[name]; dup {
+		panic("blobstore: Register called twice for driver " + name)
+	}
+	drivers[name] = factory
+}
+
+// Drivers returns the names of the registered blob stores, sorted
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open creates the blob store named by cfg.Type, the filesystem when empty
+func Open(cfg configstore.BlobStoreConfig) (Store, error) {
+	name := cfg.Type
+	if name == "" {
+		name = "filesystem"
+	}
+	driversMu.RLock()
+	factory, ok := drivers[name]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported blob store type %q (available: %s)", name, strings.Join(Drivers(), ", "))
+	}
+	return factory(cfg)
+}
+
+// ValidKey reports whether key may be used with a Store
+func ValidKey(key string) bool {
+	if key == "" || len(key) > 512 {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '.', r == '_', r == '-', r == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func checkKey(key string) error {
+	if !ValidKey(key) {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	return nil
+}
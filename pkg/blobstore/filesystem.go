@@ -0,0 +1,101 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+)
+
+const defaultFilesystemPath = "data/avatars"
+
+// Filesystem keeps objects as files below a directory. The content type is
+// derived from the key's extension, or sniffed from the data when it has none.
+type Filesystem struct {
+	dir string
+}
+
+// NewFilesystem returns a store writing below dir, which is created on the first Put
+func NewFilesystem(dir string) *Filesystem {
+	return &Filesystem{dir: dir}
+}
+
+func newFilesystemFromConfig(cfg configstore.BlobStoreConfig) (Store, error) {
+	dir := cfg.Path
+	if dir == "" {
+		dir = defaultFilesystemPath
+	}
+	return NewFilesystem(dir), nil
+}
+
+func (f *Filesystem) path(key string) string {
+	return filepath.Join(f.dir, filepath.FromSlash(key))
+}
+
+// Put writes the object to a temporary file and renames it into place, so
+// readers never see a partial file
+func (f *Filesystem) Put(_ context.Context, key string, data []byte, _ string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	name := f.path(key)
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// Get reads an object
+func (f *Filesystem) Get(_ context.Context, key string) (*Object, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	name := f.path(key)
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	obj := &Object{Data: data, ContentType: mime.TypeByExtension(path.Ext(key))}
+	if obj.ContentType == "" {
+		obj.ContentType = http.DetectContentType(data)
+	}
+	if info, err := os.Stat(name); err == nil {
+		obj.ModTime = info.ModTime()
+	}
+	return obj, nil
+}
+
+// Delete removes an object
+func (f *Filesystem) Delete(_ context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if err := os.Remove(f.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
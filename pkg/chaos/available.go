@@ -0,0 +1,7 @@
+//go:build !chaos
+
+package chaos
+
+// Available reports whether the binary was built with -tags chaos. Without it,
+// Apply ignores the chaos config.
+const Available = false
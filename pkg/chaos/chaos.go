@@ -0,0 +1,127 @@
+// Package chaos injects latency and errors into storage and outgoing HTTP calls
+// for resilience testing: to check that clients retry sensibly when the server
+// answers slowly or with 5xx errors, and that the server's own timeouts, storage
+// breaker and retries behave when its database or a remote endpoint misbehaves.
+//
+// Faults are configured in the chaos section of the config, but are only
+// injected by binaries built with -tags chaos, so a release build never has them:
+//
+//	go build -tags chaos -o openid-server-chaos .
+package chaos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+// ErrInjected is the error returned by calls chosen to fail
+var ErrInjected = errors.New("chaos: injected fault")
+
+// Apply wraps store, and http.DefaultTransport used by the server's outgoing
+// calls, with the faults in cfg. Without -tags chaos, or with chaos disabled,
+// it changes nothing and returns store.
+func Apply(store storage.Storage, cfg configstore.ChaosConfig) storage.Storage {
+	if !cfg.Enabled {
+		return store
+	}
+	if !Available {
+		log.Printf("Warning: chaos is enabled in the config but this binary was built without -tags chaos; no faults are injected")
+		return store
+	}
+	log.Printf("Warning: injecting faults for resilience testing (storage: %+v, http: %+v)", cfg.Storage, cfg.HTTP)
+	http.DefaultTransport = WrapTransport(http.DefaultTransport, cfg.HTTP)
+	return WrapStorage(store, cfg.Storage)
+}
+
+// injector decides call by call how long to delay and whether to fail
+type injector struct {
+	latency time.Duration
+	jitter  time.Duration
+	rate    float64
+	targets []string
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// newInjector returns nil when cfg injects nothing
+func newInjector(cfg configstore.FaultConfig) *injector {
+	if cfg.LatencyMs <= 0 && cfg.LatencyJitterMs <= 0 && cfg.ErrorRate <= 0 {
+		return nil
+	}
+	return &injector{
+		latency: time.Duration(cfg.LatencyMs) * time.Millisecond,
+		jitter:  time.Duration(cfg.LatencyJitterMs) * time.Millisecond,
+		rate:    cfg.ErrorRate,
+		targets: cfg.Targets,
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())), // #nosec G404 -- fault selection, not secrets
+	}
+}
+
+func (i *injector) matches(target string) bool {
+	if len(i.targets) == 0 {
+		return true
+	}
+	for _, t := range i.targets {
+		if strings.EqualFold(t, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// roll picks the delay for one call and whether it fails
+func (i *injector) roll() (time.Duration, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	d := i.latency
+	if i.jitter > 0 {
+		d += time.Duration(i.rng.Int63n(int64(i.jitter) + 1))
+	}
+	return d, i.rate > 0 && i.rng.Float64() < i.rate
+}
+
+// fault delays a call to target and returns ErrInjected if it should fail
+func (i *injector) fault(target string) error {
+	return i.faultContext(context.Background(), target)
+}
+
+// faultContext is fault for calls with a context; the delay ends early when ctx
+// is done, as a real slow call would when its caller gives up
+func (i *injector) faultContext(ctx context.Context, target string) error {
+	if !i.matches(target) {
+		return nil
+	}
+	d, fail := i.roll()
+	if d > 0 {
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+	if fail {
+		return fmt.Errorf("%w in %s", ErrInjected, target)
+	}
+	return nil
+}
+
+// delay is fault for calls that cannot fail
+func (i *injector) delay(target string) {
+	if i.matches(target) {
+		d, _ := i.roll()
+		time.Sleep(d)
+	}
+}
@@ -0,0 +1,84 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+func TestWrapStorage(t *testing.T) {
+	store, err := storage.NewJSONStorage(filepath.Join(t.TempDir(), "store.json"))
+	require.NoError(t, err)
+	require.NoError(t, store.CreateUser(&models.User{ID: "u1", Username: "alice"}))
+
+	// Nothing to inject leaves the store as it is
+	assert.Same(t, store, WrapStorage(store, configstore.FaultConfig{}))
+
+	// Only the targeted method fails
+	faulty := WrapStorage(store, configstore.FaultConfig{ErrorRate: 1, Targets: []string{"GetUserByID"}})
+	_, err = faulty.GetUserByID("u1")
+	assert.True(t, errors.Is(err, ErrInjected), err)
+	user, err := faulty.GetUserByUsername("alice")
+	require.NoError(t, err)
+	assert.Equal(t, "u1", user.ID)
+
+	// Calls made inside a transaction are affected too
+	err = faulty.RunInTransaction(func(tx storage.Storage) error {
+		_, err := tx.GetUserByID("u1")
+		return err
+	})
+	assert.True(t, errors.Is(err, ErrInjected), err)
+
+	// Latency delays calls that succeed
+	slow := WrapStorage(store, configstore.FaultConfig{LatencyMs: 20})
+	start := time.Now()
+	_, err = slow.GetUserByID("u1")
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+}
+
+func TestWrapTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	get := func(rt http.RoundTripper, timeout time.Duration) (*http.Response, error) {
+		client := &http.Client{Transport: rt, Timeout: timeout}
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	// A connection error by default, or the configured status
+	_, err := get(WrapTransport(http.DefaultTransport, configstore.FaultConfig{ErrorRate: 1}), time.Second)
+	assert.True(t, errors.Is(err, ErrInjected), err)
+	resp, err := get(WrapTransport(http.DefaultTransport, configstore.FaultConfig{ErrorRate: 1, ErrorStatus: http.StatusServiceUnavailable}), time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// Other hosts are left alone
+	resp, err = get(WrapTransport(http.DefaultTransport, configstore.FaultConfig{ErrorRate: 1, Targets: []string{"jwks.example.com"}}), time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// The caller's timeout cuts the injected latency short
+	start := time.Now()
+	_, err = get(WrapTransport(http.DefaultTransport, configstore.FaultConfig{LatencyMs: 5000}), 50*time.Millisecond)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,778 @@
+package chaos
+
+import (
+	"context"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+// faultyStorage delays or fails each storage call before passing it on. Calls
+// that cannot return an error, such as the statistics counts, are only delayed.
+type faultyStorage struct {
+	storage.Storage
+	faults *injector
+}
+
+// pingingStorage keeps the backend's Ping, with faults, so the storage breaker
+// still probes it and can be seen to trip
+type pingingStorage struct {
+	*faultyStorage
+	pinger storage.Pinger
+}
+
+// WrapStorage returns store with the faults in cfg injected into its calls. The
+// targets are storage method names such as "GetClientByID" or "Ping".
+func WrapStorage(store storage.Storage, cfg configstore.FaultConfig) storage.Storage {
+	faults := newInjector(cfg)
+	if faults == nil {
+		return store
+	}
+	s := &faultyStorage{Storage: store, faults: faults}
+	if pinger, ok := store.(storage.Pinger); ok {
+		return &pingingStorage{faultyStorage: s, pinger: pinger}
+	}
+	return s
+}
+
+func (s *pingingStorage) Ping(ctx context.Context) error {
+	if err := s.faults.faultContext(ctx, "Ping"); err != nil {
+		return err
+	}
+	return s.pinger.Ping(ctx)
+}
+
+// RunInTransaction fails the transaction as a whole or runs fn with faults in
+// the calls it makes through tx
+func (s *faultyStorage) RunInTransaction(fn func(tx storage.Storage) error) error {
+	if err := s.faults.fault("RunInTransaction"); err != nil {
+		return err
+	}
+	return s.Storage.RunInTransaction(func(tx storage.Storage) error {
+		return fn(&faultyStorage{Storage: tx, faults: s.faults})
+	})
+}
+
+func (s *faultyStorage) CreateUser(user *models.User) error {
+	if err := s.faults.fault("CreateUser"); err != nil {
+		return err
+	}
+	return s.Storage.CreateUser(user)
+}
+
+func (s *faultyStorage) GetUserByID(id string) (*models.User, error) {
+	if err := s.faults.fault("GetUserByID"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetUserByID(id)
+}
+
+func (s *faultyStorage) GetUserByUsername(username string) (*models.User, error) {
+	if err := s.faults.fault("GetUserByUsername"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetUserByUsername(username)
+}
+
+func (s *faultyStorage) GetUserByEmail(email string) (*models.User, error) {
+	if err := s.faults.fault("GetUserByEmail"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetUserByEmail(email)
+}
+
+func (s *faultyStorage) ResolveUserByLoginIdentifier(identifier string, fields []string) (*models.User, error) {
+	if err := s.faults.fault("ResolveUserByLoginIdentifier"); err != nil {
+		return nil, err
+	}
+	return s.Storage.ResolveUserByLoginIdentifier(identifier, fields)
+}
+
+func (s *faultyStorage) GetAllUsers() ([]*models.User, error) {
+	if err := s.faults.fault("GetAllUsers"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetAllUsers()
+}
+
+func (s *faultyStorage) UpdateUser(user *models.User) error {
+	if err := s.faults.fault("UpdateUser"); err != nil {
+		return err
+	}
+	return s.Storage.UpdateUser(user)
+}
+
+func (s *faultyStorage) UpdateUserIfVersion(user *models.User, version int) (bool, error) {
+	if err := s.faults.fault("UpdateUserIfVersion"); err != nil {
+		return false, err
+	}
+	return s.Storage.UpdateUserIfVersion(user, version)
+}
+
+func (s *faultyStorage) UpdatePasswordHash(id, oldHash, newHash string) (bool, error) {
+	if err := s.faults.fault("UpdatePasswordHash"); err != nil {
+		return false, err
+	}
+	return s.Storage.UpdatePasswordHash(id, oldHash, newHash)
+}
+
+func (s *faultyStorage) DeleteUser(id string) error {
+	if err := s.faults.fault("DeleteUser"); err != nil {
+		return err
+	}
+	return s.Storage.DeleteUser(id)
+}
+
+func (s *faultyStorage) CreateClient(client *models.Client) error {
+	if err := s.faults.fault("CreateClient"); err != nil {
+		return err
+	}
+	return s.Storage.CreateClient(client)
+}
+
+func (s *faultyStorage) GetClientByID(id string) (*models.Client, error) {
+	if err := s.faults.fault("GetClientByID"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetClientByID(id)
+}
+
+func (s *faultyStorage) GetAllClients() ([]*models.Client, error) {
+	if err := s.faults.fault("GetAllClients"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetAllClients()
+}
+
+func (s *faultyStorage) UpdateClient(client *models.Client) error {
+	if err := s.faults.fault("UpdateClient"); err != nil {
+		return err
+	}
+	return s.Storage.UpdateClient(client)
+}
+
+func (s *faultyStorage) UpdateClientIfVersion(client *models.Client, version int) (bool, error) {
+	if err := s.faults.fault("UpdateClientIfVersion"); err != nil {
+		return false, err
+	}
+	return s.Storage.UpdateClientIfVersion(client, version)
+}
+
+func (s *faultyStorage) DeleteClient(id string) error {
+	if err := s.faults.fault("DeleteClient"); err != nil {
+		return err
+	}
+	return s.Storage.DeleteClient(id)
+}
+
+func (s *faultyStorage) ValidateClient(clientID, clientSecret string) (*models.Client, error) {
+	if err := s.faults.fault("ValidateClient"); err != nil {
+		return nil, err
+	}
+	return s.Storage.ValidateClient(clientID, clientSecret)
+}
+
+func (s *faultyStorage) TouchClient(id string, usedAt time.Time) error {
+	if err := s.faults.fault("TouchClient"); err != nil {
+		return err
+	}
+	return s.Storage.TouchClient(id, usedAt)
+}
+
+func (s *faultyStorage) CreateAuthorizationCode(code *models.AuthorizationCode) error {
+	if err := s.faults.fault("CreateAuthorizationCode"); err != nil {
+		return err
+	}
+	return s.Storage.CreateAuthorizationCode(code)
+}
+
+func (s *faultyStorage) GetAuthorizationCode(code string) (*models.AuthorizationCode, error) {
+	if err := s.faults.fault("GetAuthorizationCode"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetAuthorizationCode(code)
+}
+
+func (s *faultyStorage) UpdateAuthorizationCode(code *models.AuthorizationCode) error {
+	if err := s.faults.fault("UpdateAuthorizationCode"); err != nil {
+		return err
+	}
+	return s.Storage.UpdateAuthorizationCode(code)
+}
+
+func (s *faultyStorage) MarkAuthorizationCodeUsed(code string, usedAt time.Time) (bool, error) {
+	if err := s.faults.fault("MarkAuthorizationCodeUsed"); err != nil {
+		return false, err
+	}
+	return s.Storage.MarkAuthorizationCodeUsed(code, usedAt)
+}
+
+func (s *faultyStorage) DeleteAuthorizationCode(code string) error {
+	if err := s.faults.fault("DeleteAuthorizationCode"); err != nil {
+		return err
+	}
+	return s.Storage.DeleteAuthorizationCode(code)
+}
+
+func (s *faultyStorage) CreateToken(token *models.Token) error {
+	if err := s.faults.fault("CreateToken"); err != nil {
+		return err
+	}
+	return s.Storage.CreateToken(token)
+}
+
+func (s *faultyStorage) GetTokenByAccessToken(accessToken string) (*models.Token, error) {
+	if err := s.faults.fault("GetTokenByAccessToken"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetTokenByAccessToken(accessToken)
+}
+
+func (s *faultyStorage) GetTokenByRefreshToken(refreshToken string) (*models.Token, error) {
+	if err := s.faults.fault("GetTokenByRefreshToken"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetTokenByRefreshToken(refreshToken)
+}
+
+func (s *faultyStorage) GetTokensByAuthCode(authCodeID string) ([]*models.Token, error) {
+	if err := s.faults.fault("GetTokensByAuthCode"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetTokensByAuthCode(authCodeID)
+}
+
+func (s *faultyStorage) DeleteToken(accessToken string) error {
+	if err := s.faults.fault("DeleteToken"); err != nil {
+		return err
+	}
+	return s.Storage.DeleteToken(accessToken)
+}
+
+func (s *faultyStorage) RevokeTokensByAuthCode(authCodeID string) error {
+	if err := s.faults.fault("RevokeTokensByAuthCode"); err != nil {
+		return err
+	}
+	return s.Storage.RevokeTokensByAuthCode(authCodeID)
+}
+
+func (s *faultyStorage) RevokeTokensBySession(sessionID string) error {
+	if err := s.faults.fault("RevokeTokensBySession"); err != nil {
+		return err
+	}
+	return s.Storage.RevokeTokensBySession(sessionID)
+}
+
+func (s *faultyStorage) ListTokens(clientID, userID string, activeOnly bool) ([]*models.Token, error) {
+	if err := s.faults.fault("ListTokens"); err != nil {
+		return nil, err
+	}
+	return s.Storage.ListTokens(clientID, userID, activeOnly)
+}
+
+func (s *faultyStorage) MarkTokenReplaced(id, replacementID string, replacedAt, expiresAt time.Time) (bool, error) {
+	if err := s.faults.fault("MarkTokenReplaced"); err != nil {
+		return false, err
+	}
+	return s.Storage.MarkTokenReplaced(id, replacementID, replacedAt, expiresAt)
+}
+
+func (s *faultyStorage) CreateSession(session *models.Session) error {
+	if err := s.faults.fault("CreateSession"); err != nil {
+		return err
+	}
+	return s.Storage.CreateSession(session)
+}
+
+func (s *faultyStorage) GetSession(id string) (*models.Session, error) {
+	if err := s.faults.fault("GetSession"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetSession(id)
+}
+
+func (s *faultyStorage) DeleteSession(id string) error {
+	if err := s.faults.fault("DeleteSession"); err != nil {
+		return err
+	}
+	return s.Storage.DeleteSession(id)
+}
+
+func (s *faultyStorage) CreateAuthSession(session *models.AuthSession) error {
+	if err := s.faults.fault("CreateAuthSession"); err != nil {
+		return err
+	}
+	return s.Storage.CreateAuthSession(session)
+}
+
+func (s *faultyStorage) GetAuthSession(id string) (*models.AuthSession, error) {
+	if err := s.faults.fault("GetAuthSession"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetAuthSession(id)
+}
+
+func (s *faultyStorage) UpdateAuthSession(session *models.AuthSession) error {
+	if err := s.faults.fault("UpdateAuthSession"); err != nil {
+		return err
+	}
+	return s.Storage.UpdateAuthSession(session)
+}
+
+func (s *faultyStorage) DeleteAuthSession(id string) error {
+	if err := s.faults.fault("DeleteAuthSession"); err != nil {
+		return err
+	}
+	return s.Storage.DeleteAuthSession(id)
+}
+
+func (s *faultyStorage) GetAuthSessionsByOwner(owner string) ([]*models.AuthSession, error) {
+	if err := s.faults.fault("GetAuthSessionsByOwner"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetAuthSessionsByOwner(owner)
+}
+
+func (s *faultyStorage) CleanupExpiredAuthSessions() (int, error) {
+	if err := s.faults.fault("CleanupExpiredAuthSessions"); err != nil {
+		return 0, err
+	}
+	return s.Storage.CleanupExpiredAuthSessions()
+}
+
+func (s *faultyStorage) CreateUserSession(session *models.UserSession) error {
+	if err := s.faults.fault("CreateUserSession"); err != nil {
+		return err
+	}
+	return s.Storage.CreateUserSession(session)
+}
+
+func (s *faultyStorage) GetUserSession(id string) (*models.UserSession, error) {
+	if err := s.faults.fault("GetUserSession"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetUserSession(id)
+}
+
+func (s *faultyStorage) GetUserSessionByUserID(userID string) (*models.UserSession, error) {
+	if err := s.faults.fault("GetUserSessionByUserID"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetUserSessionByUserID(userID)
+}
+
+func (s *faultyStorage) GetUserSessionsByUserID(userID string) ([]*models.UserSession, error) {
+	if err := s.faults.fault("GetUserSessionsByUserID"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetUserSessionsByUserID(userID)
+}
+
+func (s *faultyStorage) UpdateUserSession(session *models.UserSession) error {
+	if err := s.faults.fault("UpdateUserSession"); err != nil {
+		return err
+	}
+	return s.Storage.UpdateUserSession(session)
+}
+
+func (s *faultyStorage) DeleteUserSession(id string) error {
+	if err := s.faults.fault("DeleteUserSession"); err != nil {
+		return err
+	}
+	return s.Storage.DeleteUserSession(id)
+}
+
+func (s *faultyStorage) CleanupExpiredSessions() error {
+	if err := s.faults.fault("CleanupExpiredSessions"); err != nil {
+		return err
+	}
+	return s.Storage.CleanupExpiredSessions()
+}
+
+func (s *faultyStorage) CreateConsent(consent *models.Consent) error {
+	if err := s.faults.fault("CreateConsent"); err != nil {
+		return err
+	}
+	return s.Storage.CreateConsent(consent)
+}
+
+func (s *faultyStorage) GetConsent(userID, clientID string) (*models.Consent, error) {
+	if err := s.faults.fault("GetConsent"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetConsent(userID, clientID)
+}
+
+func (s *faultyStorage) UpdateConsent(consent *models.Consent) error {
+	if err := s.faults.fault("UpdateConsent"); err != nil {
+		return err
+	}
+	return s.Storage.UpdateConsent(consent)
+}
+
+func (s *faultyStorage) DeleteConsent(userID, clientID string) error {
+	if err := s.faults.fault("DeleteConsent"); err != nil {
+		return err
+	}
+	return s.Storage.DeleteConsent(userID, clientID)
+}
+
+func (s *faultyStorage) DeleteConsentsForUser(userID string) error {
+	if err := s.faults.fault("DeleteConsentsForUser"); err != nil {
+		return err
+	}
+	return s.Storage.DeleteConsentsForUser(userID)
+}
+
+func (s *faultyStorage) GetConsentsByUserID(userID string) ([]*models.Consent, error) {
+	if err := s.faults.fault("GetConsentsByUserID"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetConsentsByUserID(userID)
+}
+
+func (s *faultyStorage) CreateConsentReceipt(receipt *models.ConsentReceipt) error {
+	if err := s.faults.fault("CreateConsentReceipt"); err != nil {
+		return err
+	}
+	return s.Storage.CreateConsentReceipt(receipt)
+}
+
+func (s *faultyStorage) ListConsentReceipts(userID, clientID string) ([]*models.ConsentReceipt, error) {
+	if err := s.faults.fault("ListConsentReceipts"); err != nil {
+		return nil, err
+	}
+	return s.Storage.ListConsentReceipts(userID, clientID)
+}
+
+func (s *faultyStorage) DeleteConsentReceiptsForUser(userID string) error {
+	if err := s.faults.fault("DeleteConsentReceiptsForUser"); err != nil {
+		return err
+	}
+	return s.Storage.DeleteConsentReceiptsForUser(userID)
+}
+
+func (s *faultyStorage) CreateInitialAccessToken(token *models.InitialAccessToken) error {
+	if err := s.faults.fault("CreateInitialAccessToken"); err != nil {
+		return err
+	}
+	return s.Storage.CreateInitialAccessToken(token)
+}
+
+func (s *faultyStorage) GetInitialAccessToken(token string) (*models.InitialAccessToken, error) {
+	if err := s.faults.fault("GetInitialAccessToken"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetInitialAccessToken(token)
+}
+
+func (s *faultyStorage) UpdateInitialAccessToken(token *models.InitialAccessToken) error {
+	if err := s.faults.fault("UpdateInitialAccessToken"); err != nil {
+		return err
+	}
+	return s.Storage.UpdateInitialAccessToken(token)
+}
+
+func (s *faultyStorage) UseInitialAccessToken(token, clientID string, maxUses int, usedAt time.Time) (bool, error) {
+	if err := s.faults.fault("UseInitialAccessToken"); err != nil {
+		return false, err
+	}
+	return s.Storage.UseInitialAccessToken(token, clientID, maxUses, usedAt)
+}
+
+func (s *faultyStorage) DeleteInitialAccessToken(token string) error {
+	if err := s.faults.fault("DeleteInitialAccessToken"); err != nil {
+		return err
+	}
+	return s.Storage.DeleteInitialAccessToken(token)
+}
+
+func (s *faultyStorage) GetAllInitialAccessTokens() ([]*models.InitialAccessToken, error) {
+	if err := s.faults.fault("GetAllInitialAccessTokens"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetAllInitialAccessTokens()
+}
+
+func (s *faultyStorage) CreateAPIKey(key *models.APIKey) error {
+	if err := s.faults.fault("CreateAPIKey"); err != nil {
+		return err
+	}
+	return s.Storage.CreateAPIKey(key)
+}
+
+func (s *faultyStorage) GetAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	if err := s.faults.fault("GetAPIKeyByHash"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetAPIKeyByHash(keyHash)
+}
+
+func (s *faultyStorage) ListAPIKeys(userID string) ([]*models.APIKey, error) {
+	if err := s.faults.fault("ListAPIKeys"); err != nil {
+		return nil, err
+	}
+	return s.Storage.ListAPIKeys(userID)
+}
+
+func (s *faultyStorage) UpdateAPIKey(key *models.APIKey) error {
+	if err := s.faults.fault("UpdateAPIKey"); err != nil {
+		return err
+	}
+	return s.Storage.UpdateAPIKey(key)
+}
+
+func (s *faultyStorage) DeleteAPIKey(id string) error {
+	if err := s.faults.fault("DeleteAPIKey"); err != nil {
+		return err
+	}
+	return s.Storage.DeleteAPIKey(id)
+}
+
+func (s *faultyStorage) DeleteAPIKeysForUser(userID string) error {
+	if err := s.faults.fault("DeleteAPIKeysForUser"); err != nil {
+		return err
+	}
+	return s.Storage.DeleteAPIKeysForUser(userID)
+}
+
+func (s *faultyStorage) CreateClientInstance(instance *models.ClientInstance) error {
+	if err := s.faults.fault("CreateClientInstance"); err != nil {
+		return err
+	}
+	return s.Storage.CreateClientInstance(instance)
+}
+
+func (s *faultyStorage) GetClientInstance(id string) (*models.ClientInstance, error) {
+	if err := s.faults.fault("GetClientInstance"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetClientInstance(id)
+}
+
+func (s *faultyStorage) ListClientInstances(clientID string) ([]*models.ClientInstance, error) {
+	if err := s.faults.fault("ListClientInstances"); err != nil {
+		return nil, err
+	}
+	return s.Storage.ListClientInstances(clientID)
+}
+
+func (s *faultyStorage) UpdateClientInstance(instance *models.ClientInstance) error {
+	if err := s.faults.fault("UpdateClientInstance"); err != nil {
+		return err
+	}
+	return s.Storage.UpdateClientInstance(instance)
+}
+
+func (s *faultyStorage) DeleteClientInstance(id string) error {
+	if err := s.faults.fault("DeleteClientInstance"); err != nil {
+		return err
+	}
+	return s.Storage.DeleteClientInstance(id)
+}
+
+func (s *faultyStorage) CreateDeviceAuthorization(auth *models.DeviceAuthorization) error {
+	if err := s.faults.fault("CreateDeviceAuthorization"); err != nil {
+		return err
+	}
+	return s.Storage.CreateDeviceAuthorization(auth)
+}
+
+func (s *faultyStorage) GetDeviceAuthorization(deviceCode string) (*models.DeviceAuthorization, error) {
+	if err := s.faults.fault("GetDeviceAuthorization"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetDeviceAuthorization(deviceCode)
+}
+
+func (s *faultyStorage) GetDeviceAuthorizationByUserCode(userCode string) (*models.DeviceAuthorization, error) {
+	if err := s.faults.fault("GetDeviceAuthorizationByUserCode"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetDeviceAuthorizationByUserCode(userCode)
+}
+
+func (s *faultyStorage) UpdateDeviceAuthorization(auth *models.DeviceAuthorization) error {
+	if err := s.faults.fault("UpdateDeviceAuthorization"); err != nil {
+		return err
+	}
+	return s.Storage.UpdateDeviceAuthorization(auth)
+}
+
+func (s *faultyStorage) DeleteDeviceAuthorization(deviceCode string) error {
+	if err := s.faults.fault("DeleteDeviceAuthorization"); err != nil {
+		return err
+	}
+	return s.Storage.DeleteDeviceAuthorization(deviceCode)
+}
+
+func (s *faultyStorage) CreateErrorReport(report *models.ErrorReport) error {
+	if err := s.faults.fault("CreateErrorReport"); err != nil {
+		return err
+	}
+	return s.Storage.CreateErrorReport(report)
+}
+
+func (s *faultyStorage) GetErrorReport(reference string) (*models.ErrorReport, error) {
+	if err := s.faults.fault("GetErrorReport"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetErrorReport(reference)
+}
+
+func (s *faultyStorage) CreateSigningKey(key *models.SigningKey) error {
+	if err := s.faults.fault("CreateSigningKey"); err != nil {
+		return err
+	}
+	return s.Storage.CreateSigningKey(key)
+}
+
+func (s *faultyStorage) GetSigningKey(id string) (*models.SigningKey, error) {
+	if err := s.faults.fault("GetSigningKey"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetSigningKey(id)
+}
+
+func (s *faultyStorage) GetSigningKeyByKID(kid string) (*models.SigningKey, error) {
+	if err := s.faults.fault("GetSigningKeyByKID"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetSigningKeyByKID(kid)
+}
+
+func (s *faultyStorage) GetAllSigningKeys() ([]*models.SigningKey, error) {
+	if err := s.faults.fault("GetAllSigningKeys"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetAllSigningKeys()
+}
+
+func (s *faultyStorage) GetActiveSigningKey() (*models.SigningKey, error) {
+	if err := s.faults.fault("GetActiveSigningKey"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetActiveSigningKey()
+}
+
+func (s *faultyStorage) UpdateSigningKey(key *models.SigningKey) error {
+	if err := s.faults.fault("UpdateSigningKey"); err != nil {
+		return err
+	}
+	return s.Storage.UpdateSigningKey(key)
+}
+
+func (s *faultyStorage) DeleteSigningKey(id string) error {
+	if err := s.faults.fault("DeleteSigningKey"); err != nil {
+		return err
+	}
+	return s.Storage.DeleteSigningKey(id)
+}
+
+func (s *faultyStorage) RecordJTI(clientID, jti string, expiresAt time.Time) (bool, error) {
+	if err := s.faults.fault("RecordJTI"); err != nil {
+		return false, err
+	}
+	return s.Storage.RecordJTI(clientID, jti, expiresAt)
+}
+
+func (s *faultyStorage) GetActiveTokensCount() int {
+	s.faults.delay("GetActiveTokensCount")
+	return s.Storage.GetActiveTokensCount()
+}
+
+func (s *faultyStorage) GetRecentUserSessionsCount() int {
+	s.faults.delay("GetRecentUserSessionsCount")
+	return s.Storage.GetRecentUserSessionsCount()
+}
+
+func (s *faultyStorage) CreateAuditLog(entry *models.AuditLog) error {
+	if err := s.faults.fault("CreateAuditLog"); err != nil {
+		return err
+	}
+	return s.Storage.CreateAuditLog(entry)
+}
+
+func (s *faultyStorage) GetAuditLogs(filter models.AuditFilter) ([]*models.AuditLog, error) {
+	if err := s.faults.fault("GetAuditLogs"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetAuditLogs(filter)
+}
+
+func (s *faultyStorage) GetAuditLogsCount(filter models.AuditFilter) int {
+	s.faults.delay("GetAuditLogsCount")
+	return s.Storage.GetAuditLogsCount(filter)
+}
+
+func (s *faultyStorage) GetAuditChain(afterSeq int64, limit int) ([]*models.AuditLog, error) {
+	if err := s.faults.fault("GetAuditChain"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetAuditChain(afterSeq, limit)
+}
+
+func (s *faultyStorage) GetAuditChainHead() (*models.AuditLog, error) {
+	if err := s.faults.fault("GetAuditChainHead"); err != nil {
+		return nil, err
+	}
+	return s.Storage.GetAuditChainHead()
+}
+
+func (s *faultyStorage) CreateAuditCheckpoint(checkpoint *models.AuditCheckpoint) error {
+	if err := s.faults.fault("CreateAuditCheckpoint"); err != nil {
+		return err
+	}
+	return s.Storage.CreateAuditCheckpoint(checkpoint)
+}
+
+func (s *faultyStorage) ListAuditCheckpoints() ([]*models.AuditCheckpoint, error) {
+	if err := s.faults.fault("ListAuditCheckpoints"); err != nil {
+		return nil, err
+	}
+	return s.Storage.ListAuditCheckpoints()
+}
+
+func (s *faultyStorage) AnonymizeAuditLogs(subjects []string, pseudonym string) ([]models.AuditRedaction, error) {
+	if err := s.faults.fault("AnonymizeAuditLogs"); err != nil {
+		return nil, err
+	}
+	return s.Storage.AnonymizeAuditLogs(subjects, pseudonym)
+}
+
+func (s *faultyStorage) DeleteAuditLogsBefore(cutoff time.Time) (int, error) {
+	if err := s.faults.fault("DeleteAuditLogsBefore"); err != nil {
+		return 0, err
+	}
+	return s.Storage.DeleteAuditLogsBefore(cutoff)
+}
+
+func (s *faultyStorage) DeleteTokensExpiredBefore(cutoff time.Time) (int, error) {
+	if err := s.faults.fault("DeleteTokensExpiredBefore"); err != nil {
+		return 0, err
+	}
+	return s.Storage.DeleteTokensExpiredBefore(cutoff)
+}
+
+func (s *faultyStorage) DeleteAuthorizationCodesExpiredBefore(cutoff time.Time) (int, error) {
+	if err := s.faults.fault("DeleteAuthorizationCodesExpiredBefore"); err != nil {
+		return 0, err
+	}
+	return s.Storage.DeleteAuthorizationCodesExpiredBefore(cutoff)
+}
+
+func (s *faultyStorage) DeleteUserSessionsIdleSince(cutoff time.Time) (int, error) {
+	if err := s.faults.fault("DeleteUserSessionsIdleSince"); err != nil {
+		return 0, err
+	}
+	return s.Storage.DeleteUserSessionsIdleSince(cutoff)
+}
+
+func (s *faultyStorage) DeleteAuthSessionsCreatedBefore(cutoff time.Time) (int, error) {
+	if err := s.faults.fault("DeleteAuthSessionsCreatedBefore"); err != nil {
+		return 0, err
+	}
+	return s.Storage.DeleteAuthSessionsCreatedBefore(cutoff)
+}
@@ -0,0 +1,50 @@
+package chaos
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+)
+
+// transport injects faults into outgoing HTTP requests
+type transport struct {
+	next   http.RoundTripper
+	faults *injector
+	status int
+}
+
+// WrapTransport returns next with the faults in cfg injected into its requests.
+// The targets are host names. Failed requests end with a connection error, or
+// with an empty cfg.ErrorStatus response when that is set.
+func WrapTransport(next http.RoundTripper, cfg configstore.FaultConfig) http.RoundTripper {
+	faults := newInjector(cfg)
+	if faults == nil {
+		return next
+	}
+	return &transport{next: next, faults: faults, status: cfg.ErrorStatus}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.faults.faultContext(req.Context(), req.URL.Hostname()); err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		if t.status == 0 || req.Context().Err() != nil {
+			return nil, err
+		}
+		return &http.Response{
+			Status:     fmt.Sprintf("%d %s", t.status, http.StatusText(t.status)),
+			StatusCode: t.status,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader("")),
+			Request:    req,
+		}, nil
+	}
+	return t.next.RoundTrip(req)
+}
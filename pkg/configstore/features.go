@@ -0,0 +1,84 @@
+package configstore
+
+import (
+	"context"
+	"maps"
+	"sync"
+)
+
+// Feature flags gating experimental endpoints
+const (
+	FeatureDeviceFlow = "device_flow" // RFC 8628 Device Authorization Grant
+)
+
+// FeatureFlag describes an experimental feature that can be toggled at runtime
+type FeatureFlag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+}
+
+// knownFeatureFlags lists the flags recognised by the server. All are disabled by default.
+var knownFeatureFlags = []FeatureFlag{
+	{Name: FeatureDeviceFlow, Description: "OAuth 2.0 Device Authorization Grant (RFC 8628)"},
+}
+
+// featureMu guards FeatureFlags, which is read on every request and written by the admin API
+var featureMu sync.RWMutex
+
+// IsKnownFeature reports whether name is a recognised feature flag
+func IsKnownFeature(name string) bool {
+	for _, f := range knownFeatureFlags {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// FeatureEnabled reports whether the named feature flag is switched on
+func (c *ConfigData) FeatureEnabled(name string) bool {
+	featureMu.RLock()
+	defer featureMu.RUnlock()
+	return c.FeatureFlags[name]
+}
+
+// SetFeature switches the named feature flag on or off
+func (c *ConfigData) SetFeature(name string, enabled bool) {
+	featureMu.Lock()
+	defer featureMu.Unlock()
+	if c.FeatureFlags == nil {
+		c.FeatureFlags = make(map[string]bool)
+	}
+	c.FeatureFlags[name] = enabled
+}
+
+// SetFeatures replaces all feature flags; flags missing from flags are switched off
+func (c *ConfigData) SetFeatures(flags map[string]bool) {
+	featureMu.Lock()
+	defer featureMu.Unlock()
+	c.FeatureFlags = maps.Clone(flags)
+}
+
+// SaveFeature switches the named feature flag on or off in the stored config, so
+// the toggle survives restarts and reaches other instances when they reload
+func SaveFeature(ctx context.Context, store ConfigStore, name string, enabled bool) error {
+	config, err := store.GetConfig(ctx)
+	if err != nil {
+		return err
+	}
+	config.SetFeature(name, enabled)
+	return store.SaveConfig(ctx, config)
+}
+
+// Features returns all known feature flags with their current state
+func (c *ConfigData) Features() []FeatureFlag {
+	featureMu.RLock()
+	defer featureMu.RUnlock()
+	flags := make([]FeatureFlag, len(knownFeatureFlags))
+	for i, f := range knownFeatureFlags {
+		f.Enabled = c.FeatureFlags[f.Name]
+		flags[i] = f
+	}
+	return flags
+}
@@ -0,0 +1,57 @@
+package configstore
+
+import (
+	"sort"
+	"strings"
+)
+
+// GrantsConfig turns off grant and response types for every client. Disabling
+// the implicit grant also disables every response type other than "code",
+// which covers the implicit and hybrid flows.
+type GrantsConfig struct {
+	OAuth21               bool     `json:"oauth21" bson:"oauth21"`                                                     // Disable the implicit, hybrid and password flows
+	DisabledGrantTypes    []string `json:"disabled_grant_types,omitempty" bson:"disabled_grant_types,omitempty"`       // e.g. "password", "implicit", "client_credentials"
+	DisabledResponseTypes []string `json:"disabled_response_types,omitempty" bson:"disabled_response_types,omitempty"` // e.g. "code id_token"; word order doesn't matter
+}
+
+// oauth21DisabledGrantTypes are the grant types OAuth 2.1 drops
+var oauth21DisabledGrantTypes = []string{"implicit", "password"}
+
+// GrantTypeEnabled reports whether clients may use grantType
+func (g GrantsConfig) GrantTypeEnabled(grantType string) bool {
+	if g.OAuth21 && containsString(oauth21DisabledGrantTypes, grantType) {
+		return false
+	}
+	return !containsString(g.DisabledGrantTypes, grantType)
+}
+
+// ResponseTypeEnabled reports whether clients may request responseType at the
+// authorization endpoint
+func (g GrantsConfig) ResponseTypeEnabled(responseType string) bool {
+	responseType = normalizeResponseType(responseType)
+	if responseType != "code" && !g.GrantTypeEnabled("implicit") {
+		return false
+	}
+	for _, disabled := range g.DisabledResponseTypes {
+		if normalizeResponseType(disabled) == responseType {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeResponseType sorts the words of a response type, which are unordered
+func normalizeResponseType(responseType string) string {
+	words := strings.Fields(responseType)
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
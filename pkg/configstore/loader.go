@@ -12,6 +12,11 @@ type LoaderConfig struct {
 	MongoURIEnv      string
 	MongoDatabaseEnv string
 
+	// Read-only config mounted into the container (e.g. a Kubernetes ConfigMap)
+	// and an optional directory of secret files overlaid on it
+	ConfigFileEnv string
+	SecretsDirEnv string
+
 	// JSON file path
 	JSONFilePath string
 
@@ -26,6 +31,8 @@ func DefaultLoaderConfig() LoaderConfig {
 	return LoaderConfig{
 		MongoURIEnv:      "MONGODB_URI",
 		MongoDatabaseEnv: "MONGODB_DATABASE",
+		ConfigFileEnv:    "OPENID_CONFIG_FILE",
+		SecretsDirEnv:    "OPENID_SECRETS_DIR",
 		JSONFilePath:     "data/config.json",
 		DefaultHost:      "0.0.0.0",
 		DefaultPort:      8080,
@@ -33,8 +40,18 @@ func DefaultLoaderConfig() LoaderConfig {
 }
 
 // AutoLoadConfigStore automatically detects and loads the appropriate config store
-// Priority: 1) MongoDB (from env), 2) JSON file, 3) New JSON file
+// Priority: 1) Mounted config file (from env), 2) MongoDB (from env), 3) JSON file, 4) New JSON file
 func AutoLoadConfigStore(ctx context.Context, cfg LoaderConfig) (ConfigStore, bool, error) {
+	// Step 0: Check for a config file mounted from a ConfigMap
+	if configFile := os.Getenv(cfg.ConfigFileEnv); configFile != "" {
+		store := NewMountedConfigStore(configFile, os.Getenv(cfg.SecretsDirEnv))
+		initialized, err := store.IsInitialized(ctx)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to load mounted config: %w", err)
+		}
+		return store, initialized, nil
+	}
+
 	// Step 1: Check for MongoDB configuration in environment
 	mongoURI := os.Getenv(cfg.MongoURIEnv)
 	if mongoURI != "" {
@@ -92,11 +109,7 @@ func InitializeMinimalConfig(ctx context.Context, store ConfigStore, issuer stri
 	// Detect storage backend based on config store type
 	// If using MongoDB config store, also use MongoDB for data storage
 	if mongoStore, ok := store.(*MongoConfigStore); ok {
-		config.Storage.Type = "mongodb"
-		config.Storage.MongoURI = mongoStore.mongoURI
-		config.Storage.MongoDatabase = mongoStore.database
-		// Clear JSON file path when using MongoDB
-		config.Storage.JSONFilePath = ""
+		config.Storage = mongoStore.StorageBackend()
 	}
 	// Otherwise, DefaultConfig already sets it to JSON
 
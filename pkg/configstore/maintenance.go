@@ -0,0 +1,59 @@
+package configstore
+
+import (
+	"context"
+	"sync"
+)
+
+// MaintenanceConfig controls maintenance mode, during which /authorize and /token
+// answer 503 while discovery and JWKS stay available, and connection draining on shutdown
+type MaintenanceConfig struct {
+	Enabled           bool     `json:"enabled" bson:"enabled"`
+	RetryAfterSeconds int      `json:"retry_after_seconds" bson:"retry_after_seconds"`           // Retry-After sent with 503s (default: 300)
+	ExemptClients     []string `json:"exempt_clients,omitempty" bson:"exempt_clients,omitempty"` // Client IDs still served during maintenance
+	DrainSeconds      int      `json:"drain_seconds,omitempty" bson:"drain_seconds,omitempty"`   // Time to report not-ready before shutting down (default: 5)
+}
+
+// maintenanceMu guards Maintenance, which is read on every request and written by the admin API
+var maintenanceMu sync.RWMutex
+
+// MaintenanceState returns a copy of the current maintenance settings
+func (c *ConfigData) MaintenanceState() MaintenanceConfig {
+	maintenanceMu.RLock()
+	defer maintenanceMu.RUnlock()
+	m := c.Maintenance
+	m.ExemptClients = append([]string(nil), c.Maintenance.ExemptClients...)
+	return m
+}
+
+// SetMaintenance replaces the maintenance settings
+func (c *ConfigData) SetMaintenance(m MaintenanceConfig) {
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+	c.Maintenance = m
+}
+
+// SaveMaintenance replaces the maintenance settings in the stored config, so the
+// change survives restarts and reloads and reaches other instances
+func SaveMaintenance(ctx context.Context, store ConfigStore, m MaintenanceConfig) error {
+	config, err := store.GetConfig(ctx)
+	if err != nil {
+		return err
+	}
+	config.SetMaintenance(m)
+	return store.SaveConfig(ctx, config)
+}
+
+// Exempt reports whether clientID may keep using /authorize and /token
+// while maintenance mode is on
+func (m MaintenanceConfig) Exempt(clientID string) bool {
+	if clientID == "" {
+		return false
+	}
+	for _, id := range m.ExemptClients {
+		if id == clientID {
+			return true
+		}
+	}
+	return false
+}
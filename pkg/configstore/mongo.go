@@ -44,6 +44,15 @@ func NewMongoConfigStore(mongoURI, database string) (*MongoConfigStore, error) {
 	}, nil
 }
 
+// StorageBackend returns a storage configuration that keeps data in the config database
+func (s *MongoConfigStore) StorageBackend() StorageBackendConfig {
+	return StorageBackendConfig{
+		Type:          "mongodb",
+		MongoURI:      s.mongoURI,
+		MongoDatabase: s.database,
+	}
+}
+
 // Initialize ensures the config collection exists
 func (s *MongoConfigStore) Initialize(ctx context.Context) error {
 	// MongoDB creates collections automatically, nothing to do
@@ -166,6 +175,170 @@ func (s *MongoConfigStore) UpdateConfig(ctx context.Context, updates map[string]
 			if v, ok := value.(bool); ok {
 				config.JWT.RefreshEnabled = v
 			}
+		case "jwt.token_length":
+			if v, ok := value.(float64); ok {
+				config.JWT.TokenLength = int(v)
+			} else if v, ok := value.(int); ok {
+				config.JWT.TokenLength = v
+			}
+		case "jwt.encryption_key_rotation_days":
+			if v, ok := value.(float64); ok {
+				config.JWT.EncryptionKeyRotationDays = int(v)
+			} else if v, ok := value.(int); ok {
+				config.JWT.EncryptionKeyRotationDays = v
+			}
+		case "jwt.key_retention_days":
+			if v, ok := value.(float64); ok {
+				config.JWT.KeyRetentionDays = int(v)
+			} else if v, ok := value.(int); ok {
+				config.JWT.KeyRetentionDays = v
+			}
+		case "jwt.id_token_expiry_minutes":
+			if v, ok := value.(float64); ok {
+				config.JWT.IDTokenExpiryMinutes = int(v)
+			} else if v, ok := value.(int); ok {
+				config.JWT.IDTokenExpiryMinutes = v
+			}
+		case "jwt.clock_skew_seconds":
+			if v, ok := value.(float64); ok {
+				config.JWT.ClockSkewSeconds = int(v)
+			} else if v, ok := value.(int); ok {
+				config.JWT.ClockSkewSeconds = v
+			}
+		case "jwt.auth_code_lifetime_seconds":
+			if v, ok := value.(float64); ok {
+				config.JWT.AuthCodeLifetimeSeconds = int(v)
+			} else if v, ok := value.(int); ok {
+				config.JWT.AuthCodeLifetimeSeconds = v
+			}
+		case "jwt.fapi_auth_code_lifetime":
+			if v, ok := value.(bool); ok {
+				config.JWT.FAPIAuthCodeLifetime = v
+			}
+		case "magic_link.enabled":
+			if v, ok := value.(bool); ok {
+				config.MagicLink.Enabled = v
+			}
+		case "magic_link.ttl_minutes":
+			if v, ok := value.(float64); ok {
+				config.MagicLink.TTLMinutes = int(v)
+			} else if v, ok := value.(int); ok {
+				config.MagicLink.TTLMinutes = v
+			}
+		case "device_flow.user_code_charset":
+			if v, ok := value.(string); ok {
+				config.DeviceFlow.UserCodeCharset = v
+			}
+		case "device_flow.user_code_length":
+			if v, ok := value.(float64); ok {
+				config.DeviceFlow.UserCodeLength = int(v)
+			} else if v, ok := value.(int); ok {
+				config.DeviceFlow.UserCodeLength = v
+			}
+		case "device_flow.verification_uri":
+			if v, ok := value.(string); ok {
+				config.DeviceFlow.VerificationURI = v
+			}
+		case "device_flow.kiosk":
+			if v, ok := value.(bool); ok {
+				config.DeviceFlow.Kiosk = v
+			}
+		case "maintenance.enabled":
+			if v, ok := value.(bool); ok {
+				config.Maintenance.Enabled = v
+			}
+		case "maintenance.retry_after_seconds":
+			if v, ok := value.(float64); ok {
+				config.Maintenance.RetryAfterSeconds = int(v)
+			} else if v, ok := value.(int); ok {
+				config.Maintenance.RetryAfterSeconds = v
+			}
+		case "retention.audit_log_days":
+			if v, ok := value.(float64); ok {
+				config.Retention.AuditLogDays = int(v)
+			} else if v, ok := value.(int); ok {
+				config.Retention.AuditLogDays = v
+			}
+		case "retention.expired_token_days":
+			if v, ok := value.(float64); ok {
+				config.Retention.ExpiredTokenDays = int(v)
+			} else if v, ok := value.(int); ok {
+				config.Retention.ExpiredTokenDays = v
+			}
+		case "retention.idle_session_days":
+			if v, ok := value.(float64); ok {
+				config.Retention.IdleSessionDays = int(v)
+			} else if v, ok := value.(int); ok {
+				config.Retention.IdleSessionDays = v
+			}
+		case "retention.abandoned_auth_session_minutes":
+			if v, ok := value.(float64); ok {
+				config.Retention.AbandonedAuthSessionMinutes = int(v)
+			} else if v, ok := value.(int); ok {
+				config.Retention.AbandonedAuthSessionMinutes = v
+			}
+		case "retention.interval_minutes":
+			if v, ok := value.(float64); ok {
+				config.Retention.IntervalMinutes = int(v)
+			} else if v, ok := value.(int); ok {
+				config.Retention.IntervalMinutes = v
+			}
+		case "remember_me.enabled":
+			if v, ok := value.(bool); ok {
+				config.RememberMe.Enabled = v
+			}
+		case "remember_me.lifetime_days":
+			if v, ok := value.(float64); ok {
+				config.RememberMe.LifetimeDays = int(v)
+			} else if v, ok := value.(int); ok {
+				config.RememberMe.LifetimeDays = v
+			}
+		case "session_limit.max_per_user":
+			if v, ok := value.(float64); ok {
+				config.SessionLimit.MaxPerUser = int(v)
+			} else if v, ok := value.(int); ok {
+				config.SessionLimit.MaxPerUser = v
+			}
+		case "session_limit.eviction":
+			if v, ok := value.(string); ok {
+				config.SessionLimit.Eviction = v
+			}
+		case "secret_reveal.enabled":
+			if v, ok := value.(bool); ok {
+				config.SecretReveal.Enabled = v
+			}
+		case "break_glass.enabled":
+			if v, ok := value.(bool); ok {
+				config.BreakGlass.Enabled = v
+			}
+		case "break_glass.max_ttl_minutes":
+			if v, ok := value.(float64); ok {
+				config.BreakGlass.MaxTTLMinutes = int(v)
+			} else if v, ok := value.(int); ok {
+				config.BreakGlass.MaxTTLMinutes = v
+			}
+		case "consent_receipts.enabled":
+			if v, ok := value.(bool); ok {
+				config.ConsentReceipts.Enabled = v
+			}
+		case "consent_receipts.jurisdiction":
+			if v, ok := value.(string); ok {
+				config.ConsentReceipts.Jurisdiction = v
+			}
+		case "saml_issuers":
+			if v, ok := value.([]SAMLIssuerConfig); ok {
+				config.SAMLIssuers = v
+			}
+		case "brands":
+			if v, ok := value.([]BrandConfig); ok {
+				config.Brands = v
+			}
+		case "feature_flags":
+			if v, ok := value.(map[string]bool); ok {
+				for name, enabled := range v {
+					config.SetFeature(name, enabled)
+				}
+			}
 		default:
 			return fmt.Errorf("unknown config field: %s", key)
 		}
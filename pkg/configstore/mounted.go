@@ -0,0 +1,112 @@
+package configstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// secretFiles maps file names in a mounted secrets directory (e.g. a Kubernetes
+// Secret volume) to the config fields they override
+var secretFiles = map[string]func(c *ConfigData, value string){
+	"jwt_private_key":      func(c *ConfigData, v string) { c.JWT.PrivateKey = v },
+	"jwt_public_key":       func(c *ConfigData, v string) { c.JWT.PublicKey = v },
+	"mongo_uri":            func(c *ConfigData, v string) { c.Storage.MongoURI = v },
+	"mongo_read_uri":       func(c *ConfigData, v string) { c.Storage.MongoReadURI = v },
+	"etcd_password":        func(c *ConfigData, v string) { c.Storage.EtcdPassword = v },
+	"smtp_password":        func(c *ConfigData, v string) { c.SMTP.Password = v },
+	"captcha_secret":       func(c *ConfigData, v string) { c.Registration.Captcha.Secret = v },
+	"login_captcha_secret": func(c *ConfigData, v string) { c.LoginCaptcha.Secret = v },
+}
+
+// MountedConfigStore reads configuration from a file mounted into the container,
+// such as a Kubernetes ConfigMap, with secrets overlaid from a separate directory
+// of files, such as a mounted Secret. The mount is treated as read-only: changes
+// are made by updating the ConfigMap or Secret and reloading.
+type MountedConfigStore struct {
+	file       *JSONConfigStore
+	secretsDir string
+}
+
+// NewMountedConfigStore creates a config store backed by the mounted config file at
+// filePath. secretsDir may be empty when no secrets are mounted.
+func NewMountedConfigStore(filePath, secretsDir string) *MountedConfigStore {
+	return &MountedConfigStore{
+		file:       NewJSONConfigStore(filePath),
+		secretsDir: secretsDir,
+	}
+}
+
+// Initialize does nothing; the mount is managed outside the server
+func (s *MountedConfigStore) Initialize(ctx context.Context) error {
+	return nil
+}
+
+// IsInitialized returns true if the mounted config, with secrets applied, has an
+// issuer and signing key
+func (s *MountedConfigStore) IsInitialized(ctx context.Context) (bool, error) {
+	config, err := s.GetConfig(ctx)
+	if err != nil {
+		return false, err
+	}
+	return config.Issuer != "" && config.JWT.PrivateKey != "", nil
+}
+
+// GetConfig reads the mounted config file and overlays the mounted secrets
+func (s *MountedConfigStore) GetConfig(ctx context.Context) (*ConfigData, error) {
+	config, err := s.file.GetConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := applySecretFiles(config, s.secretsDir); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// SaveConfig always fails because the mounted config is read-only
+func (s *MountedConfigStore) SaveConfig(ctx context.Context, config *ConfigData) error {
+	return fmt.Errorf("config is read-only (mounted from %s)", s.file.filePath)
+}
+
+// UpdateConfig always fails because the mounted config is read-only
+func (s *MountedConfigStore) UpdateConfig(ctx context.Context, updates map[string]interface{}) error {
+	return fmt.Errorf("config is read-only (mounted from %s)", s.file.filePath)
+}
+
+// Close does nothing
+func (s *MountedConfigStore) Close() error {
+	return nil
+}
+
+// WatchPaths returns the files whose changes should trigger a reload
+func (s *MountedConfigStore) WatchPaths() []string {
+	paths := []string{s.file.filePath}
+	if s.secretsDir != "" {
+		for name := range secretFiles {
+			paths = append(paths, filepath.Join(s.secretsDir, name))
+		}
+	}
+	return paths
+}
+
+// applySecretFiles overrides config fields with the contents of known files in dir.
+// Missing files are skipped; surrounding whitespace is trimmed.
+func applySecretFiles(config *ConfigData, dir string) error {
+	if dir == "" {
+		return nil
+	}
+	for name, apply := range secretFiles {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read secret %s: %w", name, err)
+		}
+		apply(config, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
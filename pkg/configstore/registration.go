@@ -0,0 +1,45 @@
+package configstore
+
+import (
+	"strings"
+	"sync"
+)
+
+// DefaultRegistrationEndpoint is the registration path used when none is configured
+const DefaultRegistrationEndpoint = "/register"
+
+// registrationMu guards Registration, which is read on every request and may
+// change on reload
+var registrationMu sync.RWMutex
+
+// RegistrationState returns a copy of the dynamic client registration settings
+func (c *ConfigData) RegistrationState() RegistrationConfig {
+	registrationMu.RLock()
+	defer registrationMu.RUnlock()
+	return c.Registration
+}
+
+// RegistrationEndpoint returns the path dynamic client registration is served on,
+// normalized to a leading slash and no trailing slash, and whether it is enabled
+func (c *ConfigData) RegistrationEndpoint() (string, bool) {
+	registrationMu.RLock()
+	defer registrationMu.RUnlock()
+	return NormalizeRegistrationEndpoint(c.Registration.Endpoint), c.Registration.Enabled
+}
+
+// SetRegistrationEndpoint enables or disables dynamic client registration and moves it to endpoint
+func (c *ConfigData) SetRegistrationEndpoint(enabled bool, endpoint string) {
+	registrationMu.Lock()
+	defer registrationMu.Unlock()
+	c.Registration.Enabled = enabled
+	c.Registration.Endpoint = endpoint
+}
+
+// NormalizeRegistrationEndpoint cleans up a configured registration path
+func NormalizeRegistrationEndpoint(endpoint string) string {
+	endpoint = strings.Trim(strings.TrimSpace(endpoint), "/")
+	if endpoint == "" {
+		return DefaultRegistrationEndpoint
+	}
+	return "/" + endpoint
+}
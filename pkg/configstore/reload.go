@@ -0,0 +1,352 @@
+package configstore
+
+import (
+	"maps"
+	"net/url"
+	"reflect"
+	"slices"
+	"sync"
+)
+
+// redactedValue replaces secrets in logged configuration
+const redactedValue = "[REDACTED]"
+
+// Redacted returns a copy of the configuration with private keys, passwords and
+// credentials replaced, suitable for logging
+func (c *ConfigData) Redacted() *ConfigData {
+	reloadMu.RLock()
+	registrationMu.RLock()
+	r := *c
+	registrationMu.RUnlock()
+	reloadMu.RUnlock()
+	if r.JWT.PrivateKey != "" {
+		r.JWT.PrivateKey = redactedValue
+	}
+	if r.SMTP.Password != "" {
+		r.SMTP.Password = redactedValue
+	}
+	if r.Avatars.Store.SecretAccessKey != "" {
+		r.Avatars.Store.SecretAccessKey = redactedValue
+	}
+	if r.Registration.Captcha.Secret != "" {
+		r.Registration.Captcha.Secret = redactedValue
+	}
+	if r.LoginCaptcha.Secret != "" {
+		r.LoginCaptcha.Secret = redactedValue
+	}
+	r.Storage.MongoURI = redactURI(r.Storage.MongoURI)
+	r.Storage.MongoReadURI = redactURI(r.Storage.MongoReadURI)
+	if r.Storage.EtcdPassword != "" {
+		r.Storage.EtcdPassword = redactedValue
+	}
+	if len(c.Storage.Options) > 0 {
+		r.Storage.Options = make(map[string]string, len(c.Storage.Options))
+		for k := range c.Storage.Options {
+			r.Storage.Options[k] = redactedValue
+		}
+	}
+	r.AttributeProviders = make([]AttributeProviderConfig, len(c.AttributeProviders))
+	for i, p := range c.AttributeProviders {
+		if p.AuthHeader != "" {
+			p.AuthHeader = redactedValue
+		}
+		r.AttributeProviders[i] = p
+	}
+	r.AccessPolicies = make([]AccessPolicyConfig, len(c.AccessPolicies))
+	for i, p := range c.AccessPolicies {
+		if p.AuthHeader != "" {
+			p.AuthHeader = redactedValue
+		}
+		r.AccessPolicies[i] = p
+	}
+	if r.AdminPolicy.AuthHeader != "" {
+		r.AdminPolicy.AuthHeader = redactedValue
+	}
+	r.Events.Exporters = make([]EventExporterConfig, len(c.Events.Exporters))
+	for i, e := range c.Events.Exporters {
+		if e.AuthHeader != "" {
+			e.AuthHeader = redactedValue
+		}
+		r.Events.Exporters[i] = e
+	}
+	r.Reports = c.ReportsState()
+	for i, rep := range r.Reports {
+		if rep.AuthHeader != "" {
+			rep.AuthHeader = redactedValue
+		}
+		r.Reports[i] = rep
+	}
+	featureMu.RLock()
+	r.FeatureFlags = maps.Clone(c.FeatureFlags)
+	featureMu.RUnlock()
+	r.Maintenance = c.MaintenanceState()
+	r.Retention = c.RetentionState()
+	return &r
+}
+
+// redactURI hides the password in a connection string
+func redactURI(uri string) string {
+	if uri == "" {
+		return ""
+	}
+	if u, err := url.Parse(uri); err == nil {
+		return u.Redacted()
+	}
+	return redactedValue
+}
+
+// reloadMu guards the settings ApplyReload replaces, which are read on every
+// request and written by reloads and the admin API. Requests read them through
+// the State accessors below.
+var reloadMu sync.RWMutex
+
+// ApplyReload copies the settings that can change while the server is running from
+// next into c. It returns the names of changed sections that only take effect
+// after a restart, such as the listen address, storage backend and signing keys.
+func (c *ConfigData) ApplyReload(next *ConfigData) []string {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	c.JWT.ExpiryMinutes = next.JWT.ExpiryMinutes
+	c.JWT.RefreshEnabled = next.JWT.RefreshEnabled
+	c.JWT.TokenLength = next.JWT.TokenLength
+	c.JWT.EncryptionKeyRotationDays = next.JWT.EncryptionKeyRotationDays
+	c.JWT.KeyRetentionDays = next.JWT.KeyRetentionDays
+	c.JWT.IDTokenExpiryMinutes = next.JWT.IDTokenExpiryMinutes
+	c.JWT.ClockSkewSeconds = next.JWT.ClockSkewSeconds
+	c.JWT.RefreshGraceSeconds = next.JWT.RefreshGraceSeconds
+	c.JWT.AuthCodeLifetimeSeconds = next.JWT.AuthCodeLifetimeSeconds
+	c.JWT.FAPIAuthCodeLifetime = next.JWT.FAPIAuthCodeLifetime
+	// Log sinks are opened when the server starts
+	access, application := c.Logging.Access, c.Logging.Application
+	c.Logging = next.Logging
+	c.Logging.Access, c.Logging.Application = access, application
+	c.MagicLink = next.MagicLink
+	c.FirstPartyLogin = next.FirstPartyLogin
+	c.LoginCaptcha = next.LoginCaptcha
+	c.LoginIdentifiers = next.LoginIdentifiers
+	c.EmailNormalization = next.EmailNormalization
+	c.UsernamePolicy = next.UsernamePolicy
+	c.PasswordHashing = next.PasswordHashing
+	// The avatar store is opened when the server starts
+	avatarStore := c.Avatars.Store
+	c.Avatars = next.Avatars
+	c.Avatars.Store = avatarStore
+	c.RememberMe = next.RememberMe
+	c.SessionLimit = next.SessionLimit
+	c.SessionCookies.KeyRotationDays = next.SessionCookies.KeyRotationDays
+	c.AuthFlows = next.AuthFlows
+	c.Brands = next.Brands
+	c.SecretReveal = next.SecretReveal
+	c.BreakGlass = next.BreakGlass
+	c.ConsentReceipts = next.ConsentReceipts
+	c.SAMLIssuers = next.SAMLIssuers
+	c.Federation = next.Federation
+	c.Grants = next.Grants
+	c.AdminPolicy = next.AdminPolicy
+	c.OutboundHTTP = next.OutboundHTTP
+
+	// The verification page is routed at its path when the server starts
+	verificationURI := c.DeviceFlow.VerificationURI
+	c.DeviceFlow = next.DeviceFlow
+	c.DeviceFlow.VerificationURI = verificationURI
+
+	registrationMu.Lock()
+	c.Registration = next.Registration
+	registrationMu.Unlock()
+	c.SetMaintenance(next.Maintenance)
+	c.SetRetention(next.Retention)
+	c.SetReports(next.Reports)
+	c.SetFeatures(next.FeatureFlags)
+
+	var restart []string
+	changed := func(name string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			restart = append(restart, name)
+		}
+	}
+	changed("issuer", c.Issuer, next.Issuer)
+	changed("server", c.Server, next.Server)
+	changed("storage", c.Storage, next.Storage)
+	changed("logging.access", c.Logging.Access, next.Logging.Access)
+	changed("logging.application", c.Logging.Application, next.Logging.Application)
+	changed("jwt keys", [2]string{c.JWT.PrivateKey, c.JWT.PublicKey}, [2]string{next.JWT.PrivateKey, next.JWT.PublicKey})
+	changed("secret_scanning", c.SecretScanning, next.SecretScanning)
+	changed("device_flow.verification_uri", verificationURI, next.DeviceFlow.VerificationURI)
+	changed("smtp", c.SMTP, next.SMTP)
+	changed("avatars.store", c.Avatars.Store, next.Avatars.Store)
+	changed("attribute_providers", c.AttributeProviders, next.AttributeProviders)
+	changed("access_policies", c.AccessPolicies, next.AccessPolicies)
+	changed("events", c.Events, next.Events)
+	changed("chaos", c.Chaos, next.Chaos)
+	changed("session_cookies.encrypt", c.SessionCookies.Encrypt, next.SessionCookies.Encrypt)
+	return restart
+}
+
+// UpdateReloadable runs update while holding the lock that guards the settings
+// ApplyReload replaces, for changes made by the admin API
+func (c *ConfigData) UpdateReloadable(update func(c *ConfigData)) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	update(c)
+}
+
+// JWTState returns a copy of the token settings
+func (c *ConfigData) JWTState() JWTConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.JWT
+}
+
+// LoggingState returns a copy of the logging settings
+func (c *ConfigData) LoggingState() LoggingConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.Logging
+}
+
+// MagicLinkState returns a copy of the magic link settings
+func (c *ConfigData) MagicLinkState() MagicLinkConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.MagicLink
+}
+
+// FirstPartyLoginState returns a copy of the first-party login settings
+func (c *ConfigData) FirstPartyLoginState() FirstPartyLoginConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.FirstPartyLogin
+}
+
+// LoginCaptchaState returns a copy of the sign-in CAPTCHA settings
+func (c *ConfigData) LoginCaptchaState() LoginCaptchaConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.LoginCaptcha
+}
+
+// EmailNormalizationState returns a copy of the email normalization settings
+func (c *ConfigData) EmailNormalizationState() EmailNormalizationConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.EmailNormalization
+}
+
+// UsernamePolicyState returns a copy of the username policy
+func (c *ConfigData) UsernamePolicyState() UsernamePolicyConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.UsernamePolicy
+}
+
+// PasswordHashingState returns a copy of the password hashing settings
+func (c *ConfigData) PasswordHashingState() PasswordHashingConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.PasswordHashing
+}
+
+// AvatarsState returns a copy of the avatar settings
+func (c *ConfigData) AvatarsState() AvatarConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.Avatars
+}
+
+// RememberMeState returns a copy of the "keep me signed in" settings
+func (c *ConfigData) RememberMeState() RememberMeConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.RememberMe
+}
+
+// SessionLimitState returns a copy of the session limit settings
+func (c *ConfigData) SessionLimitState() SessionLimitConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.SessionLimit
+}
+
+// SessionCookiesState returns a copy of the session cookie settings
+func (c *ConfigData) SessionCookiesState() SessionCookiesConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.SessionCookies
+}
+
+// AuthFlowsState returns a copy of the sign-in flows
+func (c *ConfigData) AuthFlowsState() []AuthFlowConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return slices.Clone(c.AuthFlows)
+}
+
+// BrandsState returns a copy of the white-label brands
+func (c *ConfigData) BrandsState() []BrandConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return slices.Clone(c.Brands)
+}
+
+// SecretRevealState returns a copy of the client secret reveal settings
+func (c *ConfigData) SecretRevealState() SecretRevealConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.SecretReveal
+}
+
+// BreakGlassState returns a copy of the break-glass settings
+func (c *ConfigData) BreakGlassState() BreakGlassConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.BreakGlass
+}
+
+// ConsentReceiptsState returns a copy of the consent receipt settings
+func (c *ConfigData) ConsentReceiptsState() ConsentReceiptConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.ConsentReceipts
+}
+
+// SAMLIssuersState returns a copy of the trusted SAML assertion issuers
+func (c *ConfigData) SAMLIssuersState() []SAMLIssuerConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return slices.Clone(c.SAMLIssuers)
+}
+
+// FederationState returns a copy of the OpenID Federation settings
+func (c *ConfigData) FederationState() FederationConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.Federation
+}
+
+// GrantsState returns a copy of the grant and response types turned off server-wide
+func (c *ConfigData) GrantsState() GrantsConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.Grants
+}
+
+// AdminPolicyState returns a copy of the admin API policy agent settings
+func (c *ConfigData) AdminPolicyState() AdminPolicyConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.AdminPolicy
+}
+
+// OutboundHTTPState returns a copy of the restrictions on outbound HTTP requests
+func (c *ConfigData) OutboundHTTPState() OutboundHTTPConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.OutboundHTTP
+}
+
+// DeviceFlowState returns a copy of the device authorization settings
+func (c *ConfigData) DeviceFlowState() DeviceFlowConfig {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	return c.DeviceFlow
+}
@@ -0,0 +1,75 @@
+package configstore
+
+import (
+	"slices"
+	"sync"
+)
+
+// Report schedules, formats and metrics accepted in ReportConfig
+const (
+	ReportScheduleDaily  = "daily"
+	ReportScheduleWeekly = "weekly"
+
+	ReportFormatCSV  = "csv"
+	ReportFormatJSON = "json"
+
+	ReportMetricLogins   = "logins"
+	ReportMetricNewUsers = "new_users"
+	ReportMetricTokens   = "tokens"
+	ReportMetricFailures = "failures"
+)
+
+// ReportMetrics lists every metric in the order reports show them
+var ReportMetrics = []string{ReportMetricLogins, ReportMetricNewUsers, ReportMetricTokens, ReportMetricFailures}
+
+// ReportConfig schedules an analytics report. A daily report covers the previous
+// UTC day and is sent after midnight UTC; a weekly report covers the previous
+// Monday to Sunday and is sent on Monday.
+type ReportConfig struct {
+	Name       string   `json:"name" bson:"name"`
+	Schedule   string   `json:"schedule" bson:"schedule"`                           // "daily" or "weekly"
+	Format     string   `json:"format,omitempty" bson:"format,omitempty"`           // "csv" or "json" (default: csv)
+	Metrics    []string `json:"metrics,omitempty" bson:"metrics,omitempty"`         // Subset of logins, new_users, tokens and failures; default: all
+	Email      []string `json:"email,omitempty" bson:"email,omitempty"`             // Recipients; requires smtp
+	WebhookURL string   `json:"webhook_url,omitempty" bson:"webhook_url,omitempty"` // The report is POSTed here
+	AuthHeader string   `json:"auth_header,omitempty" bson:"auth_header,omitempty"` // Sent as the Authorization header of the webhook request
+}
+
+// ReportFormat returns the configured format, or csv
+func (r ReportConfig) ReportFormat() string {
+	if r.Format == "" {
+		return ReportFormatCSV
+	}
+	return r.Format
+}
+
+// ReportMetrics returns the configured metrics in report order, or all of them
+func (r ReportConfig) ReportMetrics() []string {
+	if len(r.Metrics) == 0 {
+		return ReportMetrics
+	}
+	var metrics []string
+	for _, m := range ReportMetrics {
+		if slices.Contains(r.Metrics, m) {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// reportsMu guards Reports, which is read by the report scheduler and replaced on reload
+var reportsMu sync.RWMutex
+
+// ReportsState returns a copy of the scheduled reports
+func (c *ConfigData) ReportsState() []ReportConfig {
+	reportsMu.RLock()
+	defer reportsMu.RUnlock()
+	return slices.Clone(c.Reports)
+}
+
+// SetReports replaces the scheduled reports
+func (c *ConfigData) SetReports(reports []ReportConfig) {
+	reportsMu.Lock()
+	defer reportsMu.Unlock()
+	c.Reports = reports
+}
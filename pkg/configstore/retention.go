@@ -0,0 +1,44 @@
+package configstore
+
+import (
+	"context"
+	"sync"
+)
+
+// RetentionConfig sets how long each kind of record is kept before the cleanup
+// worker deletes it. A zero window disables deletion for that kind of record.
+type RetentionConfig struct {
+	AuditLogDays                int `json:"audit_log_days,omitempty" bson:"audit_log_days,omitempty"`                                 // Audit entries older than this are deleted
+	ExpiredTokenDays            int `json:"expired_token_days,omitempty" bson:"expired_token_days,omitempty"`                         // Tokens whose access token expired this long ago are deleted with their refresh token, as are authorization codes
+	IdleSessionDays             int `json:"idle_session_days,omitempty" bson:"idle_session_days,omitempty"`                           // User sessions unused this long are revoked and deleted, even if not yet expired
+	AbandonedAuthSessionMinutes int `json:"abandoned_auth_session_minutes,omitempty" bson:"abandoned_auth_session_minutes,omitempty"` // Unfinished authorization flows older than this are discarded
+	IntervalMinutes             int `json:"interval_minutes,omitempty" bson:"interval_minutes,omitempty"`                             // How often the cleanup worker runs (default: 60)
+}
+
+// retentionMu guards Retention, which is read by the cleanup worker and written by the admin API
+var retentionMu sync.RWMutex
+
+// RetentionState returns a copy of the current retention settings
+func (c *ConfigData) RetentionState() RetentionConfig {
+	retentionMu.RLock()
+	defer retentionMu.RUnlock()
+	return c.Retention
+}
+
+// SetRetention replaces the retention settings
+func (c *ConfigData) SetRetention(r RetentionConfig) {
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+	c.Retention = r
+}
+
+// SaveRetention replaces the retention settings in the stored config, so the
+// change survives restarts and reloads and reaches other instances
+func SaveRetention(ctx context.Context, store ConfigStore, r RetentionConfig) error {
+	config, err := store.GetConfig(ctx)
+	if err != nil {
+		return err
+	}
+	config.SetRetention(r)
+	return store.SaveConfig(ctx, config)
+}
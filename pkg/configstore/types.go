@@ -2,6 +2,9 @@ package configstore
 
 import (
 	"context"
+	"fmt"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -46,12 +49,117 @@ type ConfigData struct {
 
 	// Dynamic Client Registration Configuration
 	Registration RegistrationConfig `json:"registration" bson:"registration"`
+
+	// Secret Scanning Configuration
+	SecretScanning SecretScanningConfig `json:"secret_scanning" bson:"secret_scanning"`
+
+	// Logging Configuration
+	Logging LoggingConfig `json:"logging" bson:"logging"`
+
+	// Outgoing Email Configuration
+	SMTP SMTPConfig `json:"smtp" bson:"smtp"`
+
+	// Sign-in flows: ordered chains of authenticators selected per client or per ACR
+	AuthFlows []AuthFlowConfig `json:"auth_flows,omitempty" bson:"auth_flows,omitempty"`
+
+	// "Keep me signed in" sessions
+	RememberMe RememberMeConfig `json:"remember_me" bson:"remember_me"`
+
+	// Cap on concurrent sign-in sessions (devices/browsers) per user
+	SessionLimit SessionLimitConfig `json:"session_limit" bson:"session_limit"`
+
+	// Encryption of session cookies with rotating keys
+	SessionCookies SessionCookiesConfig `json:"session_cookies" bson:"session_cookies"`
+
+	// CAPTCHA challenge on the login page after repeated failures
+	LoginCaptcha LoginCaptchaConfig `json:"login_captcha" bson:"login_captcha"`
+
+	// User fields a sign-in identifier is matched against, in order (default: username, email)
+	LoginIdentifiers []string `json:"login_identifiers,omitempty" bson:"login_identifiers,omitempty"`
+
+	// Canonical form of user email addresses, used for uniqueness and sign-in
+	EmailNormalization EmailNormalizationConfig `json:"email_normalization" bson:"email_normalization"`
+
+	// Rules for the usernames of new and renamed users
+	UsernamePolicy UsernamePolicyConfig `json:"username_policy" bson:"username_policy"`
+
+	// Algorithm and cost of password hashes, applied to new passwords and on sign-in
+	PasswordHashing PasswordHashingConfig `json:"password_hashing" bson:"password_hashing"`
+
+	// Uploaded profile pictures, served for the picture claim
+	Avatars AvatarConfig `json:"avatars" bson:"avatars"`
+
+	// Passwordless Magic-Link Login Configuration
+	MagicLink MagicLinkConfig `json:"magic_link" bson:"magic_link"`
+
+	// Direct sign-in API for allow-listed first-party apps
+	FirstPartyLogin FirstPartyLoginConfig `json:"first_party_login" bson:"first_party_login"`
+
+	// Device authorization grant: user code format, verification page and polling
+	DeviceFlow DeviceFlowConfig `json:"device_flow" bson:"device_flow"`
+
+	// Upstream providers consulted for live attributes at userinfo time
+	AttributeProviders []AttributeProviderConfig `json:"attribute_providers,omitempty" bson:"attribute_providers,omitempty"`
+
+	// Conditional-access policies consulted before an authorization completes
+	AccessPolicies []AccessPolicyConfig `json:"access_policies,omitempty" bson:"access_policies,omitempty"`
+
+	// Admin API authorization delegated to an Open Policy Agent
+	AdminPolicy AdminPolicyConfig `json:"admin_policy" bson:"admin_policy"`
+
+	// Partners trusted to present SAML 2.0 bearer assertions at the token endpoint
+	SAMLIssuers []SAMLIssuerConfig `json:"saml_issuers,omitempty" bson:"saml_issuers,omitempty"`
+
+	// OpenID Federation: signed entity configuration and automatic client registration
+	Federation FederationConfig `json:"federation" bson:"federation"`
+
+	// Streaming of security events to a SIEM or log collector
+	Events EventsConfig `json:"events" bson:"events"`
+
+	// Restrictions on the HTTP requests the server makes to other hosts
+	OutboundHTTP OutboundHTTPConfig `json:"outbound_http" bson:"outbound_http"`
+
+	// Policy for administrators viewing client secrets after creation
+	SecretReveal SecretRevealConfig `json:"secret_reveal" bson:"secret_reveal"`
+
+	// Emergency admin access issued from the command line
+	BreakGlass BreakGlassConfig `json:"break_glass" bson:"break_glass"`
+
+	// Kantara consent receipts recorded for every consent grant
+	ConsentReceipts ConsentReceiptConfig `json:"consent_receipts" bson:"consent_receipts"`
+
+	// Maintenance mode and shutdown draining
+	Maintenance MaintenanceConfig `json:"maintenance" bson:"maintenance"`
+
+	// How long audit logs, expired tokens and stale sessions are kept
+	Retention RetentionConfig `json:"retention" bson:"retention"`
+
+	// Analytics reports sent by email or webhook on a daily or weekly schedule
+	Reports []ReportConfig `json:"reports,omitempty" bson:"reports,omitempty"`
+
+	// White-label brands for the login and consent pages, selected by request hostname
+	Brands []BrandConfig `json:"brands,omitempty" bson:"brands,omitempty"`
+
+	// Grant and response types turned off server-wide, e.g. for an OAuth 2.1 posture
+	Grants GrantsConfig `json:"grants" bson:"grants"`
+
+	// Experimental feature flags, keyed by flag name
+	FeatureFlags map[string]bool `json:"feature_flags,omitempty" bson:"feature_flags,omitempty"`
+
+	// Fault injection for resilience testing; ignored unless built with -tags chaos
+	Chaos ChaosConfig `json:"chaos" bson:"chaos"`
 }
 
 // ServerConfig holds server-related configuration
 type ServerConfig struct {
 	Host string `json:"host" bson:"host"`
 	Port int    `json:"port" bson:"port"`
+	// BasePath overrides the path prefix taken from the issuer URL, e.g. "/" when a
+	// reverse proxy strips the prefix before forwarding requests
+	BasePath string `json:"base_path,omitempty" bson:"base_path,omitempty"`
+	// TrustedProxies lists the addresses or CIDR ranges of reverse proxies whose
+	// X-Forwarded-For header gives the client address; it is ignored from anyone else
+	TrustedProxies []string `json:"trusted_proxies,omitempty" bson:"trusted_proxies,omitempty"`
 }
 
 // JWTConfig holds JWT-related configuration
@@ -61,18 +169,91 @@ type JWTConfig struct {
 	PublicKey      string `json:"public_key" bson:"public_key"`
 	ExpiryMinutes  int    `json:"expiry_minutes" bson:"expiry_minutes"`
 	RefreshEnabled bool   `json:"refresh_enabled" bson:"refresh_enabled"`
+	// TokenLength is the number of random characters in opaque access/refresh tokens and authorization codes
+	TokenLength int `json:"token_length,omitempty" bson:"token_length,omitempty"`
+	// EncryptionKeyRotationDays is how often the request object encryption key is replaced
+	EncryptionKeyRotationDays int `json:"encryption_key_rotation_days,omitempty" bson:"encryption_key_rotation_days,omitempty"`
+	// KeyRetentionDays is how long an expired, inactive key is kept before it is purged
+	KeyRetentionDays int `json:"key_retention_days,omitempty" bson:"key_retention_days,omitempty"`
+	// IDTokenExpiryMinutes is the ID token lifetime; ExpiryMinutes is used when unset
+	IDTokenExpiryMinutes int `json:"id_token_expiry_minutes,omitempty" bson:"id_token_expiry_minutes,omitempty"`
+	// ClockSkewSeconds is the leeway allowed on exp, nbf and iat when validating
+	// inbound assertions such as client assertions and request objects
+	ClockSkewSeconds int `json:"clock_skew_seconds,omitempty" bson:"clock_skew_seconds,omitempty"`
+	// RefreshGraceSeconds is how long a used refresh token keeps returning the token
+	// pair it was exchanged for, so that concurrent refreshes by the same client do not
+	// fail. 0 rejects any second use.
+	RefreshGraceSeconds int `json:"refresh_grace_seconds,omitempty" bson:"refresh_grace_seconds,omitempty"`
+	// AuthCodeLifetimeSeconds is how long an authorization code can be exchanged (default: 600)
+	AuthCodeLifetimeSeconds int `json:"auth_code_lifetime_seconds,omitempty" bson:"auth_code_lifetime_seconds,omitempty"`
+	// FAPIAuthCodeLifetime caps authorization codes at the 60 seconds FAPI requires,
+	// whatever AuthCodeLifetimeSeconds says
+	FAPIAuthCodeLifetime bool `json:"fapi_auth_code_lifetime,omitempty" bson:"fapi_auth_code_lifetime,omitempty"`
 }
 
 // StorageBackendConfig defines which storage backend to use for data
 type StorageBackendConfig struct {
-	Type string `json:"type" bson:"type"` // "json" or "mongodb"
+	Type string `json:"type" bson:"type"` // "json", "mongodb", "etcd" or the name of a driver added with storage.Register
 
 	// For JSON backend
 	JSONFilePath string `json:"json_file_path,omitempty" bson:"json_file_path,omitempty"`
+	// JSONEncryptionKey encrypts the JSON data file at rest with the AES-256 key it
+	// refers to, e.g. "env:OPENID_DATA_KEY" or "file:/run/secrets/data-key"
+	JSONEncryptionKey string `json:"json_encryption_key,omitempty" bson:"json_encryption_key,omitempty"`
+	// JSONEncryptionPreviousKeys can still decrypt the data file while the key is
+	// being rotated; the file is written back with JSONEncryptionKey
+	JSONEncryptionPreviousKeys []string `json:"json_encryption_previous_keys,omitempty" bson:"json_encryption_previous_keys,omitempty"`
 
 	// For MongoDB backend
 	MongoURI      string `json:"mongo_uri,omitempty" bson:"mongo_uri,omitempty"`
 	MongoDatabase string `json:"mongo_database,omitempty" bson:"mongo_database,omitempty"`
+	// MongoReadURI points at replica set members that serve client, user and
+	// signing key lookups; writes and all other reads stay on MongoURI
+	MongoReadURI string `json:"mongo_read_uri,omitempty" bson:"mongo_read_uri,omitempty"`
+	// ReadMaxStalenessSeconds skips replicas lagging further behind the primary
+	// (0 = no limit, otherwise at least 90)
+	ReadMaxStalenessSeconds int `json:"read_max_staleness_seconds,omitempty" bson:"read_max_staleness_seconds,omitempty"`
+
+	// For etcd backend
+	EtcdEndpoints []string `json:"etcd_endpoints,omitempty" bson:"etcd_endpoints,omitempty"` // e.g. https://etcd-0.etcd:2379
+	EtcdPrefix    string   `json:"etcd_prefix,omitempty" bson:"etcd_prefix,omitempty"`       // Key prefix (default: /openid/)
+	EtcdUsername  string   `json:"etcd_username,omitempty" bson:"etcd_username,omitempty"`
+	EtcdPassword  string   `json:"etcd_password,omitempty" bson:"etcd_password,omitempty"`
+	EtcdCAFile    string   `json:"etcd_ca_file,omitempty" bson:"etcd_ca_file,omitempty"`
+	EtcdCertFile  string   `json:"etcd_cert_file,omitempty" bson:"etcd_cert_file,omitempty"` // Client certificate for mutual TLS
+	EtcdKeyFile   string   `json:"etcd_key_file,omitempty" bson:"etcd_key_file,omitempty"`
+
+	// Connection pool, timeout and retry settings for database backends
+	Tuning StorageTuningConfig `json:"tuning" bson:"tuning"`
+
+	// Fail requests fast while the database is unreachable
+	Breaker StorageBreakerConfig `json:"breaker" bson:"breaker"`
+
+	// Settings for third-party drivers; values are redacted when the config is logged
+	Options map[string]string `json:"options,omitempty" bson:"options,omitempty"`
+}
+
+// StorageTuningConfig sizes the database connection pool and bounds how long
+// storage calls may take. Zero values keep the backend defaults.
+type StorageTuningConfig struct {
+	MaxPoolSize             int `json:"max_pool_size,omitempty" bson:"max_pool_size,omitempty"` // Open connections per server (default: 100)
+	MinPoolSize             int `json:"min_pool_size,omitempty" bson:"min_pool_size,omitempty"`
+	MaxIdleSeconds          int `json:"max_idle_seconds,omitempty" bson:"max_idle_seconds,omitempty"`                   // Close pooled connections idle this long (default: never)
+	ConnectTimeoutSeconds   int `json:"connect_timeout_seconds,omitempty" bson:"connect_timeout_seconds,omitempty"`     // Per connection attempt (default: 10)
+	OperationTimeoutSeconds int `json:"operation_timeout_seconds,omitempty" bson:"operation_timeout_seconds,omitempty"` // Per storage call, including retries (default: none)
+	ConnectAttempts         int `json:"connect_attempts,omitempty" bson:"connect_attempts,omitempty"`                   // Tries to reach the database at startup (default: 1)
+	RetryBackoffMillis      int `json:"retry_backoff_millis,omitempty" bson:"retry_backoff_millis,omitempty"`           // Wait before the second attempt, doubled after each failure (default: 500)
+}
+
+// StorageBreakerConfig controls the storage circuit breaker. The database is
+// probed in the background; once FailureThreshold probes in a row fail, requests
+// that need storage are answered 503 with Retry-After until a probe succeeds.
+type StorageBreakerConfig struct {
+	Enabled              bool `json:"enabled" bson:"enabled"`
+	FailureThreshold     int  `json:"failure_threshold,omitempty" bson:"failure_threshold,omitempty"`           // Consecutive failed probes that open the breaker (default: 3)
+	ProbeIntervalSeconds int  `json:"probe_interval_seconds,omitempty" bson:"probe_interval_seconds,omitempty"` // Time between probes (default: 5)
+	ProbeTimeoutSeconds  int  `json:"probe_timeout_seconds,omitempty" bson:"probe_timeout_seconds,omitempty"`   // Time a probe may take before it counts as failed (default: 2)
+	RetryAfterSeconds    int  `json:"retry_after_seconds,omitempty" bson:"retry_after_seconds,omitempty"`       // Retry-After sent with 503s (default: the probe interval)
 }
 
 // RegistrationConfig holds dynamic client registration configuration
@@ -83,6 +264,462 @@ type RegistrationConfig struct {
 	PolicyURI                 string `json:"policy_uri,omitempty" bson:"policy_uri,omitempty"`
 	TosURI                    string `json:"tos_uri,omitempty" bson:"tos_uri,omitempty"`
 	RequireInitialAccessToken bool   `json:"require_initial_access_token" bson:"require_initial_access_token"` // Require token for registration
+
+	Policy  RegistrationPolicy  `json:"policy" bson:"policy"`
+	Quotas  RegistrationQuotas  `json:"quotas" bson:"quotas"`
+	Captcha RegistrationCaptcha `json:"captcha" bson:"captcha"`
+}
+
+// RegistrationQuotas limits open registration (when no initial access token is required)
+// and controls the lifetime of dynamically registered clients. Zero values disable a limit.
+type RegistrationQuotas struct {
+	MaxPerIPPerHour         int `json:"max_per_ip_per_hour" bson:"max_per_ip_per_hour"`
+	MaxPerHour              int `json:"max_per_hour" bson:"max_per_hour"`
+	UnusedClientExpiryHours int `json:"unused_client_expiry_hours" bson:"unused_client_expiry_hours"` // Delete registered clients never used within this time
+	DormantAfterDays        int `json:"dormant_after_days" bson:"dormant_after_days"`                 // Admin report threshold (default: 90)
+}
+
+// RegistrationCaptcha configures an optional CAPTCHA check for open registration.
+// The verify URL must implement the common siteverify protocol (reCAPTCHA, hCaptcha, Turnstile).
+type RegistrationCaptcha struct {
+	VerifyURL string `json:"verify_url,omitempty" bson:"verify_url,omitempty"`
+	Secret    string `json:"secret,omitempty" bson:"secret,omitempty"`
+}
+
+// RegistrationPolicy restricts what dynamically registered clients may request.
+// Empty lists mean no restriction.
+type RegistrationPolicy struct {
+	AllowedGrantTypes               []string `json:"allowed_grant_types,omitempty" bson:"allowed_grant_types,omitempty"`
+	AllowedScopes                   []string `json:"allowed_scopes,omitempty" bson:"allowed_scopes,omitempty"`                 // Requested scopes must be a subset
+	AllowedRedirectHosts            []string `json:"allowed_redirect_hosts,omitempty" bson:"allowed_redirect_hosts,omitempty"` // Exact hosts or "*.example.com"
+	RequireContacts                 bool     `json:"require_contacts" bson:"require_contacts"`
+	MaxClientsPerInitialAccessToken int      `json:"max_clients_per_initial_access_token" bson:"max_clients_per_initial_access_token"` // 0 = single use
+	RequireApproval                 bool     `json:"require_approval" bson:"require_approval"`                                         // New clients stay pending until an admin approves them
+}
+
+// SecretScanningConfig holds configuration for the secret-scanning verification endpoint
+type SecretScanningConfig struct {
+	Enabled       bool   `json:"enabled" bson:"enabled"`
+	Endpoint      string `json:"endpoint" bson:"endpoint"`               // Endpoint path (default: /secret-scanning/verify)
+	PublicKeysURL string `json:"public_keys_url" bson:"public_keys_url"` // Where the scanner publishes its signing keys
+}
+
+// LoggingConfig holds request logging configuration
+type LoggingConfig struct {
+	// DebugPayloads logs redacted OAuth request/response payloads for all clients.
+	// Individual clients can be enabled instead through their debug_logging flag.
+	DebugPayloads bool `json:"debug_payloads" bson:"debug_payloads"`
+
+	// Access receives one line per HTTP request; Application receives everything
+	// else, including security warnings. Both default to stderr.
+	Access      LogSinkConfig `json:"access" bson:"access"`
+	Application LogSinkConfig `json:"application" bson:"application"`
+}
+
+// LogSinkConfig is where one kind of log goes and how it is written
+type LogSinkConfig struct {
+	Output string `json:"output,omitempty" bson:"output,omitempty"` // "stdout", "stderr", "off" or a file path (default: stderr)
+	Format string `json:"format,omitempty" bson:"format,omitempty"` // "json" or "text"; access logs take "json" or "combined"
+	Level  string `json:"level,omitempty" bson:"level,omitempty"`   // "debug", "info", "warn" or "error" (default: info)
+}
+
+// SMTPConfig holds the mail server used for outgoing email. Email is disabled when Host is empty.
+type SMTPConfig struct {
+	Host     string `json:"host,omitempty" bson:"host,omitempty"`
+	Port     int    `json:"port,omitempty" bson:"port,omitempty"` // Default: 587
+	Username string `json:"username,omitempty" bson:"username,omitempty"`
+	Password string `json:"password,omitempty" bson:"password,omitempty"`
+	From     string `json:"from,omitempty" bson:"from,omitempty"`
+}
+
+// AuthFlowConfig is an ordered chain of authenticators a user must pass to sign in,
+// e.g. ["password", "email_otp"]. A client selects a flow by name; otherwise the first
+// flow whose ACR matches a requested acr_values entry is used, then the flow named
+// "default", then password-only sign-in.
+type AuthFlowConfig struct {
+	Name  string   `json:"name" bson:"name"`
+	Steps []string `json:"steps" bson:"steps"`
+	ACR   string   `json:"acr,omitempty" bson:"acr,omitempty"` // Asserted in the ID token once the flow completes
+}
+
+// RememberMeConfig controls the "keep me signed in" option on the login page, which
+// creates a long-lived session that resumes with a lower ACR after the regular
+// session cookie has expired
+type RememberMeConfig struct {
+	Enabled      bool   `json:"enabled" bson:"enabled"`
+	LifetimeDays int    `json:"lifetime_days" bson:"lifetime_days"` // Default: 30
+	ACR          string `json:"acr,omitempty" bson:"acr,omitempty"` // ACR of resumed sessions (default: bronze)
+}
+
+// SessionCookiesConfig encrypts the session cookies with AES-256-GCM keys kept with
+// the signing keys. Retired keys keep opening cookies until they expire, and
+// cookies sealed with them are sealed again with the active key as they come in.
+type SessionCookiesConfig struct {
+	Encrypt         bool `json:"encrypt" bson:"encrypt"`                                         // Applied at startup; existing plain cookies stop working
+	KeyRotationDays int  `json:"key_rotation_days,omitempty" bson:"key_rotation_days,omitempty"` // Default: 30
+}
+
+// Session limit eviction behaviors
+const (
+	SessionEvictionOldest = "oldest" // Sign out the least recently created session
+	SessionEvictionReject = "reject" // Refuse the new sign-in until a session ends
+)
+
+// SessionLimitConfig caps how many active sign-in sessions a user may hold at once.
+// When a new sign-in would exceed MaxPerUser, Eviction decides whether the oldest
+// session is signed out or the sign-in is refused.
+type SessionLimitConfig struct {
+	MaxPerUser int    `json:"max_per_user" bson:"max_per_user"`             // 0 = unlimited
+	Eviction   string `json:"eviction,omitempty" bson:"eviction,omitempty"` // "oldest" (default) or "reject"
+}
+
+// EvictionBehavior returns the configured eviction behavior, defaulting to "oldest"
+func (s SessionLimitConfig) EvictionBehavior() string {
+	if s.Eviction == SessionEvictionReject {
+		return SessionEvictionReject
+	}
+	return SessionEvictionOldest
+}
+
+// LoginCaptchaConfig adds a CAPTCHA to the login page once an IP address or a username
+// has failed to sign in AfterFailures times within 15 minutes. It is disabled when
+// Provider is empty.
+type LoginCaptchaConfig struct {
+	Provider      string `json:"provider,omitempty" bson:"provider,omitempty"`     // "hcaptcha", "recaptcha" or "turnstile"
+	SiteKey       string `json:"site_key,omitempty" bson:"site_key,omitempty"`     // Public key rendered in the widget
+	Secret        string `json:"secret,omitempty" bson:"secret,omitempty"`         // Server-side verification secret
+	VerifyURL     string `json:"verify_url,omitempty" bson:"verify_url,omitempty"` // Overrides the provider's siteverify endpoint
+	AfterFailures int    `json:"after_failures" bson:"after_failures"`             // 0 = always challenge (default: 3)
+}
+
+// User fields that can identify a user at sign-in
+const (
+	LoginIdentifierUsername = "username"
+	LoginIdentifierEmail    = "email"
+	LoginIdentifierPhone    = "phone_number"
+)
+
+// LoginIdentifierFields returns the user fields a sign-in identifier is matched
+// against, in the configured order. Unknown names are skipped; without any valid
+// names the username is tried first, then the email address.
+func (c *ConfigData) LoginIdentifierFields() []string {
+	reloadMu.RLock()
+	defer reloadMu.RUnlock()
+	fields := make([]string, 0, len(c.LoginIdentifiers))
+	for _, name := range c.LoginIdentifiers {
+		switch name {
+		case LoginIdentifierUsername, LoginIdentifierEmail, LoginIdentifierPhone:
+			fields = append(fields, name)
+		}
+	}
+	if len(fields) == 0 {
+		return []string{LoginIdentifierUsername, LoginIdentifierEmail}
+	}
+	return fields
+}
+
+// EmailNormalizationConfig shapes the canonical form of user email addresses.
+// Addresses are always trimmed and lowercased; StripPlusTag also drops a "+tag"
+// from the local part, so alice+news@example.com is stored as alice@example.com.
+type EmailNormalizationConfig struct {
+	StripPlusTag bool `json:"strip_plus_tag" bson:"strip_plus_tag"`
+}
+
+// UsernamePolicyConfig restricts the usernames users are created or renamed with.
+// It is off unless Enabled, and existing usernames are only checked when they
+// change. Zero values get the defaults noted on each field.
+type UsernamePolicyConfig struct {
+	Enabled   bool   `json:"enabled" bson:"enabled"`
+	Pattern   string `json:"pattern,omitempty" bson:"pattern,omitempty"`       // Regular expression the whole username must match (default: letters, digits, ".", "_" and "-", starting with a letter or digit)
+	MinLength int    `json:"min_length,omitempty" bson:"min_length,omitempty"` // Default: 3
+	MaxLength int    `json:"max_length,omitempty" bson:"max_length,omitempty"` // Default: 64
+
+	// ReservedNames may not be used as a username, compared ignoring case and
+	// the separators ".", "_" and "-". Replaces the built-in list (admin, root,
+	// support, ...) when set.
+	ReservedNames []string `json:"reserved_names,omitempty" bson:"reserved_names,omitempty"`
+	// BlockedWords, such as a profanity list, may not appear anywhere in a
+	// username, compared the same way
+	BlockedWords []string `json:"blocked_words,omitempty" bson:"blocked_words,omitempty"`
+}
+
+// PasswordHashingConfig chooses how passwords are hashed. Hashes made with any
+// supported algorithm keep working; when a user signs in with a hash made with
+// another algorithm or a lower cost, it is replaced with one made with these
+// settings. Zero values get the defaults noted on each field.
+type PasswordHashingConfig struct {
+	Algorithm         string `json:"algorithm,omitempty" bson:"algorithm,omitempty"`                   // "bcrypt" (default) or "argon2id"
+	BcryptCost        int    `json:"bcrypt_cost,omitempty" bson:"bcrypt_cost,omitempty"`               // Default: 10
+	Argon2MemoryKiB   int    `json:"argon2_memory_kib,omitempty" bson:"argon2_memory_kib,omitempty"`   // Default: 19456 (19 MiB)
+	Argon2Iterations  int    `json:"argon2_iterations,omitempty" bson:"argon2_iterations,omitempty"`   // Default: 2
+	Argon2Parallelism int    `json:"argon2_parallelism,omitempty" bson:"argon2_parallelism,omitempty"` // Default: 1
+}
+
+// AvatarConfig controls uploaded profile pictures. Uploads are cropped to a
+// square, scaled down and stored in a blob store, and the user's picture claim
+// points at the copy served under /avatars/ on the issuer.
+type AvatarConfig struct {
+	Enabled        bool            `json:"enabled" bson:"enabled"`
+	MaxUploadBytes int64           `json:"max_upload_bytes,omitempty" bson:"max_upload_bytes,omitempty"` // Default: 5 MiB
+	Size           int             `json:"size,omitempty" bson:"size,omitempty"`                         // Width and height in pixels (default: 256)
+	Store          BlobStoreConfig `json:"store" bson:"store"`
+}
+
+// BlobStoreConfig selects where uploaded files are kept
+type BlobStoreConfig struct {
+	Type string `json:"type,omitempty" bson:"type,omitempty"` // "filesystem" (default) or "s3"
+	Path string `json:"path,omitempty" bson:"path,omitempty"` // Directory for "filesystem" (default: data/avatars)
+
+	// S3 or an S3-compatible service. Credentials fall back to the
+	// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment
+	// variables when empty.
+	Bucket          string `json:"bucket,omitempty" bson:"bucket,omitempty"`
+	Region          string `json:"region,omitempty" bson:"region,omitempty"`         // Default: us-east-1
+	Endpoint        string `json:"endpoint,omitempty" bson:"endpoint,omitempty"`     // Default: https://s3.<region>.amazonaws.com
+	Prefix          string `json:"prefix,omitempty" bson:"prefix,omitempty"`         // Prepended to every object key
+	PathStyle       bool   `json:"path_style,omitempty" bson:"path_style,omitempty"` // Address the bucket in the path rather than the hostname, as MinIO expects
+	AccessKeyID     string `json:"access_key_id,omitempty" bson:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty" bson:"secret_access_key,omitempty"`
+}
+
+// MagicLinkConfig controls passwordless sign-in through a one-time link sent by email.
+// It requires SMTP to be configured.
+type MagicLinkConfig struct {
+	Enabled    bool `json:"enabled" bson:"enabled"`
+	TTLMinutes int  `json:"ttl_minutes" bson:"ttl_minutes"` // Link lifetime (default: 15)
+}
+
+// FirstPartyLoginConfig controls POST /api/auth/login, which signs a user in to one
+// of the organisation's own apps and returns tokens directly, without a browser
+// redirect. It replaces the password grant for first-party mobile apps.
+type FirstPartyLoginConfig struct {
+	Enabled              bool     `json:"enabled" bson:"enabled"`
+	Clients              []string `json:"clients,omitempty" bson:"clients,omitempty"`                                 // IDs of the clients allowed to use it; each must also be marked first party
+	MaxAttemptsPerMinute int      `json:"max_attempts_per_minute,omitempty" bson:"max_attempts_per_minute,omitempty"` // Requests per IP address (default: 10)
+	MaxFailures          int      `json:"max_failures,omitempty" bson:"max_failures,omitempty"`                       // Failed sign-ins per IP address or user in 15 minutes before further attempts are refused (default: 5)
+}
+
+// FederationConfig publishes the server as an OpenID Federation 1.0 entity at
+// /.well-known/openid-federation and lets relying parties of trusted federations
+// register automatically by resolving their trust chain
+type FederationConfig struct {
+	Enabled          bool     `json:"enabled" bson:"enabled"`
+	OrganizationName string   `json:"organization_name,omitempty" bson:"organization_name,omitempty"`
+	Contacts         []string `json:"contacts,omitempty" bson:"contacts,omitempty"`
+	AuthorityHints   []string `json:"authority_hints,omitempty" bson:"authority_hints,omitempty"` // Entity IDs of the federations' intermediates or trust anchors above this server
+	LifetimeHours    int      `json:"lifetime_hours,omitempty" bson:"lifetime_hours,omitempty"`   // Validity of the entity configuration (default: 24)
+
+	// AutomaticRegistration accepts authorization requests from relying parties
+	// whose trust chain ends at one of TrustAnchors, without prior registration
+	AutomaticRegistration bool                    `json:"automatic_registration" bson:"automatic_registration"`
+	TrustAnchors          []FederationTrustAnchor `json:"trust_anchors,omitempty" bson:"trust_anchors,omitempty"`
+}
+
+// FederationTrustAnchor is a federation whose members are trusted. Its keys are
+// configured out of band, as the anchor's own entity configuration can't vouch for itself.
+type FederationTrustAnchor struct {
+	EntityID string                 `json:"entity_id" bson:"entity_id"`
+	JWKS     map[string]interface{} `json:"jwks" bson:"jwks"`
+}
+
+// Device flow user code character sets
+const (
+	UserCodeCharsetBase20 = "base20" // Consonants without vowels, so codes never spell words (RFC 8628 §6.1)
+	UserCodeCharsetDigits = "digits" // For devices whose users only have a numeric keypad
+)
+
+// DeviceFlowConfig shapes the device authorization grant (RFC 8628), which is
+// switched on with the device_flow feature flag
+type DeviceFlowConfig struct {
+	// UserCodeCharset is "base20" (default), "digits" or the literal characters codes
+	// are drawn from. Codes are case-insensitive, so letters are used in upper case.
+	UserCodeCharset string `json:"user_code_charset,omitempty" bson:"user_code_charset,omitempty"`
+	UserCodeLength  int    `json:"user_code_length,omitempty" bson:"user_code_length,omitempty"` // 6-16 (default: 8)
+	// UserCodeGroupSize is how many characters are shown between dashes (default: 4)
+	UserCodeGroupSize int `json:"user_code_group_size,omitempty" bson:"user_code_group_size,omitempty"`
+	// VerificationURI is the short address users are told to visit: a path on this
+	// server such as "/go", or an absolute URL on a vanity domain that forwards to
+	// /device. Defaults to /device.
+	VerificationURI  string `json:"verification_uri,omitempty" bson:"verification_uri,omitempty"`
+	ExpiresInSeconds int    `json:"expires_in_seconds,omitempty" bson:"expires_in_seconds,omitempty"` // Default: 600
+	IntervalSeconds  int    `json:"interval_seconds,omitempty" bson:"interval_seconds,omitempty"`     // Minimum polling interval (default: 5)
+	// Kiosk signs the user out again after each approval and always asks for a fresh
+	// sign-in, for verification pages on shared screens
+	Kiosk bool `json:"kiosk" bson:"kiosk"`
+}
+
+// AttributeProviderConfig configures an upstream attribute source, such as a REST
+// bridge in front of an LDAP or Active Directory server
+type AttributeProviderConfig struct {
+	Name            string   `json:"name" bson:"name"`
+	Type            string   `json:"type" bson:"type"` // "rest" (default)
+	URL             string   `json:"url" bson:"url"`
+	AuthHeader      string   `json:"auth_header,omitempty" bson:"auth_header,omitempty"`             // Sent as the Authorization header
+	Scope           string   `json:"scope,omitempty" bson:"scope,omitempty"`                         // Only consulted for tokens with this scope
+	Claims          []string `json:"claims,omitempty" bson:"claims,omitempty"`                       // Claims the provider may contribute; empty = all
+	TimeoutSeconds  int      `json:"timeout_seconds,omitempty" bson:"timeout_seconds,omitempty"`     // Default: 3
+	CacheTTLSeconds int      `json:"cache_ttl_seconds,omitempty" bson:"cache_ttl_seconds,omitempty"` // Default: 300
+}
+
+// AccessPolicyConfig configures an external conditional-access policy, such as an
+// Open Policy Agent or a REST device-posture service
+type AccessPolicyConfig struct {
+	Name           string   `json:"name" bson:"name"`
+	Type           string   `json:"type" bson:"type"` // "rest" (default) or "opa"
+	URL            string   `json:"url" bson:"url"`
+	AuthHeader     string   `json:"auth_header,omitempty" bson:"auth_header,omitempty"`         // Sent as the Authorization header
+	Clients        []string `json:"clients,omitempty" bson:"clients,omitempty"`                 // Only consulted for these clients; empty = all
+	TimeoutSeconds int      `json:"timeout_seconds,omitempty" bson:"timeout_seconds,omitempty"` // Default: 3
+	FailOpen       bool     `json:"fail_open,omitempty" bson:"fail_open,omitempty"`             // Allow when the policy is unavailable; default: deny
+}
+
+// AdminPolicyConfig delegates admin API authorization decisions to an Open
+// Policy Agent, e.g. for separation-of-duties rules. Off when URL is empty.
+type AdminPolicyConfig struct {
+	URL            string `json:"url,omitempty" bson:"url,omitempty"`                         // OPA data API URL of the decision, e.g. http://localhost:8181/v1/data/openid/admin
+	AuthHeader     string `json:"auth_header,omitempty" bson:"auth_header,omitempty"`         // Sent as the Authorization header
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty" bson:"timeout_seconds,omitempty"` // Default: 3
+	FailOpen       bool   `json:"fail_open,omitempty" bson:"fail_open,omitempty"`             // Allow when OPA is unavailable; default: deny
+}
+
+// OutboundHTTPConfig restricts the HTTP requests the server makes. Hosts are
+// exact names or "*.example.com" for any subdomain.
+type OutboundHTTPConfig struct {
+	AllowedHosts          []string `json:"allowed_hosts,omitempty" bson:"allowed_hosts,omitempty"`                     // When set, URLs supplied by clients may only point at these hosts
+	DeniedHosts           []string `json:"denied_hosts,omitempty" bson:"denied_hosts,omitempty"`                       // Never contacted
+	PrivateNetworkHosts   []string `json:"private_network_hosts,omitempty" bson:"private_network_hosts,omitempty"`     // URLs supplied by clients may reach private addresses on these hosts
+	BlockPrivateEndpoints bool     `json:"block_private_endpoints,omitempty" bson:"block_private_endpoints,omitempty"` // Also block private addresses for configured endpoints, e.g. event collectors
+	TimeoutSeconds        int      `json:"timeout_seconds,omitempty" bson:"timeout_seconds,omitempty"`                 // Upper bound on any request; default: 30
+	MaxResponseBytes      int64    `json:"max_response_bytes,omitempty" bson:"max_response_bytes,omitempty"`           // Default: 1 MiB
+}
+
+// SAML user matching fields
+const (
+	SAMLMatchUsername = "username"
+	SAMLMatchEmail    = "email"
+)
+
+// SAMLIssuerConfig trusts a partner identity provider to mint SAML 2.0 bearer
+// assertions that are exchanged for access tokens (RFC 7522)
+type SAMLIssuerConfig struct {
+	EntityID      string   `json:"entity_id" bson:"entity_id"`                               // Issuer of the assertions
+	Certificate   string   `json:"certificate" bson:"certificate"`                           // PEM signing certificate(s)
+	UserAttribute string   `json:"user_attribute,omitempty" bson:"user_attribute,omitempty"` // Attribute identifying the user; empty = the NameID
+	MatchField    string   `json:"match_field,omitempty" bson:"match_field,omitempty"`       // "username" (default) or "email"
+	Clients       []string `json:"clients,omitempty" bson:"clients,omitempty"`               // Clients that may present its assertions; empty = any
+	Audience      string   `json:"audience,omitempty" bson:"audience,omitempty"`             // Accepted in addition to the issuer and token endpoint URL
+}
+
+// UserMatchField returns the user field the assertion's identifier is matched against
+func (s SAMLIssuerConfig) UserMatchField() string {
+	if s.MatchField == SAMLMatchEmail {
+		return SAMLMatchEmail
+	}
+	return SAMLMatchUsername
+}
+
+// EventsConfig streams authentication and token events to external collectors for
+// SOC ingestion. Each exporter has its own buffer, so a slow collector delays only itself.
+type EventsConfig struct {
+	Exporters  []EventExporterConfig `json:"exporters,omitempty" bson:"exporters,omitempty"`
+	BufferSize int                   `json:"buffer_size,omitempty" bson:"buffer_size,omitempty"` // Events queued per exporter before new ones are dropped (default: 1000)
+}
+
+// EventExporterConfig configures one event destination
+type EventExporterConfig struct {
+	Name           string   `json:"name" bson:"name"`
+	Type           string   `json:"type" bson:"type"`                                           // "syslog" (UDP), "http" or "kafka" (via a Kafka REST Proxy)
+	Format         string   `json:"format,omitempty" bson:"format,omitempty"`                   // "cef" or "json" (JSON Lines); default: cef for syslog, json otherwise
+	Address        string   `json:"address,omitempty" bson:"address,omitempty"`                 // syslog: host:port
+	URL            string   `json:"url,omitempty" bson:"url,omitempty"`                         // http: collector URL; kafka: REST Proxy base URL
+	Topic          string   `json:"topic,omitempty" bson:"topic,omitempty"`                     // kafka: topic to produce to
+	AuthHeader     string   `json:"auth_header,omitempty" bson:"auth_header,omitempty"`         // http/kafka: sent as the Authorization header
+	Actions        []string `json:"actions,omitempty" bson:"actions,omitempty"`                 // Action prefixes to export; default: "user." and "token."
+	TimeoutSeconds int      `json:"timeout_seconds,omitempty" bson:"timeout_seconds,omitempty"` // Default: 5
+}
+
+// ChaosConfig injects latency and errors into storage and outgoing HTTP calls so
+// that client retries and the server's own timeouts can be exercised. It only
+// takes effect in binaries built with -tags chaos, never in a release build.
+type ChaosConfig struct {
+	Enabled bool        `json:"enabled" bson:"enabled"`
+	Storage FaultConfig `json:"storage" bson:"storage"`
+	HTTP    FaultConfig `json:"http" bson:"http"` // JWKS, sector identifier, CAPTCHA, event exporter and attribute provider calls
+}
+
+// FaultConfig describes the faults injected into one kind of call
+type FaultConfig struct {
+	LatencyMs       int      `json:"latency_ms,omitempty" bson:"latency_ms,omitempty"`               // Added to every matching call
+	LatencyJitterMs int      `json:"latency_jitter_ms,omitempty" bson:"latency_jitter_ms,omitempty"` // Up to this much more, at random
+	ErrorRate       float64  `json:"error_rate,omitempty" bson:"error_rate,omitempty"`               // Share of matching calls that fail, 0 to 1
+	ErrorStatus     int      `json:"error_status,omitempty" bson:"error_status,omitempty"`           // http: answer failed calls with this status instead of a connection error
+	Targets         []string `json:"targets,omitempty" bson:"targets,omitempty"`                     // Storage method names or HTTP hosts to affect; default: all
+}
+
+// SecretRevealConfig controls whether administrators may view an existing client
+// secret. Each view needs the administrator's password again and a one-time reveal
+// token; deployments with compliance requirements can turn it off entirely.
+type SecretRevealConfig struct {
+	Enabled         bool `json:"enabled" bson:"enabled"`
+	TokenTTLSeconds int  `json:"token_ttl_seconds" bson:"token_ttl_seconds"` // Reveal token lifetime (default: 60)
+}
+
+// BreakGlassConfig controls emergency admin access. `openid-server break-glass`
+// issues a one-time code, which the admin API exchanges for an admin token that
+// expires with the code. Every step and every request made with the token is
+// audited.
+type BreakGlassConfig struct {
+	Enabled       bool `json:"enabled" bson:"enabled"`
+	MaxTTLMinutes int  `json:"max_ttl_minutes,omitempty" bson:"max_ttl_minutes,omitempty"` // Longest access window a code may grant (default: 60)
+}
+
+// ConsentReceiptConfig controls the consent receipts kept as a record of each consent
+// grant, which users and administrators can export
+type ConsentReceiptConfig struct {
+	Enabled      bool   `json:"enabled" bson:"enabled"`
+	Jurisdiction string `json:"jurisdiction,omitempty" bson:"jurisdiction,omitempty"` // Jurisdiction of the controller, e.g. "EU"
+}
+
+// BrandConfig is the look of the login and consent pages for requests to the listed
+// hostnames, so one issuer can serve several white-label products. A brand named
+// "default" applies to hostnames no other brand lists.
+type BrandConfig struct {
+	Name                 string            `json:"name" bson:"name"`
+	Hosts                []string          `json:"hosts,omitempty" bson:"hosts,omitempty"` // e.g. "login.example.com" or "*.example.com"
+	ProductName          string            `json:"product_name,omitempty" bson:"product_name,omitempty"`
+	ProductNameLocalized map[string]string `json:"product_name_localized,omitempty" bson:"product_name_localized,omitempty"` // Keyed by language tag
+	LogoURL              string            `json:"logo_url,omitempty" bson:"logo_url,omitempty"`
+	PrimaryColor         string            `json:"primary_color,omitempty" bson:"primary_color,omitempty"`       // CSS hex color, e.g. "#0D9488"
+	BackgroundColor      string            `json:"background_color,omitempty" bson:"background_color,omitempty"` // CSS hex color
+	SupportURL           string            `json:"support_url,omitempty" bson:"support_url,omitempty"`
+	Locales              []string          `json:"locales,omitempty" bson:"locales,omitempty"`               // Languages the brand's pages are offered in
+	DefaultLocale        string            `json:"default_locale,omitempty" bson:"default_locale,omitempty"` // Used when no preferred language matches
+}
+
+// MinTokenLength keeps opaque tokens at 192 bits of entropy or more
+const MinTokenLength = 32
+
+// Validate reports the first setting the server must not run with. It is checked
+// when the config is loaded and before a reloaded config is applied.
+func (c *ConfigData) Validate() error {
+	if c.JWT.TokenLength != 0 && c.JWT.TokenLength < MinTokenLength {
+		return fmt.Errorf("jwt.token_length must be at least %d", MinTokenLength)
+	}
+	return nil
+}
+
+// BasePath returns the path prefix the server is mounted under, without a trailing
+// slash. It defaults to the path of the issuer URL, e.g. "/auth" for
+// https://example.com/auth, and is empty when the server runs at the root.
+func (c *ConfigData) BasePath() string {
+	if c.Server.BasePath != "" {
+		return strings.TrimSuffix(c.Server.BasePath, "/")
+	}
+	issuer, err := url.Parse(c.Issuer)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(issuer.Path, "/")
 }
 
 // DefaultConfig returns a default configuration
@@ -97,6 +734,13 @@ func DefaultConfig() *ConfigData {
 		JWT: JWTConfig{
 			ExpiryMinutes:  60,
 			RefreshEnabled: true,
+			TokenLength:    43, // 256 bits of entropy
+
+			EncryptionKeyRotationDays: 90,
+			KeyRetentionDays:          30,
+			IDTokenExpiryMinutes:      60,
+			ClockSkewSeconds:          60,
+			RefreshGraceSeconds:       30,
 		},
 		Issuer: "http://localhost:8080",
 		Storage: StorageBackendConfig{
@@ -106,6 +750,44 @@ func DefaultConfig() *ConfigData {
 		Registration: RegistrationConfig{
 			Enabled:  true, // Enable dynamic client registration by default
 			Endpoint: "/register",
+			Quotas: RegistrationQuotas{
+				MaxPerIPPerHour:         10,
+				MaxPerHour:              200,
+				UnusedClientExpiryHours: 7 * 24,
+				DormantAfterDays:        90,
+			},
+		},
+		SecretScanning: SecretScanningConfig{
+			Enabled:       true,
+			Endpoint:      "/secret-scanning/verify",
+			PublicKeysURL: "https://api.github.com/meta/public_keys/secret_scanning",
+		},
+		RememberMe: RememberMeConfig{
+			Enabled:      true,
+			LifetimeDays: 30,
+		},
+		SecretReveal: SecretRevealConfig{
+			Enabled:         true,
+			TokenTTLSeconds: 60,
+		},
+		BreakGlass: BreakGlassConfig{
+			Enabled: true,
+		},
+		ConsentReceipts: ConsentReceiptConfig{
+			Enabled: true,
+		},
+		LoginCaptcha: LoginCaptchaConfig{
+			AfterFailures: 3,
+		},
+		MagicLink: MagicLinkConfig{
+			TTLMinutes: 15,
+		},
+		Maintenance: MaintenanceConfig{
+			RetryAfterSeconds: 300,
+			DrainSeconds:      5,
+		},
+		Retention: RetentionConfig{
+			IntervalMinutes: 60,
 		},
 	}
 }
@@ -0,0 +1,13 @@
+package configstore
+
+import "testing"
+
+func TestValidateTokenLength(t *testing.T) {
+	for length, valid := range map[int]bool{0: true, MinTokenLength: true, 64: true, MinTokenLength - 1: false, 8: false} {
+		config := DefaultConfig()
+		config.JWT.TokenLength = length
+		if err := config.Validate(); (err == nil) != valid {
+			t.Errorf("Validate() with token_length %d = %v, want valid %v", length, err, valid)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package crypto
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AuditCheckpointJWTType is the JWT "typ" of signed audit chain checkpoints
+const AuditCheckpointJWTType = "audit-checkpoint+jwt"
+
+// AuditCheckpointClaims state the head of the audit chain when the checkpoint was signed
+type AuditCheckpointClaims struct {
+	jwt.RegisteredClaims
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// SignAuditCheckpoint signs the audit chain head seq/hash with key, advertising kid
+func SignAuditCheckpoint(kid string, key *rsa.PrivateKey, seq int64, hash string, at time.Time) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, AuditCheckpointClaims{
+		RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(at)},
+		Seq:              seq,
+		Hash:             hash,
+	})
+	token.Header["kid"] = kid
+	token.Header["typ"] = AuditCheckpointJWTType
+	return token.SignedString(key)
+}
+
+// VerifyAuditCheckpoint checks a checkpoint signature with key and returns the
+// chain head it covers
+func VerifyAuditCheckpoint(signature string, key *rsa.PublicKey) (*AuditCheckpointClaims, error) {
+	claims := &AuditCheckpointClaims{}
+	_, err := jwt.ParseWithClaims(signature, claims, func(*jwt.Token) (interface{}, error) {
+		return key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}), jwt.WithIssuedAt())
+	if err != nil {
+		return nil, fmt.Errorf("invalid checkpoint signature: %w", err)
+	}
+	return claims, nil
+}
@@ -0,0 +1,80 @@
+package crypto
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// breakGlassAudience keeps break-glass codes from being accepted as admin session tokens
+const breakGlassAudience = "admin:break-glass"
+
+// BreakGlassSubject is the admin token subject of emergency access sessions
+const BreakGlassSubject = "break-glass"
+
+// BreakGlassClaims describe an emergency access grant issued from the command line
+type BreakGlassClaims struct {
+	jwt.RegisteredClaims
+	Reason   string `json:"reason"`
+	IssuedBy string `json:"issued_by"` // Operating system user and host that issued the code
+}
+
+// GenerateBreakGlassCode signs a one-time code granting admin access until ttl has
+// passed. The secret must match the one used for admin tokens.
+func GenerateBreakGlassCode(reason, issuedBy string, ttl time.Duration, secret []byte) (string, *BreakGlassClaims, error) {
+	jti, err := GenerateRandomString(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	claims := &BreakGlassClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   BreakGlassSubject,
+			Audience:  jwt.ClaimStrings{breakGlassAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        jti,
+		},
+		Reason:   reason,
+		IssuedBy: issuedBy,
+	}
+	code, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	return code, claims, err
+}
+
+// ValidateBreakGlassCode verifies a break-glass code's signature, audience and expiry.
+// Callers are responsible for enforcing single use through the jti.
+func ValidateBreakGlassCode(code string, secret []byte) (*BreakGlassClaims, error) {
+	claims := &BreakGlassClaims{}
+	_, err := jwt.ParseWithClaims(code, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return secret, nil
+	},
+		jwt.WithAudience(breakGlassAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ID == "" || claims.Subject != BreakGlassSubject {
+		return nil, fmt.Errorf("break-glass code is missing jti or has the wrong subject")
+	}
+	return claims, nil
+}
+
+// GenerateBreakGlassAdminToken creates the admin token a redeemed break-glass code
+// is exchanged for. It expires with the grant and names it in the break_glass claim.
+func GenerateBreakGlassAdminToken(grantID string, expiresAt time.Time, secret []byte) (string, error) {
+	claims := jwt.MapClaims{
+		"sub":         BreakGlassSubject,
+		"role":        "admin",
+		"break_glass": grantID,
+		"iat":         time.Now().Unix(),
+		"exp":         expiresAt.Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
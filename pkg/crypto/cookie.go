@@ -0,0 +1,79 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// CookieKeySize is the length of the AES-256 keys that seal session cookies
+const CookieKeySize = 32
+
+// GenerateCookieKey returns a new random cookie sealing key
+func GenerateCookieKey() ([]byte, error) {
+	key := make([]byte, CookieKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// SealCookie encrypts and authenticates the value of cookie name with AES-256-GCM.
+// The result is "<kid>.<nonce and ciphertext>"; the cookie name is bound as
+// additional data so a value can't be moved to another cookie.
+func SealCookie(kid string, key []byte, name, value string) (string, error) {
+	aead, err := cookieAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return kid + "." + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// CookieKeyID returns the ID of the key a sealed cookie value names
+func CookieKeyID(sealed string) (string, bool) {
+	kid, _, ok := strings.Cut(sealed, ".")
+	return kid, ok && kid != ""
+}
+
+// OpenCookie decrypts a value sealed by SealCookie for cookie name
+func OpenCookie(key []byte, name, sealed string) (string, error) {
+	_, payload, ok := strings.Cut(sealed, ".")
+	if !ok {
+		return "", fmt.Errorf("cookie is not sealed")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", fmt.Errorf("invalid cookie encoding")
+	}
+	aead, err := cookieAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < aead.NonceSize() {
+		return "", fmt.Errorf("cookie is too short")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("cookie failed authentication")
+	}
+	return string(plaintext), nil
+}
+
+func cookieAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != CookieKeySize {
+		return nil, fmt.Errorf("cookie key must be %d bytes", CookieKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
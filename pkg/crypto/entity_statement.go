@@ -0,0 +1,18 @@
+package crypto
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// EntityStatementType is the JWT "typ" of OpenID Federation entity statements;
+// prefixed with "application/" it is also their media type
+const EntityStatementType = "entity-statement+jwt"
+
+// SignEntityStatement signs an OpenID Federation entity statement, such as the
+// server's own entity configuration, with the active signing key
+func (jm *JWTManager) SignEntityStatement(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = jm.keyID
+	token.Header["typ"] = EntityStatementType
+	return token.SignedString(jm.privateKey)
+}
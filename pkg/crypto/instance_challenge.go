@@ -0,0 +1,62 @@
+package crypto
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// instanceChallengeAudience keeps instance challenges from being accepted as any other kind of JWT
+func (jm *JWTManager) instanceChallengeAudience() string {
+	return jm.issuer + "/register/instances"
+}
+
+// GenerateInstanceChallenge signs a single-use challenge that an app install embeds
+// in its platform attestation (the Play Integrity nonce or the App Attest client
+// data) before registering as an instance of clientID
+func (jm *JWTManager) GenerateInstanceChallenge(clientID string, ttl time.Duration) (string, error) {
+	jti, err := GenerateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Issuer:    jm.issuer,
+		Subject:   clientID,
+		Audience:  jwt.ClaimStrings{jm.instanceChallengeAudience()},
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ID:        jti,
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = jm.keyID
+	return token.SignedString(jm.privateKey)
+}
+
+// ValidateInstanceChallenge verifies a challenge's signature, audience and expiry
+// and that it was issued for clientID. Callers are responsible for enforcing
+// single use through the jti.
+func (jm *JWTManager) ValidateInstanceChallenge(tokenString, clientID string) (*jwt.RegisteredClaims, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return jm.publicKey, nil
+	},
+		jwt.WithIssuer(jm.issuer),
+		jwt.WithAudience(jm.instanceChallengeAudience()),
+		jwt.WithSubject(clientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ID == "" {
+		return nil, fmt.Errorf("instance challenge is missing jti")
+	}
+	return claims, nil
+}
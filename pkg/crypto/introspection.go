@@ -0,0 +1,41 @@
+package crypto
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// IntrospectionJWTType is the JWT "typ" of signed introspection responses; prefixed
+// with "application/" it is also their media type (RFC 9701)
+const IntrospectionJWTType = "token-introspection+jwt"
+
+// IntrospectionClaims carry a token introspection response signed for a resource server
+type IntrospectionClaims struct {
+	jwt.RegisteredClaims
+	TokenIntrospection interface{} `json:"token_introspection"`
+}
+
+// GenerateIntrospectionResponse signs an RFC 7662 introspection response for the
+// resource server identified by audience, as described in RFC 9701
+func (jm *JWTManager) GenerateIntrospectionResponse(audience string, introspection interface{}) (string, error) {
+	jti, err := GenerateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	claims := IntrospectionClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:   jm.issuer,
+			Audience: jwt.ClaimStrings{audience},
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+			ID:       jti,
+		},
+		TokenIntrospection: introspection,
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = jm.keyID
+	token.Header["typ"] = IntrospectionJWTType
+	return token.SignedString(jm.privateKey)
+}
@@ -0,0 +1,301 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // RSA-OAEP (RFC 7518 §4.3) is defined with SHA-1
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// JWE key management algorithms (RFC 7518 §4.3)
+const (
+	JWEAlgRSAOAEP    = "RSA-OAEP"
+	JWEAlgRSAOAEP256 = "RSA-OAEP-256"
+)
+
+// JWE content encryption algorithms (RFC 7518 §5)
+const (
+	JWEEncA128CBCHS256 = "A128CBC-HS256"
+	JWEEncA192CBCHS384 = "A192CBC-HS384"
+	JWEEncA256CBCHS512 = "A256CBC-HS512"
+	JWEEncA128GCM      = "A128GCM"
+	JWEEncA192GCM      = "A192GCM"
+	JWEEncA256GCM      = "A256GCM"
+)
+
+// SupportedJWEAlgorithms lists the key management algorithms accepted by DecryptJWE
+var SupportedJWEAlgorithms = []string{JWEAlgRSAOAEP, JWEAlgRSAOAEP256}
+
+// SupportedJWEEncryptions lists the content encryption algorithms accepted by DecryptJWE
+var SupportedJWEEncryptions = []string{
+	JWEEncA128CBCHS256, JWEEncA192CBCHS384, JWEEncA256CBCHS512,
+	JWEEncA128GCM, JWEEncA192GCM, JWEEncA256GCM,
+}
+
+// JWEHeader is the protected header of a compact JWE
+type JWEHeader struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc"`
+	Kid string `json:"kid,omitempty"`
+	Cty string `json:"cty,omitempty"`
+	Zip string `json:"zip,omitempty"`
+}
+
+// IsJWE reports whether token has the five-part compact JWE serialization
+func IsJWE(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// ParseJWEHeader decodes the protected header of a compact JWE
+func ParseJWEHeader(token string) (*JWEHeader, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("jwe must have 5 parts")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid jwe header encoding")
+	}
+	var header JWEHeader
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, fmt.Errorf("invalid jwe header")
+	}
+	return &header, nil
+}
+
+// DecryptJWE decrypts a compact JWE encrypted to an RSA key and returns its plaintext
+func DecryptJWE(token string, key *rsa.PrivateKey) ([]byte, error) {
+	header, err := ParseJWEHeader(token)
+	if err != nil {
+		return nil, err
+	}
+	if header.Zip != "" {
+		return nil, fmt.Errorf("compressed jwe is not supported")
+	}
+
+	parts := strings.Split(token, ".")
+	var segments [4][]byte
+	for i, part := range parts[1:] {
+		if segments[i], err = base64.RawURLEncoding.DecodeString(part); err != nil {
+			return nil, fmt.Errorf("invalid jwe encoding")
+		}
+	}
+	encryptedKey, iv, ciphertext, tag := segments[0], segments[1], segments[2], segments[3]
+
+	oaepHash, err := jweOAEPHash(header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := rsa.DecryptOAEP(oaepHash, nil, key, encryptedKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt content encryption key")
+	}
+
+	// The additional authenticated data is the encoded protected header (RFC 7516 §5.2)
+	return jweDecryptContent(header.Enc, cek, iv, ciphertext, tag, []byte(parts[0]))
+}
+
+// EncryptJWE encrypts plaintext to an RSA public key as a compact JWE
+func EncryptJWE(plaintext []byte, key *rsa.PublicKey, alg, enc, kid, cty string) (string, error) {
+	oaepHash, err := jweOAEPHash(alg)
+	if err != nil {
+		return "", err
+	}
+	keySize, err := jweContentKeySize(enc)
+	if err != nil {
+		return "", err
+	}
+
+	cek := make([]byte, keySize)
+	if _, err := rand.Read(cek); err != nil {
+		return "", err
+	}
+	encryptedKey, err := rsa.EncryptOAEP(oaepHash, rand.Reader, key, cek, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt content encryption key: %w", err)
+	}
+
+	headerJSON, err := json.Marshal(JWEHeader{Alg: alg, Enc: enc, Kid: kid, Cty: cty})
+	if err != nil {
+		return "", err
+	}
+	protected := base64.RawURLEncoding.EncodeToString(headerJSON)
+
+	iv, ciphertext, tag, err := jweEncryptContent(enc, cek, plaintext, []byte(protected))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join([]string{
+		protected,
+		base64.RawURLEncoding.EncodeToString(encryptedKey),
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+func jweOAEPHash(alg string) (hash.Hash, error) {
+	switch alg {
+	case JWEAlgRSAOAEP:
+		return sha1.New(), nil
+	case JWEAlgRSAOAEP256:
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported jwe alg: %s", alg)
+	}
+}
+
+// jweContentKeySize returns the content encryption key length for enc, in bytes.
+// CBC-HMAC keys hold the MAC key followed by the encryption key (RFC 7518 §5.2.2.1).
+func jweContentKeySize(enc string) (int, error) {
+	switch enc {
+	case JWEEncA128GCM:
+		return 16, nil
+	case JWEEncA192GCM:
+		return 24, nil
+	case JWEEncA256GCM, JWEEncA128CBCHS256:
+		return 32, nil
+	case JWEEncA192CBCHS384:
+		return 48, nil
+	case JWEEncA256CBCHS512:
+		return 64, nil
+	default:
+		return 0, fmt.Errorf("unsupported jwe enc: %s", enc)
+	}
+}
+
+func jweCBCHash(enc string) func() hash.Hash {
+	switch enc {
+	case JWEEncA192CBCHS384:
+		return sha512.New384
+	case JWEEncA256CBCHS512:
+		return sha512.New
+	default:
+		return sha256.New
+	}
+}
+
+func isJWEGCM(enc string) bool {
+	return strings.HasSuffix(enc, "GCM")
+}
+
+func jweDecryptContent(enc string, cek, iv, ciphertext, tag, aad []byte) ([]byte, error) {
+	keySize, err := jweContentKeySize(enc)
+	if err != nil {
+		return nil, err
+	}
+	if len(cek) != keySize {
+		return nil, fmt.Errorf("invalid content encryption key length")
+	}
+
+	if isJWEGCM(enc) {
+		block, err := aes.NewCipher(cek)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		if len(iv) != gcm.NonceSize() {
+			return nil, fmt.Errorf("invalid jwe iv length")
+		}
+		plaintext, err := gcm.Open(nil, iv, append(ciphertext, tag...), aad)
+		if err != nil {
+			return nil, fmt.Errorf("jwe authentication failed")
+		}
+		return plaintext, nil
+	}
+
+	macKey, encKey := cek[:keySize/2], cek[keySize/2:]
+	expected := jweCBCTag(enc, macKey, aad, iv, ciphertext)
+	if subtle.ConstantTimeCompare(expected, tag) != 1 {
+		return nil, fmt.Errorf("jwe authentication failed")
+	}
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid jwe ciphertext")
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, fmt.Errorf("invalid jwe padding")
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+func jweEncryptContent(enc string, cek, plaintext, aad []byte) (iv, ciphertext, tag []byte, err error) {
+	keySize, err := jweContentKeySize(enc)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if isJWEGCM(enc) {
+		block, err := aes.NewCipher(cek)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		iv = make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(iv); err != nil {
+			return nil, nil, nil, err
+		}
+		sealed := gcm.Seal(nil, iv, plaintext, aad)
+		split := len(sealed) - gcm.Overhead()
+		return iv, sealed[:split], sealed[split:], nil
+	}
+
+	macKey, encKey := cek[:keySize/2], cek[keySize/2:]
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	iv = make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, nil, err
+	}
+
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(append([]byte{}, plaintext...), make([]byte, padding)...)
+	for i := len(plaintext); i < len(padded); i++ {
+		padded[i] = byte(padding)
+	}
+	ciphertext = make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+	return iv, ciphertext, jweCBCTag(enc, macKey, aad, iv, ciphertext), nil
+}
+
+// jweCBCTag computes the AES-CBC-HMAC authentication tag (RFC 7518 §5.2.2.1)
+func jweCBCTag(enc string, macKey, aad, iv, ciphertext []byte) []byte {
+	al := make([]byte, 8)
+	binary.BigEndian.PutUint64(al, uint64(len(aad))*8)
+
+	mac := hmac.New(jweCBCHash(enc), macKey)
+	mac.Write(aad)
+	mac.Write(iv)
+	mac.Write(ciphertext)
+	mac.Write(al)
+	return mac.Sum(nil)[:len(macKey)]
+}
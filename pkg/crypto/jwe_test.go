@@ -0,0 +1,65 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+)
+
+func TestJWERoundTrip(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	plaintext := []byte(`{"scope":"openid profile"}`)
+
+	for _, alg := range SupportedJWEAlgorithms {
+		for _, enc := range SupportedJWEEncryptions {
+			token, err := EncryptJWE(plaintext, &key.PublicKey, alg, enc, "kid-1", "JWT")
+			if err != nil {
+				t.Fatalf("%s/%s: encrypt failed: %v", alg, enc, err)
+			}
+			if !IsJWE(token) {
+				t.Fatalf("%s/%s: expected compact JWE, got %q", alg, enc, token)
+			}
+			decrypted, err := DecryptJWE(token, key)
+			if err != nil {
+				t.Fatalf("%s/%s: decrypt failed: %v", alg, enc, err)
+			}
+			if string(decrypted) != string(plaintext) {
+				t.Errorf("%s/%s: got %q, want %q", alg, enc, decrypted, plaintext)
+			}
+		}
+	}
+}
+
+func TestDecryptJWE_RejectsTampering(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	for _, enc := range []string{JWEEncA128CBCHS256, JWEEncA256GCM} {
+		token, err := EncryptJWE([]byte("payload"), &key.PublicKey, JWEAlgRSAOAEP256, enc, "", "")
+		if err != nil {
+			t.Fatalf("%s: encrypt failed: %v", enc, err)
+		}
+		parts := strings.Split(token, ".")
+		// Flip the first character of the authentication tag
+		if parts[4][0] == 'A' {
+			parts[4] = "B" + parts[4][1:]
+		} else {
+			parts[4] = "A" + parts[4][1:]
+		}
+		if _, err := DecryptJWE(strings.Join(parts, "."), key); err == nil {
+			t.Errorf("%s: expected tampered JWE to be rejected", enc)
+		}
+	}
+
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	token, _ := EncryptJWE([]byte("payload"), &key.PublicKey, JWEAlgRSAOAEP, JWEEncA128GCM, "", "")
+	if _, err := DecryptJWE(token, other); err == nil {
+		t.Error("expected JWE for another key to be rejected")
+	}
+}
@@ -22,6 +22,9 @@ type JWTManager struct {
 	issuer     string
 	expiry     time.Duration
 	keyID      string // Key ID for JWT header
+
+	idTokenExpiry   time.Duration // ID token lifetime; expiry is used when zero
+	minimalIDTokens bool          // Leave scope-based user claims out of ID tokens
 }
 
 // NewJWTManager creates a new JWT manager
@@ -82,6 +85,45 @@ func NewJWTManagerForTesting(issuer string, expiryMinutes int) (*JWTManager, err
 	}, nil
 }
 
+// WithSigningKey returns a copy of the manager that signs tokens with the given key
+// and advertises kid in the JWT header, e.g. for a client pinned to a dedicated key
+func (jm *JWTManager) WithSigningKey(kid string, privateKey *rsa.PrivateKey) *JWTManager {
+	pinned := *jm
+	pinned.keyID = kid
+	pinned.privateKey = privateKey
+	pinned.publicKey = &privateKey.PublicKey
+	return &pinned
+}
+
+// WithIDTokenExpiry returns a copy of the manager that issues ID tokens valid for
+// expiry instead of the access token lifetime
+func (jm *JWTManager) WithIDTokenExpiry(expiry time.Duration) *JWTManager {
+	m := *jm
+	m.idTokenExpiry = expiry
+	return &m
+}
+
+// WithMinimalIDTokens returns a copy of the manager whose ID tokens carry only the
+// subject and authentication claims when minimal is true, leaving user data to userinfo
+func (jm *JWTManager) WithMinimalIDTokens(minimal bool) *JWTManager {
+	m := *jm
+	m.minimalIDTokens = minimal
+	return &m
+}
+
+// idTokenLifetime returns how long ID tokens issued by the manager are valid
+func (jm *JWTManager) idTokenLifetime() time.Duration {
+	if jm.idTokenExpiry > 0 {
+		return jm.idTokenExpiry
+	}
+	return jm.expiry
+}
+
+// KeyID returns the kid placed in the header of tokens the manager signs
+func (jm *JWTManager) KeyID() string {
+	return jm.keyID
+}
+
 // IDTokenClaims represents OpenID Connect ID Token claims
 type IDTokenClaims struct {
 	jwt.RegisteredClaims
@@ -109,7 +151,7 @@ func (jm *JWTManager) GenerateIDToken(user *models.User, clientID, nonce, scope
 			Issuer:    jm.issuer,
 			Subject:   user.ID,
 			Audience:  jwt.ClaimStrings{clientID},
-			ExpiresAt: jwt.NewNumericDate(now.Add(jm.expiry)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(jm.idTokenLifetime())),
 			IssuedAt:  jwt.NewNumericDate(now),
 		},
 		Nonce: nonce,
@@ -135,7 +177,7 @@ func (jm *JWTManager) GenerateIDTokenWithClaims(user *models.User, clientID, non
 			Issuer:    jm.issuer,
 			Subject:   user.ID,
 			Audience:  jwt.ClaimStrings{clientID},
-			ExpiresAt: jwt.NewNumericDate(now.Add(jm.expiry)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(jm.idTokenLifetime())),
 			IssuedAt:  jwt.NewNumericDate(now),
 		},
 		Nonce:    nonce,
@@ -164,7 +206,7 @@ func (jm *JWTManager) GenerateIDTokenWithClaims(user *models.User, clientID, non
 
 // applyScopes filters claims based on requested scopes (OIDC Core 1.0 Section 5.4)
 func (jm *JWTManager) applyScopes(claims *IDTokenClaims, user *models.User, scopeString string) {
-	if scopeString == "" {
+	if scopeString == "" || jm.minimalIDTokens {
 		return
 	}
 
@@ -247,6 +289,27 @@ func (jm *JWTManager) GetPublicKey() *rsa.PublicKey {
 	return jm.publicKey
 }
 
+// EncryptionKeyID is the kid under which the fallback encryption public key is published in JWKS
+const EncryptionKeyID = "default-enc"
+
+// GetEncryptionPublicKey returns the fallback public key for encrypting request objects.
+// It shares the signing key pair and is only used until a dedicated encryption key exists.
+func (jm *JWTManager) GetEncryptionPublicKey() *rsa.PublicKey {
+	return jm.publicKey
+}
+
+// DecryptJWE decrypts a compact JWE addressed to the server's encryption key
+func (jm *JWTManager) DecryptJWE(token string) ([]byte, error) {
+	header, err := ParseJWEHeader(token)
+	if err != nil {
+		return nil, err
+	}
+	if header.Kid != "" && header.Kid != EncryptionKeyID {
+		return nil, fmt.Errorf("unknown encryption key: %s", header.Kid)
+	}
+	return DecryptJWE(token, jm.privateKey)
+}
+
 // loadPrivateKey loads an RSA private key from a PEM file
 func loadPrivateKey(path string) (*rsa.PrivateKey, error) {
 	keyData, err := os.ReadFile(path)
@@ -376,5 +439,9 @@ func ValidateAdminToken(tokenString string, secret []byte) (jwt.MapClaims, error
 	if !ok || !token.Valid {
 		return nil, fmt.Errorf("invalid token claims")
 	}
+	// Other tokens signed with the admin secret, such as secret reveal tokens, carry no role
+	if role, _ := claims["role"].(string); role != "admin" {
+		return nil, fmt.Errorf("not an admin token")
+	}
 	return claims, nil
 }
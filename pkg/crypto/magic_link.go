@@ -0,0 +1,68 @@
+package crypto
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// MagicLinkClaims are carried by the one-time sign-in link sent by email
+type MagicLinkClaims struct {
+	jwt.RegisteredClaims
+	AuthSessionID string `json:"auth_session,omitempty"`
+}
+
+// magicLinkAudience keeps magic-link tokens from being accepted as any other kind of JWT
+func (jm *JWTManager) magicLinkAudience() string {
+	return jm.issuer + "/login/magic"
+}
+
+// GenerateMagicLinkToken signs a single-use sign-in token for userID that resumes
+// the given authorization session
+func (jm *JWTManager) GenerateMagicLinkToken(userID, authSessionID string, ttl time.Duration) (string, error) {
+	jti, err := GenerateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := MagicLinkClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jm.issuer,
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{jm.magicLinkAudience()},
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        jti,
+		},
+		AuthSessionID: authSessionID,
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = jm.keyID
+	return token.SignedString(jm.privateKey)
+}
+
+// ValidateMagicLinkToken verifies a magic-link token's signature, audience and expiry.
+// Callers are responsible for enforcing single use through the jti.
+func (jm *JWTManager) ValidateMagicLinkToken(tokenString string) (*MagicLinkClaims, error) {
+	claims := &MagicLinkClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return jm.publicKey, nil
+	},
+		jwt.WithIssuer(jm.issuer),
+		jwt.WithAudience(jm.magicLinkAudience()),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" || claims.ID == "" {
+		return nil, fmt.Errorf("magic link token is missing sub or jti")
+	}
+	return claims, nil
+}
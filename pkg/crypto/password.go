@@ -0,0 +1,157 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms
+const (
+	PasswordHashBcrypt   = "bcrypt"
+	PasswordHashArgon2id = "argon2id"
+)
+
+// argon2id hashes are stored in the PHC string format, e.g.
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>, with unpadded base64 salt and key
+const (
+	argon2idPrefix    = "$argon2id$"
+	argon2idSaltBytes = 16
+	argon2idKeyBytes  = 32
+)
+
+// PasswordHashParams choose the algorithm and cost of new password hashes. Zero
+// costs get the values in DefaultPasswordHashParams.
+type PasswordHashParams struct {
+	Algorithm         string // PasswordHashBcrypt or PasswordHashArgon2id
+	BcryptCost        int
+	Argon2Memory      uint32 // KiB
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+}
+
+// DefaultPasswordHashParams are bcrypt at its default cost, and for argon2id the
+// OWASP recommended minimum of 19 MiB, 2 iterations and 1 thread
+var DefaultPasswordHashParams = PasswordHashParams{
+	Algorithm:         PasswordHashBcrypt,
+	BcryptCost:        bcrypt.DefaultCost,
+	Argon2Memory:      19 * 1024,
+	Argon2Iterations:  2,
+	Argon2Parallelism: 1,
+}
+
+// withDefaults fills in the costs left zero
+func (p PasswordHashParams) withDefaults() PasswordHashParams {
+	if p.Algorithm == "" {
+		p.Algorithm = DefaultPasswordHashParams.Algorithm
+	}
+	if p.BcryptCost == 0 {
+		p.BcryptCost = DefaultPasswordHashParams.BcryptCost
+	}
+	if p.Argon2Memory == 0 {
+		p.Argon2Memory = DefaultPasswordHashParams.Argon2Memory
+	}
+	if p.Argon2Iterations == 0 {
+		p.Argon2Iterations = DefaultPasswordHashParams.Argon2Iterations
+	}
+	if p.Argon2Parallelism == 0 {
+		p.Argon2Parallelism = DefaultPasswordHashParams.Argon2Parallelism
+	}
+	return p
+}
+
+// HashPassword hashes a password with DefaultPasswordHashParams
+func HashPassword(password string) (string, error) {
+	return HashPasswordWith(password, DefaultPasswordHashParams)
+}
+
+// HashPasswordWith hashes a password with the given algorithm and cost
+func HashPasswordWith(password string, params PasswordHashParams) (string, error) {
+	params = params.withDefaults()
+	switch params.Algorithm {
+	case PasswordHashBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), params.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	case PasswordHashArgon2id:
+		salt := make([]byte, argon2idSaltBytes)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, params.Argon2Iterations, params.Argon2Memory, params.Argon2Parallelism, argon2idKeyBytes)
+		return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+			params.Argon2Memory, params.Argon2Iterations, params.Argon2Parallelism,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	default:
+		return "", fmt.Errorf("unsupported password hashing algorithm: %s", params.Algorithm)
+	}
+}
+
+// ValidatePassword validates a password against a bcrypt or argon2id hash
+func ValidatePassword(password, hash string) bool {
+	if strings.HasPrefix(hash, argon2idPrefix) {
+		params, salt, key, err := parseArgon2idHash(hash)
+		if err != nil {
+			return false
+		}
+		computed := argon2.IDKey([]byte(password), salt, params.Argon2Iterations, params.Argon2Memory, params.Argon2Parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(computed, key) == 1
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return err == nil
+}
+
+// PasswordNeedsRehash reports whether a hash was made with another algorithm or
+// a lower cost than params, so it should be replaced the next time the password
+// is known
+func PasswordNeedsRehash(hash string, params PasswordHashParams) bool {
+	params = params.withDefaults()
+	switch params.Algorithm {
+	case PasswordHashBcrypt:
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost < params.BcryptCost
+	case PasswordHashArgon2id:
+		current, _, _, err := parseArgon2idHash(hash)
+		return err != nil ||
+			current.Argon2Memory < params.Argon2Memory ||
+			current.Argon2Iterations < params.Argon2Iterations ||
+			current.Argon2Parallelism < params.Argon2Parallelism
+	default:
+		return false // Hashing would fail, so keep the hash that works
+	}
+}
+
+// parseArgon2idHash reads the parameters, salt and key of an argon2id PHC string
+func parseArgon2idHash(hash string) (PasswordHashParams, []byte, []byte, error) {
+	params := PasswordHashParams{Algorithm: PasswordHashArgon2id}
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != PasswordHashArgon2id {
+		return params, nil, nil, fmt.Errorf("malformed argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Argon2Memory, &params.Argon2Iterations, &params.Argon2Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id parameters: %w", err)
+	}
+	if params.Argon2Iterations == 0 || params.Argon2Parallelism == 0 {
+		return params, nil, nil, fmt.Errorf("malformed argon2id parameters")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("malformed argon2id key")
+	}
+	return params, salt, key, nil
+}
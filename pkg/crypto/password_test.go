@@ -0,0 +1,66 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+)
+
+// cheapArgon2id keeps the tests fast
+var cheapArgon2id = PasswordHashParams{Algorithm: PasswordHashArgon2id, Argon2Memory: 64, Argon2Iterations: 1, Argon2Parallelism: 1}
+
+func TestHashPasswordArgon2id(t *testing.T) {
+	hash, err := HashPasswordWith("secret", cheapArgon2id)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("unexpected hash format: %s", hash)
+	}
+	if !ValidatePassword("secret", hash) {
+		t.Error("Valid password should be accepted")
+	}
+	if ValidatePassword("wrong", hash) {
+		t.Error("Invalid password should be rejected")
+	}
+
+	again, _ := HashPasswordWith("secret", cheapArgon2id)
+	if again == hash {
+		t.Error("Hashes of the same password should use different salts")
+	}
+
+	// Malformed hashes and unsupported versions never match
+	for _, malformed := range []string{"$argon2id$", "$argon2id$v=19$m=64,t=1,p=1$salt", "$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5", "$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$a2V5"} {
+		if ValidatePassword("secret", malformed) {
+			t.Errorf("malformed hash %q should be rejected", malformed)
+		}
+	}
+
+	if _, err := HashPasswordWith("secret", PasswordHashParams{Algorithm: "md5"}); err == nil {
+		t.Error("unknown algorithms should be refused")
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	bcryptHash, _ := HashPasswordWith("secret", PasswordHashParams{Algorithm: PasswordHashBcrypt, BcryptCost: 4})
+	argonHash, _ := HashPasswordWith("secret", cheapArgon2id)
+
+	tests := []struct {
+		name   string
+		hash   string
+		params PasswordHashParams
+		want   bool
+	}{
+		{"same bcrypt cost", bcryptHash, PasswordHashParams{Algorithm: PasswordHashBcrypt, BcryptCost: 4}, false},
+		{"higher bcrypt cost", bcryptHash, PasswordHashParams{BcryptCost: 5}, true},
+		{"bcrypt to argon2id", bcryptHash, cheapArgon2id, true},
+		{"same argon2id parameters", argonHash, cheapArgon2id, false},
+		{"more argon2id memory", argonHash, PasswordHashParams{Algorithm: PasswordHashArgon2id, Argon2Memory: 128, Argon2Iterations: 1, Argon2Parallelism: 1}, true},
+		{"argon2id to bcrypt", argonHash, PasswordHashParams{Algorithm: PasswordHashBcrypt}, true},
+		{"unknown algorithm", bcryptHash, PasswordHashParams{Algorithm: "md5"}, false},
+	}
+	for _, tt := range tests {
+		if got := PasswordNeedsRehash(tt.hash, tt.params); got != tt.want {
+			t.Errorf("%s: PasswordNeedsRehash = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package crypto
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// secretRevealAudience keeps reveal tokens from being accepted as admin session tokens
+const secretRevealAudience = "admin:client-secret-reveal"
+
+// SecretRevealClaims authorize one administrator to read one client's secret once
+type SecretRevealClaims struct {
+	jwt.RegisteredClaims
+	ClientID string `json:"client_id"`
+}
+
+// GenerateSecretRevealToken signs a short-lived token allowing username to read the
+// secret of clientID. The secret must match the one used for admin tokens.
+func GenerateSecretRevealToken(username, clientID string, ttl time.Duration, secret []byte) (string, error) {
+	jti, err := GenerateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := SecretRevealClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   username,
+			Audience:  jwt.ClaimStrings{secretRevealAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        jti,
+		},
+		ClientID: clientID,
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+// ValidateSecretRevealToken verifies a reveal token's signature, audience and expiry.
+// Callers are responsible for enforcing single use through the jti.
+func ValidateSecretRevealToken(tokenString string, secret []byte) (*SecretRevealClaims, error) {
+	claims := &SecretRevealClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return secret, nil
+	},
+		jwt.WithAudience(secretRevealAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" || claims.ClientID == "" || claims.ID == "" {
+		return nil, fmt.Errorf("reveal token is missing sub, client_id or jti")
+	}
+	return claims, nil
+}
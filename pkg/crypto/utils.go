@@ -1,6 +1,8 @@
 package crypto
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -11,33 +13,60 @@ import (
 	"encoding/pem"
 	"fmt"
 	"math/big"
+	"strings"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
-// HashPassword hashes a password using bcrypt
-func HashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
+// GenerateRandomString generates a cryptographically secure random string
+func GenerateRandomString(length int) (string, error) {
+	bytes := make([]byte, length)
+	if _, err := rand.Read(bytes); err != nil {
 		return "", err
 	}
-	return string(hash), nil
+	return base64.URLEncoding.EncodeToString(bytes)[:length], nil
 }
 
-// ValidatePassword validates a password against a hash
-func ValidatePassword(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+// Prefixes for opaque credentials, so secret-scanning tools can recognise leaked values
+const (
+	AccessTokenPrefix       = "oidc_at_"
+	RefreshTokenPrefix      = "oidc_rt_"
+	AuthorizationCodePrefix = "oidc_ac_"
+	APIKeyPrefix            = "oidc_sk_"
+)
+
+// DefaultOpaqueTokenLength is the default number of random characters in an
+// opaque token. Each URL-safe base64 character carries 6 bits, so 43 characters
+// give at least 256 bits of entropy.
+const DefaultOpaqueTokenLength = 43
+
+// DetectTokenType returns the kind of opaque credential identified by its prefix:
+// "access_token", "refresh_token", "authorization_code" or "api_key". It returns
+// an empty string when the value does not carry a known prefix.
+func DetectTokenType(token string) string {
+	switch {
+	case strings.HasPrefix(token, AccessTokenPrefix):
+		return "access_token"
+	case strings.HasPrefix(token, RefreshTokenPrefix):
+		return "refresh_token"
+	case strings.HasPrefix(token, AuthorizationCodePrefix):
+		return "authorization_code"
+	case strings.HasPrefix(token, APIKeyPrefix):
+		return "api_key"
+	default:
+		return ""
+	}
 }
 
-// GenerateRandomString generates a cryptographically secure random string
-func GenerateRandomString(length int) (string, error) {
-	bytes := make([]byte, length)
-	if _, err := rand.Read(bytes); err != nil {
+// GenerateOpaqueToken generates a prefixed, URL-safe random token with length random characters
+func GenerateOpaqueToken(prefix string, length int) (string, error) {
+	if length <= 0 {
+		length = DefaultOpaqueTokenLength
+	}
+	random, err := GenerateRandomString(length)
+	if err != nil {
 		return "", err
 	}
-	return base64.URLEncoding.EncodeToString(bytes)[:length], nil
+	return prefix + random, nil
 }
 
 // VerifyCodeChallenge verifies a PKCE code challenge
@@ -72,6 +101,58 @@ type JWKS struct {
 	Keys []JWK `json:"keys"`
 }
 
+// ParseJWKPublicKey converts an RSA or EC JSON Web Key into a public key
+func ParseJWKPublicKey(jwk map[string]interface{}) (interface{}, error) {
+	field := func(name string) ([]byte, error) {
+		v, _ := jwk[name].(string)
+		if v == "" {
+			return nil, fmt.Errorf("jwk missing %s", name)
+		}
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(v, "="))
+	}
+
+	kty, _ := jwk["kty"].(string)
+	switch kty {
+	case "RSA":
+		n, err := field("n")
+		if err != nil {
+			return nil, err
+		}
+		e, err := field("e")
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch crv, _ := jwk["crv"].(string); crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", crv)
+		}
+		x, err := field("x")
+		if err != nil {
+			return nil, err
+		}
+		y, err := field("y")
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if _, err := key.ECDH(); err != nil {
+			return nil, fmt.Errorf("invalid EC public key: %w", err)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", kty)
+	}
+}
+
 // PublicKeyToJWKS converts an RSA public key to JWKS format
 func PublicKeyToJWKS(publicKey *rsa.PublicKey, keyID string) (*JWKS, error) {
 	n := base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
@@ -89,6 +170,18 @@ func PublicKeyToJWKS(publicKey *rsa.PublicKey, keyID string) (*JWKS, error) {
 	return &JWKS{Keys: []JWK{jwk}}, nil
 }
 
+// EncryptionPublicKeyToJWK builds a use=enc JWK entry for an RSA encryption key
+func EncryptionPublicKeyToJWK(publicKey *rsa.PublicKey, keyID string) JWK {
+	return JWK{
+		Kty: "RSA",
+		Use: "enc",
+		Kid: keyID,
+		Alg: JWEAlgRSAOAEP256,
+		N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	}
+}
+
 // PublicKeyToJWKWithCert builds a JWK entry including x5c and x5t#S256 from a PEM cert.
 func PublicKeyToJWKWithCert(publicKey *rsa.PublicKey, keyID, certPEM string) (JWK, error) {
 	n := base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
@@ -248,6 +341,41 @@ func ParseCertFromPEM(certPEM string) (*x509.Certificate, error) {
 	return x509.ParseCertificate(block.Bytes)
 }
 
+// GenerateEncryptionKey generates a 2048-bit RSA key pair for RSA-OAEP encryption.
+// The KID is the RFC 7638 JWK thumbprint of the public key; no certificate is issued.
+func GenerateEncryptionKey() (*SigningKeyMaterial, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate RSA key: %w", err)
+	}
+
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key: %w", err)
+	}
+
+	// Members in lexicographic order, as RFC 7638 §3.2 requires
+	thumbprintInput := fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`,
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(privateKey.E)).Bytes()),
+		base64.RawURLEncoding.EncodeToString(privateKey.N.Bytes()))
+	sum := sha256.Sum256([]byte(thumbprintInput))
+
+	return &SigningKeyMaterial{
+		PrivateKeyPEM: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+		})),
+		PublicKeyPEM: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDER})),
+		KID:          base64.RawURLEncoding.EncodeToString(sum[:]),
+		NotBefore:    time.Now().UTC(),
+	}, nil
+}
+
+// ParsePrivateKeyFromPEM parses a PEM-encoded RSA private key (PKCS#1 or PKCS#8)
+func ParsePrivateKeyFromPEM(pemData string) (*rsa.PrivateKey, error) {
+	return parsePrivateKeyPEM(pemData)
+}
+
 // ParsePublicKeyFromPEM parses a PEM-encoded RSA public key (PKIX format).
 func ParsePublicKeyFromPEM(pemData string) (*rsa.PublicKey, error) {
 	block, _ := pem.Decode([]byte(pemData))
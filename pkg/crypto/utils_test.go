@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -136,3 +137,29 @@ func TestCalculateTokenHash(t *testing.T) {
 		}
 	})
 }
+
+func TestGenerateOpaqueToken(t *testing.T) {
+	token, err := GenerateOpaqueToken(AccessTokenPrefix, 0)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	if !strings.HasPrefix(token, AccessTokenPrefix) {
+		t.Errorf("Token %q should start with %q", token, AccessTokenPrefix)
+	}
+	random := strings.TrimPrefix(token, AccessTokenPrefix)
+	if len(random) != DefaultOpaqueTokenLength {
+		t.Errorf("Expected %d random characters, got %d", DefaultOpaqueTokenLength, len(random))
+	}
+	if strings.ContainsAny(random, "+/=") {
+		t.Errorf("Token %q should be URL-safe", token)
+	}
+
+	other, _ := GenerateOpaqueToken(AccessTokenPrefix, 64)
+	if len(other) != len(AccessTokenPrefix)+64 {
+		t.Errorf("Expected configured length 64, got %d", len(other)-len(AccessTokenPrefix))
+	}
+	if other == token {
+		t.Error("Tokens should be unique")
+	}
+}
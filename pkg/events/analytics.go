@@ -0,0 +1,166 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/outbound"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+// analyticsPageSize is how many audit entries are read per query when counting
+const analyticsPageSize = 500
+
+// AnalyticsDay holds the counts for one UTC day
+type AnalyticsDay struct {
+	Date     string `json:"date"` // YYYY-MM-DD
+	Logins   int    `json:"logins"`
+	NewUsers int    `json:"new_users"`
+	Tokens   int    `json:"tokens"`
+	Failures int    `json:"failures"` // Audit entries with status failure, including failed logins
+}
+
+// Analytics summarises the activity between Start and End, day by day
+type Analytics struct {
+	Start time.Time      `json:"start"`
+	End   time.Time      `json:"end"` // Exclusive
+	Days  []AnalyticsDay `json:"days"`
+	Total AnalyticsDay   `json:"total"` // Date is empty
+}
+
+// ComputeAnalytics counts sign-ins, new users, issued tokens and failures from the
+// audit log and user records. start and end are truncated to UTC days; the
+// counts cover [start, end).
+func ComputeAnalytics(store storage.Storage, start, end time.Time) (*Analytics, error) {
+	start = start.UTC().Truncate(24 * time.Hour)
+	end = end.UTC().Truncate(24 * time.Hour)
+	a := &Analytics{Start: start, End: end, Days: []AnalyticsDay{}}
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		a.Days = append(a.Days, AnalyticsDay{Date: d.Format(time.DateOnly)})
+	}
+	day := func(t time.Time) *AnalyticsDay {
+		t = t.UTC()
+		if t.Before(start) || !t.Before(end) {
+			return nil
+		}
+		return &a.Days[int(t.Sub(start)/(24*time.Hour))]
+	}
+
+	// Audit entries come newest first, so paging stops at the first one before start
+	for offset := 0; ; offset += analyticsPageSize {
+		entries, err := store.GetAuditLogs(models.AuditFilter{Limit: analyticsPageSize, Offset: offset})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %w", err)
+		}
+		for _, e := range entries {
+			d := day(e.Timestamp)
+			if d == nil {
+				continue
+			}
+			switch {
+			case e.Status == models.AuditStatusFailure:
+				d.Failures++
+			case e.Action == models.AuditActionLogin:
+				d.Logins++
+			case e.Action == models.AuditActionTokenIssued:
+				d.Tokens++
+			}
+		}
+		if len(entries) < analyticsPageSize || entries[len(entries)-1].Timestamp.Before(start) {
+			break
+		}
+	}
+
+	users, err := store.GetAllUsers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	for _, u := range users {
+		if d := day(u.CreatedAt); d != nil {
+			d.NewUsers++
+		}
+	}
+
+	for _, d := range a.Days {
+		a.Total.Logins += d.Logins
+		a.Total.NewUsers += d.NewUsers
+		a.Total.Tokens += d.Tokens
+		a.Total.Failures += d.Failures
+	}
+	return a, nil
+}
+
+// value returns the count of metric for the day
+func (d AnalyticsDay) value(metric string) int {
+	switch metric {
+	case configstore.ReportMetricLogins:
+		return d.Logins
+	case configstore.ReportMetricNewUsers:
+		return d.NewUsers
+	case configstore.ReportMetricTokens:
+		return d.Tokens
+	case configstore.ReportMetricFailures:
+		return d.Failures
+	}
+	return 0
+}
+
+// FormatAnalytics renders a as CSV, one row per day and a total row, or as JSON,
+// limited to metrics. It returns the content type of the result.
+func FormatAnalytics(a *Analytics, format string, metrics []string) ([]byte, string, error) {
+	switch format {
+	case configstore.ReportFormatCSV:
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		row := func(date string, d AnalyticsDay) []string {
+			record := []string{date}
+			for _, m := range metrics {
+				record = append(record, strconv.Itoa(d.value(m)))
+			}
+			return record
+		}
+		_ = w.Write(append([]string{"date"}, metrics...))
+		for _, d := range a.Days {
+			_ = w.Write(row(d.Date, d))
+		}
+		_ = w.Write(row("total", a.Total))
+		w.Flush()
+		return buf.Bytes(), "text/csv; charset=utf-8", w.Error()
+
+	case configstore.ReportFormatJSON:
+		counts := func(d AnalyticsDay) map[string]interface{} {
+			m := map[string]interface{}{}
+			for _, metric := range metrics {
+				m[metric] = d.value(metric)
+			}
+			return m
+		}
+		days := []map[string]interface{}{}
+		for _, d := range a.Days {
+			m := counts(d)
+			m["date"] = d.Date
+			days = append(days, m)
+		}
+		body, err := json.MarshalIndent(map[string]interface{}{
+			"start": a.Start,
+			"end":   a.End,
+			"days":  days,
+			"total": counts(a.Total),
+		}, "", "  ")
+		return body, "application/json", err
+	}
+	return nil, "", fmt.Errorf("unknown report format %q", format)
+}
+
+// PostReport sends a formatted report to a webhook. authHeader, if set, is sent
+// as the Authorization header.
+func PostReport(ctx context.Context, endpoint, authHeader, contentType string, body []byte) error {
+	return postEvent(ctx, outbound.NewClient(outbound.Configured, defaultTimeout), endpoint, authHeader, contentType, body)
+}
@@ -0,0 +1,80 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+func TestComputeAnalytics(t *testing.T) {
+	store, err := storage.NewJSONStorage(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	yesterday := today.AddDate(0, 0, -1)
+	audit := func(id string, at time.Time, action models.AuditAction, status models.AuditStatus) {
+		require.NoError(t, store.CreateAuditLog(&models.AuditLog{ID: id, Timestamp: at, Action: action, Status: status}))
+	}
+	audit("old", yesterday.Add(-time.Hour), models.AuditActionLogin, models.AuditStatusSuccess)
+	audit("y1", yesterday.Add(time.Hour), models.AuditActionLogin, models.AuditStatusSuccess)
+	audit("y2", yesterday.Add(2*time.Hour), models.AuditActionLoginFailed, models.AuditStatusFailure)
+	audit("y3", yesterday.Add(3*time.Hour), models.AuditActionTokenIssued, models.AuditStatusSuccess)
+	audit("t1", today.Add(time.Minute), models.AuditActionTokenIssued, models.AuditStatusSuccess)
+	audit("t2", today.Add(2*time.Minute), models.AuditActionTokenIssued, models.AuditStatusSuccess)
+	require.NoError(t, store.CreateUser(&models.User{ID: "new-user", Username: "new"}))
+
+	a, err := ComputeAnalytics(store, yesterday, today.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, []AnalyticsDay{
+		{Date: yesterday.Format(time.DateOnly), Logins: 1, Tokens: 1, Failures: 1},
+		{Date: today.Format(time.DateOnly), Tokens: 2, NewUsers: 1},
+	}, a.Days)
+	assert.Equal(t, AnalyticsDay{Logins: 1, Tokens: 3, Failures: 1, NewUsers: 1}, a.Total)
+
+	body, contentType, err := FormatAnalytics(a, configstore.ReportFormatCSV, []string{"logins", "tokens"})
+	require.NoError(t, err)
+	assert.Equal(t, "text/csv; charset=utf-8", contentType)
+	assert.Equal(t, "date,logins,tokens\n"+
+		yesterday.Format(time.DateOnly)+",1,1\n"+
+		today.Format(time.DateOnly)+",0,2\n"+
+		"total,1,3\n", string(body))
+
+	body, contentType, err = FormatAnalytics(a, configstore.ReportFormatJSON, configstore.ReportMetrics)
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", contentType)
+	var report struct {
+		Days  []map[string]interface{} `json:"days"`
+		Total map[string]int           `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(body, &report))
+	assert.Len(t, report.Days, 2)
+	assert.Equal(t, map[string]int{"logins": 1, "new_users": 1, "tokens": 3, "failures": 1}, report.Total)
+
+	_, _, err = FormatAnalytics(a, "xml", configstore.ReportMetrics)
+	assert.Error(t, err)
+}
+
+func TestPostReport(t *testing.T) {
+	var got, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got, auth = string(b), r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	require.NoError(t, PostReport(context.Background(), srv.URL, "Bearer s3cret", "text/csv", []byte("date,logins\n")))
+	assert.Equal(t, "date,logins\n", got)
+	assert.Equal(t, "Bearer s3cret", auth)
+}
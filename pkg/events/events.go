@@ -0,0 +1,237 @@
+// Package events streams security events to external collectors such as a SIEM,
+// over syslog, HTTP or Kafka, in CEF or JSON Lines format.
+package events
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// Format names accepted in the exporter configuration
+const (
+	FormatNameCEF  = "cef"
+	FormatNameJSON = "json"
+)
+
+const (
+	defaultBufferSize = 1000
+	defaultTimeout    = 5 * time.Second
+)
+
+// defaultActions are exported when an exporter lists no action prefixes:
+// authentication and token events
+var defaultActions = []string{"user.", "token."}
+
+// ExporterStats counts what happened to the events offered to an exporter
+type ExporterStats struct {
+	Name    string `json:"name"`
+	Sent    int64  `json:"sent"`
+	Failed  int64  `json:"failed"`
+	Dropped int64  `json:"dropped"` // Discarded because the exporter's buffer was full
+}
+
+// exporter delivers matching events to one sink from its own queue
+type exporter struct {
+	name    string
+	actions []string
+	format  Formatter
+	sink    Sink
+	timeout time.Duration
+	version string
+	queue   chan *models.AuditLog
+
+	sent, failed, dropped atomic.Int64
+}
+
+func (e *exporter) matches(action models.AuditAction) bool {
+	for _, prefix := range e.actions {
+		if strings.HasPrefix(string(action), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *exporter) run(wg *sync.WaitGroup) {
+	defer wg.Done()
+	failing := false
+	for entry := range e.queue {
+		line, err := e.format(entry, e.version)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+			err = e.sink.Send(ctx, entry, line)
+			cancel()
+		}
+		if err != nil {
+			e.failed.Add(1)
+			// Only the first failure of a streak is logged so an unreachable collector doesn't flood the log
+			if !failing {
+				log.Printf("Warning: Event exporter %s failed to deliver event %s: %v", e.name, entry.ID, err)
+			}
+			failing = true
+			continue
+		}
+		if failing {
+			log.Printf("Event exporter %s recovered", e.name)
+		}
+		failing = false
+		e.sent.Add(1)
+	}
+}
+
+// Stream fans audit entries out to the configured exporters
+type Stream struct {
+	exporters []*exporter
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewStream creates a stream for the configured exporters and starts delivering.
+// version is reported as the product version in CEF headers. It returns nil when
+// no exporters are configured.
+func NewStream(cfg configstore.EventsConfig, version string) (*Stream, error) {
+	if len(cfg.Exporters) == 0 {
+		return nil, nil
+	}
+	bufferSize := cfg.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+
+	s := &Stream{}
+	for _, ec := range cfg.Exporters {
+		e, err := newExporter(ec, bufferSize, version)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("event exporter %s: %w", ec.Name, err)
+		}
+		s.exporters = append(s.exporters, e)
+	}
+	for _, e := range s.exporters {
+		s.wg.Add(1)
+		go e.run(&s.wg)
+	}
+	return s, nil
+}
+
+func newExporter(cfg configstore.EventExporterConfig, bufferSize int, version string) (*exporter, error) {
+	format := cfg.Format
+	if format == "" {
+		format = FormatNameJSON
+		if cfg.Type == "syslog" {
+			format = FormatNameCEF
+		}
+	}
+	var formatter Formatter
+	switch format {
+	case FormatNameCEF:
+		formatter = FormatCEF
+	case FormatNameJSON:
+		formatter = FormatJSON
+	default:
+		return nil, fmt.Errorf("unknown format %q", cfg.Format)
+	}
+
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	var sink Sink
+	switch cfg.Type {
+	case "syslog":
+		if cfg.Address == "" {
+			return nil, fmt.Errorf("address is required")
+		}
+		syslog, err := NewSyslogSink(cfg.Address)
+		if err != nil {
+			return nil, err
+		}
+		sink = syslog
+	case "http":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("url is required")
+		}
+		sink = NewHTTPSink(cfg.URL, cfg.AuthHeader, format, timeout)
+	case "kafka":
+		if cfg.URL == "" || cfg.Topic == "" {
+			return nil, fmt.Errorf("url and topic are required")
+		}
+		sink = NewKafkaSink(cfg.URL, cfg.Topic, cfg.AuthHeader, format, timeout)
+	default:
+		return nil, fmt.Errorf("unknown type %q", cfg.Type)
+	}
+
+	actions := cfg.Actions
+	if len(actions) == 0 {
+		actions = defaultActions
+	}
+	return &exporter{
+		name:    cfg.Name,
+		actions: actions,
+		format:  formatter,
+		sink:    sink,
+		timeout: timeout,
+		version: version,
+		queue:   make(chan *models.AuditLog, bufferSize),
+	}, nil
+}
+
+// Publish queues entry for every exporter interested in its action. It never
+// blocks: an exporter whose buffer is full drops the event and counts it.
+func (s *Stream) Publish(entry *models.AuditLog) {
+	if s == nil {
+		return
+	}
+	for _, e := range s.exporters {
+		if !e.matches(entry.Action) {
+			continue
+		}
+		select {
+		case e.queue <- entry:
+		default:
+			e.dropped.Add(1)
+		}
+	}
+}
+
+// Stats returns delivery counters for each exporter
+func (s *Stream) Stats() []ExporterStats {
+	if s == nil {
+		return nil
+	}
+	stats := make([]ExporterStats, 0, len(s.exporters))
+	for _, e := range s.exporters {
+		stats = append(stats, ExporterStats{
+			Name:    e.name,
+			Sent:    e.sent.Load(),
+			Failed:  e.failed.Load(),
+			Dropped: e.dropped.Load(),
+		})
+	}
+	return stats
+}
+
+// Close delivers the events already queued and releases the sinks
+func (s *Stream) Close() {
+	if s == nil {
+		return
+	}
+	s.closeOnce.Do(func() {
+		for _, e := range s.exporters {
+			close(e.queue)
+		}
+		s.wg.Wait()
+		for _, e := range s.exporters {
+			_ = e.sink.Close()
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+func testEntry(id string, action models.AuditAction) *models.AuditLog {
+	return &models.AuditLog{
+		ID:        id,
+		Timestamp: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Action:    action,
+		Actor:     "alice",
+		ActorType: models.AuditActorUser,
+		Status:    models.AuditStatusFailure,
+		IPAddress: "192.0.2.10",
+		Details:   map[string]interface{}{"client_id": "app", "reason": "a=b|c"},
+	}
+}
+
+func TestFormatCEF(t *testing.T) {
+	line, err := FormatCEF(testEntry("e1", models.AuditActionLogin), "1.2|3")
+	require.NoError(t, err)
+
+	s := string(line)
+	assert.True(t, strings.HasPrefix(s, `CEF:0|prasenjit-net|openid-golang|1.2\|3|user.login|user.login|7|`), s)
+	assert.Contains(t, s, "suser=alice")
+	assert.Contains(t, s, "cs4Label=clientId cs4=app")
+	assert.Contains(t, s, "src=192.0.2.10")
+	assert.Contains(t, s, `msg={"client_id":"app","reason":"a\=b|c"}`)
+	assert.NotContains(t, s, "\n")
+}
+
+func TestSyslogExporter(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	stream, err := NewStream(configstore.EventsConfig{Exporters: []configstore.EventExporterConfig{
+		{Name: "siem", Type: "syslog", Address: conn.LocalAddr().String()},
+	}}, "1.0.0")
+	require.NoError(t, err)
+	defer stream.Close()
+
+	stream.Publish(testEntry("skipped", models.AuditActionAdminClientCreated))
+	stream.Publish(testEntry("e1", models.AuditActionLogin))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, 4096)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	msg := string(buf[:n])
+	assert.True(t, strings.HasPrefix(msg, "<84>1 2026-01-02T03:04:05Z "), msg)
+	assert.Contains(t, msg, " openid-golang ")
+	assert.Contains(t, msg, " user.login - CEF:0|")
+	assert.Contains(t, msg, "externalId=e1")
+}
+
+func TestHTTPExporter(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- string(body)
+	}))
+	defer srv.Close()
+
+	stream, err := NewStream(configstore.EventsConfig{Exporters: []configstore.EventExporterConfig{
+		{Name: "collector", Type: "http", URL: srv.URL, AuthHeader: "Bearer secret"},
+	}}, "1.0.0")
+	require.NoError(t, err)
+
+	stream.Publish(testEntry("e1", models.AuditActionTokenIssued))
+	stream.Close()
+
+	r := <-received
+	assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+	assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+	body := <-bodies
+	assert.True(t, strings.HasSuffix(body, "\n"))
+	var decoded models.AuditLog
+	require.NoError(t, json.Unmarshal([]byte(body), &decoded))
+	assert.Equal(t, "e1", decoded.ID)
+
+	stats := stream.Stats()
+	require.Len(t, stats, 1)
+	assert.EqualValues(t, 1, stats[0].Sent)
+}
+
+func TestKafkaExporter(t *testing.T) {
+	type record struct {
+		Key   string          `json:"key"`
+		Value json.RawMessage `json:"value"`
+	}
+	var path, contentType string
+	var records struct {
+		Records []record `json:"records"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		_ = json.NewDecoder(r.Body).Decode(&records)
+	}))
+	defer srv.Close()
+
+	sink := NewKafkaSink(srv.URL+"/", "security-events", "", FormatNameCEF, time.Second)
+	entry := testEntry("e1", models.AuditActionLogin)
+	line, err := FormatCEF(entry, "1.0.0")
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(t.Context(), entry, line))
+
+	assert.Equal(t, "/topics/security-events", path)
+	assert.Equal(t, kafkaRESTContentType, contentType)
+	require.Len(t, records.Records, 1)
+	assert.Equal(t, "e1", records.Records[0].Key)
+	var value string
+	require.NoError(t, json.Unmarshal(records.Records[0].Value, &value))
+	assert.Equal(t, string(line), value)
+}
+
+func TestStreamDropsWhenFull(t *testing.T) {
+	blocked := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-blocked
+	}))
+	defer srv.Close()
+
+	stream, err := NewStream(configstore.EventsConfig{BufferSize: 1, Exporters: []configstore.EventExporterConfig{
+		{Name: "slow", Type: "http", URL: srv.URL},
+	}}, "1.0.0")
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		stream.Publish(testEntry("e", models.AuditActionLogin))
+	}
+	assert.Positive(t, stream.Stats()[0].Dropped)
+	close(blocked)
+	stream.Close()
+}
+
+func TestNewStreamRejectsInvalidExporter(t *testing.T) {
+	_, err := NewStream(configstore.EventsConfig{Exporters: []configstore.EventExporterConfig{
+		{Name: "bad", Type: "kafka", URL: "http://127.0.0.1:1"},
+	}}, "1.0.0")
+	assert.Error(t, err)
+
+	stream, err := NewStream(configstore.EventsConfig{}, "1.0.0")
+	assert.NoError(t, err)
+	assert.Nil(t, stream)
+}
+
+func TestWrapPublishesAfterCommit(t *testing.T) {
+	bodies := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+	}))
+	defer srv.Close()
+
+	base, err := storage.NewJSONStorage(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+	stream, err := NewStream(configstore.EventsConfig{Exporters: []configstore.EventExporterConfig{
+		{Name: "collector", Type: "http", URL: srv.URL},
+	}}, "1.0.0")
+	require.NoError(t, err)
+	store := Wrap(base, stream)
+
+	err = store.RunInTransaction(func(tx storage.Storage) error {
+		require.NoError(t, tx.CreateAuditLog(testEntry("rolled-back", models.AuditActionLogin)))
+		return errors.New("abort")
+	})
+	require.Error(t, err)
+	require.NoError(t, store.RunInTransaction(func(tx storage.Storage) error {
+		return tx.CreateAuditLog(testEntry("committed", models.AuditActionLogin))
+	}))
+	require.NoError(t, store.CreateAuditLog(testEntry("direct", models.AuditActionLogin)))
+	stream.Close()
+	close(bodies)
+
+	var ids []string
+	for body := range bodies {
+		var entry models.AuditLog
+		require.NoError(t, json.Unmarshal([]byte(body), &entry))
+		ids = append(ids, entry.ID)
+	}
+	assert.Equal(t, []string{"committed", "direct"}, ids)
+}
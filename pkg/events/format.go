@@ -0,0 +1,83 @@
+package events
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+const (
+	cefVendor  = "prasenjit-net"
+	cefProduct = "openid-golang"
+)
+
+// Formatter renders an audit entry as a single line for a collector
+type Formatter func(entry *models.AuditLog, version string) ([]byte, error)
+
+// FormatJSON renders entry as one JSON object, suitable for JSON Lines streams
+func FormatJSON(entry *models.AuditLog, _ string) ([]byte, error) {
+	return json.Marshal(entry)
+}
+
+// FormatCEF renders entry in ArcSight Common Event Format
+func FormatCEF(entry *models.AuditLog, version string) ([]byte, error) {
+	severity := "3"
+	if entry.Status == models.AuditStatusFailure {
+		severity = "7"
+	}
+
+	ext := []string{
+		"rt=" + strconv.FormatInt(entry.Timestamp.UnixMilli(), 10),
+		"act=" + cefValue(string(entry.Action)),
+		"outcome=" + cefValue(string(entry.Status)),
+		"externalId=" + cefValue(entry.ID),
+	}
+	add := func(key, value string) {
+		if value != "" {
+			ext = append(ext, key+"="+cefValue(value))
+		}
+	}
+	add("suser", entry.Actor)
+	add("cs1Label", "actorType")
+	add("cs1", string(entry.ActorType))
+	add("cs2Label", "resource")
+	add("cs2", entry.Resource)
+	add("cs3Label", "resourceId")
+	add("cs3", entry.ResourceID)
+	add("src", entry.IPAddress)
+	add("requestClientApplication", entry.UserAgent)
+	if clientID, ok := entry.Details["client_id"].(string); ok {
+		add("cs4Label", "clientId")
+		add("cs4", clientID)
+	}
+	if len(entry.Details) > 0 {
+		details, err := json.Marshal(entry.Details)
+		if err != nil {
+			return nil, err
+		}
+		add("msg", string(details))
+	}
+
+	header := strings.Join([]string{
+		"CEF:0",
+		cefHeader(cefVendor),
+		cefHeader(cefProduct),
+		cefHeader(version),
+		cefHeader(string(entry.Action)),
+		cefHeader(string(entry.Action)),
+		severity,
+	}, "|")
+	return []byte(header + "|" + strings.Join(ext, " ")), nil
+}
+
+// cefHeader escapes a CEF header field
+func cefHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ").Replace(s)
+}
+
+// cefValue escapes a CEF extension value
+func cefValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`).Replace(s)
+}
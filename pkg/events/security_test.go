@@ -0,0 +1,56 @@
+package events
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+func TestComputeSecurityStats(t *testing.T) {
+	store, err := storage.NewJSONStorage(filepath.Join(t.TempDir(), "data.json"))
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	audit := func(id string, ago time.Duration, action models.AuditAction, ip string) {
+		require.NoError(t, store.CreateAuditLog(&models.AuditLog{
+			ID: id, Timestamp: now.Add(-ago), Action: action, IPAddress: ip, Status: models.AuditStatusFailure,
+		}))
+	}
+	audit("old", 25*time.Hour, models.AuditActionLoginFailed, "192.0.2.1")
+	audit("f1", 3*time.Hour, models.AuditActionLoginFailed, "192.0.2.1")
+	audit("f2", 2*time.Hour, models.AuditActionLoginFailed, "192.0.2.1")
+	audit("c1", 2*time.Hour, models.AuditActionClientAuthFailed, "198.51.100.7")
+	audit("r1", time.Minute, models.AuditActionRateLimited, "192.0.2.1")
+	audit("ok", time.Minute, models.AuditActionLogin, "203.0.113.9")
+
+	lockedUntil := now.Add(time.Hour)
+	expiredLock := now.Add(-time.Hour)
+	require.NoError(t, store.CreateUser(&models.User{ID: "locked", Username: "locked", Email: "locked@example.com", LockedUntil: &lockedUntil}))
+	require.NoError(t, store.CreateUser(&models.User{ID: "unlocked", Username: "unlocked", Email: "unlocked@example.com", LockedUntil: &expiredLock}))
+
+	s, err := ComputeSecurityStats(store, now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 2, s.FailedLogins)
+	assert.Equal(t, 1, s.ClientAuthFailures)
+	assert.Equal(t, 1, s.RateLimited)
+	assert.Equal(t, 1, s.LockedAccounts)
+	assert.Equal(t, 2, s.FailureIPs)
+	assert.Equal(t, []SecuritySource{{IP: "192.0.2.1", Failures: 3}, {IP: "198.51.100.7", Failures: 1}}, s.TopSources)
+
+	require.Len(t, s.Hourly, 25)
+	last := s.Hourly[len(s.Hourly)-1]
+	assert.Equal(t, now.Truncate(time.Hour), last.Hour)
+	total := SecurityHour{}
+	for _, h := range s.Hourly {
+		total.FailedLogins += h.FailedLogins
+		total.ClientAuthFailures += h.ClientAuthFailures
+		total.RateLimited += h.RateLimited
+	}
+	assert.Equal(t, SecurityHour{FailedLogins: 2, ClientAuthFailures: 1, RateLimited: 1}, total)
+}
@@ -0,0 +1,182 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/outbound"
+)
+
+// Sink delivers one formatted event to a collector
+type Sink interface {
+	Send(ctx context.Context, entry *models.AuditLog, line []byte) error
+	Close() error
+}
+
+// syslog facility authpriv (10) and severities, RFC 5424 section 6.2.1
+const (
+	syslogFacilityAuthPriv = 10
+	syslogSeverityWarning  = 4
+	syslogSeverityInfo     = 6
+)
+
+// SyslogSink sends RFC 5424 syslog messages over UDP
+type SyslogSink struct {
+	conn     net.Conn
+	hostname string
+	appName  string
+}
+
+// NewSyslogSink creates a sink sending to the syslog server at address (host:port)
+func NewSyslogSink(address string) (*SyslogSink, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &SyslogSink{conn: conn, hostname: hostname, appName: cefProduct}, nil
+}
+
+// Send writes one syslog datagram
+func (s *SyslogSink) Send(_ context.Context, entry *models.AuditLog, line []byte) error {
+	severity := syslogSeverityInfo
+	if entry.Status == models.AuditStatusFailure {
+		severity = syslogSeverityWarning
+	}
+	header := fmt.Sprintf("<%d>1 %s %s %s %d %s - ",
+		syslogFacilityAuthPriv*8+severity,
+		entry.Timestamp.UTC().Format(time.RFC3339Nano),
+		s.hostname, s.appName, os.Getpid(), syslogMsgID(string(entry.Action)))
+	_, err := s.conn.Write(append([]byte(header), line...))
+	return err
+}
+
+// Close closes the UDP socket
+func (s *SyslogSink) Close() error {
+	return s.conn.Close()
+}
+
+// syslogMsgID turns an action into a valid MSGID (printable ASCII, at most 32 characters)
+func syslogMsgID(action string) string {
+	id := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, action)
+	if id == "" {
+		return "-"
+	}
+	if len(id) > 32 {
+		id = id[:32]
+	}
+	return id
+}
+
+// HTTPSink POSTs each event to a collector as one line of JSON Lines or CEF text
+type HTTPSink struct {
+	url         string
+	authHeader  string
+	contentType string
+	client      *http.Client
+}
+
+// NewHTTPSink creates a sink posting to endpoint. authHeader, if set, is sent as
+// the Authorization header.
+func NewHTTPSink(endpoint, authHeader, format string, timeout time.Duration) *HTTPSink {
+	contentType := "application/x-ndjson"
+	if format == FormatNameCEF {
+		contentType = "text/plain; charset=utf-8"
+	}
+	return &HTTPSink{
+		url:         endpoint,
+		authHeader:  authHeader,
+		contentType: contentType,
+		client:      outbound.NewClient(outbound.Configured, timeout),
+	}
+}
+
+// Send posts the event
+func (s *HTTPSink) Send(ctx context.Context, _ *models.AuditLog, line []byte) error {
+	return postEvent(ctx, s.client, s.url, s.authHeader, s.contentType, append(line, '\n'))
+}
+
+// Close is a no-op
+func (s *HTTPSink) Close() error {
+	return nil
+}
+
+// kafkaRESTContentType is the Confluent REST Proxy v2 media type for JSON records
+const kafkaRESTContentType = "application/vnd.kafka.json.v2+json"
+
+// KafkaSink produces each event to a Kafka topic through a Confluent-compatible REST Proxy
+type KafkaSink struct {
+	url        string
+	authHeader string
+	format     string
+	client     *http.Client
+}
+
+// NewKafkaSink creates a sink producing to topic via the REST Proxy at baseURL
+func NewKafkaSink(baseURL, topic, authHeader, format string, timeout time.Duration) *KafkaSink {
+	return &KafkaSink{
+		url:        strings.TrimRight(baseURL, "/") + "/topics/" + url.PathEscape(topic),
+		authHeader: authHeader,
+		format:     format,
+		client:     outbound.NewClient(outbound.Configured, timeout),
+	}
+}
+
+// Send produces one record keyed by the event ID
+func (s *KafkaSink) Send(ctx context.Context, entry *models.AuditLog, line []byte) error {
+	var value interface{} = string(line)
+	if s.format == FormatNameJSON {
+		value = json.RawMessage(line)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"records": []map[string]interface{}{{"key": entry.ID, "value": value}},
+	})
+	if err != nil {
+		return err
+	}
+	return postEvent(ctx, s.client, s.url, s.authHeader, kafkaRESTContentType, body)
+}
+
+// Close is a no-op
+func (s *KafkaSink) Close() error {
+	return nil
+}
+
+func postEvent(ctx context.Context, client *http.Client, endpoint, authHeader, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
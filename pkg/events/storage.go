@@ -0,0 +1,58 @@
+package events
+
+import (
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+// publishingStorage publishes every audit entry to a Stream once it has been stored
+type publishingStorage struct {
+	storage.Storage
+	stream  *Stream
+	pending *[]*models.AuditLog // Set inside a transaction: entries wait for the commit
+}
+
+// Wrap returns store with every successfully written audit entry also published
+// to stream. Entries written inside RunInTransaction are published only after the
+// transaction commits.
+func Wrap(store storage.Storage, stream *Stream) storage.Storage {
+	if stream == nil {
+		return store
+	}
+	return &publishingStorage{Storage: store, stream: stream}
+}
+
+// CreateAuditLog stores entry and publishes it
+func (s *publishingStorage) CreateAuditLog(entry *models.AuditLog) error {
+	if err := s.Storage.CreateAuditLog(entry); err != nil {
+		return err
+	}
+	if s.pending != nil {
+		*s.pending = append(*s.pending, entry)
+	} else {
+		s.stream.Publish(entry)
+	}
+	return nil
+}
+
+// RunInTransaction holds back the entries written by fn until the transaction commits
+func (s *publishingStorage) RunInTransaction(fn func(tx storage.Storage) error) error {
+	if s.pending != nil {
+		return s.Storage.RunInTransaction(func(tx storage.Storage) error {
+			return fn(&publishingStorage{Storage: tx, stream: s.stream, pending: s.pending})
+		})
+	}
+
+	var pending []*models.AuditLog
+	err := s.Storage.RunInTransaction(func(tx storage.Storage) error {
+		pending = pending[:0] // The backend may retry fn
+		return fn(&publishingStorage{Storage: tx, stream: s.stream, pending: &pending})
+	})
+	if err != nil {
+		return err
+	}
+	for _, entry := range pending {
+		s.stream.Publish(entry)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package federation
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/outbound"
+)
+
+// testEntity is a member of the federation served by testFederation
+type testEntity struct {
+	id           string
+	key          *rsa.PrivateKey
+	hints        []string
+	metadata     map[string]map[string]interface{}
+	subordinates map[string]*EntityStatement // Statements issued about subordinates, by their entity ID
+}
+
+func newTestEntity(t *testing.T, id string) *testEntity {
+	key, _, err := crypto.GenerateRSAKeyPair()
+	require.NoError(t, err)
+	return &testEntity{id: id, key: key, metadata: map[string]map[string]interface{}{}, subordinates: map[string]*EntityStatement{}}
+}
+
+func (e *testEntity) jwks(t *testing.T) map[string]interface{} {
+	jwks, err := crypto.PublicKeyToJWKS(&e.key.PublicKey, e.id+"#key")
+	require.NoError(t, err)
+	data, err := json.Marshal(jwks)
+	require.NoError(t, err)
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &m))
+	return m
+}
+
+func (e *testEntity) sign(t *testing.T, statement *EntityStatement) string {
+	now := time.Now()
+	statement.Issuer = e.id
+	statement.IssuedAt = jwt.NewNumericDate(now)
+	if statement.ExpiresAt == nil {
+		statement.ExpiresAt = jwt.NewNumericDate(now.Add(time.Hour))
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, statement)
+	token.Header["kid"] = e.id + "#key"
+	token.Header["typ"] = crypto.EntityStatementType
+	raw, err := token.SignedString(e.key)
+	require.NoError(t, err)
+	return raw
+}
+
+// testFederation serves the entity configurations and fetch endpoints of entities
+// named by their path below the server URL
+func testFederation(t *testing.T, entities map[string]*testEntity) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, e := range entities {
+			prefix := strings.TrimPrefix(e.id, "http://"+r.Host)
+			switch r.URL.Path {
+			case prefix + ConfigurationPath:
+				_, _ = w.Write([]byte(e.sign(t, &EntityStatement{
+					RegisteredClaims: jwt.RegisteredClaims{Subject: e.id},
+					JWKS:             e.jwks(t),
+					AuthorityHints:   e.hints,
+					Metadata:         e.metadata,
+				})))
+				return
+			case prefix + "/fetch":
+				statement, ok := e.subordinates[r.URL.Query().Get("sub")]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				_, _ = w.Write([]byte(e.sign(t, statement)))
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+}
+
+// setupFederation builds a relying party below an intermediate below a trust anchor
+func setupFederation(t *testing.T) (anchor, intermediate, rp *testEntity, server *httptest.Server) {
+	entities := map[string]*testEntity{}
+	server = testFederation(t, entities)
+	t.Cleanup(server.Close)
+
+	anchor = newTestEntity(t, server.URL+"/anchor")
+	intermediate = newTestEntity(t, server.URL+"/intermediate")
+	rp = newTestEntity(t, server.URL+"/rp")
+	entities["anchor"], entities["intermediate"], entities["rp"] = anchor, intermediate, rp
+
+	// The test server listens on loopback, which entity fetches may not reach by default
+	outbound.Configure(configstore.OutboundHTTPConfig{PrivateNetworkHosts: []string{"127.0.0.1"}})
+	t.Cleanup(func() { outbound.Configure(configstore.OutboundHTTPConfig{}) })
+
+	for _, e := range []*testEntity{anchor, intermediate} {
+		e.metadata[EntityTypeFederationEntity] = map[string]interface{}{federationFetchEndpointParam: e.id + "/fetch"}
+	}
+	intermediate.hints = []string{anchor.id}
+	rp.hints = []string{intermediate.id}
+	rp.metadata[EntityTypeRelyingParty] = map[string]interface{}{
+		"client_name":   "Campus Portal",
+		"redirect_uris": []interface{}{"https://rp.example/callback"},
+		"grant_types":   []interface{}{"authorization_code", "implicit"},
+	}
+
+	anchor.subordinates[intermediate.id] = &EntityStatement{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: intermediate.id},
+		JWKS:             intermediate.jwks(t),
+		MetadataPolicy: map[string]map[string]Policy{EntityTypeRelyingParty: {
+			"grant_types": {"subset_of": []interface{}{"authorization_code", "refresh_token"}},
+			"scope":       {"default": "openid"},
+		}},
+	}
+	intermediate.subordinates[rp.id] = &EntityStatement{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: rp.id},
+		JWKS:             rp.jwks(t),
+		Metadata:         map[string]map[string]interface{}{EntityTypeRelyingParty: {"client_name": "Campus Portal (University)"}},
+		MetadataPolicy: map[string]map[string]Policy{EntityTypeRelyingParty: {
+			"contacts": {"add": "ops@university.example"},
+		}},
+	}
+	return anchor, intermediate, rp, server
+}
+
+func TestResolveTrustChain(t *testing.T) {
+	anchor, intermediate, rp, _ := setupFederation(t)
+	resolver := &Resolver{TrustAnchors: []TrustAnchor{{EntityID: anchor.id, JWKS: anchor.jwks(t)}}}
+
+	chain, err := resolver.Resolve(context.Background(), rp.id)
+	require.NoError(t, err)
+	assert.Equal(t, anchor.id, chain.TrustAnchor)
+	require.Len(t, chain.Statements, 3)
+	assert.Equal(t, rp.id, chain.Subject().Subject)
+	assert.Equal(t, intermediate.id, chain.Statements[1].Issuer)
+	assert.Equal(t, anchor.id, chain.Statements[2].Issuer)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), chain.ExpiresAt(), time.Minute)
+
+	metadata, err := chain.Metadata(EntityTypeRelyingParty)
+	require.NoError(t, err)
+	assert.Equal(t, "Campus Portal (University)", metadata["client_name"])
+	assert.Equal(t, []interface{}{"authorization_code"}, metadata["grant_types"])
+	assert.Equal(t, "openid", metadata["scope"])
+	assert.Equal(t, []interface{}{"ops@university.example"}, metadata["contacts"])
+
+	_, err = chain.Metadata(EntityTypeOpenIDProvider)
+	assert.Error(t, err)
+}
+
+func TestResolveTrustChainRejected(t *testing.T) {
+	anchor, intermediate, rp, _ := setupFederation(t)
+	other := newTestEntity(t, anchor.id)
+
+	// The anchor must sign with its configured keys
+	resolver := &Resolver{TrustAnchors: []TrustAnchor{{EntityID: anchor.id, JWKS: other.jwks(t)}}}
+	_, err := resolver.Resolve(context.Background(), rp.id)
+	assert.Error(t, err)
+
+	// Chains that don't end at a configured anchor are not trusted
+	resolver = &Resolver{TrustAnchors: []TrustAnchor{{EntityID: "https://other-federation.example", JWKS: anchor.jwks(t)}}}
+	_, err = resolver.Resolve(context.Background(), rp.id)
+	assert.Error(t, err)
+
+	// The subject must sign its configuration with keys its superior vouches for
+	resolver = &Resolver{TrustAnchors: []TrustAnchor{{EntityID: anchor.id, JWKS: anchor.jwks(t)}}}
+	intermediate.subordinates[rp.id].JWKS = other.jwks(t)
+	_, err = resolver.Resolve(context.Background(), rp.id)
+	assert.ErrorContains(t, err, "not signed with keys known to")
+
+	// Entities unknown to their superior are not trusted
+	delete(intermediate.subordinates, rp.id)
+	_, err = resolver.Resolve(context.Background(), rp.id)
+	assert.ErrorContains(t, err, "status 404")
+}
+
+func TestApplyPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		present bool
+		policy  Policy
+		want    interface{}
+		wantOK  bool
+		wantErr bool
+	}{
+		{"value replaces", "a", true, Policy{"value": "b"}, "b", true, false},
+		{"default when absent", nil, false, Policy{"default": "d"}, "d", true, false},
+		{"default keeps value", "a", true, Policy{"default": "d"}, "a", true, false},
+		{"one_of allows", "a", true, Policy{"one_of": []interface{}{"a", "b"}}, "a", true, false},
+		{"one_of refuses", "c", true, Policy{"one_of": []interface{}{"a", "b"}}, nil, false, true},
+		{"subset_of drops", []interface{}{"a", "c"}, true, Policy{"subset_of": []interface{}{"a"}}, []interface{}{"a"}, true, false},
+		{"subset_of empties", []interface{}{"c"}, true, Policy{"subset_of": []interface{}{"a"}}, nil, false, false},
+		{"superset_of refuses", []interface{}{"a"}, true, Policy{"superset_of": []interface{}{"a", "b"}}, nil, false, true},
+		{"essential refuses absent", nil, false, Policy{"essential": true}, nil, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := applyPolicy(tt.value, tt.present, tt.policy)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantOK, ok)
+			if tt.wantOK {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
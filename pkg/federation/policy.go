@@ -0,0 +1,124 @@
+package federation
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Metadata returns the subject's metadata of the given entity type as the
+// federation allows it. Metadata set by the immediate superior replaces the
+// subject's own values; then the metadata policies of the superiors are applied,
+// from the trust anchor's down. The supported operators are value, add, default,
+// one_of, subset_of, superset_of and essential.
+func (tc *TrustChain) Metadata(entityType string) (map[string]interface{}, error) {
+	own, ok := tc.Subject().Metadata[entityType]
+	if !ok {
+		return nil, fmt.Errorf("%s has no %s metadata", tc.Subject().Subject, entityType)
+	}
+	metadata := make(map[string]interface{}, len(own))
+	for name, value := range own {
+		metadata[name] = value
+	}
+	if len(tc.Statements) > 1 {
+		for name, value := range tc.Statements[1].Metadata[entityType] {
+			metadata[name] = value
+		}
+	}
+
+	for i := len(tc.Statements) - 1; i >= 1; i-- {
+		policies := tc.Statements[i].MetadataPolicy[entityType]
+		names := make([]string, 0, len(policies))
+		for name := range policies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			value, present := metadata[name]
+			value, present, err := applyPolicy(value, present, policies[name])
+			if err != nil {
+				return nil, fmt.Errorf("metadata policy of %s for %s: %w", tc.Statements[i].Issuer, name, err)
+			}
+			if present {
+				metadata[name] = value
+			} else {
+				delete(metadata, name)
+			}
+		}
+	}
+	return metadata, nil
+}
+
+// applyPolicy applies the operators of one parameter's policy to its value. It
+// returns the new value and whether the parameter is still present.
+func applyPolicy(value interface{}, present bool, policy Policy) (interface{}, bool, error) {
+	if v, ok := policy["value"]; ok {
+		value, present = v, v != nil
+	}
+	if add, ok := policy["add"]; ok {
+		values := asList(value)
+		if !present {
+			values = nil
+		}
+		for _, v := range asList(add) {
+			if !containsValue(values, v) {
+				values = append(values, v)
+			}
+		}
+		value, present = values, true
+	}
+	if v, ok := policy["default"]; ok && !present {
+		value, present = v, true
+	}
+
+	if allowed, ok := policy["one_of"]; ok && present && !containsValue(asList(allowed), value) {
+		return nil, false, fmt.Errorf("%v is not one of %v", value, allowed)
+	}
+	if allowed, ok := policy["subset_of"]; ok && present {
+		var kept []interface{}
+		for _, v := range asList(value) {
+			if containsValue(asList(allowed), v) {
+				kept = append(kept, v)
+			}
+		}
+		value, present = kept, len(kept) > 0
+	}
+	if required, ok := policy["superset_of"]; ok && present {
+		for _, v := range asList(required) {
+			if !containsValue(asList(value), v) {
+				return nil, false, fmt.Errorf("%v must include %v", value, v)
+			}
+		}
+	}
+	if essential, _ := policy["essential"].(bool); essential && !present {
+		return nil, false, fmt.Errorf("value is required")
+	}
+	return value, present, nil
+}
+
+// asList returns value as a list; single values become a list of one
+func asList(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return v
+	case []string:
+		list := make([]interface{}, len(v))
+		for i, s := range v {
+			list[i] = s
+		}
+		return list
+	}
+	return []interface{}{value}
+}
+
+// containsValue reports whether list holds value
+func containsValue(list []interface{}, value interface{}) bool {
+	for _, v := range list {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
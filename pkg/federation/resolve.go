@@ -0,0 +1,198 @@
+package federation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/outbound"
+)
+
+const (
+	// maxChainLength bounds how many superiors are followed above an entity
+	maxChainLength   = 5
+	maxStatementSize = 1 << 20
+	fetchTimeout     = 10 * time.Second
+)
+
+// TrustAnchor is a federation whose members are trusted, with its keys as
+// configured out of band
+type TrustAnchor struct {
+	EntityID string
+	JWKS     map[string]interface{}
+}
+
+// Resolver builds trust chains from an entity up to one of its trust anchors
+type Resolver struct {
+	TrustAnchors []TrustAnchor
+	HTTPClient   *http.Client // Nil = an outbound client with a 10 second timeout
+}
+
+// TrustChain links an entity to a trust anchor. Statements starts with the
+// entity's own configuration, followed by the subordinate statement each superior
+// issued about the one below it, ending with the trust anchor's.
+type TrustChain struct {
+	Statements  []*EntityStatement
+	TrustAnchor string
+}
+
+// Subject returns the entity the chain is about
+func (tc *TrustChain) Subject() *EntityStatement {
+	return tc.Statements[0]
+}
+
+// ExpiresAt returns when the first statement of the chain expires, after which
+// the chain must be resolved again
+func (tc *TrustChain) ExpiresAt() time.Time {
+	var expiresAt time.Time
+	for _, s := range tc.Statements {
+		if exp := s.ExpiresAt.Time; expiresAt.IsZero() || exp.Before(expiresAt) {
+			expiresAt = exp
+		}
+	}
+	return expiresAt
+}
+
+// Resolve fetches the entity configuration of entityID and follows its authority
+// hints until it reaches a trust anchor, verifying each statement on the way. Of
+// several authority hints, the first that leads to a trust anchor is used.
+func (r *Resolver) Resolve(ctx context.Context, entityID string) (*TrustChain, error) {
+	subject, err := r.entityConfiguration(ctx, entityID)
+	if err != nil {
+		return nil, err
+	}
+	superiors, anchor, err := r.resolveSuperiors(ctx, subject, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &TrustChain{Statements: append([]*EntityStatement{subject}, superiors...), TrustAnchor: anchor}, nil
+}
+
+// resolveSuperiors returns the subordinate statements from subject's superior up
+// to a trust anchor, and the trust anchor's entity ID
+func (r *Resolver) resolveSuperiors(ctx context.Context, subject *EntityStatement, depth int) ([]*EntityStatement, string, error) {
+	if depth >= maxChainLength {
+		return nil, "", fmt.Errorf("no trust anchor within %d superiors of %s", maxChainLength, subject.Subject)
+	}
+	if len(subject.AuthorityHints) == 0 {
+		return nil, "", fmt.Errorf("%s has no authority hints leading to a trust anchor", subject.Subject)
+	}
+	var lastErr error
+	for _, authorityID := range subject.AuthorityHints {
+		statements, anchor, err := r.resolveThrough(ctx, subject, authorityID, depth)
+		if err == nil {
+			return statements, anchor, nil
+		}
+		lastErr = err
+	}
+	return nil, "", lastErr
+}
+
+// resolveThrough continues the chain of subject through one of its authorities
+func (r *Resolver) resolveThrough(ctx context.Context, subject *EntityStatement, authorityID string, depth int) ([]*EntityStatement, string, error) {
+	authority, err := r.entityConfiguration(ctx, authorityID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// A trust anchor's statements are checked against its configured keys
+	authorityKeys := authority.JWKS
+	anchor, trusted := r.trustAnchor(authorityID)
+	if trusted {
+		if _, err := Parse(authority.raw, anchor.JWKS); err != nil {
+			return nil, "", fmt.Errorf("trust anchor %s: %w", authorityID, err)
+		}
+		authorityKeys = anchor.JWKS
+	}
+
+	endpoint, err := url.Parse(authority.fetchEndpoint())
+	if err != nil || endpoint.Host == "" {
+		return nil, "", fmt.Errorf("%s has no federation fetch endpoint", authorityID)
+	}
+	query := endpoint.Query()
+	query.Set("sub", subject.Subject)
+	endpoint.RawQuery = query.Encode()
+	raw, err := r.fetch(ctx, endpoint.String())
+	if err != nil {
+		return nil, "", err
+	}
+	statement, err := Parse(raw, authorityKeys)
+	if err != nil {
+		return nil, "", fmt.Errorf("statement from %s: %w", authorityID, err)
+	}
+	if statement.Issuer != authorityID || statement.Subject != subject.Subject {
+		return nil, "", fmt.Errorf("statement from %s is not about %s", authorityID, subject.Subject)
+	}
+
+	// The subject must sign with keys its superior vouches for
+	if _, err := Parse(subject.raw, statement.JWKS); err != nil {
+		return nil, "", fmt.Errorf("%s is not signed with keys known to %s", subject.Subject, authorityID)
+	}
+
+	if trusted {
+		return []*EntityStatement{statement}, authorityID, nil
+	}
+	superiors, anchorID, err := r.resolveSuperiors(ctx, authority, depth+1)
+	if err != nil {
+		return nil, "", err
+	}
+	return append([]*EntityStatement{statement}, superiors...), anchorID, nil
+}
+
+// trustAnchor returns the configured trust anchor with the given entity ID
+func (r *Resolver) trustAnchor(entityID string) (TrustAnchor, bool) {
+	for _, anchor := range r.TrustAnchors {
+		if anchor.EntityID == entityID {
+			return anchor, true
+		}
+	}
+	return TrustAnchor{}, false
+}
+
+// entityConfiguration fetches and verifies the self-signed configuration of entityID
+func (r *Resolver) entityConfiguration(ctx context.Context, entityID string) (*EntityStatement, error) {
+	raw, err := r.fetch(ctx, strings.TrimSuffix(entityID, "/")+ConfigurationPath)
+	if err != nil {
+		return nil, err
+	}
+	statement, err := Parse(raw, nil)
+	if err != nil {
+		return nil, fmt.Errorf("entity configuration of %s: %w", entityID, err)
+	}
+	if statement.Issuer != entityID || statement.Subject != entityID {
+		return nil, fmt.Errorf("entity configuration of %s is issued for another entity", entityID)
+	}
+	return statement, nil
+}
+
+// fetch downloads an entity statement
+func (r *Resolver) fetch(ctx context.Context, uri string) (string, error) {
+	httpClient := r.HTTPClient
+	if httpClient == nil {
+		httpClient = outbound.NewClient(outbound.Untrusted, fetchTimeout)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid entity statement URL %s", uri)
+	}
+	req.Header.Set("Accept", ContentType)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %w", uri, err)
+	}
+	defer func() {
+		_ = resp.Body.Close() // Best effort close
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch %s: status %d", uri, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxStatementSize))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", uri, err)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
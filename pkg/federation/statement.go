@@ -0,0 +1,93 @@
+// Package federation resolves OpenID Federation 1.0 trust chains, so that relying
+// parties of a trusted federation can be established without prior registration.
+package federation
+
+import (
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+)
+
+const (
+	// ConfigurationPath is where an entity publishes its entity configuration, below its entity ID
+	ConfigurationPath = "/.well-known/openid-federation"
+	// ContentType is the media type of entity statements
+	ContentType = "application/" + crypto.EntityStatementType
+)
+
+// Entity types, the members of an entity statement's metadata
+const (
+	EntityTypeOpenIDProvider     = "openid_provider"
+	EntityTypeRelyingParty       = "openid_relying_party"
+	EntityTypeFederationEntity   = "federation_entity"
+	federationFetchEndpointParam = "federation_fetch_endpoint"
+)
+
+// signingMethods are the algorithms entity statements may be signed with
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// Policy maps metadata policy operators, such as "subset_of", to their values
+type Policy map[string]interface{}
+
+// EntityStatement is a signed statement about an entity. An entity configuration is
+// issued by the entity about itself; a subordinate statement is issued about it by
+// its superior in the federation.
+type EntityStatement struct {
+	jwt.RegisteredClaims
+	JWKS           map[string]interface{}            `json:"jwks,omitempty"`
+	AuthorityHints []string                          `json:"authority_hints,omitempty"`
+	Metadata       map[string]map[string]interface{} `json:"metadata,omitempty"`
+	MetadataPolicy map[string]map[string]Policy      `json:"metadata_policy,omitempty"`
+
+	raw string
+}
+
+// Parse verifies a signed entity statement and returns its claims. The signature
+// must be made with a key from jwks or, if jwks is nil, from the statement's own
+// jwks, as for an entity configuration.
+func Parse(raw string, jwks map[string]interface{}) (*EntityStatement, error) {
+	statement := &EntityStatement{}
+	_, err := jwt.ParseWithClaims(raw, statement, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != crypto.EntityStatementType {
+			return nil, fmt.Errorf("not an entity statement")
+		}
+		keys := jwks
+		if keys == nil {
+			keys = statement.JWKS
+		}
+		kid, _ := token.Header["kid"].(string)
+		return findKey(keys, kid)
+	}, jwt.WithValidMethods(signingMethods), jwt.WithExpirationRequired(), jwt.WithIssuedAt())
+	if err != nil {
+		return nil, fmt.Errorf("invalid entity statement: %w", err)
+	}
+	if statement.Issuer == "" || statement.Subject == "" {
+		return nil, fmt.Errorf("invalid entity statement: iss and sub are required")
+	}
+	statement.raw = raw
+	return statement, nil
+}
+
+// findKey returns the public key with the given key ID from a JWK Set
+func findKey(jwks map[string]interface{}, kid string) (interface{}, error) {
+	keys, _ := jwks["keys"].([]interface{})
+	for _, k := range keys {
+		jwk, ok := k.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if keyID, _ := jwk["kid"].(string); kid != "" && keyID != kid {
+			continue
+		}
+		return crypto.ParseJWKPublicKey(jwk)
+	}
+	return nil, fmt.Errorf("no key %q in jwks", kid)
+}
+
+// fetchEndpoint returns the endpoint an authority serves subordinate statements at
+func (s *EntityStatement) fetchEndpoint() string {
+	endpoint, _ := s.Metadata[EntityTypeFederationEntity][federationFetchEndpointParam].(string)
+	return endpoint
+}
@@ -3,46 +3,80 @@ package handlers
 import (
 	"crypto/sha256"
 	"fmt"
+	"log"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"golang.org/x/crypto/bcrypt"
 
+	"github.com/prasenjit-net/openid-golang/pkg/blobstore"
 	"github.com/prasenjit-net/openid-golang/pkg/configstore"
 	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/events"
+	"github.com/prasenjit-net/openid-golang/pkg/mail"
 	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/outbound"
+	"github.com/prasenjit-net/openid-golang/pkg/session"
 	"github.com/prasenjit-net/openid-golang/pkg/storage"
 )
 
 const (
 	bearerPrefix = "Bearer"
 	unknownAdmin = "admin"
+
+	// securityStatsWindow is how far back the security stats look
+	securityStatsWindow = 24 * time.Hour
+
+	// maxClockSkewSeconds bounds the leeway on inbound assertions to five minutes
+	maxClockSkewSeconds = 300
+
+	// secretRevealJTINamespace scopes reveal token IDs in the replay cache
+	secretRevealJTINamespace  = "admin-secret-reveal"
+	defaultSecretRevealTTLSec = 60
+
+	// Lifetime bounds for tokens minted through the admin test-token endpoint
+	defaultTestTokenLifetime = 5 * time.Minute
+	maxTestTokenLifetime     = 1 * time.Hour
 )
 
 // AdminHandler handles admin API endpoints
 type AdminHandler struct {
-	store       storage.Storage
-	config      *configstore.ConfigData
-	adminSecret []byte // HMAC secret for admin JWT tokens
+	store          storage.Storage
+	config         *configstore.ConfigData
+	sessionManager *session.Manager
+	adminSecret    []byte // HMAC secret for admin JWT tokens
+	storageBreaker *storage.Breaker
+	mailer         mail.Sender
+	avatars        blobstore.Store
+	configStore    configstore.ConfigStore
+	adminPolicy    adminPolicyCache
+	settingsMu     sync.Mutex // Serializes settings updates, so If-Match names the settings being changed
 }
 
 // NewAdminHandler creates a new admin handler
-func NewAdminHandler(store storage.Storage, cfg *configstore.ConfigData) *AdminHandler {
-	// Derive a stable HMAC secret from the JWT private key PEM.
-	// Falls back to a constant salt when no key is configured yet.
-	seed := []byte(cfg.JWT.PrivateKey)
+func NewAdminHandler(store storage.Storage, cfg *configstore.ConfigData, sessionMgr *session.Manager) *AdminHandler {
+	return &AdminHandler{
+		store:          store,
+		config:         cfg,
+		sessionManager: sessionMgr,
+		adminSecret:    adminTokenSecret(cfg),
+	}
+}
+
+// adminTokenSecret derives a stable HMAC secret for admin tokens from the JWT
+// private key PEM. Falls back to a constant salt when no key is configured yet.
+func adminTokenSecret(cfg *configstore.ConfigData) []byte {
+	seed := []byte(cfg.JWTState().PrivateKey)
 	if len(seed) == 0 {
 		seed = []byte("openid-admin-default-secret-seed")
 	}
 	sum := sha256.Sum256(seed)
-	return &AdminHandler{
-		store:       store,
-		config:      cfg,
-		adminSecret: sum[:],
-	}
+	return sum[:]
 }
 
 // getAdminActor extracts the authenticated admin's username from the Bearer
@@ -67,6 +101,25 @@ func (h *AdminHandler) getAdminActor(c echo.Context) string {
 	return unknownAdmin
 }
 
+// authenticatedAdmin validates the admin bearer token and returns the admin's username.
+// It writes a 401 response and returns false when the request is not authenticated.
+func (h *AdminHandler) authenticatedAdmin(c echo.Context) (string, bool) {
+	parts := strings.SplitN(c.Request().Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || parts[0] != bearerPrefix {
+		_ = c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authorization header required"})
+		return "", false
+	}
+	claims, err := crypto.ValidateAdminToken(parts[1], h.adminSecret)
+	if err != nil {
+		_ = c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid token"})
+		return "", false
+	}
+	if sub, ok := claims["sub"].(string); ok && sub != "" {
+		return sub, true
+	}
+	return unknownAdmin, true
+}
+
 // GetStats returns dashboard statistics
 func (h *AdminHandler) GetStats(c echo.Context) error {
 	users, err := h.store.GetAllUsers()
@@ -107,10 +160,43 @@ func (h *AdminHandler) GetStats(c echo.Context) error {
 		"total_keys":  totalKeys,
 		"active_keys": activeKeys,
 	}
+	if h.sessionManager != nil {
+		stats["auth_sessions"] = h.sessionManager.AuthSessionStats()
+	}
+	stats["client_assertion_replays_rejected"] = ClientAssertionReplaysRejected()
+	stats["client_jwks"] = ClientJWKSStats()
+	stats["outbound_http"] = outbound.Stats()
+	stats["retention_deleted"] = RetentionDeletions()
+	stats["storage"] = storageBreakerStats(h.storageBreaker)
+	if security, err := events.ComputeSecurityStats(h.store, time.Now().Add(-securityStatsWindow)); err == nil {
+		stats["security"] = security
+	} else {
+		log.Printf("Failed to compute security stats: %v", err)
+	}
 
 	return c.JSON(http.StatusOK, stats)
 }
 
+// SetStorageBreaker includes the state of b in the stats
+func (h *AdminHandler) SetStorageBreaker(b *storage.Breaker) {
+	h.storageBreaker = b
+}
+
+// SetMailer sets the sender used for reports run from the admin API
+func (h *AdminHandler) SetMailer(m mail.Sender) {
+	h.mailer = m
+}
+
+// SetAvatarStore sets the blob store admins upload user avatars to
+func (h *AdminHandler) SetAvatarStore(store blobstore.Store) {
+	h.avatars = store
+}
+
+// SetConfigStore sets the store runtime toggles such as feature flags are persisted to
+func (h *AdminHandler) SetConfigStore(store configstore.ConfigStore) {
+	h.configStore = store
+}
+
 // ListUsers returns all users with optional filtering
 func (h *AdminHandler) ListUsers(c echo.Context) error {
 	users, err := h.store.GetAllUsers()
@@ -123,10 +209,14 @@ func (h *AdminHandler) ListUsers(c echo.Context) error {
 	emailFilter := c.QueryParam("email")
 	nameFilter := c.QueryParam("name")
 	roleFilter := c.QueryParam("role")
+	includeDeleted := c.QueryParam("include_deleted") == "true"
 
 	// Filter users based on query parameters
 	var filteredUsers []*models.User
 	for _, user := range users {
+		if user.IsDeleted() && !includeDeleted {
+			continue
+		}
 		// Apply filters (case-insensitive partial match)
 		if usernameFilter != "" && !containsIgnoreCase(user.Username, usernameFilter) {
 			continue
@@ -145,23 +235,31 @@ func (h *AdminHandler) ListUsers(c echo.Context) error {
 
 	// Don't send password hashes to client
 	type SafeUser struct {
-		ID        string    `json:"id"`
-		Username  string    `json:"username"`
-		Email     string    `json:"email"`
-		Name      string    `json:"name"`
-		Role      string    `json:"role"`
-		CreatedAt time.Time `json:"created_at"`
+		ID          string     `json:"id"`
+		Username    string     `json:"username"`
+		Email       string     `json:"email"`
+		Name        string     `json:"name"`
+		Role        string     `json:"role"`
+		Disabled    bool       `json:"disabled"`
+		LockedUntil *time.Time `json:"locked_until,omitempty"`
+		DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+		Version     int        `json:"version"`
+		CreatedAt   time.Time  `json:"created_at"`
 	}
 
 	safeUsers := make([]SafeUser, len(filteredUsers))
 	for i, user := range filteredUsers {
 		safeUsers[i] = SafeUser{
-			ID:        user.ID,
-			Username:  user.Username,
-			Email:     user.Email,
-			Name:      user.Name,
-			Role:      string(user.Role),
-			CreatedAt: user.CreatedAt,
+			ID:          user.ID,
+			Username:    user.Username,
+			Email:       user.Email,
+			Name:        user.Name,
+			Role:        string(user.Role),
+			Disabled:    user.Disabled,
+			LockedUntil: user.LockedUntil,
+			DeletedAt:   user.DeletedAt,
+			Version:     user.Version,
+			CreatedAt:   user.CreatedAt,
 		}
 	}
 
@@ -211,10 +309,15 @@ func (h *AdminHandler) GetUser(c echo.Context) error {
 		"phone_number_verified": user.PhoneNumberVerified,
 		"address":               user.Address,
 		"role":                  user.Role,
+		"disabled":              user.Disabled,
+		"locked_until":          user.LockedUntil,
+		"deleted_at":            user.DeletedAt,
+		"version":               user.Version,
 		"created_at":            user.CreatedAt,
 		"updated_at":            user.UpdatedAt,
 	}
 
+	setVersionETag(c, user.Version)
 	return c.JSON(http.StatusOK, response)
 }
 
@@ -236,6 +339,16 @@ func (h *AdminHandler) CreateUser(c echo.Context) error {
 	if req.Username == "" || req.Email == "" || req.Password == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "username, email, and password are required"})
 	}
+	if !h.checkUsername(c, req.Username) {
+		return nil
+	}
+
+	req.Email = canonicalEmail(h.config, req.Email)
+	if taken, err := emailTakenByOther(h.store, req.Email, ""); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check email"})
+	} else if taken {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "A user with this email already exists"})
+	}
 
 	// Set default role if not provided
 	role := models.RoleUser
@@ -244,7 +357,7 @@ func (h *AdminHandler) CreateUser(c echo.Context) error {
 	}
 
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := hashPassword(h.config, req.Password)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to hash password"})
 	}
@@ -255,7 +368,7 @@ func (h *AdminHandler) CreateUser(c echo.Context) error {
 		Email:        req.Email,
 		Name:         req.Name,
 		Role:         role,
-		PasswordHash: string(hashedPassword),
+		PasswordHash: hashedPassword,
 		CreatedAt:    time.Now(),
 		UpdatedAt:    time.Now(),
 	}
@@ -305,10 +418,26 @@ func (h *AdminHandler) UpdateUser(c echo.Context) error {
 	if existingUser == nil {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
 	}
+	if !ifMatchVersion(c, existingUser.Version) {
+		return nil
+	}
+
+	if req.Username != existingUser.Username && !h.checkUsername(c, req.Username) {
+		return nil
+	}
+
+	email := canonicalEmail(h.config, req.Email)
+	if !strings.EqualFold(email, existingUser.Email) {
+		if taken, err := emailTakenByOther(h.store, email, existingUser.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check email"})
+		} else if taken {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "A user with this email already exists"})
+		}
+	}
 
 	// Update basic fields
 	existingUser.Username = req.Username
-	existingUser.Email = req.Email
+	existingUser.Email = email
 	existingUser.EmailVerified = req.EmailVerified
 	existingUser.Name = req.Name
 	if req.Role != "" {
@@ -336,20 +465,42 @@ func (h *AdminHandler) UpdateUser(c echo.Context) error {
 	// Update address
 	existingUser.Address = req.Address
 
+	// Update language-tagged claim values
+	for claim, values := range req.LocalizedClaims {
+		for tag := range values {
+			if !models.IsLanguageTag(tag) {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid language tag for " + claim + ": " + tag})
+			}
+		}
+	}
+	existingUser.LocalizedClaims = req.LocalizedClaims
+
 	// Update password if provided
 	if req.Password != "" {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		hashedPassword, err := hashPassword(h.config, req.Password)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to hash password"})
 		}
-		existingUser.PasswordHash = string(hashedPassword)
+		existingUser.PasswordHash = hashedPassword
 	}
 
 	existingUser.UpdatedAt = time.Now()
 
-	if err := h.store.UpdateUser(existingUser); err != nil {
+	updated, err := h.store.UpdateUserIfVersion(existingUser, existingUser.Version)
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update user: " + err.Error()})
 	}
+	if !updated {
+		// Changed or deleted since it was read above
+		current, err := h.store.GetUserByID(id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get user"})
+		}
+		if current == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+		}
+		return versionConflict(c, current.Version)
+	}
 
 	h.logAdminAudit(models.AuditActionAdminUserUpdated, models.AuditActorAdmin, h.getAdminActor(c),
 		"user", existingUser.ID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(), nil)
@@ -377,14 +528,16 @@ func (h *AdminHandler) UpdateUser(c echo.Context) error {
 		"phone_number_verified": existingUser.PhoneNumberVerified,
 		"address":               existingUser.Address,
 		"role":                  existingUser.Role,
+		"version":               existingUser.Version,
 		"created_at":            existingUser.CreatedAt,
 		"updated_at":            existingUser.UpdatedAt,
 	}
 
+	setVersionETag(c, existingUser.Version)
 	return c.JSON(http.StatusOK, response)
 }
 
-// DeleteUser deletes a user
+// DeleteUser soft-deletes a user, or removes it permanently with ?hard=true
 func (h *AdminHandler) DeleteUser(c echo.Context) error {
 	// Extract ID from URL parameter
 	id := c.Param("id")
@@ -392,16 +545,132 @@ func (h *AdminHandler) DeleteUser(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "User ID is required"})
 	}
 
-	if err := h.store.DeleteUser(id); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete user: " + err.Error()})
+	hard := c.QueryParam("hard") == "true"
+	if hard {
+		user, err := h.store.GetUserByID(id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get user"})
+		}
+		if err := h.store.DeleteUser(id); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete user: " + err.Error()})
+		}
+		if user != nil {
+			deleteUploadedAvatar(c.Request().Context(), h.avatars, h.config, user.Picture)
+		}
+	} else {
+		user, err := h.store.GetUserByID(id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get user"})
+		}
+		if user == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+		}
+		if !user.IsDeleted() {
+			now := time.Now()
+			user.DeletedAt = &now
+			if err := h.store.UpdateUser(user); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete user: " + err.Error()})
+			}
+		}
 	}
 
 	h.logAdminAudit(models.AuditActionAdminUserDeleted, models.AuditActorAdmin, h.getAdminActor(c),
-		"user", id, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(), nil)
+		"user", id, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"hard": hard})
 
 	return c.NoContent(http.StatusNoContent)
 }
 
+// EnableUser lets a disabled, locked or soft-deleted user sign in again
+func (h *AdminHandler) EnableUser(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	user, err := h.store.GetUserByID(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get user"})
+	}
+	if user == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+
+	user.Disabled = false
+	user.LockedUntil = nil
+	user.DeletedAt = nil
+	if err := h.store.UpdateUser(user); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update user"})
+	}
+
+	h.logAdminAudit(models.AuditActionAdminUserEnabled, models.AuditActorAdmin, actor,
+		"user", user.ID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(), nil)
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "User enabled"})
+}
+
+// DisableUser blocks sign-in and token refresh for a user. With a locked_until time
+// in the body the user is locked until then instead of disabled indefinitely.
+func (h *AdminHandler) DisableUser(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	var req struct {
+		LockedUntil *time.Time `json:"locked_until"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if req.LockedUntil != nil && !req.LockedUntil.After(time.Now()) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "locked_until must be in the future"})
+	}
+
+	user, err := h.store.GetUserByID(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get user"})
+	}
+	if user == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+
+	if req.LockedUntil != nil {
+		user.LockedUntil = req.LockedUntil
+	} else {
+		user.Disabled = true
+	}
+	if err := h.store.UpdateUser(user); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update user"})
+	}
+
+	h.logAdminAudit(models.AuditActionAdminUserDisabled, models.AuditActorAdmin, actor,
+		"user", user.ID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"locked_until": req.LockedUntil})
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "User disabled"})
+}
+
+// ExportConsentReceipts downloads stored consent receipts, optionally filtered by
+// the user_id and client_id query parameters
+func (h *AdminHandler) ExportConsentReceipts(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	userID := c.QueryParam("user_id")
+	clientID := c.QueryParam("client_id")
+
+	receipts, err := h.store.ListConsentReceipts(userID, clientID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load consent receipts"})
+	}
+
+	h.logAdminAudit(models.AuditActionAdminConsentExported, models.AuditActorAdmin, actor,
+		"consent_receipt", userID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"user_id": userID, "client_id": clientID, "count": len(receipts)})
+
+	return writeConsentReceipts(c, receipts)
+}
+
 // ListClients returns all OAuth clients
 func (h *AdminHandler) ListClients(c echo.Context) error {
 	clients, err := h.store.GetAllClients()
@@ -444,6 +713,8 @@ func (h *AdminHandler) ListClients(c echo.Context) error {
 		TosURI                  string    `json:"tos_uri,omitempty"`
 		JwksURI                 string    `json:"jwks_uri,omitempty"`
 		TokenEndpointAuthMethod string    `json:"token_endpoint_auth_method"`
+		Disabled                bool      `json:"disabled"`
+		Version                 int       `json:"version"`
 		CreatedAt               time.Time `json:"created_at"`
 	}
 
@@ -466,6 +737,8 @@ func (h *AdminHandler) ListClients(c echo.Context) error {
 			TosURI:                  client.TosURI,
 			JwksURI:                 client.JWKSURI,
 			TokenEndpointAuthMethod: client.TokenEndpointAuthMethod,
+			Disabled:                client.Disabled,
+			Version:                 client.Version,
 			CreatedAt:               client.CreatedAt,
 		}
 	}
@@ -495,6 +768,11 @@ func (h *AdminHandler) CreateClient(c echo.Context) error {
 	if len(req.RedirectURIs) == 0 {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "redirect_uris is required"})
 	}
+	for _, uri := range req.RedirectURIs {
+		if errMsg := redirectURISafetyError(uri); errMsg != "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": errMsg})
+		}
+	}
 
 	// Set defaults for optional fields
 	grantTypes := req.GrantTypes
@@ -566,12 +844,29 @@ func (h *AdminHandler) UpdateClient(c echo.Context) error {
 	}
 
 	var req struct {
-		Name            string   `json:"name"`
-		RedirectURIs    []string `json:"redirect_uris"`
-		GrantTypes      []string `json:"grant_types"`
-		ResponseTypes   []string `json:"response_types"`
-		Scope           string   `json:"scope"`
-		ApplicationType string   `json:"application_type"`
+		Name             string   `json:"name"`
+		RedirectURIs     []string `json:"redirect_uris"`
+		GrantTypes       []string `json:"grant_types"`
+		ResponseTypes    []string `json:"response_types"`
+		Scope            string   `json:"scope"`
+		ApplicationType  string   `json:"application_type"`
+		DebugLogging     *bool    `json:"debug_logging"`
+		AuthFlow         *string  `json:"auth_flow"`
+		SigningKeyID     *string  `json:"signing_key_id"`
+		ResourceServer   *bool    `json:"resource_server"`
+		ResourceScopes   []string `json:"resource_scopes"`
+		RequirePKCE      *bool    `json:"require_pkce"`
+		MinimalIDToken   *bool    `json:"minimal_id_token"`
+		FirstParty       *bool    `json:"first_party"`
+		FirstPartyScopes []string `json:"first_party_scopes"`
+
+		InstanceBinding            *string           `json:"instance_binding"`
+		InstanceAttestation        []string          `json:"instance_attestation"`
+		BindRefreshTokensToSession *bool             `json:"bind_refresh_tokens_to_session"`
+		RefreshTokenMaxUses        *int              `json:"refresh_token_max_uses"`
+		RefreshTokenIdleTimeout    *int              `json:"refresh_token_idle_timeout"`
+		UserInfoCacheSeconds       *int              `json:"userinfo_cache_seconds"`
+		TokenResponseParams        map[string]string `json:"token_response_params"`
 	}
 
 	if err := c.Bind(&req); err != nil {
@@ -586,12 +881,20 @@ func (h *AdminHandler) UpdateClient(c echo.Context) error {
 	if existingClient == nil {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "Client not found"})
 	}
+	if !ifMatchVersion(c, existingClient.Version) {
+		return nil
+	}
 
 	// Update fields
 	if req.Name != "" {
 		existingClient.Name = req.Name
 	}
 	if len(req.RedirectURIs) > 0 {
+		for _, uri := range req.RedirectURIs {
+			if errMsg := redirectURISafetyError(uri); errMsg != "" {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": errMsg})
+			}
+		}
 		existingClient.RedirectURIs = req.RedirectURIs
 	}
 	if len(req.GrantTypes) > 0 {
@@ -606,30 +909,144 @@ func (h *AdminHandler) UpdateClient(c echo.Context) error {
 	if req.ApplicationType != "" {
 		existingClient.ApplicationType = req.ApplicationType
 	}
+	if req.DebugLogging != nil {
+		existingClient.DebugLogging = *req.DebugLogging
+	}
+	if req.BindRefreshTokensToSession != nil {
+		existingClient.BindRefreshTokensToSession = *req.BindRefreshTokensToSession
+	}
+	if req.RefreshTokenMaxUses != nil {
+		if *req.RefreshTokenMaxUses < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "refresh_token_max_uses must not be negative"})
+		}
+		existingClient.RefreshTokenMaxUses = *req.RefreshTokenMaxUses
+	}
+	if req.RefreshTokenIdleTimeout != nil {
+		if *req.RefreshTokenIdleTimeout < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "refresh_token_idle_timeout must not be negative"})
+		}
+		existingClient.RefreshTokenIdleTimeout = *req.RefreshTokenIdleTimeout
+	}
+	if req.UserInfoCacheSeconds != nil {
+		if *req.UserInfoCacheSeconds < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "userinfo_cache_seconds must not be negative"})
+		}
+		existingClient.UserInfoCacheSeconds = *req.UserInfoCacheSeconds
+	}
+	if req.AuthFlow != nil {
+		if *req.AuthFlow != "" && !h.authFlowExists(*req.AuthFlow) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unknown sign-in flow: " + *req.AuthFlow})
+		}
+		existingClient.AuthFlow = *req.AuthFlow
+	}
+	if req.ResourceServer != nil {
+		existingClient.ResourceServer = *req.ResourceServer
+	}
+	if req.ResourceScopes != nil {
+		existingClient.ResourceScopes = req.ResourceScopes
+	}
+	if req.RequirePKCE != nil {
+		existingClient.RequirePKCE = *req.RequirePKCE
+	}
+	if req.MinimalIDToken != nil {
+		existingClient.MinimalIDToken = *req.MinimalIDToken
+	}
+	if req.FirstParty != nil {
+		existingClient.FirstParty = *req.FirstParty
+	}
+	if req.FirstPartyScopes != nil {
+		existingClient.FirstPartyScopes = req.FirstPartyScopes
+	}
+	if req.InstanceBinding != nil {
+		switch *req.InstanceBinding {
+		case "", models.InstanceBindingOptional, models.InstanceBindingRequired:
+			existingClient.InstanceBinding = *req.InstanceBinding
+		default:
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "instance_binding must be \"optional\" or \"required\""})
+		}
+	}
+	if req.InstanceAttestation != nil {
+		existingClient.InstanceAttestation = req.InstanceAttestation
+	}
+	if req.TokenResponseParams != nil {
+		if err := ValidateTokenResponseParams(req.TokenResponseParams); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		existingClient.TokenResponseParams = req.TokenResponseParams
+	}
+	if req.SigningKeyID != nil {
+		if *req.SigningKeyID != "" {
+			if _, err := pinnableKey(h.store, *req.SigningKeyID); err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Cannot pin client: " + err.Error()})
+			}
+		}
+		existingClient.SigningKeyID = *req.SigningKeyID
+	}
 
-	if err := h.store.UpdateClient(existingClient); err != nil {
+	updated, err := h.store.UpdateClientIfVersion(existingClient, existingClient.Version)
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update client: " + err.Error()})
 	}
+	if !updated {
+		// Changed or deleted since it was read above
+		current, err := h.store.GetClientByID(id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get client"})
+		}
+		if current == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Client not found"})
+		}
+		return versionConflict(c, current.Version)
+	}
 
 	h.logAdminAudit(models.AuditActionAdminClientUpdated, models.AuditActorAdmin, h.getAdminActor(c),
 		"client", id, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(), nil)
 
 	// Return updated client without secret
 	response := map[string]interface{}{
-		"id":               existingClient.ID,
-		"client_id":        existingClient.ID,
-		"name":             existingClient.Name,
-		"redirect_uris":    existingClient.RedirectURIs,
-		"grant_types":      existingClient.GrantTypes,
-		"response_types":   existingClient.ResponseTypes,
-		"scope":            existingClient.Scope,
-		"application_type": existingClient.ApplicationType,
-		"created_at":       existingClient.CreatedAt,
-	}
-
+		"id":                 existingClient.ID,
+		"client_id":          existingClient.ID,
+		"name":               existingClient.Name,
+		"redirect_uris":      existingClient.RedirectURIs,
+		"grant_types":        existingClient.GrantTypes,
+		"response_types":     existingClient.ResponseTypes,
+		"scope":              existingClient.Scope,
+		"application_type":   existingClient.ApplicationType,
+		"debug_logging":      existingClient.DebugLogging,
+		"auth_flow":          existingClient.AuthFlow,
+		"signing_key_id":     existingClient.SigningKeyID,
+		"resource_server":    existingClient.ResourceServer,
+		"resource_scopes":    existingClient.ResourceScopes,
+		"require_pkce":       existingClient.RequirePKCE,
+		"minimal_id_token":   existingClient.MinimalIDToken,
+		"first_party":        existingClient.FirstParty,
+		"first_party_scopes": existingClient.FirstPartyScopes,
+		"version":            existingClient.Version,
+		"created_at":         existingClient.CreatedAt,
+
+		"instance_binding":               existingClient.InstanceBinding,
+		"instance_attestation":           existingClient.InstanceAttestation,
+		"bind_refresh_tokens_to_session": existingClient.BindRefreshTokensToSession,
+		"refresh_token_max_uses":         existingClient.RefreshTokenMaxUses,
+		"refresh_token_idle_timeout":     existingClient.RefreshTokenIdleTimeout,
+		"userinfo_cache_seconds":         existingClient.UserInfoCacheSeconds,
+		"token_response_params":          existingClient.TokenResponseParams,
+	}
+
+	setVersionETag(c, existingClient.Version)
 	return c.JSON(http.StatusOK, response)
 }
 
+// authFlowExists reports whether a sign-in flow with the given name is configured
+func (h *AdminHandler) authFlowExists(name string) bool {
+	for _, flow := range h.config.AuthFlowsState() {
+		if flow.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 // DeleteClient deletes an OAuth client
 func (h *AdminHandler) DeleteClient(c echo.Context) error {
 	// Extract ID from URL parameter
@@ -648,6 +1065,193 @@ func (h *AdminHandler) DeleteClient(c echo.Context) error {
 	return c.NoContent(http.StatusNoContent)
 }
 
+// ListPendingClients returns dynamically registered clients awaiting admin review
+func (h *AdminHandler) ListPendingClients(c echo.Context) error {
+	if _, ok := h.authenticatedAdmin(c); !ok {
+		return nil
+	}
+	clients, err := h.store.GetAllClients()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get clients"})
+	}
+
+	pending := []map[string]interface{}{}
+	for _, client := range clients {
+		if client.Status != models.ClientStatusPending {
+			continue
+		}
+		pending = append(pending, map[string]interface{}{
+			"client_id":     client.ID,
+			"client_name":   client.ClientName,
+			"redirect_uris": client.RedirectURIs,
+			"grant_types":   client.GrantTypes,
+			"scope":         client.Scope,
+			"contacts":      client.Contacts,
+			"created_at":    client.CreatedAt,
+		})
+	}
+
+	return c.JSON(http.StatusOK, pending)
+}
+
+// ListDormantClients reports dynamically registered clients that have not been used
+// for the configured number of days (or the "days" query parameter)
+func (h *AdminHandler) ListDormantClients(c echo.Context) error {
+	if _, ok := h.authenticatedAdmin(c); !ok {
+		return nil
+	}
+	days := h.config.RegistrationState().Quotas.DormantAfterDays
+	if d, err := strconv.Atoi(c.QueryParam("days")); err == nil && d > 0 {
+		days = d
+	}
+	if days <= 0 {
+		days = defaultDormantAfterDays
+	}
+
+	clients, err := h.store.GetAllClients()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get clients"})
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days)
+	dormant := []map[string]interface{}{}
+	for _, client := range clients {
+		if !client.IsDynamicallyRegistered() {
+			continue
+		}
+		lastActive := time.Unix(client.ClientIDIssuedAt, 0)
+		if client.LastUsedAt != nil {
+			lastActive = *client.LastUsedAt
+		}
+		if lastActive.After(cutoff) {
+			continue
+		}
+		dormant = append(dormant, map[string]interface{}{
+			"client_id":    client.ID,
+			"client_name":  client.ClientName,
+			"contacts":     client.Contacts,
+			"issued_at":    client.ClientIDIssuedAt,
+			"last_used_at": client.LastUsedAt,
+			"never_used":   client.LastUsedAt == nil,
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"dormant_after_days": days,
+		"clients":            dormant,
+	})
+}
+
+// ListRedirectURIFindings reports clients whose redirect URIs were flagged by the
+// last redirect host scan
+func (h *AdminHandler) ListRedirectURIFindings(c echo.Context) error {
+	clients, err := h.store.GetAllClients()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get clients"})
+	}
+
+	flagged := []map[string]interface{}{}
+	for _, client := range clients {
+		if len(client.RedirectURIFindings) == 0 {
+			continue
+		}
+		flagged = append(flagged, map[string]interface{}{
+			"client_id":     client.ID,
+			"client_name":   client.ClientName,
+			"redirect_uris": client.RedirectURIs,
+			"findings":      client.RedirectURIFindings,
+			"checked_at":    client.RedirectURIsCheckedAt,
+		})
+	}
+
+	return c.JSON(http.StatusOK, flagged)
+}
+
+// ApproveClient activates a pending dynamically registered client
+func (h *AdminHandler) ApproveClient(c echo.Context) error {
+	return h.reviewClient(c, models.ClientStatusActive, models.AuditActionAdminClientApproved)
+}
+
+// RejectClient rejects a pending dynamically registered client
+func (h *AdminHandler) RejectClient(c echo.Context) error {
+	return h.reviewClient(c, models.ClientStatusRejected, models.AuditActionAdminClientRejected)
+}
+
+// reviewClient moves a pending client to the given review state
+func (h *AdminHandler) reviewClient(c echo.Context, status string, action models.AuditAction) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	id := c.Param("id")
+	if id == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Client ID is required"})
+	}
+
+	client, err := h.store.GetClientByID(id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get client"})
+	}
+	if client == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Client not found"})
+	}
+	if client.Status != models.ClientStatusPending {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Client is not pending review"})
+	}
+
+	client.Status = status
+	client.UpdatedAt = time.Now()
+	if err := h.store.UpdateClient(client); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update client"})
+	}
+
+	h.logAdminAudit(action, models.AuditActorAdmin, actor,
+		"client", id, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(), nil)
+
+	return c.JSON(http.StatusOK, map[string]string{"client_id": id, "status": status})
+}
+
+// EnableClient lifts a client suspension
+func (h *AdminHandler) EnableClient(c echo.Context) error {
+	return h.setClientDisabled(c, false, models.AuditActionAdminClientEnabled)
+}
+
+// DisableClient suspends a client. Authorization, token and introspection requests
+// are rejected, but its configuration, secret and consents are kept.
+func (h *AdminHandler) DisableClient(c echo.Context) error {
+	return h.setClientDisabled(c, true, models.AuditActionAdminClientDisabled)
+}
+
+func (h *AdminHandler) setClientDisabled(c echo.Context, disabled bool, action models.AuditAction) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	id := c.Param("id")
+	if id == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Client ID is required"})
+	}
+
+	client, err := h.store.GetClientByID(id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get client"})
+	}
+	if client == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Client not found"})
+	}
+
+	client.Disabled = disabled
+	client.UpdatedAt = time.Now()
+	if err := h.store.UpdateClient(client); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update client"})
+	}
+
+	h.logAdminAudit(action, models.AuditActorAdmin, actor,
+		"client", id, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(), nil)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"client_id": id, "disabled": disabled})
+}
+
 // GetClient returns a single OAuth client by ID
 func (h *AdminHandler) GetClient(c echo.Context) error {
 	id := c.Param("id")
@@ -680,21 +1284,173 @@ func (h *AdminHandler) GetClient(c echo.Context) error {
 		"tos_uri":                    client.TosURI,
 		"jwks_uri":                   client.JWKSURI,
 		"token_endpoint_auth_method": client.TokenEndpointAuthMethod,
+		"debug_logging":              client.DebugLogging,
+		"auth_flow":                  client.AuthFlow,
+		"signing_key_id":             client.SigningKeyID,
+		"resource_server":            client.ResourceServer,
+		"resource_scopes":            client.ResourceScopes,
+		"require_pkce":               client.RequirePKCE,
+		"minimal_id_token":           client.MinimalIDToken,
+		"first_party":                client.FirstParty,
+		"first_party_scopes":         client.FirstPartyScopes,
+		"instance_binding":           client.InstanceBinding,
+		"instance_attestation":       client.InstanceAttestation,
+		"trust_anchor":               client.TrustAnchor,
+		"status":                     client.Status,
+		"disabled":                   client.Disabled,
+		"version":                    client.Version,
 		"created_at":                 client.CreatedAt,
+		"redirect_uri_findings":      client.RedirectURIFindings,
+
+		"bind_refresh_tokens_to_session": client.BindRefreshTokensToSession,
+		"refresh_token_max_uses":         client.RefreshTokenMaxUses,
+		"refresh_token_idle_timeout":     client.RefreshTokenIdleTimeout,
+		"userinfo_cache_seconds":         client.UserInfoCacheSeconds,
+		"token_response_params":          client.TokenResponseParams,
+	}
+
+	setVersionETag(c, client.Version)
+	return c.JSON(http.StatusOK, response)
+}
+
+// RegenerateClientSecret generates a new secret for an OAuth client
+func (h *AdminHandler) RegenerateClientSecret(c echo.Context) error {
+	id := c.Param("id")
+	if id == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Client ID is required"})
+	}
+
+	// Get existing client
+	client, err := h.store.GetClientByID(id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get client"})
+	}
+	if client == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Client not found"})
+	}
+
+	// Generate new secret
+	newSecret, err := crypto.GenerateRandomString(32)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate client secret"})
+	}
+
+	// Update client with new secret
+	client.Secret = newSecret
+	if err := h.store.UpdateClient(client); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update client: " + err.Error()})
+	}
+
+	// Return new secret (only shown once)
+	response := map[string]interface{}{
+		"client_id":     client.ID,
+		"client_secret": newSecret,
+		"message":       "Client secret regenerated successfully",
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// RequestSecretReveal issues a one-time token for viewing a client's secret once the
+// administrator has re-entered their password (POST /api/admin/clients/:id/secret/reveal-token)
+func (h *AdminHandler) RequestSecretReveal(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	clientID := c.Param("id")
+	audit := func(status models.AuditStatus, details map[string]interface{}) {
+		h.logAdminAudit(models.AuditActionAdminSecretRevealRequested, models.AuditActorAdmin, actor,
+			"client", clientID, status, c.RealIP(), c.Request().UserAgent(), details)
+	}
+
+	if !h.config.SecretRevealState().Enabled {
+		audit(models.AuditStatusFailure, map[string]interface{}{"reason": "disabled by policy"})
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Viewing client secrets is disabled by policy"})
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := c.Bind(&req); err != nil || req.Password == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "password is required"})
+	}
+
+	// Step-up: the admin session alone is not enough to see a secret
+	user, err := h.store.GetUserByUsername(actor)
+	if err != nil || user == nil || !user.IsAdmin() || !checkPassword(h.store, h.config, user, req.Password) {
+		audit(models.AuditStatusFailure, map[string]interface{}{"reason": "step-up authentication failed"})
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Password is incorrect"})
+	}
+
+	client, err := h.store.GetClientByID(clientID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get client"})
+	}
+	if client == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Client not found"})
+	}
+	if client.Secret == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Client has no secret"})
+	}
+
+	ttl := h.config.SecretRevealState().TokenTTLSeconds
+	if ttl <= 0 {
+		ttl = defaultSecretRevealTTLSec
+	}
+	token, err := crypto.GenerateSecretRevealToken(actor, client.ID, time.Duration(ttl)*time.Second, h.adminSecret)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create reveal token"})
+	}
+
+	audit(models.AuditStatusSuccess, map[string]interface{}{"expires_in": ttl})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"reveal_token": token,
+		"expires_in":   ttl,
+	})
+}
+
+// RevealClientSecret returns a client's secret in exchange for a reveal token issued to
+// the same administrator. Each token works once (POST /api/admin/clients/:id/secret/reveal).
+func (h *AdminHandler) RevealClientSecret(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	clientID := c.Param("id")
+	fail := func(code int, message, reason string) error {
+		h.logAdminAudit(models.AuditActionAdminSecretRevealed, models.AuditActorAdmin, actor,
+			"client", clientID, models.AuditStatusFailure, c.RealIP(), c.Request().UserAgent(),
+			map[string]interface{}{"reason": reason})
+		return c.JSON(code, map[string]string{"error": message})
+	}
+
+	// The policy is checked again in case it changed after the token was issued
+	if !h.config.SecretRevealState().Enabled {
+		return fail(http.StatusForbidden, "Viewing client secrets is disabled by policy", "disabled by policy")
 	}
 
-	return c.JSON(http.StatusOK, response)
-}
+	var req struct {
+		RevealToken string `json:"reveal_token"`
+	}
+	if err := c.Bind(&req); err != nil || req.RevealToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "reveal_token is required"})
+	}
 
-// RegenerateClientSecret generates a new secret for an OAuth client
-func (h *AdminHandler) RegenerateClientSecret(c echo.Context) error {
-	id := c.Param("id")
-	if id == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Client ID is required"})
+	claims, err := crypto.ValidateSecretRevealToken(req.RevealToken, h.adminSecret)
+	if err != nil || claims.Subject != actor || claims.ClientID != clientID {
+		return fail(http.StatusForbidden, "Invalid or expired reveal token", "invalid reveal token")
+	}
+	// Each reveal token shows the secret once
+	fresh, err := h.store.RecordJTI(secretRevealJTINamespace, claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to verify reveal token"})
+	}
+	if !fresh {
+		return fail(http.StatusForbidden, "Reveal token has already been used", "reveal token reused")
 	}
 
-	// Get existing client
-	client, err := h.store.GetClientByID(id)
+	client, err := h.store.GetClientByID(clientID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get client"})
 	}
@@ -702,99 +1458,303 @@ func (h *AdminHandler) RegenerateClientSecret(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "Client not found"})
 	}
 
-	// Generate new secret
-	newSecret, err := crypto.GenerateRandomString(32)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate client secret"})
-	}
-
-	// Update client with new secret
-	client.Secret = newSecret
-	if err := h.store.UpdateClient(client); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update client: " + err.Error()})
-	}
+	h.logAdminAudit(models.AuditActionAdminSecretRevealed, models.AuditActorAdmin, actor,
+		"client", client.ID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"reveal_token_id": claims.ID})
 
-	// Return new secret (only shown once)
-	response := map[string]interface{}{
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, map[string]string{
 		"client_id":     client.ID,
-		"client_secret": newSecret,
-		"message":       "Client secret regenerated successfully",
-	}
-
-	return c.JSON(http.StatusOK, response)
+		"client_secret": client.Secret,
+	})
 }
 
 // GetSettings returns server settings
 func (h *AdminHandler) GetSettings(c echo.Context) error {
-	settings := map[string]interface{}{
-		"issuer":             h.config.Issuer,
-		"server_host":        h.config.Server.Host,
-		"server_port":        h.config.Server.Port,
-		"storage_type":       h.config.Storage.Type,
-		"json_file_path":     h.config.Storage.JSONFilePath,
-		"mongo_uri":          h.config.Storage.MongoURI,
-		"jwt_expiry_minutes": h.config.JWT.ExpiryMinutes,
-		"jwt_private_key":    h.config.JWT.PrivateKey, // PEM string
-		"jwt_public_key":     h.config.JWT.PublicKey,  // PEM string
-	}
+	settings := h.settingsView()
+	version := settingsVersion(settings)
+	settings["version"] = version
 
+	setVersionETag(c, version)
 	return c.JSON(http.StatusOK, settings)
 }
 
+// settingsView is the server settings as shown to administrators
+func (h *AdminHandler) settingsView() map[string]interface{} {
+	jwt := h.config.JWTState()
+	rememberMe := h.config.RememberMeState()
+	sessionLimit := h.config.SessionLimitState()
+	consentReceipts := h.config.ConsentReceiptsState()
+	return map[string]interface{}{
+		"issuer":                  h.config.Issuer,
+		"server_host":             h.config.Server.Host,
+		"server_port":             h.config.Server.Port,
+		"storage_type":            h.config.Storage.Type,
+		"json_file_path":          h.config.Storage.JSONFilePath,
+		"mongo_uri":               h.config.Storage.MongoURI,
+		"jwt_expiry_minutes":      jwt.ExpiryMinutes,
+		"id_token_expiry_minutes": jwt.IDTokenExpiryMinutes,
+		"clock_skew_seconds":      jwt.ClockSkewSeconds,
+		"token_length":            jwt.TokenLength,
+		"jwt_private_key":         jwt.PrivateKey, // PEM string
+		"jwt_public_key":          jwt.PublicKey,  // PEM string
+		"magic_link_enabled":      h.config.MagicLinkState().Enabled,
+		"remember_me_enabled":     rememberMe.Enabled,
+		"remember_me_days":        rememberMe.LifetimeDays,
+		"max_sessions_per_user":   sessionLimit.MaxPerUser,
+		"session_eviction":        sessionLimit.EvictionBehavior(),
+		"brands":                  h.config.BrandsState(),
+
+		"secret_reveal_enabled": h.config.SecretRevealState().Enabled,
+
+		"consent_receipts_enabled":     consentReceipts.Enabled,
+		"consent_receipt_jurisdiction": consentReceipts.Jurisdiction,
+	}
+}
+
 // UpdateSettings updates server settings
 func (h *AdminHandler) UpdateSettings(c echo.Context) error {
 	var req struct {
-		Issuer           string `json:"issuer"`
-		ServerHost       string `json:"server_host"`
-		ServerPort       int    `json:"server_port"`
-		StorageType      string `json:"storage_type"`
-		JSONFilePath     string `json:"json_file_path"`
-		MongoURI         string `json:"mongo_uri"`
-		JWTExpiryMinutes int    `json:"jwt_expiry_minutes"`
-		JWTPrivateKey    string `json:"jwt_private_key"`
-		JWTPublicKey     string `json:"jwt_public_key"`
+		Issuer            string `json:"issuer"`
+		ServerHost        string `json:"server_host"`
+		ServerPort        int    `json:"server_port"`
+		StorageType       string `json:"storage_type"`
+		JSONFilePath      string `json:"json_file_path"`
+		MongoURI          string `json:"mongo_uri"`
+		JWTExpiryMinutes  int    `json:"jwt_expiry_minutes"`
+		TokenLength       int    `json:"token_length"`
+		JWTPrivateKey     string `json:"jwt_private_key"`
+		JWTPublicKey      string `json:"jwt_public_key"`
+		MagicLinkEnabled  *bool  `json:"magic_link_enabled"`
+		RememberMeEnabled *bool  `json:"remember_me_enabled"`
+		RememberMeDays    int    `json:"remember_me_days"`
+
+		Brands              *[]configstore.BrandConfig `json:"brands"`
+		SecretRevealEnabled *bool                      `json:"secret_reveal_enabled"`
+
+		IDTokenExpiryMinutes int  `json:"id_token_expiry_minutes"`
+		ClockSkewSeconds     *int `json:"clock_skew_seconds"`
+
+		ConsentReceiptsEnabled     *bool   `json:"consent_receipts_enabled"`
+		ConsentReceiptJurisdiction *string `json:"consent_receipt_jurisdiction"`
+
+		MaxSessionsPerUser *int    `json:"max_sessions_per_user"`
+		SessionEviction    *string `json:"session_eviction"`
 	}
 
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
-
-	// Update config values
-	if req.Issuer != "" {
-		h.config.Issuer = req.Issuer
-	}
-	if req.ServerHost != "" {
-		h.config.Server.Host = req.ServerHost
-	}
-	if req.ServerPort > 0 {
-		h.config.Server.Port = req.ServerPort
+	if req.TokenLength != 0 && req.TokenLength < configstore.MinTokenLength {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("token_length must be at least %d", configstore.MinTokenLength)})
 	}
-	if req.StorageType != "" {
-		h.config.Storage.Type = req.StorageType
+	if req.ClockSkewSeconds != nil && (*req.ClockSkewSeconds < 0 || *req.ClockSkewSeconds > maxClockSkewSeconds) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("clock_skew_seconds must be between 0 and %d", maxClockSkewSeconds)})
 	}
-	if req.JSONFilePath != "" {
-		h.config.Storage.JSONFilePath = req.JSONFilePath
+	if req.MaxSessionsPerUser != nil && *req.MaxSessionsPerUser < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "max_sessions_per_user must not be negative"})
 	}
-	if req.MongoURI != "" {
-		h.config.Storage.MongoURI = req.MongoURI
+	if req.SessionEviction != nil && *req.SessionEviction != configstore.SessionEvictionOldest && *req.SessionEviction != configstore.SessionEvictionReject {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "session_eviction must be \"oldest\" or \"reject\""})
 	}
-	if req.JWTExpiryMinutes > 0 {
-		h.config.JWT.ExpiryMinutes = req.JWTExpiryMinutes
+	if req.Brands != nil {
+		if err := validateBrands(*req.Brands); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
 	}
-	if req.JWTPrivateKey != "" {
-		h.config.JWT.PrivateKey = req.JWTPrivateKey // PEM string
+
+	h.settingsMu.Lock()
+	defer h.settingsMu.Unlock()
+	tag, ok := requireIfMatch(c)
+	if !ok {
+		return nil
 	}
-	if req.JWTPublicKey != "" {
-		h.config.JWT.PublicKey = req.JWTPublicKey // PEM string
+	if current := settingsVersion(h.settingsView()); tag != "*" && tag != current {
+		return versionConflict(c, current)
 	}
 
+	// Update config values
+	h.config.UpdateReloadable(func(cfg *configstore.ConfigData) {
+		if req.Issuer != "" {
+			cfg.Issuer = req.Issuer
+		}
+		if req.ServerHost != "" {
+			cfg.Server.Host = req.ServerHost
+		}
+		if req.ServerPort > 0 {
+			cfg.Server.Port = req.ServerPort
+		}
+		if req.StorageType != "" {
+			cfg.Storage.Type = req.StorageType
+		}
+		if req.JSONFilePath != "" {
+			cfg.Storage.JSONFilePath = req.JSONFilePath
+		}
+		if req.MongoURI != "" {
+			cfg.Storage.MongoURI = req.MongoURI
+		}
+		if req.JWTExpiryMinutes > 0 {
+			cfg.JWT.ExpiryMinutes = req.JWTExpiryMinutes
+		}
+		if req.TokenLength > 0 {
+			cfg.JWT.TokenLength = req.TokenLength
+		}
+		if req.IDTokenExpiryMinutes > 0 {
+			cfg.JWT.IDTokenExpiryMinutes = req.IDTokenExpiryMinutes
+		}
+		if req.ClockSkewSeconds != nil {
+			cfg.JWT.ClockSkewSeconds = *req.ClockSkewSeconds
+		}
+		if req.JWTPrivateKey != "" {
+			cfg.JWT.PrivateKey = req.JWTPrivateKey // PEM string
+		}
+		if req.JWTPublicKey != "" {
+			cfg.JWT.PublicKey = req.JWTPublicKey // PEM string
+		}
+		if req.MagicLinkEnabled != nil {
+			cfg.MagicLink.Enabled = *req.MagicLinkEnabled
+		}
+		if req.RememberMeEnabled != nil {
+			cfg.RememberMe.Enabled = *req.RememberMeEnabled
+		}
+		if req.RememberMeDays > 0 {
+			cfg.RememberMe.LifetimeDays = req.RememberMeDays
+		}
+		if req.MaxSessionsPerUser != nil {
+			cfg.SessionLimit.MaxPerUser = *req.MaxSessionsPerUser
+		}
+		if req.SessionEviction != nil {
+			cfg.SessionLimit.Eviction = *req.SessionEviction
+		}
+		if req.Brands != nil {
+			cfg.Brands = *req.Brands
+		}
+		if req.SecretRevealEnabled != nil {
+			cfg.SecretReveal.Enabled = *req.SecretRevealEnabled
+		}
+		if req.ConsentReceiptsEnabled != nil {
+			cfg.ConsentReceipts.Enabled = *req.ConsentReceiptsEnabled
+		}
+		if req.ConsentReceiptJurisdiction != nil {
+			cfg.ConsentReceipts.Jurisdiction = *req.ConsentReceiptJurisdiction
+		}
+	})
+
 	// Note: ConfigData doesn't have Validate or SaveToTOML methods
 	// These would need to be implemented if runtime config updates are required
 	// For now, return success - config is in memory only
 	h.logAdminAudit(models.AuditActionAdminSettingsUpdated, models.AuditActorAdmin, h.getAdminActor(c),
 		"settings", "server", models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(), nil)
 
-	return c.JSON(http.StatusOK, map[string]string{"message": "Settings updated in memory. Note: Changes are not persisted. Restart may revert changes."})
+	version := settingsVersion(h.settingsView())
+	setVersionETag(c, version)
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Settings updated in memory. Note: Changes are not persisted. Restart may revert changes.",
+		"version": version,
+	})
+}
+
+// ListFeatures returns all experimental feature flags and their state
+func (h *AdminHandler) ListFeatures(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.config.Features())
+}
+
+// UpdateFeature switches an experimental feature flag on or off, saving the
+// change to the config store before applying it
+func (h *AdminHandler) UpdateFeature(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+
+	name := c.Param("name")
+	if !configstore.IsKnownFeature(name) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown feature flag"})
+	}
+
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := c.Bind(&req); err != nil || req.Enabled == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "enabled is required"})
+	}
+
+	if h.configStore == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Config store is not available"})
+	}
+	h.settingsMu.Lock()
+	err := configstore.SaveFeature(c.Request().Context(), h.configStore, name, *req.Enabled)
+	if err == nil {
+		h.config.SetFeature(name, *req.Enabled)
+	}
+	h.settingsMu.Unlock()
+	if err != nil {
+		log.Printf("Failed to save feature flag %s: %v", name, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save feature flag"})
+	}
+
+	h.logAdminAudit(models.AuditActionAdminFeatureToggled, models.AuditActorAdmin, actor,
+		"feature", name, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"enabled": *req.Enabled})
+
+	return c.JSON(http.StatusOK, configstore.FeatureFlag{Name: name, Enabled: *req.Enabled})
+}
+
+// GetMaintenance returns the current maintenance mode settings
+func (h *AdminHandler) GetMaintenance(c echo.Context) error {
+	if _, ok := h.authenticatedAdmin(c); !ok {
+		return nil
+	}
+	return c.JSON(http.StatusOK, h.config.MaintenanceState())
+}
+
+// UpdateMaintenance switches maintenance mode on or off at runtime and updates
+// the Retry-After value and the list of exempt clients, saving them in the config store
+func (h *AdminHandler) UpdateMaintenance(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+
+	var req struct {
+		Enabled           *bool     `json:"enabled"`
+		RetryAfterSeconds *int      `json:"retry_after_seconds"`
+		ExemptClients     *[]string `json:"exempt_clients"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.RetryAfterSeconds != nil && *req.RetryAfterSeconds < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "retry_after_seconds must not be negative"})
+	}
+
+	if h.configStore == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Config store is not available"})
+	}
+	h.settingsMu.Lock()
+	defer h.settingsMu.Unlock()
+
+	state := h.config.MaintenanceState()
+	if req.Enabled != nil {
+		state.Enabled = *req.Enabled
+	}
+	if req.RetryAfterSeconds != nil {
+		state.RetryAfterSeconds = *req.RetryAfterSeconds
+	}
+	if req.ExemptClients != nil {
+		state.ExemptClients = *req.ExemptClients
+	}
+	if err := configstore.SaveMaintenance(c.Request().Context(), h.configStore, state); err != nil {
+		log.Printf("Failed to save maintenance settings: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save maintenance settings"})
+	}
+	h.config.SetMaintenance(state)
+
+	h.logAdminAudit(models.AuditActionAdminMaintenanceSet, models.AuditActorAdmin, actor,
+		"settings", "maintenance", models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"enabled": state.Enabled, "exempt_clients": state.ExemptClients})
+
+	return c.JSON(http.StatusOK, state)
 }
 
 // GetKeys returns signing keys
@@ -822,6 +1782,7 @@ func (h *AdminHandler) GetKeys(c echo.Context) error {
 		CreatedAt time.Time `json:"created_at"`
 		ExpiresAt time.Time `json:"expires_at,omitempty"`
 		Status    string    `json:"status"` // "active", "expired", "inactive"
+		Use       string    `json:"use"`    // "sig", "enc" or "cookie"
 		Cert      *CertInfo `json:"cert,omitempty"`
 		HasCSR    bool      `json:"has_csr"`
 	}
@@ -843,6 +1804,7 @@ func (h *AdminHandler) GetKeys(c echo.Context) error {
 			CreatedAt: key.CreatedAt,
 			ExpiresAt: key.ExpiresAt,
 			Status:    status,
+			Use:       keyUse(key),
 			HasCSR:    key.CSRPEM != "",
 		}
 
@@ -867,6 +1829,144 @@ func (h *AdminHandler) GetKeys(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 }
 
+// KeyHistoryEntry describes a signing or encryption key and how many tokens were
+// issued while it was active
+type KeyHistoryEntry struct {
+	ID              string     `json:"id,omitempty"`
+	KID             string     `json:"kid"`
+	Use             string     `json:"use"`
+	Status          string     `json:"status"` // "active", "inactive", "expired" or "purged"
+	CreatedAt       time.Time  `json:"created_at"`
+	ExpiresAt       time.Time  `json:"expires_at,omitempty"`
+	TokensSigned    int        `json:"tokens_signed"`
+	UnexpiredTokens int        `json:"unexpired_tokens"`
+	PinnedClients   int        `json:"pinned_clients"`
+	PurgeAfter      *time.Time `json:"purge_after,omitempty"`
+	PurgedAt        *time.Time `json:"purged_at,omitempty"`
+}
+
+// GetKeyHistory lists current keys with token usage, followed by keys already purged
+// as recorded in the audit log (GET /api/admin/keys/history)
+func (h *AdminHandler) GetKeyHistory(c echo.Context) error {
+	if _, ok := h.authenticatedAdmin(c); !ok {
+		return nil
+	}
+	keys, err := h.store.GetAllSigningKeys()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get signing keys"})
+	}
+	usage, err := signingKeyUsage(h.store)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to count tokens"})
+	}
+	clients, err := h.store.GetAllClients()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get clients"})
+	}
+	pinned := make(map[string]int)
+	for _, client := range clients {
+		if client.SigningKeyID != "" {
+			pinned[client.SigningKeyID]++
+		}
+	}
+
+	retention := keyRetention(h.config)
+	history := make([]KeyHistoryEntry, 0, len(keys))
+	for _, key := range keys {
+		entry := KeyHistoryEntry{
+			ID:              key.ID,
+			KID:             key.KID,
+			Use:             keyUse(key),
+			Status:          "inactive",
+			CreatedAt:       key.CreatedAt,
+			ExpiresAt:       key.ExpiresAt,
+			TokensSigned:    usage[key.ID].Signed,
+			UnexpiredTokens: usage[key.ID].Unexpired,
+			PinnedClients:   pinned[key.ID],
+		}
+		if key.IsActive {
+			entry.Status = "active"
+		} else if key.IsExpired() {
+			entry.Status = "expired"
+		}
+		if purgeAt := keyPurgeAt(key, retention); !purgeAt.IsZero() {
+			entry.PurgeAfter = &purgeAt
+		}
+		history = append(history, entry)
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].CreatedAt.After(history[j].CreatedAt) })
+
+	for _, action := range []models.AuditAction{models.AuditActionKeyPurged, models.AuditActionAdminKeyDeleted} {
+		logs, err := h.store.GetAuditLogs(models.AuditFilter{Action: action})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get audit logs"})
+		}
+		for _, entry := range logs {
+			purgedAt := entry.Timestamp
+			purged := KeyHistoryEntry{KID: entry.ResourceID, Status: "purged", PurgedAt: &purgedAt}
+			if use, ok := entry.Details["use"].(string); ok && use != "" {
+				purged.Use = use
+			} else {
+				purged.Use = models.KeyUseSignature
+			}
+			if signed, ok := entry.Details["tokens_signed"].(float64); ok {
+				purged.TokensSigned = int(signed)
+			} else if signed, ok := entry.Details["tokens_signed"].(int); ok {
+				purged.TokensSigned = signed
+			}
+			history = append(history, purged)
+		}
+	}
+
+	return c.JSON(http.StatusOK, history)
+}
+
+// DeleteKey removes an inactive key. Keys that are active or signed tokens that have
+// not expired yet cannot be deleted (DELETE /api/admin/keys/:id).
+func (h *AdminHandler) DeleteKey(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	key, err := h.store.GetSigningKey(c.Param("id"))
+	if err != nil || key == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Key not found"})
+	}
+	if key.IsActive {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "The active key cannot be deleted; rotate keys first"})
+	}
+	usage, err := signingKeyUsage(h.store)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to count tokens"})
+	}
+	if unexpired := usage[key.ID].Unexpired; unexpired > 0 {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": fmt.Sprintf("Key signed %d tokens that have not expired yet", unexpired),
+		})
+	}
+	pinned, err := pinnedKeyIDs(h.store)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get clients"})
+	}
+	if pinned[key.ID] {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Clients are pinned to this key"})
+	}
+
+	if err := h.store.DeleteSigningKey(key.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete key"})
+	}
+	// Cookies sealed with a deleted cookie key stop working
+	if key.IsCookieKey() && h.sessionManager != nil {
+		h.sessionManager.ReloadCookieKeys()
+	}
+
+	h.logAdminAudit(models.AuditActionAdminKeyDeleted, models.AuditActorAdmin, actor,
+		"key", key.KID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"id": key.ID, "use": keyUse(key), "tokens_signed": usage[key.ID].Signed})
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Key deleted"})
+}
+
 // RotateKeys generates a new RSA key pair with a self-signed certificate and
 // deactivates the current active key. Old keys remain in storage for JWT validation
 // until their certificate expires. Accepts JSON body: {"validity_days": 90}
@@ -887,26 +1987,6 @@ func (h *AdminHandler) RotateKeys(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate key: " + err.Error()})
 	}
 
-	// Deactivate existing active keys. Keep their ExpiresAt as-is so they remain
-	// available for JWT verification until their own cert validity runs out.
-	existingKeys, err := h.store.GetAllSigningKeys()
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get existing keys: " + err.Error()})
-	}
-	for _, key := range existingKeys {
-		if key.IsActive {
-			key.IsActive = false
-			// If the old key has no cert-based expiry, set a 90-day grace period
-			if key.ExpiresAt.IsZero() {
-				key.ExpiresAt = time.Now().Add(90 * 24 * time.Hour)
-			}
-			if err := h.store.UpdateSigningKey(key); err != nil {
-				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update old key: " + err.Error()})
-			}
-		}
-	}
-
-	// Persist new active key
 	newKey := &models.SigningKey{
 		ID:         uuid.New().String(),
 		KID:        km.KID,
@@ -918,13 +1998,43 @@ func (h *AdminHandler) RotateKeys(c echo.Context) error {
 		CreatedAt:  time.Now(),
 		ExpiresAt:  km.NotAfter, // cert validity drives key lifecycle
 	}
-	if err := h.store.CreateSigningKey(newKey); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create new key: " + err.Error()})
+
+	// Swap the active key set atomically
+	err = h.store.RunInTransaction(func(tx storage.Storage) error {
+		// Deactivate existing active keys. Keep their ExpiresAt as-is so they remain
+		// available for JWT verification until their own cert validity runs out.
+		existingKeys, err := tx.GetAllSigningKeys()
+		if err != nil {
+			return fmt.Errorf("failed to get existing keys: %w", err)
+		}
+		for _, key := range existingKeys {
+			if key.IsActive && key.IsSigningKey() {
+				key.IsActive = false
+				// If the old key has no cert-based expiry, set a 90-day grace period
+				if key.ExpiresAt.IsZero() {
+					key.ExpiresAt = time.Now().Add(90 * 24 * time.Hour)
+				}
+				if err := tx.UpdateSigningKey(key); err != nil {
+					return fmt.Errorf("failed to update old key: %w", err)
+				}
+			}
+		}
+
+		// Persist new active key
+		if err := tx.CreateSigningKey(newKey); err != nil {
+			return fmt.Errorf("failed to create new key: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to rotate keys: " + err.Error()})
 	}
 
 	// Keep JWTManager in sync for tokens issued in this process lifetime
-	h.config.JWT.PrivateKey = km.PrivateKeyPEM
-	h.config.JWT.PublicKey = km.PublicKeyPEM
+	h.config.UpdateReloadable(func(cfg *configstore.ConfigData) {
+		cfg.JWT.PrivateKey = km.PrivateKeyPEM
+		cfg.JWT.PublicKey = km.PublicKeyPEM
+	})
 
 	h.logAdminAudit(models.AuditActionAdminKeysRotated, models.AuditActorAdmin, h.getAdminActor(c),
 		"key", newKey.KID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
@@ -947,6 +2057,59 @@ func (h *AdminHandler) RotateKeys(c echo.Context) error {
 	})
 }
 
+// RotateEncryptionKeys replaces the request object encryption key. The previous key
+// stays published in JWKS and keeps decrypting for a grace period.
+func (h *AdminHandler) RotateEncryptionKeys(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	newKey, err := RotateEncryptionKey(h.store)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to rotate encryption key: " + err.Error()})
+	}
+
+	h.logAdminAudit(models.AuditActionAdminKeysRotated, models.AuditActorAdmin, actor,
+		"key", newKey.KID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"new_kid": newKey.KID, "use": models.KeyUseEncryption})
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":    "Encryption key rotated successfully",
+		"new_key_id": newKey.KID,
+		"algorithm":  newKey.Algorithm,
+	})
+}
+
+// RotateCookieKeys generates a new session cookie key. Cookies sealed with the
+// previous key keep working during its grace period and are sealed again with the
+// new key as they come in (POST /api/admin/settings/rotate-cookie-keys).
+func (h *AdminHandler) RotateCookieKeys(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	if !h.config.SessionCookiesState().Encrypt {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Session cookie encryption is not enabled"})
+	}
+	newKey, err := session.RotateCookieKey(h.store)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to rotate cookie key: " + err.Error()})
+	}
+	if h.sessionManager != nil {
+		h.sessionManager.ReloadCookieKeys()
+	}
+
+	h.logAdminAudit(models.AuditActionAdminKeysRotated, models.AuditActorAdmin, actor,
+		"key", newKey.KID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"new_kid": newKey.KID, "use": models.KeyUseCookie})
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":    "Cookie key rotated successfully",
+		"new_key_id": newKey.KID,
+		"algorithm":  newKey.Algorithm,
+	})
+}
+
 // GenerateKeyCSR generates a PKCS#10 Certificate Signing Request for the signing key
 // identified by :id and persists it on the key record. Returns the CSR as PEM text.
 // GET /api/keys/:id/csr
@@ -1088,8 +2251,8 @@ func (h *AdminHandler) Login(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
-	// Get user from storage
-	user, err := h.store.GetUserByUsername(req.Username)
+	// Get user from storage by username, email or phone number
+	user, err := resolveLoginUser(h.store, h.config, req.Username)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
 	}
@@ -1099,8 +2262,8 @@ func (h *AdminHandler) Login(c echo.Context) error {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid credentials"})
 	}
 
-	// Validate password using bcrypt
-	if !crypto.ValidatePassword(req.Password, user.PasswordHash) {
+	// Validate password, upgrading its hash to the configured algorithm
+	if !checkPassword(h.store, h.config, user, req.Password) {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid credentials"})
 	}
 
@@ -1112,10 +2275,11 @@ func (h *AdminHandler) Login(c echo.Context) error {
 		return c.JSON(http.StatusForbidden, map[string]string{"error": "Access denied: Admin privileges required"})
 	}
 
-	h.logAdminAudit(models.AuditActionAdminLogin, models.AuditActorAdmin, req.Username,
+	h.logAdminAudit(models.AuditActionAdminLogin, models.AuditActorAdmin, user.Username,
 		"user", user.ID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(), nil)
 
-	token, err := crypto.GenerateAdminToken(req.Username, h.adminSecret)
+	// The token names the user by username whichever identifier was entered
+	token, err := crypto.GenerateAdminToken(user.Username, h.adminSecret)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate token"})
 	}
@@ -1325,18 +2489,18 @@ func (h *AdminHandler) ChangePassword(c echo.Context) error {
 	}
 
 	// Verify current password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)); err != nil {
+	if !crypto.ValidatePassword(req.CurrentPassword, user.PasswordHash) {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Current password is incorrect"})
 	}
 
 	// Hash new password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	hashedPassword, err := hashPassword(h.config, req.NewPassword)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to hash password"})
 	}
 
 	// Update password
-	user.PasswordHash = string(hashedPassword)
+	user.PasswordHash = hashedPassword
 	if err := h.store.UpdateUser(user); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update password"})
 	}
@@ -1440,3 +2604,101 @@ func (h *AdminHandler) RevokeToken(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, map[string]string{"message": "Token revoked"})
 }
+
+// TerminateSession ends a user session and revokes refresh tokens bound to it
+func (h *AdminHandler) TerminateSession(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	sessionID := c.Param("id")
+	if sessionID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Session ID is required"})
+	}
+
+	if err := h.store.DeleteUserSession(sessionID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to terminate session"})
+	}
+	if err := h.store.RevokeTokensBySession(sessionID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to revoke session tokens"})
+	}
+
+	h.logAdminAudit(models.AuditActionAdminSessionEnded, models.AuditActorAdmin, actor,
+		"session", sessionID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(), nil)
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Session terminated"})
+}
+
+// MintTestTokenRequest describes a test token to issue (POST /api/admin/tokens/test)
+type MintTestTokenRequest struct {
+	ClientID  string `json:"client_id"`
+	UserID    string `json:"user_id,omitempty"` // Empty for a client-only token
+	Scope     string `json:"scope"`
+	ExpiresIn int    `json:"expires_in,omitempty"` // Seconds, default 300, max 3600
+}
+
+// MintTestToken issues a short-lived access token for a chosen user, client and scope,
+// so downstream APIs can be tested without going through the browser flow.
+func (h *AdminHandler) MintTestToken(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+
+	var req MintTestTokenRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if req.ClientID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "client_id is required"})
+	}
+
+	lifetime := defaultTestTokenLifetime
+	if req.ExpiresIn > 0 {
+		lifetime = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if lifetime > maxTestTokenLifetime {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "expires_in must not exceed 3600 seconds"})
+	}
+
+	client, err := h.store.GetClientByID(req.ClientID)
+	if err != nil || client == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Client not found"})
+	}
+	if client.Scope != "" {
+		allowed := strings.Fields(client.Scope)
+		for _, scope := range strings.Fields(req.Scope) {
+			if !contains(allowed, scope) {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Scope not allowed for client: " + scope})
+			}
+		}
+	}
+	if req.UserID != "" {
+		user, userErr := h.store.GetUserByID(req.UserID)
+		if userErr != nil || user == nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "User not found"})
+		}
+	}
+
+	accessToken, err := crypto.GenerateOpaqueToken(crypto.AccessTokenPrefix, h.config.JWTState().TokenLength)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate token"})
+	}
+	token := models.NewToken(accessToken, "", client.ID, req.UserID, req.Scope, 0)
+	token.ExpiresAt = token.CreatedAt.Add(lifetime)
+	if err := h.store.CreateToken(token); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store token"})
+	}
+
+	h.logAdminAudit(models.AuditActionAdminTestTokenMinted, models.AuditActorAdmin, actor,
+		"token", token.ID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"client_id": client.ID, "user_id": req.UserID, "scope": req.Scope, "expires_in": int(lifetime.Seconds())})
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"id":           token.ID,
+		"access_token": token.AccessToken,
+		"token_type":   token.TokenType,
+		"expires_in":   int(lifetime.Seconds()),
+		"scope":        token.Scope,
+	})
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/access"
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// defaultAdminPolicyTimeout bounds a policy decision when admin_policy.timeout_seconds is not set
+const defaultAdminPolicyTimeout = 3 * time.Second
+
+// adminPolicyCache holds the authorizer for the configured admin policy, rebuilt
+// when the configuration is reloaded with another URL or credentials
+type adminPolicyCache struct {
+	mu         sync.Mutex
+	custom     access.AdminAuthorizer // Set in code; takes precedence over the config
+	config     configstore.AdminPolicyConfig
+	authorizer access.AdminAuthorizer
+}
+
+// SetAdminAuthorizer installs an authorizer consulted for every admin API request
+// in place of the configured OPA endpoint, e.g. one evaluating a rego bundle
+// in-process. The timeout and fail_open settings of admin_policy still apply.
+func (h *AdminHandler) SetAdminAuthorizer(authorizer access.AdminAuthorizer) {
+	h.adminPolicy.mu.Lock()
+	defer h.adminPolicy.mu.Unlock()
+	h.adminPolicy.custom = authorizer
+}
+
+// adminAuthorizer returns the authorizer for admin API requests, or nil when no
+// admin policy is in use
+func (h *AdminHandler) adminAuthorizer() access.AdminAuthorizer {
+	cache := &h.adminPolicy
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.custom != nil {
+		return cache.custom
+	}
+	cfg := h.config.AdminPolicyState()
+	if cfg.URL == "" {
+		return nil
+	}
+	if cache.authorizer == nil || cache.config != cfg {
+		cache.config = cfg
+		cache.authorizer = access.NewOPAAdminAuthorizer(cfg.URL, cfg.AuthHeader)
+	}
+	return cache.authorizer
+}
+
+// PolicyGuard asks the admin policy whether each admin API request may proceed,
+// answering 403 when it is denied. Without a policy every request proceeds.
+// Requests made with a break-glass token are audited first.
+func (h *AdminHandler) PolicyGuard() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !h.auditBreakGlass(c) {
+				return nil
+			}
+			authorizer := h.adminAuthorizer()
+			if authorizer == nil {
+				return next(c)
+			}
+
+			cfg := h.config.AdminPolicyState()
+			timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+			if timeout <= 0 {
+				timeout = defaultAdminPolicyTimeout
+			}
+			req := h.adminPolicyRequest(c)
+			ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+			allowed, reason, err := authorizer.AuthorizeAdmin(ctx, req)
+			cancel()
+			if err != nil {
+				log.Printf("Admin policy failed for %s %s: %v", req.Method, req.Route, err)
+				if cfg.FailOpen {
+					return next(c)
+				}
+				return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Admin policy is unavailable"})
+			}
+			if allowed {
+				return next(c)
+			}
+
+			actor := req.Subject.Username
+			if actor == "" {
+				actor = unknownAdmin
+			}
+			h.logAdminAudit(models.AuditActionAdminAccessDenied, models.AuditActorAdmin, actor,
+				req.Resource.Type, req.Resource.ID, models.AuditStatusFailure, c.RealIP(), c.Request().UserAgent(),
+				map[string]interface{}{"action": req.Action, "route": req.Method + " " + req.Route, "reason": reason})
+			message := "Forbidden by admin policy"
+			if reason != "" {
+				message += ": " + reason
+			}
+			return c.JSON(http.StatusForbidden, map[string]string{"error": message})
+		}
+	}
+}
+
+// adminPolicyRequest describes an admin API request for the policy
+func (h *AdminHandler) adminPolicyRequest(c echo.Context) *access.AdminRequest {
+	route := c.Path()
+	method := c.Request().Method
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(route, "/api/admin"), "/"), "/")
+
+	req := &access.AdminRequest{
+		Method:   method,
+		Route:    route,
+		Action:   segments[0] + "." + adminPolicyVerb(method, segments),
+		Resource: access.AdminResource{Type: segments[0], ID: c.Param("id")},
+	}
+	if names := c.ParamNames(); len(names) > 0 {
+		req.Resource.Params = make(map[string]string, len(names))
+		for i, name := range names {
+			req.Resource.Params[name] = c.ParamValues()[i]
+		}
+	}
+
+	parts := strings.SplitN(c.Request().Header.Get("Authorization"), " ", 2)
+	if len(parts) == 2 && parts[0] == bearerPrefix {
+		if claims, err := crypto.ValidateAdminToken(parts[1], h.adminSecret); err == nil {
+			req.Subject.Authenticated = true
+			req.Subject.Username, _ = claims["sub"].(string)
+			req.Subject.Role, _ = claims["role"].(string)
+			grant, _ := claims["break_glass"].(string)
+			req.Subject.BreakGlass = grant != ""
+			if user, err := h.store.GetUserByUsername(req.Subject.Username); err == nil && user != nil {
+				req.Subject.UserID = user.ID
+				req.Subject.Role = string(user.Role)
+			}
+		}
+	}
+	return req
+}
+
+// adminPolicyVerb names what a request does to its resource type: read, update
+// and delete by method, create for POST to the collection, and otherwise the last
+// fixed path segment, such as "approve" or "rotate-keys"
+func adminPolicyVerb(method string, segments []string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return "read"
+	case http.MethodPut, http.MethodPatch:
+		return "update"
+	case http.MethodDelete:
+		return "delete"
+	}
+	for i := len(segments) - 1; i > 0; i-- {
+		if !strings.HasPrefix(segments[i], ":") {
+			return segments[i]
+		}
+	}
+	return "create"
+}
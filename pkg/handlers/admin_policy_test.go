@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/access"
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestAdminPolicyGuard(t *testing.T) {
+	_, store, _, _ := setupRevokeTest(t)
+	cfg := &configstore.ConfigData{Issuer: "https://example.com"}
+	h := NewAdminHandler(store, cfg, nil)
+	require.NoError(t, store.CreateUser(&models.User{ID: "u-approver", Username: "approver", Email: "approver@example.com", Role: models.RoleAdmin}))
+
+	// Separation of duties: only the approver may approve clients, and nobody else may
+	var inputs []access.AdminRequest
+	opa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input access.AdminRequest `json:"input"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		inputs = append(inputs, body.Input)
+		switch {
+		case body.Input.Action != "clients.approve":
+			_, _ = w.Write([]byte(`{"result": true}`))
+		case body.Input.Subject.Username == "approver":
+			_, _ = w.Write([]byte(`{"result": {"allow": true}}`))
+		default:
+			_, _ = w.Write([]byte(`{"result": {"allow": false, "reason": "only approvers approve clients"}}`))
+		}
+	}))
+	defer opa.Close()
+
+	e := echo.New()
+	api := e.Group("/api/admin", h.PolicyGuard())
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }
+	api.GET("/clients", ok)
+	api.POST("/clients/:id/approve", ok)
+	call := func(method, path, username string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if username != "" {
+			token, err := crypto.GenerateAdminToken(username, h.adminSecret)
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Without a policy nothing changes
+	assert.Equal(t, http.StatusNoContent, call(http.MethodPost, "/api/admin/clients/c1/approve", "operator").Code)
+	assert.Empty(t, inputs)
+
+	cfg.AdminPolicy.URL = opa.URL
+	assert.Equal(t, http.StatusNoContent, call(http.MethodGet, "/api/admin/clients", "operator").Code)
+	rec := call(http.MethodPost, "/api/admin/clients/c1/approve", "operator")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "only approvers approve clients")
+	assert.Equal(t, http.StatusNoContent, call(http.MethodPost, "/api/admin/clients/c1/approve", "approver").Code)
+
+	require.Len(t, inputs, 3)
+	assert.Equal(t, "clients.read", inputs[0].Action)
+	approval := inputs[2]
+	assert.Equal(t, "/api/admin/clients/:id/approve", approval.Route)
+	assert.Equal(t, access.AdminResource{Type: "clients", ID: "c1", Params: map[string]string{"id": "c1"}}, approval.Resource)
+	assert.Equal(t, access.AdminSubject{Authenticated: true, Username: "approver", UserID: "u-approver", Role: string(models.RoleAdmin)}, approval.Subject)
+
+	// An unreachable policy denies unless it fails open
+	opa.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, call(http.MethodGet, "/api/admin/clients", "approver").Code)
+	cfg.AdminPolicy.FailOpen = true
+	assert.Equal(t, http.StatusNoContent, call(http.MethodGet, "/api/admin/clients", "approver").Code)
+}
+
+func TestAdminPolicyVerb(t *testing.T) {
+	tests := []struct {
+		method, route, want string
+	}{
+		{http.MethodGet, "/api/admin/users/:id", "users.read"},
+		{http.MethodPost, "/api/admin/users", "users.create"},
+		{http.MethodPut, "/api/admin/users/:id", "users.update"},
+		{http.MethodPost, "/api/admin/users/:id/disable", "users.disable"},
+		{http.MethodDelete, "/api/admin/keys/:id", "keys.delete"},
+		{http.MethodPost, "/api/admin/settings/rotate-keys", "settings.rotate-keys"},
+	}
+	h := NewAdminHandler(nil, &configstore.ConfigData{}, nil)
+	for _, tt := range tests {
+		c := echo.New().NewContext(httptest.NewRequest(tt.method, "/", nil), httptest.NewRecorder())
+		c.SetPath(tt.route)
+		assert.Equal(t, tt.want, h.adminPolicyRequest(c).Action, tt.route)
+	}
+}
@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestMintTestToken(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	admin := NewAdminHandler(store, h.config, nil)
+
+	adminToken, err := crypto.GenerateAdminToken("qa-admin", admin.adminSecret)
+	require.NoError(t, err)
+
+	mint := func(body, bearer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/tokens/test", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		require.NoError(t, admin.MintTestToken(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	rec := mint(`{"client_id": "`+client.ID+`", "scope": "openid"}`, "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = mint(`{"client_id": "`+client.ID+`", "scope": "openid", "expires_in": 7200}`, adminToken)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = mint(`{"client_id": "`+client.ID+`", "scope": "openid", "expires_in": 120}`, adminToken)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	var resp map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, float64(120), resp["expires_in"])
+
+	stored, err := store.GetTokenByAccessToken(resp["access_token"].(string))
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, client.ID, stored.ClientID)
+	assert.WithinDuration(t, time.Now().Add(2*time.Minute), stored.ExpiresAt, 5*time.Second)
+
+	logs, err := store.GetAuditLogs(models.AuditFilter{Action: models.AuditActionAdminTestTokenMinted})
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "qa-admin", logs[0].Actor)
+}
+
+func TestTerminateSession_RevokesBoundRefreshTokens(t *testing.T) {
+	h, store, client, unbound := setupRevokeTest(t)
+	admin := NewAdminHandler(store, h.config, nil)
+
+	userSession := &models.UserSession{
+		ID:        "session-1",
+		UserID:    "test-user",
+		AuthTime:  time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	require.NoError(t, store.CreateUserSession(userSession))
+
+	bound := &models.Token{
+		ID:           "bound-token-id",
+		AccessToken:  "bound-access-token",
+		RefreshToken: "bound-refresh-token",
+		TokenType:    "Bearer",
+		UserID:       "test-user",
+		ClientID:     client.ID,
+		SessionID:    userSession.ID,
+		CreatedAt:    time.Now(),
+		ExpiresAt:    time.Now().Add(time.Hour),
+	}
+	require.NoError(t, store.CreateToken(bound))
+
+	terminate := func(bearer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "/api/admin/sessions/"+userSession.ID, nil)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(userSession.ID)
+		require.NoError(t, admin.TerminateSession(c))
+		return rec
+	}
+	assert.Equal(t, http.StatusUnauthorized, terminate("").Code)
+	ended, _ := store.GetUserSession(userSession.ID)
+	assert.NotNil(t, ended, "an unauthenticated request must not end the session")
+
+	adminToken, err := crypto.GenerateAdminToken("support", admin.adminSecret)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, terminate(adminToken).Code)
+
+	revoked, _ := store.GetTokenByRefreshToken(bound.RefreshToken)
+	assert.Nil(t, revoked, "session-bound token should be revoked")
+	kept, _ := store.GetTokenByRefreshToken(unbound.RefreshToken)
+	assert.NotNil(t, kept, "unbound token should survive")
+
+	// A bound token that escaped revocation is still refused once its session is gone
+	bound.ID = "stale-token-id"
+	require.NoError(t, store.CreateToken(bound))
+
+	form := "grant_type=refresh_token&refresh_token=" + bound.RefreshToken +
+		"&client_id=" + client.ID + "&client_secret=" + client.Secret
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec := httptest.NewRecorder()
+	require.NoError(t, h.Token(echo.New().NewContext(req, rec)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "session has ended")
+}
+
+func TestDisableAndSoftDeleteUser(t *testing.T) {
+	h, store, client, token := setupRevokeTest(t)
+	admin := NewAdminHandler(store, h.config, nil)
+
+	user := &models.User{ID: token.UserID, Username: "disable-me", Email: "disable-me@example.com"}
+	require.NoError(t, store.CreateUser(user))
+
+	adminToken, err := crypto.GenerateAdminToken("support", admin.adminSecret)
+	require.NoError(t, err)
+	bearer := "Bearer " + adminToken
+	call := func(handler echo.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if bearer != "" {
+			req.Header.Set(echo.HeaderAuthorization, bearer)
+		}
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(user.ID)
+		require.NoError(t, handler(c))
+		return rec
+	}
+	refresh := func() *httptest.ResponseRecorder {
+		form := "grant_type=refresh_token&refresh_token=" + token.RefreshToken +
+			"&client_id=" + client.ID + "&client_secret=" + client.Secret
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Token(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	rec := call(admin.DisableUser, http.MethodPost, "/api/admin/users/"+user.ID+"/disable", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = refresh()
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "disabled")
+
+	// Only an administrator can lift a suspension or lock
+	bearer = ""
+	assert.Equal(t, http.StatusUnauthorized, call(admin.EnableUser, http.MethodPost, "/api/admin/users/"+user.ID+"/enable", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, call(admin.DisableUser, http.MethodPost, "/api/admin/users/"+user.ID+"/disable", "").Code)
+	assert.Equal(t, http.StatusBadRequest, refresh().Code)
+	bearer = "Bearer " + adminToken
+
+	rec = call(admin.EnableUser, http.MethodPost, "/api/admin/users/"+user.ID+"/enable", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = refresh()
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	t.Run("soft delete hides the user from lists", func(t *testing.T) {
+		rec := call(admin.DeleteUser, http.MethodDelete, "/api/admin/users/"+user.ID, "")
+		require.Equal(t, http.StatusNoContent, rec.Code)
+
+		stored, err := store.GetUserByID(user.ID)
+		require.NoError(t, err)
+		require.NotNil(t, stored, "soft delete keeps the record")
+		assert.True(t, stored.IsDeleted())
+
+		var listed []map[string]interface{}
+		rec = call(admin.ListUsers, http.MethodGet, "/api/admin/users", "")
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+		assert.Empty(t, listed)
+
+		rec = call(admin.ListUsers, http.MethodGet, "/api/admin/users?include_deleted=true", "")
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+		assert.Len(t, listed, 1)
+	})
+}
+
+func TestDisableClient_SuspendsWithoutDeleting(t *testing.T) {
+	h, store, client, token := setupRevokeTest(t)
+	admin := NewAdminHandler(store, h.config, nil)
+	adminToken, err := crypto.GenerateAdminToken("support", admin.adminSecret)
+	require.NoError(t, err)
+
+	setDisabled := func(handler echo.HandlerFunc, bearer string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/clients/"+client.ID, nil)
+		if bearer != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(client.ID)
+		require.NoError(t, handler(c))
+		return rec.Code
+	}
+	post := func(handler echo.HandlerFunc, target, form string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		require.NoError(t, handler(echo.New().NewContext(req, rec)))
+		return rec
+	}
+	credentials := "&client_id=" + client.ID + "&client_secret=" + client.Secret
+
+	assert.Equal(t, http.StatusUnauthorized, setDisabled(admin.DisableClient, ""))
+	require.Equal(t, http.StatusOK, setDisabled(admin.DisableClient, adminToken))
+
+	rec := post(h.Token, "/token", "grant_type=client_credentials"+credentials)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "unauthorized_client")
+
+	rec = post(h.Introspect, "/introspect", "token="+token.AccessToken+credentials)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/authorize?response_type=code&scope=openid&client_id="+client.ID+
+		"&redirect_uri="+client.RedirectURIs[0], nil)
+	rec = httptest.NewRecorder()
+	require.NoError(t, h.Authorize(echo.New().NewContext(req, rec)))
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Contains(t, rec.Header().Get("Location"), "error=unauthorized_client")
+
+	// Configuration, secret and tokens survive the suspension
+	stored, err := store.GetClientByID(client.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, client.Secret, stored.Secret)
+	assert.Equal(t, client.RedirectURIs, stored.RedirectURIs)
+
+	assert.Equal(t, http.StatusUnauthorized, setDisabled(admin.EnableClient, ""))
+	require.Equal(t, http.StatusOK, setDisabled(admin.EnableClient, adminToken))
+
+	rec = post(h.Introspect, "/introspect", "token="+token.AccessToken+credentials)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"active":true`)
+}
+
+func TestRevealClientSecret(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	h.config.SecretReveal.Enabled = true
+	admin := NewAdminHandler(store, h.config, nil)
+
+	hash, err := crypto.HashPassword("admin-pass")
+	require.NoError(t, err)
+	require.NoError(t, store.CreateUser(&models.User{ID: "admin-1", Username: "qa-admin", PasswordHash: hash, Role: models.RoleAdmin}))
+	adminToken, err := crypto.GenerateAdminToken("qa-admin", admin.adminSecret)
+	require.NoError(t, err)
+
+	call := func(handler echo.HandlerFunc, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(client.ID)
+		require.NoError(t, handler(c))
+		var resp map[string]interface{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	rec, _ := call(admin.RequestSecretReveal, `{"password": "wrong"}`)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec, resp := call(admin.RequestSecretReveal, `{"password": "admin-pass"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	revealToken := resp["reveal_token"].(string)
+
+	// A reveal token is not an admin session token
+	_, err = crypto.ValidateAdminToken(revealToken, admin.adminSecret)
+	assert.Error(t, err)
+
+	rec, resp = call(admin.RevealClientSecret, `{"reveal_token": "`+revealToken+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "test-secret", resp["client_secret"])
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+
+	rec, _ = call(admin.RevealClientSecret, `{"reveal_token": "`+revealToken+`"}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	h.config.SecretReveal.Enabled = false
+	rec, _ = call(admin.RequestSecretReveal, `{"password": "admin-pass"}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	requested, err := store.GetAuditLogs(models.AuditFilter{Action: models.AuditActionAdminSecretRevealRequested})
+	require.NoError(t, err)
+	assert.Len(t, requested, 3)
+	revealed, err := store.GetAuditLogs(models.AuditFilter{Action: models.AuditActionAdminSecretRevealed})
+	require.NoError(t, err)
+	assert.Len(t, revealed, 2)
+}
+
+func TestGetStatsSecurity(t *testing.T) {
+	h, store, client, token := setupRevokeTest(t)
+	admin := NewAdminHandler(store, h.config, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/revoke",
+		strings.NewReader("token="+token.RefreshToken+"&client_id="+client.ID+"&client_secret=wrong"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec := httptest.NewRecorder()
+	require.NoError(t, h.Revoke(echo.New().NewContext(req, rec)))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	logs, err := store.GetAuditLogs(models.AuditFilter{Action: models.AuditActionClientAuthFailed})
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.Equal(t, client.ID, logs[0].Actor)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/admin/stats", nil)
+	rec = httptest.NewRecorder()
+	require.NoError(t, admin.GetStats(echo.New().NewContext(req, rec)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var stats struct {
+		Security struct {
+			ClientAuthFailures int `json:"client_auth_failures"`
+			FailedLogins       int `json:"failed_logins"`
+			Hourly             []struct {
+				ClientAuthFailures int `json:"client_auth_failures"`
+			} `json:"hourly"`
+		} `json:"security"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+	assert.Equal(t, 1, stats.Security.ClientAuthFailures)
+	assert.Equal(t, 0, stats.Security.FailedLogins)
+	require.NotEmpty(t, stats.Security.Hourly)
+	assert.Equal(t, 1, stats.Security.Hourly[len(stats.Security.Hourly)-1].ClientAuthFailures)
+}
+
+func TestUpdateFeaturePersistsToConfigStore(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+	configStore := configstore.NewJSONConfigStore(filepath.Join(t.TempDir(), "config.json"))
+	require.NoError(t, configStore.SaveConfig(context.Background(), configstore.DefaultConfig()))
+	admin := NewAdminHandler(store, h.config, nil)
+	admin.SetConfigStore(configStore)
+
+	adminToken, err := crypto.GenerateAdminToken("qa-admin", admin.adminSecret)
+	require.NoError(t, err)
+
+	update := func(name, bearer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/admin/features/"+name, strings.NewReader(`{"enabled":true}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if bearer != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("name")
+		c.SetParamValues(name)
+		require.NoError(t, admin.UpdateFeature(c))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, update(configstore.FeatureDeviceFlow, "").Code)
+	assert.False(t, h.config.FeatureEnabled(configstore.FeatureDeviceFlow))
+
+	assert.Equal(t, http.StatusNotFound, update("ciba", adminToken).Code, "unimplemented features have no flag")
+
+	require.Equal(t, http.StatusOK, update(configstore.FeatureDeviceFlow, adminToken).Code)
+	assert.True(t, h.config.FeatureEnabled(configstore.FeatureDeviceFlow))
+	saved, err := configStore.GetConfig(context.Background())
+	require.NoError(t, err)
+	assert.True(t, saved.FeatureEnabled(configstore.FeatureDeviceFlow), "toggle survives a restart")
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Admin updates use optimistic locking, so that the admin UI and automation
+// cannot silently overwrite each other's changes. Users, clients and the server
+// settings are read with their version, also sent as the ETag, and a PUT must
+// name the version it is based on in If-Match. An update based on any other
+// version is refused with 409 Conflict and the current version; "If-Match: *"
+// updates whatever version is current.
+
+// requireIfMatch returns the entity tag an update is based on, without quotes, or
+// "*". It answers 428 Precondition Required and returns false when there is none.
+func requireIfMatch(c echo.Context) (string, bool) {
+	ifMatch := strings.TrimSpace(c.Request().Header.Get("If-Match"))
+	if ifMatch == "" {
+		_ = c.JSON(http.StatusPreconditionRequired, map[string]string{
+			"error": "If-Match with the version being updated is required",
+		})
+		return "", false
+	}
+	return strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`), true
+}
+
+// ifMatchVersion checks If-Match against the current version of a user or
+// client. It answers the request and returns false when the header is missing
+// or names another version.
+func ifMatchVersion(c echo.Context, current int) bool {
+	tag, ok := requireIfMatch(c)
+	if !ok {
+		return false
+	}
+	if tag != "*" && tag != strconv.Itoa(current) {
+		_ = versionConflict(c, current)
+		return false
+	}
+	return true
+}
+
+// setVersionETag sends the version of the entity in a response as its ETag
+func setVersionETag(c echo.Context, version interface{}) {
+	c.Response().Header().Set("ETag", `"`+versionString(version)+`"`)
+}
+
+// versionConflict answers an update based on a version that is no longer current
+func versionConflict(c echo.Context, current interface{}) error {
+	setVersionETag(c, current)
+	return c.JSON(http.StatusConflict, map[string]interface{}{
+		"error":   "The resource has changed since it was read; reload it and try again",
+		"version": current,
+	})
+}
+
+func versionString(version interface{}) string {
+	if n, ok := version.(int); ok {
+		return strconv.Itoa(n)
+	}
+	s, _ := version.(string)
+	return s
+}
+
+// settingsVersion identifies the current server settings. The settings are kept
+// in memory and also change on config reload, so their version is a digest of
+// what GET /api/admin/settings shows rather than a counter.
+func settingsVersion(settings map[string]interface{}) string {
+	data, _ := json.Marshal(settings) // Map keys are sorted, so the encoding is stable
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
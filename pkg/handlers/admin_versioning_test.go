@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestAdminUpdatesRequireCurrentVersion(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	admin := NewAdminHandler(store, h.config, nil)
+	require.NoError(t, store.CreateUser(&models.User{ID: "alice", Username: "alice", Email: "alice@example.com"}))
+
+	call := func(handler echo.HandlerFunc, method, id, ifMatch, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		require.NoError(t, handler(c))
+		var resp map[string]interface{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	t.Run("users", func(t *testing.T) {
+		rec, resp := call(admin.GetUser, http.MethodGet, "alice", "", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `"0"`, rec.Header().Get("ETag"))
+		assert.EqualValues(t, 0, resp["version"])
+
+		body := `{"username": "alice", "email": "alice@example.com", "name": "Alice"}`
+		rec, _ = call(admin.UpdateUser, http.MethodPut, "alice", "", body)
+		assert.Equal(t, http.StatusPreconditionRequired, rec.Code)
+
+		rec, resp = call(admin.UpdateUser, http.MethodPut, "alice", `"0"`, body)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, `"1"`, rec.Header().Get("ETag"))
+		assert.EqualValues(t, 1, resp["version"])
+
+		// A second update based on the same read loses
+		rec, resp = call(admin.UpdateUser, http.MethodPut, "alice", `"0"`, `{"username": "alice", "email": "alice@example.com", "name": "Stale"}`)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.EqualValues(t, 1, resp["version"])
+		assert.Equal(t, `"1"`, rec.Header().Get("ETag"))
+		user, err := store.GetUserByID("alice")
+		require.NoError(t, err)
+		assert.Equal(t, "Alice", user.Name)
+
+		// Internal writes such as sign-in bookkeeping move the version on as well
+		require.NoError(t, store.UpdateUser(user))
+		rec, _ = call(admin.UpdateUser, http.MethodPut, "alice", `W/"1"`, body)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		rec, _ = call(admin.UpdateUser, http.MethodPut, "alice", `W/"2"`, body)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		rec, resp = call(admin.UpdateUser, http.MethodPut, "alice", "*", body)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.EqualValues(t, 4, resp["version"])
+
+		rec, _ = call(admin.UpdateUser, http.MethodPut, "nobody", "*", body)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("clients", func(t *testing.T) {
+		rec, resp := call(admin.GetClient, http.MethodGet, client.ID, "", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.EqualValues(t, 0, resp["version"])
+
+		rec, _ = call(admin.UpdateClient, http.MethodPut, client.ID, "", `{"name": "Renamed"}`)
+		assert.Equal(t, http.StatusPreconditionRequired, rec.Code)
+		rec, resp = call(admin.UpdateClient, http.MethodPut, client.ID, `"0"`, `{"name": "Renamed"}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.EqualValues(t, 1, resp["version"])
+		rec, resp = call(admin.UpdateClient, http.MethodPut, client.ID, `"0"`, `{"name": "Stale"}`)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.EqualValues(t, 1, resp["version"])
+
+		stored, err := store.GetClientByID(client.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Renamed", stored.Name)
+		assert.Equal(t, 1, stored.Version)
+	})
+
+	t.Run("settings", func(t *testing.T) {
+		rec, resp := call(admin.GetSettings, http.MethodGet, "", "", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		version := resp["version"].(string)
+		assert.Equal(t, `"`+version+`"`, rec.Header().Get("ETag"))
+
+		rec, _ = call(admin.UpdateSettings, http.MethodPut, "", "", `{"remember_me_days": 7}`)
+		assert.Equal(t, http.StatusPreconditionRequired, rec.Code)
+		rec, resp = call(admin.UpdateSettings, http.MethodPut, "", `"`+version+`"`, `{"remember_me_days": 7}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		updated := resp["version"].(string)
+		assert.NotEqual(t, version, updated)
+
+		rec, resp = call(admin.UpdateSettings, http.MethodPut, "", `"`+version+`"`, `{"remember_me_days": 30}`)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, updated, resp["version"])
+		assert.Equal(t, 7, h.config.RememberMe.LifetimeDays)
+	})
+}
@@ -54,6 +54,25 @@ func (h *Handlers) logAudit(
 	_ = h.storage.CreateAuditLog(entry)
 }
 
+// logRateLimited records a request refused by the rate limit or quota named limit.
+// The caller is not known yet, so the client address is the actor.
+func (h *Handlers) logRateLimited(c echo.Context, limit string) {
+	h.logAudit(models.AuditActionRateLimited, models.AuditActorSystem, c.RealIP(), "endpoint", c.Path(),
+		models.AuditStatusFailure, c.RealIP(), c.Request().UserAgent(), map[string]interface{}{"limit": limit})
+}
+
+// rejectClientAuth records a failed client authentication and answers invalid_client
+func (h *Handlers) rejectClientAuth(c echo.Context, clientID, description string) error {
+	actor := clientID
+	if actor == "" {
+		actor = c.RealIP()
+	}
+	h.logAudit(models.AuditActionClientAuthFailed, models.AuditActorClient, actor, "client", clientID,
+		models.AuditStatusFailure, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"endpoint": c.Path(), "reason": description})
+	return ErrorInvalidClientAuth(c, description)
+}
+
 // logAdminAudit is the same but is called from AdminHandler which holds a
 // different storage reference (h.store vs h.storage).
 func (h *AdminHandler) logAdminAudit(
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+// auditChainPage is how many entries are read at a time while verifying the chain
+const auditChainPage = 1000
+
+// CheckpointAuditChain signs the current head of the audit chain with the active
+// signing key. It returns nil when the chain is empty or the head is already checkpointed.
+func CheckpointAuditChain(store storage.Storage) (*models.AuditCheckpoint, error) {
+	head, err := store.GetAuditChainHead()
+	if err != nil || head == nil {
+		return nil, err
+	}
+	checkpoints, err := store.ListAuditCheckpoints()
+	if err != nil {
+		return nil, err
+	}
+	if n := len(checkpoints); n > 0 && checkpoints[n-1].Seq >= head.Seq {
+		return nil, nil
+	}
+
+	key, err := store.GetActiveSigningKey()
+	if err != nil || key == nil {
+		return nil, fmt.Errorf("no active signing key: %v", err)
+	}
+	privateKey, err := crypto.ParsePrivateKeyFromPEM(key.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %w", key.ID, err)
+	}
+
+	now := time.Now()
+	signature, err := crypto.SignAuditCheckpoint(key.KID, privateKey, head.Seq, head.Hash, now)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := &models.AuditCheckpoint{
+		ID:           uuid.New().String(),
+		Seq:          head.Seq,
+		Hash:         head.Hash,
+		SigningKeyID: key.ID,
+		Signature:    signature,
+		CreatedAt:    now,
+	}
+	return checkpoint, store.CreateAuditCheckpoint(checkpoint)
+}
+
+// StartAuditCheckpoints periodically signs the head of the audit chain
+func (h *Handlers) StartAuditCheckpoints(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if _, err := CheckpointAuditChain(h.storage); err != nil {
+				log.Printf("Warning: Failed to checkpoint audit log: %v", err)
+			}
+		}
+	}()
+}
+
+// AuditVerification is the result of checking the audit chain and its checkpoints
+type AuditVerification struct {
+	Entries             int      `json:"entries"`
+	FirstSeq            int64    `json:"first_seq"`
+	LastSeq             int64    `json:"last_seq"`
+	Redacted            int      `json:"redacted"`             // Entries erased for privacy, vouched for by a later redaction record
+	CheckpointsVerified int      `json:"checkpoints_verified"` // Checkpoints matching the chain
+	CheckpointsPruned   int      `json:"checkpoints_pruned"`   // Checkpoints covering entries removed by retention
+	Problems            []string `json:"problems"`
+	Warnings            []string `json:"warnings"`
+}
+
+// Valid reports whether no sign of tampering was found
+func (v *AuditVerification) Valid() bool {
+	return len(v.Problems) == 0
+}
+
+// auditRedactionRecord is a redaction together with the sequence number of the entry recording it
+type auditRedactionRecord struct {
+	models.AuditRedaction
+	recordedBy int64
+}
+
+// VerifyAuditChain recomputes the audit hash chain and checks it against every
+// signed checkpoint. Entries before the oldest retained one may have been removed
+// by the retention policy; any other gap, changed entry or missing tail is a problem.
+// A redacted entry must match the latest redaction recorded for it by a later entry
+// whose own content verifies.
+func VerifyAuditChain(store storage.Storage) (*AuditVerification, error) {
+	v := &AuditVerification{Problems: []string{}, Warnings: []string{}}
+	hashes := map[int64]string{}
+	redactions := map[int64]auditRedactionRecord{}
+	var redacted []*models.AuditLog
+	record := func(e *models.AuditLog) {
+		for _, r := range e.Redactions {
+			if r.Seq >= e.Seq {
+				continue // A record can only vouch for entries chained before it
+			}
+			if current, ok := redactions[r.Seq]; !ok || current.recordedBy < e.Seq {
+				redactions[r.Seq] = auditRedactionRecord{r, e.Seq}
+			}
+		}
+	}
+
+	var prev *models.AuditLog
+	for {
+		after := int64(0)
+		if prev != nil {
+			after = prev.Seq
+		}
+		page, err := store.GetAuditChain(after, auditChainPage)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range page {
+			if prev == nil {
+				v.FirstSeq = e.Seq
+				if e.Seq == 1 && e.PrevHash != "" {
+					v.Problems = append(v.Problems, "entry 1 does not start the chain")
+				}
+			} else {
+				if e.Seq != prev.Seq+1 {
+					v.Problems = append(v.Problems, fmt.Sprintf("entries %d to %d are missing", prev.Seq+1, e.Seq-1))
+				}
+				if e.PrevHash != prev.Hash {
+					v.Problems = append(v.Problems, fmt.Sprintf("entry %d does not link to entry %d", e.Seq, prev.Seq))
+				}
+			}
+			switch {
+			case e.Redacted:
+				redacted = append(redacted, e)
+			case e.ComputeHash() != e.Hash:
+				v.Problems = append(v.Problems, fmt.Sprintf("entry %d (%s) was modified", e.Seq, e.ID))
+			default:
+				record(e)
+			}
+			hashes[e.Seq] = e.Hash
+			v.Entries++
+			v.LastSeq = e.Seq
+			prev = e
+		}
+		if len(page) < auditChainPage {
+			break
+		}
+	}
+
+	// A redaction is always recorded after the entries it covers, so checking the
+	// redacted entries latest first settles any record that was itself redacted
+	// before the entries it vouches for
+	for i := len(redacted) - 1; i >= 0; i-- {
+		e := redacted[i]
+		r, ok := redactions[e.Seq]
+		switch {
+		case !ok:
+			v.Problems = append(v.Problems, fmt.Sprintf("entry %d (%s) is redacted without a redaction record", e.Seq, e.ID))
+		case r.Hash != e.Hash || r.RedactedHash != e.ComputeHash():
+			v.Problems = append(v.Problems, fmt.Sprintf("entry %d (%s) was modified", e.Seq, e.ID))
+		default:
+			v.Redacted++
+			record(e)
+		}
+	}
+
+	checkpoints, err := store.ListAuditCheckpoints()
+	if err != nil {
+		return nil, err
+	}
+	for _, cp := range checkpoints {
+		key, err := store.GetSigningKey(cp.SigningKeyID)
+		if err != nil || key == nil {
+			v.Warnings = append(v.Warnings, fmt.Sprintf("checkpoint at entry %d: signing key %s is no longer available", cp.Seq, cp.SigningKeyID))
+			continue
+		}
+		publicKey, err := crypto.ParsePublicKeyFromPEM(key.PublicKey)
+		if err != nil {
+			v.Warnings = append(v.Warnings, fmt.Sprintf("checkpoint at entry %d: %v", cp.Seq, err))
+			continue
+		}
+		claims, err := crypto.VerifyAuditCheckpoint(cp.Signature, publicKey)
+		if err != nil {
+			v.Problems = append(v.Problems, fmt.Sprintf("checkpoint at entry %d: %v", cp.Seq, err))
+			continue
+		}
+		if claims.Seq != cp.Seq || claims.Hash != cp.Hash {
+			v.Problems = append(v.Problems, fmt.Sprintf("checkpoint at entry %d does not match its signature", cp.Seq))
+			continue
+		}
+
+		switch hash, ok := hashes[claims.Seq]; {
+		case ok && hash == claims.Hash:
+			v.CheckpointsVerified++
+		case ok:
+			v.Problems = append(v.Problems, fmt.Sprintf("entry %d differs from the signed checkpoint", claims.Seq))
+		case claims.Seq < v.FirstSeq:
+			v.CheckpointsPruned++
+		default:
+			v.Problems = append(v.Problems, fmt.Sprintf("entry %d covered by a signed checkpoint is missing", claims.Seq))
+		}
+	}
+	return v, nil
+}
+
+// VerifyAuditLog checks the audit hash chain and its signed checkpoints (GET /api/admin/audit/verify)
+func (h *AdminHandler) VerifyAuditLog(c echo.Context) error {
+	result, err := VerifyAuditChain(h.store)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to verify audit log: " + err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"valid":  result.Valid(),
+		"result": result,
+	})
+}
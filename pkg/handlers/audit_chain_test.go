@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestAuditChainVerification(t *testing.T) {
+	_, store, _, _ := setupRevokeTest(t)
+
+	material, err := crypto.GenerateSigningKeyWithCert(30)
+	require.NoError(t, err)
+	require.NoError(t, store.CreateSigningKey(&models.SigningKey{ID: "audit-key", KID: material.KID, Algorithm: "RS256",
+		PrivateKey: material.PrivateKeyPEM, PublicKey: material.PublicKeyPEM, IsActive: true,
+		CreatedAt: material.NotBefore, ExpiresAt: material.NotAfter}))
+
+	for i, actor := range []string{"alice", "bob", "carol"} {
+		require.NoError(t, store.CreateAuditLog(&models.AuditLog{ID: actor, Timestamp: time.Now().Add(time.Duration(i) * time.Second),
+			Action: models.AuditActionLogin, Actor: actor, Details: map[string]interface{}{"attempt": i + 1}}))
+	}
+	head, err := store.GetAuditChainHead()
+	require.NoError(t, err)
+	require.EqualValues(t, 3, head.Seq)
+
+	checkpoint, err := CheckpointAuditChain(store)
+	require.NoError(t, err)
+	require.NotNil(t, checkpoint)
+	again, err := CheckpointAuditChain(store)
+	require.NoError(t, err)
+	assert.Nil(t, again, "an unchanged head is not checkpointed twice")
+
+	result, err := VerifyAuditChain(store)
+	require.NoError(t, err)
+	assert.True(t, result.Valid(), result.Problems)
+	assert.Equal(t, 3, result.Entries)
+	assert.Equal(t, 1, result.CheckpointsVerified)
+
+	// Erasure rewrites an entry but keeps it linked into the chain; it only verifies
+	// once a later chained entry records the redaction
+	redactions, err := store.AnonymizeAuditLogs([]string{"bob"}, "erased-1")
+	require.NoError(t, err)
+	require.Len(t, redactions, 1)
+	result, err = VerifyAuditChain(store)
+	require.NoError(t, err)
+	assert.Contains(t, result.Problems, "entry 2 (bob) is redacted without a redaction record")
+	require.NoError(t, store.CreateAuditLog(&models.AuditLog{ID: "erasure", Timestamp: time.Now().Add(3 * time.Second),
+		Action: models.AuditActionAdminUserErased, Actor: "admin", Redactions: redactions}))
+	result, err = VerifyAuditChain(store)
+	require.NoError(t, err)
+	assert.True(t, result.Valid(), result.Problems)
+	assert.Equal(t, 1, result.Redacted)
+
+	// Editing an entry behind the storage API is detected, redacted or not
+	entries, err := store.GetAuditLogs(models.AuditFilter{Actor: "erased-1"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	entries[0].Action = models.AuditActionLogout
+	entries, err = store.GetAuditLogs(models.AuditFilter{Actor: "carol"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	entries[0].Action = models.AuditActionLogout
+	result, err = VerifyAuditChain(store)
+	require.NoError(t, err)
+	assert.False(t, result.Valid())
+	assert.Contains(t, result.Problems, "entry 2 (bob) was modified")
+	assert.Contains(t, result.Problems, "entry 3 (carol) was modified")
+
+	// Marking an entry redacted does not exempt it from verification
+	entries[0].Action = models.AuditActionLogin
+	entries[0].Redacted = true
+	result, err = VerifyAuditChain(store)
+	require.NoError(t, err)
+	assert.Contains(t, result.Problems, "entry 3 (carol) is redacted without a redaction record")
+}
@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// Built-in authenticator step names usable in configured sign-in flows
+const (
+	StepPassword = "password"
+	StepEmailOTP = "email_otp"
+
+	defaultFlowName = "default"
+	passwordACR     = "urn:mace:incommon:iap:silver"
+
+	// rememberMeState carries the "keep me signed in" choice across flow steps
+	rememberMeState       = "remember_me"
+	defaultRememberMeDays = 30
+)
+
+// defaultAuthFlow is used when no configured flow applies
+var defaultAuthFlow = configstore.AuthFlowConfig{Name: defaultFlowName, Steps: []string{StepPassword}, ACR: passwordACR}
+
+// AuthStep is the context an authenticator runs in while a sign-in flow executes
+type AuthStep struct {
+	// Session is the pending authorization session. It is nil for sign-ins that are
+	// not part of an authorization request, which only run the password step.
+	Session *models.AuthSession
+	// User is the user identified by earlier steps, or nil for the first step
+	User *models.User
+}
+
+// State returns a value an authenticator stored for this sign-in
+func (s *AuthStep) State(key string) string {
+	if s.Session == nil {
+		return ""
+	}
+	return s.Session.StepState[key]
+}
+
+// SetState stores a value for this sign-in; it is saved with the authorization session
+func (s *AuthStep) SetState(key, value string) {
+	if s.Session == nil {
+		return
+	}
+	if s.Session.StepState == nil {
+		s.Session.StepState = make(map[string]string)
+	}
+	if value == "" {
+		delete(s.Session.StepState, key)
+		return
+	}
+	s.Session.StepState[key] = value
+}
+
+// Authenticator is one step of a sign-in flow, such as a password or one-time code check
+type Authenticator interface {
+	// Prompt labels the single "code" field of the login form for this step.
+	// The password step renders its own username and password form.
+	Prompt() string
+	// AMR returns the authentication method references (RFC 8176) the step contributes
+	AMR() []string
+	// Authenticate verifies the submitted login form. The first step identifies the
+	// user; later steps must return step.User. Errors are shown to the user.
+	Authenticate(c echo.Context, step *AuthStep) (*models.User, error)
+}
+
+// loginError is a sign-in failure message shown to the user on the login page
+type loginError string
+
+func (e loginError) Error() string { return string(e) }
+
+// Challenger is implemented by authenticators that must send something to the user,
+// such as an emailed code, before the step's form is shown
+type Challenger interface {
+	Challenge(c echo.Context, step *AuthStep) error
+}
+
+// RegisterAuthenticator makes an authenticator available to configured sign-in flows
+// under the given step name, replacing any built-in authenticator of that name
+func (h *Handlers) RegisterAuthenticator(name string, authenticator Authenticator) {
+	if h.authenticators == nil {
+		h.authenticators = make(map[string]Authenticator)
+	}
+	h.authenticators[name] = authenticator
+}
+
+// authenticator returns the authenticator registered for a step name, falling back
+// to the built-in password and email one-time code steps
+func (h *Handlers) authenticator(name string) (Authenticator, bool) {
+	if authenticator, ok := h.authenticators[name]; ok {
+		return authenticator, true
+	}
+	switch name {
+	case StepPassword:
+		return passwordAuthenticator{h: h}, true
+	case StepEmailOTP:
+		return emailOTPAuthenticator{h: h}, true
+	}
+	return nil, false
+}
+
+// authFlowFor selects the sign-in flow for an authorization session: the flow named
+// by the client, then the first flow matching a requested ACR, then the default flow
+func (h *Handlers) authFlowFor(session *models.AuthSession) (configstore.AuthFlowConfig, error) {
+	flows := h.config.AuthFlowsState()
+	findFlow := func(name string) (configstore.AuthFlowConfig, bool) {
+		for _, flow := range flows {
+			if flow.Name == name {
+				return flow, true
+			}
+		}
+		return configstore.AuthFlowConfig{}, false
+	}
+
+	if session != nil {
+		if client, err := h.storage.GetClientByID(session.ClientID); err == nil && client != nil && client.AuthFlow != "" {
+			flow, ok := findFlow(client.AuthFlow)
+			if !ok {
+				return configstore.AuthFlowConfig{}, fmt.Errorf("client %s uses unknown sign-in flow %q", client.ID, client.AuthFlow)
+			}
+			return flow, nil
+		}
+		for _, acr := range session.ACRValues {
+			for _, flow := range flows {
+				if flow.ACR != "" && flow.ACR == acr {
+					return flow, nil
+				}
+			}
+		}
+	}
+	if flow, ok := findFlow(defaultFlowName); ok {
+		return flow, nil
+	}
+	return defaultAuthFlow, nil
+}
+
+// currentLoginStep returns the flow and the name of the next step for the sign-in
+// attached to authSessionID
+func (h *Handlers) currentLoginStep(authSessionID string) (configstore.AuthFlowConfig, *models.AuthSession, string, error) {
+	var session *models.AuthSession
+	if authSessionID != "" {
+		session, _ = h.storage.GetAuthSession(authSessionID)
+	}
+	flow, err := h.authFlowFor(session)
+	if err != nil {
+		return flow, session, "", err
+	}
+	if len(flow.Steps) == 0 {
+		return flow, session, "", fmt.Errorf("sign-in flow %q has no steps", flow.Name)
+	}
+	completed := 0
+	if session != nil {
+		completed = len(session.CompletedSteps)
+	}
+	if completed >= len(flow.Steps) {
+		completed = len(flow.Steps) - 1
+	}
+	return flow, session, flow.Steps[completed], nil
+}
+
+// sessionSatisfiesFlow reports whether an existing user session authenticated with
+// every method the sign-in flow for authSession would require
+func (h *Handlers) sessionSatisfiesFlow(authSession *models.AuthSession, userSession *models.UserSession) bool {
+	flow, err := h.authFlowFor(authSession)
+	if err != nil {
+		return false
+	}
+	// Any sign-in, including a magic link, satisfies plain password sign-in
+	if isPasswordOnlyFlow(flow) {
+		return true
+	}
+	for _, stepName := range flow.Steps {
+		authenticator, ok := h.authenticator(stepName)
+		if !ok {
+			return false
+		}
+		for _, method := range authenticator.AMR() {
+			if !contains(userSession.AMR, method) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isPasswordOnlyFlow reports whether flow is plain password sign-in, the only flow
+// alternative sign-in methods such as magic links may substitute for
+func isPasswordOnlyFlow(flow configstore.AuthFlowConfig) bool {
+	return len(flow.Steps) == 1 && flow.Steps[0] == StepPassword
+}
+
+// runLoginStep verifies the login form for the current step of the sign-in flow.
+// When the flow is complete the user is signed in; otherwise the next step is
+// challenged and its form rendered.
+func (h *Handlers) runLoginStep(c echo.Context, authSessionID string) error {
+	flow, session, stepName, err := h.currentLoginStep(authSessionID)
+	if err != nil {
+		return h.renderLoginPageWithError(c, authSessionID, "Sign-in is not available for this application")
+	}
+	if session == nil && authSessionID != "" {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidRequest, "Invalid or expired authorization session")
+	}
+	// Without an authorization session there is nowhere to keep progress, so only
+	// single-step flows can run
+	if session == nil && len(flow.Steps) > 1 {
+		flow = defaultAuthFlow
+		stepName = StepPassword
+	}
+	authenticator, ok := h.authenticator(stepName)
+	if !ok {
+		return h.renderLoginPageWithError(c, authSessionID, "Sign-in is not available for this application")
+	}
+
+	step := &AuthStep{Session: session}
+	if session != nil && session.PendingUserID != "" {
+		step.User, err = h.storage.GetUserByID(session.PendingUserID)
+		if err != nil || step.User == nil {
+			return h.renderLoginPageWithError(c, authSessionID, "Your sign-in has expired, please start again")
+		}
+	}
+
+	user, authErr := authenticator.Authenticate(c, step)
+	if session != nil {
+		// Authenticators may have updated their state, e.g. counted an attempt
+		if updateErr := h.storage.UpdateAuthSession(session); updateErr != nil {
+			return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to update authorization session")
+		}
+	}
+	if authErr != nil {
+		return h.renderLoginPageWithError(c, authSessionID, authErr.Error())
+	}
+	if step.User != nil && user.ID != step.User.ID {
+		return h.renderLoginPageWithError(c, authSessionID, "Your sign-in has expired, please start again")
+	}
+
+	// "Keep me signed in" is chosen on the first step and applied when the flow completes
+	remember := c.FormValue("remember_me") != "" || step.State(rememberMeState) != ""
+
+	amr := appendUnique(nil, authenticator.AMR()...)
+	if session != nil {
+		amr = appendUnique(session.AMR, authenticator.AMR()...)
+		session.CompletedSteps = append(session.CompletedSteps, stepName)
+		session.PendingUserID = user.ID
+		session.AMR = amr
+
+		if len(session.CompletedSteps) < len(flow.Steps) {
+			if remember {
+				step.SetState(rememberMeState, "1")
+			}
+			return h.beginLoginStep(c, session, &AuthStep{Session: session, User: user}, flow.Steps[len(session.CompletedSteps)])
+		}
+	}
+
+	method := strings.Join(flow.Steps, "+")
+	if session != nil {
+		// The flow is complete; clear its progress so a later sign-in starts over
+		method = strings.Join(session.CompletedSteps, "+")
+		session.CompletedSteps = nil
+		session.PendingUserID = ""
+		session.StepState = nil
+		if err := h.storage.UpdateAuthSession(session); err != nil {
+			return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to update authorization session")
+		}
+	}
+	acr := flow.ACR
+	if acr == "" {
+		acr = passwordACR
+	}
+	return h.completeLogin(c, user, authSessionID, method, acr, amr, remember)
+}
+
+// beginLoginStep saves progress, sends any challenge for the next step and renders its form
+func (h *Handlers) beginLoginStep(c echo.Context, session *models.AuthSession, step *AuthStep, stepName string) error {
+	authenticator, ok := h.authenticator(stepName)
+	if !ok {
+		return h.renderLoginPageWithError(c, session.ID, "Sign-in is not available for this application")
+	}
+	var challengeErr error
+	if challenger, ok := authenticator.(Challenger); ok {
+		challengeErr = challenger.Challenge(c, step)
+	}
+	if err := h.storage.UpdateAuthSession(session); err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to update authorization session")
+	}
+	if challengeErr != nil {
+		return h.renderLoginPageWithError(c, session.ID, challengeErr.Error())
+	}
+	return h.renderLoginPage(c, session.ID)
+}
+
+// restartLogin discards the progress of a multi-step sign-in
+func (h *Handlers) restartLogin(c echo.Context, authSessionID string) error {
+	if session, err := h.storage.GetAuthSession(authSessionID); err == nil && session != nil {
+		session.CompletedSteps = nil
+		session.PendingUserID = ""
+		session.StepState = nil
+		session.AMR = nil
+		if err := h.storage.UpdateAuthSession(session); err != nil {
+			return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to update authorization session")
+		}
+	}
+	return h.renderLoginPage(c, authSessionID)
+}
+
+// appendUnique appends values to list, skipping values already present
+func appendUnique(list []string, values ...string) []string {
+	result := append([]string(nil), list...)
+	for _, v := range values {
+		found := false
+		for _, existing := range result {
+			if existing == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestAuthFlow_PasswordThenEmailOTP(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	mailer := &fakeMailer{}
+	h.mailer = mailer
+	h.config.AuthFlows = []configstore.AuthFlowConfig{
+		{Name: "mfa", Steps: []string{StepPassword, StepEmailOTP}, ACR: "urn:example:mfa"},
+	}
+	client.AuthFlow = "mfa"
+	require.NoError(t, store.UpdateClient(client))
+
+	hash, err := crypto.HashPassword("secret")
+	require.NoError(t, err)
+	user := &models.User{ID: "mfa-user", Username: "mfa", Email: "mfa@example.com", PasswordHash: hash}
+	require.NoError(t, store.CreateUser(user))
+	authSession := &models.AuthSession{
+		ID:          "mfa-auth-session",
+		ClientID:    client.ID,
+		RedirectURI: client.RedirectURIs[0],
+		Scope:       "openid",
+		CreatedAt:   time.Now(),
+		ExpiresAt:   time.Now().Add(10 * time.Minute),
+	}
+	require.NoError(t, store.CreateAuthSession(authSession))
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/login?auth_session="+authSession.ID, strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Login(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	// The password step leads to the code step and emails a code
+	rec := post(url.Values{"username": {"mfa"}, "password": {"secret"}})
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Enter the code we emailed you")
+	require.Equal(t, user.Email, mailer.to)
+	code := regexp.MustCompile(`\d{6}`).FindString(mailer.body)
+	require.NotEmpty(t, code)
+
+	rec = post(url.Values{"code": {"not-it"}})
+	assert.Contains(t, rec.Body.String(), "Incorrect code")
+
+	rec = post(url.Values{"code": {code}})
+	require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+	assert.Equal(t, "/consent?auth_session="+authSession.ID, rec.Header().Get("Location"))
+
+	updated, err := store.GetAuthSession(authSession.ID)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, updated.UserID)
+	assert.Equal(t, "urn:example:mfa", updated.ACR)
+	assert.Equal(t, []string{"pwd", "otp"}, updated.AMR)
+	assert.Equal(t, "password+email_otp", updated.AuthenticationMethod)
+	assert.Empty(t, updated.CompletedSteps)
+}
+
+func TestAuthFlowFor_SelectsByClientThenACR(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	h.config.AuthFlows = []configstore.AuthFlowConfig{
+		{Name: "strong", Steps: []string{StepPassword, StepEmailOTP}, ACR: "urn:example:strong"},
+		{Name: "other", Steps: []string{StepPassword}},
+	}
+	session := &models.AuthSession{ClientID: client.ID}
+
+	flow, err := h.authFlowFor(session)
+	require.NoError(t, err)
+	assert.Equal(t, defaultFlowName, flow.Name)
+
+	session.ACRValues = []string{"urn:example:unknown", "urn:example:strong"}
+	flow, err = h.authFlowFor(session)
+	require.NoError(t, err)
+	assert.Equal(t, "strong", flow.Name)
+
+	client.AuthFlow = "other"
+	require.NoError(t, store.UpdateClient(client))
+	flow, err = h.authFlowFor(session)
+	require.NoError(t, err)
+	assert.Equal(t, "other", flow.Name)
+
+	client.AuthFlow = "missing"
+	require.NoError(t, store.UpdateClient(client))
+	_, err = h.authFlowFor(session)
+	assert.Error(t, err)
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+const (
+	emailOTPTTL         = 10 * time.Minute
+	emailOTPMaxAttempts = 5
+	emailOTPDigits      = 6
+
+	// Step state keys for the email one-time code
+	emailOTPStateCode     = "email_otp"
+	emailOTPStateAttempts = "email_otp_attempts"
+)
+
+// passwordAuthenticator checks a login identifier (the username, email address or
+// phone number, as configured) and password, requiring a CAPTCHA after repeated failures
+type passwordAuthenticator struct {
+	h *Handlers
+}
+
+func (a passwordAuthenticator) Prompt() string { return "Password" }
+
+func (a passwordAuthenticator) AMR() []string { return []string{"pwd"} }
+
+func (a passwordAuthenticator) Authenticate(c echo.Context, step *AuthStep) (*models.User, error) {
+	h := a.h
+	username := c.FormValue("username")
+	password := c.FormValue("password")
+
+	// After repeated failures, require a CAPTCHA before checking the password
+	if h.loginCaptchaRequired(c.RealIP(), username) {
+		if err := h.verifyLoginCaptcha(c.FormValue, c.RealIP()); err != nil {
+			h.logAudit(models.AuditActionLoginFailed, models.AuditActorUser, username,
+				"user", "", models.AuditStatusFailure,
+				c.RealIP(), c.Request().UserAgent(),
+				map[string]interface{}{"reason": "captcha failed"})
+			return nil, loginError("Please complete the CAPTCHA challenge")
+		}
+	}
+
+	// Authenticate user
+	user, err := h.userByLoginIdentifier(username)
+	if err != nil || user == nil || (step.User != nil && user.ID != step.User.ID) {
+		h.recordLoginFailure(c.RealIP(), username)
+		h.logAudit(models.AuditActionLoginFailed, models.AuditActorUser, username,
+			"user", "", models.AuditStatusFailure,
+			c.RealIP(), c.Request().UserAgent(),
+			map[string]interface{}{"reason": "user not found"})
+		return nil, loginError("Invalid username or password")
+	}
+
+	// Validate password
+	if !checkPassword(h.storage, h.config, user, password) {
+		h.recordLoginFailure(c.RealIP(), username)
+		h.logAudit(models.AuditActionLoginFailed, models.AuditActorUser, username,
+			"user", user.ID, models.AuditStatusFailure,
+			c.RealIP(), c.Request().UserAgent(),
+			map[string]interface{}{"reason": "invalid password"})
+		return nil, loginError("Invalid username or password")
+	}
+	h.clearLoginFailures(username)
+
+	return user, nil
+}
+
+// userByLoginIdentifier finds the user a sign-in identifier refers to, trying the
+// configured login identifier fields in order
+func (h *Handlers) userByLoginIdentifier(identifier string) (*models.User, error) {
+	return resolveLoginUser(h.storage, h.config, identifier)
+}
+
+// loginIdentifierLabels name the login identifier fields on the login page
+var loginIdentifierLabels = map[string]string{
+	configstore.LoginIdentifierUsername: "username",
+	configstore.LoginIdentifierEmail:    "email",
+	configstore.LoginIdentifierPhone:    "phone number",
+}
+
+// loginIdentifierLabel describes what may be entered as the sign-in identifier,
+// e.g. "Username or email"
+func (h *Handlers) loginIdentifierLabel() string {
+	fields := h.config.LoginIdentifierFields()
+	labels := make([]string, len(fields))
+	for i, field := range fields {
+		labels[i] = loginIdentifierLabels[field]
+	}
+	label := labels[len(labels)-1]
+	if n := len(labels); n > 1 {
+		label = strings.Join(labels[:n-1], ", ") + " or " + label
+	}
+	return strings.ToUpper(label[:1]) + label[1:]
+}
+
+// emailOTPAuthenticator emails a one-time code to the user identified by an earlier
+// step and checks it. It cannot be the first step of a flow.
+type emailOTPAuthenticator struct {
+	h *Handlers
+}
+
+func (a emailOTPAuthenticator) Prompt() string { return "Enter the code we emailed you" }
+
+func (a emailOTPAuthenticator) AMR() []string { return []string{"otp"} }
+
+func (a emailOTPAuthenticator) Challenge(c echo.Context, step *AuthStep) error {
+	if step.User == nil || step.User.Email == "" {
+		return loginError("This account cannot receive sign-in codes by email")
+	}
+	if a.h.mailer == nil {
+		return loginError("Email sign-in codes are not available")
+	}
+
+	code, err := generateNumericCode(emailOTPDigits)
+	if err != nil {
+		return loginError("Failed to send sign-in code, please try again")
+	}
+	expiresAt := time.Now().Add(emailOTPTTL).Unix()
+	step.SetState(emailOTPStateCode, hashOTP(code)+":"+strconv.FormatInt(expiresAt, 10))
+	step.SetState(emailOTPStateAttempts, "")
+
+	body := "Your sign-in code is " + code + ". It expires in " + emailOTPTTL.String() + ".\r\n\r\n" +
+		"If you did not try to sign in, you can ignore this email.\r\n"
+	if err := a.h.mailer.Send(step.User.Email, "Your sign-in code", body); err != nil {
+		return loginError("Failed to send sign-in code, please try again")
+	}
+	return nil
+}
+
+func (a emailOTPAuthenticator) Authenticate(c echo.Context, step *AuthStep) (*models.User, error) {
+	if step.User == nil {
+		return nil, loginError("Your sign-in has expired, please start again")
+	}
+	hash, expiry, ok := strings.Cut(step.State(emailOTPStateCode), ":")
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if !ok || err != nil || time.Now().Unix() > expiresAt {
+		return nil, loginError("The code has expired, please start again")
+	}
+	attempts, _ := strconv.Atoi(step.State(emailOTPStateAttempts))
+	if attempts >= emailOTPMaxAttempts {
+		return nil, loginError("Too many incorrect codes, please start again")
+	}
+
+	code := strings.TrimSpace(c.FormValue("code"))
+	if subtle.ConstantTimeCompare([]byte(hashOTP(code)), []byte(hash)) != 1 {
+		step.SetState(emailOTPStateAttempts, strconv.Itoa(attempts+1))
+		a.h.logAudit(models.AuditActionLoginFailed, models.AuditActorUser, step.User.Username,
+			"user", step.User.ID, models.AuditStatusFailure,
+			c.RealIP(), c.Request().UserAgent(),
+			map[string]interface{}{"reason": "invalid code", "method": StepEmailOTP})
+		return nil, loginError("Incorrect code")
+	}
+
+	step.SetState(emailOTPStateCode, "")
+	step.SetState(emailOTPStateAttempts, "")
+	return step.User, nil
+}
+
+// generateNumericCode returns a random code of the given number of decimal digits
+func generateNumericCode(digits int) (string, error) {
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", digits, n), nil
+}
+
+func hashOTP(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,14 +1,20 @@
 package handlers
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 
-	"github.com/prasenjit-net/openid-golang/pkg/crypto"
 	"github.com/prasenjit-net/openid-golang/pkg/models"
 	"github.com/prasenjit-net/openid-golang/pkg/session"
 )
@@ -45,25 +51,38 @@ func (h *Handlers) validateAuthorizationRequest(c echo.Context, clientID, redire
 
 	// Support both authorization code flow and implicit flow
 	if responseType != ResponseTypeCode && responseType != ResponseTypeIDToken && responseType != ResponseTypeTokenIDToken {
-		return nil, authorizationError(c, redirectURI, responseType, ErrorUnsupportedResponseType, "Only 'code', 'id_token', and 'token id_token' response types are supported", state)
+		return nil, h.authorizationError(c, redirectURI, responseType, ErrorUnsupportedResponseType, "Only 'code', 'id_token', and 'token id_token' response types are supported", state)
+	}
+	if !h.config.GrantsState().ResponseTypeEnabled(responseType) {
+		return nil, h.authorizationError(c, redirectURI, responseType, ErrorUnsupportedResponseType, "Response type '"+responseType+"' is disabled on this server", state)
 	}
 
 	if !strings.Contains(scope, "openid") {
-		return nil, authorizationError(c, redirectURI, responseType, ErrorInvalidScope, "scope must contain 'openid'", state)
+		return nil, h.authorizationError(c, redirectURI, responseType, ErrorInvalidScope, "scope must contain 'openid'", state)
 	}
 
 	// Nonce is REQUIRED for implicit flow (OIDC Core Section 3.2.2.1)
 	if responseType == ResponseTypeIDToken || responseType == ResponseTypeTokenIDToken {
 		nonce := c.QueryParam("nonce")
 		if nonce == "" {
-			return nil, authorizationError(c, redirectURI, responseType, ErrorInvalidRequest, "nonce parameter is required for implicit flow", state)
+			return nil, h.authorizationError(c, redirectURI, responseType, ErrorInvalidRequest, "nonce parameter is required for implicit flow", state)
 		}
 	}
 
+	if msg := validateAuthorizationParams(c.QueryParams()); msg != "" {
+		return nil, h.authorizationError(c, redirectURI, responseType, ErrorInvalidRequest, msg, state)
+	}
+
 	// Validate client
 	client, err := h.storage.GetClientByID(clientID)
 	if err != nil || client == nil {
-		return nil, authorizationError(c, redirectURI, responseType, ErrorUnauthorizedClient, "Client not found", state)
+		return nil, h.authorizationError(c, redirectURI, responseType, ErrorUnauthorizedClient, "Client not found", state)
+	}
+	if !client.IsApproved() {
+		return nil, h.authorizationError(c, redirectURI, responseType, ErrorUnauthorizedClient, "Client registration has not been approved", state)
+	}
+	if client.Disabled {
+		return nil, h.authorizationError(c, redirectURI, responseType, ErrorUnauthorizedClient, "Client is disabled", state)
 	}
 
 	// Validate redirect URI
@@ -71,9 +90,76 @@ func (h *Handlers) validateAuthorizationRequest(c echo.Context, clientID, redire
 		return nil, jsonError(c, http.StatusBadRequest, ErrorInvalidRequest, "Invalid redirect_uri")
 	}
 
+	if msg := clientRequirementError(client, responseType, c.QueryParams()); msg != "" {
+		return nil, h.authorizationError(c, redirectURI, responseType, ErrorInvalidRequest, msg, state)
+	}
+
+	h.markClientUsed(client)
 	return client, nil
 }
 
+// validateAuthorizationParams checks the optional parameters kept in the authorization
+// session: PKCE (RFC 7636 Section 4.2), display, max_age and the claims request. It returns a
+// description of the first problem, or "" when they are acceptable.
+func validateAuthorizationParams(query url.Values) string {
+	challenge := query.Get("code_challenge")
+	method := query.Get("code_challenge_method")
+	if method != "" && challenge == "" {
+		return "code_challenge_method requires code_challenge"
+	}
+	if challenge != "" {
+		if method != "" && method != "plain" && method != "S256" {
+			return "code_challenge_method must be 'plain' or 'S256'"
+		}
+		if len(challenge) < 43 || len(challenge) > 128 || strings.Trim(challenge, pkceAlphabet) != "" {
+			return "code_challenge must be 43 to 128 unreserved characters (RFC 3986)"
+		}
+	}
+
+	if display := query.Get("display"); display != "" && !contains(displayValues, display) {
+		return "display must be one of page, popup, touch or wap"
+	}
+
+	if maxAge := query.Get("max_age"); maxAge != "" {
+		if n, err := strconv.Atoi(maxAge); err != nil || n < 0 {
+			return "max_age must be a non-negative integer"
+		}
+	}
+
+	if claims := query.Get("claims"); claims != "" {
+		var parsed map[string]interface{}
+		if err := json.Unmarshal([]byte(claims), &parsed); err != nil {
+			return "claims must be a JSON object"
+		}
+	}
+	return ""
+}
+
+// clientRequirementError checks the stricter rules an administrator has set for one
+// client, such as RequirePKCE. It returns a description of the first rule the request
+// breaks, or "" when it meets them all.
+func clientRequirementError(client *models.Client, responseType string, query url.Values) string {
+	if client.RequirePKCE {
+		if responseType != ResponseTypeCode {
+			return "This client must use the authorization code flow (response_type=code) with PKCE"
+		}
+		if query.Get("code_challenge") == "" {
+			return "This client requires PKCE: code_challenge is required"
+		}
+		if query.Get("code_challenge_method") != "S256" {
+			return "This client requires PKCE with code_challenge_method=S256"
+		}
+	}
+	return ""
+}
+
+// displayValues are the display parameter values the login and consent pages
+// have layouts for (OIDC Core Section 3.1.2.1)
+var displayValues = []string{"page", "popup", "touch", "wap"}
+
+// pkceAlphabet is the unreserved character set code challenges are drawn from
+const pkceAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-._~"
+
 // handlePromptParameter handles the prompt parameter logic
 // Returns true and an error/response if prompt was handled and flow should stop
 // Returns false and nil if normal flow should continue
@@ -85,21 +171,22 @@ func (h *Handlers) handlePromptParameter(c echo.Context, authSession *models.Aut
 
 	switch prompt {
 	case "none":
-		// Must not display any UI - check if consent already given
-		if !authSession.ConsentGiven {
-			return true, authorizationError(c, redirectURI, authSession.ResponseType, ErrorConsentRequired, "User consent required but prompt=none", state)
+		// Must not display any UI - check if consent already given or implied
+		client, _ := h.storage.GetClientByID(authSession.ClientID)
+		if !authSession.ConsentGiven && !h.applyImplicitConsent(c, authSession, userSession, client) {
+			return true, h.authorizationError(c, redirectURI, authSession.ResponseType, ErrorConsentRequired, "User consent required but prompt=none", state)
 		}
 		// Proceed to generate code/tokens
 		return true, h.completeAuthorization(c, authSession, userSession)
 	case "login":
 		// Force re-authentication
-		return true, c.Redirect(http.StatusFound, "/login?auth_session="+authSession.ID)
+		return true, c.Redirect(http.StatusFound, h.path("/login?auth_session="+authSession.ID))
 	case "consent":
 		// Force consent screen
-		return true, c.Redirect(http.StatusFound, "/consent?auth_session="+authSession.ID)
+		return true, c.Redirect(http.StatusFound, h.path("/consent?auth_session="+authSession.ID))
 	case "select_account":
 		// Show account selection (simplified: redirect to login)
-		return true, c.Redirect(http.StatusFound, "/login?auth_session="+authSession.ID)
+		return true, c.Redirect(http.StatusFound, h.path("/login?auth_session="+authSession.ID))
 	}
 
 	return false, nil // Unknown prompt value, ignore
@@ -121,35 +208,73 @@ func (h *Handlers) checkAndApplyConsent(authSession *models.AuthSession, userSes
 
 // handleAuthenticatedUser processes authorization when user is already authenticated
 func (h *Handlers) handleAuthenticatedUser(c echo.Context, authSession *models.AuthSession, userSession *models.UserSession, clientID, scope, redirectURI, state string) error {
-	// Handle prompt parameter - if it was handled, return immediately
-	handled, err := h.handlePromptParameter(c, authSession, userSession, redirectURI, state)
-	if handled {
-		return err
+	// An existing session does not outlive the account being disabled, locked or deleted
+	if user, err := h.storage.GetUserByID(userSession.UserID); err != nil || user == nil || !user.CanAuthenticate() {
+		return h.requireLogin(c, authSession)
 	}
 
-	// Check max_age parameter
+	// Step up when the existing session did not pass every step of the client's sign-in flow
+	if !h.sessionSatisfiesFlow(authSession, userSession) {
+		return h.requireLogin(c, authSession)
+	}
+
+	// Check max_age parameter, also for prompt=none
 	if authSession.MaxAge > 0 {
 		if !userSession.IsAuthTimeFresh(authSession.MaxAge) {
 			// Re-authentication required
-			return c.Redirect(http.StatusFound, "/login?auth_session="+authSession.ID)
+			return h.requireLogin(c, authSession)
 		}
 	}
 
+	// Handle prompt parameter - if it was handled, return immediately
+	handled, err := h.handlePromptParameter(c, authSession, userSession, redirectURI, state)
+	if handled {
+		return err
+	}
+
 	// Note: Don't check consent if prompt=consent was handled above
 	// Check if user has previously consented to this client
 	_ = h.checkAndApplyConsent(authSession, userSession, clientID, scope)
 
 	if !authSession.ConsentGiven {
 		// Redirect to consent screen
-		return c.Redirect(http.StatusFound, "/consent?auth_session="+authSession.ID)
+		return c.Redirect(http.StatusFound, h.path("/consent?auth_session="+authSession.ID))
 	}
 
 	// All checks passed, complete authorization
 	return h.completeAuthorization(c, authSession, userSession)
 }
 
-// Authorize handles the authorization endpoint (GET /authorize)
+// Authorize handles the authorization endpoint (GET/POST /authorize)
 func (h *Handlers) Authorize(c echo.Context) error {
+	// Responses carry codes and tokens, or reveal whether the user is signed in
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set("Pragma", "no-cache")
+
+	// POST requests carry the same parameters form-encoded (OpenID Connect Core §3.1.2.1).
+	// They are moved into the query so both methods share the parameter handling below.
+	if c.Request().Method == http.MethodPost {
+		if !strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationForm) {
+			return jsonError(c, http.StatusBadRequest, ErrorInvalidRequest, "POST authorization requests must be application/x-www-form-urlencoded")
+		}
+		if err := c.Request().ParseForm(); err != nil {
+			return jsonError(c, http.StatusBadRequest, ErrorInvalidRequest, "Invalid form body")
+		}
+		c.Request().URL.RawQuery = c.Request().PostForm.Encode()
+	}
+
+	// Relying parties of a trusted federation are registered on first use
+	if err := h.establishFederatedClient(c); err != nil {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidClient, err.Error())
+	}
+
+	// Unpack a request object before any query parameter is read
+	if c.Request().URL.Query().Get("request") != "" {
+		if err := h.applyRequestObject(c); err != nil {
+			return jsonError(c, http.StatusBadRequest, ErrorInvalidRequestObject, err.Error())
+		}
+	}
+
 	query := c.QueryParams()
 
 	// Parse required parameters
@@ -160,11 +285,35 @@ func (h *Handlers) Authorize(c echo.Context) error {
 	state := query.Get("state")
 
 	// Validate parameters
+	// A nil client means an error response has already been written
 	client, err := h.validateAuthorizationRequest(c, clientID, redirectURI, responseType, scope, state)
-	if err != nil {
+	if err != nil || client == nil {
 		return err
 	}
-	_ = client // Client validated but not used directly in this function
+
+	prompts := strings.Fields(query.Get("prompt"))
+	promptNone := contains(prompts, "none")
+	if promptNone && len(prompts) > 1 {
+		return h.authorizationError(c, redirectURI, responseType, ErrorInvalidRequest, "prompt=none cannot be combined with other values", state)
+	}
+
+	// Check if user is already authenticated, as the user id_token_hint names
+	userSession := session.GetUserSession(c)
+	authenticated := userSession != nil && userSession.IsAuthenticated()
+	if hint := query.Get("id_token_hint"); hint != "" {
+		subject, err := h.idTokenHintSubject(hint, clientID)
+		if err != nil {
+			return h.authorizationError(c, redirectURI, responseType, ErrorInvalidRequest, "Invalid id_token_hint", state)
+		}
+		if authenticated && subject != userSession.UserID {
+			authenticated = false
+		}
+	}
+
+	// Silent authentication without a matching session ends here, before any state is stored
+	if promptNone && !authenticated {
+		return h.authorizationError(c, redirectURI, responseType, ErrorLoginRequired, "The user must sign in", state)
+	}
 
 	// Create authorization session to store request parameters
 	authSession, err := h.sessionManager.CreateAuthSession(c, clientID, redirectURI, responseType, scope, state)
@@ -172,14 +321,12 @@ func (h *Handlers) Authorize(c echo.Context) error {
 		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to create authorization session")
 	}
 
-	// Check if user is already authenticated
-	userSession := session.GetUserSession(c)
-	if userSession != nil && userSession.IsAuthenticated() {
+	if authenticated {
 		return h.handleAuthenticatedUser(c, authSession, userSession, clientID, scope, redirectURI, state)
 	}
 
 	// User not authenticated, redirect to login
-	return c.Redirect(http.StatusFound, "/login?auth_session="+authSession.ID)
+	return c.Redirect(http.StatusFound, h.path("/login?auth_session="+authSession.ID))
 }
 
 // Login handles the login page (GET/POST /login)
@@ -191,27 +338,44 @@ func (h *Handlers) Login(c echo.Context) error {
 		return h.renderLoginPage(c, authSessionID)
 	}
 
-	// POST - handle login
-	username := c.FormValue("username")
-	password := c.FormValue("password")
+	// POST - the user cancelled sign-in, report access_denied back to the RP
+	if c.FormValue("action") == "cancel" {
+		return h.cancelLogin(c, authSessionID)
+	}
+
+	// POST - the user asked for a sign-in link by email
+	if c.FormValue("action") == "magic_link" {
+		return h.requestMagicLink(c, authSessionID)
+	}
 
-	// Authenticate user
-	user, err := h.storage.GetUserByUsername(username)
-	if err != nil || user == nil {
-		h.logAudit(models.AuditActionLoginFailed, models.AuditActorUser, username,
-			"user", "", models.AuditStatusFailure,
-			c.RealIP(), c.Request().UserAgent(),
-			map[string]interface{}{"reason": "user not found"})
-		return h.renderLoginPageWithError(c, authSessionID, "Invalid username or password")
+	// POST - the user abandoned a multi-step sign-in and wants to start over
+	if c.FormValue("action") == "restart" {
+		return h.restartLogin(c, authSessionID)
 	}
 
-	// Validate password
-	if !crypto.ValidatePassword(password, user.PasswordHash) {
-		h.logAudit(models.AuditActionLoginFailed, models.AuditActorUser, username,
+	// POST - verify the current step of the sign-in flow
+	return h.runLoginStep(c, authSessionID)
+}
+
+// rememberMeLifetime returns how long "keep me signed in" sessions last
+func (h *Handlers) rememberMeLifetime() time.Duration {
+	days := h.config.RememberMeState().LifetimeDays
+	if days <= 0 {
+		days = defaultRememberMeDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// completeLogin starts a user session for an authenticated user and resumes the
+// pending authorization session, if any, at the consent step. A remembered session
+// is created when the user asked to stay signed in and the option is enabled.
+func (h *Handlers) completeLogin(c echo.Context, user *models.User, authSessionID, authMethod, acr string, amr []string, remember bool) error {
+	if !user.CanAuthenticate() {
+		h.logAudit(models.AuditActionLoginFailed, models.AuditActorUser, user.Username,
 			"user", user.ID, models.AuditStatusFailure,
 			c.RealIP(), c.Request().UserAgent(),
-			map[string]interface{}{"reason": "invalid password"})
-		return h.renderLoginPageWithError(c, authSessionID, "Invalid username or password")
+			map[string]interface{}{"reason": "account unavailable", "method": authMethod})
+		return h.renderLoginPageWithError(c, authSessionID, "This account is disabled or locked")
 	}
 
 	// Get authorization session if exists
@@ -225,7 +389,7 @@ func (h *Handlers) Login(c echo.Context) error {
 
 		// For admin UI, verify user has admin role
 		if authSession.ClientID == "admin-ui" && user.Role != "admin" {
-			h.logAudit(models.AuditActionLoginFailed, models.AuditActorUser, username,
+			h.logAudit(models.AuditActionLoginFailed, models.AuditActorUser, user.Username,
 				"user", user.ID, models.AuditStatusFailure,
 				c.RealIP(), c.Request().UserAgent(),
 				map[string]interface{}{"reason": "not admin", "client_id": authSession.ClientID})
@@ -233,20 +397,32 @@ func (h *Handlers) Login(c echo.Context) error {
 		}
 	}
 
-	// Create user session with authentication details
-	authMethod := "password"
-	acr := "urn:mace:incommon:iap:silver" // Authentication Context Class Reference
-	amr := []string{"pwd"}                // Authentication Methods References
+	// Refuse the sign-in when the user is at the session limit and it is set to reject
+	if h.sessionLimitRejects(user.ID) {
+		h.logAudit(models.AuditActionLoginFailed, models.AuditActorUser, user.Username,
+			"user", user.ID, models.AuditStatusFailure,
+			c.RealIP(), c.Request().UserAgent(),
+			map[string]interface{}{"reason": "session limit reached", "method": authMethod})
+		return h.renderLoginPageWithError(c, authSessionID, "You are signed in on too many devices. Sign out on another device and try again.")
+	}
 
-	userSession, sessionErr := h.sessionManager.CreateUserSession(c, user.ID, authMethod, acr, amr)
+	// Create user session with authentication details
+	var userSession *models.UserSession
+	var sessionErr error
+	if remember && h.config.RememberMeState().Enabled {
+		userSession, sessionErr = h.sessionManager.CreatePersistentUserSession(c, user.ID, authMethod, acr, amr, h.rememberMeLifetime())
+	} else {
+		userSession, sessionErr = h.sessionManager.CreateUserSession(c, user.ID, authMethod, acr, amr)
+	}
 	if sessionErr != nil {
 		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to create user session")
 	}
+	h.evictExcessSessions(c, user, userSession)
 
 	// Audit successful login
-	h.logAudit(models.AuditActionLogin, models.AuditActorUser, username,
+	h.logAudit(models.AuditActionLogin, models.AuditActorUser, user.Username,
 		"user", user.ID, models.AuditStatusSuccess,
-		c.RealIP(), c.Request().UserAgent(), nil)
+		c.RealIP(), c.Request().UserAgent(), map[string]interface{}{"method": authMethod})
 
 	// Update auth session with user info
 	if authSession != nil {
@@ -262,7 +438,7 @@ func (h *Handlers) Login(c echo.Context) error {
 		}
 
 		// Redirect to consent screen
-		return c.Redirect(http.StatusFound, "/consent?auth_session="+authSession.ID)
+		return c.Redirect(http.StatusFound, h.path("/consent?auth_session="+authSession.ID))
 	}
 
 	// No auth session, just logged in (e.g., admin UI direct access)
@@ -272,6 +448,65 @@ func (h *Handlers) Login(c echo.Context) error {
 	})
 }
 
+// Logout ends the user's session (GET/POST /logout). Refresh tokens issued to
+// clients that bind them to the session are revoked along with it. GET only shows
+// a confirmation page; the session ends when its form is posted back with the
+// logout token, so other sites cannot sign users out or revoke their tokens.
+func (h *Handlers) Logout(c echo.Context) error {
+	userSession := session.GetUserSession(c)
+	if userSession == nil {
+		return c.Redirect(http.StatusFound, h.path("/login"))
+	}
+	if c.Request().Method != http.MethodPost || !validLogoutToken(userSession.ID, c.FormValue("logout_token")) {
+		return h.renderLogoutPage(c, userSession)
+	}
+
+	if err := h.storage.RevokeTokensBySession(userSession.ID); err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to revoke session tokens")
+	}
+	if err := h.sessionManager.DeleteUserSession(c, userSession.ID); err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to end user session")
+	}
+
+	h.logAudit(models.AuditActionLogout, models.AuditActorUser, userSession.UserID,
+		"user", userSession.UserID, models.AuditStatusSuccess,
+		c.RealIP(), c.Request().UserAgent(), nil)
+
+	return c.Redirect(http.StatusFound, h.path("/login"))
+}
+
+// logoutToken returns the token the logout confirmation form posts back. It is
+// derived from the session ID, which only the browser holding the session cookie
+// knows, so a cross-site form cannot supply it.
+func logoutToken(sessionID string) string {
+	mac := hmac.New(sha256.New, []byte(sessionID))
+	mac.Write([]byte("logout"))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validLogoutToken reports whether token is the logout token of the session
+func validLogoutToken(sessionID, token string) bool {
+	return hmac.Equal([]byte(token), []byte(logoutToken(sessionID)))
+}
+
+func (h *Handlers) renderLogoutPage(c echo.Context, userSession *models.UserSession) error {
+	brand, locale := h.pageBrandAndLocale(c, nil)
+	data := struct {
+		BasePath    string
+		Brand       pageBrand
+		Locale      string
+		LogoutToken string
+	}{
+		BasePath:    h.config.BasePath(),
+		Brand:       brand,
+		Locale:      locale,
+		LogoutToken: logoutToken(userSession.ID),
+	}
+	c.Response().Header().Set("Content-Type", "text/html; charset=utf-8")
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return h.logoutTmpl.Execute(c.Response().Writer, data)
+}
+
 // Consent handles the consent page (GET/POST /consent)
 func (h *Handlers) Consent(c echo.Context) error {
 	authSessionID := c.QueryParam("auth_session")
@@ -292,7 +527,7 @@ func (h *Handlers) Consent(c echo.Context) error {
 	// Get user session
 	userSession := session.GetUserSession(c)
 	if userSession == nil || !userSession.IsAuthenticated() {
-		return c.Redirect(http.StatusFound, "/login?auth_session="+authSessionID)
+		return c.Redirect(http.StatusFound, h.path("/login?auth_session="+authSessionID))
 	}
 
 	// Get client info
@@ -302,6 +537,11 @@ func (h *Handlers) Consent(c echo.Context) error {
 	}
 
 	if c.Request().Method == "GET" {
+		// First-party clients are authorized without asking, except for devices:
+		// approving one is always confirmed so a code cannot be phished silently
+		if authSession.ResponseType != responseTypeDevice && h.applyImplicitConsent(c, authSession, userSession, client) {
+			return h.completeAuthorization(c, authSession, userSession)
+		}
 		// Render consent page
 		return h.renderConsentPage(c, authSession, client)
 	}
@@ -314,7 +554,7 @@ func (h *Handlers) Consent(c echo.Context) error {
 			"client", authSession.ClientID, models.AuditStatusSuccess,
 			c.RealIP(), c.Request().UserAgent(),
 			map[string]interface{}{"scope": authSession.Scope})
-		return authorizationError(c, authSession.RedirectURI, authSession.ResponseType, ErrorAccessDenied, "User denied consent", authSession.State)
+		return h.denyAuthorization(c, authSession, "User denied consent")
 	}
 
 	// Update auth session with consent
@@ -332,24 +572,61 @@ func (h *Handlers) Consent(c echo.Context) error {
 		map[string]interface{}{"scope": authSession.Scope})
 
 	// Save consent for future authorization requests
-	// Check if consent already exists
-	existingConsent, err := h.storage.GetConsent(userSession.UserID, authSession.ClientID)
-	if err == nil && existingConsent != nil {
-		// Update existing consent with new scopes
-		existingConsent.Scopes = authSession.ConsentedScopes
-		_ = h.storage.UpdateConsent(existingConsent)
-	} else {
-		// Create new consent record
-		newConsent := models.NewConsent(userSession.UserID, authSession.ClientID, authSession.ConsentedScopes)
-		_ = h.storage.CreateConsent(newConsent)
-	}
+	h.saveConsent(userSession.UserID, authSession.ClientID, authSession.ConsentedScopes, false)
+	_, locale := h.pageBrandAndLocale(c, authSession)
+	h.recordConsentReceipt(userSession.UserID, locale, client, authSession.ConsentedScopes)
 
 	// Complete authorization
 	return h.completeAuthorization(c, authSession, userSession)
 }
 
+// cancelLogin handles the cancel button on the login page. The pending auth
+// session is terminated and the RP receives an access_denied error so the
+// flow is not left dangling.
+func (h *Handlers) cancelLogin(c echo.Context, authSessionID string) error {
+	if authSessionID == "" {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidRequest, "auth_session is required")
+	}
+
+	authSession, err := h.storage.GetAuthSession(authSessionID)
+	if err != nil || authSession == nil {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidRequest, "Invalid or expired authorization session")
+	}
+
+	h.logAudit(models.AuditActionLoginCancel, models.AuditActorUser, "",
+		"client", authSession.ClientID, models.AuditStatusSuccess,
+		c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"scope": authSession.Scope})
+
+	return h.denyAuthorization(c, authSession, "User cancelled the sign-in")
+}
+
+// denyAuthorization ends an authorization flow with access_denied. The auth
+// session is deleted and the error is returned to the RP using the response
+// mode implied by the original response_type, with state preserved.
+func (h *Handlers) denyAuthorization(c echo.Context, authSession *models.AuthSession, description string) error {
+	if authSession.ResponseType == responseTypeDevice {
+		return h.finishDeviceAuthorization(c, authSession, session.GetUserSession(c), false)
+	}
+	_ = h.sessionManager.FinishAuthSession(c, authSession.ID, session.ConsentDenied)
+	return h.authorizationError(c, authSession.RedirectURI, authSession.ResponseType, ErrorAccessDenied, description, authSession.State)
+}
+
 // completeAuthorization completes the authorization flow
 func (h *Handlers) completeAuthorization(c echo.Context, authSession *models.AuthSession, userSession *models.UserSession) error {
+	// Conditional-access policies may deny the authorization or ask for a step-up
+	if proceed, err := h.checkAccessPolicies(c, authSession, userSession); !proceed {
+		return err
+	}
+	// So may an essential acr claim the session does not satisfy
+	if proceed, err := h.checkEssentialACR(c, authSession, userSession); !proceed {
+		return err
+	}
+
+	if authSession.ResponseType == responseTypeDevice {
+		return h.finishDeviceAuthorization(c, authSession, userSession, true)
+	}
+
 	// Get user
 	user, err := h.storage.GetUserByID(userSession.UserID)
 	if err != nil || user == nil {
@@ -365,18 +642,26 @@ func (h *Handlers) completeAuthorization(c echo.Context, authSession *models.Aut
 	// Handle implicit flow (id_token or token id_token)
 	if authSession.ResponseType == ResponseTypeIDToken || authSession.ResponseType == ResponseTypeTokenIDToken {
 		var accessToken string
-		var err error
+		jwtManager, err := h.jwtManagerFor(client)
+		if err != nil {
+			return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate ID token")
+		}
+		// Without an access token the client cannot call userinfo, so the ID token
+		// carries the user claims even for minimal clients (OIDC Core 1.0 Section 5.4)
+		if authSession.ResponseType == ResponseTypeIDToken {
+			jwtManager = jwtManager.WithMinimalIDTokens(false)
+		}
 
 		// If response_type includes 'token', generate access token first
 		if authSession.ResponseType == ResponseTypeTokenIDToken {
-			accessToken, err = h.jwtManager.GenerateAccessToken(user, client.ID, authSession.Scope)
+			accessToken, err = jwtManager.GenerateAccessToken(user, client.ID, authSession.Scope)
 			if err != nil {
 				return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate access token")
 			}
 		}
 
 		// Generate ID token with auth_time, acr, amr, and at_hash (if access token present)
-		idToken, err := h.jwtManager.GenerateIDTokenWithClaims(
+		idToken, err := jwtManager.GenerateIDTokenWithClaims(
 			user,
 			authSession.ClientID,
 			authSession.Nonce,
@@ -399,8 +684,11 @@ func (h *Handlers) completeAuthorization(c echo.Context, authSession *models.Aut
 			fragment += fmt.Sprintf("&access_token=%s&token_type=Bearer&expires_in=3600", accessToken)
 		}
 
+		// Identify the issuer so the client can detect mix-up attacks (RFC 9207)
+		fragment += "&iss=" + url.QueryEscape(h.config.Issuer)
+
 		// Clean up auth session
-		_ = h.storage.DeleteAuthSession(authSession.ID)
+		_ = h.sessionManager.FinishAuthSession(c, authSession.ID, session.ConsentApproved)
 
 		redirectURL := fmt.Sprintf("%s#%s", authSession.RedirectURI, fragment)
 		return c.Redirect(http.StatusFound, redirectURL)
@@ -408,20 +696,25 @@ func (h *Handlers) completeAuthorization(c echo.Context, authSession *models.Aut
 
 	// Handle authorization code flow
 	// Create authorization code
-	authCode := models.NewAuthorizationCode(authSession.ClientID, user.ID, authSession.RedirectURI, authSession.Scope)
+	authCode, err := h.newAuthorizationCode(authSession.ClientID, user.ID, authSession.RedirectURI, authSession.Scope)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate authorization code")
+	}
 	authCode.Nonce = authSession.Nonce
 	authCode.CodeChallenge = authSession.CodeChallenge
 	authCode.CodeChallengeMethod = authSession.CodeChallengeMethod
+	authCode.ClaimsLocales = authSession.ClaimsLocales
+	authCode.SessionID = userSession.ID
 
 	if err := h.storage.CreateAuthorizationCode(authCode); err != nil {
 		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to create authorization code")
 	}
 
 	// Clean up auth session
-	_ = h.storage.DeleteAuthSession(authSession.ID)
+	_ = h.sessionManager.FinishAuthSession(c, authSession.ID, session.ConsentApproved)
 
 	// Redirect back to client with authorization code
-	redirectURL := fmt.Sprintf("%s?code=%s&state=%s", authSession.RedirectURI, authCode.Code, authSession.State)
+	redirectURL := fmt.Sprintf("%s?code=%s&state=%s&iss=%s", authSession.RedirectURI, authCode.Code, authSession.State, url.QueryEscape(h.config.Issuer))
 	return c.Redirect(http.StatusFound, redirectURL)
 }
 
@@ -430,12 +723,56 @@ func (h *Handlers) renderLoginPage(c echo.Context, authSessionID string) error {
 }
 
 func (h *Handlers) renderLoginPageWithError(c echo.Context, authSessionID, errorMsg string) error {
+	return h.renderLoginTemplate(c, authSessionID, errorMsg, "")
+}
+
+func (h *Handlers) renderLoginTemplate(c echo.Context, authSessionID, errorMsg, infoMsg string) error {
+	// Show the form for the current step of the sign-in flow
+	flow, session, step, err := h.currentLoginStep(authSessionID)
+	if err != nil {
+		flow, step = defaultAuthFlow, StepPassword
+		if errorMsg == "" {
+			errorMsg = "Sign-in is not available for this application"
+		}
+	}
+	prompt := ""
+	if authenticator, ok := h.authenticator(step); ok {
+		prompt = authenticator.Prompt()
+	}
+
+	brand, locale := h.pageBrandAndLocale(c, session)
 	data := struct {
-		AuthSessionID string
-		ErrorMessage  string
+		BasePath         string
+		Brand            pageBrand
+		Locale           string
+		AuthSessionID    string
+		ErrorMessage     string
+		InfoMessage      string
+		MagicLinkEnabled bool
+		Captcha          *loginCaptchaWidget
+		Step             string
+		StepPrompt       string
+		Restartable      bool
+		RememberMe       bool
+		IdentifierLabel  string
+		Display          string
 	}{
-		AuthSessionID: authSessionID,
-		ErrorMessage:  errorMsg,
+		BasePath:         h.config.BasePath(),
+		Brand:            brand,
+		Locale:           locale,
+		AuthSessionID:    authSessionID,
+		ErrorMessage:     errorMsg,
+		InfoMessage:      infoMsg,
+		MagicLinkEnabled: h.magicLinkEnabled() && isPasswordOnlyFlow(flow),
+		Step:             step,
+		StepPrompt:       prompt,
+		Restartable:      session != nil && len(session.CompletedSteps) > 0,
+		RememberMe:       h.config.RememberMeState().Enabled,
+		IdentifierLabel:  h.loginIdentifierLabel(),
+		Display:          pageDisplay(session),
+	}
+	if step == StepPassword {
+		data.Captcha = h.loginCaptchaWidgetFor(c.RealIP(), c.FormValue("username"))
 	}
 	c.Response().Header().Set("Content-Type", "text/html; charset=utf-8")
 	return h.loginTmpl.Execute(c.Response().Writer, data)
@@ -470,6 +807,10 @@ func (h *Handlers) renderConsentPage(c echo.Context, authSession *models.AuthSes
 	}
 
 	clientName := client.Name
+	if clientName == "" {
+		clientName = client.ClientName
+	}
+	clientName = localizedValue(client.ClientNameLocalized, clientName, preferredLocales(c, authSession))
 	if clientName == "" {
 		clientName = "App"
 	}
@@ -478,16 +819,25 @@ func (h *Handlers) renderConsentPage(c echo.Context, authSession *models.AuthSes
 		initials = string([]rune(words[0])[0:1]) + string([]rune(words[1])[0:1])
 	}
 
+	brand, locale := h.pageBrandAndLocale(c, authSession)
 	data := struct {
+		BasePath      string
+		Brand         pageBrand
+		Locale        string
 		AuthSessionID string
 		ClientName    string
 		Initials      string
 		Scopes        []consentScopeItem
+		Display       string
 	}{
+		BasePath:      h.config.BasePath(),
+		Brand:         brand,
+		Locale:        locale,
 		AuthSessionID: authSession.ID,
 		ClientName:    clientName,
 		Initials:      strings.ToUpper(initials),
 		Scopes:        items,
+		Display:       pageDisplay(authSession),
 	}
 	c.Response().Header().Set("Content-Type", "text/html; charset=utf-8")
 	return h.consentTmpl.Execute(c.Response().Writer, data)
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"embed"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -317,3 +318,367 @@ func getAllAuthSessions(t *testing.T, store storage.Storage) []*models.AuthSessi
 
 	return sessions
 }
+
+// TestAuthorizationDenialPreservesStateAndResponseMode verifies that consent
+// denial and login cancellation return access_denied to the RP using the
+// response mode implied by response_type, and that the auth session is removed.
+func TestAuthorizationDenialPreservesStateAndResponseMode(t *testing.T) {
+	store, err := storage.NewJSONStorage(t.TempDir() + "/test_denial.json")
+	require.NoError(t, err)
+	defer func() {
+		_ = store.Close() // Best effort close in test
+	}()
+
+	sessionCfg := session.DefaultConfig(store)
+	sessionCfg.CookieSecure = false
+	sessionCfg.CleanupInterval = 0
+	sessionMgr := session.NewManager(sessionCfg)
+
+	h := &Handlers{
+		storage:        store,
+		config:         &configstore.ConfigData{Issuer: "https://localhost:8080"},
+		sessionManager: sessionMgr,
+		loginTmpl:      parseOrFallback(embed.FS{}, "public/login.html", fallbackLoginTmpl),
+	}
+
+	user := models.NewRegularUser("denyuser", "deny@example.com", "hashed_password")
+	require.NoError(t, store.CreateUser(user))
+	userSession := &models.UserSession{
+		ID:        "deny-user-session",
+		UserID:    user.ID,
+		AuthTime:  time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	require.NoError(t, store.CreateUserSession(userSession))
+
+	newAuthSession := func(id, responseType string) *models.AuthSession {
+		as := &models.AuthSession{
+			ID:           id,
+			ClientID:     "test-client",
+			RedirectURI:  "https://client.example.com/callback",
+			ResponseType: responseType,
+			Scope:        "openid profile",
+			State:        "st-" + id,
+			ExpiresAt:    time.Now().Add(10 * time.Minute),
+		}
+		require.NoError(t, store.CreateAuthSession(as))
+		return as
+	}
+
+	client := models.NewClient("Test App", []string{"https://client.example.com/callback"})
+	client.ID = "test-client"
+	require.NoError(t, store.CreateClient(client))
+
+	post := func(path string, form url.Values, handler echo.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: session.UserSessionCookieName, Value: userSession.ID})
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		require.NoError(t, sessionMgr.Middleware()(handler)(c))
+		return rec
+	}
+
+	tests := []struct {
+		name         string
+		responseType string
+		fragment     bool
+	}{
+		{"code uses query", "code", false},
+		{"id_token uses fragment", "id_token", true},
+		{"id_token token uses fragment", "id_token token", true},
+		{"token id_token uses fragment", "token id_token", true},
+		{"hybrid uses fragment", "code id_token", true},
+	}
+
+	for i, tt := range tests {
+		t.Run("consent deny: "+tt.name, func(t *testing.T) {
+			as := newAuthSession(fmt.Sprintf("deny-%d", i), tt.responseType)
+			rec := post("/consent?auth_session="+as.ID, url.Values{"consent": {"deny"}}, h.Consent)
+
+			require.Equal(t, http.StatusFound, rec.Code)
+			loc, err := url.Parse(rec.Header().Get("Location"))
+			require.NoError(t, err)
+
+			params := loc.Query()
+			if tt.fragment {
+				assert.Empty(t, loc.RawQuery)
+				params, err = url.ParseQuery(loc.Fragment)
+				require.NoError(t, err)
+			} else {
+				assert.Empty(t, loc.Fragment)
+			}
+			assert.Equal(t, ErrorAccessDenied, params.Get("error"))
+			assert.Equal(t, as.State, params.Get("state"))
+			assert.Equal(t, h.config.Issuer, params.Get("iss"), "RFC 9207 iss on error responses")
+
+			remaining, _ := store.GetAuthSession(as.ID)
+			assert.Nil(t, remaining, "auth session should be removed after denial")
+		})
+	}
+
+	t.Run("consent allow returns code with iss", func(t *testing.T) {
+		as := newAuthSession("allow-1", "code")
+		rec := post("/consent?auth_session="+as.ID, url.Values{"consent": {"allow"}}, h.Consent)
+
+		require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+		loc, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.NotEmpty(t, loc.Query().Get("code"))
+		assert.Equal(t, h.config.Issuer, loc.Query().Get("iss"))
+	})
+
+	t.Run("login cancel returns access_denied", func(t *testing.T) {
+		as := newAuthSession("cancel-1", "id_token")
+		rec := post("/login?auth_session="+as.ID, url.Values{"action": {"cancel"}}, h.Login)
+
+		require.Equal(t, http.StatusFound, rec.Code)
+		loc, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		params, err := url.ParseQuery(loc.Fragment)
+		require.NoError(t, err)
+		assert.Equal(t, ErrorAccessDenied, params.Get("error"))
+		assert.Equal(t, as.State, params.Get("state"))
+
+		remaining, _ := store.GetAuthSession(as.ID)
+		assert.Nil(t, remaining)
+	})
+
+	t.Run("login page renders cancel button", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/login?auth_session=abc", nil)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Login(echo.New().NewContext(req, rec)))
+		assert.Contains(t, rec.Body.String(), `value="cancel"`)
+	})
+}
+
+func TestAuthorize_PostForm(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+
+	authorize := func(contentType string, form url.Values) *httptest.ResponseRecorder {
+		// Query parameters are ignored for POST; only the form body counts
+		req := httptest.NewRequest(http.MethodPost, "/authorize?scope=ignored", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, contentType)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Authorize(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	form := url.Values{
+		"client_id":     {client.ID},
+		"redirect_uri":  {client.RedirectURIs[0]},
+		"response_type": {"code"},
+		"scope":         {"openid profile"},
+		"state":         {"post-state"},
+		"claims":        {`{"userinfo":{"email":{"essential":true}}}`},
+	}
+
+	rec := authorize(echo.MIMEApplicationJSON, form)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = authorize(echo.MIMEApplicationForm, form)
+	require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+	location := rec.Header().Get("Location")
+	require.True(t, strings.HasPrefix(location, "/login?auth_session="), location)
+
+	authSession, err := store.GetAuthSession(strings.TrimPrefix(location, "/login?auth_session="))
+	require.NoError(t, err)
+	require.NotNil(t, authSession)
+	assert.Equal(t, "openid profile", authSession.Scope)
+	assert.Equal(t, "post-state", authSession.State)
+}
+
+func TestAuthorize_CapturesRequestParameters(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" // RFC 7636 Appendix B
+
+	authorize := func(params url.Values) *httptest.ResponseRecorder {
+		params.Set("client_id", client.ID)
+		params.Set("redirect_uri", client.RedirectURIs[0])
+		params.Set("response_type", "code")
+		params.Set("scope", "openid profile")
+		params.Set("state", "s1")
+		req := httptest.NewRequest(http.MethodGet, "/authorize?"+params.Encode(), nil)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Authorize(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	rec := authorize(url.Values{
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+		"nonce":                 {"n-1"},
+		"prompt":                {"login"},
+		"max_age":               {"300"},
+		"display":               {"popup"},
+		"ui_locales":            {"fr-CA en"},
+		"acr_values":            {"urn:mfa"},
+		"claims":                {`{"id_token":{"email":{"essential":true}}}`},
+	})
+	require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+	authSession, err := store.GetAuthSession(strings.TrimPrefix(rec.Header().Get("Location"), "/login?auth_session="))
+	require.NoError(t, err)
+	require.NotNil(t, authSession)
+	assert.Equal(t, challenge, authSession.CodeChallenge)
+	assert.Equal(t, "S256", authSession.CodeChallengeMethod)
+	assert.Equal(t, "n-1", authSession.Nonce)
+	assert.Equal(t, "login", authSession.Prompt)
+	assert.Equal(t, 300, authSession.MaxAge)
+	assert.Equal(t, "popup", authSession.Display)
+	assert.Equal(t, []string{"fr-CA", "en"}, authSession.UILocales)
+	assert.Equal(t, []string{"urn:mfa"}, authSession.ACRValues)
+	assert.Contains(t, authSession.Claims, "id_token")
+
+	// The login page is laid out for the requested display
+	req := httptest.NewRequest(http.MethodGet, "/login?auth_session="+authSession.ID, nil)
+	page := httptest.NewRecorder()
+	require.NoError(t, h.Login(echo.New().NewContext(req, page)))
+	assert.Contains(t, page.Body.String(), `<body class="display-popup">`)
+
+	// A challenge without a method is "plain" (RFC 7636 Section 4.3)
+	rec = authorize(url.Values{"code_challenge": {challenge}})
+	require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+	authSession, err = store.GetAuthSession(strings.TrimPrefix(rec.Header().Get("Location"), "/login?auth_session="))
+	require.NoError(t, err)
+	assert.Equal(t, "plain", authSession.CodeChallengeMethod)
+
+	for name, params := range map[string]url.Values{
+		"unknown method":  {"code_challenge": {challenge}, "code_challenge_method": {"S512"}},
+		"short challenge": {"code_challenge": {"abc"}},
+		"method only":     {"code_challenge_method": {"S256"}},
+		"bad max_age":     {"max_age": {"-1"}},
+		"bad claims":      {"claims": {"not json"}},
+		"display none":    {"display": {"none"}},
+		"unknown display": {"display": {"fullscreen"}},
+	} {
+		rec := authorize(params)
+		require.Equal(t, http.StatusFound, rec.Code, name)
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, ErrorInvalidRequest, location.Query().Get("error"), name)
+	}
+}
+
+func TestAuthorize_ClientRequiresPKCE(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	client.RequirePKCE = true
+	require.NoError(t, store.UpdateClient(client))
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	authorize := func(responseType string, params url.Values) *httptest.ResponseRecorder {
+		params.Set("client_id", client.ID)
+		params.Set("redirect_uri", client.RedirectURIs[0])
+		params.Set("response_type", responseType)
+		params.Set("scope", "openid")
+		params.Set("nonce", "n-1")
+		req := httptest.NewRequest(http.MethodGet, "/authorize?"+params.Encode(), nil)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Authorize(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	for name, tc := range map[string]struct {
+		responseType string
+		params       url.Values
+		description  string
+	}{
+		"no challenge":    {"code", url.Values{}, "code_challenge is required"},
+		"plain challenge": {"code", url.Values{"code_challenge": {challenge}}, "code_challenge_method=S256"},
+		"implicit flow":   {"id_token", url.Values{"code_challenge": {challenge}, "code_challenge_method": {"S256"}}, "authorization code flow"},
+	} {
+		rec := authorize(tc.responseType, tc.params)
+		require.Equal(t, http.StatusFound, rec.Code, name)
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		query := location.Query()
+		if location.Fragment != "" {
+			query, _ = url.ParseQuery(location.Fragment)
+		}
+		assert.Equal(t, ErrorInvalidRequest, query.Get("error"), name)
+		assert.Contains(t, query.Get("error_description"), tc.description, name)
+	}
+
+	rec := authorize("code", url.Values{"code_challenge": {challenge}, "code_challenge_method": {"S256"}})
+	require.Equal(t, http.StatusFound, rec.Code)
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Location"), "/login?auth_session="), rec.Header().Get("Location"))
+}
+
+func TestConsent_FirstPartyClientSkipsScreen(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	client.FirstParty = true
+	client.FirstPartyScopes = []string{"openid", "profile"}
+	require.NoError(t, store.UpdateClient(client))
+
+	user := models.NewRegularUser("firstparty", "fp@example.com", "hashed_password")
+	require.NoError(t, store.CreateUser(user))
+	userSession := &models.UserSession{
+		ID:        "first-party-session",
+		UserID:    user.ID,
+		AuthTime:  time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	require.NoError(t, store.CreateUserSession(userSession))
+
+	consent := func(id, scope, prompt string) *httptest.ResponseRecorder {
+		require.NoError(t, store.CreateAuthSession(&models.AuthSession{
+			ID:           id,
+			ClientID:     client.ID,
+			RedirectURI:  client.RedirectURIs[0],
+			ResponseType: ResponseTypeCode,
+			Scope:        scope,
+			Prompt:       prompt,
+			ExpiresAt:    time.Now().Add(10 * time.Minute),
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/consent?auth_session="+id, nil)
+		req.AddCookie(&http.Cookie{Name: session.UserSessionCookieName, Value: userSession.ID})
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.sessionManager.Middleware()(h.Consent)(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	// Scopes outside first_party_scopes, and prompt=consent, still show the screen
+	assert.Equal(t, http.StatusOK, consent("fp-email", "openid email", "").Code)
+	assert.Equal(t, http.StatusOK, consent("fp-prompt", "openid profile", "consent").Code)
+	stored, _ := store.GetConsent(user.ID, client.ID)
+	assert.Nil(t, stored)
+
+	rec := consent("fp-profile", "openid profile", "")
+	require.Equal(t, http.StatusFound, rec.Code)
+	location, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.NotEmpty(t, location.Query().Get("code"))
+
+	stored, err = store.GetConsent(user.ID, client.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.True(t, stored.Implicit)
+	assert.Equal(t, []string{"openid", "profile"}, stored.Scopes)
+}
+
+func TestDisabledGrantsRejectedAtRuntime(t *testing.T) {
+	h, _, _, _ := setupRevokeTest(t)
+	h.config.Grants = configstore.GrantsConfig{OAuth21: true, DisabledGrantTypes: []string{"client_credentials"}}
+
+	query := url.Values{
+		"client_id":     {"test-client"},
+		"redirect_uri":  {"https://example.com/callback"},
+		"response_type": {"id_token"},
+		"scope":         {"openid"},
+		"nonce":         {"n-0S6_WzA2Mj"},
+		"state":         {"af0ifjsldkj"},
+	}
+	rec := httptest.NewRecorder()
+	require.NoError(t, h.Authorize(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/authorize?"+query.Encode(), nil), rec)))
+	require.Equal(t, http.StatusFound, rec.Code)
+	assert.Contains(t, rec.Header().Get("Location"), "error="+ErrorUnsupportedResponseType)
+
+	for _, grantType := range []string{"password", "client_credentials"} {
+		form := url.Values{"grant_type": {grantType}, "client_id": {"test-client"}, "client_secret": {"test-secret"}, "username": {"alice"}, "password": {"secret"}}
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Token(echo.New().NewContext(req, rec)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), ErrorUnsupportedGrantType, grantType)
+	}
+}
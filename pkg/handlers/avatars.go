@@ -0,0 +1,440 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // Decoder for GIF uploads
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/blobstore"
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+const (
+	defaultAvatarMaxUploadBytes = 5 << 20
+	defaultAvatarSize           = 256
+	minAvatarSize               = 16
+	maxAvatarSize               = 1024
+	// maxAvatarSourcePixels bounds the memory a decoded upload takes, checked
+	// from the image header before decoding
+	maxAvatarSourcePixels = 4096 * 4096
+	avatarJPEGQuality     = 90
+	// multipartOverhead allows for the form boundaries and headers around the file
+	multipartOverhead = 64 << 10
+)
+
+// avatarKeyPattern matches the keys avatars are stored under: a hash of the
+// user ID and the processed image, so every upload gets a new URL that can be
+// cached indefinitely
+var avatarKeyPattern = regexp.MustCompile(`^[0-9a-f]{32}\.(jpg|png)$`)
+
+// avatarUploadError is an upload refused for its content, with the status to
+// answer
+type avatarUploadError struct {
+	status  int
+	message string
+}
+
+func (e *avatarUploadError) Error() string { return e.message }
+
+// avatarLimits returns the upload size limit and the avatar size in pixels
+func avatarLimits(cfg *configstore.ConfigData) (int64, int) {
+	avatars := cfg.AvatarsState()
+	maxBytes, size := avatars.MaxUploadBytes, avatars.Size
+	if maxBytes <= 0 {
+		maxBytes = defaultAvatarMaxUploadBytes
+	}
+	if size <= 0 {
+		size = defaultAvatarSize
+	}
+	return maxBytes, min(max(size, minAvatarSize), maxAvatarSize)
+}
+
+// avatarBaseURL is the URL uploaded avatars are served under
+func avatarBaseURL(cfg *configstore.ConfigData) string {
+	return strings.TrimSuffix(cfg.Issuer, "/") + "/avatars/"
+}
+
+// uploadedAvatarKey returns the blob key of picture when it is an avatar
+// uploaded to this server
+func uploadedAvatarKey(cfg *configstore.ConfigData, picture string) (string, bool) {
+	key, ok := strings.CutPrefix(picture, avatarBaseURL(cfg))
+	return key, ok && avatarKeyPattern.MatchString(key)
+}
+
+// readAvatarUpload reads the image from the "avatar" field of a multipart form,
+// or from the request body for any other content type
+func readAvatarUpload(c echo.Context, maxBytes int64) ([]byte, error) {
+	tooLarge := &avatarUploadError{http.StatusRequestEntityTooLarge,
+		fmt.Sprintf("Avatar must not be larger than %d bytes", maxBytes)}
+	req := c.Request()
+	if !strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		req.Body = http.MaxBytesReader(c.Response(), req.Body, maxBytes)
+		data, err := io.ReadAll(req.Body)
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return nil, tooLarge
+		}
+		if err != nil {
+			return nil, &avatarUploadError{http.StatusBadRequest, "Failed to read avatar"}
+		}
+		return data, nil
+	}
+
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, maxBytes+multipartOverhead)
+	header, err := c.FormFile("avatar")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return nil, tooLarge
+		}
+		return nil, &avatarUploadError{http.StatusBadRequest, "Missing avatar file"}
+	}
+	if header.Size > maxBytes {
+		return nil, tooLarge
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, &avatarUploadError{http.StatusBadRequest, "Failed to read avatar"}
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil {
+		return nil, &avatarUploadError{http.StatusBadRequest, "Failed to read avatar"}
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, tooLarge
+	}
+	return data, nil
+}
+
+// normalizeAvatar checks that data is a PNG, JPEG or GIF image of reasonable
+// dimensions, crops it to a centered square no larger than size pixels and
+// re-encodes it, which also drops any metadata such as EXIF location. Images
+// with transparency are kept as PNG, the rest become JPEG. It returns the
+// encoded image and its file extension.
+func normalizeAvatar(data []byte, size int) ([]byte, string, error) {
+	switch http.DetectContentType(data) {
+	case "image/png", "image/jpeg", "image/gif":
+	default:
+		return nil, "", &avatarUploadError{http.StatusUnsupportedMediaType, "Avatar must be a PNG, JPEG or GIF image"}
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", &avatarUploadError{http.StatusBadRequest, "Avatar image could not be read"}
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxAvatarSourcePixels {
+		return nil, "", &avatarUploadError{http.StatusBadRequest, "Avatar image dimensions are too large"}
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", &avatarUploadError{http.StatusBadRequest, "Avatar image could not be read"}
+	}
+
+	thumb := squareThumbnail(src, size)
+	var buf bytes.Buffer
+	if thumb.Opaque() {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: avatarJPEGQuality})
+		return buf.Bytes(), "jpg", err
+	}
+	err = png.Encode(&buf, thumb)
+	return buf.Bytes(), "png", err
+}
+
+// squareThumbnail crops src to its centered square and scales it down to size
+// pixels by averaging the source pixels under each target pixel. Smaller
+// images are not scaled up.
+func squareThumbnail(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	origin := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), src, origin, draw.Src)
+	if side <= size {
+		return square
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0, y1 := y*side/size, (y+1)*side/size
+		for x := 0; x < size; x++ {
+			x0, x1 := x*side/size, (x+1)*side/size
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := square.Pix[sy*square.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					sum[0] += int(p[0])
+					sum[1] += int(p[1])
+					sum[2] += int(p[2])
+					sum[3] += int(p[3])
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			i := dst.PixOffset(x, y)
+			for ch := 0; ch < 4; ch++ {
+				dst.Pix[i+ch] = uint8(sum[ch] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// saveAvatar stores the avatar uploaded with the request and points
+// user.Picture at it. A previously uploaded avatar is deleted once the user
+// has been saved.
+func saveAvatar(c echo.Context, avatars blobstore.Store, users storage.Storage, cfg *configstore.ConfigData, user *models.User) error {
+	maxBytes, size := avatarLimits(cfg)
+	data, err := readAvatarUpload(c, maxBytes)
+	if err != nil {
+		return err
+	}
+	normalized, ext, err := normalizeAvatar(data, size)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(append([]byte(user.ID+"\x00"), normalized...))
+	key := hex.EncodeToString(sum[:16]) + "." + ext
+	contentType := "image/jpeg"
+	if ext == "png" {
+		contentType = "image/png"
+	}
+	ctx := c.Request().Context()
+	if err := avatars.Put(ctx, key, normalized, contentType); err != nil {
+		return fmt.Errorf("failed to store avatar: %w", err)
+	}
+
+	previous := user.Picture
+	user.Picture = avatarBaseURL(cfg) + key
+	user.UpdatedAt = time.Now()
+	if err := users.UpdateUser(user); err != nil {
+		if previous != user.Picture {
+			_ = avatars.Delete(ctx, key)
+		}
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	if previous != user.Picture {
+		deleteUploadedAvatar(ctx, avatars, cfg, previous)
+	}
+	return nil
+}
+
+// removeAvatar clears user.Picture, deleting the avatar it points at when it
+// was uploaded here
+func removeAvatar(ctx context.Context, avatars blobstore.Store, users storage.Storage, cfg *configstore.ConfigData, user *models.User) error {
+	previous := user.Picture
+	user.Picture = ""
+	user.UpdatedAt = time.Now()
+	if err := users.UpdateUser(user); err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	deleteUploadedAvatar(ctx, avatars, cfg, previous)
+	return nil
+}
+
+// deleteUploadedAvatar deletes the blob behind picture, if it is an avatar
+// uploaded here. A failure only leaves an unreferenced file behind, so it is
+// logged rather than returned.
+func deleteUploadedAvatar(ctx context.Context, avatars blobstore.Store, cfg *configstore.ConfigData, picture string) {
+	key, ok := uploadedAvatarKey(cfg, picture)
+	if !ok || avatars == nil {
+		return
+	}
+	if err := avatars.Delete(ctx, key); err != nil {
+		log.Printf("Failed to delete avatar %s: %v", key, err)
+	}
+}
+
+// ─── Serving: GET /avatars/:key ──────────────────────────────────────────────
+
+// Avatar serves an uploaded avatar. Keys change with every upload, so
+// responses may be cached indefinitely.
+func (h *Handlers) Avatar(c echo.Context) error {
+	key := c.Param("key")
+	if h.avatars == nil || !avatarKeyPattern.MatchString(key) {
+		return c.NoContent(http.StatusNotFound)
+	}
+	// The key is a content hash, so it doubles as the entity tag
+	etag := `"` + key[:32] + `"`
+	if c.Request().Header.Get("If-None-Match") == etag {
+		c.Response().Header().Set("ETag", etag)
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	obj, err := h.avatars.Get(c.Request().Context(), key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return c.NoContent(http.StatusNotFound)
+	}
+	if err != nil {
+		log.Printf("Failed to read avatar %s: %v", key, err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	contentType := "image/jpeg"
+	if strings.HasSuffix(key, ".png") {
+		contentType = "image/png"
+	}
+	header := c.Response().Header()
+	header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	header.Set("ETag", etag)
+	header.Set("X-Content-Type-Options", "nosniff")
+	return c.Blob(http.StatusOK, contentType, obj.Data)
+}
+
+// ─── Self-service: PUT/DELETE /account/avatar ────────────────────────────────
+
+// UploadAvatar replaces the picture of the user an access token with the
+// profile scope was issued to. The image is sent as the request body or as
+// the "avatar" field of a multipart form.
+func (h *Handlers) UploadAvatar(c echo.Context) error {
+	user, ok := h.avatarAccountUser(c)
+	if !ok {
+		return nil
+	}
+	if err := saveAvatar(c, h.avatars, h.storage, h.config, user); err != nil {
+		return h.avatarError(c, err)
+	}
+	h.logAudit(models.AuditActionAvatarChanged, models.AuditActorUser, user.ID, "user", user.ID,
+		models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(), map[string]interface{}{"picture": user.Picture})
+	return c.JSON(http.StatusOK, map[string]string{"picture": user.Picture})
+}
+
+// DeleteAvatar removes the picture of the user an access token with the
+// profile scope was issued to
+func (h *Handlers) DeleteAvatar(c echo.Context) error {
+	user, ok := h.avatarAccountUser(c)
+	if !ok {
+		return nil
+	}
+	if err := removeAvatar(c.Request().Context(), h.avatars, h.storage, h.config, user); err != nil {
+		return h.avatarError(c, err)
+	}
+	h.logAudit(models.AuditActionAvatarChanged, models.AuditActorUser, user.ID, "user", user.ID,
+		models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(), map[string]interface{}{"removed": true})
+	return c.NoContent(http.StatusNoContent)
+}
+
+// avatarAccountUser returns the user of the bearer access token, which must
+// carry the profile scope. Otherwise it answers the request and returns false.
+func (h *Handlers) avatarAccountUser(c echo.Context) (*models.User, bool) {
+	if !h.config.AvatarsState().Enabled || h.avatars == nil {
+		_ = jsonError(c, http.StatusNotFound, ErrorInvalidRequest, "Avatar uploads are not enabled")
+		return nil, false
+	}
+	accessToken := extractBearerToken(c)
+	if accessToken == "" {
+		_ = ErrorInvalidAccessToken(c, "Missing or malformed authorization header")
+		return nil, false
+	}
+	token, err := h.storage.GetTokenByAccessToken(accessToken)
+	if err != nil || token == nil || token.IsExpired() {
+		_ = ErrorInvalidAccessToken(c, "Invalid or expired access token")
+		return nil, false
+	}
+	if !h.hasScope(token.Scope, "profile") {
+		description := "Access token does not have profile scope"
+		c.Response().Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="%s", error_description="%s"`, ErrorInsufficientScope, description))
+		_ = jsonError(c, http.StatusForbidden, ErrorInsufficientScope, description)
+		return nil, false
+	}
+	user, err := h.storage.GetUserByID(token.UserID)
+	if err != nil || user == nil {
+		_ = ErrorInvalidAccessToken(c, "Invalid or expired access token")
+		return nil, false
+	}
+	return user, true
+}
+
+func (h *Handlers) avatarError(c echo.Context, err error) error {
+	var uploadErr *avatarUploadError
+	if errors.As(err, &uploadErr) {
+		return jsonError(c, uploadErr.status, ErrorInvalidRequest, uploadErr.message)
+	}
+	log.Printf("Avatar update failed: %v", err)
+	return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to update avatar")
+}
+
+// ─── Admin: POST/DELETE /api/admin/users/:id/avatar ──────────────────────────
+
+// UploadUserAvatar replaces a user's picture with an uploaded image
+func (h *AdminHandler) UploadUserAvatar(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	user, ok := h.avatarUser(c)
+	if !ok {
+		return nil
+	}
+	if err := saveAvatar(c, h.avatars, h.store, h.config, user); err != nil {
+		return adminAvatarError(c, err)
+	}
+	h.logAdminAudit(models.AuditActionAdminUserUpdated, models.AuditActorAdmin, actor,
+		"user", user.ID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"picture": user.Picture})
+	return c.JSON(http.StatusOK, map[string]string{"picture": user.Picture})
+}
+
+// DeleteUserAvatar clears a user's picture
+func (h *AdminHandler) DeleteUserAvatar(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	user, ok := h.avatarUser(c)
+	if !ok {
+		return nil
+	}
+	if err := removeAvatar(c.Request().Context(), h.avatars, h.store, h.config, user); err != nil {
+		return adminAvatarError(c, err)
+	}
+	h.logAdminAudit(models.AuditActionAdminUserUpdated, models.AuditActorAdmin, actor,
+		"user", user.ID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"picture": ""})
+	return c.NoContent(http.StatusNoContent)
+}
+
+// avatarUser returns the user named in the path. Otherwise it answers the
+// request and returns false.
+func (h *AdminHandler) avatarUser(c echo.Context) (*models.User, bool) {
+	if !h.config.AvatarsState().Enabled || h.avatars == nil {
+		_ = c.JSON(http.StatusNotFound, map[string]string{"error": "Avatar uploads are not enabled"})
+		return nil, false
+	}
+	user, err := h.store.GetUserByID(c.Param("id"))
+	if err != nil {
+		_ = c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get user"})
+		return nil, false
+	}
+	if user == nil {
+		_ = c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+		return nil, false
+	}
+	return user, true
+}
+
+func adminAvatarError(c echo.Context, err error) error {
+	var uploadErr *avatarUploadError
+	if errors.As(err, &uploadErr) {
+		return c.JSON(uploadErr.status, map[string]string{"error": uploadErr.message})
+	}
+	log.Printf("Avatar update failed: %v", err)
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update avatar"})
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/blobstore"
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func encodeTestPNG(t *testing.T, w, h int, fill color.Color) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, fill)
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// pngHeaderOnly is a PNG signature and header claiming the given size, with
+// no image data
+func pngHeaderOnly(w, h uint32) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], w)
+	binary.BigEndian.PutUint32(ihdr[8:], h)
+	ihdr[12], ihdr[13] = 8, 6 // 8-bit RGBA
+	out := append([]byte("\x89PNG\r\n\x1a\n"), 0, 0, 0, 13)
+	out = append(out, ihdr...)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(ihdr))
+}
+
+func TestNormalizeAvatar(t *testing.T) {
+	out, ext, err := normalizeAvatar(encodeTestPNG(t, 600, 400, color.NRGBA{R: 255, A: 128}), 256)
+	require.NoError(t, err)
+	assert.Equal(t, "png", ext)
+	img, err := png.Decode(bytes.NewReader(out))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 256, 256), img.Bounds())
+	_, _, _, a := img.At(100, 100).RGBA()
+	assert.InDelta(t, 0x8080, a, 0x200)
+
+	// Opaque images become JPEG, and small ones keep their size
+	out, ext, err = normalizeAvatar(encodeTestPNG(t, 100, 50, color.White), 256)
+	require.NoError(t, err)
+	assert.Equal(t, "jpg", ext)
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(out))
+	require.NoError(t, err)
+	assert.Equal(t, 50, cfg.Width)
+	assert.Equal(t, 50, cfg.Height)
+
+	var uploadErr *avatarUploadError
+	_, _, err = normalizeAvatar([]byte("<svg xmlns='http://www.w3.org/2000/svg'/>"), 256)
+	require.ErrorAs(t, err, &uploadErr)
+	assert.Equal(t, http.StatusUnsupportedMediaType, uploadErr.status)
+
+	// Dimensions are checked before the image is decoded
+	_, _, err = normalizeAvatar(pngHeaderOnly(100000, 100000), 256)
+	require.ErrorAs(t, err, &uploadErr)
+	assert.Equal(t, "Avatar image dimensions are too large", uploadErr.message)
+}
+
+func TestAccountAvatar(t *testing.T) {
+	h, store, _, token := setupRevokeTest(t)
+	require.NoError(t, store.CreateUser(&models.User{ID: token.UserID, Username: "alice", Email: "alice@example.com"}))
+	avatars := blobstore.NewFilesystem(t.TempDir())
+	h.avatars = avatars
+
+	call := func(handler echo.HandlerFunc, method, accessToken string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/account/avatar", bytes.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, "image/png")
+		if accessToken != "" {
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+		}
+		rec := httptest.NewRecorder()
+		require.NoError(t, handler(echo.New().NewContext(req, rec)))
+		return rec
+	}
+	upload := encodeTestPNG(t, 300, 300, color.NRGBA{B: 255, A: 255})
+
+	rec := call(h.UploadAvatar, http.MethodPut, token.AccessToken, upload)
+	assert.Equal(t, http.StatusNotFound, rec.Code, "uploads are off by default")
+	h.config.Avatars.Enabled = true
+
+	rec = call(h.UploadAvatar, http.MethodPut, "", upload)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.NoError(t, store.CreateToken(&models.Token{ID: "openid-only", AccessToken: "openid-only", Scope: "openid",
+		UserID: token.UserID, ExpiresAt: time.Now().Add(time.Hour)}))
+	rec = call(h.UploadAvatar, http.MethodPut, "openid-only", upload)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = call(h.UploadAvatar, http.MethodPut, token.AccessToken, upload)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var resp map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	first := resp["picture"]
+	key, ok := uploadedAvatarKey(h.config, first)
+	require.True(t, ok, first)
+	assert.True(t, strings.HasSuffix(key, ".jpg"))
+	user, err := store.GetUserByID(token.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, first, user.Picture)
+
+	// Served under the issuer with an immutable cache lifetime
+	req := httptest.NewRequest(http.MethodGet, "/avatars/"+key, nil)
+	rec = httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("key")
+	c.SetParamValues(key)
+	require.NoError(t, h.Avatar(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/jpeg", rec.Header().Get(echo.HeaderContentType))
+	assert.Contains(t, rec.Header().Get("Cache-Control"), "immutable")
+	etag := rec.Header().Get("ETag")
+
+	req = httptest.NewRequest(http.MethodGet, "/avatars/"+key, nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	c = echo.New().NewContext(req, rec)
+	c.SetParamNames("key")
+	c.SetParamValues(key)
+	require.NoError(t, h.Avatar(c))
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+
+	// A new upload replaces the stored file
+	rec = call(h.UploadAvatar, http.MethodPut, token.AccessToken, encodeTestPNG(t, 300, 300, color.NRGBA{G: 255, A: 255}))
+	require.Equal(t, http.StatusOK, rec.Code)
+	_, err = avatars.Get(context.Background(), key)
+	assert.ErrorIs(t, err, blobstore.ErrNotFound)
+
+	user, err = store.GetUserByID(token.UserID)
+	require.NoError(t, err)
+	second, _ := uploadedAvatarKey(h.config, user.Picture)
+	rec = call(h.DeleteAvatar, http.MethodDelete, token.AccessToken, nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	user, err = store.GetUserByID(token.UserID)
+	require.NoError(t, err)
+	assert.Empty(t, user.Picture)
+	_, err = avatars.Get(context.Background(), second)
+	assert.ErrorIs(t, err, blobstore.ErrNotFound)
+
+	logs, err := store.GetAuditLogs(models.AuditFilter{Action: models.AuditActionAvatarChanged})
+	require.NoError(t, err)
+	assert.Len(t, logs, 3)
+}
+
+func TestAdminUserAvatar(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+	require.NoError(t, store.CreateUser(&models.User{ID: "u-1", Username: "bob", Email: "bob@example.com",
+		Picture: "https://cdn.example.net/bob.png"}))
+	h.config.Avatars = configstore.AvatarConfig{Enabled: true, MaxUploadBytes: 4096, Size: 64}
+	admin := NewAdminHandler(store, h.config, nil)
+	admin.SetAvatarStore(blobstore.NewFilesystem(t.TempDir()))
+	adminToken, err := crypto.GenerateAdminToken("support", admin.adminSecret)
+	require.NoError(t, err)
+
+	bearer := "Bearer " + adminToken
+	call := func(handler echo.HandlerFunc, method string, image []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		if image != nil {
+			part, err := form.CreateFormFile("avatar", "bob.png")
+			require.NoError(t, err)
+			_, _ = part.Write(image)
+		}
+		require.NoError(t, form.Close())
+		req := httptest.NewRequest(method, "/", &body)
+		req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+		req.Header.Set(echo.HeaderAuthorization, bearer)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("u-1")
+		require.NoError(t, handler(c))
+		return rec
+	}
+
+	// Only administrators can change another user's picture
+	bearer = ""
+	assert.Equal(t, http.StatusUnauthorized, call(admin.UploadUserAvatar, http.MethodPost, encodeTestPNG(t, 10, 10, color.Transparent)).Code)
+	assert.Equal(t, http.StatusUnauthorized, call(admin.DeleteUserAvatar, http.MethodDelete, nil).Code)
+	bearer = "Bearer " + adminToken
+
+	rec := call(admin.UploadUserAvatar, http.MethodPost, bytes.Repeat([]byte{0}, 5000))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	rec = call(admin.UploadUserAvatar, http.MethodPost, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = call(admin.UploadUserAvatar, http.MethodPost, encodeTestPNG(t, 10, 10, color.Transparent))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	user, err := store.GetUserByID("u-1")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(user.Picture, "https://example.com/avatars/"))
+	assert.True(t, strings.HasSuffix(user.Picture, ".png"))
+
+	rec = call(admin.DeleteUserAvatar, http.MethodDelete, nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	user, err = store.GetUserByID("u-1")
+	require.NoError(t, err)
+	assert.Empty(t, user.Picture)
+}
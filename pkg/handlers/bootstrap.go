@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"io/fs"
 	"net/http"
@@ -10,12 +11,10 @@ import (
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 
 	"github.com/prasenjit-net/openid-golang/pkg/configstore"
-	"github.com/prasenjit-net/openid-golang/pkg/crypto"
-	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/setup"
 	"github.com/prasenjit-net/openid-golang/pkg/storage"
 )
 
@@ -24,6 +23,7 @@ type BootstrapHandler struct {
 	configStore    configstore.ConfigStore
 	onInitComplete func() // Callback function when initialization is complete
 	setupHTMLFS    embed.FS
+	wizard         *setup.Wizard // Draft configuration for the stepwise setup API
 }
 
 // NewBootstrapHandler creates a new bootstrap handler
@@ -31,6 +31,7 @@ func NewBootstrapHandler(configStore configstore.ConfigStore, setupHTMLFS embed.
 	return &BootstrapHandler{
 		configStore: configStore,
 		setupHTMLFS: setupHTMLFS,
+		wizard:      setup.NewWizard(configStore),
 	}
 }
 
@@ -40,6 +41,7 @@ func NewBootstrapHandlerWithCallback(configStore configstore.ConfigStore, setupH
 		configStore:    configStore,
 		onInitComplete: onInitComplete,
 		setupHTMLFS:    setupHTMLFS,
+		wizard:         setup.NewWizard(configStore),
 	}
 }
 
@@ -88,8 +90,8 @@ func validateSetupRequest(req *SetupRequest) error {
 		return fmt.Errorf("admin username is required when password is provided")
 	}
 
-	if req.AdminPassword != "" && len(req.AdminPassword) < 6 {
-		return fmt.Errorf("admin password must be at least 6 characters long")
+	if req.AdminPassword != "" && len(req.AdminPassword) < setup.MinPasswordLength {
+		return fmt.Errorf("admin password must be at least %d characters long", setup.MinPasswordLength)
 	}
 
 	return nil
@@ -166,6 +168,233 @@ func (h *BootstrapHandler) Initialize(c echo.Context) error {
 	})
 }
 
+// Stepwise setup API. Each step updates a draft configuration and can be repeated
+// safely; nothing is written to the config store until CompleteSetup. The steps
+// are refused once the server is initialized.
+
+// SetupIssuerRequest sets the issuer URL
+type SetupIssuerRequest struct {
+	Issuer string `json:"issuer"`
+}
+
+// SetupKeysRequest generates the JWT signing keys
+type SetupKeysRequest struct {
+	Rotate bool `json:"rotate,omitempty"` // Replace keys generated by an earlier call
+}
+
+// SetupAdminRequest creates the first administrator
+type SetupAdminRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// requireSetupMode answers with 409 Conflict once setup has been completed
+func (h *BootstrapHandler) requireSetupMode(c echo.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), 5*time.Second)
+	defer cancel()
+
+	initialized, err := h.configStore.IsInitialized(ctx)
+	if err != nil {
+		return false, c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if initialized {
+		return false, c.JSON(http.StatusConflict, map[string]string{
+			"error": "Already initialized",
+		})
+	}
+	return true, nil
+}
+
+// GetSetupSteps reports which setup steps have been completed
+func (h *BootstrapHandler) GetSetupSteps(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), 5*time.Second)
+	defer cancel()
+
+	status, err := h.wizard.Status(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+// SetSetupIssuer sets the issuer URL of the draft configuration
+func (h *BootstrapHandler) SetSetupIssuer(c echo.Context) error {
+	if ok, err := h.requireSetupMode(c); !ok {
+		return err
+	}
+
+	var req SetupIssuerRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+	if err := h.wizard.SetIssuer(req.Issuer); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"issuer": req.Issuer,
+	})
+}
+
+// GenerateSetupKeys generates the JWT signing keys once, or again with rotate
+func (h *BootstrapHandler) GenerateSetupKeys(c echo.Context) error {
+	if ok, err := h.requireSetupMode(c); !ok {
+		return err
+	}
+
+	var req SetupKeysRequest
+	if c.Request().ContentLength > 0 {
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+	}
+	generated, err := h.wizard.GenerateKeys(req.Rotate)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	status := http.StatusOK
+	if generated {
+		status = http.StatusCreated
+	}
+	return c.JSON(status, map[string]string{
+		"public_key": h.wizard.PublicKey(),
+	})
+}
+
+// ConfigureSetupStorage selects the storage backend after checking that it can be opened
+func (h *BootstrapHandler) ConfigureSetupStorage(c echo.Context) error {
+	if ok, err := h.requireSetupMode(c); !ok {
+		return err
+	}
+
+	var req configstore.StorageBackendConfig
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+	if err := h.wizard.ConfigureStorage(req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), 5*time.Second)
+	defer cancel()
+	status, err := h.wizard.Status(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"type": status.Storage,
+	})
+}
+
+// CreateSetupAdmin creates the first administrator, or resets its password when repeated
+func (h *BootstrapHandler) CreateSetupAdmin(c echo.Context) error {
+	if ok, err := h.requireSetupMode(c); !ok {
+		return err
+	}
+
+	var req SetupAdminRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+	}
+	created, err := h.wizard.CreateAdmin(req.Username, req.Password)
+	if errors.Is(err, setup.ErrUserExists) {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	return c.JSON(status, map[string]string{
+		"username": req.Username,
+	})
+}
+
+// CreateSetupDemoData adds a demo client and user for trying out sign-in, and
+// optionally synthetic users, clients, consents and tokens
+func (h *BootstrapHandler) CreateSetupDemoData(c echo.Context) error {
+	if ok, err := h.requireSetupMode(c); !ok {
+		return err
+	}
+
+	var req setup.SeedOptions
+	if c.Request().ContentLength > 0 {
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+		}
+	}
+	if err := req.Validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	demo, err := h.wizard.CreateDemoData(req)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, demo)
+}
+
+// CompleteSetup saves the draft configuration and switches the server to normal mode
+func (h *BootstrapHandler) CompleteSetup(c echo.Context) error {
+	if ok, err := h.requireSetupMode(c); !ok {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), 30*time.Second)
+	defer cancel()
+
+	if err := h.wizard.Complete(ctx); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	if h.onInitComplete != nil {
+		go func() {
+			time.Sleep(500 * time.Millisecond) // Small delay to ensure response is sent
+			h.onInitComplete()
+		}()
+	}
+
+	return c.JSON(http.StatusOK, SetupResponse{
+		Success: true,
+		Message: "Setup completed successfully. Server will reload automatically...",
+	})
+}
+
 // ServeSetupWizard serves a simple HTML setup wizard
 func (h *BootstrapHandler) ServeSetupWizard(c echo.Context) error {
 	storageInfo := getStorageIndicator()
@@ -204,28 +433,6 @@ func (h *BootstrapHandler) createAdminUser(ctx context.Context, req SetupRequest
 		_ = store.Close() // Best effort close
 	}()
 
-	// Hash the password
-	hashedPassword, err := crypto.HashPassword(req.AdminPassword)
-	if err != nil {
-		return fmt.Errorf("failed to hash password: %w", err)
-	}
-
-	// Create admin user
-	adminUser := &models.User{
-		ID:           uuid.New().String(),
-		Username:     req.AdminUsername,
-		PasswordHash: hashedPassword,
-		Email:        req.AdminUsername + "@local",
-		Role:         "admin",
-		Name:         "Administrator",
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
-	}
-
-	// Save user to storage
-	if err := store.CreateUser(adminUser); err != nil {
-		return fmt.Errorf("failed to create admin user: %w", err)
-	}
-
-	return nil
+	_, err = setup.CreateAdminUser(store, req.AdminUsername, req.AdminPassword)
+	return err
 }
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/setup"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+func TestStepwiseSetup(t *testing.T) {
+	dir := t.TempDir()
+	configStore := configstore.NewJSONConfigStore(filepath.Join(dir, "config.json"))
+	completed := make(chan struct{}, 1)
+	h := NewBootstrapHandlerWithCallback(configStore, embed.FS{}, func() { completed <- struct{}{} })
+	e := echo.New()
+
+	call := func(handler echo.HandlerFunc, method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/setup", strings.NewReader(body))
+		if body != "" {
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		}
+		rec := httptest.NewRecorder()
+		require.NoError(t, handler(e.NewContext(req, rec)))
+		return rec
+	}
+
+	rec := call(h.SetSetupIssuer, http.MethodPut, `{"issuer":"example.com"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = call(h.SetSetupIssuer, http.MethodPut, `{"issuer":"https://id.example.com"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// Keys are generated once; repeating the step keeps them
+	rec = call(h.GenerateSetupKeys, http.MethodPost, "")
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	publicKey := rec.Body.String()
+	rec = call(h.GenerateSetupKeys, http.MethodPost, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, publicKey, rec.Body.String())
+
+	dataFile := filepath.Join(dir, "openid.json")
+	rec = call(h.ConfigureSetupStorage, http.MethodPut, `{"type":"json","json_file_path":"`+dataFile+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = call(h.ConfigureSetupStorage, http.MethodPut, `{"type":"mongodb"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Repeating the admin step resets the password instead of adding a user
+	rec = call(h.CreateSetupAdmin, http.MethodPut, `{"username":"admin","password":"short"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = call(h.CreateSetupAdmin, http.MethodPut, `{"username":"admin","password":"secret123"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	rec = call(h.CreateSetupAdmin, http.MethodPut, `{"username":"admin","password":"secret456"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var first, second setup.DemoData
+	rec = call(h.CreateSetupDemoData, http.MethodPost, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &first))
+	rec = call(h.CreateSetupDemoData, http.MethodPost, "")
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &second))
+	assert.Equal(t, first, second)
+	assert.Equal(t, setup.DemoUsername, first.Username)
+
+	// The demo user cannot be promoted by the admin step
+	rec = call(h.CreateSetupAdmin, http.MethodPut, `{"username":"`+setup.DemoUsername+`","password":"secret123"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	var status setup.Status
+	rec = call(h.GetSetupSteps, http.MethodGet, "")
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.Equal(t, setup.Status{Issuer: "https://id.example.com", Keys: true, Storage: "json", Admin: true, DemoData: true}, status)
+
+	rec = call(h.CompleteSetup, http.MethodPost, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	<-completed
+
+	config, err := configStore.GetConfig(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "https://id.example.com", config.Issuer)
+	assert.Equal(t, dataFile, config.Storage.JSONFilePath)
+
+	store, err := storage.NewStorage(config)
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+	admin, err := store.GetUserByUsername("admin")
+	require.NoError(t, err)
+	require.NotNil(t, admin)
+	assert.Equal(t, models.RoleAdmin, admin.Role)
+
+	// Steps are refused once the server is set up
+	rec = call(h.CreateSetupAdmin, http.MethodPut, `{"username":"other","password":"secret123"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+}
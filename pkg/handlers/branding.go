@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+const (
+	defaultBrandName       = "default"
+	defaultProductName     = "SecureID"
+	defaultPrimaryColor    = "#0D9488"
+	defaultBackgroundColor = "#0B1120"
+	defaultPageLocale      = "en"
+)
+
+// hexColorPattern matches the CSS colors a brand may use, e.g. "#0D9488" or "#fff"
+var hexColorPattern = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+
+// pageBrand is the branding rendered in the login and consent templates
+type pageBrand struct {
+	ProductName     string
+	LogoURL         string
+	PrimaryColor    template.CSS // validated hex color
+	BackgroundColor template.CSS // validated hex color
+	SupportURL      string
+}
+
+// brandFor returns the brand configured for the request's hostname, then the brand
+// named "default", then the built-in look
+func (h *Handlers) brandFor(c echo.Context) configstore.BrandConfig {
+	host := c.Request().Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	brands := h.config.BrandsState()
+	for _, brand := range brands {
+		for _, pattern := range brand.Hosts {
+			if hostMatches(pattern, host) {
+				return brand
+			}
+		}
+	}
+	for _, brand := range brands {
+		if brand.Name == defaultBrandName {
+			return brand
+		}
+	}
+	return configstore.BrandConfig{Name: defaultBrandName}
+}
+
+// hostMatches reports whether host matches a brand hostname, which may start with
+// "*." to match any subdomain
+func hostMatches(pattern, host string) bool {
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(strings.ToLower(host), "."+strings.ToLower(suffix))
+	}
+	return strings.EqualFold(pattern, host)
+}
+
+// pageBrandAndLocale resolves the brand and page language for a login or consent page
+func (h *Handlers) pageBrandAndLocale(c echo.Context, authSession *models.AuthSession) (pageBrand, string) {
+	brand := h.brandFor(c)
+	locales := preferredLocales(c, authSession)
+
+	locale := brand.DefaultLocale
+	if len(brand.Locales) > 0 {
+		offered := make(map[string]string, len(brand.Locales))
+		for _, tag := range brand.Locales {
+			offered[tag] = tag
+		}
+		if tag, ok := matchLocale(offered, locales); ok {
+			locale = tag
+		}
+	}
+	if locale == "" {
+		locale = defaultPageLocale
+	}
+
+	productName := brand.ProductName
+	if productName == "" {
+		productName = defaultProductName
+	}
+	page := pageBrand{
+		ProductName:     localizedValue(brand.ProductNameLocalized, productName, append([]string{locale}, locales...)),
+		LogoURL:         brand.LogoURL,
+		PrimaryColor:    brandColor(brand.PrimaryColor, defaultPrimaryColor),
+		BackgroundColor: brandColor(brand.BackgroundColor, defaultBackgroundColor),
+		SupportURL:      brand.SupportURL,
+	}
+	return page, locale
+}
+
+// pageDisplay is the layout a login or consent page is rendered in, from the
+// display parameter of its authorization request
+func pageDisplay(authSession *models.AuthSession) string {
+	if authSession == nil || !contains(displayValues, authSession.Display) {
+		return "page"
+	}
+	return authSession.Display
+}
+
+// brandColor returns color for use in a stylesheet, or fallback when it is not a hex color
+func brandColor(color, fallback string) template.CSS {
+	if !hexColorPattern.MatchString(color) {
+		color = fallback
+	}
+	return template.CSS(color) //nolint:gosec // validated against hexColorPattern
+}
+
+// validateBrands checks brands submitted through the admin settings API
+func validateBrands(brands []configstore.BrandConfig) error {
+	names := make(map[string]bool)
+	hosts := make(map[string]string)
+	for _, brand := range brands {
+		if brand.Name == "" {
+			return fmt.Errorf("brand name is required")
+		}
+		if names[brand.Name] {
+			return fmt.Errorf("duplicate brand %q", brand.Name)
+		}
+		names[brand.Name] = true
+		for _, host := range brand.Hosts {
+			key := strings.ToLower(host)
+			if host == "" || strings.ContainsAny(host, "/: ") {
+				return fmt.Errorf("brand %q: invalid host %q", brand.Name, host)
+			}
+			if other, ok := hosts[key]; ok {
+				return fmt.Errorf("host %q is used by brands %q and %q", host, other, brand.Name)
+			}
+			hosts[key] = brand.Name
+		}
+		for field, color := range map[string]string{"primary_color": brand.PrimaryColor, "background_color": brand.BackgroundColor} {
+			if color != "" && !hexColorPattern.MatchString(color) {
+				return fmt.Errorf("brand %q: %s must be a hex color", brand.Name, field)
+			}
+		}
+		for field, link := range map[string]string{"logo_url": brand.LogoURL, "support_url": brand.SupportURL} {
+			if link == "" {
+				continue
+			}
+			if u, err := url.Parse(link); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return fmt.Errorf("brand %q: %s must be an http(s) URL", brand.Name, field)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+)
+
+func TestLoginPageBrandByHost(t *testing.T) {
+	h, _, _, _ := setupRevokeTest(t)
+	h.config.Brands = []configstore.BrandConfig{
+		{Name: "acme", Hosts: []string{"login.acme.test"}, ProductName: "Acme ID",
+			ProductNameLocalized: map[string]string{"de": "Acme Anmeldung"}, Locales: []string{"en", "de"}},
+		{Name: "globex", Hosts: []string{"*.globex.test"}, ProductName: "Globex Account"},
+		{Name: defaultBrandName, ProductName: "Umbrella"},
+	}
+
+	render := func(host, acceptLanguage string) string {
+		req := httptest.NewRequest(http.MethodGet, "/login", nil)
+		req.Host = host
+		if acceptLanguage != "" {
+			req.Header.Set("Accept-Language", acceptLanguage)
+		}
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Login(echo.New().NewContext(req, rec)))
+		return rec.Body.String()
+	}
+
+	assert.Contains(t, render("login.acme.test", ""), "<title>Acme ID</title>")
+	body := render("LOGIN.acme.test:8443", "de-DE,de;q=0.9")
+	assert.Contains(t, body, `lang="de"`)
+	assert.Contains(t, body, "<title>Acme Anmeldung</title>")
+	assert.Contains(t, render("eu.globex.test", ""), "<title>Globex Account</title>")
+	assert.Contains(t, render("other.test", ""), "<title>Umbrella</title>")
+
+	h.config.Brands = nil
+	assert.Contains(t, render("other.test", ""), "<title>"+defaultProductName+"</title>")
+}
+
+func TestBrandColorFallback(t *testing.T) {
+	assert.Equal(t, "#abc", string(brandColor("#abc", defaultPrimaryColor)))
+	assert.Equal(t, defaultPrimaryColor, string(brandColor("red;}</style><script>", defaultPrimaryColor)))
+}
+
+func TestUpdateSettingsBrands(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+	admin := NewAdminHandler(store, h.config, nil)
+
+	update := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/admin/settings", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("If-Match", "*")
+		rec := httptest.NewRecorder()
+		require.NoError(t, admin.UpdateSettings(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	rec := update(`{"brands":[{"name":"acme","hosts":["a.test"],"primary_color":"tomato"}]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = update(`{"brands":[{"name":"a","hosts":["x.test"]},{"name":"b","hosts":["X.test"]}]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = update(`{"brands":[{"name":"acme","hosts":["a.test"],"logo_url":"javascript:alert(1)"}]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Empty(t, h.config.Brands)
+
+	rec = update(`{"brands":[{"name":"acme","hosts":["a.test"],"primary_color":"#112233","support_url":"https://acme.test/help"}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, h.config.Brands, 1)
+	assert.Equal(t, "#112233", h.config.Brands[0].PrimaryColor)
+}
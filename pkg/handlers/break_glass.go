@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// DefaultBreakGlassTTL is the access window of a break-glass code when none is given
+const DefaultBreakGlassTTL = 15 * time.Minute
+
+const (
+	// breakGlassJTINamespace scopes break-glass code IDs in the replay cache
+	breakGlassJTINamespace         = "admin-break-glass"
+	defaultBreakGlassMaxTTLMinutes = 60
+)
+
+// IssueBreakGlassCode creates a one-time code for emergency admin access lasting
+// ttl, for when no administrator can sign in. It runs in `openid-server
+// break-glass`, on a host with access to the server configuration, so the
+// server needs no state to accept the code; its single use is enforced when it
+// is redeemed.
+func IssueBreakGlassCode(cfg *configstore.ConfigData, reason, issuedBy string, ttl time.Duration) (string, time.Time, error) {
+	settings := cfg.BreakGlassState()
+	if !settings.Enabled {
+		return "", time.Time{}, errors.New("emergency access is disabled (break_glass.enabled)")
+	}
+	if strings.TrimSpace(reason) == "" {
+		return "", time.Time{}, errors.New("a reason is required")
+	}
+	maxTTL := time.Duration(settings.MaxTTLMinutes) * time.Minute
+	if maxTTL <= 0 {
+		maxTTL = defaultBreakGlassMaxTTLMinutes * time.Minute
+	}
+	if ttl <= 0 || ttl > maxTTL {
+		return "", time.Time{}, fmt.Errorf("the access window must be between 1s and %s", maxTTL)
+	}
+	code, claims, err := crypto.GenerateBreakGlassCode(strings.TrimSpace(reason), issuedBy, ttl, adminTokenSecret(cfg))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return code, claims.ExpiresAt.Time, nil
+}
+
+// RedeemBreakGlass exchanges a break-glass code for an admin token that expires
+// with the code. Each code works once (POST /api/admin/break-glass).
+func (h *AdminHandler) RedeemBreakGlass(c echo.Context) error {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.Bind(&req); err != nil || req.Code == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "code is required"})
+	}
+	fail := func(code int, message, grantID, reason string) error {
+		h.logAdminAudit(models.AuditActionAdminBreakGlassRedeemed, models.AuditActorAdmin, crypto.BreakGlassSubject,
+			"break_glass", grantID, models.AuditStatusFailure, c.RealIP(), c.Request().UserAgent(),
+			map[string]interface{}{"reason": reason})
+		return c.JSON(code, map[string]string{"error": message})
+	}
+
+	if !h.config.BreakGlassState().Enabled {
+		return fail(http.StatusForbidden, "Emergency access is disabled", "", "disabled by policy")
+	}
+	claims, err := crypto.ValidateBreakGlassCode(strings.TrimSpace(req.Code), h.adminSecret)
+	if err != nil {
+		return fail(http.StatusUnauthorized, "Invalid or expired break-glass code", "", "invalid code")
+	}
+	// A code is redeemed once, so a leaked code cannot be used a second time
+	fresh, err := h.store.RecordJTI(breakGlassJTINamespace, claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to verify break-glass code"})
+	}
+	if !fresh {
+		return fail(http.StatusUnauthorized, "Break-glass code has already been used", claims.ID, "code reused")
+	}
+
+	token, err := crypto.GenerateBreakGlassAdminToken(claims.ID, claims.ExpiresAt.Time, h.adminSecret)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate token"})
+	}
+	h.logAdminAudit(models.AuditActionAdminBreakGlassRedeemed, models.AuditActorAdmin, crypto.BreakGlassSubject,
+		"break_glass", claims.ID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{
+			"reason":     claims.Reason,
+			"issued_by":  claims.IssuedBy,
+			"issued_at":  claims.IssuedAt.Time,
+			"expires_at": claims.ExpiresAt.Time,
+		})
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"token":      token,
+		"expires_at": claims.ExpiresAt.Time,
+	})
+}
+
+// breakGlassGrant returns the grant a break-glass admin token in the request was
+// issued for, or "" for any other request
+func (h *AdminHandler) breakGlassGrant(c echo.Context) string {
+	parts := strings.SplitN(c.Request().Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || parts[0] != bearerPrefix {
+		return ""
+	}
+	claims, err := crypto.ValidateAdminToken(parts[1], h.adminSecret)
+	if err != nil {
+		return ""
+	}
+	grant, _ := claims["break_glass"].(string)
+	return grant
+}
+
+// auditBreakGlass records every admin API request made with a break-glass token,
+// and refuses them once emergency access has been disabled. It answers the
+// request and returns false in that case.
+func (h *AdminHandler) auditBreakGlass(c echo.Context) bool {
+	grant := h.breakGlassGrant(c)
+	if grant == "" {
+		return true
+	}
+	status := models.AuditStatusSuccess
+	if !h.config.BreakGlassState().Enabled {
+		status = models.AuditStatusFailure
+	}
+	h.logAdminAudit(models.AuditActionAdminBreakGlassRequest, models.AuditActorAdmin, crypto.BreakGlassSubject,
+		"break_glass", grant, status, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"route": c.Request().Method + " " + c.Path(), "path": c.Request().URL.Path})
+	if status == models.AuditStatusFailure {
+		_ = c.JSON(http.StatusUnauthorized, map[string]string{"error": "Emergency access is disabled"})
+		return false
+	}
+	return true
+}
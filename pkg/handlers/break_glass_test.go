@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestIssueBreakGlassCode(t *testing.T) {
+	h, _, _, _ := setupRevokeTest(t)
+	h.config.BreakGlass.Enabled = true
+
+	_, _, err := IssueBreakGlassCode(h.config, " ", "ops@host", time.Minute)
+	assert.Error(t, err, "a reason is required")
+	_, _, err = IssueBreakGlassCode(h.config, "lost MFA", "ops@host", 2*time.Hour)
+	assert.Error(t, err, "the access window is capped")
+	h.config.BreakGlass.MaxTTLMinutes = 180
+	_, expiresAt, err := IssueBreakGlassCode(h.config, "lost MFA", "ops@host", 2*time.Hour)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), expiresAt, 5*time.Second)
+
+	h.config.BreakGlass.Enabled = false
+	_, _, err = IssueBreakGlassCode(h.config, "lost MFA", "ops@host", time.Minute)
+	assert.Error(t, err)
+}
+
+func TestRedeemBreakGlass(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+	h.config.BreakGlass.Enabled = true
+	admin := NewAdminHandler(store, h.config, nil)
+
+	e := echo.New()
+	e.POST("/api/admin/break-glass", admin.RedeemBreakGlass)
+	api := e.Group("/api/admin", admin.PolicyGuard())
+	api.GET("/users", func(c echo.Context) error { return c.NoContent(http.StatusNoContent) })
+	redeem := func(code string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/break-glass", strings.NewReader(`{"code": "`+code+`"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		var resp map[string]interface{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+	listUsers := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/users", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	code, expiresAt, err := IssueBreakGlassCode(h.config, "lost MFA", "ops@host", 10*time.Minute)
+	require.NoError(t, err)
+
+	// A code is not an admin token by itself
+	_, err = crypto.ValidateAdminToken(code, admin.adminSecret)
+	assert.Error(t, err)
+
+	rec, _ := redeem("not-a-code")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec, resp := redeem(code)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	token := resp["token"].(string)
+	claims, err := crypto.ValidateAdminToken(token, admin.adminSecret)
+	require.NoError(t, err)
+	assert.Equal(t, crypto.BreakGlassSubject, claims["sub"])
+	assert.InDelta(t, float64(expiresAt.Unix()), claims["exp"], 1)
+
+	rec, _ = redeem(code)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "codes work once")
+
+	// Every request made with the token is audited, and disabling emergency access ends it
+	assert.Equal(t, http.StatusNoContent, listUsers(token))
+	h.config.BreakGlass.Enabled = false
+	assert.Equal(t, http.StatusUnauthorized, listUsers(token))
+	rec, _ = redeem(code)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Other admin tokens are not audited as emergency access
+	regular, err := crypto.GenerateAdminToken("admin", admin.adminSecret)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, listUsers(regular))
+
+	redeemed, err := store.GetAuditLogs(models.AuditFilter{Action: models.AuditActionAdminBreakGlassRedeemed})
+	require.NoError(t, err)
+	require.Len(t, redeemed, 4)
+	statuses := map[models.AuditStatus]int{}
+	for _, entry := range redeemed {
+		statuses[entry.Status]++
+	}
+	assert.Equal(t, 1, statuses[models.AuditStatusSuccess])
+	requests, err := store.GetAuditLogs(models.AuditFilter{Action: models.AuditActionAdminBreakGlassRequest})
+	require.NoError(t, err)
+	require.Len(t, requests, 2)
+	for _, entry := range requests {
+		assert.Equal(t, "GET /api/admin/users", entry.Details["route"])
+	}
+}
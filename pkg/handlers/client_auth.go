@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+const (
+	// ClientAssertionTypeJWTBearer is the client_assertion_type for JWT client authentication (RFC 7523)
+	ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	authMethodClientSecretJWT = "client_secret_jwt"
+	authMethodPrivateKeyJWT   = "private_key_jwt"
+
+	// maxClientAssertionLifetime bounds how long a used jti has to be remembered
+	maxClientAssertionLifetime = 1 * time.Hour
+	clientJWKSFetchTimeout     = 10 * time.Second
+)
+
+// clientSecretAuthMethods are the ways a client can present its secret; they are
+// all the revocation and introspection endpoints accept
+var clientSecretAuthMethods = []string{"client_secret_basic", "client_secret_post"}
+
+// tokenEndpointAuthMethods are the client authentication methods the token
+// endpoint accepts: a shared secret, a signed assertion, or none for public clients
+var tokenEndpointAuthMethods = []string{
+	"client_secret_basic",
+	"client_secret_post",
+	authMethodClientSecretJWT,
+	authMethodPrivateKeyJWT,
+	tokenEndpointAuthMethodNone,
+}
+
+// clientAssertionSigningAlgs are the algorithms client assertions may be signed
+// with: HMAC for client_secret_jwt, and RSA or ECDSA for private_key_jwt
+var clientAssertionSigningAlgs = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"HS256", "HS384", "HS512",
+}
+
+// clientAssertionReplays counts client assertions rejected because their jti was already used
+var clientAssertionReplays atomic.Int64
+
+// ClientAssertionReplaysRejected returns how many replayed client assertions were rejected since startup
+func ClientAssertionReplaysRejected() int64 {
+	return clientAssertionReplays.Load()
+}
+
+// authenticateClientAssertion authenticates a client using a signed JWT
+// (client_secret_jwt or private_key_jwt, OpenID Connect Core §9). Each assertion
+// may be used only once: its jti is recorded in storage until the assertion expires.
+func (h *Handlers) authenticateClientAssertion(assertionType, assertion, clientID string) (*models.Client, error) {
+	if assertionType != ClientAssertionTypeJWTBearer {
+		return nil, fmt.Errorf("unsupported client_assertion_type")
+	}
+
+	var client *models.Client
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(assertion, claims, func(token *jwt.Token) (interface{}, error) {
+		sub, _ := claims["sub"].(string)
+		if sub == "" || (clientID != "" && sub != clientID) {
+			return nil, fmt.Errorf("assertion subject does not match client")
+		}
+
+		c, err := h.storage.GetClientByID(sub)
+		if err != nil || c == nil {
+			return nil, fmt.Errorf("unknown client")
+		}
+		client = c
+
+		if c.TokenEndpointAuthSigningAlg != "" && token.Method.Alg() != c.TokenEndpointAuthSigningAlg {
+			return nil, fmt.Errorf("assertion must be signed with %s", c.TokenEndpointAuthSigningAlg)
+		}
+
+		switch c.TokenEndpointAuthMethod {
+		case authMethodClientSecretJWT:
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || c.Secret == "" {
+				return nil, fmt.Errorf("client_secret_jwt requires an HMAC signature")
+			}
+			return []byte(c.Secret), nil
+		case authMethodPrivateKeyJWT:
+			switch token.Method.(type) {
+			case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+			default:
+				return nil, fmt.Errorf("private_key_jwt requires an asymmetric signature")
+			}
+			kid, _ := token.Header["kid"].(string)
+			return h.clientPublicKey(c, kid)
+		default:
+			return nil, fmt.Errorf("client is not registered for JWT authentication")
+		}
+	}, jwt.WithValidMethods(clientAssertionSigningAlgs), jwt.WithExpirationRequired(), jwt.WithIssuedAt(),
+		jwt.WithLeeway(h.clockSkew()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid client assertion: %w", err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != client.ID {
+		return nil, fmt.Errorf("assertion issuer must be the client_id")
+	}
+	if !h.validAssertionAudience(claims) {
+		return nil, fmt.Errorf("assertion audience must be the token endpoint")
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil, fmt.Errorf("assertion jti is required")
+	}
+	exp, _ := claims.GetExpirationTime()
+	if exp.After(time.Now().Add(maxClientAssertionLifetime)) {
+		return nil, fmt.Errorf("assertion lifetime is too long")
+	}
+
+	fresh, err := h.storage.RecordJTI(client.ID, jti, exp.Time)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record assertion jti: %w", err)
+	}
+	if !fresh {
+		clientAssertionReplays.Add(1)
+		return nil, fmt.Errorf("client assertion has already been used")
+	}
+
+	return client, nil
+}
+
+// validAssertionAudience accepts the issuer or the token endpoint URL as audience
+func (h *Handlers) validAssertionAudience(claims jwt.MapClaims) bool {
+	audiences, err := claims.GetAudience()
+	if err != nil {
+		return false
+	}
+	for _, aud := range audiences {
+		if aud == h.config.Issuer || aud == h.config.Issuer+"/token" {
+			return true
+		}
+	}
+	return false
+}
+
+// clientPublicKey finds the verification key for a private_key_jwt client,
+// from its registered JWKS or its jwks_uri. A kid missing from the cached
+// jwks_uri set triggers a refetch, in case the client rotated its keys.
+func (h *Handlers) clientPublicKey(client *models.Client, kid string) (interface{}, error) {
+	if len(client.JWKS) > 0 || client.JWKSURI == "" {
+		return findClientSigningKey(client.JWKS, kid)
+	}
+
+	jwks, err := h.clientJWKS(client.JWKSURI, false)
+	if err != nil {
+		return nil, err
+	}
+	key, err := findClientSigningKey(jwks, kid)
+	if err != nil && kid != "" {
+		if jwks, fetchErr := h.clientJWKS(client.JWKSURI, true); fetchErr == nil {
+			key, err = findClientSigningKey(jwks, kid)
+		}
+	}
+	return key, err
+}
+
+// findClientSigningKey returns the signing key with the given kid from a JWK
+// Set, or its first signing key when kid is empty
+func findClientSigningKey(jwks map[string]interface{}, kid string) (interface{}, error) {
+	keys, _ := jwks["keys"].([]interface{})
+	for _, k := range keys {
+		jwk, ok := k.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if keyID, _ := jwk["kid"].(string); kid != "" && keyID != kid {
+			continue
+		}
+		if use, _ := jwk["use"].(string); use != "" && use != "sig" {
+			continue
+		}
+		return crypto.ParseJWKPublicKey(jwk)
+	}
+	return nil, fmt.Errorf("no matching client key")
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientSecretJWTReplayProtection(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+
+	client := &models.Client{
+		ID:                      "jwt-client",
+		Secret:                  "a-sufficiently-long-shared-secret-value",
+		GrantTypes:              []string{"client_credentials"},
+		Scope:                   "api",
+		TokenEndpointAuthMethod: authMethodClientSecretJWT,
+	}
+	require.NoError(t, store.CreateClient(client))
+
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": client.ID,
+		"sub": client.ID,
+		"aud": h.config.Issuer + "/token",
+		"jti": "assertion-1",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(5 * time.Minute).Unix(),
+	}).SignedString([]byte(client.Secret))
+	require.NoError(t, err)
+
+	requestToken := func() *httptest.ResponseRecorder {
+		form := url.Values{
+			"grant_type":            {GrantTypeClientCredentials},
+			"scope":                 {"api"},
+			"client_assertion_type": {ClientAssertionTypeJWTBearer},
+			"client_assertion":      {assertion},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Token(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	rec := requestToken()
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	before := ClientAssertionReplaysRejected()
+	rec = requestToken()
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "already been used")
+	assert.Equal(t, before+1, ClientAssertionReplaysRejected())
+}
+
+func TestClientAssertionRejectsWrongAudience(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+
+	client := &models.Client{
+		ID:                      "jwt-client",
+		Secret:                  "a-sufficiently-long-shared-secret-value",
+		TokenEndpointAuthMethod: authMethodClientSecretJWT,
+	}
+	require.NoError(t, store.CreateClient(client))
+
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": client.ID,
+		"sub": client.ID,
+		"aud": "https://other.example.com",
+		"jti": "assertion-2",
+		"exp": time.Now().Add(5 * time.Minute).Unix(),
+	}).SignedString([]byte(client.Secret))
+	require.NoError(t, err)
+
+	_, err = h.authenticateClientAssertion(ClientAssertionTypeJWTBearer, assertion, "")
+	assert.Error(t, err)
+}
+
+func TestClientAssertionClockSkew(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+	h.config.JWT.ClockSkewSeconds = 60
+
+	client := &models.Client{
+		ID:                      "jwt-client",
+		Secret:                  "a-sufficiently-long-shared-secret-value",
+		TokenEndpointAuthMethod: authMethodClientSecretJWT,
+	}
+	require.NoError(t, store.CreateClient(client))
+
+	assertion := func(jti string, iat time.Time) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"iss": client.ID,
+			"sub": client.ID,
+			"aud": h.config.Issuer + "/token",
+			"jti": jti,
+			"iat": iat.Unix(),
+			"exp": iat.Add(5 * time.Minute).Unix(),
+		}).SignedString([]byte(client.Secret))
+		require.NoError(t, err)
+		return signed
+	}
+
+	// A client clock running 30s ahead is tolerated, one running 2m ahead is not
+	_, err := h.authenticateClientAssertion(ClientAssertionTypeJWTBearer, assertion("ahead-30s", time.Now().Add(30*time.Second)), "")
+	assert.NoError(t, err)
+	_, err = h.authenticateClientAssertion(ClientAssertionTypeJWTBearer, assertion("ahead-2m", time.Now().Add(2*time.Minute)), "")
+	assert.Error(t, err)
+
+	h.config.JWT.ClockSkewSeconds = 0
+	_, err = h.authenticateClientAssertion(ClientAssertionTypeJWTBearer, assertion("ahead-30s-strict", time.Now().Add(30*time.Second)), "")
+	assert.Error(t, err)
+}
+
+func TestClientAssertionRegisteredSigningAlg(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+
+	client := &models.Client{
+		ID:                          "jwt-client",
+		Secret:                      "a-sufficiently-long-shared-secret-value",
+		TokenEndpointAuthMethod:     authMethodClientSecretJWT,
+		TokenEndpointAuthSigningAlg: "HS512",
+	}
+	require.NoError(t, store.CreateClient(client))
+
+	assertion := func(method jwt.SigningMethod, jti string) string {
+		signed, err := jwt.NewWithClaims(method, jwt.MapClaims{
+			"iss": client.ID,
+			"sub": client.ID,
+			"aud": h.config.Issuer + "/token",
+			"jti": jti,
+			"exp": time.Now().Add(5 * time.Minute).Unix(),
+		}).SignedString([]byte(client.Secret))
+		require.NoError(t, err)
+		return signed
+	}
+
+	_, err := h.authenticateClientAssertion(ClientAssertionTypeJWTBearer, assertion(jwt.SigningMethodHS256, "hs256"), "")
+	assert.ErrorContains(t, err, "must be signed with HS512")
+	_, err = h.authenticateClientAssertion(ClientAssertionTypeJWTBearer, assertion(jwt.SigningMethodHS512, "hs512"), "")
+	assert.NoError(t, err)
+}
+
+func TestTokenEndpointEnforcesRegisteredAuthMethod(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+	for _, client := range []*models.Client{
+		{ID: "basic-client", Secret: "basic-secret", GrantTypes: []string{"client_credentials"}, TokenEndpointAuthMethod: "client_secret_basic"},
+		{ID: "post-client", Secret: "post-secret", GrantTypes: []string{"client_credentials"}, TokenEndpointAuthMethod: "client_secret_post"},
+		{ID: "jwt-client", Secret: "a-sufficiently-long-shared-secret-value", GrantTypes: []string{"client_credentials"}, TokenEndpointAuthMethod: authMethodClientSecretJWT},
+	} {
+		require.NoError(t, store.CreateClient(client))
+	}
+
+	requestToken := func(clientID, secret string, basic bool) *httptest.ResponseRecorder {
+		form := url.Values{"grant_type": {GrantTypeClientCredentials}}
+		if !basic {
+			form.Set("client_id", clientID)
+			form.Set("client_secret", secret)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		if basic {
+			req.SetBasicAuth(clientID, secret)
+		}
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Token(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, requestToken("basic-client", "basic-secret", true).Code)
+	assert.Equal(t, http.StatusOK, requestToken("post-client", "post-secret", false).Code)
+
+	rec := requestToken("basic-client", "basic-secret", false)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "must authenticate with client_secret_basic")
+	assert.Equal(t, http.StatusUnauthorized, requestToken("post-client", "post-secret", true).Code)
+
+	// A client registered for assertions cannot present its secret instead
+	rec = requestToken("jwt-client", "a-sufficiently-long-shared-secret-value", true)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "must authenticate with client_secret_jwt")
+}
@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// Attestation types a client can require of its installs. Verifying them needs
+// platform credentials, so deployments register an InstanceAttestor for each.
+const (
+	AttestationPlayIntegrity = "play_integrity"
+	AttestationAppAttest     = "app_attest"
+)
+
+const (
+	instanceChallengeTTL          = 5 * time.Minute
+	instanceChallengeJTINamespace = "client-instance"
+	instanceIDLength              = 24
+	instanceSecretLength          = 48
+	instanceAttestationTimeout    = 10 * time.Second
+)
+
+// InstanceAttestor verifies one type of platform attestation presented by an app
+// install registering as a client instance. A Play Integrity attestor decodes the
+// integrity token and checks its nonce, package name and verdicts; an App Attest
+// attestor checks the attestation chains to Apple's root and commits to the
+// challenge. It returns the package name or bundle ID the attestation vouches for.
+type InstanceAttestor interface {
+	VerifyAttestation(ctx context.Context, client *models.Client, challenge, attestation string) (appID string, err error)
+}
+
+// RegisterInstanceAttestor makes an attestation type available to clients that
+// require it of their installs, replacing any attestor of that type
+func (h *Handlers) RegisterInstanceAttestor(attestationType string, attestor InstanceAttestor) {
+	if h.attestors == nil {
+		h.attestors = make(map[string]InstanceAttestor)
+	}
+	h.attestors[attestationType] = attestor
+}
+
+// hashInstanceSecret returns the stored form of a client instance secret. Like
+// API keys, secrets are random enough for a plain SHA-256 hash.
+func hashInstanceSecret(secret string) string {
+	return hashAPIKey(secret)
+}
+
+// instanceClient returns the client named by a client instance request. If it
+// does not use instance binding, it writes a registration error and returns false.
+func (h *Handlers) instanceClient(c echo.Context, clientID string) (*models.Client, bool) {
+	client, err := h.storage.GetClientByID(clientID)
+	if err != nil {
+		_ = c.JSON(http.StatusInternalServerError, models.ClientRegistrationError{
+			Error:            "server_error",
+			ErrorDescription: "Failed to get client",
+		})
+		return nil, false
+	}
+	if client == nil || client.Disabled || !client.IsApproved() || client.InstanceBinding == "" {
+		_ = c.JSON(http.StatusBadRequest, models.ClientRegistrationError{
+			Error:            "invalid_client_id",
+			ErrorDescription: "Client does not accept instance registration",
+		})
+		return nil, false
+	}
+	return client, true
+}
+
+// InstanceChallenge issues a single-use challenge for an install to embed in its
+// platform attestation (POST {registration_endpoint}/instances/challenge).
+// Request body: {"client_id": "..."}
+func (h *Handlers) InstanceChallenge(c echo.Context) error {
+	var req struct {
+		ClientID string `json:"client_id" form:"client_id"`
+	}
+	if err := c.Bind(&req); err != nil || req.ClientID == "" {
+		return c.JSON(http.StatusBadRequest, models.ClientRegistrationError{
+			Error:            "invalid_request",
+			ErrorDescription: "client_id is required",
+		})
+	}
+	client, ok := h.instanceClient(c, req.ClientID)
+	if !ok {
+		return nil
+	}
+
+	challenge, err := h.jwtManager.GenerateInstanceChallenge(client.ID, instanceChallengeTTL)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.ClientRegistrationError{
+			Error:            "server_error",
+			ErrorDescription: "Failed to generate challenge",
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"challenge":  challenge,
+		"expires_in": int(instanceChallengeTTL.Seconds()),
+	})
+}
+
+// RegisterInstance registers one install of a public native client
+// (POST {registration_endpoint}/instances). When the client requires attestation,
+// the install presents a challenge from InstanceChallenge and an attestation of a
+// type the client lists. The instance secret is returned once and sent with
+// client_instance_id on every token request of the install.
+func (h *Handlers) RegisterInstance(c echo.Context) error {
+	if h.registrationLimiter != nil &&
+		!h.registrationLimiter.Allow(c.RealIP(), h.config.RegistrationState().Quotas.MaxPerIPPerHour) {
+		h.logRateLimited(c, "registration.instance_quota")
+		return c.JSON(http.StatusTooManyRequests, models.ClientRegistrationError{
+			Error:            "too_many_requests",
+			ErrorDescription: "Registration quota exceeded, try again later",
+		})
+	}
+
+	var req struct {
+		ClientID        string `json:"client_id"`
+		Challenge       string `json:"challenge"`
+		AttestationType string `json:"attestation_type"`
+		Attestation     string `json:"attestation"`
+	}
+	if err := c.Bind(&req); err != nil || req.ClientID == "" {
+		return c.JSON(http.StatusBadRequest, models.ClientRegistrationError{
+			Error:            "invalid_request",
+			ErrorDescription: "client_id is required",
+		})
+	}
+	client, ok := h.instanceClient(c, req.ClientID)
+	if !ok {
+		return nil
+	}
+
+	instance := &models.ClientInstance{ClientID: client.ID, CreatedAt: time.Now()}
+	if len(client.InstanceAttestation) > 0 {
+		appID, status, regErr := h.verifyInstanceAttestation(c, client, req.Challenge, req.AttestationType, req.Attestation)
+		if regErr != nil {
+			h.logAudit(models.AuditActionClientInstanceRegistered, models.AuditActorClient, client.ID,
+				"client", client.ID, models.AuditStatusFailure, c.RealIP(), c.Request().UserAgent(),
+				map[string]interface{}{"attestation_type": req.AttestationType, "error": regErr.ErrorDescription})
+			return c.JSON(status, *regErr)
+		}
+		instance.AttestationType = req.AttestationType
+		instance.AttestedAppID = appID
+	}
+
+	secret, err := crypto.GenerateRandomString(instanceSecretLength)
+	if err == nil {
+		instance.ID, err = crypto.GenerateRandomString(instanceIDLength)
+	}
+	if err == nil {
+		instance.SecretHash = hashInstanceSecret(secret)
+		err = h.storage.CreateClientInstance(instance)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, models.ClientRegistrationError{
+			Error:            "server_error",
+			ErrorDescription: "Failed to register client instance",
+		})
+	}
+
+	h.logAudit(models.AuditActionClientInstanceRegistered, models.AuditActorClient, client.ID,
+		"client", client.ID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"instance_id": instance.ID, "attestation_type": instance.AttestationType, "attested_app_id": instance.AttestedAppID})
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"client_id":              client.ID,
+		"client_instance_id":     instance.ID,
+		"client_instance_secret": secret,
+		"attested_app_id":        instance.AttestedAppID,
+	})
+}
+
+// verifyInstanceAttestation checks a registering install's challenge and
+// attestation against the client's requirements. It returns the attested app ID,
+// or the status and error to respond with.
+func (h *Handlers) verifyInstanceAttestation(c echo.Context, client *models.Client, challenge, attestationType, attestation string) (string, int, *models.ClientRegistrationError) {
+	invalid := func(desc string) (string, int, *models.ClientRegistrationError) {
+		return "", http.StatusBadRequest, &models.ClientRegistrationError{Error: "invalid_attestation", ErrorDescription: desc}
+	}
+	if challenge == "" || attestation == "" {
+		return invalid("challenge and attestation are required")
+	}
+	if !contains(client.InstanceAttestation, attestationType) {
+		return invalid("Attestation type not accepted for this client")
+	}
+	attestor, ok := h.attestors[attestationType]
+	if !ok {
+		return "", http.StatusServiceUnavailable, &models.ClientRegistrationError{
+			Error:            "temporarily_unavailable",
+			ErrorDescription: "Attestation type is not available on this server",
+		}
+	}
+
+	claims, err := h.jwtManager.ValidateInstanceChallenge(challenge, client.ID)
+	if err != nil {
+		return invalid("Invalid or expired challenge")
+	}
+	fresh, err := h.storage.RecordJTI(instanceChallengeJTINamespace, claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return "", http.StatusInternalServerError, &models.ClientRegistrationError{
+			Error:            "server_error",
+			ErrorDescription: "Failed to check challenge",
+		}
+	}
+	if !fresh {
+		return invalid("Challenge has already been used")
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), instanceAttestationTimeout)
+	defer cancel()
+	appID, err := attestor.VerifyAttestation(ctx, client, challenge, attestation)
+	if err != nil {
+		return invalid("Attestation rejected: " + err.Error())
+	}
+	return appID, 0, nil
+}
+
+// authenticateClientInstance checks the client instance credentials sent with a
+// token request and returns the instance ID, or "" when the client was not
+// bound to an instance. Instance credentials are required when the client's
+// binding is "required", except for client_credentials, which has no install.
+func (h *Handlers) authenticateClientInstance(c echo.Context, client *models.Client, grantType string) (string, string) {
+	instanceID := c.FormValue("client_instance_id")
+	if instanceID == "" {
+		if client.InstanceBinding == models.InstanceBindingRequired && grantType != GrantTypeClientCredentials {
+			return "", "client_instance_id is required for this client"
+		}
+		return "", ""
+	}
+	if client.InstanceBinding == "" {
+		return "", "Client does not use instance binding"
+	}
+
+	instance, err := h.storage.GetClientInstance(instanceID)
+	if err != nil || instance == nil || instance.ClientID != client.ID ||
+		subtle.ConstantTimeCompare([]byte(instance.SecretHash), []byte(hashInstanceSecret(c.FormValue("client_instance_secret")))) != 1 {
+		return "", "Invalid client instance credentials"
+	}
+
+	now := time.Now()
+	if instance.LastUsedAt == nil || now.Sub(*instance.LastUsedAt) >= clientUsageUpdateInterval {
+		instance.LastUsedAt = &now
+		_ = h.storage.UpdateClientInstance(instance) // Best effort, usage tracking must not fail the request
+	}
+	return instance.ID, ""
+}
+
+// ListClientInstances returns the registered installs of a client
+// (GET /api/admin/clients/:id/instances). Instance secrets are never returned.
+func (h *AdminHandler) ListClientInstances(c echo.Context) error {
+	if _, ok := h.authenticatedAdmin(c); !ok {
+		return nil
+	}
+	client, err := h.store.GetClientByID(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get client"})
+	}
+	if client == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Client not found"})
+	}
+	instances, err := h.store.ListClientInstances(client.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get client instances"})
+	}
+
+	response := make([]map[string]interface{}, 0, len(instances))
+	for _, instance := range instances {
+		response = append(response, map[string]interface{}{
+			"id":               instance.ID,
+			"attestation_type": instance.AttestationType,
+			"attested_app_id":  instance.AttestedAppID,
+			"last_used_at":     instance.LastUsedAt,
+			"created_at":       instance.CreatedAt,
+		})
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// DeleteClientInstance revokes a registered install of a client, along with the
+// tokens issued to it (DELETE /api/admin/clients/:id/instances/:instance_id)
+func (h *AdminHandler) DeleteClientInstance(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	instance, err := h.store.GetClientInstance(c.Param("instance_id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get client instance"})
+	}
+	if instance == nil || instance.ClientID != c.Param("id") {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Client instance not found"})
+	}
+	if err := h.store.DeleteClientInstance(instance.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to revoke client instance"})
+	}
+
+	revoked := 0
+	tokens, err := h.store.ListTokens(instance.ClientID, "", true)
+	if err == nil {
+		for _, token := range tokens {
+			if token.InstanceID == instance.ID && h.store.DeleteToken(token.ID) == nil {
+				revoked++
+			}
+		}
+	}
+
+	h.logAdminAudit(models.AuditActionAdminInstanceRevoked, models.AuditActorAdmin, actor,
+		"client", instance.ClientID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"instance_id": instance.ID, "tokens_revoked": revoked})
+	return c.NoContent(http.StatusNoContent)
+}
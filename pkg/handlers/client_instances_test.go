@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storagetest"
+)
+
+// fakeAttestor accepts the attestation "genuine" for the package com.example.app
+type fakeAttestor struct {
+	challenges []string
+}
+
+func (a *fakeAttestor) VerifyAttestation(_ context.Context, _ *models.Client, challenge, attestation string) (string, error) {
+	a.challenges = append(a.challenges, challenge)
+	if attestation != "genuine" {
+		return "", errors.New("device integrity not met")
+	}
+	return "com.example.app", nil
+}
+
+func postInstanceJSON(t *testing.T, handler echo.HandlerFunc, body map[string]string) *httptest.ResponseRecorder {
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/register/instances", strings.NewReader(string(data)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	require.NoError(t, handler(echo.New().NewContext(req, rec)))
+	return rec
+}
+
+func TestRegisterClientInstanceWithAttestation(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	attestor := &fakeAttestor{}
+	h.RegisterInstanceAttestor(AttestationPlayIntegrity, attestor)
+
+	// Clients without instance binding don't accept registrations
+	rec := postInstanceJSON(t, h.InstanceChallenge, map[string]string{"client_id": client.ID})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	client.InstanceBinding = models.InstanceBindingRequired
+	client.InstanceAttestation = []string{AttestationPlayIntegrity, AttestationAppAttest}
+	require.NoError(t, store.UpdateClient(client))
+
+	challenge := func() string {
+		rec := postInstanceJSON(t, h.InstanceChallenge, map[string]string{"client_id": client.ID})
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var resp map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp["challenge"].(string)
+	}
+	register := func(challenge, attestationType, attestation string) *httptest.ResponseRecorder {
+		return postInstanceJSON(t, h.RegisterInstance, map[string]string{
+			"client_id": client.ID, "challenge": challenge, "attestation_type": attestationType, "attestation": attestation,
+		})
+	}
+
+	rec = register(challenge(), AttestationPlayIntegrity, "rooted")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "device integrity not met")
+
+	// Listed types without a registered attestor are unavailable
+	rec = register(challenge(), AttestationAppAttest, "genuine")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	rec = register("not-a-challenge", AttestationPlayIntegrity, "genuine")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Invalid or expired challenge")
+
+	issued := challenge()
+	rec = register(issued, AttestationPlayIntegrity, "genuine")
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var resp map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "com.example.app", resp["attested_app_id"])
+	assert.NotEmpty(t, resp["client_instance_secret"])
+	assert.Contains(t, attestor.challenges, issued)
+
+	instance, err := store.GetClientInstance(resp["client_instance_id"])
+	require.NoError(t, err)
+	require.NotNil(t, instance)
+	assert.Equal(t, client.ID, instance.ClientID)
+	assert.Equal(t, hashInstanceSecret(resp["client_instance_secret"]), instance.SecretHash)
+
+	// Challenges are single use
+	rec = register(issued, AttestationPlayIntegrity, "genuine")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "already been used")
+}
+
+func TestRefreshTokenBoundToClientInstance(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	client.InstanceBinding = models.InstanceBindingOptional
+	require.NoError(t, store.UpdateClient(client))
+	user := storagetest.User()
+	storagetest.Create(t, store, user)
+
+	rec := postInstanceJSON(t, h.RegisterInstance, map[string]string{"client_id": client.ID})
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var instance map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &instance))
+
+	require.NoError(t, store.CreateToken(&models.Token{
+		ID:           "bound-token",
+		AccessToken:  "bound-access-token",
+		RefreshToken: "bound-refresh-token",
+		TokenType:    "Bearer",
+		Scope:        "openid",
+		UserID:       user.ID,
+		ClientID:     client.ID,
+		InstanceID:   instance["client_instance_id"],
+		CreatedAt:    time.Now(),
+		ExpiresAt:    time.Now().Add(time.Hour),
+	}))
+
+	refresh := func(instanceID, secret string) *httptest.ResponseRecorder {
+		form := url.Values{
+			"grant_type":    {GrantTypeRefreshToken},
+			"refresh_token": {"bound-refresh-token"},
+			"client_id":     {client.ID},
+			"client_secret": {client.Secret},
+		}
+		if instanceID != "" {
+			form.Set("client_instance_id", instanceID)
+			form.Set("client_instance_secret", secret)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Token(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	rec = refresh("", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "bound to another client instance")
+
+	rec = refresh(instance["client_instance_id"], "wrong-secret")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = refresh(instance["client_instance_id"], instance["client_instance_secret"])
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var tokens TokenResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tokens))
+	rotated, err := store.GetTokenByAccessToken(tokens.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, instance["client_instance_id"], rotated.InstanceID)
+
+	// Required binding refuses token requests without instance credentials
+	client.InstanceBinding = models.InstanceBindingRequired
+	require.NoError(t, store.UpdateClient(client))
+	rec = refresh("", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), "client_instance_id is required")
+}
+
+func TestAdminDeleteClientInstance(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	admin := NewAdminHandler(store, h.config, nil)
+	instance := &models.ClientInstance{ID: "instance-1", ClientID: client.ID, SecretHash: hashInstanceSecret("s"), CreatedAt: time.Now()}
+	require.NoError(t, store.CreateClientInstance(instance))
+	require.NoError(t, store.CreateToken(&models.Token{
+		ID: "bound-token", AccessToken: "bound-access-token", TokenType: "Bearer", ClientID: client.ID,
+		InstanceID: instance.ID, CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour),
+	}))
+
+	adminToken, err := crypto.GenerateAdminToken("support", admin.adminSecret)
+	require.NoError(t, err)
+	e := echo.New()
+	call := func(method, bearer string, handler func(echo.Context) error, params ...string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/", nil)
+		if bearer != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+bearer)
+		}
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id", "instance_id")
+		c.SetParamValues(params...)
+		require.NoError(t, handler(c))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "", admin.ListClientInstances, client.ID, "").Code)
+	rec := call(http.MethodGet, adminToken, admin.ListClientInstances, client.ID, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), instance.ID)
+	assert.NotContains(t, rec.Body.String(), instance.SecretHash)
+
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodDelete, "", admin.DeleteClientInstance, client.ID, instance.ID).Code)
+	kept, err := store.GetClientInstance(instance.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, kept)
+	assert.Equal(t, http.StatusNoContent, call(http.MethodDelete, adminToken, admin.DeleteClientInstance, client.ID, instance.ID).Code)
+
+	deleted, err := store.GetClientInstance(instance.ID)
+	require.NoError(t, err)
+	assert.Nil(t, deleted)
+	token, err := store.GetTokenByAccessToken("bound-access-token")
+	require.NoError(t, err)
+	assert.Nil(t, token)
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/outbound"
+)
+
+const (
+	clientJWKSDefaultTTL = 1 * time.Hour
+	clientJWKSMinTTL     = 5 * time.Minute
+	clientJWKSMaxTTL     = 24 * time.Hour
+	// clientJWKSRefetchInterval is the shortest time between two fetches of one
+	// jwks_uri, so unknown kids can't be used to make us hammer a client's server
+	clientJWKSRefetchInterval = 30 * time.Second
+	// clientJWKSMaxStale is how long the last good set is used while jwks_uri fails
+	clientJWKSMaxStale    = 24 * time.Hour
+	clientJWKSMaxBodySize = 1 << 20
+)
+
+// clientJWKSHTTPClient fetches client JWK Sets; replaced in tests
+var clientJWKSHTTPClient = outbound.NewClient(outbound.Untrusted, clientJWKSFetchTimeout)
+
+// clientJWKSMetrics counts jwks_uri activity since startup
+var clientJWKSMetrics struct {
+	fetches     atomic.Int64 // Sets downloaded
+	revalidated atomic.Int64 // Fetches answered 304 Not Modified
+	cacheHits   atomic.Int64
+	errors      atomic.Int64 // Failed fetches, including blocked addresses
+	blocked     atomic.Int64 // Fetches refused by the outbound host lists or address checks
+	rateLimited atomic.Int64 // Fetches skipped because the uri was fetched moments ago
+	staleServed atomic.Int64 // Lookups answered with an expired set while the uri failed
+}
+
+// ClientJWKSStats returns the jwks_uri fetch counters since startup
+func ClientJWKSStats() map[string]int64 {
+	m := &clientJWKSMetrics
+	return map[string]int64{
+		"fetches":      m.fetches.Load(),
+		"revalidated":  m.revalidated.Load(),
+		"cache_hits":   m.cacheHits.Load(),
+		"errors":       m.errors.Load(),
+		"blocked":      m.blocked.Load(),
+		"rate_limited": m.rateLimited.Load(),
+		"stale_served": m.staleServed.Load(),
+	}
+}
+
+// clientJWKSCache caches client JWK Sets by jwks_uri
+type clientJWKSCache struct {
+	mu      sync.Mutex
+	entries map[string]*clientJWKSEntry
+}
+
+// clientJWKSEntry is one cached set. Its lock is held while the set is fetched,
+// so concurrent lookups of a uri share one fetch.
+type clientJWKSEntry struct {
+	mu           sync.Mutex
+	jwks         map[string]interface{}
+	etag         string
+	lastModified string
+	fetchedAt    time.Time // Last successful fetch or revalidation
+	expiresAt    time.Time
+	attemptedAt  time.Time
+}
+
+func (c *clientJWKSCache) entry(uri string) *clientJWKSEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*clientJWKSEntry)
+	}
+	entry, ok := c.entries[uri]
+	if !ok {
+		entry = &clientJWKSEntry{}
+		c.entries[uri] = entry
+	}
+	return entry
+}
+
+// clientJWKS returns the JWK Set at a client's jwks_uri. A cached set is used
+// until it expires, following the response's Cache-Control max-age. refresh asks
+// for a new copy, e.g. when a kid is not in the cached set because the client
+// rotated its keys. A uri is fetched at most once per clientJWKSRefetchInterval,
+// and the last good set is used for a while when the uri fails.
+func (h *Handlers) clientJWKS(uri string, refresh bool) (map[string]interface{}, error) {
+	entry := h.clientJWKSCache.entry(uri)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	now := time.Now()
+	if entry.jwks != nil && !refresh && now.Before(entry.expiresAt) {
+		clientJWKSMetrics.cacheHits.Add(1)
+		return entry.jwks, nil
+	}
+	if !entry.attemptedAt.IsZero() && now.Sub(entry.attemptedAt) < clientJWKSRefetchInterval {
+		clientJWKSMetrics.rateLimited.Add(1)
+		if entry.jwks != nil {
+			return entry.jwks, nil
+		}
+		return nil, fmt.Errorf("failed to fetch client jwks: retried too soon")
+	}
+	entry.attemptedAt = now
+
+	resp, err := fetchClientJWKS(uri, entry.etag, entry.lastModified)
+	if err != nil {
+		clientJWKSMetrics.errors.Add(1)
+		if entry.jwks != nil && now.Sub(entry.fetchedAt) < clientJWKSMaxStale {
+			clientJWKSMetrics.staleServed.Add(1)
+			return entry.jwks, nil
+		}
+		return nil, err
+	}
+	if resp.notModified && entry.jwks != nil {
+		clientJWKSMetrics.revalidated.Add(1)
+	} else {
+		clientJWKSMetrics.fetches.Add(1)
+		entry.jwks, entry.etag, entry.lastModified = resp.jwks, resp.etag, resp.lastModified
+	}
+	entry.fetchedAt = now
+	entry.expiresAt = now.Add(resp.ttl)
+	return entry.jwks, nil
+}
+
+// clientJWKSResponse is the outcome of fetching a jwks_uri
+type clientJWKSResponse struct {
+	jwks         map[string]interface{}
+	notModified  bool
+	ttl          time.Duration
+	etag         string
+	lastModified string
+}
+
+// fetchClientJWKS downloads a client's JWK Set over https, revalidating with the
+// validators of the cached copy if there is one
+func fetchClientJWKS(uri, etag, lastModified string) (*clientJWKSResponse, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "https" {
+		return nil, fmt.Errorf("client jwks_uri must use https")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), clientJWKSFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch client jwks")
+	}
+	req.Header.Set("Accept", "application/jwk-set+json, application/json")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	resp, err := clientJWKSHTTPClient.Do(req)
+	if err != nil {
+		for _, blocked := range []error{outbound.ErrPrivateAddress, outbound.ErrHostNotAllowed} {
+			if errors.Is(err, blocked) {
+				clientJWKSMetrics.blocked.Add(1)
+				return nil, fmt.Errorf("failed to fetch client jwks: %w", blocked)
+			}
+		}
+		return nil, fmt.Errorf("failed to fetch client jwks")
+	}
+	defer func() {
+		_ = resp.Body.Close() // Best effort close
+	}()
+
+	result := &clientJWKSResponse{ttl: jwksCacheTTL(resp.Header.Get("Cache-Control"))}
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		result.notModified = true
+		return result, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("failed to fetch client jwks: status %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, clientJWKSMaxBodySize)).Decode(&result.jwks); err != nil {
+		return nil, fmt.Errorf("failed to parse client jwks")
+	}
+	if _, ok := result.jwks["keys"].([]interface{}); !ok {
+		return nil, fmt.Errorf("client jwks has no keys")
+	}
+	result.etag = resp.Header.Get("ETag")
+	result.lastModified = resp.Header.Get("Last-Modified")
+	return result, nil
+}
+
+// jwksCacheTTL reads how long a JWK Set may be cached from its Cache-Control
+// header, kept between clientJWKSMinTTL and clientJWKSMaxTTL
+func jwksCacheTTL(cacheControl string) time.Duration {
+	ttl := clientJWKSDefaultTTL
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-cache" || directive == "no-store":
+			return clientJWKSMinTTL
+		case strings.HasPrefix(directive, "max-age="):
+			if seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil {
+				ttl = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	if ttl < clientJWKSMinTTL {
+		return clientJWKSMinTTL
+	}
+	if ttl > clientJWKSMaxTTL {
+		return clientJWKSMaxTTL
+	}
+	return ttl
+}
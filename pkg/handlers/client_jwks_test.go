@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/outbound"
+)
+
+func TestClientJWKSCachingAndRotation(t *testing.T) {
+	h, _, _, _ := setupRevokeTest(t)
+	jwksFor := func(kid string) map[string]interface{} {
+		key, _, err := crypto.GenerateRSAKeyPair()
+		require.NoError(t, err)
+		jwks, err := crypto.PublicKeyToJWKS(&key.PublicKey, kid)
+		require.NoError(t, err)
+		object, err := toJSONObject(jwks)
+		require.NoError(t, err)
+		return object
+	}
+
+	var current atomic.Value
+	current.Store(jwksFor("k1"))
+	var requests atomic.Int32
+	var failing atomic.Bool
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failing.Load() {
+			http.Error(w, "down", http.StatusBadGateway)
+			return
+		}
+		body, _ := json.Marshal(current.Load())
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256(body))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "max-age=600")
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+	previous := clientJWKSHTTPClient
+	clientJWKSHTTPClient = server.Client()
+	defer func() { clientJWKSHTTPClient = previous }()
+
+	client := &models.Client{ID: "jwks-client", JWKSURI: server.URL + "/jwks"}
+	entry := h.clientJWKSCache.entry(client.JWKSURI)
+	allowRefetch := func() {
+		entry.attemptedAt = time.Now().Add(-time.Minute)
+	}
+
+	_, err := h.clientPublicKey(client, "k1")
+	require.NoError(t, err)
+	_, err = h.clientPublicKey(client, "k1")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), requests.Load(), "the set should be cached")
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), entry.expiresAt, time.Minute)
+
+	// The client rotates its key; the unknown kid triggers a refetch
+	current.Store(jwksFor("k2"))
+	allowRefetch()
+	_, err = h.clientPublicKey(client, "k2")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), requests.Load())
+
+	// Unknown kids right after a fetch don't reach the client's server
+	_, err = h.clientPublicKey(client, "k3")
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), requests.Load())
+
+	// Expired sets are revalidated with their ETag
+	revalidated := ClientJWKSStats()["revalidated"]
+	entry.expiresAt = time.Now().Add(-time.Second)
+	allowRefetch()
+	_, err = h.clientPublicKey(client, "k2")
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), requests.Load())
+	assert.Equal(t, revalidated+1, ClientJWKSStats()["revalidated"])
+
+	// While the uri fails, the last good set keeps working
+	failing.Store(true)
+	stale := ClientJWKSStats()["stale_served"]
+	entry.expiresAt = time.Now().Add(-time.Second)
+	allowRefetch()
+	_, err = h.clientPublicKey(client, "k2")
+	require.NoError(t, err)
+	assert.Equal(t, int32(4), requests.Load())
+	assert.Equal(t, stale+1, ClientJWKSStats()["stale_served"])
+}
+
+func TestClientJWKSRefusesPrivateAddresses(t *testing.T) {
+	h, _, _, _ := setupRevokeTest(t)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("jwks_uri on a loopback address should not be fetched")
+	}))
+	defer server.Close()
+
+	blocked := ClientJWKSStats()["blocked"]
+	_, err := h.clientJWKS(server.URL+"/jwks", false)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, outbound.ErrPrivateAddress)
+	assert.Equal(t, blocked+1, ClientJWKSStats()["blocked"])
+
+	_, err = h.clientJWKS("http://jwks.example.com/jwks", false)
+	assert.ErrorContains(t, err, "must use https")
+}
+
+func TestJWKSCacheTTL(t *testing.T) {
+	assert.Equal(t, clientJWKSDefaultTTL, jwksCacheTTL(""))
+	assert.Equal(t, 2*time.Hour, jwksCacheTTL("public, max-age=7200"))
+	assert.Equal(t, clientJWKSMinTTL, jwksCacheTTL("max-age=10"))
+	assert.Equal(t, clientJWKSMaxTTL, jwksCacheTTL("max-age=604800"))
+	assert.Equal(t, clientJWKSMinTTL, jwksCacheTTL("no-store"))
+}
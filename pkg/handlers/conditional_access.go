@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/access"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/session"
+)
+
+// checkAccessPolicies consults the conditional-access policies before an
+// authorization completes. It returns true when the flow may continue; otherwise
+// the response has been written: an access_denied error, or a redirect back to
+// the login page to step up to the ACR a policy asked for.
+func (h *Handlers) checkAccessPolicies(c echo.Context, authSession *models.AuthSession, userSession *models.UserSession) (bool, error) {
+	if !h.accessPolicies.Enabled() {
+		return true, nil
+	}
+
+	user, err := h.storage.GetUserByID(userSession.UserID)
+	if err != nil || user == nil {
+		return false, jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to get user")
+	}
+	client, err := h.storage.GetClientByID(authSession.ClientID)
+	if err != nil || client == nil {
+		return false, jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to get client")
+	}
+
+	decision := h.accessPolicies.Evaluate(c.Request().Context(), &access.Request{
+		ClientID:   client.ID,
+		ClientName: client.ClientName,
+		UserID:     user.ID,
+		Username:   user.Username,
+		Email:      user.Email,
+		Role:       string(user.Role),
+		Scope:      authSession.Scope,
+		IP:         c.RealIP(),
+		UserAgent:  c.Request().UserAgent(),
+		ACR:        userSession.ACR,
+		AMR:        userSession.AMR,
+		ACRValues:  authSession.ACRValues,
+		AuthTime:   userSession.AuthTime,
+	})
+
+	details := map[string]interface{}{"client_id": client.ID, "reason": decision.Reason}
+	switch decision.Decision {
+	case access.DecisionAllow:
+		return true, nil
+	case access.DecisionStepUp:
+		if reason := h.stepUpUnavailable(client, userSession, decision.ACR); reason != "" {
+			decision.Reason = reason
+			details["reason"] = reason
+			break
+		}
+		details["acr"] = decision.ACR
+		h.logAudit(models.AuditActionStepUp, models.AuditActorUser, user.Username,
+			"user", user.ID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(), details)
+		if authSession.Prompt == "none" {
+			_ = h.sessionManager.FinishAuthSession(c, authSession.ID, session.ConsentDenied)
+			return false, h.authorizationError(c, authSession.RedirectURI, authSession.ResponseType,
+				ErrorInteractionRequired, "Stronger authentication is required", authSession.State)
+		}
+		authSession.ACRValues = []string{decision.ACR}
+		authSession.CompletedSteps = nil
+		authSession.PendingUserID = ""
+		authSession.StepState = nil
+		if err := h.storage.UpdateAuthSession(authSession); err != nil {
+			return false, jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to update authorization session")
+		}
+		return false, c.Redirect(http.StatusFound, h.path("/login?auth_session="+authSession.ID))
+	}
+
+	h.logAudit(models.AuditActionAccessDenied, models.AuditActorUser, user.Username,
+		"user", user.ID, models.AuditStatusFailure, c.RealIP(), c.Request().UserAgent(), details)
+	return false, h.denyAuthorization(c, authSession, "Access denied by policy")
+}
+
+// stepUpUnavailable explains why a step-up to acr can't be done, or returns "".
+// The user must not already hold acr, and a sign-in flow must assert it, or the
+// user would be sent around the login page forever.
+func (h *Handlers) stepUpUnavailable(client *models.Client, userSession *models.UserSession, acr string) string {
+	if userSession.ACR == acr {
+		return "step-up to " + acr + " required again after signing in with it"
+	}
+	if client.AuthFlow != "" {
+		return "client " + client.ID + " uses a fixed sign-in flow"
+	}
+	for _, flow := range h.config.AuthFlowsState() {
+		if flow.ACR == acr {
+			return ""
+		}
+	}
+	return "no sign-in flow asserts " + acr
+}
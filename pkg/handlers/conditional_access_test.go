@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/access"
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/session"
+)
+
+// requireGold asks for a gold sign-in, and denies requests from one network
+type requireGold struct{}
+
+func (requireGold) Name() string { return "require-gold" }
+
+func (requireGold) Evaluate(_ context.Context, req *access.Request) (*access.Decision, error) {
+	if req.IP == "203.0.113.9" {
+		return &access.Decision{Decision: access.DecisionDeny, Reason: "blocked network"}, nil
+	}
+	if req.ACR != "gold" {
+		return &access.Decision{Decision: access.DecisionStepUp, ACR: "gold"}, nil
+	}
+	return &access.Decision{Decision: access.DecisionAllow}, nil
+}
+
+func TestAccessPoliciesAtAuthorization(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	h.accessPolicies.AddPolicy(requireGold{}, false)
+	h.config.AuthFlows = []configstore.AuthFlowConfig{{Name: "strong", Steps: []string{StepPassword}, ACR: "gold"}}
+	client.FirstParty = true
+	require.NoError(t, store.UpdateClient(client))
+
+	user := models.NewRegularUser("posture", "posture@example.com", "hashed_password")
+	require.NoError(t, store.CreateUser(user))
+	newUserSession := func(id, acr string) *models.UserSession {
+		userSession := &models.UserSession{ID: id, UserID: user.ID, ACR: acr, AuthTime: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+		require.NoError(t, store.CreateUserSession(userSession))
+		return userSession
+	}
+	bronze, gold := newUserSession("bronze-session", "bronze"), newUserSession("gold-session", "gold")
+
+	consent := func(id, prompt, ip string, userSession *models.UserSession) *httptest.ResponseRecorder {
+		require.NoError(t, store.CreateAuthSession(&models.AuthSession{
+			ID: id, ClientID: client.ID, RedirectURI: client.RedirectURIs[0], ResponseType: ResponseTypeCode,
+			Scope: "openid", State: "xyz", Prompt: prompt, ExpiresAt: time.Now().Add(10 * time.Minute),
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/consent?auth_session="+id, nil)
+		req.Header.Set(echo.HeaderXRealIP, ip)
+		req.AddCookie(&http.Cookie{Name: session.UserSessionCookieName, Value: userSession.ID})
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.sessionManager.Middleware()(h.Consent)(echo.New().NewContext(req, rec)))
+		return rec
+	}
+	redirectQuery := func(rec *httptest.ResponseRecorder) url.Values {
+		require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		return location.Query()
+	}
+
+	// A weak sign-in is sent back to the login page with the ACR the policy asked for
+	rec := consent("step-up", "", "198.51.100.1", bronze)
+	require.Equal(t, http.StatusFound, rec.Code)
+	assert.True(t, strings.HasSuffix(rec.Header().Get("Location"), "/login?auth_session=step-up"))
+	authSession, err := store.GetAuthSession("step-up")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gold"}, authSession.ACRValues)
+
+	// Without UI the client learns that interaction is needed
+	query := redirectQuery(consent("step-up-silent", "none", "198.51.100.1", bronze))
+	assert.Equal(t, ErrorInteractionRequired, query.Get("error"))
+	assert.Equal(t, "xyz", query.Get("state"))
+
+	query = redirectQuery(consent("allowed", "", "198.51.100.1", gold))
+	assert.NotEmpty(t, query.Get("code"))
+
+	query = redirectQuery(consent("denied", "", "203.0.113.9", gold))
+	assert.Equal(t, ErrorAccessDenied, query.Get("error"))
+
+	// A step-up no sign-in flow can satisfy is a denial rather than a loop
+	h.config.AuthFlows = nil
+	query = redirectQuery(consent("no-flow", "", "198.51.100.1", bronze))
+	assert.Equal(t, ErrorAccessDenied, query.Get("error"))
+}
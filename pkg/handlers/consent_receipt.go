@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/session"
+)
+
+const (
+	consentCollectionMethod = "Consent page shown during OpenID Connect authorization"
+	consentTermination      = "Until revoked by the user or an administrator"
+	consentPurposeCategory  = "Core Function"
+	consentTypeExplicit     = "EXPLICIT"
+)
+
+// scopePiiCategories lists the personal data each standard scope releases to a client
+var scopePiiCategories = map[string][]string{
+	"openid":  {"sub"},
+	"profile": {"name", "given_name", "family_name", "picture"},
+	"email":   {"email", "email_verified"},
+	"address": {"address"},
+	"phone":   {"phone_number", "phone_number_verified"},
+}
+
+// newConsentReceipt builds the Kantara consent receipt for a user consenting to
+// scopes for client, in the language the consent page was shown in
+func (h *Handlers) newConsentReceipt(userID, language string, client *models.Client, scopes []string) *models.ConsentReceipt {
+	now := time.Now()
+	id := uuid.New().String()
+
+	clientName := client.Name
+	if clientName == "" {
+		clientName = client.ClientName
+	}
+	if clientName == "" {
+		clientName = client.ID
+	}
+	controller := models.KantaraPiiController{
+		PiiController:    clientName,
+		Contact:          clientName,
+		PiiControllerURL: client.ClientURI,
+	}
+	if len(client.Contacts) > 0 {
+		controller.Contact = client.Contacts[0]
+		controller.Email = client.Contacts[0]
+	}
+
+	policyURL := client.PolicyURI
+	if policyURL == "" {
+		policyURL = h.config.RegistrationState().PolicyURI
+	}
+
+	purposes := make([]models.KantaraConsentPurpose, 0, len(scopes))
+	for _, scope := range scopes {
+		if scope == "" {
+			continue
+		}
+		purpose := scope
+		if info, ok := scopeInfo[scope]; ok {
+			purpose = info[0]
+		}
+		piiCategory := scopePiiCategories[scope]
+		if piiCategory == nil {
+			piiCategory = []string{scope}
+		}
+		purposes = append(purposes, models.KantaraConsentPurpose{
+			Purpose:              purpose,
+			PurposeCategory:      []string{consentPurposeCategory},
+			ConsentType:          consentTypeExplicit,
+			PiiCategory:          piiCategory,
+			PrimaryPurpose:       scope == "openid",
+			Termination:          consentTermination,
+			ThirdPartyDisclosure: true,
+			ThirdPartyName:       clientName,
+		})
+	}
+
+	return &models.ConsentReceipt{
+		ID:       id,
+		UserID:   userID,
+		ClientID: client.ID,
+		TermsURL: client.TosURI,
+		Receipt: models.KantaraConsentRecord{
+			Version:          models.ConsentReceiptVersion,
+			Jurisdiction:     h.config.ConsentReceiptsState().Jurisdiction,
+			ConsentTimestamp: now.Unix(),
+			CollectionMethod: consentCollectionMethod,
+			ConsentReceiptID: id,
+			Language:         language,
+			PiiPrincipalID:   userID,
+			PiiControllers:   []models.KantaraPiiController{controller},
+			PolicyURL:        policyURL,
+			Services:         []models.KantaraConsentService{{Service: clientName, Purposes: purposes}},
+			SpiCat:           []string{},
+		},
+		CreatedAt: now,
+	}
+}
+
+// recordConsentReceipt stores a receipt for a consent grant. A failure is logged
+// but does not fail the authorization the user has already agreed to.
+func (h *Handlers) recordConsentReceipt(userID, language string, client *models.Client, scopes []string) {
+	if !h.config.ConsentReceiptsState().Enabled {
+		return
+	}
+	receipt := h.newConsentReceipt(userID, language, client, scopes)
+	if err := h.storage.CreateConsentReceipt(receipt); err != nil {
+		log.Printf("Warning: Failed to store consent receipt for user %s and client %s: %v", userID, client.ID, err)
+	}
+}
+
+// ExportConsentReceipts returns the consent receipts of the signed-in user as a
+// downloadable JSON document (GET /consent/receipts)
+func (h *Handlers) ExportConsentReceipts(c echo.Context) error {
+	userSession := session.GetUserSession(c)
+	if userSession == nil || !userSession.IsAuthenticated() {
+		return c.Redirect(http.StatusFound, h.path("/login"))
+	}
+
+	receipts, err := h.storage.ListConsentReceipts(userSession.UserID, "")
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to load consent receipts")
+	}
+	return writeConsentReceipts(c, receipts)
+}
+
+// writeConsentReceipts sends receipts as a JSON attachment
+func writeConsentReceipts(c echo.Context, receipts []*models.ConsentReceipt) error {
+	if receipts == nil {
+		receipts = []*models.ConsentReceipt{}
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="consent-receipts.json"`)
+	return c.JSON(http.StatusOK, receipts)
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/session"
+)
+
+func TestConsentReceipts(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	h.config.ConsentReceipts = configstore.ConsentReceiptConfig{Enabled: true, Jurisdiction: "EU"}
+	client.PolicyURI = "https://example.com/privacy"
+	client.TosURI = "https://example.com/terms"
+	client.Contacts = []string{"dpo@example.com"}
+	require.NoError(t, store.UpdateClient(client))
+
+	user := models.NewRegularUser("alice", "alice@example.com", "hashed_password")
+	require.NoError(t, store.CreateUser(user))
+	userSession := &models.UserSession{ID: "alice-session", UserID: user.ID, AuthTime: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, store.CreateUserSession(userSession))
+	authSession := &models.AuthSession{ID: "consent-auth", ClientID: client.ID, RedirectURI: client.RedirectURIs[0],
+		ResponseType: "code", Scope: "openid email", ExpiresAt: time.Now().Add(10 * time.Minute)}
+	require.NoError(t, store.CreateAuthSession(authSession))
+
+	serve := func(method, target string, body url.Values, handler echo.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		req.AddCookie(&http.Cookie{Name: session.UserSessionCookieName, Value: userSession.ID})
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.sessionManager.Middleware()(handler)(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	rec := serve(http.MethodPost, "/consent?auth_session="+authSession.ID, url.Values{"consent": {"allow"}}, h.Consent)
+	require.Equal(t, http.StatusFound, rec.Code)
+
+	rec = serve(http.MethodGet, "/consent/receipts", nil, h.ExportConsentReceipts)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get(echo.HeaderContentDisposition), "attachment")
+
+	var receipts []models.ConsentReceipt
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &receipts))
+	require.Len(t, receipts, 1)
+	assert.Equal(t, "https://example.com/terms", receipts[0].TermsURL)
+
+	receipt := receipts[0].Receipt
+	assert.Equal(t, models.ConsentReceiptVersion, receipt.Version)
+	assert.Equal(t, "EU", receipt.Jurisdiction)
+	assert.Equal(t, user.ID, receipt.PiiPrincipalID)
+	assert.Equal(t, "https://example.com/privacy", receipt.PolicyURL)
+	require.Len(t, receipt.PiiControllers, 1)
+	assert.Equal(t, "dpo@example.com", receipt.PiiControllers[0].Contact)
+	require.Len(t, receipt.Services, 1)
+	require.Len(t, receipt.Services[0].Purposes, 2)
+	assert.Equal(t, []string{"email", "email_verified"}, receipt.Services[0].Purposes[1].PiiCategory)
+
+	// The Kantara document uses the specification's field names
+	assert.Contains(t, rec.Body.String(), `"consentReceiptID"`)
+	assert.Contains(t, rec.Body.String(), `"piiPrincipalId"`)
+
+	admin := NewAdminHandler(store, h.config, nil)
+	adminToken, err := crypto.GenerateAdminToken("dpo", admin.adminSecret)
+	require.NoError(t, err)
+	exportAs := func(query, bearer string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/consent-receipts?"+query, nil)
+		if bearer != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+bearer)
+		}
+		require.NoError(t, admin.ExportConsentReceipts(echo.New().NewContext(req, rec)))
+		return rec
+	}
+	assert.Equal(t, http.StatusUnauthorized, exportAs("user_id="+user.ID, "").Code)
+
+	export := func(query string) []models.ConsentReceipt {
+		rec := exportAs(query, adminToken)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var exported []models.ConsentReceipt
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &exported))
+		return exported
+	}
+	assert.Len(t, export("user_id="+user.ID), 1)
+	assert.Empty(t, export("client_id=other-client"))
+}
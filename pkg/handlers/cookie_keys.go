@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/session"
+)
+
+const defaultCookieKeyRotationDays = 30
+
+// rotateCookieKeyIfDue replaces the active session cookie key once it reaches the
+// configured rotation age. It reports whether a new key was generated.
+func (h *Handlers) rotateCookieKeyIfDue() (bool, error) {
+	settings := h.config.SessionCookiesState()
+	if !settings.Encrypt {
+		return false, nil
+	}
+	rotationDays := settings.KeyRotationDays
+	if rotationDays <= 0 {
+		rotationDays = defaultCookieKeyRotationDays
+	}
+
+	keys, err := h.storage.GetAllSigningKeys()
+	if err != nil {
+		return false, err
+	}
+	if active := session.ActiveCookieKey(keys); active != nil &&
+		time.Since(active.CreatedAt) < time.Duration(rotationDays)*24*time.Hour {
+		return false, nil
+	}
+
+	if _, err := session.RotateCookieKey(h.storage); err != nil {
+		return false, err
+	}
+	if h.sessionManager != nil {
+		h.sessionManager.ReloadCookieKeys()
+	}
+	return true, nil
+}
+
+// StartCookieKeyRotation periodically rotates the session cookie key when it is due
+func (h *Handlers) StartCookieKeyRotation(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if rotated, err := h.rotateCookieKeyIfDue(); err != nil {
+				log.Printf("Warning: Failed to rotate session cookie key: %v", err)
+			} else if rotated {
+				log.Println("Rotated session cookie key")
+			}
+		}
+	}()
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/middleware"
+)
+
+// maxLoggedBodySize caps how much of a payload is captured for debug logging
+const maxLoggedBodySize = 64 << 10
+
+// captureWriter tees the response body into a bounded buffer
+type captureWriter struct {
+	http.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	if remaining := maxLoggedBodySize - w.buf.Len(); remaining > 0 {
+		w.buf.Write(b[:min(len(b), remaining)])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// PayloadLogger returns middleware that logs OAuth request and response payloads
+// with secrets, tokens, passwords and codes redacted. It logs every request when
+// the global debug mode is on, or only requests from clients that have debug
+// logging enabled.
+func (h *Handlers) PayloadLogger() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if strings.HasPrefix(req.URL.Path, "/api/") {
+				return next(c)
+			}
+
+			var body []byte
+			if req.Body != nil && req.ContentLength != 0 {
+				body, _ = io.ReadAll(io.LimitReader(req.Body, maxLoggedBodySize))
+				req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), req.Body))
+			}
+
+			clientID := requestClientID(req, body)
+			if !h.payloadLoggingEnabled(clientID) {
+				return next(c)
+			}
+
+			writer := &captureWriter{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = writer
+			err := next(c)
+
+			resp := c.Response()
+			entry := map[string]interface{}{
+				"type":      "payload",
+				"method":    req.Method,
+				"uri":       middleware.RedactURL(req.RequestURI),
+				"client_id": clientID,
+				"status":    resp.Status,
+				"request":   middleware.RedactBody(req.Header.Get(echo.HeaderContentType), body),
+				"response":  middleware.RedactBody(resp.Header().Get(echo.HeaderContentType), writer.buf.Bytes()),
+			}
+			if location := resp.Header().Get(echo.HeaderLocation); location != "" {
+				entry["location"] = middleware.RedactURL(location)
+			}
+			if line, marshalErr := json.Marshal(entry); marshalErr == nil {
+				log.Print(string(line))
+			}
+			return err
+		}
+	}
+}
+
+// payloadLoggingEnabled reports whether payloads should be logged for clientID
+func (h *Handlers) payloadLoggingEnabled(clientID string) bool {
+	if h.config.LoggingState().DebugPayloads {
+		return true
+	}
+	if clientID == "" {
+		return false
+	}
+	client, err := h.storage.GetClientByID(clientID)
+	return err == nil && client != nil && client.DebugLogging
+}
+
+// requestClientID finds the client_id of a request from its query, form body or Basic auth
+func requestClientID(req *http.Request, body []byte) string {
+	if clientID := req.URL.Query().Get("client_id"); clientID != "" {
+		return clientID
+	}
+	if strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEApplicationForm) {
+		if values, err := url.ParseQuery(string(body)); err == nil && values.Get("client_id") != "" {
+			return values.Get("client_id")
+		}
+	}
+	if clientID, _, ok := parseBasicAuth(req.Header.Get(echo.HeaderAuthorization)); ok {
+		return clientID
+	}
+	return ""
+}
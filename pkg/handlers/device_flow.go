@@ -0,0 +1,522 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/session"
+)
+
+// Device authorization grant error codes (RFC 8628 Section 3.5)
+const (
+	ErrorAuthorizationPending = "authorization_pending"
+	ErrorSlowDown             = "slow_down"
+	ErrorExpiredToken         = "expired_token"
+)
+
+const (
+	// responseTypeDevice marks the authorization sessions opened from the device
+	// verification page. It is never accepted at the authorization endpoint.
+	responseTypeDevice = "device"
+
+	// devicePath is where the verification page is always served
+	devicePath = "/device"
+
+	base20Charset = "BCDFGHJKLMNPQRSTVWXZ"
+	digitsCharset = "0123456789"
+
+	defaultUserCodeLength    = 8
+	minUserCodeLength        = 6
+	maxUserCodeLength        = 16
+	defaultUserCodeGroupSize = 4
+
+	defaultDeviceCodeExpiry   = 10 * time.Minute
+	defaultDevicePollInterval = 5
+
+	// slowDownIncrement is added to the polling interval each time a device polls too fast
+	slowDownIncrement = 5
+
+	// maxUserCodeFailures is how many wrong user codes an IP address may enter within
+	// the login failure window, which keeps codes from being guessed
+	maxUserCodeFailures = 10
+
+	// kioskReturnSeconds is how long a kiosk shows the outcome before asking for the next code
+	kioskReturnSeconds = 10
+)
+
+// DeviceAuthorizationResponse is the device authorization response (RFC 8628 Section 3.2)
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// userCodeCharset returns the characters user codes are drawn from. A custom set
+// is upper-cased with duplicates and separators removed; one with fewer than two
+// usable characters falls back to base20.
+func (h *Handlers) userCodeCharset() string {
+	switch charset := h.config.DeviceFlowState().UserCodeCharset; charset {
+	case "", configstore.UserCodeCharsetBase20:
+		return base20Charset
+	case configstore.UserCodeCharsetDigits:
+		return digitsCharset
+	default:
+		var b strings.Builder
+		for _, r := range strings.ToUpper(charset) {
+			if (unicode.IsLetter(r) || unicode.IsDigit(r)) && r < unicode.MaxASCII && !strings.ContainsRune(b.String(), r) {
+				b.WriteRune(r)
+			}
+		}
+		if b.Len() < 2 {
+			return base20Charset
+		}
+		return b.String()
+	}
+}
+
+func (h *Handlers) userCodeLength() int {
+	length := h.config.DeviceFlowState().UserCodeLength
+	switch {
+	case length <= 0:
+		return defaultUserCodeLength
+	case length < minUserCodeLength:
+		return minUserCodeLength
+	case length > maxUserCodeLength:
+		return maxUserCodeLength
+	}
+	return length
+}
+
+func (h *Handlers) userCodeGroupSize() int {
+	if size := h.config.DeviceFlowState().UserCodeGroupSize; size > 0 {
+		return size
+	}
+	return defaultUserCodeGroupSize
+}
+
+func (h *Handlers) deviceCodeExpiry() time.Duration {
+	if seconds := h.config.DeviceFlowState().ExpiresInSeconds; seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return defaultDeviceCodeExpiry
+}
+
+func (h *Handlers) devicePollInterval() int {
+	if seconds := h.config.DeviceFlowState().IntervalSeconds; seconds > 0 {
+		return seconds
+	}
+	return defaultDevicePollInterval
+}
+
+// generateUserCode draws a user code from the configured character set
+func (h *Handlers) generateUserCode() (string, error) {
+	charset := h.userCodeCharset()
+	size := big.NewInt(int64(len(charset)))
+	code := make([]byte, h.userCodeLength())
+	for i := range code {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", err
+		}
+		code[i] = charset[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// normalizeUserCode turns what a user typed into the stored form of a user code:
+// separators and spaces are dropped and letters upper-cased
+func normalizeUserCode(input string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, input)
+}
+
+// formatUserCode splits a normalized user code into dash-separated groups for display
+func (h *Handlers) formatUserCode(code string) string {
+	size := h.userCodeGroupSize()
+	var b strings.Builder
+	for i, r := range code {
+		if i > 0 && i%size == 0 {
+			b.WriteByte('-')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// verificationURI returns the address users are told to visit. A configured path is
+// resolved against the issuer; an absolute URL on a vanity domain is used as is.
+func (h *Handlers) verificationURI() string {
+	uri := strings.TrimRight(h.config.DeviceFlowState().VerificationURI, "/")
+	switch {
+	case uri == "":
+		return h.config.Issuer + devicePath
+	case strings.HasPrefix(uri, "/"):
+		return h.config.Issuer + uri
+	}
+	return uri
+}
+
+// DeviceVerificationPath returns the configured short path of the verification page,
+// if it is served by this server in addition to /device
+func (h *Handlers) DeviceVerificationPath() (string, bool) {
+	path := strings.TrimRight(h.config.DeviceFlowState().VerificationURI, "/")
+	if !strings.HasPrefix(path, "/") || path == devicePath {
+		return "", false
+	}
+	return path, true
+}
+
+// DeviceAuthorization starts a device authorization grant (POST /device_authorization,
+// RFC 8628 Section 3.1). The user code is returned formatted for display, and
+// verification_uri_complete carries it as a path segment so a QR code stays short.
+func (h *Handlers) DeviceAuthorization(c echo.Context) error {
+	if !h.config.FeatureEnabled(configstore.FeatureDeviceFlow) {
+		return jsonError(c, http.StatusNotFound, ErrorInvalidRequest, "Device authorization is not enabled")
+	}
+
+	clientID, clientSecret := c.FormValue("client_id"), c.FormValue("client_secret")
+	if id, secret, ok := parseBasicAuth(c.Request().Header.Get("Authorization")); ok {
+		clientID, clientSecret = id, secret
+	}
+	client, err := h.storage.ValidateClient(clientID, clientSecret)
+	if err != nil || client == nil {
+		return h.rejectClientAuth(c, clientID, "Invalid client credentials")
+	}
+	if !client.IsApproved() || client.Disabled || !contains(client.GrantTypes, GrantTypeDeviceCode) {
+		return jsonError(c, http.StatusBadRequest, ErrorUnauthorizedClient, "Client is not authorized for the device authorization grant")
+	}
+
+	scope := c.FormValue("scope")
+	if scope == "" {
+		scope = client.Scope
+	} else if !h.validateScope(scope, client.Scope) {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidScope, "Requested scope exceeds client's allowed scope")
+	}
+
+	deviceCode, err := crypto.GenerateOpaqueToken("", h.config.JWTState().TokenLength)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate device code")
+	}
+	// User codes are short, so draw again on the rare collision with a live one
+	var userCode string
+	for attempt := 0; attempt < 5 && userCode == ""; attempt++ {
+		candidate, genErr := h.generateUserCode()
+		if genErr != nil {
+			return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate user code")
+		}
+		if existing, _ := h.storage.GetDeviceAuthorizationByUserCode(candidate); existing == nil || existing.IsExpired() {
+			userCode = candidate
+		}
+	}
+	if userCode == "" {
+		return jsonError(c, http.StatusServiceUnavailable, ErrorTemporarilyUnavailable, "Failed to allocate a user code, try again")
+	}
+
+	interval := h.devicePollInterval()
+	auth := &models.DeviceAuthorization{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		ClientID:   client.ID,
+		Scope:      scope,
+		Status:     models.DeviceAuthorizationPending,
+		Interval:   interval,
+		ExpiresAt:  time.Now().Add(h.deviceCodeExpiry()),
+	}
+	if err := h.storage.CreateDeviceAuthorization(auth); err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to save device authorization")
+	}
+	h.markClientUsed(client)
+
+	displayCode := h.formatUserCode(userCode)
+	verificationURI := h.verificationURI()
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                displayCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "/" + url.PathEscape(displayCode),
+		ExpiresIn:               int(h.deviceCodeExpiry().Seconds()),
+		Interval:                interval,
+	})
+}
+
+// handleDeviceCodeGrant exchanges an approved device code for tokens (RFC 8628 Section 3.4).
+// Until the user decides, the device is told to keep polling at its interval.
+func (h *Handlers) handleDeviceCodeGrant(c echo.Context, req *TokenRequest, client *models.Client) error {
+	if !h.config.FeatureEnabled(configstore.FeatureDeviceFlow) {
+		return jsonError(c, http.StatusBadRequest, ErrorUnsupportedGrantType, "Grant type not supported")
+	}
+	if req.DeviceCode == "" {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidRequest, "device_code is required")
+	}
+
+	auth, err := h.storage.GetDeviceAuthorization(req.DeviceCode)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to look up device code")
+	}
+	if auth == nil || auth.ClientID != client.ID {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "Invalid device code")
+	}
+	if auth.IsExpired() {
+		_ = h.storage.DeleteDeviceAuthorization(auth.DeviceCode)
+		return jsonError(c, http.StatusBadRequest, ErrorExpiredToken, "The device code has expired")
+	}
+
+	now := time.Now()
+	tooFast := auth.LastPolledAt != nil && now.Sub(*auth.LastPolledAt) < time.Duration(auth.Interval)*time.Second
+	auth.LastPolledAt = &now
+	if tooFast {
+		auth.Interval += slowDownIncrement
+		_ = h.storage.UpdateDeviceAuthorization(auth)
+		return jsonError(c, http.StatusBadRequest, ErrorSlowDown, "Polling too frequently, wait longer between requests")
+	}
+
+	switch auth.Status {
+	case models.DeviceAuthorizationPending:
+		_ = h.storage.UpdateDeviceAuthorization(auth)
+		return jsonError(c, http.StatusBadRequest, ErrorAuthorizationPending, "The user has not yet approved the device")
+	case models.DeviceAuthorizationDenied:
+		_ = h.storage.DeleteDeviceAuthorization(auth.DeviceCode)
+		return jsonError(c, http.StatusBadRequest, ErrorAccessDenied, "The user denied the device")
+	}
+
+	// An approved device code is exchanged once
+	if err := h.storage.DeleteDeviceAuthorization(auth.DeviceCode); err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to redeem device code")
+	}
+	user, err := h.storage.GetUserByID(auth.UserID)
+	if err != nil || user == nil || !user.CanAuthenticate() {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "User account is unavailable")
+	}
+
+	token, err := h.newToken(client.ID, user.ID, auth.Scope)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate token")
+	}
+	token.InstanceID = req.InstanceID
+	if err := h.storage.CreateToken(token); err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to save token")
+	}
+
+	var idToken string
+	if strings.Contains(auth.Scope, "openid") {
+		jwtManager, jmErr := h.jwtManagerFor(client)
+		if jmErr != nil {
+			return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate ID token")
+		}
+		authTime := now
+		if auth.AuthTime != nil {
+			authTime = *auth.AuthTime
+		}
+		idToken, err = jwtManager.GenerateIDTokenWithClaims(user, client.ID, "", auth.Scope, authTime, auth.ACR, auth.AMR, token.AccessToken, "")
+		if err != nil {
+			return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate ID token")
+		}
+	}
+
+	h.logAudit(models.AuditActionTokenIssued, models.AuditActorUser, user.Username,
+		"token", token.AccessToken[:min(16, len(token.AccessToken))],
+		models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"grant_type": "device_code", "client_id": client.ID, "scope": auth.Scope})
+
+	response := TokenResponse{
+		AccessToken:  token.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    h.config.JWTState().ExpiryMinutes * 60,
+		RefreshToken: token.RefreshToken,
+		IDToken:      idToken,
+		Scope:        auth.Scope,
+	}
+	h.addTokenResponseParams(&response, client, user, auth.Scope, GrantTypeDeviceCode)
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func deviceCodeFailureKey(ip string) string {
+	return "device:" + ip
+}
+
+// DeviceVerification is the page where users enter the code shown on their device
+// (GET/POST /device). verification_uri_complete links to /device/{user_code}, which
+// fills the code in but still has the user confirm it before signing in.
+func (h *Handlers) DeviceVerification(c echo.Context) error {
+	if !h.config.FeatureEnabled(configstore.FeatureDeviceFlow) {
+		return jsonError(c, http.StatusNotFound, ErrorInvalidRequest, "Device authorization is not enabled")
+	}
+
+	if c.Request().Method == http.MethodGet {
+		userCode := c.Param("user_code")
+		if userCode == "" {
+			userCode = c.QueryParam("user_code")
+		}
+		return h.renderDevicePage(c, devicePage{UserCode: h.formatUserCode(normalizeUserCode(userCode))})
+	}
+
+	failureKey := deviceCodeFailureKey(c.RealIP())
+	if h.loginFailures != nil && h.loginFailures.Count(failureKey) >= maxUserCodeFailures {
+		h.logRateLimited(c, "device_flow.user_code_failures")
+		return h.renderDevicePage(c, devicePage{ErrorMessage: "Too many incorrect codes. Wait a few minutes and try again."})
+	}
+
+	userCode := normalizeUserCode(c.FormValue("user_code"))
+	auth, err := h.storage.GetDeviceAuthorizationByUserCode(userCode)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to look up code")
+	}
+	if auth == nil || auth.IsExpired() || auth.Status != models.DeviceAuthorizationPending {
+		if h.loginFailures != nil {
+			h.loginFailures.Add(failureKey)
+		}
+		return h.renderDevicePage(c, devicePage{
+			UserCode:     h.formatUserCode(userCode),
+			ErrorMessage: "That code is not valid or has expired. Check the code on your device and try again.",
+		})
+	}
+
+	// The user signs in and approves on the regular login and consent pages
+	authSession, err := h.sessionManager.CreateAuthSession(c, auth.ClientID, "", responseTypeDevice, auth.Scope, auth.UserCode)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to create authorization session")
+	}
+
+	// Kiosk screens are shared, so every approval starts with a fresh sign-in
+	userSession := session.GetUserSession(c)
+	if h.config.DeviceFlowState().Kiosk || userSession == nil || !userSession.IsAuthenticated() {
+		return c.Redirect(http.StatusFound, h.path("/login?auth_session="+authSession.ID))
+	}
+	if user, err := h.storage.GetUserByID(userSession.UserID); err != nil || user == nil || !user.CanAuthenticate() {
+		return c.Redirect(http.StatusFound, h.path("/login?auth_session="+authSession.ID))
+	}
+	if !h.sessionSatisfiesFlow(authSession, userSession) {
+		return c.Redirect(http.StatusFound, h.path("/login?auth_session="+authSession.ID))
+	}
+	// Approving a device is always confirmed, even for clients consented to before
+	return c.Redirect(http.StatusFound, h.path("/consent?auth_session="+authSession.ID))
+}
+
+// finishDeviceAuthorization records the user's decision on the consent page for the
+// device authorization behind authSession and shows the outcome
+func (h *Handlers) finishDeviceAuthorization(c echo.Context, authSession *models.AuthSession, userSession *models.UserSession, approved bool) error {
+	outcome := session.DeviceDenied
+	if approved {
+		outcome = session.DeviceApproved
+	}
+	_ = h.sessionManager.FinishAuthSession(c, authSession.ID, outcome)
+
+	auth, err := h.storage.GetDeviceAuthorizationByUserCode(authSession.State)
+	if err != nil || auth == nil || auth.IsExpired() || auth.Status != models.DeviceAuthorizationPending || auth.ClientID != authSession.ClientID {
+		return h.renderDevicePage(c, devicePage{ErrorMessage: "This code has expired. Start again on your device."})
+	}
+
+	actor := ""
+	action := models.AuditActionDeviceDenied
+	auth.Status = models.DeviceAuthorizationDenied
+	if userSession != nil {
+		actor = h.userIDToUsername(userSession.UserID)
+		auth.UserID = userSession.UserID
+	}
+	if approved && userSession != nil {
+		action = models.AuditActionDeviceApproved
+		auth.Status = models.DeviceAuthorizationApproved
+		authTime := userSession.AuthTime
+		auth.AuthTime = &authTime
+		auth.ACR = userSession.ACR
+		auth.AMR = userSession.AMR
+	}
+	if err := h.storage.UpdateDeviceAuthorization(auth); err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to save decision")
+	}
+
+	h.logAudit(action, models.AuditActorUser, actor,
+		"client", auth.ClientID, models.AuditStatusSuccess,
+		c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"scope": auth.Scope})
+
+	// Leave nothing signed in on a shared screen
+	if h.config.DeviceFlowState().Kiosk && userSession != nil {
+		_ = h.sessionManager.DeleteUserSession(c, userSession.ID)
+	}
+
+	page := devicePage{Result: auth.Status}
+	if client, err := h.storage.GetClientByID(auth.ClientID); err == nil && client != nil {
+		page.ClientName = client.Name
+		if page.ClientName == "" {
+			page.ClientName = client.ClientName
+		}
+	}
+	return h.renderDevicePage(c, page)
+}
+
+// devicePage is the state of the device verification page
+type devicePage struct {
+	UserCode     string
+	ErrorMessage string
+	Result       string // The recorded decision once the user has approved or denied
+	ClientName   string
+}
+
+func (h *Handlers) renderDevicePage(c echo.Context, page devicePage) error {
+	brand, locale := h.pageBrandAndLocale(c, nil)
+	inputMode := "text"
+	if h.userCodeCharset() == digitsCharset {
+		inputMode = "numeric"
+	}
+	length := h.userCodeLength()
+	placeholder := h.formatUserCode(strings.Repeat("X", length))
+	if inputMode == "numeric" {
+		placeholder = h.formatUserCode(strings.Repeat("0", length))
+	}
+
+	data := struct {
+		BasePath      string
+		Brand         pageBrand
+		Locale        string
+		UserCode      string
+		ErrorMessage  string
+		Result        string
+		ClientName    string
+		InputMode     string
+		Placeholder   string
+		MaxLength     int
+		Kiosk         bool
+		ReturnSeconds int
+	}{
+		BasePath:      h.config.BasePath(),
+		Brand:         brand,
+		Locale:        locale,
+		UserCode:      page.UserCode,
+		ErrorMessage:  page.ErrorMessage,
+		Result:        page.Result,
+		ClientName:    page.ClientName,
+		InputMode:     inputMode,
+		Placeholder:   placeholder,
+		MaxLength:     len(placeholder),
+		Kiosk:         h.config.DeviceFlowState().Kiosk,
+		ReturnSeconds: kioskReturnSeconds,
+	}
+	c.Response().Header().Set("Content-Type", "text/html; charset=utf-8")
+	c.Response().Header().Set("Cache-Control", "no-store")
+	status := http.StatusOK
+	if page.ErrorMessage != "" {
+		status = http.StatusBadRequest
+	}
+	c.Response().WriteHeader(status)
+	return h.deviceTmpl.Execute(c.Response().Writer, data)
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestDeviceAuthorizationFlow(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	client.GrantTypes = append(client.GrantTypes, GrantTypeDeviceCode)
+	client.Scope = "openid profile"
+	require.NoError(t, store.UpdateClient(client))
+	user := models.NewRegularUser("alice", "alice@example.com", "hashed_password")
+	require.NoError(t, store.CreateUser(user))
+
+	h.config.SetFeature(configstore.FeatureDeviceFlow, true)
+	h.config.DeviceFlow = configstore.DeviceFlowConfig{
+		UserCodeCharset:   configstore.UserCodeCharsetDigits,
+		UserCodeLength:    6,
+		UserCodeGroupSize: 3,
+		VerificationURI:   "/go",
+	}
+	e := echo.New()
+
+	post := func(handler echo.HandlerFunc, path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		require.NoError(t, handler(e.NewContext(req, rec)))
+		return rec
+	}
+	poll := func(deviceCode string) *httptest.ResponseRecorder {
+		return post(h.Token, "/token", url.Values{"grant_type": {GrantTypeDeviceCode}, "device_code": {deviceCode},
+			"client_id": {client.ID}, "client_secret": {client.Secret}})
+	}
+
+	rec := post(h.DeviceAuthorization, "/device_authorization", url.Values{"client_id": {client.ID}, "client_secret": {client.Secret}})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var started DeviceAuthorizationResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &started))
+	assert.Regexp(t, regexp.MustCompile(`^[0-9]{3}-[0-9]{3}$`), started.UserCode)
+	assert.Equal(t, "https://example.com/go", started.VerificationURI)
+	assert.Equal(t, "https://example.com/go/"+started.UserCode, started.VerificationURIComplete)
+	assert.Equal(t, defaultDevicePollInterval, started.Interval)
+
+	// The device is told to wait, and to back off when it polls too fast
+	rec = poll(started.DeviceCode)
+	assert.Contains(t, rec.Body.String(), ErrorAuthorizationPending)
+	rec = poll(started.DeviceCode)
+	assert.Contains(t, rec.Body.String(), ErrorSlowDown)
+
+	// verification_uri_complete pre-fills the code on a numeric-keypad friendly page
+	req := httptest.NewRequest(http.MethodGet, "/go/"+started.UserCode, nil)
+	rec = httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("user_code")
+	c.SetParamValues(started.UserCode)
+	require.NoError(t, h.DeviceVerification(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `value="`+started.UserCode+`"`)
+	assert.Contains(t, rec.Body.String(), `inputmode="numeric"`)
+
+	// A wrong code is refused; the right one leads to sign-in
+	rec = post(h.DeviceVerification, "/device", url.Values{"user_code": {"ABC-DEF"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = post(h.DeviceVerification, "/device", url.Values{"user_code": {" " + strings.ReplaceAll(started.UserCode, "-", " ") + " "}})
+	require.Equal(t, http.StatusFound, rec.Code)
+	location := rec.Header().Get("Location")
+	require.Contains(t, location, "/login?auth_session=")
+
+	authSession, err := store.GetAuthSession(strings.TrimPrefix(location, "/login?auth_session="))
+	require.NoError(t, err)
+	require.NotNil(t, authSession)
+	userSession := &models.UserSession{ID: "session-1", UserID: user.ID, AuthTime: time.Now(), AMR: []string{"pwd"}}
+	req = httptest.NewRequest(http.MethodPost, "/consent", nil)
+	rec = httptest.NewRecorder()
+	require.NoError(t, h.completeAuthorization(e.NewContext(req, rec), authSession, userSession))
+	assert.Contains(t, rec.Body.String(), "Test Client is connected")
+
+	// Once approved, the next poll after the interval returns tokens, once
+	auth, err := store.GetDeviceAuthorization(started.DeviceCode)
+	require.NoError(t, err)
+	earlier := time.Now().Add(-time.Minute)
+	auth.LastPolledAt = &earlier
+	require.NoError(t, store.UpdateDeviceAuthorization(auth))
+
+	rec = poll(started.DeviceCode)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var token TokenResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &token))
+	assert.NotEmpty(t, token.AccessToken)
+	assert.NotEmpty(t, token.IDToken)
+	stored, err := store.GetTokenByAccessToken(token.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, stored.UserID)
+
+	rec = poll(started.DeviceCode)
+	assert.Contains(t, rec.Body.String(), ErrorInvalidGrant)
+}
+
+func TestDeviceUserCodeFormat(t *testing.T) {
+	h := &Handlers{config: &configstore.ConfigData{}}
+	code, err := h.generateUserCode()
+	require.NoError(t, err)
+	assert.Len(t, code, defaultUserCodeLength)
+	assert.Equal(t, "", strings.Trim(code, base20Charset))
+	assert.Equal(t, code[:4]+"-"+code[4:], h.formatUserCode(code))
+	assert.Equal(t, "BCDFGHJK", normalizeUserCode(" bcdf-ghjk "))
+
+	// Custom character sets are upper-cased and deduplicated
+	h.config.DeviceFlow.UserCodeCharset = "aab-c"
+	assert.Equal(t, "ABC", h.userCodeCharset())
+	h.config.DeviceFlow.UserCodeCharset = "x"
+	assert.Equal(t, base20Charset, h.userCodeCharset())
+
+	// A vanity domain is advertised as is
+	h.config.DeviceFlow.VerificationURI = "https://go.example.com/"
+	assert.Equal(t, "https://go.example.com", h.verificationURI())
+	_, served := h.DeviceVerificationPath()
+	assert.False(t, served)
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof" // #nosec G108 -- served only through AdminHandler.Pprof, never on DefaultServeMux
+	"runtime"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// processStart is when the server process started, for the uptime in RuntimeStats
+var processStart = time.Now()
+
+// RuntimeStats is a snapshot of the process for diagnosing production incidents
+type RuntimeStats struct {
+	Uptime     string                 `json:"uptime"`
+	GoVersion  string                 `json:"go_version"`
+	Goroutines int                    `json:"goroutines"`
+	GOMAXPROCS int                    `json:"gomaxprocs"`
+	NumCPU     int                    `json:"num_cpu"`
+	Memory     RuntimeMemoryStats     `json:"memory"`
+	GC         RuntimeGCStats         `json:"gc"`
+	Storage    map[string]interface{} `json:"storage"`
+}
+
+// RuntimeMemoryStats reports heap usage in bytes
+type RuntimeMemoryStats struct {
+	HeapAlloc   uint64 `json:"heap_alloc"`
+	HeapInuse   uint64 `json:"heap_inuse"`
+	HeapObjects uint64 `json:"heap_objects"`
+	StackInuse  uint64 `json:"stack_inuse"`
+	Sys         uint64 `json:"sys"`
+}
+
+// RuntimeGCStats reports garbage collector activity
+type RuntimeGCStats struct {
+	NumGC        uint32     `json:"num_gc"`
+	Forced       uint32     `json:"forced"` // Started by runtime.GC or debug.FreeOSMemory
+	PauseTotalMs float64    `json:"pause_total_ms"`
+	LastPauseMs  float64    `json:"last_pause_ms"`
+	LastGC       *time.Time `json:"last_gc,omitempty"`
+	NextGCBytes  uint64     `json:"next_gc_bytes"`
+	CPUFraction  float64    `json:"cpu_fraction"`
+}
+
+// GetRuntimeStats returns goroutine, memory, GC and storage figures for the
+// running process (GET /api/admin/runtime)
+func (h *AdminHandler) GetRuntimeStats(c echo.Context) error {
+	if _, ok := h.authenticatedAdmin(c); !ok {
+		return nil
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := RuntimeStats{
+		Uptime:     time.Since(processStart).Round(time.Second).String(),
+		GoVersion:  runtime.Version(),
+		Goroutines: runtime.NumGoroutine(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		NumCPU:     runtime.NumCPU(),
+		Memory: RuntimeMemoryStats{
+			HeapAlloc:   mem.HeapAlloc,
+			HeapInuse:   mem.HeapInuse,
+			HeapObjects: mem.HeapObjects,
+			StackInuse:  mem.StackInuse,
+			Sys:         mem.Sys,
+		},
+		GC: RuntimeGCStats{
+			NumGC:        mem.NumGC,
+			Forced:       mem.NumForcedGC,
+			PauseTotalMs: float64(mem.PauseTotalNs) / 1e6,
+			NextGCBytes:  mem.NextGC,
+			CPUFraction:  mem.GCCPUFraction,
+		},
+		Storage: storageBreakerStats(h.storageBreaker),
+	}
+	if mem.NumGC > 0 {
+		stats.GC.LastPauseMs = float64(mem.PauseNs[(mem.NumGC+255)%256]) / 1e6
+		lastGC := time.Unix(0, int64(mem.LastGC)) // #nosec G115 -- nanoseconds since 1970 fit in int64
+		stats.GC.LastGC = &lastGC
+	}
+	return c.JSON(http.StatusOK, stats)
+}
+
+// Pprof serves the net/http/pprof index and profiles under /debug/pprof/ to
+// administrators, so a production server can be profiled without a restart
+func (h *AdminHandler) Pprof(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+
+	name := c.Param("*")
+	w, r := c.Response(), c.Request()
+	switch name {
+	case "cmdline":
+		pprof.Cmdline(w, r)
+	case "profile":
+		log.Printf("Admin %s started a CPU profile", actor)
+		pprof.Profile(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	case "trace":
+		log.Printf("Admin %s started an execution trace", actor)
+		pprof.Trace(w, r)
+	default:
+		// Index serves the named profiles (heap, goroutine, ...) from the path
+		pprof.Index(w, r)
+	}
+	return nil
+}
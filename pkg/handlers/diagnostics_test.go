@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+)
+
+func TestDiagnostics(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+	admin := NewAdminHandler(store, h.config, nil)
+	adminToken, err := crypto.GenerateAdminToken("oncall", admin.adminSecret)
+	require.NoError(t, err)
+
+	e := echo.New()
+	e.GET("/api/admin/runtime", admin.GetRuntimeStats)
+	e.GET("/debug/pprof/*", admin.Pprof)
+	get := func(path, bearer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, get("/api/admin/runtime", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, get("/debug/pprof/heap", "").Code)
+
+	rec := get("/api/admin/runtime", adminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var stats RuntimeStats
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+	assert.Positive(t, stats.Goroutines)
+	assert.Positive(t, stats.Memory.HeapAlloc)
+	assert.Contains(t, stats.Storage, "requests_in_flight")
+
+	rec = get("/debug/pprof/", adminToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "goroutine")
+
+	rec = get("/debug/pprof/goroutine?debug=1", adminToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "goroutine profile:")
+}
@@ -5,7 +5,9 @@ import (
 
 	"github.com/labstack/echo/v4"
 
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
 	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
 )
 
 // DiscoveryResponse represents OpenID Connect Discovery response
@@ -33,14 +35,20 @@ type DiscoveryResponse struct {
 	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
 
 	// RECOMMENDED - Additional capabilities
-	ScopesSupported                           []string `json:"scopes_supported,omitempty"`
-	ResponseModesSupported                    []string `json:"response_modes_supported,omitempty"`
-	GrantTypesSupported                       []string `json:"grant_types_supported,omitempty"`
-	TokenEndpointAuthMethodsSupported         []string `json:"token_endpoint_auth_methods_supported,omitempty"`
-	RevocationEndpointAuthMethodsSupported    []string `json:"revocation_endpoint_auth_methods_supported,omitempty"`    // RFC 7009
-	IntrospectionEndpointAuthMethodsSupported []string `json:"introspection_endpoint_auth_methods_supported,omitempty"` // RFC 7662
-	ClaimsSupported                           []string `json:"claims_supported,omitempty"`
-	CodeChallengeMethodsSupported             []string `json:"code_challenge_methods_supported,omitempty"`
+	ScopesSupported                            []string `json:"scopes_supported,omitempty"`
+	ResponseModesSupported                     []string `json:"response_modes_supported,omitempty"`
+	GrantTypesSupported                        []string `json:"grant_types_supported,omitempty"`
+	TokenEndpointAuthMethodsSupported          []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	TokenEndpointAuthSigningAlgValuesSupported []string `json:"token_endpoint_auth_signing_alg_values_supported,omitempty"`
+	RevocationEndpointAuthMethodsSupported     []string `json:"revocation_endpoint_auth_methods_supported,omitempty"`    // RFC 7009
+	IntrospectionEndpointAuthMethodsSupported  []string `json:"introspection_endpoint_auth_methods_supported,omitempty"` // RFC 7662
+	IntrospectionSigningAlgValuesSupported     []string `json:"introspection_signing_alg_values_supported,omitempty"`    // RFC 9701
+	ClaimsSupported                            []string `json:"claims_supported,omitempty"`
+	ClaimTypesSupported                        []string `json:"claim_types_supported,omitempty"`
+	CodeChallengeMethodsSupported              []string `json:"code_challenge_methods_supported,omitempty"`
+
+	// OPTIONAL - Login and consent page layouts
+	DisplayValuesSupported []string `json:"display_values_supported,omitempty"`
 
 	// OPTIONAL - Localization support
 	UILocalesSupported     []string `json:"ui_locales_supported,omitempty"`
@@ -51,10 +59,25 @@ type DiscoveryResponse struct {
 	RequestParameterSupported     bool `json:"request_parameter_supported,omitempty"`
 	RequestURIParameterSupported  bool `json:"request_uri_parameter_supported,omitempty"`
 	RequireRequestURIRegistration bool `json:"require_request_uri_registration,omitempty"`
+
+	AuthorizationResponseIssParameterSupported bool `json:"authorization_response_iss_parameter_supported,omitempty"` // RFC 9207
+
+	RequestObjectSigningAlgValuesSupported    []string `json:"request_object_signing_alg_values_supported,omitempty"`
+	RequestObjectEncryptionAlgValuesSupported []string `json:"request_object_encryption_alg_values_supported,omitempty"`
+	RequestObjectEncryptionEncValuesSupported []string `json:"request_object_encryption_enc_values_supported,omitempty"`
+
+	// Experimental features, advertised only when their feature flag is enabled
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint,omitempty"` // RFC 8628
 }
 
 // Discovery handles the OpenID Connect Discovery endpoint
 func (h *Handlers) Discovery(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.discoveryMetadata())
+}
+
+// discoveryMetadata describes the provider, for discovery and for its federation
+// entity configuration
+func (h *Handlers) discoveryMetadata() DiscoveryResponse {
 	baseURL := h.config.Issuer
 
 	response := DiscoveryResponse{
@@ -78,16 +101,9 @@ func (h *Handlers) Discovery(c echo.Context) error {
 		SubjectTypesSupported: []string{
 			"public",
 		},
-		IDTokenSigningAlgValuesSupported: []string{
-			"RS256",
-		},
+		IDTokenSigningAlgValuesSupported: idTokenSigningAlgs,
 
 		// RECOMMENDED - Additional capabilities
-		ScopesSupported: []string{
-			"openid",
-			"profile",
-			"email",
-		},
 		ResponseModesSupported: []string{
 			"query",
 			"fragment",
@@ -97,64 +113,86 @@ func (h *Handlers) Discovery(c echo.Context) error {
 			"refresh_token",
 			"client_credentials",
 			"password",
+			GrantTypeAPIKey,
 		},
-		TokenEndpointAuthMethodsSupported: []string{
-			"client_secret_basic",
-			"client_secret_post",
-		},
-		RevocationEndpointAuthMethodsSupported: []string{
-			"client_secret_basic",
-			"client_secret_post",
-		},
-		IntrospectionEndpointAuthMethodsSupported: []string{
-			"client_secret_basic",
-			"client_secret_post",
-		},
-		ClaimsSupported: []string{
-			"sub",
-			"iss",
-			"aud",
-			"exp",
-			"iat",
-			"auth_time",
-			"acr",
-			"amr",
-			"nonce",
-			"name",
-			"given_name",
-			"family_name",
-			"email",
-			"picture",
+		// Client authentication as implemented by each endpoint
+		TokenEndpointAuthMethodsSupported:          tokenEndpointAuthMethods,
+		TokenEndpointAuthSigningAlgValuesSupported: clientAssertionSigningAlgs,
+		RevocationEndpointAuthMethodsSupported:     clientSecretAuthMethods,
+		IntrospectionEndpointAuthMethodsSupported:  clientSecretAuthMethods,
+		IntrospectionSigningAlgValuesSupported:     introspectionSigningAlgs,
+		// Only normal claims are issued; none are aggregated or distributed
+		ClaimTypesSupported: []string{
+			"normal",
 		},
 		CodeChallengeMethodsSupported: []string{
 			"plain",
 			"S256",
 		},
+		DisplayValuesSupported: displayValues,
+
+		// OPTIONAL - Authorization responses carry iss (RFC 9207)
+		AuthorizationResponseIssParameterSupported: true,
+
+		// OPTIONAL - Request objects, passed by value and optionally encrypted
+		RequestParameterSupported:                 true,
+		RequestObjectSigningAlgValuesSupported:    requestObjectSigningAlgs,
+		RequestObjectEncryptionAlgValuesSupported: crypto.SupportedJWEAlgorithms,
+		RequestObjectEncryptionEncValuesSupported: crypto.SupportedJWEEncryptions,
 
 		// OPTIONAL - Currently not supported
 		ClaimsParameterSupported:      false,
-		RequestParameterSupported:     false,
 		RequestURIParameterSupported:  false,
 		RequireRequestURIRegistration: false,
 	}
 
+	// Scopes and claims follow the configured attribute providers and resource servers
+	response.ScopesSupported, response.ClaimsSupported = h.supportedScopesAndClaims()
+
 	// Add dynamic registration endpoint if enabled
-	if h.config.Registration.Enabled {
-		response.RegistrationEndpoint = baseURL + h.config.Registration.Endpoint
+	if endpoint, enabled := h.registrationURL(baseURL); enabled {
+		response.RegistrationEndpoint = endpoint
 	}
 
+	if len(h.config.SAMLIssuersState()) > 0 {
+		response.GrantTypesSupported = append(response.GrantTypesSupported, GrantTypeSAML2Bearer)
+	}
+
+	// Advertise experimental capabilities only when their flag is on
+	if h.config.FeatureEnabled(configstore.FeatureDeviceFlow) {
+		response.DeviceAuthorizationEndpoint = baseURL + "/device_authorization"
+		response.GrantTypesSupported = append(response.GrantTypesSupported, GrantTypeDeviceCode)
+	}
+
+	// Leave out the grant and response types turned off server-wide
+	grants := h.config.GrantsState()
+	response.GrantTypesSupported = filterStrings(response.GrantTypesSupported, grants.GrantTypeEnabled)
+	response.ResponseTypesSupported = filterStrings(response.ResponseTypesSupported, grants.ResponseTypeEnabled)
+
 	// Add documentation URIs if configured
-	if h.config.Registration.ServiceDocumentation != "" {
-		response.ServiceDocumentation = h.config.Registration.ServiceDocumentation
+	registration := h.config.RegistrationState()
+	if registration.ServiceDocumentation != "" {
+		response.ServiceDocumentation = registration.ServiceDocumentation
 	}
-	if h.config.Registration.PolicyURI != "" {
-		response.OPPolicyURI = h.config.Registration.PolicyURI
+	if registration.PolicyURI != "" {
+		response.OPPolicyURI = registration.PolicyURI
 	}
-	if h.config.Registration.TosURI != "" {
-		response.OPTosURI = h.config.Registration.TosURI
+	if registration.TosURI != "" {
+		response.OPTosURI = registration.TosURI
 	}
 
-	return c.JSON(http.StatusOK, response)
+	return response
+}
+
+// filterStrings returns the values for which keep reports true
+func filterStrings(values []string, keep func(string) bool) []string {
+	kept := make([]string, 0, len(values))
+	for _, v := range values {
+		if keep(v) {
+			kept = append(kept, v)
+		}
+	}
+	return kept
 }
 
 // JWKS handles the JWKS endpoint.
@@ -178,16 +216,38 @@ func (h *Handlers) JWKS(c echo.Context) error {
 		return c.Blob(http.StatusOK, "application/json", jwksJSON)
 	}
 
+	jwks, encryptionKeys := h.publishedKeys(keys)
+
+	// Publish the request object encryption keys alongside the signing keys,
+	// falling back to the signing key pair until a dedicated key exists
+	if len(encryptionKeys) == 0 {
+		encryptionKeys = append(encryptionKeys, crypto.EncryptionPublicKeyToJWK(h.jwtManager.GetEncryptionPublicKey(), crypto.EncryptionKeyID))
+	}
+	jwks.Keys = append(jwks.Keys, encryptionKeys...)
+
+	c.Response().Header().Set("Cache-Control", "public, max-age=3600")
+	jwksJSON, _ := crypto.MarshalJWKS(jwks)
+	return c.Blob(http.StatusOK, "application/json", jwksJSON)
+}
+
+// publishedKeys returns the public keys of the stored signing keys that are active
+// or not yet expired, falling back to the JWTManager key, and the encryption keys
+func (h *Handlers) publishedKeys(keys []*models.SigningKey) (*crypto.JWKS, []crypto.JWK) {
 	jwks := &crypto.JWKS{Keys: []crypto.JWK{}}
+	var encryptionKeys []crypto.JWK
 	for _, key := range keys {
-		// Include active keys AND inactive keys that have not yet expired
-		if !key.IsActive && key.IsExpired() {
+		// Include active keys AND inactive keys that have not yet expired; cookie keys are secret
+		if (!key.IsActive && key.IsExpired()) || key.IsCookieKey() {
 			continue
 		}
 		pk, parseErr := crypto.ParsePublicKeyFromPEM(key.PublicKey)
 		if parseErr != nil {
 			continue
 		}
+		if key.IsEncryptionKey() {
+			encryptionKeys = append(encryptionKeys, crypto.EncryptionPublicKeyToJWK(pk, key.KID))
+			continue
+		}
 		jwk, jwkErr := crypto.PublicKeyToJWKWithCert(pk, key.KID, key.CertPEM)
 		if jwkErr != nil {
 			continue
@@ -203,8 +263,5 @@ func (h *Handlers) JWKS(c echo.Context) error {
 			jwks = fallback
 		}
 	}
-
-	c.Response().Header().Set("Cache-Control", "public, max-age=3600")
-	jwksJSON, _ := crypto.MarshalJWKS(jwks)
-	return c.Blob(http.StatusOK, "application/json", jwksJSON)
+	return jwks, encryptionKeys
 }
@@ -4,10 +4,13 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
 
 	"github.com/labstack/echo/v4"
 	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -67,10 +70,20 @@ func TestDiscovery_WithoutRegistration(t *testing.T) {
 	assert.Contains(t, response.CodeChallengeMethodsSupported, "S256")
 	assert.Contains(t, response.CodeChallengeMethodsSupported, "plain")
 
+	// Client authentication follows what each endpoint accepts
+	assert.Equal(t, tokenEndpointAuthMethods, response.TokenEndpointAuthMethodsSupported)
+	assert.Contains(t, response.TokenEndpointAuthMethodsSupported, "private_key_jwt")
+	assert.Contains(t, response.TokenEndpointAuthSigningAlgValuesSupported, "ES256")
+	assert.NotContains(t, response.TokenEndpointAuthSigningAlgValuesSupported, "none")
+	assert.NotContains(t, response.RevocationEndpointAuthMethodsSupported, "private_key_jwt")
+	assert.NotContains(t, response.IntrospectionEndpointAuthMethodsSupported, "client_secret_jwt")
+
 	// Verify advanced features flags
 	assert.False(t, response.ClaimsParameterSupported)
-	assert.False(t, response.RequestParameterSupported)
+	assert.True(t, response.RequestParameterSupported)
 	assert.False(t, response.RequestURIParameterSupported)
+	assert.Contains(t, response.RequestObjectEncryptionAlgValuesSupported, "RSA-OAEP-256")
+	assert.True(t, response.AuthorizationResponseIssParameterSupported)
 }
 
 func TestDiscovery_WithRegistrationEnabled(t *testing.T) {
@@ -186,3 +199,83 @@ func TestDiscovery_JSONStructure(t *testing.T) {
 	assert.NotNil(t, jsonMap["subject_types_supported"])
 	assert.NotNil(t, jsonMap["id_token_signing_alg_values_supported"])
 }
+
+func TestDiscovery_FeatureFlags(t *testing.T) {
+	cfg := &configstore.ConfigData{Issuer: "https://example.com"}
+	handlers := &Handlers{config: cfg}
+
+	discover := func() DiscoveryResponse {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil), rec)
+		assert.NoError(t, handlers.Discovery(c))
+
+		var response DiscoveryResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		return response
+	}
+
+	// Experimental capabilities are hidden by default
+	response := discover()
+	assert.Empty(t, response.DeviceAuthorizationEndpoint)
+	assert.NotContains(t, response.GrantTypesSupported, GrantTypeDeviceCode)
+
+	cfg.SetFeature(configstore.FeatureDeviceFlow, true)
+
+	response = discover()
+	assert.Equal(t, "https://example.com/device_authorization", response.DeviceAuthorizationEndpoint)
+	assert.Contains(t, response.GrantTypesSupported, GrantTypeDeviceCode)
+}
+
+func TestDiscovery_ScopesAndClaimsFromRegistry(t *testing.T) {
+	store, err := storage.NewJSONStorage(filepath.Join(t.TempDir(), "test-storage.json"))
+	assert.NoError(t, err)
+	assert.NoError(t, store.CreateClient(&models.Client{
+		ID:             "orders-api",
+		ResourceServer: true,
+		ResourceScopes: []string{"orders:read", "orders:write"},
+	}))
+	assert.NoError(t, store.CreateClient(&models.Client{
+		ID:             "retired-api",
+		ResourceServer: true,
+		ResourceScopes: []string{"legacy:read"},
+		Disabled:       true,
+	}))
+
+	cfg := &configstore.ConfigData{
+		Issuer: "https://example.com",
+		AttributeProviders: []configstore.AttributeProviderConfig{
+			{Name: "ldap", URL: "https://ldap-bridge.example.com", Scope: "groups", Claims: []string{"groups", "department"}},
+		},
+	}
+	handlers := &Handlers{config: cfg, storage: store}
+
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil), rec)
+	assert.NoError(t, handlers.Discovery(c))
+
+	var response DiscoveryResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+
+	assert.Equal(t, []string{"address", "email", "groups", "openid", "orders:read", "orders:write", "profile"}, response.ScopesSupported)
+	assert.Contains(t, response.ClaimsSupported, "email_verified")
+	assert.Contains(t, response.ClaimsSupported, "groups")
+	assert.Contains(t, response.ClaimsSupported, "department")
+	assert.Contains(t, response.ClaimsSupported, "auth_time")
+	assert.Equal(t, []string{"normal"}, response.ClaimTypesSupported)
+}
+
+func TestDiscovery_DisabledGrants(t *testing.T) {
+	cfg := &configstore.ConfigData{Issuer: "https://example.com", Grants: configstore.GrantsConfig{OAuth21: true}}
+	handlers := &Handlers{config: cfg}
+
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil), rec)
+	assert.NoError(t, handlers.Discovery(c))
+
+	var response DiscoveryResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, []string{ResponseTypeCode}, response.ResponseTypesSupported)
+	assert.NotContains(t, response.GrantTypesSupported, "password")
+	assert.Contains(t, response.GrantTypesSupported, "authorization_code")
+	assert.Contains(t, response.GrantTypesSupported, "client_credentials")
+}
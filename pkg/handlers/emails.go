@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+// canonicalEmail returns the form of email that is stored and compared
+func canonicalEmail(cfg *configstore.ConfigData, email string) string {
+	return models.NormalizeEmail(email, cfg.EmailNormalizationState().StripPlusTag)
+}
+
+// emailTakenByOther reports whether a user other than userID already has email,
+// compared in canonical form
+func emailTakenByOther(store storage.Storage, email, userID string) (bool, error) {
+	if email == "" {
+		return false, nil
+	}
+	other, err := store.GetUserByEmail(email)
+	if err != nil {
+		return false, err
+	}
+	return other != nil && other.ID != userID, nil
+}
+
+// resolveLoginUser finds the user a sign-in identifier refers to, trying the
+// configured login identifier fields in order. With plus tags stripped from
+// stored addresses, an address entered with its tag still matches.
+func resolveLoginUser(store storage.Storage, cfg *configstore.ConfigData, identifier string) (*models.User, error) {
+	fields := cfg.LoginIdentifierFields()
+	user, err := store.ResolveUserByLoginIdentifier(identifier, fields)
+	if err != nil || user != nil {
+		return user, err
+	}
+	email := canonicalEmail(cfg, identifier)
+	if !strings.Contains(email, "@") || strings.EqualFold(email, strings.TrimSpace(identifier)) {
+		return nil, nil
+	}
+	for _, field := range fields {
+		if field == configstore.LoginIdentifierEmail {
+			return store.ResolveUserByLoginIdentifier(email, []string{field})
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestAdminUsersHaveCanonicalUniqueEmails(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+	h.config.EmailNormalization.StripPlusTag = true
+	admin := NewAdminHandler(store, h.config, nil)
+	adminToken, err := crypto.GenerateAdminToken("admin", admin.adminSecret)
+	require.NoError(t, err)
+
+	call := func(handler echo.HandlerFunc, method, body string, id ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/users", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+adminToken)
+		req.Header.Set("If-Match", "*")
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		if len(id) > 0 {
+			c.SetParamNames("id")
+			c.SetParamValues(id[0])
+		}
+		require.NoError(t, handler(c))
+		return rec
+	}
+
+	rec := call(admin.CreateUser, http.MethodPost, `{"username": "erin", "email": " Erin+Work@Example.com ", "password": "secret123"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var erin models.User
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &erin))
+	stored, err := store.GetUserByID(erin.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "erin@example.com", stored.Email)
+
+	// The same address in another case or with another tag is taken
+	rec = call(admin.CreateUser, http.MethodPost, `{"username": "erin2", "email": "ERIN+home@example.com", "password": "secret123"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code, rec.Body.String())
+	rec = call(admin.CreateServiceAccount, http.MethodPost, `{"username": "erin-bot", "email": "Erin@example.com"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code, rec.Body.String())
+
+	rec = call(admin.CreateUser, http.MethodPost, `{"username": "frank", "email": "frank@example.com", "password": "secret123"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var frank models.User
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &frank))
+	rec = call(admin.UpdateUser, http.MethodPut, `{"username": "frank", "email": "Erin@Example.com"}`, frank.ID)
+	assert.Equal(t, http.StatusConflict, rec.Code, rec.Body.String())
+	rec = call(admin.UpdateUser, http.MethodPut, `{"username": "frank", "email": "FRANK+x@example.com"}`, frank.ID)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// Signing in with the tagged, differently cased address finds the user
+	user, err := h.userByLoginIdentifier("Erin+Anything@EXAMPLE.com")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	assert.Equal(t, erin.ID, user.ID)
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+const (
+	defaultEncryptionKeyRotationDays = 90
+	// encryptionKeyGracePeriod keeps a replaced key usable while clients refresh cached JWKS
+	encryptionKeyGracePeriod = 7 * 24 * time.Hour
+)
+
+// RotateEncryptionKey generates a new request object encryption key and retires the
+// current one. Retired keys stay published and keep decrypting for a grace period.
+func RotateEncryptionKey(store storage.Storage) (*models.SigningKey, error) {
+	km, err := crypto.GenerateEncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+
+	newKey := &models.SigningKey{
+		ID:         uuid.New().String(),
+		KID:        km.KID,
+		Algorithm:  crypto.JWEAlgRSAOAEP256,
+		PrivateKey: km.PrivateKeyPEM,
+		PublicKey:  km.PublicKeyPEM,
+		IsActive:   true,
+		CreatedAt:  time.Now(),
+		Use:        models.KeyUseEncryption,
+	}
+
+	// Retire the old key and publish the new one together, so there is never zero or two active keys
+	err = store.RunInTransaction(func(tx storage.Storage) error {
+		keys, err := tx.GetAllSigningKeys()
+		if err != nil {
+			return fmt.Errorf("failed to get existing keys: %w", err)
+		}
+		for _, key := range keys {
+			if key.IsEncryptionKey() && key.IsActive {
+				key.IsActive = false
+				key.ExpiresAt = time.Now().Add(encryptionKeyGracePeriod)
+				if err := tx.UpdateSigningKey(key); err != nil {
+					return fmt.Errorf("failed to retire encryption key: %w", err)
+				}
+			}
+		}
+		if err := tx.CreateSigningKey(newKey); err != nil {
+			return fmt.Errorf("failed to create encryption key: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newKey, nil
+}
+
+// activeEncryptionKey returns the encryption key new request objects should use
+func activeEncryptionKey(keys []*models.SigningKey) *models.SigningKey {
+	for _, key := range keys {
+		if key.IsEncryptionKey() && key.IsActive {
+			return key
+		}
+	}
+	return nil
+}
+
+// EnsureEncryptionKey creates an encryption key if none is active
+func (h *Handlers) EnsureEncryptionKey() error {
+	keys, err := h.storage.GetAllSigningKeys()
+	if err != nil {
+		return err
+	}
+	if activeEncryptionKey(keys) != nil {
+		return nil
+	}
+	_, err = RotateEncryptionKey(h.storage)
+	return err
+}
+
+// rotateEncryptionKeyIfDue replaces the active encryption key once it reaches the
+// configured rotation age. It reports whether a new key was generated.
+func (h *Handlers) rotateEncryptionKeyIfDue() (bool, error) {
+	rotationDays := h.config.JWTState().EncryptionKeyRotationDays
+	if rotationDays <= 0 {
+		rotationDays = defaultEncryptionKeyRotationDays
+	}
+
+	keys, err := h.storage.GetAllSigningKeys()
+	if err != nil {
+		return false, err
+	}
+	if active := activeEncryptionKey(keys); active != nil &&
+		time.Since(active.CreatedAt) < time.Duration(rotationDays)*24*time.Hour {
+		return false, nil
+	}
+
+	if _, err := RotateEncryptionKey(h.storage); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// StartEncryptionKeyRotation periodically rotates the encryption key when it is due
+func (h *Handlers) StartEncryptionKeyRotation(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if rotated, err := h.rotateEncryptionKeyIfDue(); err != nil {
+				log.Printf("Warning: Failed to rotate encryption key: %v", err)
+			} else if rotated {
+				log.Println("Rotated request object encryption key")
+			}
+		}
+	}()
+}
+
+// decryptJWE decrypts a JWE addressed to one of the server's encryption keys. The
+// header kid selects the key; without one, every usable key is tried. Until a
+// dedicated encryption key exists the signing key pair is used instead.
+func (h *Handlers) decryptJWE(token string) ([]byte, error) {
+	header, err := crypto.ParseJWEHeader(token)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := h.storage.GetAllSigningKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load encryption keys")
+	}
+
+	found := false
+	for _, key := range keys {
+		if !key.IsEncryptionKey() {
+			continue
+		}
+		found = true
+		if !key.IsValid() || (header.Kid != "" && header.Kid != key.KID) {
+			continue
+		}
+		privateKey, parseErr := crypto.ParsePrivateKeyFromPEM(key.PrivateKey)
+		if parseErr != nil {
+			continue
+		}
+		if plaintext, decErr := crypto.DecryptJWE(token, privateKey); decErr == nil {
+			return plaintext, nil
+		}
+	}
+
+	if !found {
+		return h.jwtManager.DecryptJWE(token)
+	}
+	return nil, fmt.Errorf("no encryption key could decrypt the request object")
+}
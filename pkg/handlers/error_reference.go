@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+const (
+	// errorsPath is where the hosted page for an error reference is served
+	errorsPath = "/errors"
+
+	// errorReporterKey marks requests whose error responses carry a reference
+	errorReporterKey = "error_reporter"
+
+	// errorReportTTL is how long support can look up a reference
+	errorReportTTL = 30 * 24 * time.Hour
+
+	// maxErrorReportsPerIP bounds the reports stored for one address per
+	// errorReportWindow; further errors are still logged with a reference
+	maxErrorReportsPerIP = 30
+	errorReportWindow    = time.Minute
+)
+
+// referencedErrors are the error codes that get a reference below status 500:
+// security rejections, and server errors sent to the client by redirect
+var referencedErrors = map[string]bool{
+	ErrorInvalidClient:          true,
+	ErrorUnauthorizedClient:     true,
+	ErrorInvalidGrant:           true,
+	ErrorInvalidToken:           true,
+	ErrorInvalidRequestObject:   true,
+	ErrorServerError:            true,
+	ErrorTemporarilyUnavailable: true,
+}
+
+var errorReferenceEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ErrorReferences returns middleware that gives every 5xx and security rejection
+// on the request an error reference. The reference is logged with the request's
+// context, stored for the admin API, and sent to the client as error_uri.
+func (h *Handlers) ErrorReferences() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(errorReporterKey, h)
+			return next(c)
+		}
+	}
+}
+
+// errorURI reports the error when it needs a reference and returns its error_uri,
+// or "" when it does not or the request did not pass through ErrorReferences
+func errorURI(c echo.Context, status int, errorCode, errorDescription string) string {
+	h, ok := c.Get(errorReporterKey).(*Handlers)
+	if !ok || (status < http.StatusInternalServerError && !referencedErrors[errorCode]) {
+		return ""
+	}
+	reference, err := newErrorReference()
+	if err != nil {
+		log.Printf("Failed to generate error reference: %v", err)
+		return ""
+	}
+
+	req := c.Request()
+	requestID := req.Header.Get(echo.HeaderXRequestID)
+	if requestID == "" {
+		requestID = c.Response().Header().Get(echo.HeaderXRequestID)
+	}
+	now := time.Now()
+	report := &models.ErrorReport{
+		Reference:        reference,
+		RequestID:        requestID,
+		Status:           status,
+		Error:            errorCode,
+		ErrorDescription: errorDescription,
+		Method:           req.Method,
+		Path:             req.URL.Path,
+		ClientID:         maintenanceClientID(c),
+		RemoteIP:         c.RealIP(),
+		UserAgent:        req.UserAgent(),
+		ExpiresAt:        now.Add(errorReportTTL),
+		CreatedAt:        now,
+	}
+	log.Printf(`{"error_ref":%q,"request_id":%q,"status":%d,"error":%q,"error_description":%q,"method":%q,"path":%q,"client_id":%q,"remote_ip":%q,"user_agent":%q}`,
+		report.Reference, report.RequestID, report.Status, report.Error, report.ErrorDescription,
+		report.Method, report.Path, report.ClientID, report.RemoteIP, report.UserAgent)
+
+	// The log line is enough while the database is down or one address floods us with errors
+	if !h.storageBreaker.Open() && h.errorReports.Allow(report.RemoteIP, maxErrorReportsPerIP) {
+		if err := h.storage.CreateErrorReport(report); err != nil {
+			log.Printf("Failed to store error report %s: %v", reference, err)
+		}
+	}
+	return h.config.Issuer + errorsPath + "/" + reference
+}
+
+// newErrorReference returns a short reference that is easy to read out over the phone
+func newErrorReference() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	ref := errorReferenceEncoding.EncodeToString(b)
+	return fmt.Sprintf("%s-%s-%s-%s", ref[0:4], ref[4:8], ref[8:12], ref[12:16]), nil
+}
+
+// ErrorDetail serves the page that error_uri points to (GET /errors/:reference).
+// Only the reference, the error code and the time are shown; the rest of the
+// report is for administrators, through the admin API.
+func (h *Handlers) ErrorDetail(c echo.Context) error {
+	brand, locale := h.pageBrandAndLocale(c, nil)
+	data := struct {
+		BasePath  string
+		Brand     pageBrand
+		Locale    string
+		Reference string
+		Error     string
+		Time      string
+		Found     bool
+	}{
+		BasePath:  h.config.BasePath(),
+		Brand:     brand,
+		Locale:    locale,
+		Reference: strings.ToUpper(strings.TrimSpace(c.Param("reference"))),
+	}
+	if report, err := h.storage.GetErrorReport(data.Reference); err == nil && report != nil {
+		data.Found = true
+		data.Error = report.Error
+		data.Time = report.CreatedAt.UTC().Format("2006-01-02 15:04:05 MST")
+	}
+
+	c.Response().Header().Set("Content-Type", "text/html; charset=utf-8")
+	c.Response().Header().Set("Cache-Control", "no-store")
+	status := http.StatusOK
+	if !data.Found {
+		status = http.StatusNotFound
+	}
+	c.Response().WriteHeader(status)
+	return h.errorTmpl.Execute(c.Response().Writer, data)
+}
+
+// GetErrorReport returns the full report behind an error reference
+// (GET /api/admin/errors/:reference)
+func (h *AdminHandler) GetErrorReport(c echo.Context) error {
+	if _, ok := h.authenticatedAdmin(c); !ok {
+		return nil
+	}
+	report, err := h.store.GetErrorReport(strings.ToUpper(strings.TrimSpace(c.Param("reference"))))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get error report"})
+	}
+	if report == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Error report not found"})
+	}
+	return c.JSON(http.StatusOK, report)
+}
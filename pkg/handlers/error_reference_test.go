@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestErrorReferences(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	e := echo.New()
+
+	token := func(form url.Values) ErrorResponse {
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		req.Header.Set(echo.HeaderXRequestID, "req-123")
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.ErrorReferences()(h.Token)(e.NewContext(req, rec)))
+		var resp ErrorResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp
+	}
+
+	// Plain request errors carry no reference
+	resp := token(url.Values{"grant_type": {"password_please"}, "client_id": {client.ID}, "client_secret": {client.Secret}})
+	assert.NotEmpty(t, resp.Error)
+	assert.Empty(t, resp.ErrorURI)
+
+	// A failed client authentication does, with the request's context stored behind it
+	resp = token(url.Values{"grant_type": {GrantTypeClientCredentials}, "client_id": {client.ID},
+		"client_assertion_type": {ClientAssertionTypeJWTBearer}, "client_assertion": {"not-a-jwt"}})
+	assert.Equal(t, ErrorInvalidClient, resp.Error)
+	require.True(t, strings.HasPrefix(resp.ErrorURI, "https://example.com/errors/"), resp.ErrorURI)
+	reference := strings.TrimPrefix(resp.ErrorURI, "https://example.com/errors/")
+	assert.Regexp(t, `^[A-Z2-7]{4}-[A-Z2-7]{4}-[A-Z2-7]{4}-[A-Z2-7]{4}$`, reference)
+
+	report, err := store.GetErrorReport(reference)
+	require.NoError(t, err)
+	require.NotNil(t, report)
+	assert.Equal(t, "req-123", report.RequestID)
+	assert.Equal(t, client.ID, report.ClientID)
+	assert.Equal(t, http.StatusUnauthorized, report.Status)
+
+	// A wrong client secret is a failed client authentication too
+	resp = token(url.Values{"grant_type": {GrantTypeClientCredentials}, "client_id": {client.ID}, "client_secret": {"wrong"}})
+	assert.Equal(t, ErrorInvalidClient, resp.Error)
+	assert.NotEmpty(t, resp.ErrorURI)
+
+	// The hosted page shows the reference but not the details
+	req := httptest.NewRequest(http.MethodGet, "/errors/"+strings.ToLower(reference), nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("reference")
+	c.SetParamValues(strings.ToLower(reference))
+	require.NoError(t, h.ErrorDetail(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), reference)
+	assert.Contains(t, rec.Body.String(), ErrorInvalidClient)
+	assert.NotContains(t, rec.Body.String(), client.ID)
+
+	// Administrators get the full report
+	admin := NewAdminHandler(store, h.config, nil)
+	adminToken, err := crypto.GenerateAdminToken("support", admin.adminSecret)
+	require.NoError(t, err)
+	getReport := func(bearer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/errors/"+reference, nil)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("reference")
+		c.SetParamValues(reference)
+		require.NoError(t, admin.GetErrorReport(c))
+		return rec
+	}
+	assert.Equal(t, http.StatusUnauthorized, getReport("").Code)
+	rec = getReport(adminToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var full models.ErrorReport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &full))
+	assert.Equal(t, client.ID, full.ClientID)
+	assert.Equal(t, "/token", full.Path)
+}
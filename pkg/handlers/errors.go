@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 )
@@ -30,6 +31,8 @@ const (
 	ErrorRequestNotSupported      = "request_not_supported"
 	ErrorRequestURINotSupported   = "request_uri_not_supported"
 	ErrorRegistrationNotSupported = "registration_not_supported"
+	// ErrorUnmetAuthenticationRequirements is from OpenID Connect Core Unmet Authentication Requirements 1.0
+	ErrorUnmetAuthenticationRequirements = "unmet_authentication_requirements"
 )
 
 // Token Endpoint Error Codes (RFC 6749 Section 5.2)
@@ -53,13 +56,15 @@ type ErrorResponse struct {
 }
 
 // redirectWithError redirects to the client's redirect_uri with error parameters
-// Used for authorization endpoint errors
-func redirectWithError(c echo.Context, redirectURI, errorCode, errorDescription, state string, useFragment bool) error {
+// Used for authorization endpoint errors. A non-empty issuer is sent as the iss
+// parameter so clients can detect mix-up attacks (RFC 9207).
+func redirectWithError(c echo.Context, redirectURI, errorCode, errorDescription, state, issuer string, useFragment bool) error {
 	if redirectURI == "" {
 		// If no valid redirect_uri, return JSON error instead
 		return c.JSON(http.StatusBadRequest, ErrorResponse{
 			Error:            errorCode,
 			ErrorDescription: errorDescription,
+			ErrorURI:         errorURI(c, http.StatusBadRequest, errorCode, errorDescription),
 		})
 	}
 
@@ -77,9 +82,15 @@ func redirectWithError(c echo.Context, redirectURI, errorCode, errorDescription,
 	if errorDescription != "" {
 		params.Set("error_description", errorDescription)
 	}
+	if uri := errorURI(c, http.StatusFound, errorCode, errorDescription); uri != "" {
+		params.Set("error_uri", uri)
+	}
 	if state != "" {
 		params.Set("state", state)
 	}
+	if issuer != "" {
+		params.Set("iss", issuer)
+	}
 
 	if useFragment {
 		// For implicit/hybrid flows - use fragment
@@ -102,32 +113,29 @@ func jsonError(c echo.Context, statusCode int, errorCode, errorDescription strin
 	return c.JSON(statusCode, ErrorResponse{
 		Error:            errorCode,
 		ErrorDescription: errorDescription,
+		ErrorURI:         errorURI(c, statusCode, errorCode, errorDescription),
 	})
 }
 
 // determineErrorRedirectMethod determines whether to use query or fragment for error redirect
-// based on response_type
+// based on response_type. Per OAuth 2.0 Multiple Response Type Encoding Practices §5,
+// any response type that returns a token or id_token from the authorization endpoint
+// uses the fragment, regardless of the order in which the values were sent
+// (e.g. both "id_token token" and "token id_token").
 func determineErrorRedirectMethod(responseType string) bool {
-	// Use fragment for implicit and hybrid flows
-	// These response types return tokens/id_tokens in fragment
-	switch responseType {
-	case ResponseTypeIDToken,
-		ResponseTypeToken,
-		ResponseTypeTokenIDToken,
-		ResponseTypeCodeIDToken,
-		ResponseTypeCodeToken,
-		ResponseTypeCodeTokenIDToken:
-		return true // use fragment
-	default:
-		return false // use query (code flow)
+	for _, rt := range strings.Fields(responseType) {
+		if rt == ResponseTypeToken || rt == ResponseTypeIDToken {
+			return true // use fragment
+		}
 	}
+	return false // use query (code flow)
 }
 
 // authorizationError is a convenience function for authorization endpoint errors
 // Automatically determines whether to use query or fragment
-func authorizationError(c echo.Context, redirectURI, responseType, errorCode, errorDescription, state string) error {
+func (h *Handlers) authorizationError(c echo.Context, redirectURI, responseType, errorCode, errorDescription, state string) error {
 	useFragment := determineErrorRedirectMethod(responseType)
-	return redirectWithError(c, redirectURI, errorCode, errorDescription, state, useFragment)
+	return redirectWithError(c, redirectURI, errorCode, errorDescription, state, h.config.Issuer, useFragment)
 }
 
 // Helper functions for common error scenarios
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/session"
+)
+
+// checkEssentialACR enforces an acr claim the client requested as essential
+// (OpenID Connect Core Section 5.5.1.1). When the user's session does not carry an
+// acceptable acr, the user is sent back to sign in with a flow that asserts one;
+// when no such step-up is possible, the authorization fails with
+// unmet_authentication_requirements instead of issuing tokens without it. It
+// returns true when the flow may continue; otherwise the response has been written.
+func (h *Handlers) checkEssentialACR(c echo.Context, authSession *models.AuthSession, userSession *models.UserSession) (bool, error) {
+	values, essential := authSession.EssentialACR()
+	if !essential || acrAcceptable(userSession.ACR, values) {
+		return true, nil
+	}
+
+	client, err := h.storage.GetClientByID(authSession.ClientID)
+	if err != nil || client == nil {
+		return false, jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to get client")
+	}
+	user, _ := h.storage.GetUserByID(userSession.UserID)
+	actor := userSession.UserID
+	if user != nil {
+		actor = user.Username
+	}
+	details := map[string]interface{}{"client_id": client.ID, "acr": userSession.ACR, "requested_acr": values}
+
+	stepUp, reason := "", "the session has no acr"
+	for _, acr := range values {
+		if reason = h.stepUpUnavailable(client, userSession, acr); reason == "" {
+			stepUp = acr
+			break
+		}
+	}
+	if stepUp != "" {
+		details["reason"] = "essential acr claim"
+		h.logAudit(models.AuditActionStepUp, models.AuditActorUser, actor,
+			"user", userSession.UserID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(), details)
+		if authSession.Prompt == "none" {
+			_ = h.sessionManager.FinishAuthSession(c, authSession.ID, session.ConsentDenied)
+			return false, h.authorizationError(c, authSession.RedirectURI, authSession.ResponseType,
+				ErrorInteractionRequired, "Stronger authentication is required", authSession.State)
+		}
+		authSession.ACRValues = []string{stepUp}
+		authSession.CompletedSteps = nil
+		authSession.PendingUserID = ""
+		authSession.StepState = nil
+		if err := h.storage.UpdateAuthSession(authSession); err != nil {
+			return false, jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to update authorization session")
+		}
+		return false, c.Redirect(http.StatusFound, h.path("/login?auth_session="+authSession.ID))
+	}
+
+	details["reason"] = reason
+	h.logAudit(models.AuditActionAccessDenied, models.AuditActorUser, actor,
+		"user", userSession.UserID, models.AuditStatusFailure, c.RealIP(), c.Request().UserAgent(), details)
+	if authSession.ResponseType == responseTypeDevice {
+		return false, h.finishDeviceAuthorization(c, authSession, userSession, false)
+	}
+	_ = h.sessionManager.FinishAuthSession(c, authSession.ID, session.ConsentDenied)
+	description := "The user could not be authenticated with an acceptable authentication context"
+	if len(values) > 0 {
+		description += " (" + strings.Join(values, " ") + ")"
+	}
+	return false, h.authorizationError(c, authSession.RedirectURI, authSession.ResponseType,
+		ErrorUnmetAuthenticationRequirements, description, authSession.State)
+}
+
+// acrAcceptable reports whether acr is one of the requested values, or when none
+// were named, whether there is an acr at all
+func acrAcceptable(acr string, values []string) bool {
+	if len(values) == 0 {
+		return acr != ""
+	}
+	return contains(values, acr)
+}
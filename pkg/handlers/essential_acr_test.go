@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/session"
+)
+
+func TestEssentialACRClaim(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	h.config.AuthFlows = []configstore.AuthFlowConfig{{Name: "strong", Steps: []string{StepPassword}, ACR: "gold"}}
+	client.FirstParty = true
+	require.NoError(t, store.UpdateClient(client))
+
+	user := models.NewRegularUser("essential", "essential@example.com", "hashed_password")
+	require.NoError(t, store.CreateUser(user))
+	newUserSession := func(id, acr string) *models.UserSession {
+		userSession := &models.UserSession{ID: id, UserID: user.ID, ACR: acr, AuthTime: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+		require.NoError(t, store.CreateUserSession(userSession))
+		return userSession
+	}
+	bronze, gold := newUserSession("bronze-session", "bronze"), newUserSession("gold-session", "gold")
+
+	consent := func(id, prompt string, acr map[string]interface{}, userSession *models.UserSession) *httptest.ResponseRecorder {
+		require.NoError(t, store.CreateAuthSession(&models.AuthSession{
+			ID: id, ClientID: client.ID, RedirectURI: client.RedirectURIs[0], ResponseType: ResponseTypeCode,
+			Scope: "openid", State: "xyz", Prompt: prompt, ExpiresAt: time.Now().Add(10 * time.Minute),
+			Claims: map[string]interface{}{"id_token": map[string]interface{}{"acr": acr}},
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/consent?auth_session="+id, nil)
+		req.AddCookie(&http.Cookie{Name: session.UserSessionCookieName, Value: userSession.ID})
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.sessionManager.Middleware()(h.Consent)(echo.New().NewContext(req, rec)))
+		return rec
+	}
+	redirectQuery := func(rec *httptest.ResponseRecorder) url.Values {
+		require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		return location.Query()
+	}
+	goldOnly := map[string]interface{}{"essential": true, "values": []interface{}{"gold", "platinum"}}
+
+	// A voluntary acr claim changes nothing
+	query := redirectQuery(consent("voluntary", "", map[string]interface{}{"values": []interface{}{"gold"}}, bronze))
+	assert.NotEmpty(t, query.Get("code"))
+
+	// An essential one the session lacks steps up to a flow that asserts it
+	rec := consent("step-up", "", goldOnly, bronze)
+	require.Equal(t, http.StatusFound, rec.Code)
+	assert.True(t, strings.HasSuffix(rec.Header().Get("Location"), "/login?auth_session=step-up"))
+	authSession, err := store.GetAuthSession("step-up")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gold"}, authSession.ACRValues)
+
+	query = redirectQuery(consent("step-up-silent", "none", goldOnly, bronze))
+	assert.Equal(t, ErrorInteractionRequired, query.Get("error"))
+
+	query = redirectQuery(consent("satisfied", "", goldOnly, gold))
+	assert.NotEmpty(t, query.Get("code"))
+
+	// When no sign-in flow can provide it, the request fails rather than issuing a code
+	query = redirectQuery(consent("unmet", "", map[string]interface{}{"essential": true, "value": "platinum"}, gold))
+	assert.Equal(t, ErrorUnmetAuthenticationRequirements, query.Get("error"))
+	assert.Equal(t, "xyz", query.Get("state"))
+	assert.Empty(t, query.Get("code"))
+
+	denied, err := store.GetAuditLogs(models.AuditFilter{Action: models.AuditActionAccessDenied})
+	require.NoError(t, err)
+	require.Len(t, denied, 1)
+	assert.Equal(t, "no sign-in flow asserts platinum", denied[0].Details["reason"])
+}
+
+func TestEssentialACRParsing(t *testing.T) {
+	parse := func(claims string) ([]string, bool) {
+		var s models.AuthSession
+		require.NoError(t, json.Unmarshal([]byte(claims), &s.Claims))
+		return s.EssentialACR()
+	}
+	values, essential := parse(`{"id_token": {"acr": {"essential": true, "value": "gold"}}}`)
+	assert.True(t, essential)
+	assert.Equal(t, []string{"gold"}, values)
+	values, essential = parse(`{"id_token": {"acr": {"essential": true}}}`)
+	assert.True(t, essential)
+	assert.Empty(t, values)
+	_, essential = parse(`{"userinfo": {"acr": {"essential": true}}}`)
+	assert.False(t, essential, "only the ID token carries acr")
+	assert.False(t, acrAcceptable("", nil))
+	assert.True(t, acrAcceptable("bronze", nil))
+}
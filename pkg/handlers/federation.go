@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/federation"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/outbound"
+)
+
+// defaultFederationLifetime is how long the entity configuration is valid by default
+const defaultFederationLifetime = 24 * time.Hour
+
+// federationHTTPClient fetches the entity statements of other federation members; replaced in tests
+var federationHTTPClient = outbound.NewClient(outbound.Untrusted, 10*time.Second)
+
+// FederationEntityConfiguration serves the server's entity configuration, a
+// statement about itself signed with its signing key that carries its provider
+// metadata (GET /.well-known/openid-federation, OpenID Federation 1.0 §9)
+func (h *Handlers) FederationEntityConfiguration(c echo.Context) error {
+	cfg := h.config.FederationState()
+	if !cfg.Enabled {
+		return echo.ErrNotFound
+	}
+
+	keys, err := h.storage.GetAllSigningKeys()
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to load signing keys")
+	}
+	signingKeys, _ := h.publishedKeys(keys)
+	jwks, err := toJSONObject(signingKeys)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to encode signing keys")
+	}
+	provider, err := toJSONObject(h.discoveryMetadata())
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to encode provider metadata")
+	}
+	if cfg.AutomaticRegistration {
+		provider["client_registration_types_supported"] = []string{"automatic"}
+	}
+	metadata := map[string]map[string]interface{}{federation.EntityTypeOpenIDProvider: provider}
+	entity := map[string]interface{}{}
+	if cfg.OrganizationName != "" {
+		entity["organization_name"] = cfg.OrganizationName
+	}
+	if len(cfg.Contacts) > 0 {
+		entity["contacts"] = cfg.Contacts
+	}
+	if len(entity) > 0 {
+		metadata[federation.EntityTypeFederationEntity] = entity
+	}
+
+	lifetime := defaultFederationLifetime
+	if cfg.LifetimeHours > 0 {
+		lifetime = time.Duration(cfg.LifetimeHours) * time.Hour
+	}
+	now := time.Now()
+	signed, err := h.jwtManager.SignEntityStatement(&federation.EntityStatement{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    h.config.Issuer,
+			Subject:   h.config.Issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(lifetime)),
+		},
+		JWKS:           jwks,
+		AuthorityHints: cfg.AuthorityHints,
+		Metadata:       metadata,
+	})
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to sign entity configuration")
+	}
+
+	c.Response().Header().Set("Cache-Control", "public, max-age=3600")
+	return c.Blob(http.StatusOK, federation.ContentType, []byte(signed))
+}
+
+// toJSONObject converts v to the generic form of its JSON encoding
+func toJSONObject(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]interface{}
+	err = json.Unmarshal(data, &object)
+	return object, err
+}
+
+// federationResolver resolves trust chains up to the configured trust anchors
+func (h *Handlers) federationResolver() *federation.Resolver {
+	resolver := &federation.Resolver{HTTPClient: federationHTTPClient}
+	for _, anchor := range h.config.FederationState().TrustAnchors {
+		resolver.TrustAnchors = append(resolver.TrustAnchors, federation.TrustAnchor{EntityID: anchor.EntityID, JWKS: anchor.JWKS})
+	}
+	return resolver
+}
+
+// establishFederatedClient registers a relying party of a trusted federation on
+// its first authorization request, using its entity ID as client_id, and again
+// once its trust chain expires (OpenID Federation 1.0 automatic registration).
+// The request must carry a request object signed with the relying party's keys.
+func (h *Handlers) establishFederatedClient(c echo.Context) error {
+	cfg := h.config.FederationState()
+	query := c.Request().URL.Query()
+	entityID := query.Get("client_id")
+	if !cfg.Enabled || !cfg.AutomaticRegistration || !strings.HasPrefix(entityID, "https://") {
+		return nil
+	}
+
+	existing, err := h.storage.GetClientByID(entityID)
+	if err != nil {
+		return fmt.Errorf("failed to get client")
+	}
+	if existing != nil && existing.TrustAnchor == "" {
+		return nil // Registered by other means
+	}
+	if query.Get("request") == "" {
+		return fmt.Errorf("automatic registration requires a signed request object")
+	}
+	if existing != nil && existing.FederationExpiresAt != nil && time.Now().Before(*existing.FederationExpiresAt) {
+		return nil
+	}
+
+	chain, err := h.federationResolver().Resolve(c.Request().Context(), entityID)
+	if err == nil {
+		var client *models.Client
+		if client, err = h.federatedClient(chain); err == nil {
+			return h.saveFederatedClient(c, client, existing)
+		}
+	}
+	h.logAudit(models.AuditActionClientRegistered, models.AuditActorClient, entityID,
+		"client", entityID, models.AuditStatusFailure, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"federation": true, "error": err.Error()})
+	return fmt.Errorf("relying party is not trusted: %w", err)
+}
+
+// federatedClient builds a client from the relying party metadata the trust
+// chain allows. Relying parties authenticate with the keys in their metadata.
+func (h *Handlers) federatedClient(chain *federation.TrustChain) (*models.Client, error) {
+	metadata, err := chain.Metadata(federation.EntityTypeRelyingParty)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	var req models.ClientRegistrationRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("invalid relying party metadata: %w", err)
+	}
+
+	if len(req.JWKS) == 0 && req.JWKSURI == "" {
+		return nil, fmt.Errorf("relying party metadata has no jwks or jwks_uri")
+	}
+	if req.TokenEndpointAuthMethod == "" {
+		req.TokenEndpointAuthMethod = authMethodPrivateKeyJWT
+	}
+	if req.TokenEndpointAuthMethod != authMethodPrivateKeyJWT {
+		return nil, fmt.Errorf("automatic registration requires private_key_jwt")
+	}
+	if req.RequestObjectSigningAlg == "none" {
+		return nil, fmt.Errorf("automatic registration requires signed request objects")
+	}
+	if regErr := h.validateRegistrationRequest(&req); regErr != nil {
+		return nil, fmt.Errorf("%s", regErr.ErrorDescription)
+	}
+
+	client, err := h.createClientFromRequest(&req)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := chain.ExpiresAt()
+	client.ID = chain.Subject().Subject
+	client.Secret = ""
+	client.RegistrationAccessToken = ""
+	client.Status = models.ClientStatusActive
+	client.TrustAnchor = chain.TrustAnchor
+	client.FederationExpiresAt = &expiresAt
+	return client, nil
+}
+
+// saveFederatedClient stores an automatically registered client, keeping the
+// administrative state of an earlier registration of the same relying party
+func (h *Handlers) saveFederatedClient(c echo.Context, client, existing *models.Client) error {
+	if existing == nil {
+		if err := h.storage.CreateClient(client); err != nil {
+			return fmt.Errorf("failed to register client")
+		}
+	} else {
+		client.Status = existing.Status
+		client.Disabled = existing.Disabled
+		client.LastUsedAt = existing.LastUsedAt
+		client.CreatedAt = existing.CreatedAt
+		if err := h.storage.UpdateClient(client); err != nil {
+			return fmt.Errorf("failed to update client")
+		}
+	}
+
+	h.logAudit(models.AuditActionClientRegistered, models.AuditActorClient, client.ID,
+		"client", client.ID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"federation": true, "trust_anchor": client.TrustAnchor, "renewed": existing != nil})
+	return nil
+}
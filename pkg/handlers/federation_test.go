@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/federation"
+)
+
+func TestFederationEntityConfiguration(t *testing.T) {
+	h, _, _, _ := setupRevokeTest(t)
+	get := func() (*httptest.ResponseRecorder, error) {
+		rec := httptest.NewRecorder()
+		err := h.FederationEntityConfiguration(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/.well-known/openid-federation", nil), rec))
+		return rec, err
+	}
+
+	_, err := get()
+	assert.Equal(t, echo.ErrNotFound, err)
+
+	h.config.Federation = configstore.FederationConfig{
+		Enabled:               true,
+		OrganizationName:      "Example University",
+		AuthorityHints:        []string{"https://federation.example.edu"},
+		AutomaticRegistration: true,
+	}
+	rec, err := get()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, federation.ContentType, rec.Header().Get(echo.HeaderContentType))
+
+	statement, err := federation.Parse(rec.Body.String(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, h.config.Issuer, statement.Issuer)
+	assert.Equal(t, h.config.Issuer, statement.Subject)
+	assert.Equal(t, []string{"https://federation.example.edu"}, statement.AuthorityHints)
+	provider := statement.Metadata[federation.EntityTypeOpenIDProvider]
+	assert.Equal(t, h.config.Issuer+"/token", provider["token_endpoint"])
+	assert.Equal(t, []interface{}{"automatic"}, provider["client_registration_types_supported"])
+	assert.Equal(t, "Example University", statement.Metadata[federation.EntityTypeFederationEntity]["organization_name"])
+}
+
+// signFederationStatement signs an entity statement as issuer with key
+func signFederationStatement(t *testing.T, key *rsa.PrivateKey, issuer, subject string, jwks map[string]interface{}, build func(*federation.EntityStatement)) string {
+	now := time.Now()
+	statement := &federation.EntityStatement{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: issuer, Subject: subject, IssuedAt: jwt.NewNumericDate(now), ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+		JWKS: jwks,
+	}
+	if build != nil {
+		build(statement)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, statement)
+	token.Header["kid"] = issuer
+	token.Header["typ"] = crypto.EntityStatementType
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func TestAuthorizeFederationAutomaticRegistration(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+	anchorKey, _, err := crypto.GenerateRSAKeyPair()
+	require.NoError(t, err)
+	rpKey, _, err := crypto.GenerateRSAKeyPair()
+	require.NoError(t, err)
+
+	var anchorID, rpID string
+	jwksOf := func(key *rsa.PrivateKey, kid string) map[string]interface{} {
+		jwks, err := crypto.PublicKeyToJWKS(&key.PublicKey, kid)
+		require.NoError(t, err)
+		object, err := toJSONObject(jwks)
+		require.NoError(t, err)
+		return object
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/anchor" + federation.ConfigurationPath:
+			_, _ = w.Write([]byte(signFederationStatement(t, anchorKey, anchorID, anchorID, jwksOf(anchorKey, anchorID), func(s *federation.EntityStatement) {
+				s.Metadata = map[string]map[string]interface{}{federation.EntityTypeFederationEntity: {"federation_fetch_endpoint": anchorID + "/fetch"}}
+			})))
+		case "/anchor/fetch":
+			_, _ = w.Write([]byte(signFederationStatement(t, anchorKey, anchorID, rpID, jwksOf(rpKey, rpID), nil)))
+		case "/rp" + federation.ConfigurationPath:
+			_, _ = w.Write([]byte(signFederationStatement(t, rpKey, rpID, rpID, jwksOf(rpKey, rpID), func(s *federation.EntityStatement) {
+				s.AuthorityHints = []string{anchorID}
+				s.Metadata = map[string]map[string]interface{}{federation.EntityTypeRelyingParty: {
+					"client_name":   "Library Portal",
+					"redirect_uris": []string{"https://library.example.edu/callback"},
+					"jwks":          jwksOf(rpKey, rpID),
+				}}
+			})))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	anchorID, rpID = server.URL+"/anchor", server.URL+"/rp"
+	previousClient := federationHTTPClient
+	federationHTTPClient = server.Client()
+	defer func() { federationHTTPClient = previousClient }()
+
+	h.config.Federation = configstore.FederationConfig{Enabled: true, AutomaticRegistration: true}
+	requestObject := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":           rpID,
+		"aud":           h.config.Issuer,
+		"exp":           time.Now().Add(5 * time.Minute).Unix(),
+		"client_id":     rpID,
+		"response_type": "code",
+		"redirect_uri":  "https://library.example.edu/callback",
+		"scope":         "openid",
+		"state":         "xyz",
+	})
+	requestObject.Header["kid"] = rpID
+	signed, err := requestObject.SignedString(rpKey)
+	require.NoError(t, err)
+
+	authorize := func(request string) *httptest.ResponseRecorder {
+		query := url.Values{"client_id": {rpID}, "scope": {"openid"}, "response_type": {"code"}}
+		if request != "" {
+			query.Set("request", request)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/authorize?"+query.Encode(), nil)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Authorize(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	rec := authorize("")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "requires a signed request object")
+
+	// The relying party's federation is not trusted yet
+	rec = authorize(signed)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrorInvalidClient)
+	client, err := store.GetClientByID(rpID)
+	require.NoError(t, err)
+	assert.Nil(t, client)
+
+	h.config.Federation.TrustAnchors = []configstore.FederationTrustAnchor{{EntityID: anchorID, JWKS: jwksOf(anchorKey, anchorID)}}
+	rec = authorize(signed)
+	require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Location"), "/login?auth_session="))
+
+	client, err = store.GetClientByID(rpID)
+	require.NoError(t, err)
+	require.NotNil(t, client)
+	assert.Equal(t, anchorID, client.TrustAnchor)
+	assert.Equal(t, "Library Portal", client.ClientName)
+	assert.Equal(t, authMethodPrivateKeyJWT, client.TokenEndpointAuthMethod)
+	assert.Empty(t, client.Secret)
+	require.NotNil(t, client.FederationExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *client.FederationExpiresAt, time.Minute)
+}
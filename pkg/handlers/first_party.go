@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// skipsConsent reports whether a request can be authorized without the consent
+// screen: the client is first party, every requested scope is one it may use
+// without consent, and the request did not ask for the screen with prompt=consent
+func skipsConsent(client *models.Client, authSession *models.AuthSession) bool {
+	if client == nil || !client.FirstParty {
+		return false
+	}
+	if contains(strings.Fields(authSession.Prompt), "consent") {
+		return false
+	}
+	if len(client.FirstPartyScopes) == 0 {
+		return true
+	}
+	for _, scope := range strings.Fields(authSession.Scope) {
+		if !contains(client.FirstPartyScopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// applyImplicitConsent grants consent on the user's behalf when the client skips the
+// consent screen. The grant is stored as an implicit consent and audited like one
+// the user gave, so it can be reviewed and revoked later. It returns false when the
+// user still has to be asked.
+func (h *Handlers) applyImplicitConsent(c echo.Context, authSession *models.AuthSession, userSession *models.UserSession, client *models.Client) bool {
+	if !skipsConsent(client, authSession) {
+		return false
+	}
+
+	authSession.ConsentGiven = true
+	authSession.ConsentedScopes = strings.Fields(authSession.Scope)
+	if err := h.storage.UpdateAuthSession(authSession); err != nil {
+		return false
+	}
+
+	h.logAudit(models.AuditActionConsentGrant, models.AuditActorUser, h.userIDToUsername(userSession.UserID),
+		"client", authSession.ClientID, models.AuditStatusSuccess,
+		c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"scope": authSession.Scope, "implicit": true})
+
+	h.saveConsent(userSession.UserID, authSession.ClientID, authSession.ConsentedScopes, true)
+	return true
+}
+
+// saveConsent stores the scopes a user consented to for a client, replacing any
+// earlier consent so the next authorization request can skip the screen
+func (h *Handlers) saveConsent(userID, clientID string, scopes []string, implicit bool) {
+	existingConsent, err := h.storage.GetConsent(userID, clientID)
+	if err == nil && existingConsent != nil {
+		existingConsent.Scopes = scopes
+		existingConsent.Implicit = implicit
+		_ = h.storage.UpdateConsent(existingConsent)
+		return
+	}
+	newConsent := models.NewConsent(userID, clientID, scopes)
+	newConsent.Implicit = implicit
+	_ = h.storage.CreateConsent(newConsent)
+}
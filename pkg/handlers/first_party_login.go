@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// ErrorMFARequired tells a first-party app that the sign-in needs another step
+const ErrorMFARequired = "mfa_required"
+
+const (
+	// GrantTypeFirstPartyLogin is reported for tokens issued by the first-party login API
+	GrantTypeFirstPartyLogin = "first_party_login"
+
+	// responseTypeFirstParty marks the authorization sessions that hold the progress
+	// of a multi-step first-party sign-in. It is never accepted at the authorization endpoint.
+	responseTypeFirstParty = "first_party"
+
+	// errorTooManyAttempts refuses first-party sign-ins over the rate or failure limit
+	errorTooManyAttempts = "too_many_attempts"
+
+	firstPartyLoginWindow          = time.Minute
+	firstPartyMFATTL               = 10 * time.Minute
+	defaultFirstPartyLoginAttempts = 10
+	defaultFirstPartyLoginFailures = 5
+	maxDeviceIDLength              = 128
+
+	// firstPartyDeviceState binds a pending sign-in to the device that started it
+	firstPartyDeviceState = "device_id"
+)
+
+// MFAChallenge is returned when a first-party sign-in needs another step. The app
+// collects the code the step's prompt asks for and posts it with the mfa_token.
+type MFAChallenge struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+	MFAToken         string `json:"mfa_token"`
+	Step             string `json:"step"`
+	Prompt           string `json:"prompt"`
+	ExpiresIn        int    `json:"expires_in"`
+}
+
+// FirstPartyLogin signs a user in to an allow-listed first-party app and returns
+// tokens directly, without a browser redirect (POST /api/auth/login). It takes the
+// form parameters of the token endpoint's password grant plus:
+//
+//	device_id (required) — stable identifier of the app installation; refresh
+//	                       tokens issued here only work with the same device_id
+//	mfa_token, code      — continue a sign-in that answered mfa_required
+//
+// The client's sign-in flow applies, so a flow with an email code step answers the
+// password with mfa_required before issuing tokens.
+func (h *Handlers) FirstPartyLogin(c echo.Context) error {
+	cfg := h.config.FirstPartyLoginState()
+	if !cfg.Enabled {
+		return echo.ErrNotFound
+	}
+
+	attempts := cfg.MaxAttemptsPerMinute
+	if attempts <= 0 {
+		attempts = defaultFirstPartyLoginAttempts
+	}
+	if h.firstPartyLogins != nil && !h.firstPartyLogins.Allow(c.RealIP(), attempts) {
+		h.logRateLimited(c, "first_party_login.attempts")
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(firstPartyLoginWindow/time.Second)))
+		return jsonError(c, http.StatusTooManyRequests, errorTooManyAttempts, "Too many sign-in attempts, try again later")
+	}
+
+	client, errResp := h.firstPartyClient(c)
+	if client == nil {
+		return errResp
+	}
+	instanceID, instanceErr := h.authenticateClientInstance(c, client, GrantTypeFirstPartyLogin)
+	if instanceErr != "" {
+		return h.rejectClientAuth(c, client.ID, instanceErr)
+	}
+
+	deviceID := c.FormValue("device_id")
+	if deviceID == "" || len(deviceID) > maxDeviceIDLength {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidRequest, "device_id is required")
+	}
+
+	username := c.FormValue("username")
+	if h.firstPartyLoginLocked(c.RealIP(), username) {
+		h.logRateLimited(c, "first_party_login.failures")
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(loginFailureWindow/time.Second)))
+		return jsonError(c, http.StatusTooManyRequests, errorTooManyAttempts, "Too many failed sign-in attempts, try again later")
+	}
+
+	// Continue a sign-in that is waiting for another step, or start one
+	var session *models.AuthSession
+	if mfaToken := c.FormValue("mfa_token"); mfaToken != "" {
+		session, _ = h.storage.GetAuthSession(mfaToken)
+		if session == nil || session.ResponseType != responseTypeFirstParty || session.ClientID != client.ID ||
+			session.PendingUserID == "" || time.Now().After(session.ExpiresAt) ||
+			subtle.ConstantTimeCompare([]byte(session.StepState[firstPartyDeviceState]), []byte(deviceID)) != 1 {
+			return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "Invalid or expired mfa_token")
+		}
+	} else {
+		scope := c.FormValue("scope")
+		if scope == "" {
+			scope = client.Scope
+		} else if !h.validateScope(scope, client.Scope) {
+			return jsonError(c, http.StatusBadRequest, ErrorInvalidScope, "Requested scope exceeds client's allowed scope")
+		}
+		if c.FormValue("username") == "" || c.FormValue("password") == "" {
+			return jsonError(c, http.StatusBadRequest, ErrorInvalidRequest, "username and password parameters are required")
+		}
+		session = &models.AuthSession{ClientID: client.ID, ResponseType: responseTypeFirstParty, Scope: scope}
+	}
+
+	flow, err := h.authFlowFor(session)
+	if err != nil || len(flow.Steps) == 0 {
+		return jsonError(c, http.StatusBadRequest, ErrorUnauthorizedClient, "Sign-in is not available for this application")
+	}
+	stepName := flow.Steps[min(len(session.CompletedSteps), len(flow.Steps)-1)]
+	authenticator, ok := h.authenticator(stepName)
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, ErrorUnauthorizedClient, "Sign-in is not available for this application")
+	}
+
+	step := &AuthStep{Session: session}
+	if session.PendingUserID != "" {
+		if step.User, err = h.storage.GetUserByID(session.PendingUserID); err != nil || step.User == nil {
+			return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "Invalid or expired mfa_token")
+		}
+	}
+	user, authErr := authenticator.Authenticate(c, step)
+	if session.ID != "" {
+		// Authenticators may have updated their state, e.g. counted an attempt
+		if err := h.storage.UpdateAuthSession(session); err != nil {
+			return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to update sign-in")
+		}
+	}
+	if authErr == nil && step.User != nil && user.ID != step.User.ID {
+		authErr = loginError("Invalid or expired mfa_token")
+	}
+	if authErr != nil {
+		// The password step counts its own failures
+		if step.User != nil {
+			h.recordLoginFailure(c.RealIP(), step.User.Username)
+		}
+		return jsonError(c, http.StatusUnauthorized, ErrorInvalidGrant, authErr.Error())
+	}
+	if !user.CanAuthenticate() {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "User account is disabled")
+	}
+
+	session.CompletedSteps = append(session.CompletedSteps, stepName)
+	session.AMR = appendUnique(session.AMR, authenticator.AMR()...)
+	session.PendingUserID = user.ID
+	if len(session.CompletedSteps) < len(flow.Steps) {
+		return h.challengeFirstPartyStep(c, session, user, deviceID, flow.Steps[len(session.CompletedSteps)])
+	}
+	if session.ID != "" {
+		_ = h.storage.DeleteAuthSession(session.ID)
+	}
+	return h.issueFirstPartyTokens(c, client, user, session, deviceID, instanceID)
+}
+
+// firstPartyClient authenticates the client like the token endpoint does and checks
+// that it may use the first-party login API. On failure it returns a nil client
+// and the result of writing the error response.
+func (h *Handlers) firstPartyClient(c echo.Context) (*models.Client, error) {
+	clientID, clientSecret := c.FormValue("client_id"), c.FormValue("client_secret")
+	if clientID == "" || clientSecret == "" {
+		if id, secret, ok := parseBasicAuth(c.Request().Header.Get("Authorization")); ok {
+			clientID, clientSecret = id, secret
+		}
+	}
+
+	var client *models.Client
+	var err error
+	if assertionType := c.FormValue("client_assertion_type"); assertionType != "" {
+		client, err = h.authenticateClientAssertion(assertionType, c.FormValue("client_assertion"), clientID)
+		if err != nil {
+			return nil, h.rejectClientAuth(c, clientID, err.Error())
+		}
+	} else {
+		client, err = h.storage.ValidateClient(clientID, clientSecret)
+		if err != nil || client == nil {
+			return nil, h.rejectClientAuth(c, clientID, "Invalid client credentials")
+		}
+	}
+	if !client.IsApproved() || client.Disabled || !client.FirstParty ||
+		!slices.Contains(h.config.FirstPartyLoginState().Clients, client.ID) {
+		return nil, jsonError(c, http.StatusUnauthorized, ErrorUnauthorizedClient, "Client is not allowed to use first-party login")
+	}
+	h.markClientUsed(client)
+	return client, nil
+}
+
+// firstPartyLoginLocked reports whether sign-in from ip, or for username, is refused
+// because of repeated failures
+func (h *Handlers) firstPartyLoginLocked(ip, username string) bool {
+	if h.loginFailures == nil {
+		return false
+	}
+	limit := h.config.FirstPartyLoginState().MaxFailures
+	if limit <= 0 {
+		limit = defaultFirstPartyLoginFailures
+	}
+	if h.loginFailures.Count(loginFailureIPKey(ip)) >= limit {
+		return true
+	}
+	return username != "" && h.loginFailures.Count(loginFailureUserKey(username)) >= limit
+}
+
+// challengeFirstPartyStep saves the sign-in's progress, sends any challenge for the
+// next step and answers with mfa_required
+func (h *Handlers) challengeFirstPartyStep(c echo.Context, session *models.AuthSession, user *models.User, deviceID, stepName string) error {
+	authenticator, ok := h.authenticator(stepName)
+	if !ok {
+		return jsonError(c, http.StatusBadRequest, ErrorUnauthorizedClient, "Sign-in is not available for this application")
+	}
+	if session.ID == "" {
+		id, err := crypto.GenerateRandomString(32)
+		if err != nil {
+			return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to start sign-in")
+		}
+		now := time.Now()
+		session.ID = id
+		session.CreatedAt = now
+		session.ExpiresAt = now.Add(firstPartyMFATTL)
+		session.StepState = map[string]string{firstPartyDeviceState: deviceID}
+		if err := h.storage.CreateAuthSession(session); err != nil {
+			return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to start sign-in")
+		}
+	}
+
+	var challengeErr error
+	if challenger, ok := authenticator.(Challenger); ok {
+		challengeErr = challenger.Challenge(c, &AuthStep{Session: session, User: user})
+	}
+	if err := h.storage.UpdateAuthSession(session); err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to update sign-in")
+	}
+	if challengeErr != nil {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidRequest, challengeErr.Error())
+	}
+	return c.JSON(http.StatusForbidden, MFAChallenge{
+		Error:            ErrorMFARequired,
+		ErrorDescription: "Another sign-in step is required",
+		MFAToken:         session.ID,
+		Step:             stepName,
+		Prompt:           authenticator.Prompt(),
+		ExpiresIn:        int(time.Until(session.ExpiresAt).Seconds()),
+	})
+}
+
+// issueFirstPartyTokens completes a first-party sign-in with tokens bound to deviceID
+// and, if the client authenticated one, its instance
+func (h *Handlers) issueFirstPartyTokens(c echo.Context, client *models.Client, user *models.User, session *models.AuthSession, deviceID, instanceID string) error {
+	token, err := h.newToken(client.ID, user.ID, session.Scope)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate token")
+	}
+	token.DeviceID = deviceID
+	token.InstanceID = instanceID
+	if err := h.storage.CreateToken(token); err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to save token")
+	}
+
+	var idToken string
+	if strings.Contains(session.Scope, "openid") {
+		jwtManager, err := h.jwtManagerFor(client)
+		if err != nil {
+			return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate ID token")
+		}
+		if idToken, err = jwtManager.GenerateIDToken(user, client.ID, "", session.Scope); err != nil {
+			return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate ID token")
+		}
+	}
+
+	method := strings.Join(session.CompletedSteps, "+")
+	h.logAudit(models.AuditActionLogin, models.AuditActorUser, user.Username,
+		"user", user.ID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"method": method, "client_id": client.ID, "first_party": true, "device_id": deviceID})
+	h.logAudit(models.AuditActionTokenIssued, models.AuditActorUser, user.Username,
+		"token", token.AccessToken[:min(16, len(token.AccessToken))],
+		models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"grant_type": GrantTypeFirstPartyLogin, "client_id": client.ID, "scope": session.Scope})
+
+	response := TokenResponse{
+		AccessToken:  token.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    h.config.JWTState().ExpiryMinutes * 60,
+		RefreshToken: token.RefreshToken,
+		IDToken:      idToken,
+		Scope:        session.Scope,
+	}
+	h.addTokenResponseParams(&response, client, user, session.Scope, GrantTypeFirstPartyLogin)
+	return c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storagetest"
+)
+
+// setupFirstPartyLoginTest allow-lists the test client for the first-party login API
+func setupFirstPartyLoginTest(t *testing.T) (*Handlers, *models.Client, *models.User, func(url.Values) *httptest.ResponseRecorder) {
+	h, store, client, _ := setupRevokeTest(t)
+	client.FirstParty = true
+	client.Scope = "openid profile email"
+	require.NoError(t, store.UpdateClient(client))
+	h.config.FirstPartyLogin = configstore.FirstPartyLoginConfig{Enabled: true, Clients: []string{client.ID}}
+	user := storagetest.User()
+	storagetest.Create(t, store, user)
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		form.Set("client_id", client.ID)
+		form.Set("client_secret", client.Secret)
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.FirstPartyLogin(echo.New().NewContext(req, rec)))
+		return rec
+	}
+	return h, client, user, post
+}
+
+func TestFirstPartyLogin(t *testing.T) {
+	h, client, user, post := setupFirstPartyLoginTest(t)
+
+	rec := post(url.Values{"username": {user.Username}, "password": {storagetest.Password}, "scope": {"openid"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "device_id is required")
+
+	rec = post(url.Values{"username": {user.Username}, "password": {storagetest.Password}, "scope": {"openid"}, "device_id": {"phone-1"}})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var tokens TokenResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tokens))
+	assert.NotEmpty(t, tokens.AccessToken)
+	assert.NotEmpty(t, tokens.IDToken)
+	assert.Equal(t, "openid", tokens.Scope)
+
+	issued, err := h.storage.GetTokenByAccessToken(tokens.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "phone-1", issued.DeviceID)
+
+	// The refresh token only works with the device it was issued to
+	refresh := func(deviceID string) *httptest.ResponseRecorder {
+		form := url.Values{
+			"grant_type":    {GrantTypeRefreshToken},
+			"refresh_token": {tokens.RefreshToken},
+			"client_id":     {client.ID},
+			"client_secret": {client.Secret},
+			"device_id":     {deviceID},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Token(echo.New().NewContext(req, rec)))
+		return rec
+	}
+	rec = refresh("phone-2")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "bound to another device")
+	rec = refresh("phone-1")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var refreshed TokenResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &refreshed))
+	rotated, err := h.storage.GetTokenByAccessToken(refreshed.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "phone-1", rotated.DeviceID)
+
+	// Scopes are limited to the client's
+	rec = post(url.Values{"username": {user.Username}, "password": {storagetest.Password}, "scope": {"openid admin"}, "device_id": {"phone-1"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestFirstPartyLogin_ClientAllowList(t *testing.T) {
+	h, client, user, post := setupFirstPartyLoginTest(t)
+	form := func() url.Values {
+		return url.Values{"username": {user.Username}, "password": {storagetest.Password}, "device_id": {"phone-1"}}
+	}
+
+	h.config.FirstPartyLogin.Clients = []string{"other-client"}
+	rec := post(form())
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrorUnauthorizedClient)
+
+	// Allow-listed clients must also be first party
+	h.config.FirstPartyLogin.Clients = []string{client.ID}
+	client.FirstParty = false
+	require.NoError(t, h.storage.UpdateClient(client))
+	rec = post(form())
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	h.config.FirstPartyLogin.Enabled = false
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(form().Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	assert.Equal(t, echo.ErrNotFound, h.FirstPartyLogin(echo.New().NewContext(req, httptest.NewRecorder())))
+}
+
+func TestFirstPartyLogin_RateLimits(t *testing.T) {
+	h, _, user, post := setupFirstPartyLoginTest(t)
+	h.config.FirstPartyLogin.MaxFailures = 2
+
+	for i := 0; i < 2; i++ {
+		rec := post(url.Values{"username": {user.Username}, "password": {"wrong"}, "device_id": {"phone-1"}})
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+	// Locked out even with the right password
+	rec := post(url.Values{"username": {user.Username}, "password": {storagetest.Password}, "device_id": {"phone-1"}})
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	h.loginFailures.Reset(loginFailureIPKey("192.0.2.1"))
+	h.loginFailures.Reset(loginFailureUserKey(user.Username))
+	h.config.FirstPartyLogin.MaxAttemptsPerMinute = 4 // Three requests were made above
+	rec = post(url.Values{"username": {user.Username}, "password": {storagetest.Password}, "device_id": {"phone-1"}})
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = post(url.Values{"username": {user.Username}, "password": {storagetest.Password}, "device_id": {"phone-1"}})
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+}
+
+func TestFirstPartyLogin_MFA(t *testing.T) {
+	h, client, user, post := setupFirstPartyLoginTest(t)
+	mailer := &fakeMailer{}
+	h.mailer = mailer
+	h.config.AuthFlows = []configstore.AuthFlowConfig{{Name: "mfa", Steps: []string{StepPassword, StepEmailOTP}}}
+	client.AuthFlow = "mfa"
+	require.NoError(t, h.storage.UpdateClient(client))
+
+	rec := post(url.Values{"username": {user.Username}, "password": {storagetest.Password}, "device_id": {"phone-1"}})
+	require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	var challenge MFAChallenge
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &challenge))
+	assert.Equal(t, ErrorMFARequired, challenge.Error)
+	assert.Equal(t, StepEmailOTP, challenge.Step)
+	require.NotEmpty(t, challenge.MFAToken)
+	require.Equal(t, user.Email, mailer.to)
+	code := regexp.MustCompile(`\d{6}`).FindString(mailer.body)
+
+	// The pending sign-in is bound to the device that started it
+	rec = post(url.Values{"mfa_token": {challenge.MFAToken}, "code": {code}, "device_id": {"phone-2"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = post(url.Values{"mfa_token": {challenge.MFAToken}, "code": {"000000x"}, "device_id": {"phone-1"}})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = post(url.Values{"mfa_token": {challenge.MFAToken}, "code": {code}, "device_id": {"phone-1"}})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// The mfa_token is used up
+	rec = post(url.Values{"mfa_token": {challenge.MFAToken}, "code": {code}, "device_id": {"phone-1"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	logins, err := h.storage.GetAuditLogs(models.AuditFilter{Action: models.AuditActionLogin})
+	require.NoError(t, err)
+	require.Len(t, logins, 1)
+	assert.Equal(t, "password+email_otp", logins[0].Details["method"])
+}
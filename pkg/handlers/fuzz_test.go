@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// The seed corpus runs with go test; explore further with, for example,
+// go test -fuzz FuzzParseBasicAuth -fuzztime 30s ./pkg/handlers
+
+func FuzzParseBasicAuth(f *testing.F) {
+	f.Add("Basic " + base64.StdEncoding.EncodeToString([]byte("client:secret")))
+	f.Add("basic " + base64.StdEncoding.EncodeToString([]byte("client:se:cr:et")))
+	f.Add("Basic " + base64.StdEncoding.EncodeToString([]byte("no-colon")))
+	f.Add("Basic " + base64.StdEncoding.EncodeToString([]byte(":")))
+	f.Add("Basic not base64!")
+	f.Add("Bearer abc")
+	f.Add("Basic")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, header string) {
+		username, password, ok := parseBasicAuth(header)
+
+		// net/http parses the header the same way
+		req := &http.Request{Header: http.Header{"Authorization": {header}}}
+		wantUser, wantPass, wantOK := req.BasicAuth()
+		if ok != wantOK || username != wantUser || password != wantPass {
+			t.Fatalf("parseBasicAuth(%q) = %q, %q, %v; net/http gives %q, %q, %v",
+				header, username, password, ok, wantUser, wantPass, wantOK)
+		}
+		if !ok {
+			return
+		}
+
+		// Credentials survive being encoded again
+		encoded := "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+		u, p, ok := parseBasicAuth(encoded)
+		if !ok || u != username || p != password {
+			t.Fatalf("round trip of %q, %q gave %q, %q, %v", username, password, u, p, ok)
+		}
+	})
+}
+
+func FuzzValidateAuthorizationParams(f *testing.F) {
+	verifier := strings.Repeat("a", 43)
+	f.Add("code_challenge=" + verifier + "&code_challenge_method=S256")
+	f.Add("code_challenge=" + verifier + "&code_challenge_method=plain&max_age=0")
+	f.Add("code_challenge_method=S256")
+	f.Add("code_challenge=short")
+	f.Add("max_age=-1")
+	f.Add("max_age=99999999999999999999")
+	f.Add(`claims={"id_token":{"email":{"essential":true}}}`)
+	f.Add("claims=[1,2]")
+	f.Add("response_type=code&client_id=c&redirect_uri=https%3A%2F%2Fexample.com&scope=openid&state=%00")
+	f.Add("%zz=1&;&=&code_challenge=%F0%9F%98%80")
+
+	f.Fuzz(func(t *testing.T, rawQuery string) {
+		query, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			return
+		}
+		problem := validateAuthorizationParams(query)
+		_ = clientRequirementError(&models.Client{RequirePKCE: true}, ResponseTypeCode, query)
+		if problem != "" {
+			return
+		}
+
+		// Whatever passes validation is safe for the later steps to use as is
+		if challenge := query.Get("code_challenge"); challenge != "" {
+			if len(challenge) < 43 || len(challenge) > 128 || strings.Trim(challenge, pkceAlphabet) != "" {
+				t.Fatalf("accepted code_challenge %q", challenge)
+			}
+		} else if query.Get("code_challenge_method") != "" {
+			t.Fatalf("accepted code_challenge_method without code_challenge in %q", rawQuery)
+		}
+		if maxAge := query.Get("max_age"); maxAge != "" {
+			if n, err := strconv.Atoi(maxAge); err != nil || n < 0 {
+				t.Fatalf("accepted max_age %q", maxAge)
+			}
+		}
+		if claims := query.Get("claims"); claims != "" {
+			var parsed map[string]interface{}
+			if err := json.Unmarshal([]byte(claims), &parsed); err != nil {
+				t.Fatalf("accepted claims %q", claims)
+			}
+		}
+	})
+}
+
+func FuzzValidateRegistrationRequest(f *testing.F) {
+	f.Add(`{"redirect_uris":["https://app.example.com/cb"],"client_name":"App"}`)
+	f.Add(`{"redirect_uris":["http://localhost:8080/cb"],"application_type":"native","token_endpoint_auth_method":"none"}`)
+	f.Add(`{"redirect_uris":["https://app.example.com/cb"],"grant_types":["implicit"],"response_types":["code"]}`)
+	f.Add(`{"redirect_uris":["https://app.example.com/cb#frag"]}`)
+	f.Add(`{"redirect_uris":["javascript:alert(1)"]}`)
+	f.Add(`{"redirect_uris":["https://app.example.com/cb"],"jwks":{"keys":[]},"jwks_uri":"https://app.example.com/jwks"}`)
+	f.Add(`{"redirect_uris":["https://app.example.com/cb"],"jwks":{"keys":"nope"}}`)
+	f.Add(`{"redirect_uris":["https://evil.example.net/cb"],"subject_type":"pairwise","sector_identifier_uri":"http://x"}`)
+	f.Add(`{"redirect_uris":[""],"logo_uri":"%%","request_object_signing_alg":"none"}`)
+	f.Add(`{}`)
+
+	h := &Handlers{config: &configstore.ConfigData{
+		Issuer: "https://example.com",
+		Registration: configstore.RegistrationConfig{
+			Policy: configstore.RegistrationPolicy{AllowedRedirectHosts: []string{"*.example.com", "localhost"}},
+		},
+	}}
+
+	f.Fuzz(func(t *testing.T, body string) {
+		var req models.ClientRegistrationRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			return
+		}
+		regErr := h.validateRegistrationRequest(&req)
+		if regErr != nil {
+			if regErr.Error == "" || regErr.ErrorDescription == "" {
+				t.Fatalf("incomplete error %+v for %s", regErr, body)
+			}
+			return
+		}
+
+		// Accepted metadata has usable redirect URIs within the policy
+		if len(req.RedirectURIs) == 0 {
+			t.Fatalf("accepted registration without redirect_uris: %s", body)
+		}
+		for _, uri := range req.RedirectURIs {
+			parsed, err := url.Parse(uri)
+			if err != nil || parsed.Scheme == "" || parsed.Fragment != "" {
+				t.Fatalf("accepted redirect_uri %q", uri)
+			}
+			if !redirectHostAllowed(parsed.Hostname(), h.config.Registration.Policy.AllowedRedirectHosts) {
+				t.Fatalf("accepted redirect_uri %q outside the policy", uri)
+			}
+		}
+	})
+}
@@ -3,50 +3,145 @@ package handlers
 import (
 	"embed"
 	"html/template"
+	"log"
+	"sync/atomic"
 
+	"github.com/prasenjit-net/openid-golang/pkg/access"
+	"github.com/prasenjit-net/openid-golang/pkg/attributes"
+	"github.com/prasenjit-net/openid-golang/pkg/blobstore"
 	"github.com/prasenjit-net/openid-golang/pkg/configstore"
 	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/mail"
+	"github.com/prasenjit-net/openid-golang/pkg/middleware"
 	"github.com/prasenjit-net/openid-golang/pkg/session"
 	"github.com/prasenjit-net/openid-golang/pkg/storage"
 )
 
 // Handlers holds all HTTP handlers
 type Handlers struct {
-	config         *configstore.ConfigData
-	storage        storage.Storage
-	jwtManager     *crypto.JWTManager
-	sessionManager *session.Manager
-	loginTmpl      *template.Template
-	consentTmpl    *template.Template
+	config            *configstore.ConfigData
+	storage           storage.Storage
+	jwtManager        *crypto.JWTManager
+	sessionManager    *session.Manager
+	loginTmpl         *template.Template
+	consentTmpl       *template.Template
+	deviceTmpl        *template.Template
+	errorTmpl         *template.Template
+	logoutTmpl        *template.Template
+	scanningKeys      secretScanningKeyCache
+	sectorIdentifiers sectorIdentifierCache
+	clientJWKSCache   clientJWKSCache
+	mailer            mail.Sender
+	avatars           blobstore.Store
+	attributes        *attributes.Resolver
+	accessPolicies    *access.Evaluator
+	draining          atomic.Bool
+	authenticators    map[string]Authenticator
+	attestors         map[string]InstanceAttestor
+	storageBreaker    *storage.Breaker
+
+	tokenResponseMapper TokenResponseMapper
+
+	registrationLimiter *middleware.RateLimiter
+	loginFailures       *middleware.RateLimiter
+	firstPartyLogins    *middleware.RateLimiter
+	errorReports        *middleware.RateLimiter
 }
 
 // minimal fallback templates used when no embed.FS is provided (e.g. tests).
-const fallbackLoginTmpl = `<!DOCTYPE html><html><body>
-<form method="POST" action="/login?auth_session={{.AuthSessionID}}">
+const fallbackLoginTmpl = `<!DOCTYPE html><html lang="{{.Locale}}"><head><title>{{.Brand.ProductName}}</title></head><body class="display-{{.Display}}">
+<form method="POST" action="{{.BasePath}}/login?auth_session={{.AuthSessionID}}">
 {{if .ErrorMessage}}<p style="color:red">{{.ErrorMessage}}</p>{{end}}
-<input name="username" required><input type="password" name="password" required>
-<button type="submit">Sign In</button></form></body></html>`
+{{if ne .Step "password"}}<label>{{.StepPrompt}} <input name="code" required></label>
+<button type="submit">Continue</button>
+{{if .Restartable}}<button type="submit" name="action" value="restart" formnovalidate>Start over</button>{{end}}
+</form>{{else}}
+<label>{{.IdentifierLabel}} <input name="username" required></label><input type="password" name="password" required>
+{{if .RememberMe}}<label><input type="checkbox" name="remember_me" value="1"> Keep me signed in</label>{{end}}
+{{with .Captcha}}<script src="{{.ScriptURL}}" async defer></script><div class="{{.WidgetClass}}" data-sitekey="{{.SiteKey}}"></div>{{end}}
+<button type="submit">Sign In</button>
+{{if .AuthSessionID}}<button type="submit" name="action" value="cancel" formnovalidate>Cancel</button>{{end}}
+</form>{{end}}
+{{if .MagicLinkEnabled}}<form method="POST" action="{{.BasePath}}/login?auth_session={{.AuthSessionID}}">
+{{if .InfoMessage}}<p>{{.InfoMessage}}</p>{{end}}
+<input type="email" name="email" required>
+<button type="submit" name="action" value="magic_link">Email me a sign-in link</button>
+</form>{{end}}</body></html>`
 
-const fallbackConsentTmpl = `<!DOCTYPE html><html><body>
-<form method="POST" action="/consent?auth_session={{.AuthSessionID}}">
+const fallbackConsentTmpl = `<!DOCTYPE html><html lang="{{.Locale}}"><head><title>{{.Brand.ProductName}}</title></head><body class="display-{{.Display}}">
+<form method="POST" action="{{.BasePath}}/consent?auth_session={{.AuthSessionID}}">
 <p>{{.ClientName}} requests: {{range .Scopes}}{{.Name}} {{end}}</p>
 <button name="consent" value="allow">Allow</button>
 <button name="consent" value="deny">Deny</button></form></body></html>`
 
+const fallbackDeviceTmpl = `<!DOCTYPE html><html lang="{{.Locale}}"><head><title>{{.Brand.ProductName}}</title>
+{{if and .Kiosk .Result}}<meta http-equiv="refresh" content="{{.ReturnSeconds}};url={{.BasePath}}/device">{{end}}</head><body>
+{{if .ErrorMessage}}<p style="color:red">{{.ErrorMessage}}</p>{{end}}
+{{if eq .Result "approved"}}<p>{{.ClientName}} is connected. You can return to your device.</p>
+{{else if eq .Result "denied"}}<p>{{.ClientName}} was not connected.</p>
+{{else}}<form method="POST" action="{{.BasePath}}/device">
+<input name="user_code" value="{{.UserCode}}" placeholder="{{.Placeholder}}" maxlength="{{.MaxLength}}" inputmode="{{.InputMode}}" autocomplete="off" required autofocus>
+<button type="submit">Continue</button></form>{{end}}</body></html>`
+
+const fallbackErrorTmpl = `<!DOCTYPE html><html lang="{{.Locale}}"><head><title>{{.Brand.ProductName}}</title></head><body>
+{{if .Found}}<p>Error {{.Error}} at {{.Time}}</p>{{else}}<p>Unknown or expired error reference</p>{{end}}
+<p>Reference: <code>{{.Reference}}</code></p></body></html>`
+
+const fallbackLogoutTmpl = `<!DOCTYPE html><html lang="{{.Locale}}"><head><title>{{.Brand.ProductName}}</title></head><body>
+<form method="POST" action="{{.BasePath}}/logout"><input type="hidden" name="logout_token" value="{{.LogoutToken}}">
+<button type="submit">Sign out</button></form></body></html>`
+
 // NewHandlers creates a new handlers instance.
-// publicFS should contain public/login.html and public/consent.html.
+// publicFS should contain public/login.html, public/consent.html, public/device.html, public/error.html
+// and public/logout.html.
 // Pass an empty embed.FS (or zero value) to use minimal fallback templates (useful in tests).
 func NewHandlers(store storage.Storage, jwtManager *crypto.JWTManager, cfg *configstore.ConfigData, sessionMgr *session.Manager, publicFS embed.FS) *Handlers {
 	loginTmpl := parseOrFallback(publicFS, "public/login.html", fallbackLoginTmpl)
 	consentTmpl := parseOrFallback(publicFS, "public/consent.html", fallbackConsentTmpl)
-	return &Handlers{
+	deviceTmpl := parseOrFallback(publicFS, "public/device.html", fallbackDeviceTmpl)
+	errorTmpl := parseOrFallback(publicFS, "public/error.html", fallbackErrorTmpl)
+	logoutTmpl := parseOrFallback(publicFS, "public/logout.html", fallbackLogoutTmpl)
+	h := &Handlers{
 		config:         cfg,
 		storage:        store,
 		jwtManager:     jwtManager,
 		sessionManager: sessionMgr,
 		loginTmpl:      loginTmpl,
 		consentTmpl:    consentTmpl,
+		deviceTmpl:     deviceTmpl,
+		errorTmpl:      errorTmpl,
+		logoutTmpl:     logoutTmpl,
+
+		registrationLimiter: middleware.NewRateLimiter(registrationQuotaWindow),
+		loginFailures:       middleware.NewRateLimiter(loginFailureWindow),
+		firstPartyLogins:    middleware.NewRateLimiter(firstPartyLoginWindow),
+		errorReports:        middleware.NewRateLimiter(errorReportWindow),
+	}
+	if sender := mail.NewSMTPSender(cfg.SMTP); sender != nil {
+		h.mailer = sender
+	}
+	if cfg.Avatars.Enabled {
+		if store, err := blobstore.Open(cfg.Avatars.Store); err != nil {
+			log.Printf("Avatar uploads disabled: %v", err)
+		} else {
+			h.avatars = store
+		}
 	}
+	resolver, err := attributes.NewResolver(cfg.AttributeProviders)
+	if err != nil {
+		log.Printf("Attribute providers disabled: %v", err)
+		resolver, _ = attributes.NewResolver(nil)
+	}
+	h.attributes = resolver
+	evaluator, err := access.NewEvaluator(cfg.AccessPolicies)
+	if err != nil {
+		// Failing closed: a misconfigured policy must not silently allow everything
+		log.Printf("Access policies misconfigured, denying authorizations: %v", err)
+		evaluator = &access.Evaluator{}
+		evaluator.AddPolicy(access.DenyAll("misconfigured"), false)
+	}
+	h.accessPolicies = evaluator
+	return h
 }
 
 // parseOrFallback tries to parse the named file from fs; on any error it parses the fallback string.
@@ -57,7 +152,40 @@ func parseOrFallback(fsys embed.FS, name, fallback string) *template.Template {
 	return template.Must(template.New(name).Parse(fallback))
 }
 
+// path prefixes a server-relative path with the base path the server is mounted under
+func (h *Handlers) path(p string) string {
+	return h.config.BasePath() + p
+}
+
 // GetStorage returns the storage instance
 func (h *Handlers) GetStorage() storage.Storage {
 	return h.storage
 }
+
+// Mailer returns the outgoing mail sender, or nil when SMTP is not configured
+func (h *Handlers) Mailer() mail.Sender {
+	return h.mailer
+}
+
+// AvatarStore returns the blob store holding uploaded avatars, or nil when
+// avatar uploads are not enabled
+func (h *Handlers) AvatarStore() blobstore.Store {
+	return h.avatars
+}
+
+// GetAttributeResolver returns the resolver for upstream user attributes, so that
+// custom providers can be registered at startup
+func (h *Handlers) GetAttributeResolver() *attributes.Resolver {
+	return h.attributes
+}
+
+// GetAccessEvaluator returns the conditional-access policies checked at
+// authorization, so that custom policies can be registered at startup
+func (h *Handlers) GetAccessEvaluator() *access.Evaluator {
+	return h.accessPolicies
+}
+
+// GetSessionManager returns the session manager instance
+func (h *Handlers) GetSessionManager() *session.Manager {
+	return h.sessionManager
+}
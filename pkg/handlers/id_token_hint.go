@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/session"
+)
+
+// idTokenHintSubject verifies an id_token_hint (OpenID Connect Core §3.1.2.1) and
+// returns the user it names. The hint must be an ID token this server issued to
+// clientID, signed with one of its keys. It may have expired: clients send the last
+// ID token they received, which is often stale by the time they renew silently.
+func (h *Handlers) idTokenHintSubject(hint, clientID string) (string, error) {
+	claims := &crypto.IDTokenClaims{}
+	_, err := jwt.ParseWithClaims(hint, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return h.idTokenVerificationKey(kid)
+	}, jwt.WithoutClaimsValidation())
+	if err != nil {
+		return "", err
+	}
+	if claims.Issuer != h.config.Issuer {
+		return "", fmt.Errorf("id_token_hint was issued by %s", claims.Issuer)
+	}
+	if !contains(claims.Audience, clientID) {
+		return "", fmt.Errorf("id_token_hint was issued to another client")
+	}
+	if claims.Subject == "" {
+		return "", fmt.Errorf("id_token_hint has no subject")
+	}
+	return claims.Subject, nil
+}
+
+// idTokenVerificationKey returns the public key of the signing key kid, including
+// keys that have been rotated out but are still published
+func (h *Handlers) idTokenVerificationKey(kid string) (interface{}, error) {
+	if kid == "" || kid == h.jwtManager.KeyID() {
+		return h.jwtManager.GetPublicKey(), nil
+	}
+	key, err := h.storage.GetSigningKeyByKID(kid)
+	if err != nil || key == nil || (key.Use != "" && key.Use != models.KeyUseSignature) {
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	}
+	return crypto.ParsePublicKeyFromPEM(key.PublicKey)
+}
+
+// requireLogin sends the user to the login page to sign in again. With prompt=none
+// no page may be shown, so the client gets login_required instead.
+func (h *Handlers) requireLogin(c echo.Context, authSession *models.AuthSession) error {
+	if authSession.Prompt == "none" {
+		_ = h.sessionManager.FinishAuthSession(c, authSession.ID, session.ConsentDenied)
+		return h.authorizationError(c, authSession.RedirectURI, authSession.ResponseType,
+			ErrorLoginRequired, "The user must sign in", authSession.State)
+	}
+	return c.Redirect(http.StatusFound, h.path("/login?auth_session="+authSession.ID))
+}
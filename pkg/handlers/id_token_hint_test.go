@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/session"
+)
+
+func TestSilentAuthenticationWithIDTokenHint(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	client.FirstParty = true
+	client.FirstPartyScopes = []string{"openid"}
+	require.NoError(t, store.UpdateClient(client))
+
+	user := models.NewRegularUser("silent", "silent@example.com", "hashed_password")
+	require.NoError(t, store.CreateUser(user))
+	other := models.NewRegularUser("other", "other@example.com", "hashed_password")
+	require.NoError(t, store.CreateUser(other))
+	userSession := &models.UserSession{
+		ID:        "silent-session",
+		UserID:    user.ID,
+		AuthTime:  time.Now().Add(-10 * time.Minute),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	require.NoError(t, store.CreateUserSession(userSession))
+
+	hintFor := func(u *models.User, clientID string) string {
+		token, err := h.jwtManager.GenerateIDToken(u, clientID, "", "openid")
+		require.NoError(t, err)
+		return token
+	}
+	var cookies []*http.Cookie
+	authorize := func(params url.Values, withSession bool) *url.URL {
+		params.Set("client_id", client.ID)
+		params.Set("redirect_uri", client.RedirectURIs[0])
+		params.Set("response_type", ResponseTypeCode)
+		params.Set("scope", "openid")
+		params.Set("state", "st")
+		req := httptest.NewRequest(http.MethodGet, "/authorize?"+params.Encode(), nil)
+		if withSession {
+			req.AddCookie(&http.Cookie{Name: session.UserSessionCookieName, Value: userSession.ID})
+		}
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.sessionManager.Middleware()(h.Authorize)(echo.New().NewContext(req, rec)))
+		assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+		assert.Equal(t, "no-cache", rec.Header().Get("Pragma"))
+		require.Equal(t, http.StatusFound, rec.Code)
+		cookies = rec.Result().Cookies()
+		location, err := url.Parse(rec.Header().Get("Location"))
+		require.NoError(t, err)
+		return location
+	}
+
+	t.Run("no session", func(t *testing.T) {
+		location := authorize(url.Values{"prompt": {"none"}}, false)
+		assert.Equal(t, ErrorLoginRequired, location.Query().Get("error"))
+		assert.Equal(t, "st", location.Query().Get("state"))
+		for _, cookie := range cookies {
+			assert.NotEqual(t, session.AuthSessionCookieName, cookie.Name, "nothing is stored for a silent request that fails")
+		}
+	})
+
+	t.Run("hint matches session", func(t *testing.T) {
+		location := authorize(url.Values{"prompt": {"none"}, "id_token_hint": {hintFor(user, client.ID)}}, true)
+		assert.Empty(t, location.Query().Get("error"))
+		assert.NotEmpty(t, location.Query().Get("code"))
+	})
+
+	t.Run("hint names another user", func(t *testing.T) {
+		location := authorize(url.Values{"prompt": {"none"}, "id_token_hint": {hintFor(other, client.ID)}}, true)
+		assert.Equal(t, ErrorLoginRequired, location.Query().Get("error"))
+	})
+
+	t.Run("hint issued to another client", func(t *testing.T) {
+		location := authorize(url.Values{"prompt": {"none"}, "id_token_hint": {hintFor(user, "other-client")}}, true)
+		assert.Equal(t, ErrorInvalidRequest, location.Query().Get("error"))
+	})
+
+	t.Run("tampered hint", func(t *testing.T) {
+		location := authorize(url.Values{"prompt": {"none"}, "id_token_hint": {hintFor(user, client.ID) + "x"}}, true)
+		assert.Equal(t, ErrorInvalidRequest, location.Query().Get("error"))
+	})
+
+	t.Run("session older than max_age", func(t *testing.T) {
+		location := authorize(url.Values{"prompt": {"none"}, "max_age": {"60"}}, true)
+		assert.Equal(t, ErrorLoginRequired, location.Query().Get("error"))
+	})
+
+	t.Run("prompt=none combined with login", func(t *testing.T) {
+		location := authorize(url.Values{"prompt": {"none login"}}, true)
+		assert.Equal(t, ErrorInvalidRequest, location.Query().Get("error"))
+	})
+
+	t.Run("interactive request with another user's hint", func(t *testing.T) {
+		location := authorize(url.Values{"id_token_hint": {hintFor(other, client.ID)}}, true)
+		assert.Equal(t, "/login", location.Path)
+	})
+}
+
+func TestIDTokenHintSubjectAcceptsExpiredTokensFromRotatedKeys(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	publicPEM, err := crypto.EncodePublicKeyToPEM(&privateKey.PublicKey)
+	require.NoError(t, err)
+	require.NoError(t, store.CreateSigningKey(&models.SigningKey{ID: "rotated", KID: "kid-rotated",
+		Algorithm: "RS256", PublicKey: publicPEM, CreatedAt: time.Now().Add(-48 * time.Hour)}))
+
+	sign := func(issuer string) string {
+		claims := &crypto.IDTokenClaims{RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   "user-1",
+			Audience:  jwt.ClaimStrings{client.ID},
+			IssuedAt:  jwt.NewNumericDate(time.Now().Add(-2 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+		}}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "kid-rotated"
+		signed, err := token.SignedString(privateKey)
+		require.NoError(t, err)
+		return signed
+	}
+
+	subject, err := h.idTokenHintSubject(sign(h.config.Issuer), client.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", subject)
+
+	_, err = h.idTokenHintSubject(sign("https://other.example.com"), client.ID)
+	assert.Error(t, err)
+}
@@ -1,14 +1,24 @@
 package handlers
 
 import (
+	"mime"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
 	"github.com/prasenjit-net/openid-golang/pkg/models"
 )
 
+// introspectionJWTMediaType is requested in Accept by resource servers that want a
+// signed introspection response (RFC 9701)
+const introspectionJWTMediaType = "application/" + crypto.IntrospectionJWTType
+
+// introspectionSigningAlgs are the algorithms signed introspection responses may use
+var introspectionSigningAlgs = []string{"RS256"}
+
 // IntrospectRequest represents a token introspection request per RFC 7662
 type IntrospectRequest struct {
 	Token         string
@@ -62,16 +72,74 @@ func (h *Handlers) Introspect(c echo.Context) error {
 	// Validate client credentials - REQUIRED per RFC 7662 §2.1
 	client, err := h.storage.ValidateClient(clientID, clientSecret)
 	if err != nil || client == nil {
-		return ErrorInvalidClientAuth(c, "Invalid client credentials")
+		return h.rejectClientAuth(c, clientID, "Invalid client credentials")
+	}
+	if client.Disabled {
+		return h.rejectClientAuth(c, clientID, "Client is disabled")
 	}
 
 	// Introspect the token
-	response := h.introspectToken(req.Token, req.TokenTypeHint)
+	response := restrictIntrospection(client, h.introspectToken(req.Token, req.TokenTypeHint))
+
+	// RFC 9701: resource servers may ask for the response as a JWT signed by the server
+	if acceptsMediaType(c.Request().Header.Get(echo.HeaderAccept), introspectionJWTMediaType) {
+		return h.signedIntrospectionResponse(c, client, response)
+	}
 
 	// RFC 7662 §2.2: The authorization server responds with a JSON object
 	return c.JSON(http.StatusOK, response)
 }
 
+// restrictIntrospection limits what a resource server learns about tokens issued to
+// other clients: tokens without any of its resource scopes are reported inactive
+// (RFC 7662 §4), and for the rest only its own scopes are disclosed with the resource
+// server as audience. Other clients see the full response.
+func restrictIntrospection(client *models.Client, response *IntrospectResponse) *IntrospectResponse {
+	if !client.ResourceServer || !response.Active || response.ClientID == client.ID {
+		return response
+	}
+
+	var visible []string
+	for _, scope := range strings.Fields(response.Scope) {
+		if contains(client.ResourceScopes, scope) {
+			visible = append(visible, scope)
+		}
+	}
+	if len(visible) == 0 {
+		return &IntrospectResponse{Active: false}
+	}
+
+	restricted := *response
+	restricted.Scope = strings.Join(visible, " ")
+	restricted.Aud = client.ID
+	return &restricted
+}
+
+// signedIntrospectionResponse writes response as a JWT addressed to the calling
+// resource server (RFC 9701 §5)
+func (h *Handlers) signedIntrospectionResponse(c echo.Context, client *models.Client, response *IntrospectResponse) error {
+	jwtManager, err := h.jwtManagerFor(client)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to sign introspection response")
+	}
+	signed, err := jwtManager.GenerateIntrospectionResponse(client.ID, response)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to sign introspection response")
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.Blob(http.StatusOK, introspectionJWTMediaType, []byte(signed))
+}
+
+// acceptsMediaType reports whether an Accept header lists mediaType explicitly
+func acceptsMediaType(accept, mediaType string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		if mt, _, err := mime.ParseMediaType(strings.TrimSpace(part)); err == nil && mt == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
 // introspectToken performs the actual token introspection
 func (h *Handlers) introspectToken(tokenString, tokenTypeHint string) *IntrospectResponse {
 	// Try to find token in storage first
@@ -105,6 +173,11 @@ func (h *Handlers) buildIntrospectResponse(token *models.Token, tokenString stri
 		return &IntrospectResponse{Active: false}
 	}
 
+	// Tokens of a suspended client are inactive until it is re-enabled
+	if owner, err := h.storage.GetClientByID(token.ClientID); err == nil && owner != nil && owner.Disabled {
+		return &IntrospectResponse{Active: false}
+	}
+
 	// Get user info for username
 	username := ""
 	if token.UserID != "" {
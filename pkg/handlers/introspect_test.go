@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestIntrospectSignedResponse(t *testing.T) {
+	h, _, client, token := setupRevokeTest(t)
+
+	introspect := func(accept string) *httptest.ResponseRecorder {
+		form := "token=" + token.AccessToken + "&client_id=" + client.ID + "&client_secret=" + client.Secret
+		req := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(form))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		if accept != "" {
+			req.Header.Set(echo.HeaderAccept, accept)
+		}
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Introspect(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	rec := introspect("")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get(echo.HeaderContentType), echo.MIMEApplicationJSON)
+
+	rec = introspect("application/json;q=0.5, application/token-introspection+jwt")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/token-introspection+jwt", rec.Header().Get(echo.HeaderContentType))
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+
+	claims := &crypto.IntrospectionClaims{}
+	parsed, err := jwt.ParseWithClaims(rec.Body.String(), claims, func(*jwt.Token) (interface{}, error) {
+		return h.jwtManager.GetPublicKey(), nil
+	}, jwt.WithAudience(client.ID), jwt.WithIssuer(h.config.Issuer))
+	require.NoError(t, err)
+	assert.Equal(t, crypto.IntrospectionJWTType, parsed.Header["typ"])
+
+	introspection, ok := claims.TokenIntrospection.(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, true, introspection["active"])
+	assert.Equal(t, client.ID, introspection["client_id"])
+}
+
+func TestIntrospectResourceServerAudience(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	token := models.NewToken("at-orders", "", client.ID, "user-1", "openid orders:read", 60)
+	require.NoError(t, store.CreateToken(token))
+
+	register := func(id string, scopes ...string) {
+		require.NoError(t, store.CreateClient(&models.Client{
+			ID: id, Secret: id + "-secret", ResourceServer: true, ResourceScopes: scopes,
+		}))
+	}
+	register("orders-api", "orders:read", "orders:write")
+	register("billing-api", "billing:read")
+
+	introspect := func(clientID, secret string) *IntrospectResponse {
+		form := "token=" + token.AccessToken + "&client_id=" + clientID + "&client_secret=" + secret
+		req := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(form))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Introspect(echo.New().NewContext(req, rec)))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var response IntrospectResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		return &response
+	}
+
+	orders := introspect("orders-api", "orders-api-secret")
+	assert.True(t, orders.Active)
+	assert.Equal(t, "orders:read", orders.Scope)
+	assert.Equal(t, "orders-api", orders.Aud)
+
+	// The billing API has no claim on an orders token
+	billing := introspect("billing-api", "billing-api-secret")
+	assert.False(t, billing.Active)
+	assert.Empty(t, billing.ClientID)
+
+	// The client the token was issued to still sees everything
+	owner := introspect(client.ID, client.Secret)
+	assert.True(t, owner.Active)
+	assert.Equal(t, "openid orders:read", owner.Scope)
+}
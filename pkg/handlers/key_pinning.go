@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+// pinnableKey returns the stored key a client may be pinned to, or an error when the
+// key does not exist, is not a signing key or has expired
+func pinnableKey(store storage.Storage, keyID string) (*models.SigningKey, error) {
+	key, err := store.GetSigningKey(keyID)
+	if err != nil || key == nil {
+		return nil, fmt.Errorf("signing key %s not found", keyID)
+	}
+	if !key.IsSigningKey() {
+		return nil, fmt.Errorf("key %s is not a signing key", keyID)
+	}
+	if key.IsExpired() {
+		return nil, fmt.Errorf("signing key %s has expired", keyID)
+	}
+	return key, nil
+}
+
+// jwtManagerFor returns the JWT manager that signs tokens for client: the shared
+// manager, or one using the key the client is pinned to, issuing ID tokens with the
+// configured lifetime. A pinned client whose key is no longer usable gets an error
+// rather than a token signed with another key.
+func (h *Handlers) jwtManagerFor(client *models.Client) (*crypto.JWTManager, error) {
+	if client == nil || client.SigningKeyID == "" {
+		return h.jwtManager.WithIDTokenExpiry(h.idTokenExpiry()).WithMinimalIDTokens(client != nil && client.MinimalIDToken), nil
+	}
+	key, err := pinnableKey(h.storage, client.SigningKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("client %s is pinned to an unusable key: %w", client.ID, err)
+	}
+	privateKey, err := crypto.ParsePrivateKeyFromPEM(key.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse pinned key %s: %w", key.ID, err)
+	}
+	return h.jwtManager.WithSigningKey(key.KID, privateKey).WithIDTokenExpiry(h.idTokenExpiry()).WithMinimalIDTokens(client.MinimalIDToken), nil
+}
+
+// signingKeyIDFor returns the ID of the stored key that signs tokens for a client
+func (h *Handlers) signingKeyIDFor(clientID string) string {
+	if client, err := h.storage.GetClientByID(clientID); err == nil && client != nil && client.SigningKeyID != "" {
+		return client.SigningKeyID
+	}
+	if key, err := h.storage.GetActiveSigningKey(); err == nil && key != nil {
+		return key.ID
+	}
+	return ""
+}
+
+// pinnedKeyIDs returns the IDs of keys that clients are pinned to
+func pinnedKeyIDs(store storage.Storage) (map[string]bool, error) {
+	clients, err := store.GetAllClients()
+	if err != nil {
+		return nil, err
+	}
+	pinned := make(map[string]bool)
+	for _, client := range clients {
+		if client.SigningKeyID != "" {
+			pinned[client.SigningKeyID] = true
+		}
+	}
+	return pinned, nil
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestClientKeyPinning(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	admin := NewAdminHandler(store, h.config, nil)
+	adminToken, err := crypto.GenerateAdminToken("keymaster", admin.adminSecret)
+	require.NoError(t, err)
+
+	material, err := crypto.GenerateSigningKeyWithCert(30)
+	require.NoError(t, err)
+	key := &models.SigningKey{ID: "pinned", KID: material.KID, Algorithm: "RS256",
+		PrivateKey: material.PrivateKeyPEM, PublicKey: material.PublicKeyPEM,
+		CreatedAt: material.NotBefore, ExpiresAt: material.NotAfter}
+	require.NoError(t, store.CreateSigningKey(key))
+	require.NoError(t, store.CreateSigningKey(&models.SigningKey{ID: "old", KID: "kid-old",
+		CreatedAt: time.Now().AddDate(0, -3, 0), ExpiresAt: time.Now().AddDate(0, 0, -1)}))
+
+	call := func(method string, handler echo.HandlerFunc, id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("If-Match", "*")
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+adminToken)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		require.NoError(t, handler(c))
+		return rec
+	}
+
+	// Expired and unknown keys cannot be pinned
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPut, admin.UpdateClient, client.ID, `{"signing_key_id":"old"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPut, admin.UpdateClient, client.ID, `{"signing_key_id":"missing"}`).Code)
+
+	rec := call(http.MethodPut, admin.UpdateClient, client.ID, `{"signing_key_id":"pinned"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	client, err = store.GetClientByID(client.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "pinned", client.SigningKeyID)
+
+	jm, err := h.jwtManagerFor(client)
+	require.NoError(t, err)
+	idToken, err := jm.GenerateIDToken(&models.User{ID: "user-1"}, client.ID, "", "openid")
+	require.NoError(t, err)
+
+	publicKey, err := crypto.ParsePublicKeyFromPEM(material.PublicKeyPEM)
+	require.NoError(t, err)
+	parsed, err := jwt.Parse(idToken, func(*jwt.Token) (interface{}, error) { return publicKey, nil })
+	require.NoError(t, err)
+	assert.Equal(t, material.KID, parsed.Header["kid"])
+
+	issued, err := h.newToken(client.ID, "", "openid")
+	require.NoError(t, err)
+	assert.Equal(t, "pinned", issued.SigningKeyID)
+
+	// A pinned key cannot be deleted while clients still use it
+	assert.Equal(t, http.StatusConflict, call(http.MethodDelete, admin.DeleteKey, "pinned", "").Code)
+
+	// Once the pinned key expires the client gets no tokens rather than ones signed with another key
+	key.ExpiresAt = time.Now().Add(-time.Minute)
+	require.NoError(t, store.UpdateSigningKey(key))
+	_, err = h.jwtManagerFor(client)
+	assert.Error(t, err)
+
+	rec = call(http.MethodPut, admin.UpdateClient, client.ID, `{"signing_key_id":""}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	client, err = store.GetClientByID(client.ID)
+	require.NoError(t, err)
+	jm, err = h.jwtManagerFor(client)
+	require.NoError(t, err)
+	assert.Equal(t, h.jwtManager.KeyID(), jm.KeyID())
+}
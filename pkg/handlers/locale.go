@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// preferredLocales returns the end-user's preferred languages, in order: the
+// ui_locales of the authorization request, then the browser's Accept-Language.
+func preferredLocales(c echo.Context, authSession *models.AuthSession) []string {
+	var locales []string
+	if authSession != nil {
+		locales = append(locales, authSession.UILocales...)
+	}
+	for _, part := range strings.Split(c.Request().Header.Get("Accept-Language"), ",") {
+		tag, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag != "" && tag != "*" {
+			locales = append(locales, tag)
+		}
+	}
+	return locales
+}
+
+// localizedValue picks the variant of a value best matching the preferred
+// locales, falling back to the default value.
+func localizedValue(values map[string]string, fallback string, locales []string) string {
+	if tag, ok := matchLocale(values, locales); ok {
+		return values[tag]
+	}
+	return fallback
+}
+
+// matchLocale returns the tag of the variant best matching the preferred locales.
+// An exact tag match wins, then a match on the primary language subtag
+// ("ja" for "ja-JP" and the reverse).
+func matchLocale(values map[string]string, locales []string) (string, bool) {
+	if len(values) == 0 {
+		return "", false
+	}
+	tags := make([]string, 0, len(values))
+	for tag := range values {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	for _, locale := range locales {
+		for _, tag := range tags {
+			if strings.EqualFold(tag, locale) {
+				return tag, true
+			}
+		}
+		language := primaryLanguage(locale)
+		for _, tag := range tags {
+			if strings.EqualFold(primaryLanguage(tag), language) {
+				return tag, true
+			}
+		}
+	}
+	return "", false
+}
+
+// primaryLanguage returns the primary language subtag of a language tag
+func primaryLanguage(tag string) string {
+	language, _, _ := strings.Cut(tag, "-")
+	return language
+}
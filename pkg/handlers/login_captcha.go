@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+)
+
+// loginFailureWindow is how long failed sign-in attempts count towards the CAPTCHA threshold
+const loginFailureWindow = 15 * time.Minute
+
+// captchaProvider describes a CAPTCHA service implementing the common siteverify protocol
+type captchaProvider struct {
+	ScriptURL     string // Widget script loaded by the login page
+	WidgetClass   string // CSS class of the element the widget renders into
+	ResponseField string // Form field the widget submits its response in
+	VerifyURL     string // Default server-side verification endpoint
+}
+
+// captchaProviders lists the supported login CAPTCHA services, keyed by config name
+var captchaProviders = map[string]captchaProvider{
+	"hcaptcha": {
+		ScriptURL:     "https://js.hcaptcha.com/1/api.js",
+		WidgetClass:   "h-captcha",
+		ResponseField: "h-captcha-response",
+		VerifyURL:     "https://api.hcaptcha.com/siteverify",
+	},
+	"recaptcha": {
+		ScriptURL:     "https://www.google.com/recaptcha/api.js",
+		WidgetClass:   "g-recaptcha",
+		ResponseField: "g-recaptcha-response",
+		VerifyURL:     "https://www.google.com/recaptcha/api/siteverify",
+	},
+	"turnstile": {
+		ScriptURL:     "https://challenges.cloudflare.com/turnstile/v0/api.js",
+		WidgetClass:   "cf-turnstile",
+		ResponseField: "cf-turnstile-response",
+		VerifyURL:     "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+	},
+}
+
+// loginCaptchaWidget is passed to the login template when a CAPTCHA must be solved
+type loginCaptchaWidget struct {
+	ScriptURL   string
+	WidgetClass string
+	SiteKey     string
+}
+
+// loginCaptchaProvider returns the configured CAPTCHA provider, if any
+func (h *Handlers) loginCaptchaProvider() (captchaProvider, bool) {
+	cfg := h.config.LoginCaptchaState()
+	provider, ok := captchaProviders[strings.ToLower(cfg.Provider)]
+	if !ok || cfg.SiteKey == "" {
+		return captchaProvider{}, false
+	}
+	if cfg.VerifyURL != "" {
+		provider.VerifyURL = cfg.VerifyURL
+	}
+	return provider, true
+}
+
+// loginCaptchaRequired reports whether sign-in from ip, or for username, must pass a
+// CAPTCHA because of earlier failed attempts
+func (h *Handlers) loginCaptchaRequired(ip, username string) bool {
+	if _, ok := h.loginCaptchaProvider(); !ok {
+		return false
+	}
+	threshold := h.config.LoginCaptchaState().AfterFailures
+	if threshold <= 0 {
+		return true
+	}
+	if h.loginFailures == nil {
+		return false
+	}
+	if h.loginFailures.Count(loginFailureIPKey(ip)) >= threshold {
+		return true
+	}
+	return username != "" && h.loginFailures.Count(loginFailureUserKey(username)) >= threshold
+}
+
+// loginCaptchaWidgetFor returns the widget to render for a login page, or nil
+func (h *Handlers) loginCaptchaWidgetFor(ip, username string) *loginCaptchaWidget {
+	provider, ok := h.loginCaptchaProvider()
+	if !ok || !h.loginCaptchaRequired(ip, username) {
+		return nil
+	}
+	return &loginCaptchaWidget{
+		ScriptURL:   provider.ScriptURL,
+		WidgetClass: provider.WidgetClass,
+		SiteKey:     h.config.LoginCaptchaState().SiteKey,
+	}
+}
+
+// verifyLoginCaptcha checks the CAPTCHA response submitted with a login form
+func (h *Handlers) verifyLoginCaptcha(formValue func(string) string, remoteIP string) error {
+	provider, _ := h.loginCaptchaProvider()
+	return verifyCaptcha(provider.VerifyURL, h.config.LoginCaptchaState().Secret, formValue(provider.ResponseField), remoteIP)
+}
+
+// recordLoginFailure counts a failed sign-in against the IP address and the username
+func (h *Handlers) recordLoginFailure(ip, username string) {
+	if h.loginFailures == nil {
+		return
+	}
+	h.loginFailures.Add(loginFailureIPKey(ip))
+	if username != "" {
+		h.loginFailures.Add(loginFailureUserKey(username))
+	}
+}
+
+// clearLoginFailures forgets failed attempts for a user after a successful sign-in
+func (h *Handlers) clearLoginFailures(username string) {
+	if h.loginFailures != nil {
+		h.loginFailures.Reset(loginFailureUserKey(username))
+	}
+}
+
+func loginFailureIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+func loginFailureUserKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
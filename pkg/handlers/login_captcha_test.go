@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestLoginCaptchaAfterFailures(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+
+	var verified []string
+	verifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		verified = append(verified, r.PostForm.Get("response"))
+		_, _ = w.Write([]byte(`{"success":` + strconv.FormatBool(r.PostForm.Get("response") == "solved") + `}`))
+	}))
+	defer verifier.Close()
+
+	h.config.LoginCaptcha = configstore.LoginCaptchaConfig{
+		Provider:      "hcaptcha",
+		SiteKey:       "site-key",
+		Secret:        "secret",
+		VerifyURL:     verifier.URL,
+		AfterFailures: 2,
+	}
+
+	hash, err := crypto.HashPassword("correct")
+	require.NoError(t, err)
+	require.NoError(t, store.CreateUser(&models.User{ID: "captcha-user", Username: "alice", PasswordHash: hash}))
+
+	login := func(password, captcha string) *httptest.ResponseRecorder {
+		form := url.Values{"username": {"alice"}, "password": {password}}
+		if captcha != "" {
+			form.Set("h-captcha-response", captcha)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Login(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	// Failures below the threshold neither show nor check a CAPTCHA
+	rec := login("wrong", "")
+	assert.Contains(t, rec.Body.String(), "Invalid username or password")
+	assert.NotContains(t, rec.Body.String(), "h-captcha")
+	rec = login("wrong", "")
+	assert.Contains(t, rec.Body.String(), "h-captcha")
+	assert.Empty(t, verified)
+
+	// Once required, the CAPTCHA is verified before the password
+	rec = login("correct", "")
+	assert.Contains(t, rec.Body.String(), "Please complete the CAPTCHA challenge")
+	rec = login("correct", "bogus")
+	assert.Contains(t, rec.Body.String(), "Please complete the CAPTCHA challenge")
+	assert.Equal(t, []string{"bogus"}, verified)
+
+	rec = login("correct", "solved")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Login successful")
+}
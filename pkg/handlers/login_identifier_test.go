@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storagetest"
+)
+
+func TestPasswordGrantLoginIdentifiers(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	client.GrantTypes = append(client.GrantTypes, GrantTypePassword)
+	require.NoError(t, store.UpdateClient(client))
+	user := storagetest.User(func(u *models.User) {
+		u.Username = "dana"
+		u.Email = "Dana@Example.com"
+		u.PhoneNumber = "+15550123"
+	})
+	storagetest.Create(t, store, user)
+
+	passwordGrant := func(username string) int {
+		form := url.Values{
+			"grant_type":    {GrantTypePassword},
+			"username":      {username},
+			"password":      {storagetest.Password},
+			"client_id":     {client.ID},
+			"client_secret": {client.Secret},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Token(echo.New().NewContext(req, rec)))
+		return rec.Code
+	}
+
+	// By default the username and then the email address are accepted, in any case
+	assert.Equal(t, http.StatusOK, passwordGrant("DANA"))
+	assert.Equal(t, http.StatusOK, passwordGrant("dana@example.com"))
+	assert.Equal(t, http.StatusUnauthorized, passwordGrant("+15550123"))
+	assert.Equal(t, "Username or email", h.loginIdentifierLabel())
+
+	h.config.LoginIdentifiers = []string{configstore.LoginIdentifierPhone, configstore.LoginIdentifierEmail}
+	assert.Equal(t, http.StatusOK, passwordGrant("+15550123"))
+	assert.Equal(t, http.StatusUnauthorized, passwordGrant("dana"))
+	assert.Equal(t, "Phone number or email", h.loginIdentifierLabel())
+
+	h.config.LoginIdentifiers = []string{"username", "email", "phone_number"}
+	assert.Equal(t, "Username, email or phone number", h.loginIdentifierLabel())
+}
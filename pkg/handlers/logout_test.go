@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/session"
+)
+
+func TestLogoutRequiresConfirmation(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	userSession := &models.UserSession{ID: "logout-session", UserID: "test-user",
+		AuthTime: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, store.CreateUserSession(userSession))
+	require.NoError(t, store.CreateToken(&models.Token{ID: "bound", AccessToken: "bound-at", RefreshToken: "bound-rt",
+		UserID: userSession.UserID, ClientID: client.ID, SessionID: userSession.ID,
+		ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now()}))
+
+	logout := func(method string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/logout", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		req.AddCookie(&http.Cookie{Name: session.UserSessionCookieName, Value: userSession.ID})
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.sessionManager.Middleware()(h.Logout)(echo.New().NewContext(req, rec)))
+		return rec
+	}
+	signedIn := func() bool {
+		s, err := store.GetUserSession(userSession.ID)
+		require.NoError(t, err)
+		return s != nil
+	}
+
+	// Following a link, or a cross-site form without the token, only asks for confirmation
+	rec := logout(http.MethodGet, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, signedIn())
+	assert.Equal(t, http.StatusOK, logout(http.MethodPost, nil).Code)
+	assert.Equal(t, http.StatusOK, logout(http.MethodPost, url.Values{"logout_token": {"forged"}}).Code)
+	assert.True(t, signedIn())
+	bound, _ := store.GetTokenByRefreshToken("bound-rt")
+	assert.NotNil(t, bound)
+
+	// Confirming on the page ends the session and revokes its tokens
+	match := regexp.MustCompile(`name="logout_token" value="([^"]+)"`).FindStringSubmatch(rec.Body.String())
+	require.Len(t, match, 2)
+	rec = logout(http.MethodPost, url.Values{"logout_token": {match[1]}})
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.False(t, signedIn())
+	bound, _ = store.GetTokenByRefreshToken("bound-rt")
+	assert.Nil(t, bound)
+}
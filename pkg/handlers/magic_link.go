@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+const (
+	defaultMagicLinkTTLMinutes = 15
+
+	// magicLinkJTINamespace scopes one-time link IDs in the replay cache
+	magicLinkJTINamespace = "magic-link"
+
+	magicLinkAuthMethod = "email"
+	magicLinkACR        = "urn:mace:incommon:iap:bronze"
+
+	// magicLinkSentMessage is shown whether or not the address belongs to an account,
+	// so the form cannot be used to discover registered emails
+	magicLinkSentMessage = "If an account exists for that email, a sign-in link is on its way."
+)
+
+// magicLinkEnabled reports whether passwordless email sign-in is offered
+func (h *Handlers) magicLinkEnabled() bool {
+	return h.config.MagicLinkState().Enabled && h.mailer != nil
+}
+
+func (h *Handlers) magicLinkTTL() time.Duration {
+	minutes := h.config.MagicLinkState().TTLMinutes
+	if minutes <= 0 {
+		minutes = defaultMagicLinkTTLMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// requestMagicLink emails a one-time sign-in link to the account registered under
+// the submitted address. The link resumes the pending authorization session.
+func (h *Handlers) requestMagicLink(c echo.Context, authSessionID string) error {
+	if !h.magicLinkEnabled() {
+		return h.renderLoginPageWithError(c, authSessionID, "Email sign-in is not available")
+	}
+
+	if flow, _, _, err := h.currentLoginStep(authSessionID); err != nil || !isPasswordOnlyFlow(flow) {
+		return h.renderLoginPageWithError(c, authSessionID, "Email sign-in is not available for this application")
+	}
+
+	email := canonicalEmail(h.config, c.FormValue("email"))
+	user, err := h.storage.GetUserByEmail(email)
+	if err != nil || user == nil || !user.CanAuthenticate() {
+		h.logAudit(models.AuditActionLoginFailed, models.AuditActorUser, email,
+			"user", "", models.AuditStatusFailure,
+			c.RealIP(), c.Request().UserAgent(),
+			map[string]interface{}{"reason": "user not found or unavailable", "method": magicLinkAuthMethod})
+		return h.renderLoginTemplate(c, authSessionID, "", magicLinkSentMessage)
+	}
+
+	token, err := h.jwtManager.GenerateMagicLinkToken(user.ID, authSessionID, h.magicLinkTTL())
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to create sign-in link")
+	}
+	link := h.config.Issuer + "/login/magic?token=" + url.QueryEscape(token)
+	body := "Use the link below to sign in. It can be used once and expires in " +
+		h.magicLinkTTL().String() + ".\r\n\r\n" + link + "\r\n\r\n" +
+		"If you did not request this email, you can ignore it.\r\n"
+	if err := h.mailer.Send(user.Email, "Your sign-in link", body); err != nil {
+		return h.renderLoginPageWithError(c, authSessionID, "Failed to send sign-in link, please try again")
+	}
+
+	h.logAudit(models.AuditActionMagicLinkSent, models.AuditActorUser, user.Username,
+		"user", user.ID, models.AuditStatusSuccess,
+		c.RealIP(), c.Request().UserAgent(), nil)
+
+	return h.renderLoginTemplate(c, authSessionID, "", magicLinkSentMessage)
+}
+
+// MagicLinkLogin completes sign-in from an emailed link (GET /login/magic)
+func (h *Handlers) MagicLinkLogin(c echo.Context) error {
+	if !h.magicLinkEnabled() {
+		return jsonError(c, http.StatusNotFound, ErrorInvalidRequest, "Email sign-in is not available")
+	}
+
+	claims, err := h.jwtManager.ValidateMagicLinkToken(c.QueryParam("token"))
+	if err != nil {
+		return h.renderLoginPageWithError(c, "", "This sign-in link is invalid or has expired")
+	}
+	authSessionID := claims.AuthSessionID
+
+	// Recording the jti spends the link, so a forwarded or replayed link fails
+	// once the first click has signed in
+	fresh, err := h.storage.RecordJTI(magicLinkJTINamespace, claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to verify sign-in link")
+	}
+	if !fresh {
+		h.logAudit(models.AuditActionLoginFailed, models.AuditActorUser, claims.Subject,
+			"user", claims.Subject, models.AuditStatusFailure,
+			c.RealIP(), c.Request().UserAgent(),
+			map[string]interface{}{"reason": "magic link reused", "method": magicLinkAuthMethod})
+		return h.renderLoginPageWithError(c, authSessionID, "This sign-in link has already been used")
+	}
+
+	user, err := h.storage.GetUserByID(claims.Subject)
+	if err != nil || user == nil {
+		return h.renderLoginPageWithError(c, authSessionID, "This sign-in link is invalid or has expired")
+	}
+
+	// The client's sign-in flow may have changed since the link was sent
+	if flow, _, _, err := h.currentLoginStep(authSessionID); err != nil || !isPasswordOnlyFlow(flow) {
+		return h.renderLoginPageWithError(c, authSessionID, "Email sign-in is not available for this application")
+	}
+
+	// Possession of the mailbox is a single factor, so only "email" is reported in amr
+	return h.completeLogin(c, user, authSessionID, magicLinkAuthMethod, magicLinkACR, []string{magicLinkAuthMethod}, false)
+}
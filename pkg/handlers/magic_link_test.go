@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+type fakeMailer struct {
+	to, subject, body string
+}
+
+func (m *fakeMailer) Send(to, subject, body string) error {
+	m.to, m.subject, m.body = to, subject, body
+	return nil
+}
+
+func TestMagicLinkLogin(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	mailer := &fakeMailer{}
+	h.mailer = mailer
+	h.config.MagicLink.Enabled = true
+
+	user := &models.User{ID: "magic-user", Username: "magic", Email: "magic@example.com"}
+	require.NoError(t, store.CreateUser(user))
+	authSession := &models.AuthSession{
+		ID:          "magic-auth-session",
+		ClientID:    client.ID,
+		RedirectURI: client.RedirectURIs[0],
+		Scope:       "openid",
+		CreatedAt:   time.Now(),
+		ExpiresAt:   time.Now().Add(10 * time.Minute),
+	}
+	require.NoError(t, store.CreateAuthSession(authSession))
+
+	requestLink := func(email string) *httptest.ResponseRecorder {
+		form := url.Values{"action": {"magic_link"}, "email": {email}}
+		req := httptest.NewRequest(http.MethodPost, "/login?auth_session="+authSession.ID, strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Login(echo.New().NewContext(req, rec)))
+		return rec
+	}
+	followLink := func(link string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, link, nil)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.MagicLinkLogin(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	// Unknown addresses get the same response and no mail
+	rec := requestLink("nobody@example.com")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "If an account exists")
+	assert.Empty(t, mailer.to)
+
+	rec = requestLink(user.Email)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "If an account exists")
+	require.Equal(t, user.Email, mailer.to)
+
+	link := regexp.MustCompile(`https://\S+/login/magic\?token=\S+`).FindString(mailer.body)
+	require.NotEmpty(t, link, mailer.body)
+
+	rec = followLink(link)
+	require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+	assert.Equal(t, "/consent?auth_session="+authSession.ID, rec.Header().Get("Location"))
+
+	updated, err := store.GetAuthSession(authSession.ID)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, updated.UserID)
+	assert.Equal(t, []string{"email"}, updated.AMR)
+	assert.Equal(t, "email", updated.AuthenticationMethod)
+
+	t.Run("link is single use", func(t *testing.T) {
+		rec := followLink(link)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "already been used")
+	})
+
+	t.Run("tampered link is rejected", func(t *testing.T) {
+		rec := followLink(link + "x")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid or has expired")
+	})
+
+	t.Run("disabled per deployment", func(t *testing.T) {
+		h.config.MagicLink.Enabled = false
+		defer func() { h.config.MagicLink.Enabled = true }()
+
+		rec := followLink(link)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
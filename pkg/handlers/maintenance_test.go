@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+)
+
+func TestMaintenanceGuard(t *testing.T) {
+	h, _, client, _ := setupRevokeTest(t)
+	token := func() *httptest.ResponseRecorder {
+		form := "grant_type=client_credentials&client_id=" + client.ID + "&client_secret=" + client.Secret
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.MaintenanceGuard()(h.Token)(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, token().Code)
+
+	h.config.SetMaintenance(configstore.MaintenanceConfig{Enabled: true, RetryAfterSeconds: 120})
+	rec := token()
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "120", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), ErrorTemporarilyUnavailable)
+
+	h.config.SetMaintenance(configstore.MaintenanceConfig{Enabled: true, ExemptClients: []string{client.ID}})
+	assert.Equal(t, http.StatusOK, token().Code)
+}
+
+func TestUpdateMaintenancePersistsToConfigStore(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	configStore := configstore.NewJSONConfigStore(filepath.Join(t.TempDir(), "config.json"))
+	require.NoError(t, configStore.SaveConfig(context.Background(), configstore.DefaultConfig()))
+	admin := NewAdminHandler(store, h.config, nil)
+	admin.SetConfigStore(configStore)
+	adminToken, err := crypto.GenerateAdminToken("qa-admin", admin.adminSecret)
+	require.NoError(t, err)
+
+	update := func(bearer string) *httptest.ResponseRecorder {
+		body := `{"enabled":true,"exempt_clients":["` + client.ID + `"]}`
+		req := httptest.NewRequest(http.MethodPut, "/api/admin/maintenance", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if bearer != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		require.NoError(t, admin.UpdateMaintenance(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, update("").Code)
+	assert.False(t, h.config.MaintenanceState().Enabled)
+
+	require.Equal(t, http.StatusOK, update(adminToken).Code)
+	assert.True(t, h.config.MaintenanceState().Exempt(client.ID))
+	saved, err := configStore.GetConfig(context.Background())
+	require.NoError(t, err)
+	assert.True(t, saved.MaintenanceState().Enabled, "maintenance mode survives a reload or restart")
+	assert.Equal(t, []string{client.ID}, saved.MaintenanceState().ExemptClients)
+}
+
+func TestReadyReportsDraining(t *testing.T) {
+	h, _, _, _ := setupRevokeTest(t)
+	ready := func() int {
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Ready(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/readyz", nil), rec)))
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, ready())
+	h.BeginDrain()
+	assert.Equal(t, http.StatusServiceUnavailable, ready())
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"log"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+// passwordHashParams are the password_hashing settings, with defaults for the
+// values left out
+func passwordHashParams(cfg *configstore.ConfigData) crypto.PasswordHashParams {
+	settings := cfg.PasswordHashingState()
+	params := crypto.PasswordHashParams{
+		Algorithm:  settings.Algorithm,
+		BcryptCost: settings.BcryptCost,
+	}
+	if settings.Argon2MemoryKiB > 0 {
+		params.Argon2Memory = uint32(settings.Argon2MemoryKiB)
+	}
+	if settings.Argon2Iterations > 0 {
+		params.Argon2Iterations = uint32(settings.Argon2Iterations)
+	}
+	if settings.Argon2Parallelism > 0 && settings.Argon2Parallelism <= 255 {
+		params.Argon2Parallelism = uint8(settings.Argon2Parallelism)
+	}
+	return params
+}
+
+// hashPassword hashes a new password with the configured algorithm
+func hashPassword(cfg *configstore.ConfigData, password string) (string, error) {
+	return crypto.HashPasswordWith(password, passwordHashParams(cfg))
+}
+
+// checkPassword reports whether password is the user's password. A hash made
+// with another algorithm or a lower cost than configured is replaced while the
+// password is known, so existing users move to the preferred algorithm as they
+// sign in. Only the hash is written, and only while it is still the one that
+// was checked, so a password changed meanwhile is not overwritten. Failing to
+// store the new hash does not fail the sign-in.
+func checkPassword(store storage.Storage, cfg *configstore.ConfigData, user *models.User, password string) bool {
+	if !crypto.ValidatePassword(password, user.PasswordHash) {
+		return false
+	}
+	params := passwordHashParams(cfg)
+	if !crypto.PasswordNeedsRehash(user.PasswordHash, params) {
+		return true
+	}
+	hash, err := crypto.HashPasswordWith(password, params)
+	if err != nil {
+		log.Printf("Failed to rehash password of user %s: %v", user.ID, err)
+		return true
+	}
+	stored, err := store.UpdatePasswordHash(user.ID, user.PasswordHash, hash)
+	if err != nil {
+		log.Printf("Failed to store rehashed password of user %s: %v", user.ID, err)
+	} else if stored {
+		user.PasswordHash = hash
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storagetest"
+)
+
+func TestPasswordsRehashedOnSignIn(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	client.GrantTypes = append(client.GrantTypes, GrantTypePassword)
+	require.NoError(t, store.UpdateClient(client))
+	user := storagetest.User(func(u *models.User) { u.Username = "erin" })
+	storagetest.Create(t, store, user)
+
+	passwordGrant := func(password string) int {
+		form := url.Values{
+			"grant_type":    {GrantTypePassword},
+			"username":      {"erin"},
+			"password":      {password},
+			"client_id":     {client.ID},
+			"client_secret": {client.Secret},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Token(echo.New().NewContext(req, rec)))
+		return rec.Code
+	}
+	storedHash := func() string {
+		stored, err := store.GetUserByID(user.ID)
+		require.NoError(t, err)
+		return stored.PasswordHash
+	}
+	bcryptHash := storedHash()
+
+	// Hashes matching the configuration are left alone
+	assert.Equal(t, http.StatusOK, passwordGrant(storagetest.Password))
+	assert.Equal(t, bcryptHash, storedHash())
+
+	h.config.PasswordHashing = configstore.PasswordHashingConfig{
+		Algorithm:        crypto.PasswordHashArgon2id,
+		Argon2MemoryKiB:  64,
+		Argon2Iterations: 1,
+	}
+	assert.Equal(t, http.StatusUnauthorized, passwordGrant("wrong"))
+	assert.Equal(t, bcryptHash, storedHash(), "a wrong password does not rehash")
+
+	assert.Equal(t, http.StatusOK, passwordGrant(storagetest.Password))
+	argonHash := storedHash()
+	assert.True(t, strings.HasPrefix(argonHash, "$argon2id$v=19$m=64,t=1,p=1$"), argonHash)
+	assert.Equal(t, http.StatusOK, passwordGrant(storagetest.Password))
+	assert.Equal(t, argonHash, storedHash())
+
+	// Raising a cost upgrades hashes the same way, and going back to bcrypt works too
+	h.config.PasswordHashing.Argon2Iterations = 2
+	assert.Equal(t, http.StatusOK, passwordGrant(storagetest.Password))
+	assert.True(t, strings.HasPrefix(storedHash(), "$argon2id$v=19$m=64,t=2,p=1$"))
+	h.config.PasswordHashing = configstore.PasswordHashingConfig{BcryptCost: 4}
+	assert.Equal(t, http.StatusOK, passwordGrant(storagetest.Password))
+	assert.True(t, strings.HasPrefix(storedHash(), "$2a$"))
+
+	// New passwords set by administrators use the configured algorithm
+	h.config.PasswordHashing = configstore.PasswordHashingConfig{Algorithm: crypto.PasswordHashArgon2id, Argon2MemoryKiB: 64, Argon2Iterations: 1}
+	hash, err := hashPassword(h.config, "new-password")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$"))
+}
+
+func TestRehashKeepsPasswordChangedMeanwhile(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+	user := storagetest.User(func(u *models.User) { u.Username = "frank" })
+	storagetest.Create(t, store, user)
+	h.config.PasswordHashing = configstore.PasswordHashingConfig{Algorithm: crypto.PasswordHashArgon2id, Argon2MemoryKiB: 64, Argon2Iterations: 1}
+
+	// The sign-in read the user before an administrator set a new password
+	signingIn, err := store.GetUserByID(user.ID)
+	require.NoError(t, err)
+	changed, err := store.GetUserByID(user.ID)
+	require.NoError(t, err)
+	changed.PasswordHash, err = hashPassword(h.config, "new-password")
+	require.NoError(t, err)
+	require.NoError(t, store.UpdateUser(changed))
+
+	assert.True(t, checkPassword(store, h.config, signingIn, storagetest.Password))
+	stored, err := store.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, changed.PasswordHash, stored.PasswordHash, "the old password's rehash does not overwrite the new one")
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const redirectHostLookupTimeout = 5 * time.Second
+
+// urlShortenerHosts are redirect services whose targets can change after registration
+var urlShortenerHosts = map[string]bool{
+	"bit.ly": true, "bl.ink": true, "buff.ly": true, "cutt.ly": true, "goo.gl": true,
+	"is.gd": true, "ow.ly": true, "rb.gy": true, "rebrand.ly": true, "s.id": true,
+	"shorturl.at": true, "t.co": true, "t.ly": true, "tiny.cc": true, "tinyurl.com": true,
+}
+
+// redirectHostResolver resolves redirect URI hosts during scans; replaced in tests
+var redirectHostResolver = func(ctx context.Context, host string) ([]string, error) {
+	return net.DefaultResolver.LookupHost(ctx, host)
+}
+
+// redirectURISafetyError rejects redirect URIs that are open-redirect risks: embedded
+// credentials, wildcards and URL shorteners. It returns an empty string when the URI is acceptable.
+func redirectURISafetyError(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return "redirect_uri must be a valid URI: " + uri
+	}
+	if parsed.User != nil {
+		return "redirect_uri must not contain credentials: " + uri
+	}
+	if strings.Contains(parsed.Host, "*") {
+		return "redirect_uri must not contain wildcards: " + uri
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	for shortener := range urlShortenerHosts {
+		if host == shortener || strings.HasSuffix(host, "."+shortener) {
+			return "redirect_uri must not use a URL shortener: " + uri
+		}
+	}
+	return ""
+}
+
+// ScanRedirectURIs checks that every client's http(s) redirect hosts still resolve and
+// records the problems found on the client, so abandoned domains that could be
+// re-registered by an attacker show up in the admin API. It returns the number of
+// clients with findings.
+func (h *Handlers) ScanRedirectURIs() (int, error) {
+	clients, err := h.storage.GetAllClients()
+	if err != nil {
+		return 0, err
+	}
+
+	resolved := make(map[string]bool)
+	flagged := 0
+	for _, client := range clients {
+		findings := []string{}
+		for _, uri := range client.RedirectURIs {
+			if msg := redirectURISafetyError(uri); msg != "" {
+				findings = append(findings, msg)
+				continue
+			}
+			parsed, _ := url.Parse(uri)
+			host := parsed.Hostname()
+			if (parsed.Scheme != "https" && parsed.Scheme != "http") || isLocalhost(host) || net.ParseIP(host) != nil {
+				continue
+			}
+			ok, seen := resolved[host]
+			if !seen {
+				ctx, cancel := context.WithTimeout(context.Background(), redirectHostLookupTimeout)
+				addrs, lookupErr := redirectHostResolver(ctx, host)
+				cancel()
+				ok = lookupErr == nil && len(addrs) > 0
+				resolved[host] = ok
+			}
+			if !ok {
+				findings = append(findings, "redirect host does not resolve: "+host)
+			}
+		}
+
+		now := time.Now()
+		client.RedirectURIsCheckedAt = &now
+		client.RedirectURIFindings = nil
+		if len(findings) > 0 {
+			client.RedirectURIFindings = findings
+			flagged++
+		}
+		if err := h.storage.UpdateClient(client); err != nil {
+			log.Printf("Warning: Failed to record redirect URI scan for client %s: %v", client.ID, err)
+		}
+	}
+	return flagged, nil
+}
+
+// StartRedirectURIScan periodically scans client redirect URIs
+func (h *Handlers) StartRedirectURIScan(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if flagged, err := h.ScanRedirectURIs(); err != nil {
+				log.Printf("Warning: Failed to scan redirect URIs: %v", err)
+			} else if flagged > 0 {
+				log.Printf("Redirect URI scan flagged %d clients", flagged)
+			}
+		}
+	}()
+}
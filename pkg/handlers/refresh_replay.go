@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// errRefreshTokenReplaced aborts a refresh that lost the race to another request
+// for the same refresh token
+var errRefreshTokenReplaced = errors.New("refresh token already replaced")
+
+const (
+	// refreshReplayWait bounds how long a losing refresh waits for the winning
+	// request, possibly on another replica, to store the new token
+	refreshReplayWait  = 2 * time.Second
+	refreshReplayPoll  = 100 * time.Millisecond
+	refreshReplayError = "Refresh token has already been used"
+)
+
+// refreshGrace returns how long a used refresh token keeps answering with its replacement
+func (h *Handlers) refreshGrace() time.Duration {
+	seconds := h.config.JWTState().RefreshGraceSeconds
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// replayRefreshTokenGrant answers a refresh with an already used refresh token. Within
+// the grace window the client receives the token pair the first use was exchanged for,
+// so that a mobile app refreshing from several threads at once stays signed in.
+func (h *Handlers) replayRefreshTokenGrant(c echo.Context, client *models.Client, oldToken *models.Token) error {
+	grace := h.refreshGrace()
+	if grace <= 0 || time.Since(oldToken.ReplacedAt) > grace {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, refreshReplayError)
+	}
+
+	replacement, err := h.findReplacementToken(oldToken)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to get token")
+	}
+	if replacement == nil {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, refreshReplayError)
+	}
+
+	// The replacement is bound to the same session and dies with it
+	if h.refreshSessionEnded(replacement) {
+		_ = h.storage.DeleteToken(replacement.ID)
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "Refresh token session has ended")
+	}
+
+	user, err := h.storage.GetUserByID(replacement.UserID)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to get user")
+	}
+	if user == nil || !user.CanAuthenticate() {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "User account is disabled")
+	}
+
+	jwtManager, err := h.jwtManagerFor(client)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate ID token")
+	}
+	idToken, err := jwtManager.GenerateIDToken(user, client.ID, "", replacement.Scope)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate ID token")
+	}
+
+	h.logAudit(models.AuditActionTokenIssued, models.AuditActorUser, user.Username,
+		"token", replacement.AccessToken[:min(16, len(replacement.AccessToken))],
+		models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"grant_type": "refresh_token", "client_id": client.ID, "scope": replacement.Scope, "replayed": true})
+
+	response := TokenResponse{
+		AccessToken:  replacement.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(time.Until(replacement.ExpiresAt).Seconds()),
+		RefreshToken: replacement.RefreshToken,
+		IDToken:      idToken,
+	}
+	h.addTokenResponseParams(&response, client, user, replacement.Scope, GrantTypeRefreshToken)
+	return c.JSON(http.StatusOK, response)
+}
+
+// findReplacementToken returns the token oldToken was refreshed into, waiting briefly
+// for it to be stored when the refresh that replaced it is still in flight
+func (h *Handlers) findReplacementToken(oldToken *models.Token) (*models.Token, error) {
+	deadline := time.Now().Add(refreshReplayWait)
+	for {
+		tokens, err := h.storage.ListTokens(oldToken.ClientID, oldToken.UserID, true)
+		if err != nil {
+			return nil, err
+		}
+		for _, token := range tokens {
+			if token.ID == oldToken.ReplacedBy {
+				return token, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, nil
+		}
+		time.Sleep(refreshReplayPoll)
+	}
+}
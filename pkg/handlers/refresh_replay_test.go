@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestConcurrentRefreshesShareOneRotation(t *testing.T) {
+	h, store, client, token := setupRevokeTest(t)
+	h.config.JWT.RefreshGraceSeconds = 30
+	require.NoError(t, store.CreateUser(&models.User{ID: token.UserID, Username: "racer", Email: "racer@example.com"}))
+
+	const clients = 5
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, clients)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = refreshTokenRequest(t, h, client, token.RefreshToken)
+		}(i)
+	}
+	wg.Wait()
+
+	var first TokenResponse
+	for i, rec := range responses {
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var resp TokenResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		if i == 0 {
+			first = resp
+			continue
+		}
+		assert.Equal(t, first.AccessToken, resp.AccessToken, "every concurrent refresh should get the same pair")
+		assert.Equal(t, first.RefreshToken, resp.RefreshToken)
+	}
+
+	tokens, err := store.ListTokens(client.ID, token.UserID, false)
+	require.NoError(t, err)
+	assert.Len(t, tokens, 2, "only one replacement token should be stored")
+
+	// The new refresh token rotates as usual
+	rec := refreshTokenRequest(t, h, client, first.RefreshToken)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+}
+
+func TestReusedRefreshTokenRejectedWithoutGrace(t *testing.T) {
+	h, store, client, token := setupRevokeTest(t)
+	require.NoError(t, store.CreateUser(&models.User{ID: token.UserID, Username: "reuser", Email: "reuser@example.com"}))
+
+	rec := refreshTokenRequest(t, h, client, token.RefreshToken)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = refreshTokenRequest(t, h, client, token.RefreshToken)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrorInvalidGrant)
+}
+
+func TestReplayedRefreshTokenEndsWithSession(t *testing.T) {
+	h, store, client, token := setupRevokeTest(t)
+	h.config.JWT.RefreshGraceSeconds = 30
+	require.NoError(t, store.CreateUser(&models.User{ID: token.UserID, Username: "signedout", Email: "signedout@example.com"}))
+	userSession := &models.UserSession{ID: "session-1", UserID: token.UserID, AuthTime: time.Now(), ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now()}
+	require.NoError(t, store.CreateUserSession(userSession))
+	require.NoError(t, store.CreateToken(&models.Token{
+		ID: "session-token", AccessToken: "session-access-token", RefreshToken: "session-refresh-token", TokenType: "Bearer",
+		Scope: "openid", UserID: token.UserID, ClientID: client.ID, SessionID: userSession.ID,
+		CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour),
+	}))
+
+	rec := refreshTokenRequest(t, h, client, "session-refresh-token")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var first TokenResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &first))
+
+	// Within the grace window the used token would answer with its replacement,
+	// but not once the user has signed out
+	require.NoError(t, store.DeleteUserSession(userSession.ID))
+	rec = refreshTokenRequest(t, h, client, "session-refresh-token")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "session has ended")
+	replacement, err := store.GetTokenByRefreshToken(first.RefreshToken)
+	require.NoError(t, err)
+	assert.Nil(t, replacement, "the replacement dies with the session")
+}
+
+func TestRefreshTokenFamilyLimits(t *testing.T) {
+	h, store, client, token := setupRevokeTest(t)
+	require.NoError(t, store.CreateUser(&models.User{ID: token.UserID, Username: "limited", Email: "limited@example.com"}))
+	client.RefreshTokenMaxUses = 2
+	require.NoError(t, store.UpdateClient(client))
+
+	refreshToken := token.RefreshToken
+	for i := 0; i < 2; i++ {
+		rec := refreshTokenRequest(t, h, client, refreshToken)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var resp TokenResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		refreshToken = resp.RefreshToken
+	}
+	rec := refreshTokenRequest(t, h, client, refreshToken)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrorInvalidGrant)
+	assert.Contains(t, rec.Body.String(), "limit of 2 refreshes")
+}
+
+func TestRefreshTokenIdleTimeout(t *testing.T) {
+	client := &models.Client{RefreshTokenIdleTimeout: 3600}
+	token := &models.Token{CreatedAt: time.Now()}
+
+	assert.Empty(t, refreshFamilyLimitReason(client, token, time.Now().Add(30*time.Minute)))
+	reason := refreshFamilyLimitReason(client, token, time.Now().Add(2*time.Hour))
+	assert.Contains(t, reason, "3600 seconds without use")
+
+	// Each refresh issues a new token, which restarts the idle clock
+	token = &models.Token{CreatedAt: time.Now().Add(90 * time.Minute), RefreshCount: 1}
+	assert.Empty(t, refreshFamilyLimitReason(client, token, time.Now().Add(2*time.Hour)))
+}
+
+func refreshTokenRequest(t *testing.T, h *Handlers, client *models.Client, refreshToken string) *httptest.ResponseRecorder {
+	form := "grant_type=refresh_token&refresh_token=" + refreshToken +
+		"&client_id=" + client.ID + "&client_secret=" + client.Secret
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec := httptest.NewRecorder()
+	require.NoError(t, h.Token(echo.New().NewContext(req, rec)))
+	return rec
+}
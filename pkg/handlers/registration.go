@@ -27,7 +27,7 @@ const (
 // and OpenID Connect Dynamic Client Registration 1.0
 func (h *Handlers) Register(c echo.Context) error {
 	// 1. Check if registration is enabled in config
-	if !h.config.Registration.Enabled {
+	if _, enabled := h.config.RegistrationEndpoint(); !enabled {
 		return c.JSON(http.StatusForbidden, models.ClientRegistrationError{
 			Error:            "registration_not_supported",
 			ErrorDescription: "Dynamic client registration is not enabled on this server",
@@ -35,7 +35,7 @@ func (h *Handlers) Register(c echo.Context) error {
 	}
 
 	// 2. Validate initial access token if required
-	if h.config.Registration.RequireInitialAccessToken {
+	if h.config.RegistrationState().RequireInitialAccessToken {
 		token := extractBearerToken(c)
 		if token == "" {
 			return c.JSON(http.StatusUnauthorized, models.ClientRegistrationError{
@@ -68,16 +68,11 @@ func (h *Handlers) Register(c echo.Context) error {
 			})
 		}
 
-		// Mark token as used (we'll update it after successful client creation)
-		defer func() {
-			now := time.Now()
-			initialToken.Used = true
-			initialToken.UsedAt = &now
-			// UsedBy will be set after client creation
-		}()
-
-		// Store the initial token for later update
+		// Store the initial token so the registration can be counted against it
 		c.Set("initial_access_token", initialToken)
+	} else if status, quotaErr := h.checkOpenRegistration(c); quotaErr != nil {
+		// Open registration is subject to quotas and the optional CAPTCHA
+		return c.JSON(status, *quotaErr)
 	}
 
 	// 3. Parse registration request
@@ -93,6 +88,9 @@ func (h *Handlers) Register(c echo.Context) error {
 	if err := h.validateRegistrationRequest(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, *err)
 	}
+	if err := h.validateSectorIdentifier(&req, false); err != nil {
+		return c.JSON(http.StatusBadRequest, *err)
+	}
 
 	// 4. Create the client from the request
 	client, err := h.createClientFromRequest(&req)
@@ -103,7 +101,34 @@ func (h *Handlers) Register(c echo.Context) error {
 		})
 	}
 
-	// 5. Store the client
+	// New clients wait in the admin approval queue when the policy requires review
+	if h.config.RegistrationState().Policy.RequireApproval {
+		client.Status = models.ClientStatusPending
+	} else {
+		client.Status = models.ClientStatusActive
+	}
+
+	// 5. Count the registration against the initial access token (if applicable).
+	// Storage claims the use atomically, so concurrent registrations cannot exceed
+	// the token's limit.
+	if initialToken, ok := c.Get("initial_access_token").(*models.InitialAccessToken); ok {
+		used, err := h.storage.UseInitialAccessToken(initialToken.Token, client.ID,
+			h.maxClientsPerInitialAccessToken(), time.Now())
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, models.ClientRegistrationError{
+				Error:            "server_error",
+				ErrorDescription: "Failed to validate initial access token",
+			})
+		}
+		if !used {
+			return c.JSON(http.StatusUnauthorized, models.ClientRegistrationError{
+				Error:            "invalid_token",
+				ErrorDescription: "Invalid or already used initial access token",
+			})
+		}
+	}
+
+	// 6. Store the client
 	if err := h.storage.CreateClient(client); err != nil {
 		return c.JSON(http.StatusInternalServerError, models.ClientRegistrationError{
 			Error:            "server_error",
@@ -111,13 +136,6 @@ func (h *Handlers) Register(c echo.Context) error {
 		})
 	}
 
-	// 6. Mark initial access token as used (if applicable)
-	if initialToken, ok := c.Get("initial_access_token").(*models.InitialAccessToken); ok {
-		initialToken.UsedBy = client.ID
-		_ = h.storage.UpdateInitialAccessToken(initialToken)
-		// Ignore error - client was already created successfully
-	}
-
 	// 7. Build and return the registration response
 	response := h.buildRegistrationResponse(client)
 
@@ -125,7 +143,7 @@ func (h *Handlers) Register(c echo.Context) error {
 	h.logAudit(models.AuditActionClientRegistered, models.AuditActorClient, client.ID,
 		"client", client.ID, models.AuditStatusSuccess,
 		c.RealIP(), c.Request().UserAgent(),
-		map[string]interface{}{"client_name": client.Name, "grant_types": client.GrantTypes})
+		map[string]interface{}{"client_name": client.Name, "grant_types": client.GrantTypes, "status": client.Status})
 
 	return c.JSON(http.StatusCreated, response)
 }
@@ -181,6 +199,24 @@ func (h *Handlers) validateRegistrationRequest(req *models.ClientRegistrationReq
 		return err
 	}
 
+	// Validate request object algorithms
+	if err := validateRequestObjectMetadata(req); err != nil {
+		return err
+	}
+
+	// Only algorithms the server signs with or verifies can be registered
+	if err := validateSigningAlgMetadata(req); err != nil {
+		return err
+	}
+
+	// Introspection responses are signed with the server's RSA key
+	if req.IntrospectionSignedResponseAlg != "" && !contains(introspectionSigningAlgs, req.IntrospectionSignedResponseAlg) {
+		return &models.ClientRegistrationError{
+			Error:            models.ErrInvalidClientMetadata,
+			ErrorDescription: "unsupported introspection_signed_response_alg: " + req.IntrospectionSignedResponseAlg,
+		}
+	}
+
 	// Validate JWKS - can't have both jwks and jwks_uri
 	if req.JWKS != nil && req.JWKSURI != "" {
 		return &models.ClientRegistrationError{
@@ -214,9 +250,96 @@ func (h *Handlers) validateRegistrationRequest(req *models.ClientRegistrationReq
 		}
 	}
 
+	// Apply the server's registration policy
+	if err := h.validateRegistrationPolicy(req); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// validateRegistrationPolicy checks a registration request against the configured registration policy
+func (h *Handlers) validateRegistrationPolicy(req *models.ClientRegistrationRequest) *models.ClientRegistrationError {
+	policy := h.config.RegistrationState().Policy
+
+	if len(policy.AllowedGrantTypes) > 0 {
+		grantTypes := req.GrantTypes
+		if len(grantTypes) == 0 {
+			grantTypes = []string{grantTypeAuthorizationCode}
+		}
+		for _, gt := range grantTypes {
+			if !contains(policy.AllowedGrantTypes, gt) {
+				return &models.ClientRegistrationError{
+					Error:            models.ErrInvalidClientMetadata,
+					ErrorDescription: "grant_type not permitted by registration policy: " + gt,
+				}
+			}
+		}
+	}
+
+	if len(policy.AllowedScopes) > 0 {
+		scope := req.Scope
+		if scope == "" {
+			scope = "openid"
+		}
+		for _, s := range strings.Fields(scope) {
+			if !contains(policy.AllowedScopes, s) {
+				return &models.ClientRegistrationError{
+					Error:            models.ErrInvalidClientMetadata,
+					ErrorDescription: "scope not permitted by registration policy: " + s,
+				}
+			}
+		}
+	}
+
+	if len(policy.AllowedRedirectHosts) > 0 {
+		for _, uri := range req.RedirectURIs {
+			parsed, err := url.Parse(uri)
+			if err != nil || !redirectHostAllowed(parsed.Hostname(), policy.AllowedRedirectHosts) {
+				return &models.ClientRegistrationError{
+					Error:            models.ErrInvalidRedirectURI,
+					ErrorDescription: "redirect_uri host not permitted by registration policy: " + uri,
+				}
+			}
+		}
+	}
+
+	if policy.RequireContacts && len(req.Contacts) == 0 {
+		return &models.ClientRegistrationError{
+			Error:            models.ErrInvalidClientMetadata,
+			ErrorDescription: "contacts is required by registration policy",
+		}
+	}
+
+	return nil
+}
+
+// redirectHostAllowed matches a host against exact names and "*.domain" wildcard patterns
+func redirectHostAllowed(host string, allowed []string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(pattern)
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// maxClientsPerInitialAccessToken returns how many clients one initial access token may register
+func (h *Handlers) maxClientsPerInitialAccessToken() int {
+	if limit := h.config.RegistrationState().Policy.MaxClientsPerInitialAccessToken; limit > 0 {
+		return limit
+	}
+	return 1
+}
+
 // validateRedirectURI validates a redirect URI per OAuth 2.0 spec
 func validateRedirectURI(uri string, applicationType string) string {
 	// Check if empty
@@ -240,6 +363,11 @@ func validateRedirectURI(uri string, applicationType string) string {
 		return "redirect_uri must not contain a fragment: " + uri
 	}
 
+	// Reject credentials, wildcards and URL shorteners
+	if errMsg := redirectURISafetyError(uri); errMsg != "" {
+		return errMsg
+	}
+
 	// For web applications (default), must be HTTPS unless localhost
 	if applicationType == "" || applicationType == applicationTypeWeb {
 		if parsedURI.Scheme != "https" {
@@ -316,6 +444,24 @@ func (h *Handlers) validateGrantTypesAndResponseTypes(req *models.ClientRegistra
 		}
 	}
 
+	// Grant and response types turned off server-wide can't be registered
+	for _, gt := range grantTypes {
+		if !h.config.GrantsState().GrantTypeEnabled(gt) {
+			return &models.ClientRegistrationError{
+				Error:            models.ErrInvalidClientMetadata,
+				ErrorDescription: "grant_type is disabled on this server: " + gt,
+			}
+		}
+	}
+	for _, rt := range responseTypes {
+		if !h.config.GrantsState().ResponseTypeEnabled(rt) {
+			return &models.ClientRegistrationError{
+				Error:            models.ErrInvalidClientMetadata,
+				ErrorDescription: "response_type is disabled on this server: " + rt,
+			}
+		}
+	}
+
 	// Validate consistency: if response_type includes "code", must have authorization_code grant
 	hasCode := false
 	for _, rt := range responseTypes {
@@ -373,15 +519,7 @@ func (h *Handlers) validateTokenEndpointAuthMethod(req *models.ClientRegistratio
 		return nil // Will use default
 	}
 
-	validMethods := map[string]bool{
-		"none":                true, // Public clients
-		"client_secret_post":  true, // Client secret in POST body
-		"client_secret_basic": true, // Client secret in Basic auth header
-		"client_secret_jwt":   true, // JWT signed with client secret
-		"private_key_jwt":     true, // JWT signed with private key
-	}
-
-	if !validMethods[req.TokenEndpointAuthMethod] {
+	if !contains(tokenEndpointAuthMethods, req.TokenEndpointAuthMethod) {
 		return &models.ClientRegistrationError{
 			Error:            models.ErrInvalidClientMetadata,
 			ErrorDescription: "invalid token_endpoint_auth_method: " + req.TokenEndpointAuthMethod,
@@ -409,6 +547,42 @@ func (h *Handlers) validateTokenEndpointAuthMethod(req *models.ClientRegistratio
 	return nil
 }
 
+// validateSigningAlgMetadata checks the signing algorithms a client registers for
+// ID tokens, UserInfo responses and client assertions against those implemented
+func validateSigningAlgMetadata(req *models.ClientRegistrationRequest) *models.ClientRegistrationError {
+	if req.IDTokenSignedResponseAlg != "" && !contains(idTokenSigningAlgs, req.IDTokenSignedResponseAlg) {
+		return &models.ClientRegistrationError{
+			Error:            models.ErrInvalidClientMetadata,
+			ErrorDescription: "unsupported id_token_signed_response_alg: " + req.IDTokenSignedResponseAlg,
+		}
+	}
+	// UserInfo responses are always plain JSON
+	if req.UserInfoSignedResponseAlg != "" {
+		return &models.ClientRegistrationError{
+			Error:            models.ErrInvalidClientMetadata,
+			ErrorDescription: "unsupported userinfo_signed_response_alg: " + req.UserInfoSignedResponseAlg,
+		}
+	}
+	if alg := req.TokenEndpointAuthSigningAlg; alg != "" {
+		if !contains(clientAssertionSigningAlgs, alg) {
+			return &models.ClientRegistrationError{
+				Error:            models.ErrInvalidClientMetadata,
+				ErrorDescription: "unsupported token_endpoint_auth_signing_alg: " + alg,
+			}
+		}
+		// client_secret_jwt is signed with HMAC and private_key_jwt with a key pair
+		hmac := strings.HasPrefix(alg, "HS")
+		if (req.TokenEndpointAuthMethod == authMethodClientSecretJWT && !hmac) ||
+			(req.TokenEndpointAuthMethod == authMethodPrivateKeyJWT && hmac) {
+			return &models.ClientRegistrationError{
+				Error:            models.ErrInvalidClientMetadata,
+				ErrorDescription: "token_endpoint_auth_signing_alg " + alg + " cannot be used with " + req.TokenEndpointAuthMethod,
+			}
+		}
+	}
+	return nil
+}
+
 // validateURIs validates the format of various URI fields
 func (h *Handlers) validateURIs(req *models.ClientRegistrationRequest) *models.ClientRegistrationError {
 	uriFields := map[string]string{
@@ -421,6 +595,19 @@ func (h *Handlers) validateURIs(req *models.ClientRegistrationRequest) *models.C
 		"initiate_login_uri":    req.InitiateLoginURI,
 	}
 
+	// Language-tagged variants follow the same rules as the default value
+	localizedURIs := map[string]map[string]string{
+		"client_uri": req.ClientURILocalized,
+		"logo_uri":   req.LogoURILocalized,
+		"tos_uri":    req.TosURILocalized,
+		"policy_uri": req.PolicyURILocalized,
+	}
+	for fieldName, values := range localizedURIs {
+		for tag, uri := range values {
+			uriFields[fieldName+"#"+tag] = uri
+		}
+	}
+
 	for fieldName, uri := range uriFields {
 		if uri == "" {
 			continue
@@ -435,7 +622,7 @@ func (h *Handlers) validateURIs(req *models.ClientRegistrationRequest) *models.C
 		}
 
 		// Most URIs should use HTTPS
-		if fieldName != "logo_uri" && parsed.Scheme != "https" && !isLocalhost(parsed.Host) {
+		if !strings.HasPrefix(fieldName, "logo_uri") && parsed.Scheme != "https" && !isLocalhost(parsed.Host) {
 			return &models.ClientRegistrationError{
 				Error:            models.ErrInvalidClientMetadata,
 				ErrorDescription: fieldName + " should use https",
@@ -548,6 +735,13 @@ func (h *Handlers) createClientFromRequest(req *models.ClientRegistrationRequest
 		PolicyURI: req.PolicyURI,
 		TosURI:    req.TosURI,
 
+		// Language-tagged metadata
+		ClientNameLocalized: req.ClientNameLocalized,
+		LogoURILocalized:    req.LogoURILocalized,
+		ClientURILocalized:  req.ClientURILocalized,
+		PolicyURILocalized:  req.PolicyURILocalized,
+		TosURILocalized:     req.TosURILocalized,
+
 		// JWK fields
 		JWKSURI:             req.JWKSURI,
 		JWKS:                req.JWKS,
@@ -564,6 +758,9 @@ func (h *Handlers) createClientFromRequest(req *models.ClientRegistrationRequest
 		UserInfoEncryptedResponseAlg: req.UserInfoEncryptedResponseAlg,
 		UserInfoEncryptedResponseEnc: req.UserInfoEncryptedResponseEnc,
 
+		// Introspection response preferences
+		IntrospectionSignedResponseAlg: req.IntrospectionSignedResponseAlg,
+
 		// Request Object preferences
 		RequestObjectSigningAlg:    req.RequestObjectSigningAlg,
 		RequestObjectEncryptionAlg: req.RequestObjectEncryptionAlg,
@@ -622,7 +819,8 @@ func (h *Handlers) isConfidentialClient(req *models.ClientRegistrationRequest) b
 // buildRegistrationResponse creates the registration response
 func (h *Handlers) buildRegistrationResponse(client *models.Client) models.ClientRegistrationResponse {
 	// Build the registration_client_uri
-	registrationClientURI := h.config.Issuer + h.config.Registration.Endpoint + "/" + client.ID
+	endpoint, _ := h.registrationURL(h.config.Issuer)
+	registrationClientURI := endpoint + "/" + client.ID
 
 	response := models.ClientRegistrationResponse{
 		Client:                  *client,
@@ -642,7 +840,7 @@ func (h *Handlers) buildRegistrationResponse(client *models.Client) models.Clien
 // and OpenID Connect Dynamic Client Registration 1.0 Section 4 (Client Read Request)
 func (h *Handlers) GetClientConfiguration(c echo.Context) error {
 	// 1. Check if registration is enabled
-	if !h.config.Registration.Enabled {
+	if _, enabled := h.config.RegistrationEndpoint(); !enabled {
 		return c.JSON(http.StatusForbidden, models.ClientRegistrationError{
 			Error:            "registration_not_supported",
 			ErrorDescription: "Dynamic client registration is not enabled on this server",
@@ -711,7 +909,7 @@ func extractBearerToken(c echo.Context) string {
 // Implements RFC 7592 Section 3 (Client Update Request)
 func (h *Handlers) UpdateClientConfiguration(c echo.Context) error {
 	// 1. Check if registration is enabled
-	if !h.config.Registration.Enabled {
+	if _, enabled := h.config.RegistrationEndpoint(); !enabled {
 		return c.JSON(http.StatusForbidden, models.ClientRegistrationError{
 			Error:            "registration_not_supported",
 			ErrorDescription: "Dynamic client registration is not enabled on this server",
@@ -766,6 +964,10 @@ func (h *Handlers) UpdateClientConfiguration(c echo.Context) error {
 	if validationErr := h.validateRegistrationRequest(&req); validationErr != nil {
 		return c.JSON(http.StatusBadRequest, *validationErr)
 	}
+	// Re-fetch the sector identifier document, it may have changed since registration
+	if validationErr := h.validateSectorIdentifier(&req, true); validationErr != nil {
+		return c.JSON(http.StatusBadRequest, *validationErr)
+	}
 
 	// 8. Update client while preserving certain fields
 	updatedClient, err := h.createClientFromRequest(&req)
@@ -780,6 +982,21 @@ func (h *Handlers) UpdateClientConfiguration(c echo.Context) error {
 	updatedClient.ID = existingClient.ID
 	updatedClient.Secret = existingClient.Secret
 	updatedClient.RegistrationAccessToken = existingClient.RegistrationAccessToken
+	updatedClient.Status = existingClient.Status
+	updatedClient.Disabled = existingClient.Disabled
+	updatedClient.AuthFlow = existingClient.AuthFlow
+	updatedClient.SigningKeyID = existingClient.SigningKeyID
+	updatedClient.ResourceServer = existingClient.ResourceServer
+	updatedClient.ResourceScopes = existingClient.ResourceScopes
+	updatedClient.MinimalIDToken = existingClient.MinimalIDToken
+	updatedClient.FirstParty = existingClient.FirstParty
+	updatedClient.FirstPartyScopes = existingClient.FirstPartyScopes
+	updatedClient.InstanceBinding = existingClient.InstanceBinding
+	updatedClient.InstanceAttestation = existingClient.InstanceAttestation
+	updatedClient.TokenResponseParams = existingClient.TokenResponseParams
+	updatedClient.RefreshTokenMaxUses = existingClient.RefreshTokenMaxUses
+	updatedClient.RefreshTokenIdleTimeout = existingClient.RefreshTokenIdleTimeout
+	updatedClient.LastUsedAt = existingClient.LastUsedAt
 	updatedClient.CreatedAt = existingClient.CreatedAt
 	updatedClient.UpdatedAt = time.Now()
 
@@ -800,7 +1017,7 @@ func (h *Handlers) UpdateClientConfiguration(c echo.Context) error {
 // Implements RFC 7592 Section 4 (Client Delete Request)
 func (h *Handlers) DeleteClientConfiguration(c echo.Context) error {
 	// 1. Check if registration is enabled
-	if !h.config.Registration.Enabled {
+	if _, enabled := h.config.RegistrationEndpoint(); !enabled {
 		return c.JSON(http.StatusForbidden, models.ClientRegistrationError{
 			Error:            "registration_not_supported",
 			ErrorDescription: "Dynamic client registration is not enabled on this server",
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/qrcode"
+)
+
+const (
+	// defaultBootstrapLinkBase is the deep link native apps and devices register for
+	// when the request names none
+	defaultBootstrapLinkBase = "openid-register://bootstrap"
+
+	defaultBootstrapTTL = 24 * time.Hour
+	maxBootstrapTTL     = 30 * 24 * time.Hour
+	bootstrapQRScale    = 8 // Pixels per QR module
+)
+
+// RegistrationBootstrap is what a native app or device needs to register itself:
+// where to discover the server and the initial access token to register with
+type RegistrationBootstrap struct {
+	Issuer               string `json:"issuer"`
+	DiscoveryURL         string `json:"discovery_url"`
+	RegistrationEndpoint string `json:"registration_endpoint"`
+	InitialAccessToken   string `json:"initial_access_token"`
+}
+
+// CreateRegistrationBootstrap issues an initial access token and returns it with the
+// discovery URL as a JSON payload, a deep link and a QR code of the deep link
+// (POST /api/registration/bootstrap), so apps and devices can be onboarded by
+// scanning or opening a link
+func (h *AdminHandler) CreateRegistrationBootstrap(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	var req struct {
+		ExpiresInHours int    `json:"expires_in_hours"` // Default: 24
+		LinkBase       string `json:"link_base"`        // Deep link the app handles (default: openid-register://bootstrap)
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	endpoint, enabled := h.config.RegistrationEndpoint()
+	if !enabled {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Dynamic client registration is disabled"})
+	}
+
+	ttl := defaultBootstrapTTL
+	if req.ExpiresInHours != 0 {
+		ttl = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+	if ttl <= 0 || ttl > maxBootstrapTTL {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "expires_in_hours must be between 1 and 720"})
+	}
+
+	linkBase := defaultBootstrapLinkBase
+	if req.LinkBase != "" {
+		linkBase = req.LinkBase
+	}
+	link, err := url.Parse(linkBase)
+	if err != nil || link.Scheme == "" || link.Fragment != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "link_base must be an absolute URL without a fragment"})
+	}
+
+	value, err := crypto.GenerateRandomString(crypto.DefaultOpaqueTokenLength)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate initial access token"})
+	}
+	now := time.Now()
+	token := &models.InitialAccessToken{
+		Token:     value,
+		IssuedBy:  actor,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	if err := h.store.CreateInitialAccessToken(token); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store initial access token"})
+	}
+
+	payload := RegistrationBootstrap{
+		Issuer:               h.config.Issuer,
+		DiscoveryURL:         h.config.Issuer + "/.well-known/openid-configuration",
+		RegistrationEndpoint: h.config.Issuer + endpoint,
+		InitialAccessToken:   token.Token,
+	}
+	query := link.Query()
+	query.Set("discovery_url", payload.DiscoveryURL)
+	query.Set("initial_access_token", payload.InitialAccessToken)
+	link.RawQuery = query.Encode()
+	deepLink := link.String()
+
+	code, err := qrcode.Encode([]byte(deepLink))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Deep link is too long for a QR code"})
+	}
+	image, err := code.PNG(bootstrapQRScale)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to render QR code"})
+	}
+
+	h.logAdminAudit(models.AuditActionAdminBootstrapIssued, models.AuditActorAdmin, token.IssuedBy,
+		"initial_access_token", token.Token[:8], models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"expires_at": token.ExpiresAt})
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"payload":    payload,
+		"expires_at": token.ExpiresAt,
+		"deep_link":  deepLink,
+		"qr_code":    "data:image/png;base64," + base64.StdEncoding.EncodeToString(image),
+	})
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestCreateRegistrationBootstrap(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+	admin := NewAdminHandler(store, h.config, nil)
+	adminToken, err := crypto.GenerateAdminToken("onboarding", admin.adminSecret)
+	require.NoError(t, err)
+
+	bearer := "Bearer " + adminToken
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/registration/bootstrap", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, bearer)
+		rec := httptest.NewRecorder()
+		require.NoError(t, admin.CreateRegistrationBootstrap(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	bearer = ""
+	assert.Equal(t, http.StatusUnauthorized, create(`{}`).Code, "an admin token is required")
+	bearer = "Bearer " + adminToken
+
+	assert.Equal(t, http.StatusConflict, create(`{}`).Code, "registration is disabled")
+
+	h.config.SetRegistrationEndpoint(true, "")
+	assert.Equal(t, http.StatusBadRequest, create(`{"expires_in_hours": 1000}`).Code)
+	assert.Equal(t, http.StatusBadRequest, create(`{"link_base": "not a link"}`).Code)
+
+	rec := create(`{"expires_in_hours": 2, "link_base": "https://app.example.com/onboard?src=admin"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var response struct {
+		Payload  RegistrationBootstrap `json:"payload"`
+		DeepLink string                `json:"deep_link"`
+		QRCode   string                `json:"qr_code"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, "https://example.com/.well-known/openid-configuration", response.Payload.DiscoveryURL)
+	assert.Equal(t, "https://example.com/register", response.Payload.RegistrationEndpoint)
+	assert.True(t, strings.HasPrefix(response.QRCode, "data:image/png;base64,"))
+
+	link, err := url.Parse(response.DeepLink)
+	require.NoError(t, err)
+	assert.Equal(t, "admin", link.Query().Get("src"))
+	assert.Equal(t, response.Payload.DiscoveryURL, link.Query().Get("discovery_url"))
+	assert.Equal(t, response.Payload.InitialAccessToken, link.Query().Get("initial_access_token"))
+
+	stored, err := store.GetInitialAccessToken(response.Payload.InitialAccessToken)
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.False(t, stored.Used)
+	assert.Equal(t, "onboarding", stored.IssuedBy)
+
+	logs, err := store.GetAuditLogs(models.AuditFilter{Action: models.AuditActionAdminBootstrapIssued})
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "onboarding", logs[0].Actor)
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/outbound"
+)
+
+const (
+	// CaptchaTokenHeader carries the CAPTCHA response for open registration requests
+	CaptchaTokenHeader = "X-Captcha-Token"
+
+	registrationQuotaWindow    = 1 * time.Hour
+	registrationGlobalQuotaKey = "*"
+	captchaVerifyTimeout       = 10 * time.Second
+
+	// clientUsageUpdateInterval throttles how often a client's last-used time is written
+	clientUsageUpdateInterval = 1 * time.Hour
+	defaultDormantAfterDays   = 90
+)
+
+// checkOpenRegistration applies anti-abuse controls to registration requests that are
+// not authorized by an initial access token: per-IP and global hourly quotas and the
+// optional CAPTCHA check. It returns a non-nil error response when the request is refused.
+func (h *Handlers) checkOpenRegistration(c echo.Context) (int, *models.ClientRegistrationError) {
+	quotas := h.config.RegistrationState().Quotas
+	if h.registrationLimiter != nil {
+		// The per-IP quota goes first, so requests it refuses do not use up the global one
+		if !h.registrationLimiter.Allow(c.RealIP(), quotas.MaxPerIPPerHour) ||
+			!h.registrationLimiter.Allow(registrationGlobalQuotaKey, quotas.MaxPerHour) {
+			h.logRateLimited(c, "registration.quota")
+			return http.StatusTooManyRequests, &models.ClientRegistrationError{
+				Error:            "too_many_requests",
+				ErrorDescription: "Registration quota exceeded, try again later",
+			}
+		}
+	}
+
+	if h.config.RegistrationState().Captcha.VerifyURL != "" {
+		if err := h.verifyRegistrationCaptcha(c.Request().Header.Get(CaptchaTokenHeader), c.RealIP()); err != nil {
+			return http.StatusForbidden, &models.ClientRegistrationError{
+				Error:            "invalid_request",
+				ErrorDescription: "CAPTCHA verification failed",
+			}
+		}
+	}
+
+	return 0, nil
+}
+
+// verifyRegistrationCaptcha checks a CAPTCHA response with the configured siteverify endpoint
+func (h *Handlers) verifyRegistrationCaptcha(token, remoteIP string) error {
+	captcha := h.config.RegistrationState().Captcha
+	return verifyCaptcha(captcha.VerifyURL, captcha.Secret, token, remoteIP)
+}
+
+// verifyCaptcha checks a CAPTCHA response with a siteverify endpoint, as implemented
+// by reCAPTCHA, hCaptcha and Turnstile
+func verifyCaptcha(verifyURL, secret, token, remoteIP string) error {
+	if token == "" {
+		return fmt.Errorf("captcha token missing")
+	}
+
+	httpClient := outbound.NewClient(outbound.Configured, captchaVerifyTimeout)
+	resp, err := httpClient.PostForm(verifyURL, url.Values{
+		"secret":   {secret},
+		"response": {token},
+		"remoteip": {remoteIP},
+	})
+	if err != nil {
+		return fmt.Errorf("captcha verification request failed: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close() // Best effort close
+	}()
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result); err != nil {
+		return fmt.Errorf("invalid captcha verification response")
+	}
+	if !result.Success {
+		return fmt.Errorf("captcha rejected")
+	}
+	return nil
+}
+
+// markClientUsed records that a client took part in an authorization or token request.
+// Writes are throttled so busy clients do not cause a storage update per request.
+func (h *Handlers) markClientUsed(client *models.Client) {
+	now := time.Now()
+	if client.LastUsedAt != nil && now.Sub(*client.LastUsedAt) < clientUsageUpdateInterval {
+		return
+	}
+	client.LastUsedAt = &now
+	// Only the usage time is written, so concurrent admin edits of the client are kept
+	_ = h.storage.TouchClient(client.ID, now) // Best effort, usage tracking must not fail the request
+}
+
+// ExpireUnusedClients deletes dynamically registered clients that were never used
+// within the configured expiry period. It returns the number of clients deleted.
+func (h *Handlers) ExpireUnusedClients() (int, error) {
+	expiryHours := h.config.RegistrationState().Quotas.UnusedClientExpiryHours
+	if expiryHours <= 0 {
+		return 0, nil
+	}
+
+	clients, err := h.storage.GetAllClients()
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-time.Duration(expiryHours) * time.Hour)
+	deleted := 0
+	for _, client := range clients {
+		// client_id_issued_at is used rather than CreatedAt, which JSON storage does not persist
+		if !client.IsDynamicallyRegistered() || client.LastUsedAt != nil || client.ClientIDIssuedAt == 0 ||
+			time.Unix(client.ClientIDIssuedAt, 0).After(cutoff) {
+			continue
+		}
+		if err := h.storage.DeleteClient(client.ID); err != nil {
+			continue
+		}
+		deleted++
+		h.logAudit(models.AuditActionClientExpired, models.AuditActorSystem, "system",
+			"client", client.ID, models.AuditStatusSuccess, "", "",
+			map[string]interface{}{"client_id_issued_at": client.ClientIDIssuedAt})
+	}
+	return deleted, nil
+}
+
+// StartRegistrationCleanup periodically expires unused dynamically registered clients
+func (h *Handlers) StartRegistrationCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if deleted, err := h.ExpireUnusedClients(); err != nil {
+				log.Printf("Warning: Failed to expire unused clients: %v", err)
+			} else if deleted > 0 {
+				log.Printf("Expired %d unused dynamically registered clients", deleted)
+			}
+		}
+	}()
+}
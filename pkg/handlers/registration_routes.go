@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// registrationMount is where the registration endpoints are routed internally. The
+// router can't change once the server is running, so routeRegistration maps the
+// configured public path onto it on every request instead.
+const registrationMount = "/_registration"
+
+// registrationRoutedKey marks requests that arrived on the configured public path
+const registrationRoutedKey = "registration_routed"
+
+// MountRegistration serves dynamic client registration (RFC 7591/7592) on the
+// configured registration endpoint. The endpoint can be enabled, disabled or moved
+// by a config reload without restarting.
+func (h *Handlers) MountRegistration(e *echo.Echo) {
+	e.Pre(h.routeRegistration)
+
+	g := e.Group(registrationMount, requireRegistrationRouted, h.StorageGuard())
+	g.POST("", h.Register)
+	g.POST("/instances/challenge", h.InstanceChallenge)
+	g.POST("/instances", h.RegisterInstance)
+	g.GET("/:client_id", h.GetClientConfiguration)
+	g.PUT("/:client_id", h.UpdateClientConfiguration)
+	g.DELETE("/:client_id", h.DeleteClientConfiguration)
+}
+
+// routeRegistration rewrites requests for the configured registration path to registrationMount
+func (h *Handlers) routeRegistration(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		endpoint, enabled := h.config.RegistrationEndpoint()
+		if !enabled {
+			return next(c)
+		}
+		req := c.Request()
+		path := req.URL.Path
+		if path == endpoint || strings.HasPrefix(path, endpoint+"/") {
+			req.URL.Path = registrationMount + strings.TrimPrefix(path, endpoint)
+			req.URL.RawPath = ""
+			c.Set(registrationRoutedKey, true)
+		}
+		return next(c)
+	}
+}
+
+// requireRegistrationRouted hides registrationMount from requests that name it directly
+func requireRegistrationRouted(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if routed, _ := c.Get(registrationRoutedKey).(bool); !routed {
+			return echo.ErrNotFound
+		}
+		return next(c)
+	}
+}
+
+// IsRegistrationRequest reports whether c arrived on the registration endpoint
+func (h *Handlers) IsRegistrationRequest(c echo.Context) bool {
+	routed, _ := c.Get(registrationRoutedKey).(bool)
+	return routed
+}
+
+// registrationURL returns the absolute URL of the registration endpoint under baseURL
+func (h *Handlers) registrationURL(baseURL string) (string, bool) {
+	endpoint, enabled := h.config.RegistrationEndpoint()
+	return baseURL + endpoint, enabled
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/events"
+	"github.com/prasenjit-net/openid-golang/pkg/mail"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+// reportCheckInterval is how often the scheduler looks for reports that are due
+const reportCheckInterval = 15 * time.Minute
+
+// ReportRun describes the last time a report was sent
+type ReportRun struct {
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"` // Exclusive
+	SentAt      time.Time `json:"sent_at"`
+	Actor       string    `json:"actor"` // "system" for scheduled runs, else the admin who ran it
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// reportPeriod returns the most recent complete period of schedule before now:
+// the previous UTC day, or the previous Monday to Sunday
+func reportPeriod(schedule string, now time.Time) (start, end time.Time, err error) {
+	end = now.UTC().Truncate(24 * time.Hour)
+	switch schedule {
+	case configstore.ReportScheduleDaily:
+		return end.AddDate(0, 0, -1), end, nil
+	case configstore.ReportScheduleWeekly:
+		end = end.AddDate(0, 0, -((int(end.Weekday()) + 6) % 7))
+		return end.AddDate(0, 0, -7), end, nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("unknown report schedule %q", schedule)
+}
+
+// lastReportRun returns the newest run of the named report, or nil if it never ran
+func lastReportRun(store storage.Storage, name string) (*ReportRun, error) {
+	entries, err := store.GetAuditLogs(models.AuditFilter{Action: models.AuditActionReportSent, Subject: name, Limit: 1})
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	e := entries[0]
+	run := &ReportRun{SentAt: e.Timestamp, Actor: e.Actor, Status: string(e.Status)}
+	if s, ok := e.Details["period_start"].(string); ok {
+		run.PeriodStart, _ = time.Parse(time.RFC3339, s)
+	}
+	if s, ok := e.Details["period_end"].(string); ok {
+		run.PeriodEnd, _ = time.Parse(time.RFC3339, s)
+	}
+	if s, ok := e.Details["error"].(string); ok {
+		run.Error = s
+	}
+	return run, nil
+}
+
+// sendReport computes the report for [start, end), delivers it to every email
+// recipient and the webhook, and records the run in the audit log
+func sendReport(store storage.Storage, mailer mail.Sender, report configstore.ReportConfig, start, end time.Time, actorType models.AuditActorType, actor string) error {
+	err := deliverReport(store, mailer, report, start, end)
+
+	status := models.AuditStatusSuccess
+	details := map[string]interface{}{
+		"period_start": start.Format(time.RFC3339),
+		"period_end":   end.Format(time.RFC3339),
+		"format":       report.ReportFormat(),
+	}
+	if err != nil {
+		status = models.AuditStatusFailure
+		details["error"] = err.Error()
+	}
+	_ = store.CreateAuditLog(&models.AuditLog{
+		ID:         uuid.NewString(),
+		Timestamp:  time.Now().UTC(),
+		Action:     models.AuditActionReportSent,
+		Actor:      actor,
+		ActorType:  actorType,
+		Resource:   "report",
+		ResourceID: report.Name,
+		Status:     status,
+		Details:    details,
+	})
+	return err
+}
+
+func deliverReport(store storage.Storage, mailer mail.Sender, report configstore.ReportConfig, start, end time.Time) error {
+	if len(report.Email) == 0 && report.WebhookURL == "" {
+		return fmt.Errorf("report has no email recipients or webhook")
+	}
+	analytics, err := events.ComputeAnalytics(store, start, end)
+	if err != nil {
+		return err
+	}
+	body, contentType, err := events.FormatAnalytics(analytics, report.ReportFormat(), report.ReportMetrics())
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	if len(report.Email) > 0 {
+		if mailer == nil {
+			errs = append(errs, fmt.Errorf("smtp is not configured"))
+		} else {
+			subject := fmt.Sprintf("%s report %s: %s to %s", report.Schedule, report.Name,
+				start.Format(time.DateOnly), end.AddDate(0, 0, -1).Format(time.DateOnly))
+			for _, to := range report.Email {
+				if err := mailer.Send(to, subject, string(body)); err != nil {
+					errs = append(errs, fmt.Errorf("email to %s: %w", to, err))
+				}
+			}
+		}
+	}
+	if report.WebhookURL != "" {
+		if err := events.PostReport(context.Background(), report.WebhookURL, report.AuthHeader, contentType, body); err != nil {
+			errs = append(errs, fmt.Errorf("webhook: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SendDueReports sends every scheduled report whose latest complete period has
+// not been sent yet. A failed run is not retried; it can be repeated from the
+// admin API.
+func (h *Handlers) SendDueReports(now time.Time) {
+	for _, report := range h.config.ReportsState() {
+		start, end, err := reportPeriod(report.Schedule, now)
+		if err != nil {
+			log.Printf("Warning: Report %s: %v", report.Name, err)
+			continue
+		}
+		last, err := lastReportRun(h.storage, report.Name)
+		if err != nil {
+			log.Printf("Warning: Failed to look up the last run of report %s: %v", report.Name, err)
+			continue
+		}
+		if last != nil && !last.PeriodEnd.Before(end) {
+			continue
+		}
+		if err := sendReport(h.storage, h.mailer, report, start, end, models.AuditActorSystem, "system"); err != nil {
+			log.Printf("Warning: Failed to send report %s: %v", report.Name, err)
+		}
+	}
+}
+
+// StartReportScheduler sends scheduled reports in the background. Reports are
+// re-read on every check, so configuration reloads apply without a restart.
+func (h *Handlers) StartReportScheduler() {
+	go func() {
+		for {
+			time.Sleep(reportCheckInterval)
+			h.SendDueReports(time.Now())
+		}
+	}()
+}
+
+// findReport returns the configured report with the given name
+func (h *AdminHandler) findReport(name string) (configstore.ReportConfig, bool) {
+	for _, report := range h.config.ReportsState() {
+		if report.Name == name {
+			return report, true
+		}
+	}
+	return configstore.ReportConfig{}, false
+}
+
+// ListReports returns the scheduled reports and their last runs (GET /api/admin/reports)
+func (h *AdminHandler) ListReports(c echo.Context) error {
+	if _, ok := h.authenticatedAdmin(c); !ok {
+		return nil
+	}
+	reports := []map[string]interface{}{}
+	for _, report := range h.config.ReportsState() {
+		last, err := lastReportRun(h.store, report.Name)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get report runs"})
+		}
+		if report.AuthHeader != "" {
+			report.AuthHeader = "[REDACTED]"
+		}
+		reports = append(reports, map[string]interface{}{
+			"report":   report,
+			"last_run": last,
+		})
+	}
+	return c.JSON(http.StatusOK, reports)
+}
+
+// RunReport sends a report for its latest complete period now
+// (POST /api/admin/reports/:name/run)
+func (h *AdminHandler) RunReport(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	report, ok := h.findReport(c.Param("name"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Report not found"})
+	}
+	start, end, err := reportPeriod(report.Schedule, time.Now())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := sendReport(h.store, h.mailer, report, start, end, models.AuditActorAdmin, actor); err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": "Failed to send report: " + err.Error()})
+	}
+	last, err := lastReportRun(h.store, report.Name)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get report runs"})
+	}
+	return c.JSON(http.StatusOK, last)
+}
+
+// ExportAnalytics returns daily analytics as CSV or JSON
+// (GET /api/admin/reports/analytics).
+// Query params:
+//
+//	days    (int, default 7, max 366) — days up to and including today (UTC)
+//	format  ("csv" or "json", default json)
+//	metrics (comma-separated subset of logins, new_users, tokens, failures)
+func (h *AdminHandler) ExportAnalytics(c echo.Context) error {
+	if _, ok := h.authenticatedAdmin(c); !ok {
+		return nil
+	}
+	days := 7
+	if d := c.QueryParam("days"); d != "" {
+		if v, err := strconv.Atoi(d); err == nil && v > 0 {
+			days = v
+		}
+	}
+	if days > 366 {
+		days = 366
+	}
+	format := c.QueryParam("format")
+	if format == "" {
+		format = configstore.ReportFormatJSON
+	}
+	var requested []string
+	if m := c.QueryParam("metrics"); m != "" {
+		requested = strings.Split(m, ",")
+	}
+	metrics := configstore.ReportConfig{Metrics: requested}.ReportMetrics()
+	if len(metrics) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "No known metrics requested"})
+	}
+
+	end := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	analytics, err := events.ComputeAnalytics(h.store, end.AddDate(0, 0, -days), end)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to compute analytics: " + err.Error()})
+	}
+	body, contentType, err := events.FormatAnalytics(analytics, format, metrics)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if format == configstore.ReportFormatCSV {
+		c.Response().Header().Set(echo.HeaderContentDisposition,
+			fmt.Sprintf(`attachment; filename="analytics-%s.csv"`, end.AddDate(0, 0, -1).Format(time.DateOnly)))
+	}
+	return c.Blob(http.StatusOK, contentType, body)
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestReportPeriod(t *testing.T) {
+	// Thursday
+	now := time.Date(2026, 10, 15, 9, 30, 0, 0, time.UTC)
+
+	start, end, err := reportPeriod(configstore.ReportScheduleDaily, now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC), end)
+
+	start, end, err = reportPeriod(configstore.ReportScheduleWeekly, now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC), end)
+
+	// On Monday the week just ended is due
+	_, end, err = reportPeriod(configstore.ReportScheduleWeekly, time.Date(2026, 10, 12, 0, 5, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC), end)
+
+	_, _, err = reportPeriod("monthly", now)
+	assert.Error(t, err)
+}
+
+func TestScheduledReports(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+	mailer := &fakeMailer{}
+	h.mailer = mailer
+	var webhookBody, webhookType string
+	webhooks := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		webhookBody, webhookType = string(b), r.Header.Get("Content-Type")
+		webhooks++
+	}))
+	defer srv.Close()
+
+	now := time.Now().UTC()
+	yesterday := now.Truncate(24*time.Hour).AddDate(0, 0, -1)
+	require.NoError(t, store.CreateAuditLog(&models.AuditLog{ID: "login", Timestamp: yesterday.Add(time.Hour),
+		Action: models.AuditActionLogin, Status: models.AuditStatusSuccess}))
+
+	h.config.SetReports([]configstore.ReportConfig{
+		{Name: "daily-logins", Schedule: configstore.ReportScheduleDaily, Metrics: []string{"logins"}, Email: []string{"ops@example.com"}},
+		{Name: "daily-json", Schedule: configstore.ReportScheduleDaily, Format: configstore.ReportFormatJSON, WebhookURL: srv.URL},
+	})
+	h.SendDueReports(now)
+
+	assert.Equal(t, "ops@example.com", mailer.to)
+	assert.Equal(t, "daily report daily-logins: "+yesterday.Format(time.DateOnly)+" to "+yesterday.Format(time.DateOnly), mailer.subject)
+	assert.Equal(t, "date,logins\n"+yesterday.Format(time.DateOnly)+",1\ntotal,1\n", mailer.body)
+	assert.Equal(t, 1, webhooks)
+	assert.Equal(t, "application/json", webhookType)
+	assert.Contains(t, webhookBody, `"logins": 1`)
+
+	// A period is sent once
+	mailer.to = ""
+	h.SendDueReports(now)
+	assert.Empty(t, mailer.to)
+	assert.Equal(t, 1, webhooks)
+
+	admin := NewAdminHandler(store, h.config, nil)
+	admin.SetMailer(mailer)
+	adminToken, err := crypto.GenerateAdminToken("qa-admin", admin.adminSecret)
+	require.NoError(t, err)
+	adminRequest := func(method, target, bearer string) *http.Request {
+		req := httptest.NewRequest(method, target, nil)
+		if bearer != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+bearer)
+		}
+		return req
+	}
+
+	// Running a report sends it, so only administrators may
+	rec := httptest.NewRecorder()
+	ctx := echo.New().NewContext(adminRequest(http.MethodPost, "/api/admin/reports/daily-json/run", ""), rec)
+	ctx.SetParamNames("name")
+	ctx.SetParamValues("daily-json")
+	require.NoError(t, admin.RunReport(ctx))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, 1, webhooks)
+
+	rec = httptest.NewRecorder()
+	ctx = echo.New().NewContext(adminRequest(http.MethodPost, "/api/admin/reports/daily-json/run", adminToken), rec)
+	ctx.SetParamNames("name")
+	ctx.SetParamValues("daily-json")
+	require.NoError(t, admin.RunReport(ctx))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 2, webhooks)
+	var run ReportRun
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &run))
+	assert.Equal(t, string(models.AuditStatusSuccess), run.Status)
+	assert.Equal(t, yesterday, run.PeriodStart.UTC())
+
+	rec = httptest.NewRecorder()
+	ctx = echo.New().NewContext(adminRequest(http.MethodPost, "/api/admin/reports/missing/run", adminToken), rec)
+	ctx.SetParamNames("name")
+	ctx.SetParamValues("missing")
+	require.NoError(t, admin.RunReport(ctx))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	require.NoError(t, admin.ListReports(echo.New().NewContext(adminRequest(http.MethodGet, "/api/admin/reports", ""), rec)))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = httptest.NewRecorder()
+	require.NoError(t, admin.ListReports(echo.New().NewContext(adminRequest(http.MethodGet, "/api/admin/reports", adminToken), rec)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var reports []struct {
+		Report  configstore.ReportConfig `json:"report"`
+		LastRun *ReportRun               `json:"last_run"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &reports))
+	require.Len(t, reports, 2)
+	assert.Equal(t, "system", reports[0].LastRun.Actor)
+	assert.Equal(t, "qa-admin", reports[1].LastRun.Actor)
+}
+
+func TestExportAnalytics(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+	admin := NewAdminHandler(store, h.config, nil)
+	adminToken, err := crypto.GenerateAdminToken("qa-admin", admin.adminSecret)
+	require.NoError(t, err)
+	require.NoError(t, store.CreateAuditLog(&models.AuditLog{ID: "failure", Timestamp: time.Now(),
+		Action: models.AuditActionLoginFailed, Status: models.AuditStatusFailure}))
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/reports/analytics?days=2&format=csv&metrics=failures,bogus", nil)
+	require.NoError(t, admin.ExportAnalytics(echo.New().NewContext(req, rec)))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = httptest.NewRecorder()
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+adminToken)
+	require.NoError(t, admin.ExportAnalytics(echo.New().NewContext(req, rec)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get(echo.HeaderContentDisposition), "attachment")
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, "date,failures", lines[0])
+	assert.Equal(t, "total,1", lines[3])
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/admin/reports/analytics?metrics=bogus", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+adminToken)
+	require.NoError(t, admin.ExportAnalytics(echo.New().NewContext(req, rec)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
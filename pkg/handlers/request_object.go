@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// defaultRequestObjectEncryptionEnc applies when a client registers
+// request_object_encryption_alg without request_object_encryption_enc
+const defaultRequestObjectEncryptionEnc = crypto.JWEEncA128CBCHS256
+
+// requestObjectSigningAlgs lists the request object signature algorithms accepted
+var requestObjectSigningAlgs = []string{"RS256", "RS384", "RS512", "PS256", "ES256", "HS256", "none"}
+
+// requestObjectEnvelopeClaims are JWT claims that describe the request object itself
+// rather than carrying authorization request parameters
+var requestObjectEnvelopeClaims = map[string]bool{
+	"iss": true, "aud": true, "exp": true, "iat": true, "nbf": true, "jti": true,
+}
+
+// applyRequestObject unpacks the request parameter (OpenID Connect Core §6.1) and
+// replaces the authorization request's query parameters with the ones it carries.
+// Encrypted request objects are decrypted with the server's encryption key before
+// their signature is checked against the client's registered keys.
+func (h *Handlers) applyRequestObject(c echo.Context) error {
+	query := c.Request().URL.Query()
+	clientID := query.Get("client_id")
+	if clientID == "" {
+		return fmt.Errorf("client_id is required alongside a request object")
+	}
+	client, err := h.storage.GetClientByID(clientID)
+	if err != nil || client == nil {
+		return fmt.Errorf("unknown client")
+	}
+
+	raw, err := h.decryptRequestObject(client, query.Get("request"))
+	if err != nil {
+		return err
+	}
+	claims, err := h.verifyRequestObject(client, raw)
+	if err != nil {
+		return err
+	}
+
+	query.Del("request")
+	for name, value := range claims {
+		if requestObjectEnvelopeClaims[name] {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			query.Set(name, v)
+		case float64:
+			query.Set(name, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			query.Set(name, strconv.FormatBool(v))
+		case map[string]interface{}:
+			encoded, _ := json.Marshal(v) // e.g. the claims request parameter
+			query.Set(name, string(encoded))
+		}
+	}
+	c.Request().URL.RawQuery = query.Encode()
+	return nil
+}
+
+// decryptRequestObject decrypts a JWE request object, enforcing the client's
+// registered encryption algorithms. Unencrypted request objects pass through
+// unless the client registered request_object_encryption_alg.
+func (h *Handlers) decryptRequestObject(client *models.Client, raw string) (string, error) {
+	if !crypto.IsJWE(raw) {
+		if client.RequestObjectEncryptionAlg != "" {
+			return "", fmt.Errorf("request object must be encrypted")
+		}
+		return raw, nil
+	}
+
+	header, err := crypto.ParseJWEHeader(raw)
+	if err != nil {
+		return "", err
+	}
+	if client.RequestObjectEncryptionAlg != "" {
+		enc := client.RequestObjectEncryptionEnc
+		if enc == "" {
+			enc = defaultRequestObjectEncryptionEnc
+		}
+		if header.Alg != client.RequestObjectEncryptionAlg || header.Enc != enc {
+			return "", fmt.Errorf("request object encryption does not match the registered algorithms")
+		}
+	}
+
+	plaintext, err := h.decryptJWE(raw)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt request object: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// verifyRequestObject validates a signed (or, if registered, unsigned) request object
+// and returns its claims
+func (h *Handlers) verifyRequestObject(client *models.Client, raw string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		alg := token.Method.Alg()
+		if client.RequestObjectSigningAlg != "" && alg != client.RequestObjectSigningAlg {
+			return nil, fmt.Errorf("request object must be signed with %s", client.RequestObjectSigningAlg)
+		}
+
+		switch token.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			if client.Secret == "" {
+				return nil, fmt.Errorf("client has no secret for HMAC request objects")
+			}
+			return []byte(client.Secret), nil
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+			kid, _ := token.Header["kid"].(string)
+			return h.clientPublicKey(client, kid)
+		default:
+			// Unsigned request objects are accepted only from clients that registered "none"
+			if alg == "none" && client.RequestObjectSigningAlg == "none" {
+				return jwt.UnsafeAllowNoneSignatureType, nil
+			}
+			return nil, fmt.Errorf("unsupported request object signature")
+		}
+	}, jwt.WithIssuedAt(), jwt.WithLeeway(h.clockSkew()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid request object: %w", err)
+	}
+
+	if iss, ok := claims["iss"].(string); ok && iss != client.ID {
+		return nil, fmt.Errorf("request object issuer must be the client_id")
+	}
+	if _, ok := claims["aud"]; ok && !h.validRequestObjectAudience(claims) {
+		return nil, fmt.Errorf("request object audience must be the issuer")
+	}
+	if id, ok := claims["client_id"].(string); ok && id != client.ID {
+		return nil, fmt.Errorf("request object client_id does not match")
+	}
+	return claims, nil
+}
+
+// validRequestObjectAudience accepts the issuer or the authorization endpoint as audience
+func (h *Handlers) validRequestObjectAudience(claims jwt.MapClaims) bool {
+	audiences, err := claims.GetAudience()
+	if err != nil {
+		return false
+	}
+	for _, aud := range audiences {
+		if aud == h.config.Issuer || aud == h.config.Issuer+"/authorize" {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRequestObjectMetadata checks the request object algorithms a client registers
+func validateRequestObjectMetadata(req *models.ClientRegistrationRequest) *models.ClientRegistrationError {
+	if req.RequestObjectSigningAlg != "" && !contains(requestObjectSigningAlgs, req.RequestObjectSigningAlg) {
+		return &models.ClientRegistrationError{
+			Error:            models.ErrInvalidClientMetadata,
+			ErrorDescription: "unsupported request_object_signing_alg: " + req.RequestObjectSigningAlg,
+		}
+	}
+	if req.RequestObjectEncryptionEnc != "" && req.RequestObjectEncryptionAlg == "" {
+		return &models.ClientRegistrationError{
+			Error:            models.ErrInvalidClientMetadata,
+			ErrorDescription: "request_object_encryption_enc requires request_object_encryption_alg",
+		}
+	}
+	if req.RequestObjectEncryptionAlg != "" && !contains(crypto.SupportedJWEAlgorithms, req.RequestObjectEncryptionAlg) {
+		return &models.ClientRegistrationError{
+			Error:            models.ErrInvalidClientMetadata,
+			ErrorDescription: "unsupported request_object_encryption_alg: " + req.RequestObjectEncryptionAlg,
+		}
+	}
+	if req.RequestObjectEncryptionEnc != "" && !contains(crypto.SupportedJWEEncryptions, req.RequestObjectEncryptionEnc) {
+		return &models.ClientRegistrationError{
+			Error:            models.ErrInvalidClientMetadata,
+			ErrorDescription: "unsupported request_object_encryption_enc: " + req.RequestObjectEncryptionEnc,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestAuthorize_EncryptedRequestObject(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+
+	client := &models.Client{
+		ID:                         "jar-client",
+		Secret:                     "a-sufficiently-long-shared-secret-value",
+		RedirectURIs:               []string{"https://rp.example.com/cb"},
+		GrantTypes:                 []string{"authorization_code"},
+		ResponseTypes:              []string{"code"},
+		RequestObjectSigningAlg:    "HS256",
+		RequestObjectEncryptionAlg: crypto.JWEAlgRSAOAEP256,
+		RequestObjectEncryptionEnc: crypto.JWEEncA256GCM,
+	}
+	require.NoError(t, store.CreateClient(client))
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss":           client.ID,
+		"aud":           h.config.Issuer,
+		"exp":           time.Now().Add(5 * time.Minute).Unix(),
+		"client_id":     client.ID,
+		"response_type": "code",
+		"redirect_uri":  "https://rp.example.com/cb",
+		"scope":         "openid profile",
+		"state":         "state-from-jar",
+		"max_age":       300,
+	}).SignedString([]byte(client.Secret))
+	require.NoError(t, err)
+
+	authorize := func(request string) *httptest.ResponseRecorder {
+		query := url.Values{"client_id": {client.ID}, "request": {request}, "scope": {"openid"}}
+		req := httptest.NewRequest(http.MethodGet, "/authorize?"+query.Encode(), nil)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Authorize(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	// The client registered encryption, so a signed-only request object is refused
+	rec := authorize(signed)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrorInvalidRequestObject)
+
+	// Encrypted with different algorithms than registered
+	mismatched, err := crypto.EncryptJWE([]byte(signed), h.jwtManager.GetEncryptionPublicKey(),
+		crypto.JWEAlgRSAOAEP, crypto.JWEEncA256GCM, crypto.EncryptionKeyID, "JWT")
+	require.NoError(t, err)
+	rec = authorize(mismatched)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	encrypted, err := crypto.EncryptJWE([]byte(signed), h.jwtManager.GetEncryptionPublicKey(),
+		crypto.JWEAlgRSAOAEP256, crypto.JWEEncA256GCM, crypto.EncryptionKeyID, "JWT")
+	require.NoError(t, err)
+	rec = authorize(encrypted)
+	require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+
+	location := rec.Header().Get("Location")
+	require.True(t, strings.HasPrefix(location, "/login?auth_session="), location)
+	authSession, err := store.GetAuthSession(strings.TrimPrefix(location, "/login?auth_session="))
+	require.NoError(t, err)
+	require.NotNil(t, authSession)
+	assert.Equal(t, "openid profile", authSession.Scope, "request object parameters replace the query")
+	assert.Equal(t, "state-from-jar", authSession.State)
+	assert.Equal(t, 300, authSession.MaxAge)
+}
+
+func TestJWKS_PublishesEncryptionKey(t *testing.T) {
+	h, _, _, _ := setupRevokeTest(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	rec := httptest.NewRecorder()
+	require.NoError(t, h.JWKS(echo.New().NewContext(req, rec)))
+
+	assert.Contains(t, rec.Body.String(), `"use":"enc"`)
+	assert.Contains(t, rec.Body.String(), `"kid":"`+crypto.EncryptionKeyID+`"`)
+}
+
+func TestEncryptionKeyRotation(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+	require.NoError(t, h.EnsureEncryptionKey())
+
+	keys, err := store.GetAllSigningKeys()
+	require.NoError(t, err)
+	first := activeEncryptionKey(keys)
+	require.NotNil(t, first)
+	assert.Equal(t, models.KeyUseEncryption, first.Use)
+
+	// Ensuring again must not create a second key
+	require.NoError(t, h.EnsureEncryptionKey())
+	keys, _ = store.GetAllSigningKeys()
+	assert.Len(t, keys, 1)
+
+	publicKey, err := crypto.ParsePublicKeyFromPEM(first.PublicKey)
+	require.NoError(t, err)
+	encrypted, err := crypto.EncryptJWE([]byte("payload"), publicKey, crypto.JWEAlgRSAOAEP256, crypto.JWEEncA128GCM, first.KID, "")
+	require.NoError(t, err)
+
+	// A fresh key is not due for rotation; an old one is
+	rotated, err := h.rotateEncryptionKeyIfDue()
+	require.NoError(t, err)
+	assert.False(t, rotated)
+
+	first.CreatedAt = time.Now().AddDate(0, 0, -(defaultEncryptionKeyRotationDays + 1))
+	require.NoError(t, store.UpdateSigningKey(first))
+	rotated, err = h.rotateEncryptionKeyIfDue()
+	require.NoError(t, err)
+	assert.True(t, rotated)
+
+	keys, _ = store.GetAllSigningKeys()
+	second := activeEncryptionKey(keys)
+	require.NotNil(t, second)
+	assert.NotEqual(t, first.KID, second.KID)
+
+	// The retired key keeps decrypting during its grace period
+	plaintext, err := h.decryptJWE(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(plaintext))
+
+	// JWKS publishes both dedicated keys instead of the signing key fallback
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	rec := httptest.NewRecorder()
+	require.NoError(t, h.JWKS(echo.New().NewContext(req, rec)))
+	assert.Contains(t, rec.Body.String(), `"kid":"`+first.KID+`"`)
+	assert.Contains(t, rec.Body.String(), `"kid":"`+second.KID+`"`)
+	assert.NotContains(t, rec.Body.String(), crypto.EncryptionKeyID)
+
+	// Encryption keys are never picked for signing
+	_, err = store.GetActiveSigningKey()
+	assert.Error(t, err)
+}
+
+func TestRotateEncryptionKeysRequiresAdmin(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+	admin := NewAdminHandler(store, h.config, nil)
+	adminToken, err := crypto.GenerateAdminToken("keymaster", admin.adminSecret)
+	require.NoError(t, err)
+	rotate := func(bearer string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/settings/rotate-encryption-keys", nil)
+		if bearer != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		require.NoError(t, admin.RotateEncryptionKeys(echo.New().NewContext(req, rec)))
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, rotate(""))
+	keys, err := store.GetAllSigningKeys()
+	require.NoError(t, err)
+	assert.Nil(t, activeEncryptionKey(keys))
+
+	assert.Equal(t, http.StatusOK, rotate(adminToken))
+	keys, err = store.GetAllSigningKeys()
+	require.NoError(t, err)
+	assert.NotNil(t, activeEncryptionKey(keys))
+}
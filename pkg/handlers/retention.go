@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+// defaultRetentionInterval is used when retention.interval_minutes is not set
+const defaultRetentionInterval = time.Hour
+
+// RetentionStats counts records deleted by the retention policy
+type RetentionStats struct {
+	AuditLogs          int64 `json:"audit_logs"`
+	Tokens             int64 `json:"tokens"`
+	AuthorizationCodes int64 `json:"authorization_codes"`
+	UserSessions       int64 `json:"user_sessions"`
+	AuthSessions       int64 `json:"auth_sessions"`
+	LastRunAt          int64 `json:"last_run_at,omitempty"` // Unix time of the last completed run
+	LastRunErrors      int64 `json:"last_run_errors"`
+}
+
+// retentionDeleted holds the deletion counters since startup
+var retentionDeleted struct {
+	auditLogs, tokens, authorizationCodes, userSessions, authSessions atomic.Int64
+	lastRunAt, lastRunErrors                                          atomic.Int64
+}
+
+// RetentionDeletions returns how many records the retention policy has deleted since startup
+func RetentionDeletions() RetentionStats {
+	return RetentionStats{
+		AuditLogs:          retentionDeleted.auditLogs.Load(),
+		Tokens:             retentionDeleted.tokens.Load(),
+		AuthorizationCodes: retentionDeleted.authorizationCodes.Load(),
+		UserSessions:       retentionDeleted.userSessions.Load(),
+		AuthSessions:       retentionDeleted.authSessions.Load(),
+		LastRunAt:          retentionDeleted.lastRunAt.Load(),
+		LastRunErrors:      retentionDeleted.lastRunErrors.Load(),
+	}
+}
+
+// enforceRetention deletes the records that have outlived policy and returns how
+// many of each kind were deleted in this run
+func enforceRetention(store storage.Storage, policy configstore.RetentionConfig, now time.Time) RetentionStats {
+	var run RetentionStats
+	failures := 0
+	purge := func(kind string, window time.Duration, del func(time.Time) (int, error), total *atomic.Int64, count *int64) {
+		if window <= 0 {
+			return
+		}
+		deleted, err := del(now.Add(-window))
+		if err != nil {
+			log.Printf("Warning: Failed to delete %s past retention: %v", kind, err)
+			failures++
+			return
+		}
+		*count = int64(deleted)
+		total.Add(int64(deleted))
+	}
+
+	day := 24 * time.Hour
+	purge("audit logs", time.Duration(policy.AuditLogDays)*day,
+		store.DeleteAuditLogsBefore, &retentionDeleted.auditLogs, &run.AuditLogs)
+	purge("expired tokens", time.Duration(policy.ExpiredTokenDays)*day,
+		store.DeleteTokensExpiredBefore, &retentionDeleted.tokens, &run.Tokens)
+	purge("expired authorization codes", time.Duration(policy.ExpiredTokenDays)*day,
+		store.DeleteAuthorizationCodesExpiredBefore, &retentionDeleted.authorizationCodes, &run.AuthorizationCodes)
+	purge("idle sessions", time.Duration(policy.IdleSessionDays)*day,
+		store.DeleteUserSessionsIdleSince, &retentionDeleted.userSessions, &run.UserSessions)
+	purge("abandoned authorization sessions", time.Duration(policy.AbandonedAuthSessionMinutes)*time.Minute,
+		store.DeleteAuthSessionsCreatedBefore, &retentionDeleted.authSessions, &run.AuthSessions)
+
+	retentionDeleted.lastRunAt.Store(now.Unix())
+	retentionDeleted.lastRunErrors.Store(int64(failures))
+	run.LastRunAt = now.Unix()
+	run.LastRunErrors = int64(failures)
+	return run
+}
+
+// EnforceRetention applies the configured retention policy once
+func (h *Handlers) EnforceRetention() RetentionStats {
+	return enforceRetention(h.storage, h.config.RetentionState(), time.Now())
+}
+
+// StartRetentionCleanup runs the retention policy in the background. The interval
+// is re-read after every run so changes made through the admin API apply without a restart.
+func (h *Handlers) StartRetentionCleanup() {
+	go func() {
+		for {
+			interval := time.Duration(h.config.RetentionState().IntervalMinutes) * time.Minute
+			if interval <= 0 {
+				interval = defaultRetentionInterval
+			}
+			time.Sleep(interval)
+
+			run := h.EnforceRetention()
+			if deleted := run.AuditLogs + run.Tokens + run.UserSessions + run.AuthSessions; deleted > 0 {
+				log.Printf("Retention cleanup deleted %d audit logs, %d tokens, %d idle sessions and %d abandoned authorization sessions",
+					run.AuditLogs, run.Tokens, run.UserSessions, run.AuthSessions)
+			}
+		}
+	}()
+}
+
+// GetRetention returns the retention settings and the deletions made since startup
+func (h *AdminHandler) GetRetention(c echo.Context) error {
+	if _, ok := h.authenticatedAdmin(c); !ok {
+		return nil
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"settings": h.config.RetentionState(),
+		"deleted":  RetentionDeletions(),
+	})
+}
+
+// UpdateRetention changes retention windows at runtime and saves them in the config
+// store. Omitted fields keep their current value; zero turns deletion off for that
+// kind of record.
+func (h *AdminHandler) UpdateRetention(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+
+	var req struct {
+		AuditLogDays                *int `json:"audit_log_days"`
+		ExpiredTokenDays            *int `json:"expired_token_days"`
+		IdleSessionDays             *int `json:"idle_session_days"`
+		AbandonedAuthSessionMinutes *int `json:"abandoned_auth_session_minutes"`
+		IntervalMinutes             *int `json:"interval_minutes"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if h.configStore == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Config store is not available"})
+	}
+	h.settingsMu.Lock()
+	defer h.settingsMu.Unlock()
+
+	state := h.config.RetentionState()
+	for _, f := range []struct {
+		name  string
+		value *int
+		dest  *int
+	}{
+		{"audit_log_days", req.AuditLogDays, &state.AuditLogDays},
+		{"expired_token_days", req.ExpiredTokenDays, &state.ExpiredTokenDays},
+		{"idle_session_days", req.IdleSessionDays, &state.IdleSessionDays},
+		{"abandoned_auth_session_minutes", req.AbandonedAuthSessionMinutes, &state.AbandonedAuthSessionMinutes},
+		{"interval_minutes", req.IntervalMinutes, &state.IntervalMinutes},
+	} {
+		if f.value == nil {
+			continue
+		}
+		if *f.value < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": f.name + " must not be negative"})
+		}
+		*f.dest = *f.value
+	}
+	if err := configstore.SaveRetention(c.Request().Context(), h.configStore, state); err != nil {
+		log.Printf("Failed to save retention settings: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save retention settings"})
+	}
+	h.config.SetRetention(state)
+
+	h.logAdminAudit(models.AuditActionAdminRetentionSet, models.AuditActorAdmin, actor,
+		"settings", "retention", models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{
+			"audit_log_days":                 state.AuditLogDays,
+			"expired_token_days":             state.ExpiredTokenDays,
+			"idle_session_days":              state.IdleSessionDays,
+			"abandoned_auth_session_minutes": state.AbandonedAuthSessionMinutes,
+		})
+
+	return c.JSON(http.StatusOK, state)
+}
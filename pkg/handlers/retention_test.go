@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestRetentionPolicy(t *testing.T) {
+	h, store, client, token := setupRevokeTest(t)
+	admin := NewAdminHandler(store, h.config, nil)
+	configStore := configstore.NewJSONConfigStore(filepath.Join(t.TempDir(), "config.json"))
+	require.NoError(t, configStore.SaveConfig(context.Background(), configstore.DefaultConfig()))
+	admin.SetConfigStore(configStore)
+	adminToken, err := crypto.GenerateAdminToken("qa-admin", admin.adminSecret)
+	require.NoError(t, err)
+	updateRetention := func(body, bearer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/admin/retention", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if bearer != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		require.NoError(t, admin.UpdateRetention(echo.New().NewContext(req, rec)))
+		return rec
+	}
+	now := time.Now()
+
+	require.NoError(t, store.CreateAuditLog(&models.AuditLog{ID: "old", Timestamp: now.AddDate(0, 0, -100)}))
+	require.NoError(t, store.CreateAuditLog(&models.AuditLog{ID: "recent", Timestamp: now.AddDate(0, 0, -1)}))
+	stale := models.NewToken("stale-access", "stale-refresh", client.ID, "user-1", "openid", 60)
+	stale.ExpiresAt = now.AddDate(0, 0, -40)
+	require.NoError(t, store.CreateToken(stale))
+	require.NoError(t, store.CreateAuthorizationCode(&models.AuthorizationCode{Code: "stale-code", ClientID: client.ID,
+		ExpiresAt: now.AddDate(0, 0, -40)}))
+	require.NoError(t, store.CreateAuthorizationCode(&models.AuthorizationCode{Code: "recent-code", ClientID: client.ID,
+		ExpiresAt: now.Add(-time.Minute)}))
+	require.NoError(t, store.CreateUserSession(&models.UserSession{ID: "idle", UserID: "user-1", ExpiresAt: now.AddDate(0, 1, 0)}))
+	require.NoError(t, store.CreateAuthSession(&models.AuthSession{ID: "abandoned", ClientID: client.ID,
+		CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(time.Hour)}))
+	require.NoError(t, store.CreateAuthSession(&models.AuthSession{ID: "fresh", ClientID: client.ID,
+		CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+
+	// Nothing is deleted until a window is configured
+	run := h.EnforceRetention()
+	assert.Zero(t, run.AuditLogs+run.Tokens+run.AuthorizationCodes+run.UserSessions+run.AuthSessions)
+
+	// Only administrators may shorten the windows, since that deletes audit history
+	settings := `{"audit_log_days":90,"expired_token_days":30,"idle_session_days":14,"abandoned_auth_session_minutes":60}`
+	assert.Equal(t, http.StatusUnauthorized, updateRetention(`{"audit_log_days":1}`, "").Code)
+	assert.Zero(t, h.config.RetentionState().AuditLogDays)
+
+	require.Equal(t, http.StatusOK, updateRetention(settings, adminToken).Code)
+	assert.Equal(t, 30, h.config.RetentionState().ExpiredTokenDays)
+	saved, err := configStore.GetConfig(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 90, saved.RetentionState().AuditLogDays, "the change survives a reload or restart")
+
+	// The idle session was created now, so it is only removed once it has been unused for long enough
+	run = h.EnforceRetention()
+	assert.EqualValues(t, 1, run.AuditLogs)
+	assert.EqualValues(t, 1, run.Tokens)
+	assert.EqualValues(t, 1, run.AuthorizationCodes)
+	assert.EqualValues(t, 0, run.UserSessions)
+	assert.EqualValues(t, 1, run.AuthSessions)
+
+	remaining := store.GetAuditLogsCount(models.AuditFilter{})
+	assert.Equal(t, 2, remaining) // the recent entry and the retention change itself
+	refreshed, err := store.GetTokenByRefreshToken("stale-refresh")
+	require.NoError(t, err)
+	assert.Nil(t, refreshed)
+	live, err := store.GetTokenByAccessToken(token.AccessToken)
+	require.NoError(t, err)
+	assert.NotNil(t, live)
+	fresh, err := store.GetAuthSession("fresh")
+	require.NoError(t, err)
+	assert.NotNil(t, fresh)
+
+	run = enforceRetention(store, h.config.RetentionState(), now.AddDate(0, 0, 15))
+	assert.EqualValues(t, 1, run.UserSessions)
+	assert.GreaterOrEqual(t, RetentionDeletions().UserSessions, int64(1))
+
+	assert.Equal(t, http.StatusBadRequest, updateRetention(`{"audit_log_days":-1}`, adminToken).Code)
+}
@@ -41,7 +41,7 @@ func (h *Handlers) Revoke(c echo.Context) error {
 	if err != nil || client == nil {
 		// RFC 7009 §2.2.1: The authorization server validates the client credentials
 		// If invalid, return invalid_client error
-		return ErrorInvalidClientAuth(c, "Invalid client credentials")
+		return h.rejectClientAuth(c, clientID, "Invalid client credentials")
 	}
 
 	// Attempt to revoke the token
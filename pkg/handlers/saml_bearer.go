@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/saml"
+)
+
+// samlAssertionJTINamespace scopes SAML assertion IDs in the replay cache; the
+// issuer's entity ID is appended so IDs of different partners cannot collide
+const samlAssertionJTINamespace = "saml-bearer:"
+
+// handleSAMLBearerGrant implements the SAML 2.0 bearer assertion grant (RFC 7522)
+// for partners that can only mint SAML. The assertion must be signed by a trusted
+// issuer, and its subject is mapped to a local user by the issuer's configuration.
+// Like client_credentials, no refresh token is issued; the partner presents a
+// fresh assertion instead.
+func (h *Handlers) handleSAMLBearerGrant(c echo.Context, req *TokenRequest, client *models.Client) error {
+	if !client.HasGrantType(GrantTypeSAML2Bearer) {
+		return jsonError(c, http.StatusBadRequest, ErrorUnauthorizedClient,
+			"Client not authorized for the SAML bearer grant")
+	}
+	if req.Assertion == "" {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidRequest, "assertion parameter is required")
+	}
+
+	assertion, err := saml.ParseAssertion(req.Assertion)
+	if err != nil {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, err.Error())
+	}
+	issuer := h.samlIssuer(assertion.Issuer)
+	if issuer == nil {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "Assertion issuer is not trusted")
+	}
+	if len(issuer.Clients) > 0 && !contains(issuer.Clients, client.ID) {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "Client may not present assertions of this issuer")
+	}
+
+	certs, err := saml.ParseCertificates(issuer.Certificate)
+	if err != nil {
+		log.Printf("Warning: SAML issuer %s has no usable certificate: %v", issuer.EntityID, err)
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "Assertion issuer is not trusted")
+	}
+	if err := assertion.VerifySignature(certs); err != nil {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "Invalid assertion signature: "+err.Error())
+	}
+
+	tokenEndpoint := h.config.Issuer + "/token"
+	audiences := []string{h.config.Issuer, tokenEndpoint}
+	if issuer.Audience != "" {
+		audiences = append(audiences, issuer.Audience)
+	}
+	if err := assertion.Validate(tokenEndpoint, audiences, time.Now(), h.clockSkew()); err != nil {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, err.Error())
+	}
+
+	fresh, err := h.storage.RecordJTI(samlAssertionJTINamespace+issuer.EntityID, assertion.ID, assertion.ExpiresAt)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to record assertion")
+	}
+	if !fresh {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "Assertion has already been used")
+	}
+
+	user := h.samlUser(issuer, assertion)
+	if user == nil || !user.CanAuthenticate() {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "Assertion subject is not a known user")
+	}
+
+	scope := req.Scope
+	if scope == "" {
+		scope = client.Scope
+	} else if !h.validateScope(scope, client.Scope) {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidScope,
+			"Requested scope exceeds client allowed scope")
+	}
+
+	token, err := h.newToken(client.ID, user.ID, scope)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate token")
+	}
+	if err := h.storage.CreateToken(token); err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to create token")
+	}
+
+	h.logAudit(models.AuditActionTokenIssued, models.AuditActorUser, user.Username,
+		"token", token.AccessToken[:min(16, len(token.AccessToken))],
+		models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"grant_type": GrantTypeSAML2Bearer, "client_id": client.ID,
+			"saml_issuer": issuer.EntityID, "scope": scope})
+
+	response := TokenResponse{
+		AccessToken: token.AccessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   h.config.JWTState().ExpiryMinutes * 60,
+		Scope:       scope,
+	}
+	h.addTokenResponseParams(&response, client, user, scope, GrantTypeSAML2Bearer)
+	return c.JSON(http.StatusOK, response)
+}
+
+// samlIssuer returns the trust configuration of an assertion issuer
+func (h *Handlers) samlIssuer(entityID string) *configstore.SAMLIssuerConfig {
+	issuers := h.config.SAMLIssuersState()
+	for i := range issuers {
+		if issuers[i].EntityID == entityID {
+			return &issuers[i]
+		}
+	}
+	return nil
+}
+
+// samlUser finds the local user an assertion is about, using the NameID or the
+// issuer's user attribute, matched against the username or email
+func (h *Handlers) samlUser(issuer *configstore.SAMLIssuerConfig, assertion *saml.Assertion) *models.User {
+	identifier := assertion.NameID
+	if issuer.UserAttribute != "" {
+		values := assertion.Attributes[issuer.UserAttribute]
+		if len(values) == 0 || values[0] == "" {
+			return nil
+		}
+		identifier = values[0]
+	}
+
+	var user *models.User
+	var err error
+	if issuer.UserMatchField() == configstore.SAMLMatchEmail {
+		user, err = h.storage.GetUserByEmail(canonicalEmail(h.config, identifier))
+	} else {
+		user, err = h.storage.GetUserByUsername(identifier)
+	}
+	if err != nil {
+		return nil
+	}
+	return user
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestSAMLBearerGrant(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	client.GrantTypes = append(client.GrantTypes, GrantTypeSAML2Bearer)
+	client.Scope = "reports"
+	require.NoError(t, store.UpdateClient(client))
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "partner"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	h.config.SAMLIssuers = []configstore.SAMLIssuerConfig{{
+		EntityID:      "https://partner.example.com",
+		Certificate:   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		UserAttribute: "mail",
+		MatchField:    configstore.SAMLMatchEmail,
+	}}
+
+	user := models.NewRegularUser("alice", "alice@example.com", "hashed_password")
+	require.NoError(t, store.CreateUser(user))
+
+	exchange := func(assertion string) *httptest.ResponseRecorder {
+		form := url.Values{"grant_type": {GrantTypeSAML2Bearer}, "assertion": {assertion},
+			"client_id": {client.ID}, "client_secret": {client.Secret}}
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Token(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	// The mail attribute is matched against local email addresses
+	assertion := signedSAMLAssertion(t, key, "_first", "alice@example.com", h.config.Issuer+"/token")
+	rec := exchange(assertion)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var token TokenResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &token))
+	assert.Equal(t, "reports", token.Scope)
+	assert.Empty(t, token.RefreshToken)
+	stored, err := store.GetTokenByAccessToken(token.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, stored.UserID)
+
+	// Assertions are single use
+	rec = exchange(assertion)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "already been used")
+
+	// Assertions for another recipient, unknown users and untrusted issuers are refused
+	assert.Equal(t, http.StatusBadRequest, exchange(signedSAMLAssertion(t, key, "_other", "alice@example.com", "https://other.example.com/token")).Code)
+	assert.Equal(t, http.StatusBadRequest, exchange(signedSAMLAssertion(t, key, "_nobody", "nobody@example.com", h.config.Issuer+"/token")).Code)
+	h.config.SAMLIssuers[0].Clients = []string{"another-client"}
+	assert.Equal(t, http.StatusBadRequest, exchange(signedSAMLAssertion(t, key, "_client", "alice@example.com", h.config.Issuer+"/token")).Code)
+}
+
+// signedSAMLAssertion builds a signed assertion from partner.example.com whose mail
+// attribute is mail. The XML is written in canonical form so it can be signed directly.
+func signedSAMLAssertion(t *testing.T, key *rsa.PrivateKey, id, mail, recipient string) string {
+	t.Helper()
+	exp := time.Now().UTC().Add(5 * time.Minute).Format(time.RFC3339)
+	const ds = `xmlns:ds="http://www.w3.org/2000/09/xmldsig#"`
+
+	head := `<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="` + id + `" Version="2.0">` +
+		`<saml:Issuer>https://partner.example.com</saml:Issuer>`
+	tail := `<saml:Subject><saml:NameID>partner-user-42</saml:NameID>` +
+		`<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">` +
+		`<saml:SubjectConfirmationData NotOnOrAfter="` + exp + `" Recipient="` + recipient + `"></saml:SubjectConfirmationData>` +
+		`</saml:SubjectConfirmation></saml:Subject>` +
+		`<saml:Conditions NotOnOrAfter="` + exp + `"><saml:AudienceRestriction><saml:Audience>https://example.com</saml:Audience></saml:AudienceRestriction></saml:Conditions>` +
+		`<saml:AttributeStatement><saml:Attribute Name="mail"><saml:AttributeValue>` + mail + `</saml:AttributeValue></saml:Attribute></saml:AttributeStatement>` +
+		`</saml:Assertion>`
+	digest := sha256.Sum256([]byte(head + tail))
+
+	signedInfo := `<ds:SignedInfo>` +
+		`<ds:CanonicalizationMethod Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"></ds:CanonicalizationMethod>` +
+		`<ds:SignatureMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"></ds:SignatureMethod>` +
+		`<ds:Reference URI="#` + id + `"><ds:Transforms>` +
+		`<ds:Transform Algorithm="http://www.w3.org/2000/09/xmldsig#enveloped-signature"></ds:Transform>` +
+		`<ds:Transform Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"></ds:Transform></ds:Transforms>` +
+		`<ds:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"></ds:DigestMethod>` +
+		`<ds:DigestValue>` + base64.StdEncoding.EncodeToString(digest[:]) + `</ds:DigestValue></ds:Reference></ds:SignedInfo>`
+	hashed := sha256.Sum256([]byte(strings.Replace(signedInfo, `<ds:SignedInfo>`, `<ds:SignedInfo `+ds+`>`, 1)))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	require.NoError(t, err)
+
+	doc := head + `<ds:Signature ` + ds + `>` + signedInfo +
+		`<ds:SignatureValue>` + base64.StdEncoding.EncodeToString(signature) + `</ds:SignatureValue></ds:Signature>` + tail
+	return base64.RawURLEncoding.EncodeToString([]byte(doc))
+}
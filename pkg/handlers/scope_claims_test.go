@@ -13,6 +13,7 @@ import (
 	"github.com/prasenjit-net/openid-golang/pkg/configstore"
 	"github.com/prasenjit-net/openid-golang/pkg/crypto"
 	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storagetest"
 )
 
 // TestIDToken_ProfileScope tests that ID token only includes profile claims when profile scope is requested
@@ -278,11 +279,56 @@ func TestIDToken_AddressScopeWithoutAddressData(t *testing.T) {
 	assert.Nil(t, claims.Address)
 }
 
+// TestIDToken_MinimalClient tests that a minimal-ID-token client gets only the subject
+// and authentication claims, whatever scopes were granted
+func TestIDToken_MinimalClient(t *testing.T) {
+	h := &Handlers{config: &configstore.ConfigData{}}
+	jwtManager, err := crypto.NewJWTManagerForTesting("https://test-issuer.example.com", 60)
+	assert.NoError(t, err)
+	h.jwtManager = jwtManager
+
+	user := &models.User{
+		ID:            "user123",
+		Email:         "test@example.com",
+		EmailVerified: true,
+		Name:          "Test User",
+		Address:       &models.Address{Formatted: "123 Main St"},
+	}
+	client := &models.Client{ID: "mobile-app", MinimalIDToken: true}
+
+	minimal, err := h.jwtManagerFor(client)
+	assert.NoError(t, err)
+	token, err := minimal.GenerateIDTokenWithClaims(user, client.ID, "nonce123", "openid profile email address",
+		time.Now(), "urn:acr:pwd", []string{"pwd"}, "", "")
+	assert.NoError(t, err)
+
+	claims, err := jwtManager.ValidateToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "user123", claims.Subject)
+	assert.Equal(t, "nonce123", claims.Nonce)
+	assert.NotNil(t, claims.AuthTime)
+	assert.Equal(t, []string{"pwd"}, claims.AMR)
+	assert.Empty(t, claims.Name)
+	assert.Empty(t, claims.Email)
+	assert.Nil(t, claims.Address)
+
+	// Other clients keep the scope-based claims
+	client.MinimalIDToken = false
+	full, err := h.jwtManagerFor(client)
+	assert.NoError(t, err)
+	token, err = full.GenerateIDToken(user, client.ID, "nonce123", "openid profile email")
+	assert.NoError(t, err)
+	claims, err = jwtManager.ValidateToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "Test User", claims.Name)
+	assert.Equal(t, "test@example.com", claims.Email)
+}
+
 // TestUserInfo_AddressScope tests that UserInfo endpoint only includes address when address scope is granted
 func TestUserInfo_AddressScope(t *testing.T) {
 	// Setup
 	e := echo.New()
-	mockStorage := new(MockStorage)
+	mockStorage := new(storagetest.MockStorage)
 
 	// Setup JWT manager for handlers
 	jwtManager, err := crypto.NewJWTManagerForTesting("https://test-issuer.example.com", 60)
@@ -370,7 +416,7 @@ func TestUserInfo_AddressScope(t *testing.T) {
 func TestUserInfo_WithoutAddressScope(t *testing.T) {
 	// Setup
 	e := echo.New()
-	mockStorage := new(MockStorage)
+	mockStorage := new(storagetest.MockStorage)
 
 	// Setup JWT manager for handlers
 	jwtManager, err := crypto.NewJWTManagerForTesting("https://test-issuer.example.com", 60)
@@ -444,7 +490,7 @@ func TestUserInfo_WithoutAddressScope(t *testing.T) {
 func TestUserInfo_WithoutEmailScope(t *testing.T) {
 	// Setup
 	e := echo.New()
-	mockStorage := new(MockStorage)
+	mockStorage := new(storagetest.MockStorage)
 
 	handlers := &Handlers{
 		storage: mockStorage,
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"sort"
+)
+
+// standardScopeClaims lists the claims each standard scope releases, as put in ID
+// tokens and userinfo responses (OIDC Core 1.0 Section 5.4)
+var standardScopeClaims = map[string][]string{
+	"openid":  {"sub"},
+	"profile": {"name", "given_name", "family_name", "picture", "updated_at"},
+	"email":   {"email", "email_verified"},
+	"address": {"address"},
+}
+
+// protocolClaims are the ID token claims that describe the token and the authentication
+// rather than the user
+var protocolClaims = []string{"iss", "aud", "exp", "iat", "auth_time", "nonce", "acr", "amr", "at_hash", "c_hash"}
+
+// supportedScopesAndClaims returns the scopes and claims this server can release, for
+// discovery: the standard scopes, the scopes and claims of the configured attribute
+// providers and the resource scopes of registered APIs. Both lists are sorted.
+func (h *Handlers) supportedScopesAndClaims() (scopes, claims []string) {
+	scopeSet := make(map[string]bool)
+	claimSet := make(map[string]bool)
+	for scope, scopeClaims := range standardScopeClaims {
+		scopeSet[scope] = true
+		for _, claim := range scopeClaims {
+			claimSet[claim] = true
+		}
+	}
+	for _, claim := range protocolClaims {
+		claimSet[claim] = true
+	}
+
+	for _, provider := range h.config.AttributeProviders {
+		if provider.Scope != "" {
+			scopeSet[provider.Scope] = true
+		}
+		for _, claim := range provider.Claims {
+			claimSet[claim] = true
+		}
+	}
+
+	// Resource scopes live in storage; discovery stays available without them
+	if h.storage != nil && !h.storageBreaker.Open() {
+		if clients, err := h.storage.GetAllClients(); err == nil {
+			for _, client := range clients {
+				if !client.ResourceServer || client.Disabled {
+					continue
+				}
+				for _, scope := range client.ResourceScopes {
+					scopeSet[scope] = true
+				}
+			}
+		}
+	}
+
+	return sortedKeys(scopeSet), sortedKeys(claimSet)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/outbound"
+)
+
+const (
+	// Headers sent by GitHub secret scanning with every alert
+	secretScanningKeyIDHeader     = "Github-Public-Key-Identifier"
+	secretScanningSignatureHeader = "Github-Public-Key-Signature"
+
+	secretScanningKeysTTL        = 1 * time.Hour
+	secretScanningMaxBodySize    = 1 << 20
+	secretScanningLabelTrue      = "true_positive"
+	secretScanningLabelFalse     = "false_positive"
+	tokenTypeAuthorizationCode   = "authorization_code"
+	tokenTypeAPIKey              = "api_key"
+	tokenMetadataPatternSuffix   = "[A-Za-z0-9_-]{%d,}"
+	secretScanningRequestTimeout = 10 * time.Second
+)
+
+// TokenMetadataResponse describes the formats of opaque credentials issued by this server
+type TokenMetadataResponse struct {
+	Issuer                 string              `json:"issuer"`
+	SecretScanningEndpoint string              `json:"secret_scanning_endpoint,omitempty"`
+	TokenTypes             []TokenTypeMetadata `json:"token_types"`
+}
+
+// TokenTypeMetadata describes a single credential type
+type TokenTypeMetadata struct {
+	Type    string `json:"type"`
+	Prefix  string `json:"prefix"`
+	Pattern string `json:"pattern"`
+}
+
+// SecretScanningMatch is a single leaked credential reported by the scanner
+type SecretScanningMatch struct {
+	Token  string `json:"token"`
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Source string `json:"source"`
+}
+
+// SecretScanningResult reports whether a reported credential was a live secret
+type SecretScanningResult struct {
+	TokenHash string `json:"token_hash"`
+	TokenType string `json:"token_type"`
+	Label     string `json:"label"`
+}
+
+// secretScanningKeyCache caches the scanner's public keys between alerts
+type secretScanningKeyCache struct {
+	mu        sync.Mutex
+	keys      map[string]*ecdsa.PublicKey
+	fetchedAt time.Time
+}
+
+// TokenMetadata handles GET /.well-known/token-metadata
+func (h *Handlers) TokenMetadata(c echo.Context) error {
+	length := h.config.JWTState().TokenLength
+	if length <= 0 {
+		length = crypto.DefaultOpaqueTokenLength
+	}
+
+	response := TokenMetadataResponse{
+		Issuer: h.config.Issuer,
+		TokenTypes: []TokenTypeMetadata{
+			{Type: TokenTypeHintAccessToken, Prefix: crypto.AccessTokenPrefix},
+			{Type: TokenTypeHintRefreshToken, Prefix: crypto.RefreshTokenPrefix},
+			{Type: tokenTypeAuthorizationCode, Prefix: crypto.AuthorizationCodePrefix},
+			{Type: tokenTypeAPIKey, Prefix: crypto.APIKeyPrefix},
+		},
+	}
+	for i := range response.TokenTypes {
+		response.TokenTypes[i].Pattern = response.TokenTypes[i].Prefix + fmt.Sprintf(tokenMetadataPatternSuffix, length)
+	}
+	if h.config.SecretScanning.Enabled {
+		response.SecretScanningEndpoint = h.config.Issuer + h.config.SecretScanning.Endpoint
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// SecretScanningVerify handles alerts from GitHub secret scanning
+// (POST /secret-scanning/verify). Reported credentials that are still live are
+// revoked immediately and labelled as true positives in the response.
+func (h *Handlers) SecretScanningVerify(c echo.Context) error {
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, secretScanningMaxBodySize))
+	if err != nil {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidRequest, "Failed to read request body")
+	}
+
+	keyID := c.Request().Header.Get(secretScanningKeyIDHeader)
+	signature := c.Request().Header.Get(secretScanningSignatureHeader)
+	if err := h.verifySecretScanningSignature(keyID, signature, body); err != nil {
+		return jsonError(c, http.StatusUnauthorized, ErrorInvalidRequest, err.Error())
+	}
+
+	var matches []SecretScanningMatch
+	if err := json.Unmarshal(body, &matches); err != nil {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidRequest, "Invalid request body")
+	}
+
+	results := make([]SecretScanningResult, 0, len(matches))
+	for _, match := range matches {
+		hash := sha256.Sum256([]byte(match.Token))
+		result := SecretScanningResult{
+			TokenHash: hex.EncodeToString(hash[:]),
+			TokenType: match.Type,
+			Label:     secretScanningLabelFalse,
+		}
+
+		if h.revokeLeakedToken(match.Token) {
+			result.Label = secretScanningLabelTrue
+			h.logAudit(models.AuditActionTokenRevoked, models.AuditActorSystem, "secret-scanning",
+				"token", match.Token[:min(16, len(match.Token))], models.AuditStatusSuccess,
+				c.RealIP(), c.Request().UserAgent(),
+				map[string]interface{}{"reason": "leaked", "url": match.URL, "source": match.Source})
+		}
+		results = append(results, result)
+	}
+
+	return c.JSON(http.StatusOK, results)
+}
+
+// revokeLeakedToken revokes a live token regardless of which client owns it
+func (h *Handlers) revokeLeakedToken(value string) bool {
+	switch crypto.DetectTokenType(value) {
+	case TokenTypeHintAccessToken:
+		token, err := h.storage.GetTokenByAccessToken(value)
+		if err != nil || token == nil {
+			return false
+		}
+		return h.storage.DeleteToken(token.ID) == nil
+	case TokenTypeHintRefreshToken:
+		token, err := h.storage.GetTokenByRefreshToken(value)
+		if err != nil || token == nil {
+			return false
+		}
+		if token.AuthorizationCodeID != "" {
+			_ = h.storage.RevokeTokensByAuthCode(token.AuthorizationCodeID)
+		}
+		return h.storage.DeleteToken(token.ID) == nil
+	case tokenTypeAuthorizationCode:
+		code, err := h.storage.GetAuthorizationCode(value)
+		if err != nil || code == nil {
+			return false
+		}
+		_ = h.storage.RevokeTokensByAuthCode(code.Code)
+		return h.storage.DeleteAuthorizationCode(code.Code) == nil
+	case tokenTypeAPIKey:
+		key, err := h.storage.GetAPIKeyByHash(hashAPIKey(value))
+		if err != nil || key == nil {
+			return false
+		}
+		return h.storage.DeleteAPIKey(key.ID) == nil
+	default:
+		return false
+	}
+}
+
+// verifySecretScanningSignature checks the ECDSA signature over the raw alert body
+func (h *Handlers) verifySecretScanningSignature(keyID, signature string, body []byte) error {
+	if keyID == "" || signature == "" {
+		return fmt.Errorf("missing signature headers")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("malformed signature")
+	}
+
+	key, err := h.secretScanningKey(keyID)
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(body)
+	if !ecdsa.VerifyASN1(key, digest[:], sig) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// secretScanningKey returns the scanner public key with the given identifier,
+// refreshing the cached key set when it is stale or the key is unknown.
+func (h *Handlers) secretScanningKey(keyID string) (*ecdsa.PublicKey, error) {
+	cache := &h.scanningKeys
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	age := time.Since(cache.fetchedAt)
+	if key, ok := cache.keys[keyID]; ok && age < secretScanningKeysTTL {
+		return key, nil
+	}
+	// Don't let unknown identifiers trigger a fetch on every request
+	if cache.keys != nil && age < time.Minute {
+		return nil, fmt.Errorf("unknown public key identifier")
+	}
+
+	keys, err := fetchSecretScanningKeys(h.config.SecretScanning.PublicKeysURL)
+	if err != nil {
+		return nil, err
+	}
+	cache.keys = keys
+	cache.fetchedAt = time.Now()
+
+	key, ok := keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown public key identifier")
+	}
+	return key, nil
+}
+
+// fetchSecretScanningKeys downloads and parses the scanner's public key set
+func fetchSecretScanningKeys(url string) (map[string]*ecdsa.PublicKey, error) {
+	if url == "" {
+		return nil, fmt.Errorf("secret scanning public keys URL is not configured")
+	}
+
+	client := outbound.NewClient(outbound.Configured, secretScanningRequestTimeout)
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch public keys")
+	}
+	defer func() {
+		_ = resp.Body.Close() // Best effort close
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch public keys: status %d", resp.StatusCode)
+	}
+
+	var doc struct {
+		PublicKeys []struct {
+			KeyIdentifier string `json:"key_identifier"`
+			Key           string `json:"key"`
+		} `json:"public_keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, secretScanningMaxBodySize)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse public keys")
+	}
+
+	keys := make(map[string]*ecdsa.PublicKey)
+	for _, k := range doc.PublicKeys {
+		block, _ := pem.Decode([]byte(k.Key))
+		if block == nil {
+			continue
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			continue
+		}
+		if ecKey, ok := pub.(*ecdsa.PublicKey); ok {
+			keys[k.KeyIdentifier] = ecKey
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenMetadata(t *testing.T) {
+	h, _, _, _ := setupRevokeTest(t)
+	h.config.SecretScanning.Enabled = true
+	h.config.SecretScanning.Endpoint = "/secret-scanning/verify"
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/.well-known/token-metadata", nil), rec)
+	require.NoError(t, h.TokenMetadata(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var response TokenMetadataResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, "https://example.com/secret-scanning/verify", response.SecretScanningEndpoint)
+	require.Len(t, response.TokenTypes, 4)
+	assert.Equal(t, crypto.AccessTokenPrefix, response.TokenTypes[0].Prefix)
+	assert.Equal(t, "oidc_at_[A-Za-z0-9_-]{43,}", response.TokenTypes[0].Pattern)
+}
+
+func TestSecretScanningVerifyRevokesLeakedTokens(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+
+	// Scanner signing key published like GitHub's public key endpoint
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	require.NoError(t, err)
+	keyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"public_keys": []map[string]interface{}{{
+				"key_identifier": "key-1",
+				"key":            string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+				"is_current":     true,
+			}},
+		})
+	}))
+	defer keyServer.Close()
+	h.config.SecretScanning.PublicKeysURL = keyServer.URL
+
+	leaked, err := h.newToken(client.ID, "test-user", "openid")
+	require.NoError(t, err)
+	require.NoError(t, store.CreateToken(leaked))
+
+	body, err := json.Marshal([]SecretScanningMatch{
+		{Token: leaked.AccessToken, Type: "oidc_access_token", URL: "https://github.com/org/repo/blob/main/.env"},
+		{Token: "oidc_at_unknown", Type: "oidc_access_token"},
+	})
+	require.NoError(t, err)
+	digest := sha256.Sum256(body)
+	sig, err := ecdsa.SignASN1(rand.Reader, privateKey, digest[:])
+	require.NoError(t, err)
+
+	send := func(signature string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/secret-scanning/verify", strings.NewReader(string(body)))
+		req.Header.Set(secretScanningKeyIDHeader, "key-1")
+		req.Header.Set(secretScanningSignatureHeader, signature)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.SecretScanningVerify(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("rejects invalid signature", func(t *testing.T) {
+		rec := send(base64.StdEncoding.EncodeToString([]byte("bogus")))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		token, _ := store.GetTokenByAccessToken(leaked.AccessToken)
+		assert.NotNil(t, token, "token must not be revoked by an unsigned alert")
+	})
+
+	t.Run("revokes live token", func(t *testing.T) {
+		rec := send(base64.StdEncoding.EncodeToString(sig))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var results []SecretScanningResult
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &results))
+		require.Len(t, results, 2)
+		assert.Equal(t, secretScanningLabelTrue, results[0].Label)
+		assert.Equal(t, secretScanningLabelFalse, results[1].Label)
+		assert.NotContains(t, rec.Body.String(), leaked.AccessToken)
+
+		token, _ := store.GetTokenByAccessToken(leaked.AccessToken)
+		assert.Nil(t, token, "leaked token should be revoked")
+	})
+}
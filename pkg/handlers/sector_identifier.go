@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/outbound"
+)
+
+const (
+	sectorIdentifierCacheTTL    = 1 * time.Hour
+	sectorIdentifierMaxBodySize = 64 << 10
+)
+
+// sectorIdentifierHTTPClient fetches sector identifier documents; replaced in tests
+var sectorIdentifierHTTPClient = outbound.NewClient(outbound.Untrusted, 10*time.Second)
+
+// sectorIdentifierCache caches fetched sector identifier documents by URI
+type sectorIdentifierCache struct {
+	mu      sync.Mutex
+	entries map[string]*sectorIdentifierEntry
+}
+
+// sectorIdentifierEntry is one cached document. Its lock is held while the
+// document is fetched, so concurrent lookups of a URI share one fetch and
+// lookups of other URIs do not wait for it.
+type sectorIdentifierEntry struct {
+	mu           sync.Mutex
+	redirectURIs []string
+	fetchedAt    time.Time
+}
+
+// entry returns the cache entry for uri, adding an empty one if there is none
+func (c *sectorIdentifierCache) entry(uri string) *sectorIdentifierEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*sectorIdentifierEntry)
+	}
+	entry, ok := c.entries[uri]
+	if !ok {
+		entry = &sectorIdentifierEntry{}
+		c.entries[uri] = entry
+	}
+	return entry
+}
+
+// validateSectorIdentifier checks pairwise subject settings per OpenID Connect
+// Registration §5 and Core §8.1: a registered sector_identifier_uri must be an
+// HTTPS JSON array containing every redirect URI, and clients with redirect URIs
+// on several hosts must register one. refresh bypasses the document cache, so
+// client updates are always checked against the current document.
+func (h *Handlers) validateSectorIdentifier(req *models.ClientRegistrationRequest, refresh bool) *models.ClientRegistrationError {
+	if req.SectorIdentifierURI == "" {
+		if req.SubjectType == "pairwise" && len(redirectURIHosts(req.RedirectURIs)) > 1 {
+			return &models.ClientRegistrationError{
+				Error:            models.ErrInvalidClientMetadata,
+				ErrorDescription: "sector_identifier_uri is required for pairwise clients with redirect_uris on multiple hosts",
+			}
+		}
+		return nil
+	}
+
+	parsed, err := url.Parse(req.SectorIdentifierURI)
+	if err != nil || parsed.Scheme != "https" {
+		return &models.ClientRegistrationError{
+			Error:            models.ErrInvalidClientMetadata,
+			ErrorDescription: "sector_identifier_uri must use https",
+		}
+	}
+
+	listed, err := h.sectorRedirectURIs(req.SectorIdentifierURI, refresh)
+	if err != nil {
+		return &models.ClientRegistrationError{
+			Error:            models.ErrInvalidClientMetadata,
+			ErrorDescription: "sector_identifier_uri could not be retrieved: " + err.Error(),
+		}
+	}
+
+	for _, uri := range req.RedirectURIs {
+		if !contains(listed, uri) {
+			return &models.ClientRegistrationError{
+				Error:            models.ErrInvalidRedirectURI,
+				ErrorDescription: "redirect_uri is not listed in sector_identifier_uri: " + uri,
+			}
+		}
+	}
+	return nil
+}
+
+// sectorRedirectURIs returns the redirect URIs listed at a sector identifier URI
+func (h *Handlers) sectorRedirectURIs(uri string, refresh bool) ([]string, error) {
+	entry := h.sectorIdentifiers.entry(uri)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if !entry.fetchedAt.IsZero() && !refresh && time.Since(entry.fetchedAt) < sectorIdentifierCacheTTL {
+		return entry.redirectURIs, nil
+	}
+
+	uris, err := fetchSectorIdentifier(uri)
+	if err != nil {
+		return nil, err
+	}
+	entry.redirectURIs, entry.fetchedAt = uris, time.Now()
+	return uris, nil
+}
+
+// fetchSectorIdentifier downloads a sector identifier document (a JSON array of URIs)
+func fetchSectorIdentifier(uri string) ([]string, error) {
+	resp, err := sectorIdentifierHTTPClient.Get(uri)
+	if err != nil {
+		return nil, fmt.Errorf("request failed")
+	}
+	defer func() {
+		_ = resp.Body.Close() // Best effort close
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	var uris []string
+	if err := json.NewDecoder(io.LimitReader(resp.Body, sectorIdentifierMaxBodySize)).Decode(&uris); err != nil {
+		return nil, fmt.Errorf("document must be a JSON array of URIs")
+	}
+	return uris, nil
+}
+
+// redirectURIHosts returns the distinct hosts of a set of redirect URIs
+func redirectURIHosts(uris []string) map[string]bool {
+	hosts := make(map[string]bool)
+	for _, uri := range uris {
+		if parsed, err := url.Parse(uri); err == nil {
+			hosts[parsed.Host] = true
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// hashAPIKey returns the stored form of an API key. Keys carry enough entropy that
+// a plain SHA-256 hash is safe and lets them be looked up directly.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// handleAPIKeyGrant exchanges a service account API key for a short-lived access
+// token. The key is the only credential; no client is involved, and neither a
+// refresh token nor an ID token is issued.
+func (h *Handlers) handleAPIKeyGrant(c echo.Context, apiKey, scope string) error {
+	if apiKey == "" {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidRequest, "api_key parameter is required")
+	}
+
+	key, err := h.storage.GetAPIKeyByHash(hashAPIKey(apiKey))
+	if err != nil || key == nil || key.IsExpired() {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "Invalid or expired API key")
+	}
+	user, err := h.storage.GetUserByID(key.UserID)
+	if err != nil || user == nil || !user.ServiceAccount || !user.CanAuthenticate() {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "Service account is disabled")
+	}
+
+	if scope == "" {
+		scope = key.Scope
+	} else if !h.validateScope(scope, key.Scope) {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidScope, "Requested scope exceeds the API key's scope")
+	}
+
+	token, err := h.newToken("", user.ID, scope)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate token")
+	}
+	if err := h.storage.CreateToken(token); err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to create token")
+	}
+
+	now := time.Now()
+	key.LastUsedAt = &now
+	_ = h.storage.UpdateAPIKey(key)
+
+	h.logAudit(models.AuditActionTokenIssued, models.AuditActorUser, user.Username,
+		"token", token.AccessToken[:min(16, len(token.AccessToken))],
+		models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"grant_type": GrantTypeAPIKey, "api_key_id": key.ID, "scope": scope})
+
+	return c.JSON(http.StatusOK, TokenResponse{
+		AccessToken: token.AccessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   h.config.JWTState().ExpiryMinutes * 60,
+		Scope:       scope,
+	})
+}
+
+// serviceAccount loads the service account named by the :id path parameter,
+// writing a 404 response when there is none
+func (h *AdminHandler) serviceAccount(c echo.Context) (*models.User, bool) {
+	user, err := h.store.GetUserByID(c.Param("id"))
+	if err != nil || user == nil || !user.ServiceAccount {
+		_ = c.JSON(http.StatusNotFound, map[string]string{"error": "Service account not found"})
+		return nil, false
+	}
+	return user, true
+}
+
+// ListServiceAccounts returns all service accounts
+func (h *AdminHandler) ListServiceAccounts(c echo.Context) error {
+	if _, ok := h.authenticatedAdmin(c); !ok {
+		return nil
+	}
+	users, err := h.store.GetAllUsers()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get users"})
+	}
+
+	accounts := make([]*models.User, 0)
+	for _, user := range users {
+		if user.ServiceAccount && !user.IsDeleted() {
+			accounts = append(accounts, user)
+		}
+	}
+	return c.JSON(http.StatusOK, accounts)
+}
+
+// CreateServiceAccount creates a user without a password that can only
+// authenticate with API keys
+func (h *AdminHandler) CreateServiceAccount(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	var req struct {
+		Username string `json:"username"`
+		Email    string `json:"email"`
+		Name     string `json:"name"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.Username == "" || req.Email == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "username and email are required"})
+	}
+	if !h.checkUsername(c, req.Username) {
+		return nil
+	}
+	req.Email = canonicalEmail(h.config, req.Email)
+	if taken, err := emailTakenByOther(h.store, req.Email, ""); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check email"})
+	} else if taken {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "A user with this email already exists"})
+	}
+
+	user := &models.User{
+		ID:             uuid.New().String(),
+		Username:       req.Username,
+		Email:          req.Email,
+		Name:           req.Name,
+		Role:           models.RoleUser,
+		ServiceAccount: true,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+	if err := h.store.CreateUser(user); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create service account: " + err.Error()})
+	}
+
+	h.logAdminAudit(models.AuditActionAdminServiceAccountCreated, models.AuditActorAdmin, actor,
+		"user", user.ID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"created_username": req.Username})
+
+	return c.JSON(http.StatusCreated, user)
+}
+
+// ListAPIKeys returns the API keys of a service account. Key values are never returned.
+func (h *AdminHandler) ListAPIKeys(c echo.Context) error {
+	if _, ok := h.authenticatedAdmin(c); !ok {
+		return nil
+	}
+	user, ok := h.serviceAccount(c)
+	if !ok {
+		return nil
+	}
+	keys, err := h.store.ListAPIKeys(user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get API keys"})
+	}
+
+	response := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		response = append(response, map[string]interface{}{
+			"id":           key.ID,
+			"name":         key.Name,
+			"scope":        key.Scope,
+			"expires_at":   key.ExpiresAt,
+			"last_used_at": key.LastUsedAt,
+			"created_by":   key.CreatedBy,
+			"created_at":   key.CreatedAt,
+		})
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// CreateAPIKey issues a new API key for a service account. The key value is
+// returned once in the response and cannot be retrieved again.
+func (h *AdminHandler) CreateAPIKey(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	user, ok := h.serviceAccount(c)
+	if !ok {
+		return nil
+	}
+
+	var req struct {
+		Name          string `json:"name"`
+		Scope         string `json:"scope"`
+		ExpiresInDays int    `json:"expires_in_days"` // 0 = never expires
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if req.Name == "" || req.Scope == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "name and scope are required"})
+	}
+	if req.ExpiresInDays < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "expires_in_days must not be negative"})
+	}
+
+	value, err := crypto.GenerateOpaqueToken(crypto.APIKeyPrefix, h.config.JWTState().TokenLength)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate API key"})
+	}
+	key := &models.APIKey{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		Name:      req.Name,
+		KeyHash:   hashAPIKey(value),
+		Scope:     req.Scope,
+		CreatedBy: actor,
+		CreatedAt: time.Now(),
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := key.CreatedAt.AddDate(0, 0, req.ExpiresInDays)
+		key.ExpiresAt = &expiresAt
+	}
+	if err := h.store.CreateAPIKey(key); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create API key"})
+	}
+
+	h.logAdminAudit(models.AuditActionAdminAPIKeyCreated, models.AuditActorAdmin, key.CreatedBy,
+		"user", user.ID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+		map[string]interface{}{"api_key_id": key.ID, "name": key.Name, "scope": key.Scope})
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"id":         key.ID,
+		"name":       key.Name,
+		"scope":      key.Scope,
+		"expires_at": key.ExpiresAt,
+		"created_at": key.CreatedAt,
+		"api_key":    value,
+	})
+}
+
+// DeleteAPIKey revokes an API key. Access tokens already exchanged for it stay
+// valid until they expire.
+func (h *AdminHandler) DeleteAPIKey(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	user, ok := h.serviceAccount(c)
+	if !ok {
+		return nil
+	}
+	keys, err := h.store.ListAPIKeys(user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get API keys"})
+	}
+	for _, key := range keys {
+		if key.ID != c.Param("key_id") {
+			continue
+		}
+		if err := h.store.DeleteAPIKey(key.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to revoke API key"})
+		}
+		h.logAdminAudit(models.AuditActionAdminAPIKeyRevoked, models.AuditActorAdmin, actor,
+			"user", user.ID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(),
+			map[string]interface{}{"api_key_id": key.ID, "name": key.Name})
+		return c.NoContent(http.StatusNoContent)
+	}
+	return c.JSON(http.StatusNotFound, map[string]string{"error": "API key not found"})
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestServiceAccountAPIKeys(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+	admin := NewAdminHandler(store, h.config, nil)
+	adminToken, err := crypto.GenerateAdminToken("ops", admin.adminSecret)
+	require.NoError(t, err)
+
+	bearer := "Bearer " + adminToken
+	call := func(handler echo.HandlerFunc, method, body string, params ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/service-accounts", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, bearer)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		if len(params) > 0 {
+			c.SetParamNames("id", "key_id")
+			c.SetParamValues(append(params, "")[:2]...)
+		}
+		require.NoError(t, handler(c))
+		return rec
+	}
+	exchange := func(apiKey, scope string) *httptest.ResponseRecorder {
+		form := url.Values{"grant_type": {GrantTypeAPIKey}, "api_key": {apiKey}, "scope": {scope}}
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Token(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	rec := call(admin.CreateServiceAccount, http.MethodPost, `{"username": "nightly-export", "email": "export@svc.example.com"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var account models.User
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &account))
+	assert.True(t, account.ServiceAccount)
+
+	// Only administrators can issue keys
+	bearer = ""
+	rec = call(admin.CreateAPIKey, http.MethodPost, `{"name": "cron", "scope": "reports:read reports:write"}`, account.ID)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	bearer = "Bearer " + adminToken
+
+	rec = call(admin.CreateAPIKey, http.MethodPost, `{"name": "cron", "scope": "reports:read reports:write"}`, account.ID)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var created map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	apiKey := created["api_key"].(string)
+	assert.True(t, strings.HasPrefix(apiKey, "oidc_sk_"))
+
+	// The key is exchanged for a short-lived token without a refresh token
+	rec = exchange(apiKey, "reports:read")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var token TokenResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &token))
+	assert.Equal(t, "reports:read", token.Scope)
+	assert.Empty(t, token.RefreshToken)
+	stored, err := store.GetTokenByAccessToken(token.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, account.ID, stored.UserID)
+
+	assert.Equal(t, http.StatusBadRequest, exchange(apiKey, "admin").Code)
+	assert.Equal(t, http.StatusBadRequest, exchange("oidc_sk_unknown", "").Code)
+
+	// Only administrators can list service accounts and their keys
+	bearer = ""
+	assert.Equal(t, http.StatusUnauthorized, call(admin.ListServiceAccounts, http.MethodGet, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, call(admin.ListAPIKeys, http.MethodGet, "", account.ID).Code)
+	bearer = "Bearer " + adminToken
+	rec = call(admin.ListServiceAccounts, http.MethodGet, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "nightly-export")
+
+	// Key values are not listed, and a revoked key no longer works
+	rec = call(admin.ListAPIKeys, http.MethodGet, "", account.ID)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), apiKey)
+	assert.NotContains(t, rec.Body.String(), hashAPIKey(apiKey))
+	assert.Contains(t, rec.Body.String(), "last_used_at")
+
+	rec = call(admin.DeleteAPIKey, http.MethodDelete, "", account.ID, created["id"].(string))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, http.StatusBadRequest, exchange(apiKey, "").Code)
+
+	// Regular users cannot own API keys
+	user := models.NewRegularUser("person", "person@example.com", "hashed_password")
+	require.NoError(t, store.CreateUser(user))
+	rec = call(admin.CreateAPIKey, http.MethodPost, `{"name": "cron", "scope": "openid"}`, user.ID)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
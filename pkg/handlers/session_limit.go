@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/session"
+)
+
+// activeUserSessions returns the unexpired sessions of a user, oldest first
+func (h *Handlers) activeUserSessions(userID string) ([]*models.UserSession, error) {
+	sessions, err := h.storage.GetUserSessionsByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	active := make([]*models.UserSession, 0, len(sessions))
+	for _, s := range sessions {
+		if s.IsAuthenticated() {
+			active = append(active, s)
+		}
+	}
+	sort.Slice(active, func(i, k int) bool {
+		return active[i].CreatedAt.Before(active[k].CreatedAt)
+	})
+	return active, nil
+}
+
+// sessionLimitRejects reports whether a new sign-in must be refused because the user
+// already holds the maximum number of sessions and the limit is set to reject
+func (h *Handlers) sessionLimitRejects(userID string) bool {
+	limit := h.config.SessionLimitState()
+	if limit.MaxPerUser <= 0 || limit.EvictionBehavior() != configstore.SessionEvictionReject {
+		return false
+	}
+	active, err := h.activeUserSessions(userID)
+	if err != nil {
+		return false
+	}
+	return len(active) >= limit.MaxPerUser
+}
+
+// evictExcessSessions signs out the oldest sessions of a user beyond the session limit,
+// keeping the session that was just created. Refresh tokens bound to an evicted
+// session are revoked with it. Failures are logged; the new sign-in stands.
+func (h *Handlers) evictExcessSessions(c echo.Context, user *models.User, current *models.UserSession) {
+	limit := h.config.SessionLimitState()
+	if limit.MaxPerUser <= 0 || limit.EvictionBehavior() != configstore.SessionEvictionOldest {
+		return
+	}
+	active, err := h.activeUserSessions(user.ID)
+	if err != nil {
+		log.Printf("Warning: Failed to load sessions of user %s for the session limit: %v", user.ID, err)
+		return
+	}
+
+	excess := len(active) - limit.MaxPerUser
+	for _, s := range active {
+		if excess <= 0 {
+			break
+		}
+		if s.ID == current.ID {
+			continue
+		}
+		if err := h.storage.RevokeTokensBySession(s.ID); err != nil {
+			log.Printf("Warning: Failed to revoke tokens of evicted session for user %s: %v", user.ID, err)
+			continue
+		}
+		if err := h.storage.DeleteUserSession(s.ID); err != nil {
+			log.Printf("Warning: Failed to evict session for user %s: %v", user.ID, err)
+			continue
+		}
+		excess--
+
+		h.logAudit(models.AuditActionSessionEvicted, models.AuditActorSystem, "session-limit",
+			"user", user.ID, models.AuditStatusSuccess,
+			c.RealIP(), c.Request().UserAgent(),
+			map[string]interface{}{"max_sessions": limit.MaxPerUser, "session_created_at": s.CreatedAt})
+	}
+}
+
+// SessionInfo describes one of the signed-in user's sessions. Session IDs are bearer
+// credentials and are not included.
+type SessionInfo struct {
+	Current              bool      `json:"current"`
+	AuthenticationMethod string    `json:"authentication_method"`
+	Persistent           bool      `json:"persistent,omitempty"`
+	CreatedAt            time.Time `json:"created_at"`
+	LastActivityAt       time.Time `json:"last_activity_at"`
+	ExpiresAt            time.Time `json:"expires_at"`
+}
+
+// SessionsResponse lists the active sessions of the signed-in user together with the
+// session limit that applies to them
+type SessionsResponse struct {
+	Sessions    []SessionInfo `json:"sessions"`
+	MaxSessions int           `json:"max_sessions"` // 0 = unlimited
+	Eviction    string        `json:"eviction"`
+}
+
+// ListSessions returns the active sessions of the signed-in user (GET /sessions)
+func (h *Handlers) ListSessions(c echo.Context) error {
+	userSession := session.GetUserSession(c)
+	if userSession == nil || !userSession.IsAuthenticated() {
+		return jsonError(c, http.StatusUnauthorized, ErrorInvalidRequest, "Sign-in required")
+	}
+
+	active, err := h.activeUserSessions(userSession.UserID)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to load sessions")
+	}
+
+	limit := h.config.SessionLimitState()
+	response := SessionsResponse{
+		Sessions:    make([]SessionInfo, 0, len(active)),
+		MaxSessions: limit.MaxPerUser,
+		Eviction:    limit.EvictionBehavior(),
+	}
+	for _, s := range active {
+		response.Sessions = append(response.Sessions, SessionInfo{
+			Current:              s.ID == userSession.ID,
+			AuthenticationMethod: s.AuthenticationMethod,
+			Persistent:           s.Persistent,
+			CreatedAt:            s.CreatedAt,
+			LastActivityAt:       s.LastActivityAt,
+			ExpiresAt:            s.ExpiresAt,
+		})
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, response)
+}
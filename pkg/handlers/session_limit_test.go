@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/session"
+)
+
+func TestSessionLimit(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+	h.config.SessionLimit = configstore.SessionLimitConfig{MaxPerUser: 2}
+
+	user := models.NewRegularUser("limited", "limited@example.com", "hashed_password")
+	require.NoError(t, store.CreateUser(user))
+
+	login := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.completeLogin(echo.New().NewContext(req, rec), user, "", "password", "", []string{"pwd"}, false))
+		return rec
+	}
+	activeIDs := func() []string {
+		active, err := h.activeUserSessions(user.ID)
+		require.NoError(t, err)
+		ids := make([]string, 0, len(active))
+		for _, s := range active {
+			ids = append(ids, s.ID)
+		}
+		return ids
+	}
+
+	// The oldest session is signed out, with the refresh tokens bound to it
+	login()
+	time.Sleep(time.Millisecond)
+	login()
+	first := activeIDs()[0]
+	require.NoError(t, store.CreateToken(&models.Token{ID: "bound", AccessToken: "bound-at", RefreshToken: "bound-rt",
+		UserID: user.ID, SessionID: first, ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now()}))
+	time.Sleep(time.Millisecond)
+	login()
+	ids := activeIDs()
+	assert.Len(t, ids, 2)
+	assert.NotContains(t, ids, first)
+	bound, _ := store.GetTokenByRefreshToken("bound-rt")
+	assert.Nil(t, bound, "tokens of the evicted session are revoked")
+
+	// With reject, the sign-in fails and existing sessions are kept
+	h.config.SessionLimit.Eviction = configstore.SessionEvictionReject
+	rec := login()
+	assert.Contains(t, rec.Body.String(), "too many devices")
+	assert.Equal(t, ids, activeIDs())
+
+	// Users see their sessions and the limit, without session IDs
+	req := httptest.NewRequest(http.MethodGet, "/sessions", nil)
+	req.AddCookie(&http.Cookie{Name: session.UserSessionCookieName, Value: ids[1]})
+	rec = httptest.NewRecorder()
+	require.NoError(t, h.sessionManager.Middleware()(h.ListSessions)(echo.New().NewContext(req, rec)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), ids[0])
+
+	var response SessionsResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, 2, response.MaxSessions)
+	assert.Equal(t, configstore.SessionEvictionReject, response.Eviction)
+	require.Len(t, response.Sessions, 2)
+	assert.False(t, response.Sessions[0].Current)
+	assert.True(t, response.Sessions[1].Current)
+}
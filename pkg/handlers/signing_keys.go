@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+const defaultKeyRetentionDays = 30
+
+// keyUsage counts the stored tokens issued while each signing key was active
+type keyUsage struct {
+	Signed    int // tokens on record
+	Unexpired int // tokens that have not expired yet
+}
+
+// signingKeyUsage returns token counts keyed by signing key ID
+func signingKeyUsage(store storage.Storage) (map[string]keyUsage, error) {
+	tokens, err := store.ListTokens("", "", false)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	usage := make(map[string]keyUsage)
+	for _, token := range tokens {
+		if token.SigningKeyID == "" {
+			continue
+		}
+		u := usage[token.SigningKeyID]
+		u.Signed++
+		if token.ExpiresAt.After(now) {
+			u.Unexpired++
+		}
+		usage[token.SigningKeyID] = u
+	}
+	return usage, nil
+}
+
+// keyRetention returns how long expired, inactive keys are kept
+func keyRetention(cfg *configstore.ConfigData) time.Duration {
+	days := cfg.JWTState().KeyRetentionDays
+	if days <= 0 {
+		days = defaultKeyRetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// keyPurgeAt returns when an inactive key becomes eligible for purging, or the zero
+// time for keys that are never purged automatically
+func keyPurgeAt(key *models.SigningKey, retention time.Duration) time.Time {
+	if key.IsActive || key.ExpiresAt.IsZero() {
+		return time.Time{}
+	}
+	return key.ExpiresAt.Add(retention)
+}
+
+// PurgeExpiredKeys deletes inactive signing and encryption keys whose retention
+// window has passed, skipping keys that signed tokens which are still valid and
+// keys that clients are pinned to.
+// It returns the number of keys deleted.
+func (h *Handlers) PurgeExpiredKeys() (int, error) {
+	keys, err := h.storage.GetAllSigningKeys()
+	if err != nil {
+		return 0, err
+	}
+	usage, err := signingKeyUsage(h.storage)
+	if err != nil {
+		return 0, err
+	}
+	// A pinned client's tokens cannot be signed once its key is gone, so leave
+	// such keys for an administrator to re-pin the client first
+	pinned, err := pinnedKeyIDs(h.storage)
+	if err != nil {
+		return 0, err
+	}
+
+	retention := keyRetention(h.config)
+	now := time.Now()
+	deleted := 0
+	for _, key := range keys {
+		purgeAt := keyPurgeAt(key, retention)
+		if purgeAt.IsZero() || now.Before(purgeAt) || usage[key.ID].Unexpired > 0 || pinned[key.ID] {
+			continue
+		}
+		if err := h.storage.DeleteSigningKey(key.ID); err != nil {
+			continue
+		}
+		deleted++
+		h.logAudit(models.AuditActionKeyPurged, models.AuditActorSystem, "system",
+			"key", key.KID, models.AuditStatusSuccess, "", "",
+			map[string]interface{}{"id": key.ID, "use": keyUse(key), "created_at": key.CreatedAt,
+				"expires_at": key.ExpiresAt, "tokens_signed": usage[key.ID].Signed})
+	}
+	return deleted, nil
+}
+
+// StartKeyPurge periodically deletes expired keys past their retention window
+func (h *Handlers) StartKeyPurge(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if deleted, err := h.PurgeExpiredKeys(); err != nil {
+				log.Printf("Warning: Failed to purge expired keys: %v", err)
+			} else if deleted > 0 {
+				log.Printf("Purged %d expired keys", deleted)
+			}
+		}
+	}()
+}
+
+// keyUse returns the JWK "use" of a key, or "cookie" for session cookie keys
+func keyUse(key *models.SigningKey) string {
+	if key.IsEncryptionKey() {
+		return models.KeyUseEncryption
+	}
+	if key.IsCookieKey() {
+		return models.KeyUseCookie
+	}
+	return models.KeyUseSignature
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestPurgeExpiredKeys(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	h.config.JWT.KeyRetentionDays = 30
+
+	now := time.Now()
+	keys := map[string]*models.SigningKey{
+		"active":       {ID: "active", KID: "kid-active", IsActive: true, CreatedAt: now},
+		"in-use":       {ID: "in-use", KID: "kid-in-use", CreatedAt: now.AddDate(0, -3, 0), ExpiresAt: now.AddDate(0, 0, -40)},
+		"purgeable":    {ID: "purgeable", KID: "kid-purgeable", CreatedAt: now.AddDate(0, -3, 0), ExpiresAt: now.AddDate(0, 0, -40)},
+		"in-retention": {ID: "in-retention", KID: "kid-in-retention", CreatedAt: now.AddDate(0, -2, 0), ExpiresAt: now.AddDate(0, 0, -1)},
+	}
+	for _, key := range keys {
+		require.NoError(t, store.CreateSigningKey(key))
+	}
+
+	// A long-lived token issued under the old key keeps it from being purged
+	token := models.NewToken("at-old", "", client.ID, "", "openid", 60)
+	token.SigningKeyID = "in-use"
+	require.NoError(t, store.CreateToken(token))
+
+	issued, err := h.newToken(client.ID, "", "openid")
+	require.NoError(t, err)
+	assert.Equal(t, "active", issued.SigningKeyID)
+
+	deleted, err := h.PurgeExpiredKeys()
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	for id := range keys {
+		key, _ := store.GetSigningKey(id)
+		if id == "purgeable" {
+			assert.Nil(t, key, id)
+		} else {
+			assert.NotNil(t, key, id)
+		}
+	}
+
+	admin := NewAdminHandler(store, h.config, nil)
+	adminToken, err := crypto.GenerateAdminToken("keymaster", admin.adminSecret)
+	require.NoError(t, err)
+	bearer := "Bearer " + adminToken
+	call := func(method string, handler echo.HandlerFunc, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", nil)
+		if bearer != "" {
+			req.Header.Set(echo.HeaderAuthorization, bearer)
+		}
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		if id != "" {
+			c.SetParamNames("id")
+			c.SetParamValues(id)
+		}
+		require.NoError(t, handler(c))
+		return rec
+	}
+
+	// Deleting keys and reading their history needs an administrator
+	bearer = ""
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodDelete, admin.DeleteKey, "in-retention").Code)
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, admin.GetKeyHistory, "").Code)
+	bearer = "Bearer " + adminToken
+
+	assert.Equal(t, http.StatusConflict, call(http.MethodDelete, admin.DeleteKey, "active").Code)
+	assert.Equal(t, http.StatusConflict, call(http.MethodDelete, admin.DeleteKey, "in-use").Code)
+	assert.Equal(t, http.StatusOK, call(http.MethodDelete, admin.DeleteKey, "in-retention").Code)
+
+	rec := call(http.MethodGet, admin.GetKeyHistory, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var history []KeyHistoryEntry
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &history))
+
+	byKID := make(map[string]KeyHistoryEntry)
+	for _, entry := range history {
+		byKID[entry.KID] = entry
+	}
+	assert.Equal(t, 1, byKID["kid-in-use"].UnexpiredTokens)
+	assert.Equal(t, "expired", byKID["kid-in-use"].Status)
+	assert.NotNil(t, byKID["kid-in-use"].PurgeAfter)
+	assert.Equal(t, "purged", byKID["kid-purgeable"].Status)
+	assert.Equal(t, "purged", byKID["kid-in-retention"].Status)
+	assert.Equal(t, "active", byKID["kid-active"].Status)
+}
+
+func TestCookieKeysStaySecret(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+	h.config.SessionCookies.Encrypt = true
+
+	rotated, err := h.rotateCookieKeyIfDue()
+	require.NoError(t, err)
+	assert.True(t, rotated, "a cookie key is created when none is active")
+	rotated, err = h.rotateCookieKeyIfDue()
+	require.NoError(t, err)
+	assert.False(t, rotated)
+
+	keys, err := store.GetAllSigningKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	cookieKey := keys[0]
+	assert.Equal(t, models.KeyUseCookie, keyUse(cookieKey))
+
+	// Cookie keys never sign tokens, can't be pinned and aren't published
+	_, err = store.GetActiveSigningKey()
+	assert.Error(t, err)
+	_, err = pinnableKey(store, cookieKey.ID)
+	assert.Error(t, err)
+
+	rec := httptest.NewRecorder()
+	require.NoError(t, h.JWKS(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil), rec)))
+	assert.NotContains(t, rec.Body.String(), cookieKey.KID)
+
+	// Rotating signs everyone out once the grace period ends, so it needs an administrator
+	admin := NewAdminHandler(store, h.config, nil)
+	adminToken, err := crypto.GenerateAdminToken("keymaster", admin.adminSecret)
+	require.NoError(t, err)
+	rotate := func(bearer string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/settings/rotate-cookie-keys", nil)
+		if bearer != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		require.NoError(t, admin.RotateCookieKeys(echo.New().NewContext(req, rec)))
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusUnauthorized, rotate(""))
+	keys, err = store.GetAllSigningKeys()
+	require.NoError(t, err)
+	assert.Len(t, keys, 1)
+	assert.Equal(t, http.StatusOK, rotate(adminToken))
+	keys, err = store.GetAllSigningKeys()
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+var (
+	// storageBreakerRejections counts requests refused while the storage breaker was open
+	storageBreakerRejections atomic.Int64
+
+	// storageRequestsInFlight counts requests past StorageGuard that have not finished,
+	// which grows when the database slows down
+	storageRequestsInFlight atomic.Int64
+)
+
+// SetStorageBreaker makes StorageGuard and the readiness probe follow b. A nil
+// breaker, the default, never refuses requests.
+func (h *Handlers) SetStorageBreaker(b *storage.Breaker) {
+	h.storageBreaker = b
+}
+
+// StorageBreaker returns the breaker set with SetStorageBreaker, or nil
+func (h *Handlers) StorageBreaker() *storage.Breaker {
+	return h.storageBreaker
+}
+
+// StorageGuard returns middleware for endpoints that need storage. While the
+// storage breaker is open it answers 503 temporarily_unavailable with a
+// Retry-After header instead of letting the request wait on the database.
+func (h *Handlers) StorageGuard() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !h.storageBreaker.Open() {
+				storageRequestsInFlight.Add(1)
+				defer storageRequestsInFlight.Add(-1)
+				return next(c)
+			}
+			storageBreakerRejections.Add(1)
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(h.storageBreaker.RetryAfter().Seconds())))
+			return jsonError(c, http.StatusServiceUnavailable, ErrorTemporarilyUnavailable,
+				"The server is temporarily unable to reach its database")
+		}
+	}
+}
+
+// storageBreakerStats reports the breaker state for the readiness probe and admin stats
+func storageBreakerStats(b *storage.Breaker) map[string]interface{} {
+	stats := map[string]interface{}{
+		"requests_rejected":  storageBreakerRejections.Load(),
+		"requests_in_flight": storageRequestsInFlight.Load(),
+	}
+	if b != nil {
+		stats["breaker"] = b.Stats()
+	}
+	return stats
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+// unreachableStorage fails every health probe
+type unreachableStorage struct {
+	storage.Storage
+}
+
+func (unreachableStorage) Ping(context.Context) error {
+	return errors.New("server selection timeout")
+}
+
+func TestStorageGuardRefusesWhileBreakerOpen(t *testing.T) {
+	h, _, client, _ := setupRevokeTest(t)
+	breaker := storage.NewBreaker(unreachableStorage{h.storage}, configstore.StorageBreakerConfig{
+		Enabled: true, FailureThreshold: 1, RetryAfterSeconds: 15,
+	})
+	h.SetStorageBreaker(breaker)
+
+	token := func() *httptest.ResponseRecorder {
+		form := "grant_type=client_credentials&client_id=" + client.ID + "&client_secret=" + client.Secret
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.StorageGuard()(h.Token)(echo.New().NewContext(req, rec)))
+		return rec
+	}
+	ready := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.Ready(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/readyz", nil), rec)))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, token().Code)
+	assert.Contains(t, ready().Body.String(), `"status":"ready"`)
+
+	breaker.Probe()
+	rec := token()
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "15", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), ErrorTemporarilyUnavailable)
+
+	rec = ready()
+	assert.Equal(t, http.StatusOK, rec.Code, "an open breaker should not take the server out of rotation")
+	assert.Contains(t, rec.Body.String(), `"status":"degraded"`)
+	assert.Contains(t, rec.Body.String(), `"state":"open"`)
+}
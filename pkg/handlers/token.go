@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
@@ -10,6 +14,13 @@ import (
 
 	"github.com/prasenjit-net/openid-golang/pkg/crypto"
 	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+const (
+	defaultAuthCodeLifetime = 10 * time.Minute
+	// fapiAuthCodeLifetime is the longest authorization code lifetime FAPI allows
+	fapiAuthCodeLifetime = 60 * time.Second
 )
 
 const (
@@ -21,6 +32,12 @@ const (
 	GrantTypeClientCredentials = "client_credentials"
 	// GrantTypePassword is the resource owner password credentials grant type
 	GrantTypePassword = "password"
+	// GrantTypeDeviceCode is the device authorization grant type (RFC 8628)
+	GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+	// GrantTypeAPIKey exchanges a service account API key for an access token
+	GrantTypeAPIKey = "urn:openid-golang:params:oauth:grant-type:api-key"
+	// GrantTypeSAML2Bearer is the SAML 2.0 bearer assertion grant type (RFC 7522)
+	GrantTypeSAML2Bearer = "urn:ietf:params:oauth:grant-type:saml2-bearer"
 
 	// TokenTypeHintAccessToken is the access token type hint
 	TokenTypeHintAccessToken = "access_token"
@@ -28,6 +45,10 @@ const (
 	TokenTypeHintRefreshToken = "refresh_token"
 )
 
+// idTokenSigningAlgs are the algorithms ID tokens are signed with; they always use
+// the server's RSA key
+var idTokenSigningAlgs = []string{"RS256"}
+
 // TokenRequest represents a token request
 type TokenRequest struct {
 	GrantType    string
@@ -40,6 +61,10 @@ type TokenRequest struct {
 	Scope        string // For client_credentials and password grants
 	Username     string // For password grant
 	Password     string // For password grant
+	Assertion    string // For SAML bearer grant
+	DeviceCode   string // For device code grant
+	DeviceID     string // For refresh tokens bound to a device by the first-party login API
+	InstanceID   string // Authenticated client instance, for tokens bound to an install
 }
 
 // TokenResponse represents a token response
@@ -50,6 +75,28 @@ type TokenResponse struct {
 	RefreshToken string `json:"refresh_token,omitempty"`
 	IDToken      string `json:"id_token,omitempty"`
 	Scope        string `json:"scope,omitempty"` // For client_credentials grant
+
+	// Extra holds vendor-specific top-level members configured for the client
+	Extra map[string]interface{} `json:"-"`
+}
+
+// MarshalJSON adds the extra members to the standard ones
+func (r TokenResponse) MarshalJSON() ([]byte, error) {
+	type standard TokenResponse
+	data, err := json.Marshal(standard(r))
+	if err != nil || len(r.Extra) == 0 {
+		return data, err
+	}
+	members := map[string]interface{}{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+	for name, value := range r.Extra {
+		if !reservedTokenResponseParams[name] {
+			members[name] = value
+		}
+	}
+	return json.Marshal(members)
 }
 
 // Token handles the token endpoint (POST /token)
@@ -65,21 +112,62 @@ func (h *Handlers) Token(c echo.Context) error {
 		Scope:        c.FormValue("scope"),
 		Username:     c.FormValue("username"),
 		Password:     c.FormValue("password"),
+		Assertion:    c.FormValue("assertion"),
+		DeviceCode:   c.FormValue("device_code"),
+		DeviceID:     c.FormValue("device_id"),
+	}
+
+	// API keys authenticate a service account on their own, without a client
+	if req.GrantType == GrantTypeAPIKey {
+		return h.handleAPIKeyGrant(c, c.FormValue("api_key"), req.Scope)
 	}
 
 	// Try to get client credentials from Authorization header
+	authMethod := "client_secret_post"
 	if req.ClientID == "" || req.ClientSecret == "" {
 		clientID, clientSecret, ok := parseBasicAuth(c.Request().Header.Get("Authorization"))
 		if ok {
 			req.ClientID = clientID
 			req.ClientSecret = clientSecret
+			authMethod = "client_secret_basic"
 		}
 	}
+	if req.ClientSecret == "" {
+		authMethod = tokenEndpointAuthMethodNone
+	}
 
-	// Validate client
-	client, err := h.storage.ValidateClient(req.ClientID, req.ClientSecret)
-	if err != nil {
-		return ErrorInvalidClientAuth(c, "Invalid client credentials")
+	// Validate client, either by signed JWT assertion or by shared secret
+	var client *models.Client
+	var err error
+	if assertionType := c.FormValue("client_assertion_type"); assertionType != "" {
+		client, err = h.authenticateClientAssertion(assertionType, c.FormValue("client_assertion"), req.ClientID)
+		if err != nil {
+			return h.rejectClientAuth(c, req.ClientID, err.Error())
+		}
+	} else {
+		client, err = h.storage.ValidateClient(req.ClientID, req.ClientSecret)
+		if err != nil || client == nil {
+			return h.rejectClientAuth(c, req.ClientID, "Invalid client credentials")
+		}
+		// A client registered for another method must not fall back to its secret
+		if client.TokenEndpointAuthMethod != "" && client.TokenEndpointAuthMethod != authMethod {
+			return h.rejectClientAuth(c, client.ID, "Client must authenticate with "+client.TokenEndpointAuthMethod)
+		}
+	}
+	if !client.IsApproved() {
+		return jsonError(c, http.StatusBadRequest, ErrorUnauthorizedClient, "Client registration has not been approved")
+	}
+	if client.Disabled {
+		return jsonError(c, http.StatusBadRequest, ErrorUnauthorizedClient, "Client is disabled")
+	}
+	h.markClientUsed(client)
+	var instanceErr string
+	if req.InstanceID, instanceErr = h.authenticateClientInstance(c, client, req.GrantType); instanceErr != "" {
+		return h.rejectClientAuth(c, client.ID, instanceErr)
+	}
+
+	if !h.config.GrantsState().GrantTypeEnabled(req.GrantType) {
+		return jsonError(c, http.StatusBadRequest, ErrorUnsupportedGrantType, "Grant type is disabled on this server")
 	}
 
 	switch req.GrantType {
@@ -91,67 +179,115 @@ func (h *Handlers) Token(c echo.Context) error {
 		return h.handleClientCredentialsGrant(c, req, client)
 	case GrantTypePassword:
 		return h.handlePasswordGrant(c, req, client)
+	case GrantTypeSAML2Bearer:
+		return h.handleSAMLBearerGrant(c, req, client)
+	case GrantTypeDeviceCode:
+		return h.handleDeviceCodeGrant(c, req, client)
 	default:
 		return jsonError(c, http.StatusBadRequest, ErrorUnsupportedGrantType, "Grant type not supported")
 	}
 }
 
-// validateAndMarkAuthCode validates authorization code and marks it as used
+// validateAndMarkAuthCode validates authorization code and marks it as used.
+// When the code cannot be exchanged it writes the error response and returns a
+// nil code with the result of writing it.
 func (h *Handlers) validateAndMarkAuthCode(c echo.Context, req *TokenRequest) (*models.AuthorizationCode, error) {
 	authCode, err := h.storage.GetAuthorizationCode(req.Code)
 	if err != nil || authCode == nil {
 		return nil, ErrorInvalidAuthorizationCode(c, "Invalid authorization code")
 	}
 
-	// Check if code has already been used (replay attack prevention)
-	if authCode.Used {
-		_ = h.storage.RevokeTokensByAuthCode(authCode.Code)
-		_ = h.storage.DeleteAuthorizationCode(req.Code)
-		return nil, ErrorInvalidAuthorizationCode(c, "Authorization code has already been used")
+	now := time.Now()
+	if authCode.Used || authCode.IsExpired() {
+		return nil, h.rejectStaleAuthCode(c, req, authCode, now)
+	}
+
+	// Mark code as used before issuing anything; of several concurrent exchanges
+	// only one can mark it, and the others are treated as replays
+	marked, err := h.storage.MarkAuthorizationCodeUsed(req.Code, now)
+	if err != nil {
+		return nil, jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to redeem authorization code")
+	}
+	if !marked {
+		if current, err := h.storage.GetAuthorizationCode(req.Code); err == nil && current != nil {
+			authCode = current
+		}
+		authCode.Used = true
+		return nil, h.rejectStaleAuthCode(c, req, authCode, now)
 	}
 
-	// Mark code as used immediately to prevent concurrent replay
-	now := time.Now()
 	authCode.Used = true
 	authCode.UsedAt = &now
-	_ = h.storage.UpdateAuthorizationCode(authCode)
-
 	return authCode, nil
 }
 
-// validateAuthCodeConstraints validates expiry, client, redirect URI, and PKCE
-func (h *Handlers) validateAuthCodeConstraints(c echo.Context, authCode *models.AuthorizationCode, req *TokenRequest) error {
-	// Check if code is expired
-	if authCode.IsExpired() {
-		_ = h.storage.DeleteAuthorizationCode(req.Code)
-		return ErrorInvalidAuthorizationCode(c, "Authorization code expired")
+// rejectStaleAuthCode refuses a code that was already exchanged or has expired.
+// Tokens issued for a code presented again are revoked, since the code may have
+// been stolen (RFC 6749 section 4.1.2). The audit entry says who the code was
+// issued to, how old it is and how often it was refused, to tell a client's
+// retries from a replay.
+func (h *Handlers) rejectStaleAuthCode(c echo.Context, req *TokenRequest, authCode *models.AuthorizationCode, now time.Time) error {
+	authCode.ReuseCount++
+	_ = h.storage.UpdateAuthorizationCode(authCode)
+
+	details := map[string]interface{}{
+		"client_id":        authCode.ClientID,
+		"presented_by":     req.ClientID,
+		"age_seconds":      int64(now.Sub(authCode.CreatedAt).Seconds()),
+		"lifetime_seconds": int64(authCode.ExpiresAt.Sub(authCode.CreatedAt).Seconds()),
+		"reuse_count":      authCode.ReuseCount,
+	}
+	description := "Authorization code expired"
+	if authCode.Used {
+		_ = h.storage.RevokeTokensByAuthCode(authCode.Code)
+		description = "Authorization code has already been used"
+		details["reason"] = "used"
+		if authCode.UsedAt != nil {
+			details["since_use_seconds"] = int64(now.Sub(*authCode.UsedAt).Seconds())
+		}
+	} else {
+		details["reason"] = "expired"
 	}
 
+	actor := req.ClientID
+	if actor == "" {
+		actor = c.RealIP()
+	}
+	h.logAudit(models.AuditActionAuthCodeRejected, models.AuditActorClient, actor,
+		"authorization_code", authCode.Code[:min(16, len(authCode.Code))],
+		models.AuditStatusFailure, c.RealIP(), c.Request().UserAgent(), details)
+	return ErrorInvalidAuthorizationCode(c, description)
+}
+
+// validateAuthCodeConstraints validates client, redirect URI, and PKCE.
+// It returns true, with the result of writing the error response, when the code
+// must not be exchanged.
+func (h *Handlers) validateAuthCodeConstraints(c echo.Context, authCode *models.AuthorizationCode, req *TokenRequest) (bool, error) {
 	// Validate client ID
 	if authCode.ClientID != req.ClientID {
 		_ = h.storage.DeleteAuthorizationCode(req.Code)
-		return ErrorInvalidAuthorizationCode(c, "Client ID mismatch")
+		return true, ErrorInvalidAuthorizationCode(c, "Client ID mismatch")
 	}
 
 	// Validate redirect URI
 	if authCode.RedirectURI != req.RedirectURI {
 		_ = h.storage.DeleteAuthorizationCode(req.Code)
-		return ErrorInvalidAuthorizationCode(c, "Redirect URI mismatch")
+		return true, ErrorInvalidAuthorizationCode(c, "Redirect URI mismatch")
 	}
 
 	// Verify PKCE if used
 	if authCode.CodeChallenge != "" {
 		if req.CodeVerifier == "" {
 			_ = h.storage.DeleteAuthorizationCode(req.Code)
-			return jsonError(c, http.StatusBadRequest, ErrorInvalidRequest, "code_verifier required")
+			return true, jsonError(c, http.StatusBadRequest, ErrorInvalidRequest, "code_verifier required")
 		}
 		if !crypto.VerifyCodeChallenge(req.CodeVerifier, authCode.CodeChallenge, authCode.CodeChallengeMethod) {
 			_ = h.storage.DeleteAuthorizationCode(req.Code)
-			return ErrorInvalidAuthorizationCode(c, "Invalid code_verifier")
+			return true, ErrorInvalidAuthorizationCode(c, "Invalid code_verifier")
 		}
 	}
 
-	return nil
+	return false, nil
 }
 
 // generateIDTokenForAuthCode generates ID token with session claims if available
@@ -159,13 +295,16 @@ func (h *Handlers) generateIDTokenForAuthCode(user *models.User, client *models.
 	// Try to get user session for auth_time, acr, amr claims
 	userSession, _ := h.storage.GetUserSessionByUserID(authCode.UserID)
 
+	jwtManager, err := h.jwtManagerFor(client)
+	if err != nil {
+		return "", err
+	}
 	var idToken string
-	var err error
 
 	if userSession != nil && userSession.IsAuthenticated() {
 		// Include auth_time, acr, amr from user session
 		// No at_hash/c_hash needed for authorization code flow
-		idToken, err = h.jwtManager.GenerateIDTokenWithClaims(
+		idToken, err = jwtManager.GenerateIDTokenWithClaims(
 			user,
 			client.ID,
 			authCode.Nonce,
@@ -178,7 +317,7 @@ func (h *Handlers) generateIDTokenForAuthCode(user *models.User, client *models.
 		)
 	} else {
 		// Fallback to basic ID token without session-specific claims
-		idToken, err = h.jwtManager.GenerateIDToken(user, client.ID, authCode.Nonce, authCode.Scope)
+		idToken, err = jwtManager.GenerateIDToken(user, client.ID, authCode.Nonce, authCode.Scope)
 	}
 
 	return idToken, err
@@ -187,13 +326,13 @@ func (h *Handlers) generateIDTokenForAuthCode(user *models.User, client *models.
 func (h *Handlers) handleAuthorizationCodeGrant(c echo.Context, req *TokenRequest, client *models.Client) error {
 	// Validate and mark authorization code as used
 	authCode, err := h.validateAndMarkAuthCode(c, req)
-	if err != nil {
+	if authCode == nil {
 		return err
 	}
 
 	// Validate constraints (expiry, client, redirect URI, PKCE)
-	if validationErr := h.validateAuthCodeConstraints(c, authCode, req); validationErr != nil {
-		return validationErr
+	if rejected, err := h.validateAuthCodeConstraints(c, authCode, req); rejected {
+		return err
 	}
 
 	// Get user
@@ -202,25 +341,37 @@ func (h *Handlers) handleAuthorizationCodeGrant(c echo.Context, req *TokenReques
 		_ = h.storage.DeleteAuthorizationCode(req.Code)
 		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to get user")
 	}
+	if user == nil || !user.CanAuthenticate() {
+		_ = h.storage.DeleteAuthorizationCode(req.Code)
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "User account is disabled")
+	}
 
 	// Create tokens
-	token := models.NewToken(client.ID, user.ID, authCode.Scope, h.config.JWT.ExpiryMinutes)
-	token.AuthorizationCodeID = authCode.Code
-	if createErr := h.storage.CreateToken(token); createErr != nil {
+	token, err := h.newToken(client.ID, user.ID, authCode.Scope)
+	if err != nil {
 		_ = h.storage.DeleteAuthorizationCode(req.Code)
-		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to create token")
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate token")
+	}
+	token.AuthorizationCodeID = authCode.Code
+	token.ClaimsLocales = authCode.ClaimsLocales
+	token.InstanceID = req.InstanceID
+	if client.BindRefreshTokensToSession && token.RefreshToken != "" {
+		token.SessionID = authCode.SessionID
 	}
 
 	// Generate ID token with enhanced claims if user session exists
 	idToken, err := h.generateIDTokenForAuthCode(user, client, authCode)
 	if err != nil {
 		_ = h.storage.DeleteAuthorizationCode(req.Code)
-		_ = h.storage.DeleteToken(token.AccessToken)
 		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate ID token")
 	}
 
-	// Delete authorization code (one-time use completed)
-	_ = h.storage.DeleteAuthorizationCode(req.Code)
+	// The used code is kept until it is purged, so that presenting it again is
+	// recognized as a replay and revokes this token
+	if err := h.storage.CreateToken(token); err != nil {
+		_ = h.storage.DeleteAuthorizationCode(req.Code)
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to create token")
+	}
 
 	// Audit token issued for auth-code grant
 	h.logAudit(models.AuditActionTokenIssued, models.AuditActorUser, user.Username,
@@ -232,10 +383,11 @@ func (h *Handlers) handleAuthorizationCodeGrant(c echo.Context, req *TokenReques
 	response := TokenResponse{
 		AccessToken:  token.AccessToken,
 		TokenType:    "Bearer",
-		ExpiresIn:    h.config.JWT.ExpiryMinutes * 60,
+		ExpiresIn:    h.config.JWTState().ExpiryMinutes * 60,
 		RefreshToken: token.RefreshToken,
 		IDToken:      idToken,
 	}
+	h.addTokenResponseParams(&response, client, user, token.Scope, GrantTypeAuthorizationCode)
 
 	return c.JSON(http.StatusOK, response)
 }
@@ -243,7 +395,7 @@ func (h *Handlers) handleAuthorizationCodeGrant(c echo.Context, req *TokenReques
 func (h *Handlers) handleRefreshTokenGrant(c echo.Context, req *TokenRequest, client *models.Client) error {
 	// Get token by refresh token
 	oldToken, err := h.storage.GetTokenByRefreshToken(req.RefreshToken)
-	if err != nil {
+	if err != nil || oldToken == nil {
 		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "Invalid refresh token")
 	}
 
@@ -252,26 +404,91 @@ func (h *Handlers) handleRefreshTokenGrant(c echo.Context, req *TokenRequest, cl
 		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "Client ID mismatch")
 	}
 
+	// Refresh tokens from the first-party login API only work on the device they were issued to
+	if oldToken.DeviceID != "" && subtle.ConstantTimeCompare([]byte(req.DeviceID), []byte(oldToken.DeviceID)) != 1 {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "Refresh token is bound to another device")
+	}
+
+	// Refresh tokens issued to a client instance only work with that instance's credentials
+	if oldToken.InstanceID != "" && req.InstanceID != oldToken.InstanceID {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "Refresh token is bound to another client instance")
+	}
+
+	// A refresh token that was already used answers with the pair it was exchanged for
+	if oldToken.ReplacedBy != "" {
+		return h.replayRefreshTokenGrant(c, client, oldToken)
+	}
+
+	// The client may limit how long and how often a token family can be refreshed
+	if reason := refreshFamilyLimitReason(client, oldToken, time.Now()); reason != "" {
+		_ = h.storage.DeleteToken(oldToken.ID)
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, reason)
+	}
+
+	// A session-bound refresh token dies with its session, even if revocation was missed
+	if h.refreshSessionEnded(oldToken) {
+		_ = h.storage.DeleteToken(oldToken.ID)
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "Refresh token session has ended")
+	}
+
 	// Get user
 	user, userErr := h.storage.GetUserByID(oldToken.UserID)
 	if userErr != nil {
 		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to get user")
 	}
+	if user == nil || !user.CanAuthenticate() {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "User account is disabled")
+	}
 
 	// Create new tokens
-	newToken := models.NewToken(client.ID, user.ID, oldToken.Scope, h.config.JWT.ExpiryMinutes)
-	if createErr := h.storage.CreateToken(newToken); createErr != nil {
-		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to create token")
+	newToken, genErr := h.newToken(client.ID, user.ID, oldToken.Scope)
+	if genErr != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate token")
 	}
+	newToken.ClaimsLocales = oldToken.ClaimsLocales
+	newToken.SessionID = oldToken.SessionID
+	newToken.DeviceID = oldToken.DeviceID
+	newToken.InstanceID = oldToken.InstanceID
+	newToken.RefreshCount = oldToken.RefreshCount + 1
 
 	// Generate new ID token with scope filtering
-	idToken, tokenErr := h.jwtManager.GenerateIDToken(user, client.ID, "", oldToken.Scope)
+	jwtManager, tokenErr := h.jwtManagerFor(client)
+	if tokenErr != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate ID token")
+	}
+	idToken, tokenErr := jwtManager.GenerateIDToken(user, client.ID, "", oldToken.Scope)
 	if tokenErr != nil {
 		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate ID token")
 	}
 
-	// Delete old token
-	_ = h.storage.DeleteToken(oldToken.AccessToken)
+	// Replace the old token with the new one. Marking the old token replaced is a
+	// compare-and-set, so of several concurrent refreshes, on any replica, one wins
+	// and the others answer with its result. The old token lives on for the grace
+	// window to serve those answers.
+	grace := h.refreshGrace()
+	now := time.Now()
+	txErr := h.storage.RunInTransaction(func(tx storage.Storage) error {
+		replaced, err := tx.MarkTokenReplaced(oldToken.ID, newToken.ID, now, now.Add(grace))
+		if err != nil {
+			return err
+		}
+		if !replaced {
+			return errRefreshTokenReplaced
+		}
+		return tx.CreateToken(newToken)
+	})
+	if errors.Is(txErr, errRefreshTokenReplaced) {
+		if oldToken, err = h.storage.GetTokenByRefreshToken(req.RefreshToken); err != nil || oldToken == nil {
+			return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant, "Invalid refresh token")
+		}
+		return h.replayRefreshTokenGrant(c, client, oldToken)
+	}
+	if txErr != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to create token")
+	}
+	if grace <= 0 {
+		_ = h.storage.DeleteToken(oldToken.ID)
+	}
 
 	// Audit token issued via refresh
 	h.logAudit(models.AuditActionTokenIssued, models.AuditActorUser, user.Username,
@@ -283,14 +500,31 @@ func (h *Handlers) handleRefreshTokenGrant(c echo.Context, req *TokenRequest, cl
 	response := TokenResponse{
 		AccessToken:  newToken.AccessToken,
 		TokenType:    "Bearer",
-		ExpiresIn:    h.config.JWT.ExpiryMinutes * 60,
+		ExpiresIn:    h.config.JWTState().ExpiryMinutes * 60,
 		RefreshToken: newToken.RefreshToken,
 		IDToken:      idToken,
 	}
+	h.addTokenResponseParams(&response, client, user, newToken.Scope, GrantTypeRefreshToken)
 
 	return c.JSON(http.StatusOK, response)
 }
 
+// refreshFamilyLimitReason explains why the client's refresh token limits stop
+// token from being refreshed at now, or returns "" if they don't. A family's
+// last activity is when its newest refresh token was issued.
+func refreshFamilyLimitReason(client *models.Client, token *models.Token, now time.Time) string {
+	if client.RefreshTokenIdleTimeout > 0 {
+		idle := time.Duration(client.RefreshTokenIdleTimeout) * time.Second
+		if now.Sub(token.CreatedAt) > idle {
+			return fmt.Sprintf("Refresh token expired after %d seconds without use; sign in again", client.RefreshTokenIdleTimeout)
+		}
+	}
+	if client.RefreshTokenMaxUses > 0 && token.RefreshCount >= client.RefreshTokenMaxUses {
+		return fmt.Sprintf("Refresh token reached the limit of %d refreshes; sign in again", client.RefreshTokenMaxUses)
+	}
+	return ""
+}
+
 // parseBasicAuth parses HTTP Basic Authentication credentials
 func parseBasicAuth(auth string) (username, password string, ok bool) {
 	const prefix = "Basic "
@@ -331,7 +565,11 @@ func (h *Handlers) handleClientCredentialsGrant(c echo.Context, req *TokenReques
 	}
 
 	// 4. Generate access token (NO user - client is the resource owner)
-	token := models.NewToken(client.ID, "", requestedScope, h.config.JWT.ExpiryMinutes)
+	token, err := h.newToken(client.ID, "", requestedScope)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError,
+			"Failed to generate token")
+	}
 	if err := h.storage.CreateToken(token); err != nil {
 		return jsonError(c, http.StatusInternalServerError, ErrorServerError,
 			"Failed to create token")
@@ -349,9 +587,10 @@ func (h *Handlers) handleClientCredentialsGrant(c echo.Context, req *TokenReques
 	response := TokenResponse{
 		AccessToken: token.AccessToken,
 		TokenType:   "Bearer",
-		ExpiresIn:   h.config.JWT.ExpiryMinutes * 60,
+		ExpiresIn:   h.config.JWTState().ExpiryMinutes * 60,
 		Scope:       token.Scope,
 	}
+	h.addTokenResponseParams(&response, client, nil, token.Scope, GrantTypeClientCredentials)
 
 	return c.JSON(http.StatusOK, response)
 }
@@ -407,17 +646,21 @@ func (h *Handlers) handlePasswordGrant(c echo.Context, req *TokenRequest, client
 	}
 
 	// Authenticate the user
-	user, err := h.storage.GetUserByUsername(req.Username)
+	user, err := h.userByLoginIdentifier(req.Username)
 	if err != nil || user == nil {
 		return jsonError(c, http.StatusUnauthorized, ErrorInvalidGrant,
 			"Invalid username or password")
 	}
 
 	// Verify password
-	if !crypto.ValidatePassword(req.Password, user.PasswordHash) {
+	if !checkPassword(h.storage, h.config, user, req.Password) {
 		return jsonError(c, http.StatusUnauthorized, ErrorInvalidGrant,
 			"Invalid username or password")
 	}
+	if !user.CanAuthenticate() {
+		return jsonError(c, http.StatusBadRequest, ErrorInvalidGrant,
+			"User account is disabled")
+	}
 
 	// Determine scope
 	// If scope is requested, validate it against client's allowed scope
@@ -434,7 +677,11 @@ func (h *Handlers) handlePasswordGrant(c echo.Context, req *TokenRequest, client
 	}
 
 	// Generate tokens
-	token := models.NewToken(client.ID, user.ID, scope, h.config.JWT.ExpiryMinutes)
+	token, err := h.newToken(client.ID, user.ID, scope)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate token")
+	}
+	token.InstanceID = req.InstanceID
 
 	err = h.storage.CreateToken(token)
 	if err != nil {
@@ -444,7 +691,11 @@ func (h *Handlers) handlePasswordGrant(c echo.Context, req *TokenRequest, client
 	// Generate ID token if openid scope is requested
 	var idToken string
 	if strings.Contains(scope, "openid") {
-		idToken, err = h.jwtManager.GenerateIDToken(user, client.ID, "", scope)
+		jwtManager, jmErr := h.jwtManagerFor(client)
+		if jmErr != nil {
+			return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate ID token")
+		}
+		idToken, err = jwtManager.GenerateIDToken(user, client.ID, "", scope)
 		if err != nil {
 			return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to generate ID token")
 		}
@@ -457,14 +708,17 @@ func (h *Handlers) handlePasswordGrant(c echo.Context, req *TokenRequest, client
 		map[string]interface{}{"grant_type": "password", "client_id": client.ID, "scope": scope})
 
 	// Return token response
-	return c.JSON(http.StatusOK, TokenResponse{
+	response := TokenResponse{
 		AccessToken:  token.AccessToken,
 		TokenType:    "Bearer",
-		ExpiresIn:    h.config.JWT.ExpiryMinutes * 60,
+		ExpiresIn:    h.config.JWTState().ExpiryMinutes * 60,
 		RefreshToken: token.RefreshToken,
 		IDToken:      idToken,
 		Scope:        scope,
-	})
+	}
+	h.addTokenResponseParams(&response, client, user, scope, GrantTypePassword)
+
+	return c.JSON(http.StatusOK, response)
 }
 
 func min(a, b int) int {
@@ -473,3 +727,51 @@ func min(a, b int) int {
 	}
 	return b
 }
+
+// refreshSessionEnded reports whether token is bound to a sign-in session that no longer exists
+func (h *Handlers) refreshSessionEnded(token *models.Token) bool {
+	if token.SessionID == "" {
+		return false
+	}
+	userSession, err := h.storage.GetUserSession(token.SessionID)
+	return err != nil || userSession == nil
+}
+
+// newToken creates a token with random, prefixed access and refresh token values
+func (h *Handlers) newToken(clientID, userID, scope string) (*models.Token, error) {
+	settings := h.config.JWTState()
+	accessToken, err := crypto.GenerateOpaqueToken(crypto.AccessTokenPrefix, settings.TokenLength)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := crypto.GenerateOpaqueToken(crypto.RefreshTokenPrefix, settings.TokenLength)
+	if err != nil {
+		return nil, err
+	}
+	token := models.NewToken(accessToken, refreshToken, clientID, userID, scope, settings.ExpiryMinutes)
+	// Record the signing key so it is not purged while tokens it signed are still valid
+	token.SigningKeyID = h.signingKeyIDFor(clientID)
+	return token, nil
+}
+
+// newAuthorizationCode creates an authorization code with a random, prefixed code value
+func (h *Handlers) newAuthorizationCode(clientID, userID, redirectURI, scope string) (*models.AuthorizationCode, error) {
+	code, err := crypto.GenerateOpaqueToken(crypto.AuthorizationCodePrefix, h.config.JWTState().TokenLength)
+	if err != nil {
+		return nil, err
+	}
+	return models.NewAuthorizationCode(code, clientID, userID, redirectURI, scope, h.authCodeLifetime()), nil
+}
+
+// authCodeLifetime is how long new authorization codes can be exchanged
+func (h *Handlers) authCodeLifetime() time.Duration {
+	settings := h.config.JWTState()
+	lifetime := defaultAuthCodeLifetime
+	if settings.AuthCodeLifetimeSeconds > 0 {
+		lifetime = time.Duration(settings.AuthCodeLifetimeSeconds) * time.Second
+	}
+	if settings.FAPIAuthCodeLifetime && lifetime > fapiAuthCodeLifetime {
+		lifetime = fapiAuthCodeLifetime
+	}
+	return lifetime
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+func createTestAuthCode(t *testing.T, store storage.Storage, client *models.Client, userID, code string) {
+	require.NoError(t, store.CreateAuthorizationCode(&models.AuthorizationCode{
+		Code:        code,
+		ClientID:    client.ID,
+		UserID:      userID,
+		RedirectURI: client.RedirectURIs[0],
+		Scope:       "openid",
+		ExpiresAt:   time.Now().Add(5 * time.Minute),
+	}))
+}
+
+func codeExchangeRequest(t *testing.T, h *Handlers, client *models.Client, code string) *httptest.ResponseRecorder {
+	return codeExchangeRequestTo(t, h, client, code, client.RedirectURIs[0])
+}
+
+func codeExchangeRequestTo(t *testing.T, h *Handlers, client *models.Client, code, redirectURI string) *httptest.ResponseRecorder {
+	form := url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {client.ID},
+		"client_secret": {client.Secret},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec := httptest.NewRecorder()
+	require.NoError(t, h.Token(echo.New().NewContext(req, rec)))
+	return rec
+}
+
+func TestRejectedCodeExchangeIssuesNothing(t *testing.T) {
+	h, store, client, token := setupRevokeTest(t)
+	require.NoError(t, store.CreateUser(&models.User{ID: token.UserID, Username: "rejected", Email: "rejected@example.com"}))
+
+	// An unknown code is answered once, without going on to issue tokens
+	rec := codeExchangeRequest(t, h, client, "no-such-code")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrorInvalidGrant)
+	assert.NotContains(t, rec.Body.String(), "access_token")
+
+	// So is a code presented with another redirect URI
+	createTestAuthCode(t, store, client, token.UserID, "mismatched-code")
+	rec = codeExchangeRequestTo(t, h, client, "mismatched-code", "https://elsewhere.example.com/cb")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "access_token")
+
+	tokens, err := store.GetTokensByAuthCode("mismatched-code")
+	require.NoError(t, err)
+	assert.Empty(t, tokens)
+}
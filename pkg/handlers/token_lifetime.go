@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"time"
+)
+
+// idTokenExpiry returns the configured ID token lifetime, falling back to the
+// access token lifetime for configurations that predate the separate setting
+func (h *Handlers) idTokenExpiry() time.Duration {
+	settings := h.config.JWTState()
+	minutes := settings.IDTokenExpiryMinutes
+	if minutes <= 0 {
+		minutes = settings.ExpiryMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// clockSkew returns the leeway allowed on exp, nbf and iat of inbound assertions
+func (h *Handlers) clockSkew() time.Duration {
+	seconds := h.config.JWTState().ClockSkewSeconds
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
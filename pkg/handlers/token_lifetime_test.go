@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestIDTokenExpiry(t *testing.T) {
+	h, _, client, _ := setupRevokeTest(t)
+	h.config.JWT.ExpiryMinutes = 60
+	h.config.JWT.IDTokenExpiryMinutes = 5
+
+	jm, err := h.jwtManagerFor(client)
+	require.NoError(t, err)
+	idToken, err := jm.GenerateIDToken(&models.User{ID: "user-1"}, client.ID, "", "openid")
+	require.NoError(t, err)
+	claims, err := jm.ValidateToken(idToken)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), claims.ExpiresAt.Time, 5*time.Second)
+
+	accessToken, err := jm.GenerateAccessToken(&models.User{ID: "user-1"}, client.ID, "openid")
+	require.NoError(t, err)
+	parsed, err := jm.ValidateToken(accessToken)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(60*time.Minute), parsed.ExpiresAt.Time, 5*time.Second)
+}
+
+func TestAuthCodeLifetime(t *testing.T) {
+	h, _, client, _ := setupRevokeTest(t)
+	code, err := h.newAuthorizationCode(client.ID, "user-1", client.RedirectURIs[0], "openid")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), code.ExpiresAt, 5*time.Second)
+
+	h.config.JWT.AuthCodeLifetimeSeconds = 300
+	assert.Equal(t, 5*time.Minute, h.authCodeLifetime())
+	h.config.JWT.FAPIAuthCodeLifetime = true
+	assert.Equal(t, time.Minute, h.authCodeLifetime())
+	h.config.JWT.AuthCodeLifetimeSeconds = 30
+	assert.Equal(t, 30*time.Second, h.authCodeLifetime())
+}
+
+func TestAuthCodeReuseDiagnostics(t *testing.T) {
+	h, store, client, token := setupRevokeTest(t)
+	require.NoError(t, store.CreateUser(&models.User{ID: token.UserID, Username: "retry", Email: "retry@example.com"}))
+	now := time.Now()
+	issue := func(code string, issuedAt, expiresAt time.Time) {
+		authCode := &models.AuthorizationCode{Code: code, ClientID: client.ID, UserID: token.UserID,
+			RedirectURI: client.RedirectURIs[0], Scope: "openid", ExpiresAt: expiresAt}
+		require.NoError(t, store.CreateAuthorizationCode(authCode))
+		authCode.CreatedAt = issuedAt
+		require.NoError(t, store.UpdateAuthorizationCode(authCode))
+	}
+	issue("once", now.Add(-30*time.Second), now.Add(time.Minute))
+	issue("stale", now.Add(-2*time.Minute), now.Add(-time.Minute))
+
+	rec := codeExchangeRequest(t, h, client, "once")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// Each retry is refused and revokes what the code was exchanged for
+	for i := 0; i < 2; i++ {
+		rec = codeExchangeRequest(t, h, client, "once")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "already been used")
+	}
+	issued, err := store.GetTokensByAuthCode("once")
+	require.NoError(t, err)
+	assert.Empty(t, issued, "tokens issued for a replayed code are revoked")
+
+	rec = codeExchangeRequest(t, h, client, "stale")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "expired")
+
+	logs, err := store.GetAuditLogs(models.AuditFilter{Action: models.AuditActionAuthCodeRejected})
+	require.NoError(t, err)
+	require.Len(t, logs, 3)
+	byReason := map[string][]*models.AuditLog{}
+	for _, entry := range logs {
+		byReason[entry.Details["reason"].(string)] = append(byReason[entry.Details["reason"].(string)], entry)
+	}
+	require.Len(t, byReason["used"], 2)
+	reuses := []interface{}{}
+	for _, entry := range byReason["used"] {
+		assert.Equal(t, client.ID, entry.Details["client_id"])
+		assert.InDelta(t, 30, toFloat(entry.Details["age_seconds"]), 5)
+		reuses = append(reuses, toFloat(entry.Details["reuse_count"]))
+	}
+	assert.ElementsMatch(t, []interface{}{1.0, 2.0}, reuses)
+	require.Len(t, byReason["expired"], 1)
+	assert.InDelta(t, 120, toFloat(byReason["expired"][0].Details["age_seconds"]), 5)
+	assert.InDelta(t, 60, toFloat(byReason["expired"][0].Details["lifetime_seconds"]), 1)
+}
+
+// toFloat reads a number from audit details, which hold the value logged or,
+// once stored as JSON, a float64
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return -1
+}
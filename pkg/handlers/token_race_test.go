@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// These tests are most useful under the race detector; make test-race and CI run
+// them repeatedly with -race
+
+func TestConcurrentCodeExchangeIssuesOnce(t *testing.T) {
+	h, store, client, token := setupRevokeTest(t)
+	require.NoError(t, store.CreateUser(&models.User{ID: token.UserID, Username: "racer", Email: "racer@example.com"}))
+	createTestAuthCode(t, store, client, token.UserID, "contested-code")
+
+	const attempts = 20
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, attempts)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = codeExchangeRequest(t, h, client, "contested-code")
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, rec := range responses {
+		if rec.Code == http.StatusOK {
+			succeeded++
+			continue
+		}
+		assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+		assert.Contains(t, rec.Body.String(), ErrorInvalidGrant)
+	}
+	assert.Equal(t, 1, succeeded, "an authorization code must be exchanged only once")
+}
+
+func TestConcurrentCodeExchangesAndRefreshes(t *testing.T) {
+	h, store, client, token := setupRevokeTest(t)
+	require.NoError(t, store.CreateUser(&models.User{ID: token.UserID, Username: "busy", Email: "busy@example.com"}))
+
+	// Each worker exchanges its own code and then rotates the refresh token a few
+	// times, while the others do the same against the shared storage
+	const workers, rotations = 8, 5
+	var wg sync.WaitGroup
+	errs := make(chan string, workers*(rotations+1))
+	for w := 0; w < workers; w++ {
+		code := fmt.Sprintf("code-%d", w)
+		createTestAuthCode(t, store, client, token.UserID, code)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := codeExchangeRequest(t, h, client, code)
+			if rec.Code != http.StatusOK {
+				errs <- rec.Body.String()
+				return
+			}
+			var resp TokenResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				errs <- err.Error()
+				return
+			}
+			for r := 0; r < rotations; r++ {
+				rec := refreshTokenRequest(t, h, client, resp.RefreshToken)
+				if rec.Code != http.StatusOK {
+					errs <- rec.Body.String()
+					return
+				}
+				previous := resp.RefreshToken
+				resp = TokenResponse{}
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+					errs <- err.Error()
+					return
+				}
+				if resp.RefreshToken == previous {
+					errs <- "refresh token was not rotated"
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// Without a grace window each rotation deletes the token it replaced, leaving
+	// the last one of each chain besides the fixture's own
+	tokens, err := store.ListTokens(client.ID, token.UserID, false)
+	require.NoError(t, err)
+	assert.Len(t, tokens, 1+workers)
+}
+
+func TestConcurrentRequestsDuringConfigReload(t *testing.T) {
+	h, store, client, token := setupRevokeTest(t)
+	require.NoError(t, store.CreateUser(&models.User{ID: token.UserID, Username: "reloaded", Email: "reloaded@example.com"}))
+
+	// Reloads replace the token settings, SAML issuers and grant restrictions while
+	// code exchanges and discovery requests read them
+	const workers = 8
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			next := configstore.DefaultConfig()
+			next.JWT.ExpiryMinutes = 30 + i%2*30
+			next.SAMLIssuers = []configstore.SAMLIssuerConfig{{EntityID: fmt.Sprintf("https://idp-%d.example.com", i)}}
+			h.config.ApplyReload(next)
+		}
+	}()
+	errs := make(chan string, workers*2)
+	for w := 0; w < workers; w++ {
+		code := fmt.Sprintf("reload-code-%d", w)
+		createTestAuthCode(t, store, client, token.UserID, code)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rec := codeExchangeRequest(t, h, client, code); rec.Code != http.StatusOK {
+				errs <- rec.Body.String()
+			}
+			rec := httptest.NewRecorder()
+			if err := h.Discovery(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil), rec)); err != nil || rec.Code != http.StatusOK {
+				errs <- rec.Body.String()
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// reservedTokenResponseParams are token response members defined by OAuth 2.0 and
+// OpenID Connect; extra parameters cannot replace them
+var reservedTokenResponseParams = map[string]bool{
+	"access_token":      true,
+	"token_type":        true,
+	"expires_in":        true,
+	"refresh_token":     true,
+	"id_token":          true,
+	"scope":             true,
+	"error":             true,
+	"error_description": true,
+	"error_uri":         true,
+}
+
+// TokenResponseMapper adds vendor-specific members to token responses from code,
+// for values the per-client templates cannot express. user is nil for grants
+// without a user, such as client_credentials. Members it returns replace template
+// values of the same name; reserved members are ignored.
+type TokenResponseMapper func(client *models.Client, user *models.User, scope, grantType string) map[string]interface{}
+
+// SetTokenResponseMapper installs a hook that adds members to every token response
+// issued to a client
+func (h *Handlers) SetTokenResponseMapper(m TokenResponseMapper) {
+	h.tokenResponseMapper = m
+}
+
+// ValidateTokenResponseParams checks that extra token response parameters use
+// unreserved names and valid templates
+func ValidateTokenResponseParams(params map[string]string) error {
+	for name, value := range params {
+		if name == "" || reservedTokenResponseParams[name] {
+			return fmt.Errorf("%q cannot be used as a token response parameter", name)
+		}
+		if _, err := parseTokenResponseParam(name, value); err != nil {
+			return fmt.Errorf("invalid template for %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func parseTokenResponseParam(name, value string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=zero").Parse(value)
+}
+
+// addTokenResponseParams renders the client's extra token response parameters
+// and applies the mapper hook. Templates see issuer, client_id, client_name,
+// grant_type and scope, plus sub, username, email and name when there is a user.
+// Parameters that render empty are left out.
+func (h *Handlers) addTokenResponseParams(response *TokenResponse, client *models.Client, user *models.User, scope, grantType string) {
+	if client == nil {
+		return
+	}
+	extra := map[string]interface{}{}
+
+	if len(client.TokenResponseParams) > 0 {
+		data := map[string]string{
+			"issuer":      h.config.Issuer,
+			"client_id":   client.ID,
+			"client_name": client.Name,
+			"grant_type":  grantType,
+			"scope":       scope,
+		}
+		if user != nil {
+			data["sub"] = user.ID
+			data["username"] = user.Username
+			data["email"] = user.Email
+			data["name"] = user.Name
+		}
+		for name, value := range client.TokenResponseParams {
+			if reservedTokenResponseParams[name] {
+				continue
+			}
+			tmpl, err := parseTokenResponseParam(name, value)
+			if err != nil {
+				log.Printf("Warning: Invalid token response parameter %s for client %s: %v", name, client.ID, err)
+				continue
+			}
+			var rendered strings.Builder
+			if err := tmpl.Execute(&rendered, data); err != nil {
+				log.Printf("Warning: Failed to render token response parameter %s for client %s: %v", name, client.ID, err)
+				continue
+			}
+			if rendered.Len() > 0 {
+				extra[name] = rendered.String()
+			}
+		}
+	}
+
+	if h.tokenResponseMapper != nil {
+		for name, value := range h.tokenResponseMapper(client, user, scope, grantType) {
+			if !reservedTokenResponseParams[name] {
+				extra[name] = value
+			}
+		}
+	}
+
+	if len(extra) > 0 {
+		response.Extra = extra
+	}
+}
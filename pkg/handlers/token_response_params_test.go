@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestTokenResponseParams(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	client.Scope = "reports"
+	client.TokenResponseParams = map[string]string{
+		"tenant":       "acme",
+		"api_base_url": "https://api.example.com/{{.client_id}}",
+		"user_email":   "{{.email}}", // No user with client_credentials, so left out
+	}
+	require.NoError(t, store.UpdateClient(client))
+	h.SetTokenResponseMapper(func(client *models.Client, user *models.User, scope, grantType string) map[string]interface{} {
+		return map[string]interface{}{"region": "eu-west-1", "access_token": "overridden"}
+	})
+
+	form := "grant_type=client_credentials&client_id=" + client.ID + "&client_secret=" + client.Secret
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec := httptest.NewRecorder()
+	require.NoError(t, h.Token(echo.New().NewContext(req, rec)))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, "acme", response["tenant"])
+	assert.Equal(t, "https://api.example.com/test-client", response["api_base_url"])
+	assert.Equal(t, "eu-west-1", response["region"])
+	assert.NotContains(t, response, "user_email")
+	assert.NotEqual(t, "overridden", response["access_token"], "reserved members cannot be replaced")
+	assert.Equal(t, "reports", response["scope"])
+
+	assert.Error(t, ValidateTokenResponseParams(map[string]string{"id_token": "x"}))
+	assert.Error(t, ValidateTokenResponseParams(map[string]string{"tenant": "{{.client_id"}))
+	assert.NoError(t, ValidateTokenResponseParams(map[string]string{"tenant": "{{.client_name}}"}))
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// Timeline item types of UserActivityItem
+const (
+	ActivityTypeAudit   = "audit"
+	ActivityTypeToken   = "token"
+	ActivityTypeConsent = "consent"
+	ActivityTypeSession = "session"
+)
+
+// UserActivity is the recent activity of one user, for the timeline on the user
+// detail page of the admin console. Every list is newest first.
+type UserActivity struct {
+	UserID   string                `json:"user_id"`
+	Username string                `json:"username"`
+	Logins   []*models.AuditLog    `json:"logins"` // Sign-ins, successful or not
+	Tokens   []UserTokenMetadata   `json:"tokens"`
+	Consents []*models.Consent     `json:"consents"`
+	Sessions []*models.UserSession `json:"sessions"`
+	Events   []*models.AuditLog    `json:"events"`   // Audit entries naming the user
+	Timeline []UserActivityItem    `json:"timeline"` // Tokens, consents, sessions and events together
+}
+
+// UserActivityItem is one entry of the merged timeline. ID refers to the record
+// of its type in the other lists of the response.
+type UserActivityItem struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`   // "audit", "token", "consent" or "session"
+	Action   string    `json:"action"` // Audit action, or "issued", "granted", "signed_in"
+	ClientID string    `json:"client_id,omitempty"`
+	Status   string    `json:"status,omitempty"` // Audit status
+	ID       string    `json:"id"`
+}
+
+// GetUserActivity returns a user's recent sign-ins, tokens, consents, sessions and
+// audit events in one response (GET /api/admin/users/:id/activity).
+// Query params:
+//
+//	limit (int, default 50, max 200) — entries per list
+func (h *AdminHandler) GetUserActivity(c echo.Context) error {
+	if _, ok := h.authenticatedAdmin(c); !ok {
+		return nil
+	}
+	limit := 50
+	if l := c.QueryParam("limit"); l != "" {
+		if v, err := strconv.Atoi(l); err == nil && v > 0 {
+			limit = v
+		}
+	}
+	if limit > 200 {
+		limit = 200
+	}
+
+	user, err := h.store.GetUserByID(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get user"})
+	}
+	if user == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+
+	activity, err := h.collectUserActivity(user, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get user activity: " + err.Error()})
+	}
+	return c.JSON(http.StatusOK, activity)
+}
+
+// collectUserActivity gathers up to limit of each kind of activity for user
+func (h *AdminHandler) collectUserActivity(user *models.User, limit int) (*UserActivity, error) {
+	activity := &UserActivity{UserID: user.ID, Username: user.Username}
+
+	var err error
+	if activity.Events, err = h.recentUserAuditLogs(user, "", limit); err != nil {
+		return nil, fmt.Errorf("audit events: %w", err)
+	}
+	logins, err := h.recentUserAuditLogs(user, models.AuditActionLogin, limit)
+	if err != nil {
+		return nil, fmt.Errorf("logins: %w", err)
+	}
+	failed, err := h.recentUserAuditLogs(user, models.AuditActionLoginFailed, limit)
+	if err != nil {
+		return nil, fmt.Errorf("logins: %w", err)
+	}
+	activity.Logins = newestAuditLogs(append(logins, failed...), limit)
+
+	tokens, err := h.store.ListTokens("", user.ID, false)
+	if err != nil {
+		return nil, fmt.Errorf("tokens: %w", err)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.After(tokens[j].CreatedAt) })
+	activity.Tokens = []UserTokenMetadata{}
+	for _, t := range tokens[:min(len(tokens), limit)] {
+		activity.Tokens = append(activity.Tokens, UserTokenMetadata{
+			ID:              t.ID,
+			TokenType:       t.TokenType,
+			ClientID:        t.ClientID,
+			Scope:           t.Scope,
+			HasRefreshToken: t.RefreshToken != "",
+			ExpiresAt:       t.ExpiresAt,
+			CreatedAt:       t.CreatedAt,
+		})
+	}
+
+	consents, err := h.store.GetConsentsByUserID(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("consents: %w", err)
+	}
+	sort.Slice(consents, func(i, j int) bool { return consents[i].UpdatedAt.After(consents[j].UpdatedAt) })
+	activity.Consents = append([]*models.Consent{}, consents[:min(len(consents), limit)]...)
+
+	sessions, err := h.store.GetUserSessionsByUserID(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("sessions: %w", err)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].AuthTime.After(sessions[j].AuthTime) })
+	activity.Sessions = append([]*models.UserSession{}, sessions[:min(len(sessions), limit)]...)
+
+	activity.Timeline = userActivityTimeline(activity, limit)
+	return activity, nil
+}
+
+// recentUserAuditLogs returns up to limit of the newest audit entries naming user,
+// optionally with the given action. Entries name a user by ID or, for their own
+// logins, by username.
+func (h *AdminHandler) recentUserAuditLogs(user *models.User, action models.AuditAction, limit int) ([]*models.AuditLog, error) {
+	var entries []*models.AuditLog
+	for _, subject := range userSubjects(user) {
+		found, err := h.store.GetAuditLogs(models.AuditFilter{Subject: subject, Action: action, Limit: limit})
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, found...)
+	}
+	return newestAuditLogs(entries, limit), nil
+}
+
+// newestAuditLogs drops duplicate entries and returns up to limit of the rest, newest first
+func newestAuditLogs(entries []*models.AuditLog, limit int) []*models.AuditLog {
+	seen := map[string]bool{}
+	unique := []*models.AuditLog{}
+	for _, e := range entries {
+		if !seen[e.ID] {
+			seen[e.ID] = true
+			unique = append(unique, e)
+		}
+	}
+	sort.SliceStable(unique, func(i, j int) bool { return unique[i].Timestamp.After(unique[j].Timestamp) })
+	return unique[:min(len(unique), limit)]
+}
+
+// userActivityTimeline merges the lists of activity into up to limit items,
+// newest first. Logins are not repeated, as they are among the events.
+func userActivityTimeline(activity *UserActivity, limit int) []UserActivityItem {
+	timeline := []UserActivityItem{}
+	for _, e := range activity.Events {
+		timeline = append(timeline, UserActivityItem{
+			Time: e.Timestamp, Type: ActivityTypeAudit, Action: string(e.Action), Status: string(e.Status), ID: e.ID,
+		})
+	}
+	for _, t := range activity.Tokens {
+		timeline = append(timeline, UserActivityItem{
+			Time: t.CreatedAt, Type: ActivityTypeToken, Action: "issued", ClientID: t.ClientID, ID: t.ID,
+		})
+	}
+	for _, c := range activity.Consents {
+		timeline = append(timeline, UserActivityItem{
+			Time: c.UpdatedAt, Type: ActivityTypeConsent, Action: "granted", ClientID: c.ClientID, ID: c.ID,
+		})
+	}
+	for _, s := range activity.Sessions {
+		timeline = append(timeline, UserActivityItem{
+			Time: s.AuthTime, Type: ActivityTypeSession, Action: "signed_in", ID: s.ID,
+		})
+	}
+	sort.SliceStable(timeline, func(i, j int) bool { return timeline[i].Time.After(timeline[j].Time) })
+	return timeline[:min(len(timeline), limit)]
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storagetest"
+)
+
+func TestGetUserActivity(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	admin := NewAdminHandler(store, h.config, nil)
+
+	start := time.Now().Add(-time.Hour)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+	user := storagetest.User()
+	session := storagetest.UserSession(user, func(s *models.UserSession) { s.AuthTime = at(1) })
+	token := storagetest.Token(user, client)
+	storagetest.Create(t, store, user, session, token,
+		&models.Consent{ID: "consent", UserID: user.ID, ClientID: client.ID, Scopes: []string{"openid"}},
+		&models.AuditLog{ID: "failed", Timestamp: at(0), Action: models.AuditActionLoginFailed,
+			Actor: user.Username, Status: models.AuditStatusFailure},
+		&models.AuditLog{ID: "login", Timestamp: at(1), Action: models.AuditActionLogin,
+			Actor: user.Username, ResourceID: user.ID, Status: models.AuditStatusSuccess},
+		&models.AuditLog{ID: "disabled", Timestamp: at(2), Action: models.AuditActionAdminUserUpdated,
+			Actor: "admin", Resource: "user", ResourceID: user.ID, Status: models.AuditStatusSuccess},
+		&models.AuditLog{ID: "other", Timestamp: at(3), Action: models.AuditActionLogin, Actor: "someone-else"})
+
+	adminToken, err := crypto.GenerateAdminToken("support", admin.adminSecret)
+	require.NoError(t, err)
+	bearer := "Bearer " + adminToken
+	get := func(id, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+		req.Header.Set(echo.HeaderAuthorization, bearer)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		require.NoError(t, admin.GetUserActivity(c))
+		return rec
+	}
+
+	bearer = ""
+	assert.Equal(t, http.StatusUnauthorized, get(user.ID, "").Code)
+	bearer = "Bearer " + adminToken
+
+	rec := get(user.ID, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.NotContains(t, rec.Body.String(), token.AccessToken)
+	var activity UserActivity
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &activity))
+	assert.Equal(t, user.Username, activity.Username)
+
+	ids := func(entries []*models.AuditLog) []string {
+		var out []string
+		for _, e := range entries {
+			out = append(out, e.ID)
+		}
+		return out
+	}
+	assert.Equal(t, []string{"login", "failed"}, ids(activity.Logins))
+	assert.Equal(t, []string{"disabled", "login", "failed"}, ids(activity.Events))
+	require.Len(t, activity.Tokens, 1)
+	assert.Equal(t, token.ID, activity.Tokens[0].ID)
+	assert.Len(t, activity.Consents, 1)
+	assert.Len(t, activity.Sessions, 1)
+
+	// The timeline holds everything once, newest first
+	require.Len(t, activity.Timeline, 6)
+	for i := 1; i < len(activity.Timeline); i++ {
+		assert.False(t, activity.Timeline[i].Time.After(activity.Timeline[i-1].Time))
+	}
+	types := map[string]int{}
+	for _, item := range activity.Timeline {
+		types[item.Type]++
+	}
+	assert.Equal(t, map[string]int{ActivityTypeAudit: 3, ActivityTypeToken: 1, ActivityTypeConsent: 1, ActivityTypeSession: 1}, types)
+
+	// The limit applies to each list
+	require.NoError(t, json.Unmarshal(get(user.ID, "limit=2").Body.Bytes(), &activity))
+	assert.Len(t, activity.Events, 2)
+	assert.Len(t, activity.Timeline, 2)
+
+	assert.Equal(t, http.StatusNotFound, get("missing", "").Code)
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+// UserDataExport is the machine-readable dump of everything stored about a user,
+// returned by the data export endpoint. Token values and session IDs, which work
+// as bearer credentials, are never included.
+type UserDataExport struct {
+	ExportedAt      time.Time                `json:"exported_at"`
+	User            *models.User             `json:"user"`
+	Consents        []*models.Consent        `json:"consents"`
+	ConsentReceipts []*models.ConsentReceipt `json:"consent_receipts"`
+	Sessions        []UserSessionMetadata    `json:"sessions"`
+	Tokens          []UserTokenMetadata      `json:"tokens"`
+	AuditHistory    []*models.AuditLog       `json:"audit_history"`
+}
+
+// UserTokenMetadata describes a token issued to a user without its secret values
+type UserTokenMetadata struct {
+	ID              string    `json:"id"`
+	TokenType       string    `json:"token_type"`
+	ClientID        string    `json:"client_id"`
+	Scope           string    `json:"scope"`
+	HasRefreshToken bool      `json:"has_refresh_token"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// UserSessionMetadata describes a user's sign-in session without its ID, which is
+// the value of the session cookie
+type UserSessionMetadata struct {
+	AuthTime             time.Time `json:"auth_time"`
+	AuthenticationMethod string    `json:"authentication_method"`
+	ACR                  string    `json:"acr,omitempty"`
+	AMR                  []string  `json:"amr,omitempty"`
+	LastActivityAt       time.Time `json:"last_activity_at"`
+	Persistent           bool      `json:"persistent,omitempty"`
+	ExpiresAt            time.Time `json:"expires_at"`
+	CreatedAt            time.Time `json:"created_at"`
+}
+
+// ExportUserData returns all personal data held about a user as a JSON attachment
+// (GET /api/admin/users/:id/export)
+func (h *AdminHandler) ExportUserData(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	user, err := h.store.GetUserByID(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get user"})
+	}
+	if user == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+
+	export, err := h.collectUserData(user)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to export user data: " + err.Error()})
+	}
+
+	h.logAdminAudit(models.AuditActionAdminUserExported, models.AuditActorAdmin, actor,
+		"user", user.ID, models.AuditStatusSuccess, c.RealIP(), c.Request().UserAgent(), nil)
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="user-%s.json"`, user.ID))
+	return c.JSON(http.StatusOK, export)
+}
+
+// collectUserData gathers the export document for user
+func (h *AdminHandler) collectUserData(user *models.User) (*UserDataExport, error) {
+	export := &UserDataExport{
+		ExportedAt:      time.Now(),
+		User:            user,
+		Consents:        []*models.Consent{},
+		ConsentReceipts: []*models.ConsentReceipt{},
+		Sessions:        []UserSessionMetadata{},
+		Tokens:          []UserTokenMetadata{},
+		AuditHistory:    []*models.AuditLog{},
+	}
+
+	consents, err := h.store.GetConsentsByUserID(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("consents: %w", err)
+	}
+	export.Consents = append(export.Consents, consents...)
+
+	receipts, err := h.store.ListConsentReceipts(user.ID, "")
+	if err != nil {
+		return nil, fmt.Errorf("consent receipts: %w", err)
+	}
+	export.ConsentReceipts = append(export.ConsentReceipts, receipts...)
+
+	sessions, err := h.store.GetUserSessionsByUserID(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("sessions: %w", err)
+	}
+	for _, session := range sessions {
+		export.Sessions = append(export.Sessions, UserSessionMetadata{
+			AuthTime:             session.AuthTime,
+			AuthenticationMethod: session.AuthenticationMethod,
+			ACR:                  session.ACR,
+			AMR:                  session.AMR,
+			LastActivityAt:       session.LastActivityAt,
+			Persistent:           session.Persistent,
+			ExpiresAt:            session.ExpiresAt,
+			CreatedAt:            session.CreatedAt,
+		})
+	}
+
+	tokens, err := h.store.ListTokens("", user.ID, false)
+	if err != nil {
+		return nil, fmt.Errorf("tokens: %w", err)
+	}
+	for _, t := range tokens {
+		export.Tokens = append(export.Tokens, UserTokenMetadata{
+			ID:              t.ID,
+			TokenType:       t.TokenType,
+			ClientID:        t.ClientID,
+			Scope:           t.Scope,
+			HasRefreshToken: t.RefreshToken != "",
+			ExpiresAt:       t.ExpiresAt,
+			CreatedAt:       t.CreatedAt,
+		})
+	}
+
+	// Audit entries name a user by ID or, for their own logins, by username
+	seen := map[string]bool{}
+	for _, subject := range userSubjects(user) {
+		filter := models.AuditFilter{Subject: subject}
+		filter.Limit = h.store.GetAuditLogsCount(filter)
+		if filter.Limit == 0 {
+			continue
+		}
+		entries, err := h.store.GetAuditLogs(filter)
+		if err != nil {
+			return nil, fmt.Errorf("audit history: %w", err)
+		}
+		for _, e := range entries {
+			if !seen[e.ID] {
+				seen[e.ID] = true
+				export.AuditHistory = append(export.AuditHistory, e)
+			}
+		}
+	}
+	sort.Slice(export.AuditHistory, func(i, j int) bool {
+		return export.AuditHistory[i].Timestamp.Before(export.AuditHistory[j].Timestamp)
+	})
+
+	return export, nil
+}
+
+// EraseUser deletes a user together with their consents, consent receipts, sessions
+// and tokens, and pseudonymizes the audit entries that mention them so the audit
+// trail keeps its shape without identifying the person. The erasure's own audit entry
+// records the redacted entries so the audit chain still verifies (POST /api/admin/users/:id/erase)
+func (h *AdminHandler) EraseUser(c echo.Context) error {
+	actor, ok := h.authenticatedAdmin(c)
+	if !ok {
+		return nil
+	}
+	user, err := h.store.GetUserByID(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get user"})
+	}
+	if user == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+
+	pseudonym := "erased-" + uuid.New().String()
+	var anonymized int
+	err = h.store.RunInTransaction(func(tx storage.Storage) error {
+		tokens, err := tx.ListTokens("", user.ID, false)
+		if err != nil {
+			return fmt.Errorf("failed to list tokens: %w", err)
+		}
+		for _, t := range tokens {
+			if err := tx.DeleteToken(t.ID); err != nil {
+				return fmt.Errorf("failed to delete token: %w", err)
+			}
+		}
+
+		sessions, err := tx.GetUserSessionsByUserID(user.ID)
+		if err != nil {
+			return fmt.Errorf("failed to list sessions: %w", err)
+		}
+		for _, s := range sessions {
+			if err := tx.DeleteUserSession(s.ID); err != nil {
+				return fmt.Errorf("failed to delete session: %w", err)
+			}
+		}
+
+		if err := tx.DeleteConsentsForUser(user.ID); err != nil {
+			return fmt.Errorf("failed to delete consents: %w", err)
+		}
+		if err := tx.DeleteConsentReceiptsForUser(user.ID); err != nil {
+			return fmt.Errorf("failed to delete consent receipts: %w", err)
+		}
+		if err := tx.DeleteAPIKeysForUser(user.ID); err != nil {
+			return fmt.Errorf("failed to delete API keys: %w", err)
+		}
+
+		redactions, err := tx.AnonymizeAuditLogs(userSubjects(user), pseudonym)
+		if err != nil {
+			return fmt.Errorf("failed to anonymize audit logs: %w", err)
+		}
+		anonymized = len(redactions)
+		erased := &models.AuditLog{
+			ID:         uuid.NewString(),
+			Timestamp:  time.Now().UTC(),
+			Action:     models.AuditActionAdminUserErased,
+			Actor:      actor,
+			ActorType:  models.AuditActorAdmin,
+			Resource:   "user",
+			ResourceID: pseudonym,
+			IPAddress:  c.RealIP(),
+			UserAgent:  c.Request().UserAgent(),
+			Status:     models.AuditStatusSuccess,
+			Details:    map[string]interface{}{"audit_entries_anonymized": anonymized},
+			Redactions: redactions,
+		}
+		if err := tx.CreateAuditLog(erased); err != nil {
+			return fmt.Errorf("failed to record the erasure: %w", err)
+		}
+
+		if err := tx.DeleteUser(user.ID); err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to erase user: " + err.Error()})
+	}
+
+	deleteUploadedAvatar(c.Request().Context(), h.avatars, h.config, user.Picture)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"pseudonym":                pseudonym,
+		"audit_entries_anonymized": anonymized,
+	})
+}
+
+// userSubjects returns the identifiers audit entries may use for user
+func userSubjects(user *models.User) []string {
+	subjects := []string{user.ID}
+	if user.Username != "" && user.Username != user.ID {
+		subjects = append(subjects, user.Username)
+	}
+	return subjects
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestUserDataExportAndErasure(t *testing.T) {
+	h, store, client, _ := setupRevokeTest(t)
+	admin := NewAdminHandler(store, h.config, nil)
+
+	user := models.NewRegularUser("alice", "alice@example.com", "hashed_password")
+	require.NoError(t, store.CreateUser(user))
+	require.NoError(t, store.CreateUserSession(&models.UserSession{ID: "alice-session", UserID: user.ID,
+		AuthTime: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}))
+	require.NoError(t, store.CreateConsent(&models.Consent{ID: "alice-consent", UserID: user.ID,
+		ClientID: client.ID, Scopes: []string{"openid", "email"}}))
+	require.NoError(t, store.CreateConsentReceipt(h.newConsentReceipt(user.ID, "en", client, []string{"openid"})))
+	token := models.NewToken("alice-access-token", "alice-refresh-token", client.ID, user.ID, "openid email", 60)
+	require.NoError(t, store.CreateToken(token))
+	require.NoError(t, store.CreateAuditLog(&models.AuditLog{ID: "login", Timestamp: time.Now(),
+		Action: models.AuditActionLogin, Actor: "alice", ResourceID: user.ID, IPAddress: "203.0.113.7"}))
+	require.NoError(t, store.CreateAuditLog(&models.AuditLog{ID: "update", Timestamp: time.Now().Add(time.Second),
+		Action: models.AuditActionAdminUserUpdated, Actor: "admin", Resource: "user", ResourceID: user.ID,
+		Details: map[string]interface{}{"username": "alice"}}))
+	require.NoError(t, store.CreateAuditLog(&models.AuditLog{ID: "other", Timestamp: time.Now(),
+		Action: models.AuditActionLogin, Actor: "bob", ResourceID: "bob-id"}))
+
+	adminToken, err := crypto.GenerateAdminToken("dpo", admin.adminSecret)
+	require.NoError(t, err)
+	bearer := "Bearer " + adminToken
+	call := func(method string, handler echo.HandlerFunc, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", nil)
+		req.Header.Set(echo.HeaderAuthorization, bearer)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		require.NoError(t, handler(c))
+		return rec
+	}
+
+	// Exports and erasure are only available to administrators
+	bearer = ""
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, admin.ExportUserData, user.ID).Code)
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodPost, admin.EraseUser, user.ID).Code)
+	bearer = "Bearer " + adminToken
+
+	rec := call(http.MethodGet, admin.ExportUserData, user.ID)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get(echo.HeaderContentDisposition), "attachment")
+	assert.NotContains(t, rec.Body.String(), token.AccessToken)
+	assert.NotContains(t, rec.Body.String(), "alice-session", "session IDs are credentials")
+
+	var export UserDataExport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &export))
+	assert.Equal(t, "alice", export.User.Username)
+	assert.Len(t, export.Consents, 1)
+	assert.Len(t, export.ConsentReceipts, 1)
+	assert.Len(t, export.Sessions, 1)
+	require.Len(t, export.Tokens, 1)
+	assert.Equal(t, token.ID, export.Tokens[0].ID)
+	require.Len(t, export.AuditHistory, 2)
+	assert.Equal(t, "login", export.AuditHistory[0].ID)
+	assert.Equal(t, "update", export.AuditHistory[1].ID)
+
+	rec = call(http.MethodPost, admin.EraseUser, user.ID)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var erased struct {
+		Pseudonym  string `json:"pseudonym"`
+		Anonymized int    `json:"audit_entries_anonymized"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &erased))
+	assert.Equal(t, 3, erased.Anonymized) // including the export itself
+
+	deleted, err := store.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Nil(t, deleted)
+	sessions, err := store.GetUserSessionsByUserID(user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+	consents, err := store.GetConsentsByUserID(user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, consents)
+	receipts, err := store.ListConsentReceipts(user.ID, "")
+	require.NoError(t, err)
+	assert.Empty(t, receipts)
+	tokens, err := store.ListTokens("", user.ID, false)
+	require.NoError(t, err)
+	assert.Empty(t, tokens)
+
+	// The audit trail keeps its entries but no longer identifies the user
+	assert.Zero(t, store.GetAuditLogsCount(models.AuditFilter{Subject: user.ID}))
+	assert.Zero(t, store.GetAuditLogsCount(models.AuditFilter{Subject: "alice"}))
+	login, err := store.GetAuditLogs(models.AuditFilter{Action: models.AuditActionLogin, Actor: erased.Pseudonym})
+	require.NoError(t, err)
+	require.Len(t, login, 1)
+	assert.Equal(t, erased.Pseudonym, login[0].ResourceID)
+	assert.Empty(t, login[0].IPAddress)
+	update, err := store.GetAuditLogs(models.AuditFilter{Action: models.AuditActionAdminUserUpdated})
+	require.NoError(t, err)
+	require.Len(t, update, 1)
+	assert.Equal(t, "admin", update[0].Actor)
+	assert.Equal(t, erased.Pseudonym, update[0].Details["username"])
+	assert.Equal(t, 1, store.GetAuditLogsCount(models.AuditFilter{Subject: "bob"}))
+
+	// The erasure records what it redacted, so the audit chain still verifies
+	verification, err := VerifyAuditChain(store)
+	require.NoError(t, err)
+	assert.True(t, verification.Valid(), verification.Problems)
+	assert.Equal(t, 3, verification.Redacted)
+
+	assert.Equal(t, http.StatusNotFound, call(http.MethodPost, admin.EraseUser, user.ID).Code)
+}
@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 
@@ -27,6 +31,45 @@ type UserInfoResponse struct {
 
 	// Address scope claims (OIDC Core 1.0 Section 5.4)
 	Address *models.Address `json:"address,omitempty"`
+
+	// Language-tagged claim variants, e.g. "name#ja-JP" (OIDC Core 1.0 Section 5.2)
+	LocalizedClaims map[string]string `json:"-"`
+
+	// Live attributes from upstream providers, e.g. directory group memberships.
+	// They never replace the claims above.
+	ExtraClaims map[string]interface{} `json:"-"`
+}
+
+// localizableProfileClaims are the profile claims returned in language-tagged variants
+var localizableProfileClaims = []string{"name", "given_name", "family_name"}
+
+// MarshalJSON adds the language-tagged claim variants and upstream attributes to the response
+func (r UserInfoResponse) MarshalJSON() ([]byte, error) {
+	type plain UserInfoResponse
+	data, err := json.Marshal(plain(r))
+	if err != nil || (len(r.LocalizedClaims) == 0 && len(r.ExtraClaims) == 0) {
+		return data, err
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, err
+	}
+	for name, value := range r.LocalizedClaims {
+		claims[name] = value
+	}
+	for name, value := range r.ExtraClaims {
+		if _, exists := claims[name]; !exists && !userInfoReservedClaims[name] {
+			claims[name] = value
+		}
+	}
+	return json.Marshal(claims)
+}
+
+// userInfoReservedClaims may only come from the local user record, even when empty
+var userInfoReservedClaims = map[string]bool{
+	"sub": true, "iss": true, "aud": true, "email": true, "email_verified": true,
+	"phone_number": true, "phone_number_verified": true,
 }
 
 // UserInfo handles the UserInfo endpoint (GET/POST /userinfo)
@@ -74,7 +117,80 @@ func (h *Handlers) UserInfo(c echo.Context) error {
 	// Build response based on requested scopes
 	response := h.buildUserInfoResponse(user, token.Scope)
 
-	return c.JSON(http.StatusOK, response)
+	// Add localized claim variants for the requested claims_locales, falling back
+	// to the locales of the authorization request
+	claimsLocales := strings.Fields(c.FormValue("claims_locales"))
+	if len(claimsLocales) == 0 {
+		claimsLocales = token.ClaimsLocales
+	}
+	if h.hasScope(token.Scope, "profile") {
+		response.LocalizedClaims = localizedClaims(user, claimsLocales)
+	}
+
+	// Merge live attributes from upstream providers
+	response.ExtraClaims = h.attributes.Resolve(c.Request().Context(), user, token.Scope)
+
+	client, _ := h.storage.GetClientByID(token.ClientID)
+	return writeUserInfoResponse(c, client, user, response)
+}
+
+// writeUserInfoResponse sends a UserInfo response with an ETag and Last-Modified,
+// so clients polling the endpoint can make conditional requests. The entity tag
+// covers the whole response; Last-Modified is the time the user record changed,
+// and is left out when upstream attributes, which may change at any time, are
+// included. Responses carry personal data and are not stored unless the client
+// has a userinfo_cache_seconds.
+func writeUserInfoResponse(c echo.Context, client *models.Client, user *models.User, response UserInfoResponse) error {
+	body, err := json.Marshal(response)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, ErrorServerError, "Failed to encode user information")
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:18]) + `"`
+	var lastModified time.Time
+	if len(response.ExtraClaims) == 0 && !user.UpdatedAt.IsZero() {
+		lastModified = user.UpdatedAt.UTC().Truncate(time.Second)
+	}
+
+	header := c.Response().Header()
+	if client != nil && client.UserInfoCacheSeconds > 0 {
+		header.Set("Cache-Control", fmt.Sprintf("private, max-age=%d", client.UserInfoCacheSeconds))
+	} else {
+		header.Set("Cache-Control", "no-store")
+		header.Set("Pragma", "no-cache")
+	}
+	header.Add("Vary", "Authorization")
+	header.Set("ETag", etag)
+	if !lastModified.IsZero() {
+		header.Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	}
+
+	if notModified(c.Request(), etag, lastModified) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSONBlob(http.StatusOK, body)
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when it is absent, for
+// a GET or HEAD request (RFC 9110 Section 13.2.2)
+func notModified(req *http.Request, etag string, lastModified time.Time) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if lastModified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	return err == nil && !lastModified.After(since)
 }
 
 // buildUserInfoResponse constructs the UserInfo response based on granted scopes
@@ -129,6 +245,25 @@ func (h *Handlers) buildUserInfoResponse(user *models.User, scopeString string)
 	return response
 }
 
+// localizedClaims returns the user's language-tagged profile claims matching the
+// requested locales, keyed as "claim#tag"
+func localizedClaims(user *models.User, locales []string) map[string]string {
+	if len(locales) == 0 || len(user.LocalizedClaims) == 0 {
+		return nil
+	}
+
+	claims := make(map[string]string)
+	for _, claim := range localizableProfileClaims {
+		values := user.LocalizedClaims[claim]
+		for _, locale := range locales {
+			if tag, ok := matchLocale(values, []string{locale}); ok {
+				claims[claim+"#"+tag] = values[tag]
+			}
+		}
+	}
+	return claims
+}
+
 // hasScope checks if a scope string contains a specific scope
 func (h *Handlers) hasScope(scopeString, targetScope string) bool {
 	if scopeString == "" {
@@ -9,127 +9,18 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 
+	"github.com/prasenjit-net/openid-golang/pkg/attributes"
 	"github.com/prasenjit-net/openid-golang/pkg/configstore"
 	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storagetest"
 )
 
-// MockStorage is a mock implementation of the storage interface
-type MockStorage struct {
-	mock.Mock
-}
-
-func (m *MockStorage) GetTokenByAccessToken(accessToken string) (*models.Token, error) {
-	args := m.Called(accessToken)
-	if args.Get(0) == nil {
-		return nil, args.Error(1)
-	}
-	return args.Get(0).(*models.Token), args.Error(1)
-}
-
-func (m *MockStorage) GetUserByID(id string) (*models.User, error) {
-	args := m.Called(id)
-	if args.Get(0) == nil {
-		return nil, args.Error(1)
-	}
-	return args.Get(0).(*models.User), args.Error(1)
-}
-
-// Add stubs for other required storage methods
-func (m *MockStorage) CreateUser(user *models.User) error                      { return nil }
-func (m *MockStorage) GetUserByUsername(username string) (*models.User, error) { return nil, nil }
-func (m *MockStorage) GetUserByEmail(email string) (*models.User, error)       { return nil, nil }
-func (m *MockStorage) GetAllUsers() ([]*models.User, error)                    { return nil, nil }
-func (m *MockStorage) UpdateUser(user *models.User) error                      { return nil }
-func (m *MockStorage) DeleteUser(id string) error                              { return nil }
-func (m *MockStorage) CreateClient(client *models.Client) error                { return nil }
-func (m *MockStorage) GetClientByID(id string) (*models.Client, error)         { return nil, nil }
-func (m *MockStorage) GetAllClients() ([]*models.Client, error)                { return nil, nil }
-func (m *MockStorage) UpdateClient(client *models.Client) error                { return nil }
-func (m *MockStorage) DeleteClient(id string) error                            { return nil }
-func (m *MockStorage) ValidateClient(clientID, clientSecret string) (*models.Client, error) {
-	return nil, nil
-}
-func (m *MockStorage) CreateAuthorizationCode(code *models.AuthorizationCode) error { return nil }
-func (m *MockStorage) GetAuthorizationCode(code string) (*models.AuthorizationCode, error) {
-	return nil, nil
-}
-func (m *MockStorage) UpdateAuthorizationCode(code *models.AuthorizationCode) error { return nil }
-func (m *MockStorage) DeleteAuthorizationCode(code string) error                    { return nil }
-func (m *MockStorage) CreateToken(token *models.Token) error                        { return nil }
-func (m *MockStorage) GetTokenByRefreshToken(refreshToken string) (*models.Token, error) {
-	return nil, nil
-}
-func (m *MockStorage) DeleteToken(accessToken string) error                  { return nil }
-func (m *MockStorage) CreateSession(session *models.Session) error           { return nil }
-func (m *MockStorage) GetSession(id string) (*models.Session, error)         { return nil, nil }
-func (m *MockStorage) DeleteSession(id string) error                         { return nil }
-func (m *MockStorage) CreateAuthSession(session *models.AuthSession) error   { return nil }
-func (m *MockStorage) GetAuthSession(id string) (*models.AuthSession, error) { return nil, nil }
-func (m *MockStorage) UpdateAuthSession(session *models.AuthSession) error   { return nil }
-func (m *MockStorage) DeleteAuthSession(id string) error                     { return nil }
-func (m *MockStorage) CreateUserSession(session *models.UserSession) error   { return nil }
-func (m *MockStorage) GetUserSession(id string) (*models.UserSession, error) { return nil, nil }
-func (m *MockStorage) GetUserSessionByUserID(userID string) (*models.UserSession, error) {
-	return nil, nil
-}
-func (m *MockStorage) UpdateUserSession(session *models.UserSession) error { return nil }
-func (m *MockStorage) DeleteUserSession(id string) error                   { return nil }
-func (m *MockStorage) CleanupExpiredSessions() error                       { return nil }
-func (m *MockStorage) CreateConsent(consent *models.Consent) error         { return nil }
-func (m *MockStorage) GetConsent(userID, clientID string) (*models.Consent, error) {
-	return nil, nil
-}
-func (m *MockStorage) UpdateConsent(consent *models.Consent) error { return nil }
-func (m *MockStorage) DeleteConsent(userID, clientID string) error { return nil }
-func (m *MockStorage) DeleteConsentsForUser(userID string) error   { return nil }
-func (m *MockStorage) GetTokensByAuthCode(authCodeID string) ([]*models.Token, error) {
-	return nil, nil
-}
-func (m *MockStorage) RevokeTokensByAuthCode(authCodeID string) error { return nil }
-func (m *MockStorage) ListTokens(clientID, userID string, activeOnly bool) ([]*models.Token, error) {
-	return nil, nil
-}
-func (m *MockStorage) CreateInitialAccessToken(token *models.InitialAccessToken) error {
-	return nil
-}
-func (m *MockStorage) GetInitialAccessToken(token string) (*models.InitialAccessToken, error) {
-	return nil, nil
-}
-func (m *MockStorage) UpdateInitialAccessToken(token *models.InitialAccessToken) error {
-	return nil
-}
-func (m *MockStorage) DeleteInitialAccessToken(token string) error { return nil }
-func (m *MockStorage) GetAllInitialAccessTokens() ([]*models.InitialAccessToken, error) {
-	return nil, nil
-}
-func (m *MockStorage) CreateSigningKey(key *models.SigningKey) error { return nil }
-func (m *MockStorage) GetSigningKey(id string) (*models.SigningKey, error) {
-	return nil, nil
-}
-func (m *MockStorage) GetSigningKeyByKID(kid string) (*models.SigningKey, error) {
-	return nil, nil
-}
-func (m *MockStorage) GetAllSigningKeys() ([]*models.SigningKey, error) { return nil, nil }
-func (m *MockStorage) GetActiveSigningKey() (*models.SigningKey, error) {
-	return nil, nil
-}
-func (m *MockStorage) UpdateSigningKey(key *models.SigningKey) error { return nil }
-func (m *MockStorage) DeleteSigningKey(id string) error              { return nil }
-func (m *MockStorage) GetActiveTokensCount() int                     { return 0 }
-func (m *MockStorage) GetRecentUserSessionsCount() int               { return 0 }
-func (m *MockStorage) CreateAuditLog(entry *models.AuditLog) error   { return nil }
-func (m *MockStorage) GetAuditLogs(filter models.AuditFilter) ([]*models.AuditLog, error) {
-	return nil, nil
-}
-func (m *MockStorage) GetAuditLogsCount(filter models.AuditFilter) int { return 0 }
-func (m *MockStorage) Close() error                                    { return nil }
-
 func TestUserInfo_Success(t *testing.T) {
 	// Setup
 	e := echo.New()
-	mockStorage := new(MockStorage)
+	mockStorage := new(storagetest.MockStorage)
 
 	handlers := &Handlers{
 		storage: mockStorage,
@@ -201,7 +92,7 @@ func TestUserInfo_Success(t *testing.T) {
 func TestUserInfo_ProfileScopeOnly(t *testing.T) {
 	// Setup
 	e := echo.New()
-	mockStorage := new(MockStorage)
+	mockStorage := new(storagetest.MockStorage)
 
 	handlers := &Handlers{
 		storage: mockStorage,
@@ -255,6 +146,156 @@ func TestUserInfo_ProfileScopeOnly(t *testing.T) {
 	mockStorage.AssertExpectations(t)
 }
 
+func TestUserInfo_ClaimsLocales(t *testing.T) {
+	e := echo.New()
+	mockStorage := new(storagetest.MockStorage)
+
+	handlers := &Handlers{
+		storage: mockStorage,
+		config:  &configstore.ConfigData{},
+	}
+
+	user := &models.User{
+		ID:         "user123",
+		Name:       "Taro Yamada",
+		FamilyName: "Yamada",
+		LocalizedClaims: map[string]map[string]string{
+			"name":        {"ja-Jpan-JP": "山田太郎", "ja-Kana-JP": "ヤマダタロウ"},
+			"family_name": {"ja-Jpan-JP": "山田"},
+		},
+	}
+
+	// The authorization request asked for Japanese claims
+	token := &models.Token{
+		ID:            "token123",
+		AccessToken:   "localized_token",
+		UserID:        "user123",
+		Scope:         "openid profile",
+		ClaimsLocales: []string{"ja-Jpan-JP"},
+		ExpiresAt:     time.Now().Add(1 * time.Hour),
+	}
+
+	mockStorage.On("GetTokenByAccessToken", "localized_token").Return(token, nil)
+	mockStorage.On("GetUserByID", "user123").Return(user, nil)
+
+	request := func(target string) map[string]interface{} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", "Bearer localized_token")
+		rec := httptest.NewRecorder()
+		require.NoError(t, handlers.UserInfo(e.NewContext(req, rec)))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var claims map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &claims))
+		return claims
+	}
+
+	claims := request("/userinfo")
+	assert.Equal(t, "Taro Yamada", claims["name"])
+	assert.Equal(t, "山田太郎", claims["name#ja-Jpan-JP"])
+	assert.Equal(t, "山田", claims["family_name#ja-Jpan-JP"])
+	assert.NotContains(t, claims, "name#ja-Kana-JP")
+
+	// A claims_locales parameter on the UserInfo request takes precedence
+	claims = request("/userinfo?claims_locales=ja-Kana-JP")
+	assert.Equal(t, "ヤマダタロウ", claims["name#ja-Kana-JP"])
+	assert.NotContains(t, claims, "name#ja-Jpan-JP")
+}
+
+func TestUserInfo_UpstreamAttributes(t *testing.T) {
+	e := echo.New()
+	mockStorage := new(storagetest.MockStorage)
+
+	directory := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"groups": []string{"admins"},
+			"email":  "spoofed@example.com",
+		})
+	}))
+	defer directory.Close()
+
+	resolver, err := attributes.NewResolver([]configstore.AttributeProviderConfig{
+		{Name: "directory", URL: directory.URL, Scope: "groups"},
+	})
+	require.NoError(t, err)
+	handlers := &Handlers{
+		storage:    mockStorage,
+		config:     &configstore.ConfigData{},
+		attributes: resolver,
+	}
+
+	user := &models.User{ID: "user123", Email: ""}
+	token := &models.Token{
+		ID:          "token123",
+		AccessToken: "groups_token",
+		UserID:      "user123",
+		Scope:       "openid email groups",
+		ExpiresAt:   time.Now().Add(1 * time.Hour),
+	}
+	mockStorage.On("GetTokenByAccessToken", "groups_token").Return(token, nil)
+	mockStorage.On("GetUserByID", "user123").Return(user, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer groups_token")
+	rec := httptest.NewRecorder()
+	require.NoError(t, handlers.UserInfo(e.NewContext(req, rec)))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var claims map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &claims))
+	assert.Equal(t, []interface{}{"admins"}, claims["groups"])
+	assert.NotContains(t, claims, "email", "providers must not supply identity claims")
+}
+
+func TestUserInfo_ConditionalRequests(t *testing.T) {
+	h, store, client, token := setupRevokeTest(t)
+	require.NoError(t, store.CreateUser(&models.User{ID: token.UserID, Username: "alice", Name: "Alice"}))
+
+	call := func(method string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/userinfo", nil)
+		req.Header = header
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.UserInfo(echo.New().NewContext(req, rec)))
+		return rec
+	}
+
+	rec := call(http.MethodGet, http.Header{})
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	lastModified := rec.Header().Get("Last-Modified")
+	require.NotEmpty(t, lastModified)
+
+	rec = call(http.MethodGet, http.Header{"If-None-Match": {`"other", ` + etag}})
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	rec = call(http.MethodGet, http.Header{"If-Modified-Since": {lastModified}})
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	rec = call(http.MethodPost, http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusOK, rec.Code, "only GET and HEAD are conditional")
+
+	// A change to the user gives a new entity tag
+	time.Sleep(time.Second)
+	user, err := store.GetUserByID(token.UserID)
+	require.NoError(t, err)
+	user.Name = "Alice Smith"
+	require.NoError(t, store.UpdateUser(user))
+	rec = call(http.MethodGet, http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+	rec = call(http.MethodGet, http.Header{"If-Modified-Since": {lastModified}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Clients can opt in to caching
+	client.UserInfoCacheSeconds = 300
+	require.NoError(t, store.UpdateClient(client))
+	rec = call(http.MethodGet, http.Header{})
+	assert.Equal(t, "private, max-age=300", rec.Header().Get("Cache-Control"))
+	assert.Equal(t, "Authorization", rec.Header().Get("Vary"))
+}
+
 func TestUserInfo_MissingAuthHeader(t *testing.T) {
 	e := echo.New()
 	handlers := &Handlers{}
@@ -276,7 +317,7 @@ func TestUserInfo_MissingAuthHeader(t *testing.T) {
 
 func TestUserInfo_InvalidToken(t *testing.T) {
 	e := echo.New()
-	mockStorage := new(MockStorage)
+	mockStorage := new(storagetest.MockStorage)
 	handlers := &Handlers{
 		storage: mockStorage,
 	}
@@ -301,7 +342,7 @@ func TestUserInfo_InvalidToken(t *testing.T) {
 
 func TestUserInfo_ExpiredToken(t *testing.T) {
 	e := echo.New()
-	mockStorage := new(MockStorage)
+	mockStorage := new(storagetest.MockStorage)
 	handlers := &Handlers{
 		storage: mockStorage,
 	}
@@ -334,7 +375,7 @@ func TestUserInfo_ExpiredToken(t *testing.T) {
 
 func TestUserInfo_MissingOpenIDScope(t *testing.T) {
 	e := echo.New()
-	mockStorage := new(MockStorage)
+	mockStorage := new(storagetest.MockStorage)
 	handlers := &Handlers{
 		storage: mockStorage,
 	}
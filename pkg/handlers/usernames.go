@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+)
+
+const (
+	defaultUsernamePattern   = `^[A-Za-z0-9][A-Za-z0-9._-]*$`
+	defaultUsernameMinLength = 3
+	defaultUsernameMaxLength = 64
+)
+
+// defaultReservedUsernames are refused unless username_policy.reserved_names
+// replaces them. They could be mistaken for the server, its operators or a
+// well-known mailbox.
+var defaultReservedUsernames = []string{
+	"admin", "administrator", "root", "superuser", "sysadmin", "system",
+	"support", "security", "help", "info", "abuse", "postmaster", "hostmaster",
+	"webmaster", "noreply", "anonymous", "guest", "null", "undefined",
+}
+
+// Rules of UsernameViolation
+const (
+	UsernameRuleLength      = "length"
+	UsernameRulePattern     = "pattern"
+	UsernameRuleReserved    = "reserved"
+	UsernameRuleBlockedWord = "blocked_word"
+)
+
+// UsernameViolation is one rule of the username policy a username breaks
+type UsernameViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// usernamePatterns caches compiled username_policy.pattern values
+var usernamePatterns sync.Map
+
+// usernameViolations checks username against the username policy. It returns
+// nothing when the policy is off or the username passes. An error means the
+// configured pattern is not a valid regular expression.
+func usernameViolations(cfg *configstore.ConfigData, username string) ([]UsernameViolation, error) {
+	policy := cfg.UsernamePolicyState()
+	if !policy.Enabled {
+		return nil, nil
+	}
+	var violations []UsernameViolation
+
+	minLength, maxLength := policy.MinLength, policy.MaxLength
+	if minLength <= 0 {
+		minLength = defaultUsernameMinLength
+	}
+	if maxLength <= 0 {
+		maxLength = defaultUsernameMaxLength
+	}
+	if n := len([]rune(username)); n < minLength || n > maxLength {
+		violations = append(violations, UsernameViolation{Rule: UsernameRuleLength,
+			Message: fmt.Sprintf("must be between %d and %d characters long", minLength, maxLength)})
+	}
+
+	pattern := policy.Pattern
+	if pattern == "" {
+		pattern = defaultUsernamePattern
+	}
+	re, err := compileUsernamePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if !re.MatchString(username) {
+		message := "may only contain letters, digits, \".\", \"_\" and \"-\", and must start with a letter or digit"
+		if policy.Pattern != "" {
+			message = "does not match the required format"
+		}
+		violations = append(violations, UsernameViolation{Rule: UsernameRulePattern, Message: message})
+	}
+
+	folded := foldUsername(username)
+	reserved := policy.ReservedNames
+	if len(reserved) == 0 {
+		reserved = defaultReservedUsernames
+	}
+	for _, name := range reserved {
+		if folded == foldUsername(name) {
+			violations = append(violations, UsernameViolation{Rule: UsernameRuleReserved, Message: "is reserved"})
+			break
+		}
+	}
+	for _, word := range policy.BlockedWords {
+		if w := foldUsername(word); w != "" && strings.Contains(folded, w) {
+			// The word itself is not repeated back
+			violations = append(violations, UsernameViolation{Rule: UsernameRuleBlockedWord, Message: "contains a word that is not allowed"})
+			break
+		}
+	}
+	return violations, nil
+}
+
+// foldUsername lowercases a username and drops separators, so "Ad.Min" and
+// "admin" compare equal
+func foldUsername(username string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '_', '-':
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(username)))
+}
+
+func compileUsernamePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := usernamePatterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid username_policy.pattern: %w", err)
+	}
+	usernamePatterns.Store(pattern, re)
+	return re, nil
+}
+
+// checkUsername answers 400 with the broken rules when username fails the
+// username policy, and returns false in that case
+func (h *AdminHandler) checkUsername(c echo.Context, username string) bool {
+	violations, err := usernameViolations(h.config, username)
+	if err != nil {
+		log.Printf("Username policy: %v", err)
+		_ = c.JSON(http.StatusInternalServerError, map[string]string{"error": "Username policy is misconfigured"})
+		return false
+	}
+	if len(violations) == 0 {
+		return true
+	}
+	_ = c.JSON(http.StatusBadRequest, map[string]interface{}{
+		"error":      "Username does not meet the username policy",
+		"field":      "username",
+		"violations": violations,
+	})
+	return false
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestUsernameViolations(t *testing.T) {
+	cfg := &configstore.ConfigData{}
+	rules := func(username string) []string {
+		violations, err := usernameViolations(cfg, username)
+		require.NoError(t, err)
+		names := []string{}
+		for _, v := range violations {
+			names = append(names, v.Rule)
+		}
+		return names
+	}
+
+	// Off by default
+	assert.Empty(t, rules("admin"))
+
+	cfg.UsernamePolicy.Enabled = true
+	assert.Empty(t, rules("alice.smith"))
+	assert.Equal(t, []string{UsernameRuleLength}, rules("al"))
+	assert.Equal(t, []string{UsernameRulePattern}, rules("alice smith"))
+	assert.Equal(t, []string{UsernameRulePattern}, rules("-alice"))
+	assert.Equal(t, []string{UsernameRuleReserved}, rules("Ad.Min"))
+	assert.Equal(t, []string{UsernameRuleLength, UsernameRulePattern}, rules(" x"))
+
+	cfg.UsernamePolicy = configstore.UsernamePolicyConfig{
+		Enabled:       true,
+		Pattern:       `^[a-z]+$`,
+		MinLength:     2,
+		MaxLength:     8,
+		ReservedNames: []string{"ceo"},
+		BlockedWords:  []string{"darn"},
+	}
+	assert.Empty(t, rules("admin"), "a configured list replaces the built-in one")
+	assert.Equal(t, []string{UsernameRuleReserved}, rules("ceo"))
+	assert.Equal(t, []string{UsernameRuleBlockedWord}, rules("xdarnx"))
+	assert.Equal(t, []string{UsernameRulePattern, UsernameRuleBlockedWord}, rules("x_DARN"))
+	assert.Equal(t, []string{UsernameRuleLength}, rules("abcdefghi"))
+
+	cfg.UsernamePolicy.Pattern = "["
+	_, err := usernameViolations(cfg, "alice")
+	assert.Error(t, err)
+}
+
+func TestUsernamePolicyEnforcedByAdminAPI(t *testing.T) {
+	h, store, _, _ := setupRevokeTest(t)
+	h.config.UsernamePolicy = configstore.UsernamePolicyConfig{Enabled: true, BlockedWords: []string{"darn"}}
+	admin := NewAdminHandler(store, h.config, nil)
+	adminToken, err := crypto.GenerateAdminToken("admin", admin.adminSecret)
+	require.NoError(t, err)
+
+	call := func(handler echo.HandlerFunc, method, id, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+adminToken)
+		req.Header.Set("If-Match", "*")
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		require.NoError(t, handler(c))
+		var resp map[string]interface{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	rec, resp := call(admin.CreateUser, http.MethodPost, "", `{"username": "root", "email": "r@example.com", "password": "pw-123456"}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "username", resp["field"])
+	violations := resp["violations"].([]interface{})
+	require.Len(t, violations, 1)
+	assert.Equal(t, UsernameRuleReserved, violations[0].(map[string]interface{})["rule"])
+
+	rec, _ = call(admin.CreateServiceAccount, http.MethodPost, "", `{"username": "darn-bot", "email": "bot@example.com"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec, created := call(admin.CreateUser, http.MethodPost, "", `{"username": "alice", "email": "a@example.com", "password": "pw-123456"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	id := created["id"].(string)
+
+	rec, _ = call(admin.UpdateUser, http.MethodPut, id, `{"username": "a b", "email": "a@example.com"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	user, err := store.GetUserByID(id)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", user.Username)
+
+	// Usernames from before the policy are only checked when they change
+	require.NoError(t, store.CreateUser(&models.User{ID: "legacy", Username: "x", Email: "x@example.com"}))
+	rec, _ = call(admin.UpdateUser, http.MethodPut, "legacy", `{"username": "x", "email": "x@example.com", "name": "X"}`)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"runtime"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Build information, set at link time with
+// -ldflags "-X github.com/prasenjit-net/openid-golang/pkg/handlers.Version=..."
+var (
+	Version     = "dev"
+	BuildCommit = "unknown"
+	BuildTime   = ""
+)
+
+// VersionResponse describes the running server build and its capabilities
+type VersionResponse struct {
+	Version        string          `json:"version"`
+	Commit         string          `json:"commit"`
+	BuildTime      string          `json:"build_time,omitempty"`
+	GoVersion      string          `json:"go_version"`
+	StorageBackend string          `json:"storage_backend"`
+	KeyAlgorithm   string          `json:"key_algorithm"`
+	Features       map[string]bool `json:"features"`
+}
+
+// GetVersion returns server version and capabilities (GET /api/admin/version)
+func (h *AdminHandler) GetVersion(c echo.Context) error {
+	if _, ok := h.authenticatedAdmin(c); !ok {
+		return nil
+	}
+
+	keyAlgorithm := "RS256"
+	if key, err := h.store.GetActiveSigningKey(); err == nil && key != nil && key.Algorithm != "" {
+		keyAlgorithm = key.Algorithm
+	}
+
+	_, registrationEnabled := h.config.RegistrationEndpoint()
+	features := map[string]bool{
+		"registration":    registrationEnabled,
+		"refresh_tokens":  h.config.JWTState().RefreshEnabled,
+		"secret_scanning": h.config.SecretScanning.Enabled,
+	}
+	for _, flag := range h.config.Features() {
+		features[flag.Name] = flag.Enabled
+	}
+
+	return c.JSON(http.StatusOK, VersionResponse{
+		Version:        Version,
+		Commit:         BuildCommit,
+		BuildTime:      BuildTime,
+		GoVersion:      runtime.Version(),
+		StorageBackend: h.config.Storage.Type,
+		KeyAlgorithm:   keyAlgorithm,
+		Features:       features,
+	})
+}
@@ -0,0 +1,239 @@
+// Package logging sends HTTP access logs and application logs to separate sinks,
+// each with its own output, format and level.
+//
+// Application and security messages are written with the standard library log
+// package throughout the server. Once SetupApplication has run, those lines are
+// passed through log/slog and given a level from their wording: "Warning…" lines
+// are warnings, "Error…", "Failed…" and "Panic…" lines are errors, the rest is
+// info. Code that logs with log/slog directly keeps its own level and attributes.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+)
+
+// Sink outputs
+const (
+	OutputStdout = "stdout"
+	OutputStderr = "stderr"
+	OutputOff    = "off"
+)
+
+// Formats
+const (
+	FormatJSON     = "json"
+	FormatText     = "text"
+	FormatCombined = "combined" // Apache combined log format, access logs only
+)
+
+var (
+	filesMu sync.Mutex
+	files   = map[string]*os.File{} // Log files stay open for the life of the process
+)
+
+// open returns the writer for output, or nil when the sink is off
+func open(output string, fallback io.Writer) (io.Writer, error) {
+	switch output {
+	case "":
+		return fallback, nil
+	case OutputStdout:
+		return os.Stdout, nil
+	case OutputStderr:
+		return os.Stderr, nil
+	case OutputOff:
+		return nil, nil
+	}
+
+	filesMu.Lock()
+	defer filesMu.Unlock()
+	if f, ok := files[output]; ok {
+		return f, nil
+	}
+	// #nosec G304 -- the path comes from the server configuration
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	files[output] = f
+	return f, nil
+}
+
+// ParseLevel parses "debug", "info", "warn" or "error"; empty means info
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", level)
+}
+
+// SetupApplication routes application and security logs to cfg. An empty config
+// leaves the standard library logger as it is.
+func SetupApplication(cfg configstore.LogSinkConfig) error {
+	if cfg == (configstore.LogSinkConfig{}) {
+		return nil
+	}
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	w, err := open(cfg.Output, os.Stderr)
+	if err != nil {
+		return err
+	}
+	if w == nil {
+		w = io.Discard
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch cfg.Format {
+	case "", FormatText:
+		handler = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown application log format %q", cfg.Format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	log.SetOutput(&levelWriter{handler: handler})
+	log.SetFlags(0)
+	return nil
+}
+
+// levelWriter passes standard library log lines to a slog handler
+type levelWriter struct {
+	handler slog.Handler
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	level := lineLevel(msg)
+	ctx := context.Background()
+	if !w.handler.Enabled(ctx, level) {
+		return len(p), nil
+	}
+	if err := w.handler.Handle(ctx, slog.NewRecord(time.Now(), level, msg, 0)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// lineLevel guesses the level of a log line from its first word
+func lineLevel(msg string) slog.Level {
+	switch {
+	case strings.HasPrefix(msg, "Warning"), strings.HasPrefix(msg, "WARN"):
+		return slog.LevelWarn
+	case strings.HasPrefix(msg, "Error"), strings.HasPrefix(msg, "ERROR"),
+		strings.HasPrefix(msg, "Failed"), strings.HasPrefix(msg, "Panic"):
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// AccessLog returns middleware that writes one line per request to cfg's sink.
+// The level filters by status: info logs every request, warn only 4xx and 5xx,
+// error only 5xx. Without an output the log goes to stderr as JSON.
+func AccessLog(cfg configstore.LogSinkConfig) (echo.MiddlewareFunc, error) {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+	w, err := open(cfg.Output, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if w == nil {
+		return func(next echo.HandlerFunc) echo.HandlerFunc { return next }, nil
+	}
+
+	var format func(v middleware.RequestLoggerValues) string
+	switch cfg.Format {
+	case "", FormatJSON:
+		format = jsonAccessLine
+	case FormatCombined:
+		format = combinedAccessLine
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", cfg.Format)
+	}
+
+	var mu sync.Mutex
+	return middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
+		LogURI:          true,
+		LogMethod:       true,
+		LogStatus:       true,
+		LogError:        true,
+		LogLatency:      true,
+		LogRemoteIP:     true,
+		LogUserAgent:    true,
+		LogRequestID:    true,
+		LogProtocol:     true,
+		LogReferer:      true,
+		LogResponseSize: true,
+		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+			if statusLevel(v.Status) < level {
+				return nil
+			}
+			line := format(v)
+			mu.Lock()
+			defer mu.Unlock()
+			_, err := io.WriteString(w, line+"\n")
+			return err
+		},
+	}), nil
+}
+
+// statusLevel is the level of an access log line
+func statusLevel(status int) slog.Level {
+	switch {
+	case status >= 500:
+		return slog.LevelError
+	case status >= 400:
+		return slog.LevelWarn
+	}
+	return slog.LevelInfo
+}
+
+func jsonAccessLine(v middleware.RequestLoggerValues) string {
+	errStr := ""
+	if v.Error != nil {
+		errStr = v.Error.Error()
+	}
+	return fmt.Sprintf(`{"time":%q,"request_id":%q,"remote_ip":%q,"method":%q,"uri":%q,"status":%d,"latency_human":%q,"error":%q}`,
+		v.StartTime.Format("2006-01-02T15:04:05.000000Z07:00"),
+		v.RequestID, v.RemoteIP, v.Method, v.URI, v.Status, v.Latency, errStr)
+}
+
+func combinedAccessLine(v middleware.RequestLoggerValues) string {
+	return fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %d %q %q`,
+		v.RemoteIP, v.StartTime.Format("02/Jan/2006:15:04:05 -0700"),
+		v.Method, v.URI, v.Protocol, v.Status, v.ResponseSize, dash(v.Referer), dash(v.UserAgent))
+}
+
+// dash stands in for an empty field, as in Apache logs
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,60 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+)
+
+func TestLineLevel(t *testing.T) {
+	assert.Equal(t, slog.LevelWarn, lineLevel("Warning: client secret is weak"))
+	assert.Equal(t, slog.LevelError, lineLevel("Failed to store error report"))
+	assert.Equal(t, slog.LevelError, lineLevel("Error closing storage: boom"))
+	assert.Equal(t, slog.LevelInfo, lineLevel("Config reloaded"))
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("")
+	require.NoError(t, err)
+	assert.Equal(t, slog.LevelInfo, level)
+	level, err = ParseLevel("WARN")
+	require.NoError(t, err)
+	assert.Equal(t, slog.LevelWarn, level)
+	_, err = ParseLevel("verbose")
+	assert.Error(t, err)
+}
+
+func TestAccessLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	accessLog, err := AccessLog(configstore.LogSinkConfig{Output: path, Format: FormatCombined, Level: "warn"})
+	require.NoError(t, err)
+
+	e := echo.New()
+	handler := accessLog(func(c echo.Context) error {
+		return c.NoContent(map[string]int{"/ok": http.StatusOK, "/missing": http.StatusNotFound}[c.Request().URL.Path])
+	})
+	for _, path := range []string{"/ok", "/missing"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("User-Agent", "test-agent")
+		require.NoError(t, handler(e.NewContext(req, httptest.NewRecorder())))
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 1, "the warn level drops 2xx responses")
+	assert.Contains(t, lines[0], `"GET /missing HTTP/1.1" 404 0 "-" "test-agent"`)
+
+	_, err = AccessLog(configstore.LogSinkConfig{Format: FormatText})
+	assert.Error(t, err)
+}
@@ -0,0 +1,66 @@
+// Package mail sends outgoing email such as passwordless sign-in links.
+package mail
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+)
+
+const defaultSMTPPort = 587
+
+// Sender delivers a plain-text email message
+type Sender interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPSender delivers mail through an SMTP relay
+type SMTPSender struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPSender creates a sender for the configured SMTP relay.
+// It returns nil when no SMTP host is configured.
+func NewSMTPSender(cfg configstore.SMTPConfig) *SMTPSender {
+	if cfg.Host == "" {
+		return nil
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = defaultSMTPPort
+	}
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+	return &SMTPSender{
+		addr: net.JoinHostPort(cfg.Host, strconv.Itoa(port)),
+		auth: auth,
+		from: cfg.From,
+	}
+}
+
+// Send delivers a plain-text message to a single recipient
+func (s *SMTPSender) Send(to, subject, body string) error {
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("invalid mail header value")
+	}
+
+	msg := "From: " + s.from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body
+	if err := smtp.SendMail(s.addr, s.auth, s.from, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter counts events per key in fixed time windows
+type RateLimiter struct {
+	mu        sync.Mutex
+	window    time.Duration
+	counts    map[string]*rateWindow
+	nextPrune time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// NewRateLimiter creates a rate limiter with the given window length
+func NewRateLimiter(window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		window: window,
+		counts: make(map[string]*rateWindow),
+	}
+}
+
+// Allow records an event for key and reports whether it is within limit for
+// the current window. A limit of 0 or less means unlimited.
+func (l *RateLimiter) Allow(key string, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	w, ok := l.counts[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &rateWindow{start: now}
+		l.counts[key] = w
+	}
+	if w.count >= limit {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// prune drops windows that have ended, at most once per window
+func (l *RateLimiter) prune(now time.Time) {
+	if now.Before(l.nextPrune) {
+		return
+	}
+	for key, w := range l.counts {
+		if now.Sub(w.start) >= l.window {
+			delete(l.counts, key)
+		}
+	}
+	l.nextPrune = now.Add(l.window)
+}
+
+// Add records an event for key without enforcing a limit
+func (l *RateLimiter) Add(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	w, ok := l.counts[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &rateWindow{start: now}
+		l.counts[key] = w
+	}
+	w.count++
+}
+
+// Count returns the number of events recorded for key in the current window
+func (l *RateLimiter) Count(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.counts[key]
+	if !ok || time.Since(w.start) >= l.window {
+		return 0
+	}
+	return w.count
+}
+
+// Reset forgets all events recorded for key
+func (l *RateLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.counts, key)
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	limiter := NewRateLimiter(50 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow("10.0.0.1", 3) {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+	}
+	if limiter.Allow("10.0.0.1", 3) {
+		t.Error("fourth request should be rejected")
+	}
+	if !limiter.Allow("10.0.0.2", 3) {
+		t.Error("other keys should have their own quota")
+	}
+	if !limiter.Allow("10.0.0.1", 0) {
+		t.Error("a zero limit should mean unlimited")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !limiter.Allow("10.0.0.1", 3) {
+		t.Error("quota should reset after the window")
+	}
+}
+
+func TestRateLimiter_CountAndReset(t *testing.T) {
+	limiter := NewRateLimiter(50 * time.Millisecond)
+
+	limiter.Add("user:alice")
+	limiter.Add("user:alice")
+	if got := limiter.Count("user:alice"); got != 2 {
+		t.Errorf("Count() = %d, want 2", got)
+	}
+	limiter.Reset("user:alice")
+	if got := limiter.Count("user:alice"); got != 0 {
+		t.Errorf("Count() after Reset = %d, want 0", got)
+	}
+
+	limiter.Add("10.0.0.1")
+	time.Sleep(60 * time.Millisecond)
+	if got := limiter.Count("10.0.0.1"); got != 0 {
+		t.Errorf("Count() after the window = %d, want 0", got)
+	}
+}
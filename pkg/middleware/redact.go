@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// Redacted replaces the value of sensitive fields in logged payloads
+const Redacted = "[REDACTED]"
+
+// sensitiveFields lists OAuth/OIDC parameters that carry credentials
+var sensitiveFields = map[string]bool{
+	"password":                  true,
+	"client_secret":             true,
+	"client_assertion":          true,
+	"assertion":                 true,
+	"code":                      true,
+	"code_verifier":             true,
+	"device_code":               true,
+	"access_token":              true,
+	"refresh_token":             true,
+	"id_token":                  true,
+	"id_token_hint":             true,
+	"token":                     true,
+	"logout_token":              true,
+	"registration_access_token": true,
+	"api_key":                   true,
+	"authorization":             true,
+	"cookie":                    true,
+	"set-cookie":                true,
+}
+
+// IsSensitiveField reports whether a parameter, JSON key or header name carries a secret
+func IsSensitiveField(name string) bool {
+	name = strings.ToLower(name)
+	if sensitiveFields[name] {
+		return true
+	}
+	return strings.Contains(name, "password") ||
+		strings.Contains(name, "secret") ||
+		strings.HasSuffix(name, "_token") ||
+		strings.HasSuffix(name, "private_key")
+}
+
+// RedactValues returns a copy of values with sensitive parameters redacted
+func RedactValues(values url.Values) url.Values {
+	redacted := make(url.Values, len(values))
+	for key, vals := range values {
+		if IsSensitiveField(key) {
+			redacted[key] = []string{Redacted}
+			continue
+		}
+		redacted[key] = vals
+	}
+	return redacted
+}
+
+// RedactURL redacts sensitive parameters in the query and fragment of a URL
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Redacted
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = RedactValues(u.Query()).Encode()
+	}
+	if u.Fragment != "" {
+		if fragment, err := url.ParseQuery(u.Fragment); err == nil {
+			u.Fragment = RedactValues(fragment).Encode()
+		}
+	}
+	return u.String()
+}
+
+// RedactJSON redacts sensitive keys at any depth of a JSON document.
+// Bodies that are not valid JSON are replaced entirely.
+func RedactJSON(body []byte) string {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return Redacted
+	}
+	out, err := json.Marshal(redactJSONValue(doc))
+	if err != nil {
+		return Redacted
+	}
+	return string(out)
+}
+
+func redactJSONValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for key, child := range val {
+			if IsSensitiveField(key) {
+				val[key] = Redacted
+				continue
+			}
+			val[key] = redactJSONValue(child)
+		}
+		return val
+	case []interface{}:
+		for i, child := range val {
+			val[i] = redactJSONValue(child)
+		}
+		return val
+	default:
+		return v
+	}
+}
+
+// RedactBody redacts a request or response body based on its content type.
+// Content types that cannot be inspected are not logged.
+func RedactBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	switch {
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return Redacted
+		}
+		return RedactValues(values).Encode()
+	case strings.Contains(contentType, "json"):
+		return RedactJSON(body)
+	default:
+		return Redacted
+	}
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedactValues(t *testing.T) {
+	values := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {"oidc_ac_secret"},
+		"code_verifier": {"verifier"},
+		"client_id":     {"my-client"},
+		"client_secret": {"s3cr3t"},
+		"api_key":       {"oidc_sk_key"},
+		"state":         {"xyz"},
+	}
+
+	redacted := RedactValues(values)
+
+	for _, key := range []string{"code", "code_verifier", "client_secret", "api_key"} {
+		if got := redacted.Get(key); got != Redacted {
+			t.Errorf("%s = %q, want redacted", key, got)
+		}
+	}
+	for _, key := range []string{"grant_type", "client_id", "state"} {
+		if got := redacted.Get(key); got != values.Get(key) {
+			t.Errorf("%s = %q, want %q", key, got, values.Get(key))
+		}
+	}
+	if values.Get("code") != "oidc_ac_secret" {
+		t.Error("RedactValues should not modify its input")
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	got := RedactURL("https://app.example.com/cb#access_token=oidc_at_abc&id_token=eyJ&state=s1")
+	if strings.Contains(got, "oidc_at_abc") || strings.Contains(got, "eyJ") {
+		t.Errorf("tokens leaked in %q", got)
+	}
+	if !strings.Contains(got, "state=s1") {
+		t.Errorf("state should be preserved in %q", got)
+	}
+}
+
+func TestRedactJSON(t *testing.T) {
+	got := RedactJSON([]byte(`{"access_token":"oidc_at_abc","token_type":"Bearer","nested":{"refresh_token":"oidc_rt_def","password":"pw"},"api_key":"oidc_sk_ghi"}`))
+	for _, secret := range []string{"oidc_at_abc", "oidc_rt_def", `"pw"`, "oidc_sk_ghi"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("%s leaked in %q", secret, got)
+		}
+	}
+	if !strings.Contains(got, `"token_type":"Bearer"`) {
+		t.Errorf("token_type should be preserved in %q", got)
+	}
+
+	if got := RedactJSON([]byte("not json")); got != Redacted {
+		t.Errorf("invalid JSON = %q, want fully redacted", got)
+	}
+}
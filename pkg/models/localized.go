@@ -0,0 +1,177 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Client metadata that may carry language-tagged variants
+// (OpenID Connect Dynamic Client Registration 1.0 §2.1)
+const (
+	metadataClientName = "client_name"
+	metadataLogoURI    = "logo_uri"
+	metadataClientURI  = "client_uri"
+	metadataPolicyURI  = "policy_uri"
+	metadataTosURI     = "tos_uri"
+)
+
+// localizedMetadata returns the client's localized value maps keyed by metadata name
+func (c *Client) localizedMetadata() map[string]*map[string]string {
+	return map[string]*map[string]string{
+		metadataClientName: &c.ClientNameLocalized,
+		metadataLogoURI:    &c.LogoURILocalized,
+		metadataClientURI:  &c.ClientURILocalized,
+		metadataPolicyURI:  &c.PolicyURILocalized,
+		metadataTosURI:     &c.TosURILocalized,
+	}
+}
+
+// localizedMetadata returns the request's localized value maps keyed by metadata name
+func (r *ClientRegistrationRequest) localizedMetadata() map[string]*map[string]string {
+	return map[string]*map[string]string{
+		metadataClientName: &r.ClientNameLocalized,
+		metadataLogoURI:    &r.LogoURILocalized,
+		metadataClientURI:  &r.ClientURILocalized,
+		metadataPolicyURI:  &r.PolicyURILocalized,
+		metadataTosURI:     &r.TosURILocalized,
+	}
+}
+
+// MarshalJSON adds the language-tagged metadata keys to the client's JSON form
+func (c Client) MarshalJSON() ([]byte, error) {
+	type plain Client
+	data, err := json.Marshal(plain(c))
+	if err != nil {
+		return nil, err
+	}
+	return mergeJSONObject(data, localizedKeys(c.localizedMetadata()))
+}
+
+// UnmarshalJSON reads the language-tagged metadata keys into the localized maps
+func (c *Client) UnmarshalJSON(data []byte) error {
+	type plain Client
+	if err := json.Unmarshal(data, (*plain)(c)); err != nil {
+		return err
+	}
+	return parseLocalizedKeys(data, c.localizedMetadata())
+}
+
+// UnmarshalJSON reads the language-tagged metadata keys into the localized maps
+func (r *ClientRegistrationRequest) UnmarshalJSON(data []byte) error {
+	type plain ClientRegistrationRequest
+	if err := json.Unmarshal(data, (*plain)(r)); err != nil {
+		return err
+	}
+	return parseLocalizedKeys(data, r.localizedMetadata())
+}
+
+// MarshalJSON keeps the registration fields alongside the embedded client's
+// metadata, which would otherwise be hidden by Client.MarshalJSON
+func (r ClientRegistrationResponse) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(r.Client)
+	if err != nil {
+		return nil, err
+	}
+	extra := map[string]interface{}{}
+	if r.RegistrationAccessToken != "" {
+		extra["registration_access_token"] = r.RegistrationAccessToken
+	}
+	if r.RegistrationClientURI != "" {
+		extra["registration_client_uri"] = r.RegistrationClientURI
+	}
+	return mergeJSONObject(data, extra)
+}
+
+// UnmarshalJSON reads both the embedded client and the registration fields
+func (r *ClientRegistrationResponse) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &r.Client); err != nil {
+		return err
+	}
+	var fields struct {
+		RegistrationAccessToken string `json:"registration_access_token"`
+		RegistrationClientURI   string `json:"registration_client_uri"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	r.RegistrationAccessToken = fields.RegistrationAccessToken
+	r.RegistrationClientURI = fields.RegistrationClientURI
+	return nil
+}
+
+// localizedKeys flattens localized maps into "name#tag" keys
+func localizedKeys(fields map[string]*map[string]string) map[string]interface{} {
+	keys := map[string]interface{}{}
+	for name, values := range fields {
+		for tag, value := range *values {
+			keys[name+"#"+tag] = value
+		}
+	}
+	return keys
+}
+
+// parseLocalizedKeys collects "name#tag" keys of a JSON object into localized maps
+func parseLocalizedKeys(data []byte, fields map[string]*map[string]string) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for key, value := range raw {
+		name, tag, found := strings.Cut(key, "#")
+		target, known := fields[name]
+		if !found || !known {
+			continue
+		}
+		if !IsLanguageTag(tag) {
+			return fmt.Errorf("%s: invalid language tag", key)
+		}
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			return fmt.Errorf("%s must be a string", key)
+		}
+		if *target == nil {
+			*target = make(map[string]string)
+		}
+		(*target)[tag] = s
+	}
+	return nil
+}
+
+// mergeJSONObject adds extra members to an encoded JSON object
+func mergeJSONObject(data []byte, extra map[string]interface{}) ([]byte, error) {
+	if len(extra) == 0 {
+		return data, nil
+	}
+	encoded, err := json.Marshal(extra)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= 2 {
+		return encoded, nil
+	}
+	merged := make([]byte, 0, len(data)+len(encoded))
+	merged = append(merged, data[:len(data)-1]...)
+	merged = append(merged, ',')
+	return append(merged, encoded[1:]...), nil
+}
+
+// IsLanguageTag performs a syntactic check of an RFC 5646 language tag:
+// hyphen-separated subtags of 1-8 ASCII letters or digits, starting with a letter subtag
+func IsLanguageTag(tag string) bool {
+	if tag == "" {
+		return false
+	}
+	for i, subtag := range strings.Split(tag, "-") {
+		if len(subtag) == 0 || len(subtag) > 8 {
+			return false
+		}
+		for _, r := range subtag {
+			isLetter := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+			if !isLetter && (i == 0 || r < '0' || r > '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -62,6 +63,22 @@ type User struct {
 	// Address Claim (from OIDC Core 1.0 Section 5.1.1)
 	Address *Address `json:"address,omitempty"` // Physical mailing address
 
+	// Language-tagged claim values (OIDC Core 1.0 Section 5.2), keyed by claim name then language tag,
+	// e.g. {"name": {"ja-Kana-JP": "ヤマダタロウ"}}
+	LocalizedClaims map[string]map[string]string `json:"localized_claims,omitempty"`
+
+	// Account state
+	Disabled    bool       `json:"disabled,omitempty"`     // Set by an admin; blocks sign-in until re-enabled
+	LockedUntil *time.Time `json:"locked_until,omitempty"` // Temporary lock that lifts on its own
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`   // Soft delete; hidden from lists by default
+
+	// ServiceAccount marks a non-human user for batch jobs. It has no password and
+	// authenticates only with API keys at the token endpoint.
+	ServiceAccount bool `json:"service_account,omitempty"`
+
+	// Version counts stored updates; an admin update must name the version it is based on
+	Version int `json:"version"`
+
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -76,6 +93,40 @@ func (u *User) HasRole(role UserRole) bool {
 	return u.Role == role
 }
 
+// IsDeleted returns true if the user has been soft-deleted
+func (u *User) IsDeleted() bool {
+	return u.DeletedAt != nil
+}
+
+// IsLocked returns true while a temporary lock is in effect
+func (u *User) IsLocked() bool {
+	return u.LockedUntil != nil && time.Now().Before(*u.LockedUntil)
+}
+
+// CanAuthenticate returns true if the user may sign in or refresh tokens
+func (u *User) CanAuthenticate() bool {
+	return !u.Disabled && !u.IsLocked() && !u.IsDeleted()
+}
+
+// NormalizeEmail returns the canonical form of an email address: trimmed and
+// lowercased and, when stripPlusTag is set, without a "+tag" in the local part,
+// so that Alice+News@Example.com becomes alice@example.com
+func NormalizeEmail(email string, stripPlusTag bool) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if !stripPlusTag {
+		return email
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	local, domain := email[:at], email[at:]
+	if plus := strings.Index(local, "+"); plus > 0 {
+		local = local[:plus]
+	}
+	return local + domain
+}
+
 // Client represents an OAuth2/OIDC client with full OIDC Dynamic Registration support
 type Client struct {
 	// Core OAuth 2.0 fields
@@ -92,7 +143,7 @@ type Client struct {
 	Contacts        []string `json:"contacts,omitempty" bson:"contacts,omitempty"`                 // Email addresses
 	ClientName      string   `json:"client_name,omitempty" bson:"client_name,omitempty"`           // Human-readable name
 
-	// Localized metadata (stored as JSON internally, exposed via special handling)
+	// Localized metadata, serialized as language-tagged keys ("client_name#ja-JP")
 	ClientNameLocalized map[string]string `json:"-" bson:"client_name_localized,omitempty"` // e.g., "en" -> "My App"
 
 	// Client URIs
@@ -121,6 +172,9 @@ type Client struct {
 	UserInfoEncryptedResponseAlg string `json:"userinfo_encrypted_response_alg,omitempty" bson:"userinfo_encrypted_response_alg,omitempty"`
 	UserInfoEncryptedResponseEnc string `json:"userinfo_encrypted_response_enc,omitempty" bson:"userinfo_encrypted_response_enc,omitempty"`
 
+	// Introspection response signing preference (RFC 9701)
+	IntrospectionSignedResponseAlg string `json:"introspection_signed_response_alg,omitempty" bson:"introspection_signed_response_alg,omitempty"`
+
 	// Request Object signing/encryption preferences
 	RequestObjectSigningAlg    string `json:"request_object_signing_alg,omitempty" bson:"request_object_signing_alg,omitempty"`
 	RequestObjectEncryptionAlg string `json:"request_object_encryption_alg,omitempty" bson:"request_object_encryption_alg,omitempty"`
@@ -129,26 +183,78 @@ type Client struct {
 	// Token Endpoint Authentication
 	TokenEndpointAuthMethod     string `json:"token_endpoint_auth_method,omitempty" bson:"token_endpoint_auth_method,omitempty"`
 	TokenEndpointAuthSigningAlg string `json:"token_endpoint_auth_signing_alg,omitempty" bson:"token_endpoint_auth_signing_alg,omitempty"`
+	// SigningKeyID pins the client's ID tokens to one stored signing key, for partners
+	// that refresh JWKS slowly. Empty = the active signing key.
+	SigningKeyID string `json:"signing_key_id,omitempty" bson:"signing_key_id,omitempty"`
 
 	// Authentication requirements
 	DefaultMaxAge    int      `json:"default_max_age,omitempty" bson:"default_max_age,omitempty"`
 	RequireAuthTime  bool     `json:"require_auth_time,omitempty" bson:"require_auth_time,omitempty"`
 	DefaultACRValues []string `json:"default_acr_values,omitempty" bson:"default_acr_values,omitempty"`
+	AuthFlow         string   `json:"auth_flow,omitempty" bson:"auth_flow,omitempty"` // Named sign-in flow from the server config; empty = selected by ACR or default
+	// RequirePKCE limits the client to the authorization code flow with an S256 code_challenge
+	RequirePKCE bool `json:"require_pkce,omitempty" bson:"require_pkce,omitempty"`
+	// FirstParty clients are our own apps and skip the consent screen for
+	// FirstPartyScopes (empty = every scope); the grant is stored as implicit consent
+	FirstParty       bool     `json:"first_party,omitempty" bson:"first_party,omitempty"`
+	FirstPartyScopes []string `json:"first_party_scopes,omitempty" bson:"first_party_scopes,omitempty"`
+	// InstanceBinding is "optional" or "required" for public native clients whose
+	// installs register as client instances; their refresh tokens are bound to the instance
+	InstanceBinding string `json:"instance_binding,omitempty" bson:"instance_binding,omitempty"`
+	// InstanceAttestation lists the attestation types an install must present to
+	// register, e.g. "play_integrity" or "app_attest"; empty = no attestation
+	InstanceAttestation []string `json:"instance_attestation,omitempty" bson:"instance_attestation,omitempty"`
 
 	// Advanced features
 	InitiateLoginURI string   `json:"initiate_login_uri,omitempty" bson:"initiate_login_uri,omitempty"`
 	RequestURIs      []string `json:"request_uris,omitempty" bson:"request_uris,omitempty"`
 
+	// Refresh tokens are revoked when the user session that obtained them ends
+	BindRefreshTokensToSession bool `json:"bind_refresh_tokens_to_session,omitempty" bson:"bind_refresh_tokens_to_session,omitempty"`
+	// RefreshTokenMaxUses caps how many times a refresh token family (the chain of
+	// rotated refresh tokens from one grant) can be refreshed; 0 = unlimited
+	RefreshTokenMaxUses int `json:"refresh_token_max_uses,omitempty" bson:"refresh_token_max_uses,omitempty"`
+	// RefreshTokenIdleTimeout expires a refresh token family left unused for this
+	// many seconds; 0 = no inactivity expiry
+	RefreshTokenIdleTimeout int `json:"refresh_token_idle_timeout,omitempty" bson:"refresh_token_idle_timeout,omitempty"`
+
+	// Resource servers (APIs) may introspect only tokens carrying one of their
+	// resource scopes, and see just those scopes
+	ResourceServer bool     `json:"resource_server,omitempty" bson:"resource_server,omitempty"`
+	ResourceScopes []string `json:"resource_scopes,omitempty" bson:"resource_scopes,omitempty"`
+
+	// MinimalIDToken keeps profile, email and address claims out of ID tokens; the
+	// client reads them from userinfo instead
+	MinimalIDToken bool `json:"minimal_id_token,omitempty" bson:"minimal_id_token,omitempty"`
+	// UserInfoCacheSeconds lets the client cache userinfo responses privately for
+	// this many seconds; 0 = responses are sent with Cache-Control: no-store
+	UserInfoCacheSeconds int `json:"userinfo_cache_seconds,omitempty" bson:"userinfo_cache_seconds,omitempty"`
+
+	// TokenResponseParams adds vendor-specific top-level members to token responses
+	// for legacy SDKs. Values are text/template templates, e.g. "{{.client_id}}".
+	TokenResponseParams map[string]string `json:"token_response_params,omitempty" bson:"token_response_params,omitempty"`
+
+	// Troubleshooting
+	DebugLogging bool `json:"debug_logging,omitempty" bson:"debug_logging,omitempty"` // Log redacted request/response payloads
+
 	// Software Statement (JWT containing client metadata claims)
 	SoftwareID        string `json:"software_id,omitempty" bson:"software_id,omitempty"`
 	SoftwareVersion   string `json:"software_version,omitempty" bson:"software_version,omitempty"`
 	SoftwareStatement string `json:"software_statement,omitempty" bson:"software_statement,omitempty"` // JWT
 
 	// Registration metadata (internal use)
-	RegistrationAccessToken string    `json:"-" bson:"registration_access_token,omitempty"`                       // Never exposed in responses (except registration response)
-	ClientIDIssuedAt        int64     `json:"client_id_issued_at,omitempty" bson:"client_id_issued_at,omitempty"` // Unix timestamp
-	CreatedAt               time.Time `json:"-" bson:"created_at"`
-	UpdatedAt               time.Time `json:"-" bson:"updated_at"`
+	RegistrationAccessToken string     `json:"-" bson:"registration_access_token,omitempty"`                       // Never exposed in responses (except registration response)
+	ClientIDIssuedAt        int64      `json:"client_id_issued_at,omitempty" bson:"client_id_issued_at,omitempty"` // Unix timestamp
+	Status                  string     `json:"status,omitempty" bson:"status,omitempty"`                           // Registration review state, empty = active
+	Disabled                bool       `json:"disabled,omitempty" bson:"disabled,omitempty"`                       // Suspended by an admin; configuration is kept for re-enablement
+	LastUsedAt              *time.Time `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	RedirectURIFindings     []string   `json:"redirect_uri_findings,omitempty" bson:"redirect_uri_findings,omitempty"` // Problems found by the redirect host scan
+	RedirectURIsCheckedAt   *time.Time `json:"redirect_uris_checked_at,omitempty" bson:"redirect_uris_checked_at,omitempty"`
+	TrustAnchor             string     `json:"trust_anchor,omitempty" bson:"trust_anchor,omitempty"`                   // Federation the client was registered automatically through
+	FederationExpiresAt     *time.Time `json:"federation_expires_at,omitempty" bson:"federation_expires_at,omitempty"` // When its trust chain must be resolved again
+	CreatedAt               time.Time  `json:"-" bson:"created_at"`
+	UpdatedAt               time.Time  `json:"-" bson:"updated_at"`
+	Version                 int        `json:"version" bson:"version"` // Counts stored updates; an admin update must name the version it is based on
 
 	// Legacy compatibility field (maps to ClientName)
 	Name string `json:"name,omitempty" bson:"-"` // Deprecated: use ClientName
@@ -164,24 +270,35 @@ type AuthorizationCode struct {
 	Nonce               string     `json:"nonce,omitempty" bson:"nonce,omitempty"`
 	CodeChallenge       string     `json:"code_challenge,omitempty" bson:"code_challenge,omitempty"`
 	CodeChallengeMethod string     `json:"code_challenge_method,omitempty" bson:"code_challenge_method,omitempty"`
+	ClaimsLocales       []string   `json:"claims_locales,omitempty" bson:"claims_locales,omitempty"`
+	SessionID           string     `json:"session_id,omitempty" bson:"session_id,omitempty"` // UserSession that authorized the code
 	Used                bool       `json:"used" bson:"used"`
 	UsedAt              *time.Time `json:"used_at,omitempty" bson:"used_at,omitempty"`
+	ReuseCount          int        `json:"reuse_count,omitempty" bson:"reuse_count,omitempty"` // Times the code was refused after it was used or expired
 	ExpiresAt           time.Time  `json:"expires_at" bson:"expires_at"`
 	CreatedAt           time.Time  `json:"created_at" bson:"created_at"`
 }
 
 // Token represents an access or refresh token
 type Token struct {
-	ID                  string    `json:"id"`
-	AccessToken         string    `json:"access_token"`
-	RefreshToken        string    `json:"refresh_token,omitempty"`
-	TokenType           string    `json:"token_type"`
-	ClientID            string    `json:"client_id"`
-	UserID              string    `json:"user_id"`
-	Scope               string    `json:"scope"`
+	ID                  string    `json:"id" bson:"id"`
+	AccessToken         string    `json:"access_token" bson:"access_token"`
+	RefreshToken        string    `json:"refresh_token,omitempty" bson:"refresh_token,omitempty"`
+	TokenType           string    `json:"token_type" bson:"token_type"`
+	ClientID            string    `json:"client_id" bson:"client_id"`
+	UserID              string    `json:"user_id" bson:"user_id"`
+	Scope               string    `json:"scope" bson:"scope"`
 	AuthorizationCodeID string    `json:"authorization_code_id,omitempty" bson:"authorization_code_id,omitempty"`
-	ExpiresAt           time.Time `json:"expires_at"`
-	CreatedAt           time.Time `json:"created_at"`
+	ClaimsLocales       []string  `json:"claims_locales,omitempty" bson:"claims_locales,omitempty"`
+	SessionID           string    `json:"session_id,omitempty" bson:"session_id,omitempty"`         // Bound UserSession, revoked with it
+	DeviceID            string    `json:"device_id,omitempty" bson:"device_id,omitempty"`           // Device the token was issued to; its refresh token only works with the same device_id
+	InstanceID          string    `json:"instance_id,omitempty" bson:"instance_id,omitempty"`       // Client instance the token was issued to; its refresh token only works for that instance
+	SigningKeyID        string    `json:"signing_key_id,omitempty" bson:"signing_key_id,omitempty"` // Key active when the token's ID token was signed
+	ReplacedBy          string    `json:"replaced_by,omitempty" bson:"replaced_by,omitempty"`       // Token issued for this one's refresh token
+	ReplacedAt          time.Time `json:"replaced_at,omitempty" bson:"replaced_at,omitempty"`
+	RefreshCount        int       `json:"refresh_count,omitempty" bson:"refresh_count,omitempty"` // Refreshes in the token's family before it was issued
+	ExpiresAt           time.Time `json:"expires_at" bson:"expires_at"`
+	CreatedAt           time.Time `json:"created_at" bson:"created_at"`
 }
 
 // Session represents a user session
@@ -205,6 +322,30 @@ type SigningKey struct {
 	IsActive   bool      `json:"is_active" bson:"is_active"`             // Whether this key is used for signing
 	CreatedAt  time.Time `json:"created_at" bson:"created_at"`           // When the key was created
 	ExpiresAt  time.Time `json:"expires_at,omitempty" bson:"expires_at"` // When the key expires — taken from cert NotAfter
+	Use        string    `json:"use,omitempty" bson:"use,omitempty"`     // "sig" (default), "enc" or "cookie"
+}
+
+// Key uses, as published in the JWK "use" parameter. Cookie keys are symmetric
+// keys that seal session cookies; they are never published.
+const (
+	KeyUseSignature  = "sig"
+	KeyUseEncryption = "enc"
+	KeyUseCookie     = "cookie"
+)
+
+// IsEncryptionKey reports whether the key decrypts request objects rather than signing tokens
+func (k *SigningKey) IsEncryptionKey() bool {
+	return k.Use == KeyUseEncryption
+}
+
+// IsCookieKey reports whether the key seals session cookies rather than signing tokens
+func (k *SigningKey) IsCookieKey() bool {
+	return k.Use == KeyUseCookie
+}
+
+// IsSigningKey reports whether the key signs tokens
+func (k *SigningKey) IsSigningKey() bool {
+	return k.Use == "" || k.Use == KeyUseSignature
 }
 
 // IsExpired checks if the key has expired
@@ -246,10 +387,37 @@ type AuthSession struct {
 	AuthenticationMethod string                 `json:"authentication_method,omitempty" bson:"authentication_method,omitempty"`
 	ACR                  string                 `json:"acr,omitempty" bson:"acr,omitempty"`
 	AMR                  []string               `json:"amr,omitempty" bson:"amr,omitempty"`
+	CompletedSteps       []string               `json:"completed_steps,omitempty" bson:"completed_steps,omitempty"` // Sign-in flow steps passed so far
+	PendingUserID        string                 `json:"pending_user_id,omitempty" bson:"pending_user_id,omitempty"` // User identified by an earlier step
+	StepState            map[string]string      `json:"step_state,omitempty" bson:"step_state,omitempty"`           // Per-step data kept by authenticators, e.g. OTP hashes
+	Owner                string                 `json:"owner,omitempty" bson:"owner,omitempty"`                     // Browser or user that started the flow, used to cap pending sessions
 	ExpiresAt            time.Time              `json:"expires_at" bson:"expires_at"`
 	CreatedAt            time.Time              `json:"created_at" bson:"created_at"`
 }
 
+// EssentialACR returns the acr values the claims request asks for as an essential
+// ID token claim (OpenID Connect Core Section 5.5.1.1), and whether it does. No
+// values with essential true means any acr will do.
+func (s *AuthSession) EssentialACR() ([]string, bool) {
+	idToken, _ := s.Claims["id_token"].(map[string]interface{})
+	acr, _ := idToken["acr"].(map[string]interface{})
+	if essential, _ := acr["essential"].(bool); !essential {
+		return nil, false
+	}
+	var values []string
+	if value, ok := acr["value"].(string); ok && value != "" {
+		values = append(values, value)
+	}
+	if list, ok := acr["values"].([]interface{}); ok {
+		for _, v := range list {
+			if value, ok := v.(string); ok && value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values, true
+}
+
 // UserSession represents an authenticated user session with cookies
 type UserSession struct {
 	ID                   string    `json:"id" bson:"_id"`
@@ -259,6 +427,7 @@ type UserSession struct {
 	ACR                  string    `json:"acr,omitempty" bson:"acr,omitempty"`
 	AMR                  []string  `json:"amr,omitempty" bson:"amr,omitempty"`
 	LastActivityAt       time.Time `json:"last_activity_at" bson:"last_activity_at"`
+	Persistent           bool      `json:"persistent,omitempty" bson:"persistent,omitempty"` // Created with "keep me signed in"
 	ExpiresAt            time.Time `json:"expires_at" bson:"expires_at"`
 	CreatedAt            time.Time `json:"created_at" bson:"created_at"`
 }
@@ -283,6 +452,7 @@ type Consent struct {
 	UserID    string    `json:"user_id" bson:"user_id"`
 	ClientID  string    `json:"client_id" bson:"client_id"`
 	Scopes    []string  `json:"scopes" bson:"scopes"`
+	Implicit  bool      `json:"implicit,omitempty" bson:"implicit,omitempty"` // Granted on the user's behalf for a first-party client
 	CreatedAt time.Time `json:"created_at" bson:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
 }
@@ -354,48 +524,72 @@ func NewClient(name string, redirectURIs []string) *Client {
 	}
 }
 
-// NewAdminUIClient creates a client for the admin UI using implicit flow
+// NewAdminUIClient creates the public client the embedded admin UI signs in with,
+// using the authorization code flow with PKCE and refresh tokens for silent renewal
 func NewAdminUIClient(issuerURL string) *Client {
 	now := time.Now()
 	return &Client{
-		ID:                       "admin-ui",
-		Secret:                   "", // No secret needed for implicit flow
-		SecretExpiresAt:          0,  // N/A for public clients
-		ClientName:               "Admin UI",
-		Name:                     "Admin UI", // Legacy compatibility
-		RedirectURIs:             []string{issuerURL + "/admin/callback"},
-		GrantTypes:               []string{"implicit"},
-		ResponseTypes:            []string{ResponseTypeIDToken, ResponseTypeTokenIDToken},
-		Scope:                    "openid profile email",
-		ApplicationType:          "web",
-		SubjectType:              "public",
-		TokenEndpointAuthMethod:  "none", // Public client
-		IDTokenSignedResponseAlg: "RS256",
-		ClientIDIssuedAt:         now.Unix(),
-		CreatedAt:                now,
-		UpdatedAt:                now,
+		ID:                         "admin-ui",
+		Secret:                     "", // Public client; PKCE protects the code
+		SecretExpiresAt:            0,  // N/A for public clients
+		ClientName:                 "Admin UI",
+		Name:                       "Admin UI", // Legacy compatibility
+		RedirectURIs:               []string{issuerURL + "/admin/callback"},
+		GrantTypes:                 []string{"authorization_code", "refresh_token"},
+		ResponseTypes:              []string{"code"},
+		Scope:                      "openid profile email",
+		ApplicationType:            "web",
+		SubjectType:                "public",
+		TokenEndpointAuthMethod:    "none", // Public client
+		IDTokenSignedResponseAlg:   "RS256",
+		RequirePKCE:                true,
+		BindRefreshTokensToSession: true, // Signing out of the server ends the admin UI session
+		ClientIDIssuedAt:           now.Unix(),
+		CreatedAt:                  now,
+		UpdatedAt:                  now,
+	}
+}
+
+// UpgradeAdminUIClient moves an admin UI client created for the implicit flow by
+// earlier versions to the authorization code flow, keeping its redirect URIs.
+// It reports whether the client was changed.
+func UpgradeAdminUIClient(client *Client) bool {
+	for _, gt := range client.GrantTypes {
+		if gt == "implicit" {
+			upgraded := NewAdminUIClient("")
+			client.GrantTypes = upgraded.GrantTypes
+			client.ResponseTypes = upgraded.ResponseTypes
+			client.TokenEndpointAuthMethod = upgraded.TokenEndpointAuthMethod
+			client.RequirePKCE = upgraded.RequirePKCE
+			client.BindRefreshTokensToSession = upgraded.BindRefreshTokensToSession
+			client.UpdatedAt = upgraded.UpdatedAt
+			return true
+		}
 	}
+	return false
 }
 
-// NewAuthorizationCode creates a new authorization code
-func NewAuthorizationCode(clientID, userID, redirectURI, scope string) *AuthorizationCode {
+// NewAuthorizationCode creates a new authorization code with the given code value,
+// valid for lifetime
+func NewAuthorizationCode(code, clientID, userID, redirectURI, scope string, lifetime time.Duration) *AuthorizationCode {
+	now := time.Now()
 	return &AuthorizationCode{
-		Code:        uuid.New().String(),
+		Code:        code,
 		ClientID:    clientID,
 		UserID:      userID,
 		RedirectURI: redirectURI,
 		Scope:       scope,
-		ExpiresAt:   time.Now().Add(10 * time.Minute),
-		CreatedAt:   time.Now(),
+		ExpiresAt:   now.Add(lifetime),
+		CreatedAt:   now,
 	}
 }
 
-// NewToken creates a new token
-func NewToken(clientID, userID, scope string, expiryMinutes int) *Token {
+// NewToken creates a new token with the given access and refresh token values
+func NewToken(accessToken, refreshToken, clientID, userID, scope string, expiryMinutes int) *Token {
 	return &Token{
 		ID:           uuid.New().String(),
-		AccessToken:  uuid.New().String(),
-		RefreshToken: uuid.New().String(),
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
 		TokenType:    "Bearer",
 		ClientID:     clientID,
 		UserID:       userID,
@@ -428,8 +622,85 @@ func NewConsent(userID, clientID string, scopes []string) *Consent {
 	}
 }
 
+// ConsentReceiptVersion is the Kantara Initiative Consent Receipt specification
+// version that receipts follow
+const ConsentReceiptVersion = "KI-CR-v1.1.0"
+
+// ConsentReceipt is the stored record of a single consent grant. Receipt holds the
+// Kantara-format document; TermsURL the client's terms of service the user accepted.
+type ConsentReceipt struct {
+	ID        string               `json:"id" bson:"_id"`
+	UserID    string               `json:"user_id" bson:"user_id"`
+	ClientID  string               `json:"client_id" bson:"client_id"`
+	TermsURL  string               `json:"terms_url,omitempty" bson:"terms_url,omitempty"`
+	Receipt   KantaraConsentRecord `json:"receipt" bson:"receipt"`
+	CreatedAt time.Time            `json:"created_at" bson:"created_at"`
+}
+
+// KantaraConsentRecord is a consent receipt as defined by the Kantara Initiative
+// Consent Receipt Specification v1.1
+type KantaraConsentRecord struct {
+	Version          string                  `json:"version" bson:"version"`
+	Jurisdiction     string                  `json:"jurisdiction" bson:"jurisdiction"`
+	ConsentTimestamp int64                   `json:"consentTimestamp" bson:"consent_timestamp"`
+	CollectionMethod string                  `json:"collectionMethod" bson:"collection_method"`
+	ConsentReceiptID string                  `json:"consentReceiptID" bson:"consent_receipt_id"`
+	Language         string                  `json:"language,omitempty" bson:"language,omitempty"`
+	PiiPrincipalID   string                  `json:"piiPrincipalId" bson:"pii_principal_id"`
+	PiiControllers   []KantaraPiiController  `json:"piiControllers" bson:"pii_controllers"`
+	PolicyURL        string                  `json:"policyUrl" bson:"policy_url"`
+	Services         []KantaraConsentService `json:"services" bson:"services"`
+	Sensitive        bool                    `json:"sensitive" bson:"sensitive"`
+	SpiCat           []string                `json:"spiCat" bson:"spi_cat"`
+}
+
+// KantaraPiiController identifies the party the personal data is released to
+type KantaraPiiController struct {
+	PiiController    string `json:"piiController" bson:"pii_controller"`
+	OnBehalf         bool   `json:"onBehalf,omitempty" bson:"on_behalf,omitempty"`
+	Contact          string `json:"contact" bson:"contact"`
+	Email            string `json:"email,omitempty" bson:"email,omitempty"`
+	PiiControllerURL string `json:"piiControllerUrl,omitempty" bson:"pii_controller_url,omitempty"`
+}
+
+// KantaraConsentService lists what the user consented to for one service
+type KantaraConsentService struct {
+	Service  string                  `json:"service" bson:"service"`
+	Purposes []KantaraConsentPurpose `json:"purposes" bson:"purposes"`
+}
+
+// KantaraConsentPurpose is one purpose (here, one OAuth scope) the user agreed to
+type KantaraConsentPurpose struct {
+	Purpose              string   `json:"purpose" bson:"purpose"`
+	PurposeCategory      []string `json:"purposeCategory" bson:"purpose_category"`
+	ConsentType          string   `json:"consentType" bson:"consent_type"`
+	PiiCategory          []string `json:"piiCategory" bson:"pii_category"`
+	PrimaryPurpose       bool     `json:"primaryPurpose,omitempty" bson:"primary_purpose,omitempty"`
+	Termination          string   `json:"termination" bson:"termination"`
+	ThirdPartyDisclosure bool     `json:"thirdPartyDisclosure" bson:"third_party_disclosure"`
+	ThirdPartyName       string   `json:"thirdPartyName,omitempty" bson:"third_party_name,omitempty"`
+}
+
+// Client registration review states
+const (
+	ClientStatusActive   = "active"
+	ClientStatusPending  = "pending"
+	ClientStatusRejected = "rejected"
+)
+
 // Client helper methods
 
+// IsApproved returns true if the client may be used for authorization and token requests.
+// Clients created before review states existed have no status and are treated as active.
+func (c *Client) IsApproved() bool {
+	return c.Status == "" || c.Status == ClientStatusActive
+}
+
+// IsDynamicallyRegistered returns true if the client was created through the registration endpoint
+func (c *Client) IsDynamicallyRegistered() bool {
+	return c.RegistrationAccessToken != ""
+}
+
 // IsPublicClient returns true if the client doesn't have a secret (public client)
 func (c *Client) IsPublicClient() bool {
 	return c.Secret == ""
@@ -506,24 +777,32 @@ type ClientRegistrationRequest struct {
 	SoftwareStatement       string                 `json:"software_statement,omitempty"` // JWT
 
 	// OPTIONAL OIDC-specific fields
-	ApplicationType              string   `json:"application_type,omitempty"`
-	SectorIdentifierURI          string   `json:"sector_identifier_uri,omitempty"`
-	SubjectType                  string   `json:"subject_type,omitempty"`
-	RequestObjectSigningAlg      string   `json:"request_object_signing_alg,omitempty"`
-	RequestObjectEncryptionAlg   string   `json:"request_object_encryption_alg,omitempty"`
-	RequestObjectEncryptionEnc   string   `json:"request_object_encryption_enc,omitempty"`
-	UserInfoSignedResponseAlg    string   `json:"userinfo_signed_response_alg,omitempty"`
-	UserInfoEncryptedResponseAlg string   `json:"userinfo_encrypted_response_alg,omitempty"`
-	UserInfoEncryptedResponseEnc string   `json:"userinfo_encrypted_response_enc,omitempty"`
-	IDTokenSignedResponseAlg     string   `json:"id_token_signed_response_alg,omitempty"`
-	IDTokenEncryptedResponseAlg  string   `json:"id_token_encrypted_response_alg,omitempty"`
-	IDTokenEncryptedResponseEnc  string   `json:"id_token_encrypted_response_enc,omitempty"`
-	TokenEndpointAuthSigningAlg  string   `json:"token_endpoint_auth_signing_alg,omitempty"`
-	DefaultMaxAge                int      `json:"default_max_age,omitempty"`
-	RequireAuthTime              bool     `json:"require_auth_time,omitempty"`
-	DefaultACRValues             []string `json:"default_acr_values,omitempty"`
-	InitiateLoginURI             string   `json:"initiate_login_uri,omitempty"`
-	RequestURIs                  []string `json:"request_uris,omitempty"`
+	ApplicationType                string   `json:"application_type,omitempty"`
+	SectorIdentifierURI            string   `json:"sector_identifier_uri,omitempty"`
+	SubjectType                    string   `json:"subject_type,omitempty"`
+	RequestObjectSigningAlg        string   `json:"request_object_signing_alg,omitempty"`
+	RequestObjectEncryptionAlg     string   `json:"request_object_encryption_alg,omitempty"`
+	RequestObjectEncryptionEnc     string   `json:"request_object_encryption_enc,omitempty"`
+	UserInfoSignedResponseAlg      string   `json:"userinfo_signed_response_alg,omitempty"`
+	UserInfoEncryptedResponseAlg   string   `json:"userinfo_encrypted_response_alg,omitempty"`
+	UserInfoEncryptedResponseEnc   string   `json:"userinfo_encrypted_response_enc,omitempty"`
+	IDTokenSignedResponseAlg       string   `json:"id_token_signed_response_alg,omitempty"`
+	IDTokenEncryptedResponseAlg    string   `json:"id_token_encrypted_response_alg,omitempty"`
+	IDTokenEncryptedResponseEnc    string   `json:"id_token_encrypted_response_enc,omitempty"`
+	TokenEndpointAuthSigningAlg    string   `json:"token_endpoint_auth_signing_alg,omitempty"`
+	IntrospectionSignedResponseAlg string   `json:"introspection_signed_response_alg,omitempty"`
+	DefaultMaxAge                  int      `json:"default_max_age,omitempty"`
+	RequireAuthTime                bool     `json:"require_auth_time,omitempty"`
+	DefaultACRValues               []string `json:"default_acr_values,omitempty"`
+	InitiateLoginURI               string   `json:"initiate_login_uri,omitempty"`
+	RequestURIs                    []string `json:"request_uris,omitempty"`
+
+	// Language-tagged variants ("client_name#ja-JP"), parsed by UnmarshalJSON
+	ClientNameLocalized map[string]string `json:"-"`
+	LogoURILocalized    map[string]string `json:"-"`
+	ClientURILocalized  map[string]string `json:"-"`
+	PolicyURILocalized  map[string]string `json:"-"`
+	TosURILocalized     map[string]string `json:"-"`
 }
 
 // ClientRegistrationResponse represents the successful registration response
@@ -554,33 +833,100 @@ type AuditAction string
 
 const (
 	// User / session events
-	AuditActionLogin        AuditAction = "user.login"
-	AuditActionLoginFailed  AuditAction = "user.login_failed"
-	AuditActionConsentGrant AuditAction = "user.consent_granted"
-	AuditActionConsentDeny  AuditAction = "user.consent_denied"
+	AuditActionLogin         AuditAction = "user.login"
+	AuditActionLoginFailed   AuditAction = "user.login_failed"
+	AuditActionLoginCancel   AuditAction = "user.login_cancelled"
+	AuditActionLogout        AuditAction = "user.logout"
+	AuditActionMagicLinkSent AuditAction = "user.magic_link_sent"
+	AuditActionConsentGrant  AuditAction = "user.consent_granted"
+	AuditActionConsentDeny   AuditAction = "user.consent_denied"
+
+	// A session signed out to keep the user within the session limit
+	AuditActionSessionEvicted AuditAction = "user.session_evicted"
+
+	// A conditional-access policy denied an authorization or required a step-up
+	AuditActionAccessDenied AuditAction = "user.access_denied"
+	AuditActionStepUp       AuditAction = "user.step_up_required"
+
+	// A user approved or denied a device at the device verification page
+	AuditActionDeviceApproved AuditAction = "user.device_approved"
+	AuditActionDeviceDenied   AuditAction = "user.device_denied"
+
+	// A user uploaded or removed their avatar
+	AuditActionAvatarChanged AuditAction = "user.avatar_changed"
 
 	// Token events
 	AuditActionTokenIssued  AuditAction = "token.issued"
 	AuditActionTokenRevoked AuditAction = "token.revoked"
 
+	// An authorization code was presented again after it was used or had expired
+	AuditActionAuthCodeRejected AuditAction = "token.code_rejected"
+
 	// Dynamic client registration
 	AuditActionClientRegistered AuditAction = "client.registered"
+	AuditActionClientExpired    AuditAction = "client.expired"
+
+	AuditActionClientInstanceRegistered AuditAction = "client.instance_registered"
+
+	// A client presented wrong credentials or an invalid assertion
+	AuditActionClientAuthFailed AuditAction = "client.auth_failed"
+
+	// A request was refused by a rate limit or quota
+	AuditActionRateLimited AuditAction = "security.rate_limited"
+
+	// Signing keys
+	AuditActionKeyPurged AuditAction = "key.purged"
+
+	// Scheduled analytics reports
+	AuditActionReportSent AuditAction = "report.sent"
 
 	// Admin — user management
 	AuditActionAdminLogin         AuditAction = "admin.login"
+	AuditActionAdminAccessDenied  AuditAction = "admin.access_denied"
 	AuditActionAdminUserCreated   AuditAction = "admin.user.created"
 	AuditActionAdminUserUpdated   AuditAction = "admin.user.updated"
 	AuditActionAdminUserDeleted   AuditAction = "admin.user.deleted"
+	AuditActionAdminUserEnabled   AuditAction = "admin.user.enabled"
+	AuditActionAdminUserDisabled  AuditAction = "admin.user.disabled"
+	AuditActionAdminUserExported  AuditAction = "admin.user.exported"
+	AuditActionAdminUserErased    AuditAction = "admin.user.erased"
 	AuditActionAdminPasswordReset AuditAction = "admin.password.changed"
 
 	// Admin — client management
-	AuditActionAdminClientCreated AuditAction = "admin.client.created"
-	AuditActionAdminClientUpdated AuditAction = "admin.client.updated"
-	AuditActionAdminClientDeleted AuditAction = "admin.client.deleted"
+	AuditActionAdminClientCreated  AuditAction = "admin.client.created"
+	AuditActionAdminClientUpdated  AuditAction = "admin.client.updated"
+	AuditActionAdminClientDeleted  AuditAction = "admin.client.deleted"
+	AuditActionAdminClientApproved AuditAction = "admin.client.approved"
+	AuditActionAdminClientRejected AuditAction = "admin.client.rejected"
+	AuditActionAdminClientEnabled  AuditAction = "admin.client.enabled"
+	AuditActionAdminClientDisabled AuditAction = "admin.client.disabled"
+
+	AuditActionAdminSecretRevealRequested AuditAction = "admin.client.secret_reveal_requested"
+	AuditActionAdminSecretRevealed        AuditAction = "admin.client.secret_revealed"
+
+	// Admin — emergency access: a break-glass code exchanged for an admin token,
+	// and each admin API request made with that token
+	AuditActionAdminBreakGlassRedeemed AuditAction = "admin.break_glass.redeemed"
+	AuditActionAdminBreakGlassRequest  AuditAction = "admin.break_glass.request"
+
+	// Admin — service accounts
+	AuditActionAdminServiceAccountCreated AuditAction = "admin.service_account.created"
+	AuditActionAdminAPIKeyCreated         AuditAction = "admin.api_key.created"
+	AuditActionAdminAPIKeyRevoked         AuditAction = "admin.api_key.revoked"
+	AuditActionAdminInstanceRevoked       AuditAction = "admin.client.instance_revoked"
+
+	AuditActionAdminBootstrapIssued AuditAction = "admin.registration.bootstrap_issued"
 
 	// Admin — system
 	AuditActionAdminSettingsUpdated AuditAction = "admin.settings.updated"
 	AuditActionAdminKeysRotated     AuditAction = "admin.keys.rotated"
+	AuditActionAdminFeatureToggled  AuditAction = "admin.feature.toggled"
+	AuditActionAdminTestTokenMinted AuditAction = "admin.token.minted"
+	AuditActionAdminSessionEnded    AuditAction = "admin.session.terminated"
+	AuditActionAdminMaintenanceSet  AuditAction = "admin.maintenance.updated"
+	AuditActionAdminRetentionSet    AuditAction = "admin.retention.updated"
+	AuditActionAdminKeyDeleted      AuditAction = "admin.key.deleted"
+	AuditActionAdminConsentExported AuditAction = "admin.consent_receipts.exported"
 )
 
 // AuditActorType describes who performed the action.
@@ -615,14 +961,24 @@ type AuditLog struct {
 	UserAgent  string                 `json:"user_agent"  bson:"user_agent"`
 	Status     AuditStatus            `json:"status"      bson:"status"`
 	Details    map[string]interface{} `json:"details"     bson:"details"` // free-form extra context
+
+	// Hash chain making tampering evident; see ChainAfter
+	Seq      int64  `json:"seq,omitempty"       bson:"seq,omitempty"`
+	PrevHash string `json:"prev_hash,omitempty" bson:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"      bson:"hash,omitempty"`
+	Redacted bool   `json:"redacted,omitempty"  bson:"redacted,omitempty"` // Personal data erased; content no longer matches Hash
+
+	// Entries this one records as erased; see AuditRedaction
+	Redactions []AuditRedaction `json:"redactions,omitempty" bson:"redactions,omitempty"`
 }
 
 // AuditFilter carries optional query constraints for listing audit logs.
 type AuditFilter struct {
-	Action AuditAction
-	Actor  string
-	Limit  int
-	Offset int
+	Action  AuditAction
+	Actor   string
+	Subject string // Matches entries whose actor or resource ID is Subject
+	Limit   int
+	Offset  int
 }
 
 // ─────────────────────────────────────────────────────────────────────────────
@@ -635,6 +991,103 @@ type InitialAccessToken struct {
 	ExpiresAt time.Time  `json:"expires_at" bson:"expires_at"`
 	Used      bool       `json:"used" bson:"used"`
 	UsedAt    *time.Time `json:"used_at,omitempty" bson:"used_at,omitempty"`
-	UsedBy    string     `json:"used_by,omitempty" bson:"used_by,omitempty"` // Client ID
+	UsedBy    string     `json:"used_by,omitempty" bson:"used_by,omitempty"`     // Client ID
+	UseCount  int        `json:"use_count,omitempty" bson:"use_count,omitempty"` // Clients registered with this token
 	CreatedAt time.Time  `json:"created_at" bson:"created_at"`
 }
+
+// APIKey is a long-lived credential of a service account, exchanged at the token
+// endpoint for short-lived access tokens. Only a SHA-256 hash of the key is stored.
+type APIKey struct {
+	ID         string     `json:"id" bson:"_id"`
+	UserID     string     `json:"user_id" bson:"user_id"` // Owning service account
+	Name       string     `json:"name" bson:"name"`
+	KeyHash    string     `json:"key_hash" bson:"key_hash"`
+	Scope      string     `json:"scope" bson:"scope"`                                   // Most a token exchanged for the key may carry
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`     // Nil = never expires
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"` // Last exchange at the token endpoint
+	CreatedBy  string     `json:"created_by,omitempty" bson:"created_by,omitempty"`     // Admin who issued the key
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+}
+
+// IsExpired returns true once the key is past its expiry
+func (k *APIKey) IsExpired() bool {
+	return k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt)
+}
+
+// Client instance binding modes
+const (
+	InstanceBindingOptional = "optional"
+	InstanceBindingRequired = "required"
+)
+
+// ClientInstance is one install of a public native client. The install proves it
+// is the same instance with its secret, so a refresh token bound to the instance
+// cannot be used from another device.
+type ClientInstance struct {
+	ID              string     `json:"id" bson:"_id"`
+	ClientID        string     `json:"client_id" bson:"client_id"`
+	SecretHash      string     `json:"secret_hash" bson:"secret_hash"`
+	AttestationType string     `json:"attestation_type,omitempty" bson:"attestation_type,omitempty"` // Attestation presented at registration, if any
+	AttestedAppID   string     `json:"attested_app_id,omitempty" bson:"attested_app_id,omitempty"`   // Package name or bundle ID vouched for by the attestation
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at" bson:"created_at"`
+}
+
+// Device authorization states
+const (
+	DeviceAuthorizationPending  = "pending"
+	DeviceAuthorizationApproved = "approved"
+	DeviceAuthorizationDenied   = "denied"
+)
+
+// DeviceAuthorization is a device authorization request (RFC 8628). The device
+// polls the token endpoint with DeviceCode while the user enters UserCode on the
+// verification page from another browser.
+type DeviceAuthorization struct {
+	DeviceCode   string     `json:"device_code" bson:"_id"`
+	UserCode     string     `json:"user_code" bson:"user_code"` // Normalized: no separators, upper case
+	ClientID     string     `json:"client_id" bson:"client_id"`
+	Scope        string     `json:"scope" bson:"scope"`
+	Status       string     `json:"status" bson:"status"`
+	UserID       string     `json:"user_id,omitempty" bson:"user_id,omitempty"` // Set once the user has decided
+	AuthTime     *time.Time `json:"auth_time,omitempty" bson:"auth_time,omitempty"`
+	ACR          string     `json:"acr,omitempty" bson:"acr,omitempty"`
+	AMR          []string   `json:"amr,omitempty" bson:"amr,omitempty"`
+	Interval     int        `json:"interval" bson:"interval"` // Seconds the device must wait between polls
+	LastPolledAt *time.Time `json:"last_polled_at,omitempty" bson:"last_polled_at,omitempty"`
+	ExpiresAt    time.Time  `json:"expires_at" bson:"expires_at"`
+	CreatedAt    time.Time  `json:"created_at" bson:"created_at"`
+}
+
+// IsExpired returns true once the device code can no longer be used
+func (d *DeviceAuthorization) IsExpired() bool {
+	return time.Now().After(d.ExpiresAt)
+}
+
+// ErrorReport records the context of a server error or security rejection under
+// a short reference that is handed to the client in error_uri, so support can
+// find the failure an integrator reports
+type ErrorReport struct {
+	Reference        string    `json:"reference" bson:"_id"`
+	RequestID        string    `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	Status           int       `json:"status" bson:"status"` // HTTP status, 302 for authorization redirects
+	Error            string    `json:"error" bson:"error"`
+	ErrorDescription string    `json:"error_description,omitempty" bson:"error_description,omitempty"`
+	Method           string    `json:"method" bson:"method"`
+	Path             string    `json:"path" bson:"path"`
+	ClientID         string    `json:"client_id,omitempty" bson:"client_id,omitempty"`
+	RemoteIP         string    `json:"remote_ip" bson:"remote_ip"`
+	UserAgent        string    `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+	ExpiresAt        time.Time `json:"expires_at" bson:"expires_at"`
+	CreatedAt        time.Time `json:"created_at" bson:"created_at"`
+}
+
+// UsedJTI records a client assertion JWT ID that has already been presented.
+// Entries are kept until the assertion expires so that replays can be rejected.
+type UsedJTI struct {
+	ID        string    `json:"id" bson:"_id"` // clientID:jti
+	ClientID  string    `json:"client_id" bson:"client_id"`
+	JTI       string    `json:"jti" bson:"jti"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
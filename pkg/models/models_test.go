@@ -1,6 +1,8 @@
 package models
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 )
@@ -43,6 +45,36 @@ func TestUserRoles(t *testing.T) {
 	}
 }
 
+func TestUserCanAuthenticate(t *testing.T) {
+	user := NewRegularUser("user", "user@example.com", "hashed_password")
+	if !user.CanAuthenticate() {
+		t.Error("New user should be able to authenticate")
+	}
+
+	user.Disabled = true
+	if user.CanAuthenticate() {
+		t.Error("Disabled user should not be able to authenticate")
+	}
+	user.Disabled = false
+
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+	user.LockedUntil = &past
+	if !user.CanAuthenticate() {
+		t.Error("Expired lock should no longer apply")
+	}
+	user.LockedUntil = &future
+	if user.CanAuthenticate() {
+		t.Error("Locked user should not be able to authenticate")
+	}
+	user.LockedUntil = nil
+
+	user.DeletedAt = &past
+	if user.CanAuthenticate() {
+		t.Error("Soft-deleted user should not be able to authenticate")
+	}
+}
+
 func TestAuthorizationCodeExpiry(t *testing.T) {
 	code := &AuthorizationCode{
 		Code:      "test-code",
@@ -92,3 +124,116 @@ func TestNewClient(t *testing.T) {
 		t.Errorf("Expected 1 redirect URI, got %d", len(client.RedirectURIs))
 	}
 }
+
+func TestUpgradeAdminUIClient(t *testing.T) {
+	legacy := &Client{
+		ID:            "admin-ui",
+		RedirectURIs:  []string{"https://auth.example.com/admin/callback"},
+		GrantTypes:    []string{"implicit"},
+		ResponseTypes: []string{ResponseTypeIDToken, ResponseTypeTokenIDToken},
+	}
+	if !UpgradeAdminUIClient(legacy) {
+		t.Fatal("Implicit admin UI client should be upgraded")
+	}
+	if !legacy.RequirePKCE || len(legacy.ResponseTypes) != 1 || legacy.ResponseTypes[0] != "code" {
+		t.Errorf("Expected code flow with PKCE, got response types %v", legacy.ResponseTypes)
+	}
+	if legacy.RedirectURIs[0] != "https://auth.example.com/admin/callback" {
+		t.Errorf("Redirect URIs should be kept, got %v", legacy.RedirectURIs)
+	}
+
+	if UpgradeAdminUIClient(NewAdminUIClient("https://auth.example.com")) {
+		t.Error("Current admin UI client should be left alone")
+	}
+}
+
+func TestClientLocalizedMetadataRoundTrip(t *testing.T) {
+	client := &Client{
+		ID:                  "client-1",
+		ClientName:          "My App",
+		ClientNameLocalized: map[string]string{"ja-Jpan-JP": "マイアプリ"},
+		TosURILocalized:     map[string]string{"de": "https://example.com/de/tos"},
+	}
+
+	data, err := json.Marshal(client)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"client_name#ja-Jpan-JP":"マイアプリ"`) {
+		t.Errorf("Expected language-tagged client_name in %s", data)
+	}
+
+	var decoded Client
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded.ClientNameLocalized["ja-Jpan-JP"] != "マイアプリ" {
+		t.Errorf("Expected localized client name to round-trip, got %v", decoded.ClientNameLocalized)
+	}
+	if decoded.TosURILocalized["de"] != "https://example.com/de/tos" {
+		t.Errorf("Expected localized tos_uri to round-trip, got %v", decoded.TosURILocalized)
+	}
+
+	var req ClientRegistrationRequest
+	if err := json.Unmarshal([]byte(`{"client_name#bad tag": "x"}`), &req); err == nil {
+		t.Error("Expected invalid language tag to be rejected")
+	}
+}
+
+func TestClientRegistrationResponseJSON(t *testing.T) {
+	resp := ClientRegistrationResponse{
+		Client:                  Client{ID: "client-1", ClientNameLocalized: map[string]string{"fr": "Mon App"}},
+		RegistrationAccessToken: "rat",
+		RegistrationClientURI:   "https://example.com/register/client-1",
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var decoded ClientRegistrationResponse
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded.RegistrationAccessToken != "rat" || decoded.RegistrationClientURI == "" {
+		t.Errorf("Expected registration fields to survive, got %s", data)
+	}
+	if decoded.ClientNameLocalized["fr"] != "Mon App" {
+		t.Errorf("Expected localized name to survive, got %s", data)
+	}
+}
+
+func TestIsLanguageTag(t *testing.T) {
+	for _, tag := range []string{"en", "ja-JP", "ja-Jpan-JP", "zh-Hant-TW", "es-419"} {
+		if !IsLanguageTag(tag) {
+			t.Errorf("Expected %q to be a valid language tag", tag)
+		}
+	}
+	for _, tag := range []string{"", "1en", "en_US", "en--US", "toolongsubtag"} {
+		if IsLanguageTag(tag) {
+			t.Errorf("Expected %q to be rejected", tag)
+		}
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		email        string
+		stripPlusTag bool
+		want         string
+	}{
+		{" Alice@Example.COM ", false, "alice@example.com"},
+		{"Alice+News@Example.com", false, "alice+news@example.com"},
+		{"Alice+News@Example.com", true, "alice@example.com"},
+		{"+tag@example.com", true, "+tag@example.com"},
+		{"a+b+c@example.com", true, "a@example.com"},
+		{"no-at-sign+tag", true, "no-at-sign+tag"},
+		{"", true, ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeEmail(tt.email, tt.stripPlusTag); got != tt.want {
+			t.Errorf("NormalizeEmail(%q, %v) = %q, want %q", tt.email, tt.stripPlusTag, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+// Package outbound makes the HTTP requests the server initiates to other hosts.
+// Every client it returns enforces the configured host allow and deny lists, a
+// cap on request time and response size, and, for URLs supplied by clients,
+// refuses to connect to private, loopback and link-local addresses so that
+// registration metadata can't be used to reach internal services.
+package outbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+)
+
+const (
+	defaultTimeout          = 30 * time.Second
+	defaultMaxResponseBytes = 1 << 20
+	dialTimeout             = 10 * time.Second
+	maxRedirects            = 5
+)
+
+// Kind says who chose the URL of a request
+type Kind int
+
+const (
+	// Untrusted requests go to URLs supplied by clients or relying parties, such as
+	// jwks_uri, sector_identifier_uri and federation entity URLs
+	Untrusted Kind = iota
+	// Configured requests go to endpoints set by the operator, such as event
+	// collectors, policy engines and attribute providers
+	Configured
+)
+
+var (
+	// ErrHostNotAllowed is returned for hosts on the deny list, or missing from the
+	// allow list
+	ErrHostNotAllowed = errors.New("host is not allowed")
+	// ErrPrivateAddress is returned for hosts resolving to private, loopback or
+	// link-local addresses
+	ErrPrivateAddress = errors.New("address is not public")
+	// ErrResponseTooLarge is returned while reading a body over the size limit
+	ErrResponseTooLarge = errors.New("response is too large")
+)
+
+// settings is the parsed outbound_http configuration
+type settings struct {
+	allowed      []string
+	denied       []string
+	privateHosts []string
+	blockPrivate bool
+	timeout      time.Duration
+	maxBytes     int64
+}
+
+var current atomic.Pointer[settings]
+
+func init() {
+	Configure(configstore.OutboundHTTPConfig{})
+}
+
+// Configure applies the outbound_http configuration to all clients, including
+// those already created
+func Configure(cfg configstore.OutboundHTTPConfig) {
+	s := &settings{
+		allowed:      cfg.AllowedHosts,
+		denied:       cfg.DeniedHosts,
+		privateHosts: cfg.PrivateNetworkHosts,
+		blockPrivate: cfg.BlockPrivateEndpoints,
+		timeout:      time.Duration(cfg.TimeoutSeconds) * time.Second,
+		maxBytes:     cfg.MaxResponseBytes,
+	}
+	if s.timeout <= 0 {
+		s.timeout = defaultTimeout
+	}
+	if s.maxBytes <= 0 {
+		s.maxBytes = defaultMaxResponseBytes
+	}
+	current.Store(s)
+	// Open connections were checked against the previous settings
+	for _, t := range transports {
+		t.base.CloseIdleConnections()
+	}
+}
+
+// metrics counts outbound requests since startup
+var metrics struct {
+	requests atomic.Int64
+	blocked  atomic.Int64 // Refused by the host lists or the private address check
+	tooLarge atomic.Int64
+	errors   atomic.Int64 // Failed requests, including blocked ones
+}
+
+// Stats returns the outbound request counters since startup
+func Stats() map[string]int64 {
+	return map[string]int64{
+		"requests":  metrics.requests.Load(),
+		"blocked":   metrics.blocked.Load(),
+		"too_large": metrics.tooLarge.Load(),
+		"errors":    metrics.errors.Load(),
+	}
+}
+
+// transports are shared by all clients of a kind, so they share idle connections
+var transports = [...]*transport{
+	Untrusted:  newTransport(Untrusted),
+	Configured: newTransport(Configured),
+}
+
+// NewClient returns a client for requests of the given kind. timeout bounds each
+// request, within the configured maximum; zero means the maximum.
+func NewClient(kind Kind, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transports[kind],
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			if via[0].URL.Scheme == "https" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect from https to %s not followed", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+func newTransport(kind Kind) *transport {
+	base := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialContext(kind),
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   dialTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+	if kind == Untrusted {
+		// A proxy would be dialed instead of the host, hiding its address
+		base.Proxy = nil
+	}
+	return &transport{kind: kind, base: base}
+}
+
+// transport checks each request, redirects included, against the host lists and
+// applies the configured time and size limits
+type transport struct {
+	kind Kind
+	base *http.Transport
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	s := current.Load()
+	metrics.requests.Add(1)
+	if !hostAllowed(s, t.kind, req.URL.Hostname()) {
+		metrics.blocked.Add(1)
+		metrics.errors.Add(1)
+		return nil, fmt.Errorf("%s: %w", req.URL.Hostname(), ErrHostNotAllowed)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), s.timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		metrics.errors.Add(1)
+		if errors.Is(err, ErrPrivateAddress) {
+			metrics.blocked.Add(1)
+		}
+		return nil, err
+	}
+	if resp.ContentLength > s.maxBytes {
+		cancel()
+		_ = resp.Body.Close()
+		metrics.tooLarge.Add(1)
+		metrics.errors.Add(1)
+		return nil, ErrResponseTooLarge
+	}
+	resp.Body = &limitedBody{body: resp.Body, remaining: s.maxBytes, cancel: cancel}
+	return resp, nil
+}
+
+// hostAllowed applies the deny list to every request, and the allow list to
+// requests for URLs supplied by clients
+func hostAllowed(s *settings, kind Kind, host string) bool {
+	if matchesAny(s.denied, host) {
+		return false
+	}
+	return kind != Untrusted || len(s.allowed) == 0 || matchesAny(s.allowed, host)
+}
+
+// dialContext resolves the host and connects, refusing non-public addresses
+// unless the kind and configuration permit them. The check runs on the address
+// actually dialed, so DNS answers that change between lookups can't point a
+// request at internal services.
+func dialContext(kind Kind) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		s := current.Load()
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		blockPrivate := s.blockPrivate
+		if kind == Untrusted {
+			blockPrivate = !matchesAny(s.privateHosts, host)
+		}
+		dialer := &net.Dialer{Timeout: dialTimeout}
+		if blockPrivate {
+			dialer.Control = func(_, address string, _ syscall.RawConn) error {
+				ipHost, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(ipHost); ip == nil || !isPublicIP(ip) {
+					return fmt.Errorf("%s: %w", host, ErrPrivateAddress)
+				}
+				return nil
+			}
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598)
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isPublicIP reports whether ip is a globally routable unicast address
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() && !sharedAddressSpace.Contains(ip)
+}
+
+// matchesAny reports whether host matches one of patterns, which may start with
+// "*." to match any subdomain
+func matchesAny(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(strings.ToLower(host), "."+strings.ToLower(suffix)) {
+				return true
+			}
+		} else if strings.EqualFold(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitedBody fails reads past the response size limit instead of truncating,
+// and releases the request deadline when closed
+type limitedBody struct {
+	body      io.ReadCloser
+	remaining int64
+	cancel    context.CancelFunc
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// Check whether the body really continues past the limit
+		var probe [1]byte
+		n, err := b.body.Read(probe[:])
+		if n > 0 {
+			metrics.tooLarge.Add(1)
+			return 0, ErrResponseTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.body.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	b.cancel()
+	return b.body.Close()
+}
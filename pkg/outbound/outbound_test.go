@@ -0,0 +1,90 @@
+package outbound
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+)
+
+func newTestServer(t *testing.T, body string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { Configure(configstore.OutboundHTTPConfig{}) })
+	return server
+}
+
+func get(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestPrivateAddresses(t *testing.T) {
+	server := newTestServer(t, "ok")
+
+	blocked := Stats()["blocked"]
+	_, err := get(NewClient(Untrusted, 0), server.URL)
+	assert.ErrorIs(t, err, ErrPrivateAddress)
+	assert.Equal(t, blocked+1, Stats()["blocked"])
+
+	// Operator endpoints are often internal services
+	body, err := get(NewClient(Configured, 0), server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", body)
+
+	Configure(configstore.OutboundHTTPConfig{BlockPrivateEndpoints: true})
+	_, err = get(NewClient(Configured, 0), server.URL)
+	assert.ErrorIs(t, err, ErrPrivateAddress)
+
+	Configure(configstore.OutboundHTTPConfig{PrivateNetworkHosts: []string{"127.0.0.1"}})
+	body, err = get(NewClient(Untrusted, 0), server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", body)
+}
+
+func TestHostLists(t *testing.T) {
+	server := newTestServer(t, "ok")
+	Configure(configstore.OutboundHTTPConfig{
+		PrivateNetworkHosts: []string{"127.0.0.1"},
+		AllowedHosts:        []string{"*.example.com"},
+	})
+	_, err := get(NewClient(Untrusted, 0), server.URL)
+	assert.ErrorIs(t, err, ErrHostNotAllowed)
+	_, err = get(NewClient(Configured, 0), server.URL)
+	assert.NoError(t, err, "the allow list only restricts URLs supplied by clients")
+
+	Configure(configstore.OutboundHTTPConfig{DeniedHosts: []string{"127.0.0.1"}})
+	_, err = get(NewClient(Configured, 0), server.URL)
+	assert.ErrorIs(t, err, ErrHostNotAllowed)
+
+	assert.True(t, matchesAny([]string{"*.example.com"}, "jwks.EXAMPLE.com"))
+	assert.False(t, matchesAny([]string{"*.example.com"}, "example.com"))
+	assert.False(t, matchesAny([]string{"example.com"}, "evil-example.com"))
+}
+
+func TestResponseSizeLimit(t *testing.T) {
+	server := newTestServer(t, strings.Repeat("x", 100))
+	client := NewClient(Configured, 0)
+
+	Configure(configstore.OutboundHTTPConfig{MaxResponseBytes: 100})
+	body, err := get(client, server.URL)
+	require.NoError(t, err)
+	assert.Len(t, body, 100)
+
+	Configure(configstore.OutboundHTTPConfig{MaxResponseBytes: 99})
+	_, err = get(client, server.URL)
+	assert.ErrorIs(t, err, ErrResponseTooLarge)
+}
@@ -0,0 +1,471 @@
+// Package qrcode renders short payloads, such as provisioning deep links, as QR
+// codes (ISO/IEC 18004). It supports byte mode at error correction level M for
+// versions 1 to 20, which holds up to 666 bytes.
+package qrcode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+const (
+	maxVersion = 20
+	quietZone  = 4 // Modules of light border required around the symbol
+)
+
+// blockLayout describes the error correction blocks of a version at level M
+type blockLayout struct {
+	ecPerBlock int
+	g1Blocks   int
+	g1Data     int // Data codewords per block in group 1; group 2 blocks hold one more
+	g2Blocks   int
+}
+
+// layoutsM lists the block structure of versions 1-20 at error correction level M
+var layoutsM = [maxVersion + 1]blockLayout{
+	{},
+	{10, 1, 16, 0}, {16, 1, 28, 0}, {26, 1, 44, 0}, {18, 2, 32, 0}, {24, 2, 43, 0},
+	{16, 4, 27, 0}, {18, 4, 31, 0}, {22, 2, 38, 2}, {22, 3, 36, 2}, {26, 4, 43, 1},
+	{30, 1, 50, 4}, {22, 6, 36, 2}, {22, 8, 37, 1}, {24, 4, 40, 5}, {24, 5, 41, 5},
+	{28, 7, 45, 3}, {28, 10, 46, 1}, {26, 9, 43, 4}, {26, 3, 44, 11}, {26, 3, 41, 13},
+}
+
+// alignmentPositions lists the row/column centres of alignment patterns per version
+var alignmentPositions = [maxVersion + 1][]int{
+	{}, {},
+	{6, 18}, {6, 22}, {6, 26}, {6, 30}, {6, 34},
+	{6, 22, 38}, {6, 24, 42}, {6, 26, 46}, {6, 28, 50}, {6, 30, 54}, {6, 32, 58}, {6, 34, 62},
+	{6, 26, 46, 66}, {6, 26, 48, 70}, {6, 26, 50, 74}, {6, 30, 54, 78}, {6, 30, 56, 82}, {6, 30, 58, 86}, {6, 34, 62, 90},
+}
+
+func (l blockLayout) dataCodewords() int {
+	return l.g1Blocks*l.g1Data + l.g2Blocks*(l.g1Data+1)
+}
+
+// Code is an encoded QR code symbol
+type Code struct {
+	Version  int
+	size     int
+	modules  [][]bool // [row][column]; true = dark
+	reserved [][]bool
+}
+
+// Size returns the width of the symbol in modules, without the quiet zone
+func (q *Code) Size() int {
+	return q.size
+}
+
+// Dark reports whether the module at row, col is dark
+func (q *Code) Dark(row, col int) bool {
+	return q.modules[row][col]
+}
+
+// Encode encodes data in the smallest version that holds it
+func Encode(data []byte) (*Code, error) {
+	version := 0
+	for v := 1; v <= maxVersion; v++ {
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= 8*layoutsM[v].dataCodewords() {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("qrcode: %d bytes do not fit in a version %d symbol", len(data), maxVersion)
+	}
+
+	q := &Code{Version: version, size: 17 + 4*version}
+	q.modules = make([][]bool, q.size)
+	q.reserved = make([][]bool, q.size)
+	for i := range q.modules {
+		q.modules[i] = make([]bool, q.size)
+		q.reserved[i] = make([]bool, q.size)
+	}
+
+	q.drawFunctionPatterns()
+	q.drawCodewords(interleave(encodeData(data, version), layoutsM[version]))
+
+	bestMask, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormatBits(mask)
+		if penalty := q.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			bestMask, bestPenalty = mask, penalty
+		}
+		q.applyMask(mask) // XOR again to undo
+	}
+	q.applyMask(bestMask)
+	q.drawFormatBits(bestMask)
+	return q, nil
+}
+
+// PNG renders the symbol with its quiet zone, scale pixels per module
+func (q *Code) PNG(scale int) ([]byte, error) {
+	if scale < 1 {
+		scale = 1
+	}
+	width := (q.size + 2*quietZone) * scale
+	img := image.NewGray(image.Rect(0, 0, width, width))
+	for y := 0; y < width; y++ {
+		for x := 0; x < width; x++ {
+			row, col := y/scale-quietZone, x/scale-quietZone
+			if row >= 0 && row < q.size && col >= 0 && col < q.size && q.modules[row][col] {
+				img.SetGray(x, y, color.Gray{Y: 0})
+			} else {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeData builds the data codewords: byte mode header, payload, terminator and padding
+func encodeData(data []byte, version int) []byte {
+	var bits bitBuffer
+	bits.append(0x4, 4) // Byte mode
+	if version >= 10 {
+		bits.append(len(data), 16)
+	} else {
+		bits.append(len(data), 8)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+
+	capacity := 8 * layoutsM[version].dataCodewords()
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return codewords
+}
+
+// interleave splits data into blocks, adds Reed-Solomon error correction to each
+// and interleaves the codewords of all blocks
+func interleave(data []byte, layout blockLayout) []byte {
+	divisor := rsDivisor(layout.ecPerBlock)
+	var blocks, ecBlocks [][]byte
+	offset := 0
+	for i := 0; i < layout.g1Blocks+layout.g2Blocks; i++ {
+		length := layout.g1Data
+		if i >= layout.g1Blocks {
+			length++
+		}
+		block := data[offset : offset+length]
+		offset += length
+		blocks = append(blocks, block)
+		ecBlocks = append(ecBlocks, rsRemainder(block, divisor))
+	}
+
+	var result []byte
+	for i := 0; i <= layout.g1Data; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < layout.ecPerBlock; i++ {
+		for _, ec := range ecBlocks {
+			result = append(result, ec[i])
+		}
+	}
+	return result
+}
+
+// setFunction sets a function pattern module and reserves it from data placement
+func (q *Code) setFunction(row, col int, dark bool) {
+	q.modules[row][col] = dark
+	q.reserved[row][col] = true
+}
+
+func (q *Code) drawFunctionPatterns() {
+	for i := 0; i < q.size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns with their separators
+	for _, centre := range [][2]int{{3, 3}, {3, q.size - 4}, {q.size - 4, 3}} {
+		for dr := -4; dr <= 4; dr++ {
+			for dc := -4; dc <= 4; dc++ {
+				row, col := centre[0]+dr, centre[1]+dc
+				if row < 0 || row >= q.size || col < 0 || col >= q.size {
+					continue
+				}
+				dist := max(abs(dr), abs(dc))
+				q.setFunction(row, col, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	positions := alignmentPositions[q.Version]
+	last := len(positions) - 1
+	for i, row := range positions {
+		for j, col := range positions {
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue // Overlaps a finder pattern
+			}
+			for dr := -2; dr <= 2; dr++ {
+				for dc := -2; dc <= 2; dc++ {
+					q.setFunction(row+dr, col+dc, max(abs(dr), abs(dc)) != 1)
+				}
+			}
+		}
+	}
+
+	q.drawFormatBits(0) // Reserves the format areas; redrawn once the mask is chosen
+	q.drawVersionBits()
+}
+
+// drawFormatBits writes both copies of the format information (level M, mask)
+func (q *Code) drawFormatBits(mask int) {
+	data := mask // Level M is 00, so the level bits in front of the mask are zero
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		q.setFunction(i, 8, bit(i))
+	}
+	q.setFunction(7, 8, bit(6))
+	q.setFunction(8, 8, bit(7))
+	q.setFunction(8, 7, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(8, 14-i, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.setFunction(8, q.size-1-i, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(q.size-15+i, 8, bit(i))
+	}
+	q.setFunction(q.size-8, 8, true) // Dark module
+}
+
+// drawVersionBits writes both copies of the version information for version 7 and up
+func (q *Code) drawVersionBits() {
+	if q.Version < 7 {
+		return
+	}
+	rem := q.Version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1F25
+	}
+	bits := q.Version<<12 | rem
+	for i := 0; i < 18; i++ {
+		dark := bits>>i&1 != 0
+		a, b := q.size-11+i%3, i/3
+		q.setFunction(b, a, dark)
+		q.setFunction(a, b, dark)
+	}
+}
+
+// drawCodewords places the codewords in the zigzag order of the standard
+func (q *Code) drawCodewords(codewords []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // Skip the vertical timing pattern
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				col := right - j
+				row := vert
+				if (right+1)&2 == 0 {
+					row = q.size - 1 - vert // Upward
+				}
+				if !q.reserved[row][col] && i < len(codewords)*8 {
+					q.modules[row][col] = codewords[i/8]>>(7-i%8)&1 != 0
+					i++
+				}
+				// Remainder bits stay light
+			}
+		}
+	}
+}
+
+// applyMask XORs the data modules with a mask pattern; applying it twice undoes it
+func (q *Code) applyMask(mask int) {
+	for row := 0; row < q.size; row++ {
+		for col := 0; col < q.size; col++ {
+			if q.reserved[row][col] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (row+col)%2 == 0
+			case 1:
+				invert = row%2 == 0
+			case 2:
+				invert = col%3 == 0
+			case 3:
+				invert = (row+col)%3 == 0
+			case 4:
+				invert = (row/2+col/3)%2 == 0
+			case 5:
+				invert = row*col%2+row*col%3 == 0
+			case 6:
+				invert = (row*col%2+row*col%3)%2 == 0
+			case 7:
+				invert = ((row+col)%2+row*col%3)%2 == 0
+			}
+			if invert {
+				q.modules[row][col] = !q.modules[row][col]
+			}
+		}
+	}
+}
+
+// penalty scores the symbol by the four rules of the standard; lower is easier to read
+func (q *Code) penalty() int {
+	penalty := 0
+	finderLike := [][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+	line := make([]bool, q.size)
+	dark := 0
+	for horizontal := 0; horizontal < 2; horizontal++ {
+		for i := 0; i < q.size; i++ {
+			for j := 0; j < q.size; j++ {
+				if horizontal == 0 {
+					line[j] = q.modules[i][j]
+				} else {
+					line[j] = q.modules[j][i]
+				}
+			}
+
+			// Rule 1: runs of five or more modules of one colour
+			run := 1
+			for j := 1; j <= q.size; j++ {
+				if j < q.size && line[j] == line[j-1] {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += 3 + run - 5
+				}
+				run = 1
+			}
+
+			// Rule 3: patterns resembling a finder
+			for j := 0; j+11 <= q.size; j++ {
+				for _, pattern := range finderLike {
+					match := true
+					for k, v := range pattern {
+						if line[j+k] != v {
+							match = false
+							break
+						}
+					}
+					if match {
+						penalty += 40
+					}
+				}
+			}
+		}
+	}
+
+	for row := 0; row < q.size; row++ {
+		for col := 0; col < q.size; col++ {
+			if q.modules[row][col] {
+				dark++
+			}
+			// Rule 2: 2x2 blocks of one colour
+			if row+1 < q.size && col+1 < q.size {
+				c := q.modules[row][col]
+				if q.modules[row+1][col] == c && q.modules[row][col+1] == c && q.modules[row+1][col+1] == c {
+					penalty += 3
+				}
+			}
+		}
+	}
+
+	// Rule 4: deviation of the dark share from 50%, in steps of 5%
+	total := q.size * q.size
+	k := (abs(dark*20-total*10) + total - 1) / total
+	penalty += max(k-1, 0) * 10
+	return penalty
+}
+
+// bitBuffer accumulates bits, most significant first
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, value>>i&1 != 0)
+	}
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of the given degree,
+// without its leading term
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords of data
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMultiply(d, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,82 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// Data codewords of "HELLO WORLD" at version 1-M and their known error correction
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("rsRemainder() = %v, want %v", got, want)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	for _, tc := range []struct {
+		length  int
+		version int
+	}{
+		{14, 1}, {15, 2}, {180, 9}, {213, 10}, {666, 20},
+	} {
+		q, err := Encode([]byte(strings.Repeat("a", tc.length)))
+		if err != nil {
+			t.Fatalf("Encode(%d bytes): %v", tc.length, err)
+		}
+		if q.Version != tc.version || q.Size() != 17+4*tc.version {
+			t.Errorf("Encode(%d bytes) = version %d, size %d; want version %d", tc.length, q.Version, q.Size(), tc.version)
+		}
+
+		// Both copies of the format information agree
+		var first, second int
+		for i := 0; i <= 5; i++ {
+			first |= bit(q.Dark(i, 8)) << i
+		}
+		first |= bit(q.Dark(7, 8))<<6 | bit(q.Dark(8, 8))<<7 | bit(q.Dark(8, 7))<<8
+		for i := 9; i < 15; i++ {
+			first |= bit(q.Dark(8, 14-i)) << i
+		}
+		for i := 0; i < 8; i++ {
+			second |= bit(q.Dark(8, q.Size()-1-i)) << i
+		}
+		for i := 8; i < 15; i++ {
+			second |= bit(q.Dark(q.Size()-15+i, 8)) << i
+		}
+		if first != second || (first^0x5412)>>13 != 0 {
+			t.Errorf("format information %015b / %015b is inconsistent or not level M", first, second)
+		}
+	}
+
+	if _, err := Encode(make([]byte, 667)); err == nil {
+		t.Error("oversized payload was accepted")
+	}
+}
+
+func TestPNG(t *testing.T) {
+	q, err := Encode([]byte("https://example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := q.PNG(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width := img.Bounds().Dx(); width != (q.Size()+8)*4 {
+		t.Errorf("width = %d", width)
+	}
+}
+
+func bit(dark bool) int {
+	if dark {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,202 @@
+// Package saml validates SAML 2.0 bearer assertions presented as authorization
+// grants at the token endpoint (RFC 7522).
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	namespaceAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+
+	// ConfirmationMethodBearer is the only subject confirmation method accepted
+	ConfirmationMethodBearer = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+
+	// maxAssertionSize bounds the decoded assertion to keep parsing cheap
+	maxAssertionSize = 64 << 10
+)
+
+// Assertion is a parsed SAML 2.0 assertion. Its fields must not be trusted until
+// VerifySignature and Validate have succeeded.
+type Assertion struct {
+	ID           string
+	Issuer       string
+	NameID       string
+	NameIDFormat string
+	Attributes   map[string][]string
+	ExpiresAt    time.Time // Earliest NotOnOrAfter of the conditions and the bearer confirmation
+
+	root *element
+}
+
+// ParseAssertion decodes a base64url-encoded <saml:Assertion> as sent in the
+// assertion parameter. Padded and standard base64 are accepted too.
+func ParseAssertion(encoded string) (*Assertion, error) {
+	encoded = strings.TrimSpace(encoded)
+	var data []byte
+	var err error
+	for _, encoding := range []*base64.Encoding{base64.RawURLEncoding, base64.URLEncoding, base64.StdEncoding} {
+		if data, err = encoding.DecodeString(encoded); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("assertion is not base64url encoded")
+	}
+	if len(data) > maxAssertionSize {
+		return nil, fmt.Errorf("assertion is too large")
+	}
+
+	root, err := parseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	if !root.is(namespaceAssertion, "Assertion") {
+		return nil, fmt.Errorf("document is not a SAML 2.0 assertion")
+	}
+	if root.attr("Version") != "2.0" {
+		return nil, fmt.Errorf("unsupported SAML version")
+	}
+
+	a := &Assertion{ID: root.attr("ID"), Attributes: map[string][]string{}, root: root}
+	if issuer := root.child(namespaceAssertion, "Issuer"); issuer != nil {
+		a.Issuer = issuer.text()
+	}
+	if subject := root.child(namespaceAssertion, "Subject"); subject != nil {
+		if nameID := subject.child(namespaceAssertion, "NameID"); nameID != nil {
+			a.NameID = nameID.text()
+			a.NameIDFormat = nameID.attr("Format")
+		}
+	}
+	for _, statement := range root.childElements(namespaceAssertion, "AttributeStatement") {
+		for _, attribute := range statement.childElements(namespaceAssertion, "Attribute") {
+			name := attribute.attr("Name")
+			for _, value := range attribute.childElements(namespaceAssertion, "AttributeValue") {
+				a.Attributes[name] = append(a.Attributes[name], value.text())
+			}
+		}
+	}
+	if a.ID == "" || a.Issuer == "" || a.NameID == "" {
+		return nil, fmt.Errorf("assertion must have an ID, an Issuer and a Subject NameID")
+	}
+	return a, nil
+}
+
+// VerifySignature checks that the assertion is signed by one of certs
+func (a *Assertion) VerifySignature(certs []*x509.Certificate) error {
+	return verifyEnvelopedSignature(a.root, certs)
+}
+
+// Validate applies the processing rules of RFC 7522 §3: the assertion must be
+// within its validity period, be restricted to one of audiences, and carry a
+// bearer subject confirmation for recipient that has not expired.
+func (a *Assertion) Validate(recipient string, audiences []string, now time.Time, skew time.Duration) error {
+	conditions := a.root.child(namespaceAssertion, "Conditions")
+	if conditions == nil {
+		return fmt.Errorf("assertion has no Conditions")
+	}
+	notBefore, err := parseTime(conditions.attr("NotBefore"))
+	if err != nil {
+		return err
+	}
+	if !notBefore.IsZero() && now.Add(skew).Before(notBefore) {
+		return fmt.Errorf("assertion is not yet valid")
+	}
+	expiresAt, err := parseTime(conditions.attr("NotOnOrAfter"))
+	if err != nil {
+		return err
+	}
+
+	restrictions := conditions.childElements(namespaceAssertion, "AudienceRestriction")
+	if len(restrictions) == 0 {
+		return fmt.Errorf("assertion has no AudienceRestriction")
+	}
+	for _, restriction := range restrictions {
+		if !restrictedTo(restriction, audiences) {
+			return fmt.Errorf("assertion audience does not match this server")
+		}
+	}
+
+	confirmed := false
+	for _, confirmation := range a.root.child(namespaceAssertion, "Subject").childElements(namespaceAssertion, "SubjectConfirmation") {
+		if confirmation.attr("Method") != ConfirmationMethodBearer {
+			continue
+		}
+		data := confirmation.child(namespaceAssertion, "SubjectConfirmationData")
+		if data == nil || data.attr("Recipient") != recipient {
+			continue
+		}
+		confirmationExpiry, err := parseTime(data.attr("NotOnOrAfter"))
+		if err != nil || confirmationExpiry.IsZero() || !now.Add(-skew).Before(confirmationExpiry) {
+			continue
+		}
+		if expiresAt.IsZero() || confirmationExpiry.Before(expiresAt) {
+			expiresAt = confirmationExpiry
+		}
+		confirmed = true
+		break
+	}
+	if !confirmed {
+		return fmt.Errorf("assertion has no unexpired bearer confirmation for the token endpoint")
+	}
+	if !now.Add(-skew).Before(expiresAt) {
+		return fmt.Errorf("assertion has expired")
+	}
+
+	a.ExpiresAt = expiresAt
+	return nil
+}
+
+// restrictedTo reports whether an AudienceRestriction names one of audiences
+func restrictedTo(restriction *element, audiences []string) bool {
+	for _, audience := range restriction.childElements(namespaceAssertion, "Audience") {
+		for _, accepted := range audiences {
+			if audience.text() == accepted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseTime parses an xs:dateTime attribute; an empty value gives the zero time
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q in assertion", value)
+	}
+	return t, nil
+}
+
+// ParseCertificates parses the PEM encoded certificates an issuer signs with
+func ParseCertificates(pemData string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(pemData)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return certs, nil
+}
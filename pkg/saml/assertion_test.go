@@ -0,0 +1,161 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testRecipient = "https://op.example.com/token"
+	testAudience  = "https://op.example.com"
+)
+
+func TestCanonicalize(t *testing.T) {
+	root, err := parseDocument([]byte(`<?xml version="1.0"?>
+<root xmlns="urn:default" xmlns:a="urn:a" xmlns:unused="urn:u"><a:child z="&quot;x&#xA;" a:attr="1" b="2">text &amp; more<!-- comment --><empty/></a:child></root>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := root.children[0].(*element)
+
+	got := string(canonicalize(child, nil, nil))
+	want := `<a:child xmlns:a="urn:a" b="2" z="&quot;x&#xA;" a:attr="1">text &amp; more<empty xmlns="urn:default"></empty></a:child>`
+	if got != want {
+		t.Errorf("canonicalize() =\n%s\nwant\n%s", got, want)
+	}
+
+	got = string(canonicalize(child, nil, []string{"unused"}))
+	if !strings.HasPrefix(got, `<a:child xmlns:a="urn:a" xmlns:unused="urn:u" b="2"`) {
+		t.Errorf("inclusive prefixes not rendered: %s", got)
+	}
+}
+
+func TestAssertionSignatureAndValidation(t *testing.T) {
+	key, cert := testSigningCert(t)
+	now := time.Now()
+
+	assertion, err := ParseAssertion(signTestAssertion(t, key, "alice", testRecipient, now.Add(5*time.Minute)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assertion.Issuer != "https://partner.example.com" || assertion.NameID != "alice" {
+		t.Errorf("unexpected assertion %+v", assertion)
+	}
+	if got := assertion.Attributes["mail"]; len(got) != 1 || got[0] != "alice@example.com" {
+		t.Errorf("attributes = %v", assertion.Attributes)
+	}
+	if err := assertion.VerifySignature([]*x509.Certificate{cert}); err != nil {
+		t.Fatalf("VerifySignature() = %v", err)
+	}
+	if err := assertion.Validate(testRecipient, []string{testAudience}, now, 0); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+
+	if err := assertion.Validate(testRecipient, []string{"https://other.example.com"}, now, 0); err == nil {
+		t.Error("assertion for another audience was accepted")
+	}
+	if err := assertion.Validate("https://other.example.com/token", []string{testAudience}, now, 0); err == nil {
+		t.Error("assertion for another recipient was accepted")
+	}
+	if err := assertion.Validate(testRecipient, []string{testAudience}, now.Add(10*time.Minute), 0); err == nil {
+		t.Error("expired assertion was accepted")
+	}
+
+	_, otherCert := testSigningCert(t)
+	if err := assertion.VerifySignature([]*x509.Certificate{otherCert}); err == nil {
+		t.Error("assertion verified with the wrong certificate")
+	}
+
+	// Changing the subject after signing breaks the digest
+	encoded := signTestAssertion(t, key, "alice", testRecipient, now.Add(5*time.Minute))
+	raw, _ := base64.RawURLEncoding.DecodeString(encoded)
+	tampered := strings.Replace(string(raw), "<saml:NameID>alice<", "<saml:NameID>admin<", 1)
+	assertion, err = ParseAssertion(base64.RawURLEncoding.EncodeToString([]byte(tampered)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := assertion.VerifySignature([]*x509.Certificate{cert}); err == nil {
+		t.Error("tampered assertion was accepted")
+	}
+}
+
+func TestParseAssertionRejectsDTD(t *testing.T) {
+	doc := `<!DOCTYPE x [<!ENTITY e "boom">]><saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" Version="2.0"/>`
+	if _, err := ParseAssertion(base64.RawURLEncoding.EncodeToString([]byte(doc))); err == nil {
+		t.Error("assertion with a DTD was accepted")
+	}
+}
+
+func testSigningCert(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "partner"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+// signTestAssertion builds a signed assertion. The assertion and SignedInfo are
+// written in canonical form, so the digest and signature are computed without
+// relying on the canonicalizer under test.
+func signTestAssertion(t *testing.T, key *rsa.PrivateKey, nameID, recipient string, expiresAt time.Time) string {
+	t.Helper()
+	exp := expiresAt.UTC().Format(time.RFC3339)
+	issued := time.Now().UTC().Add(-time.Minute).Format(time.RFC3339)
+	const ds = `xmlns:ds="http://www.w3.org/2000/09/xmldsig#"`
+
+	head := `<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_a1" IssueInstant="` + issued + `" Version="2.0">` +
+		`<saml:Issuer>https://partner.example.com</saml:Issuer>`
+	tail := `<saml:Subject><saml:NameID>` + nameID + `</saml:NameID>` +
+		`<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">` +
+		`<saml:SubjectConfirmationData NotOnOrAfter="` + exp + `" Recipient="` + recipient + `"></saml:SubjectConfirmationData>` +
+		`</saml:SubjectConfirmation></saml:Subject>` +
+		`<saml:Conditions NotBefore="` + issued + `" NotOnOrAfter="` + exp + `">` +
+		`<saml:AudienceRestriction><saml:Audience>` + testAudience + `</saml:Audience></saml:AudienceRestriction></saml:Conditions>` +
+		`<saml:AttributeStatement><saml:Attribute Name="mail"><saml:AttributeValue>alice@example.com</saml:AttributeValue></saml:Attribute></saml:AttributeStatement>` +
+		`</saml:Assertion>`
+	digest := sha256.Sum256([]byte(head + tail))
+
+	signedInfo := `<ds:SignedInfo>` +
+		`<ds:CanonicalizationMethod Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"></ds:CanonicalizationMethod>` +
+		`<ds:SignatureMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"></ds:SignatureMethod>` +
+		`<ds:Reference URI="#_a1"><ds:Transforms>` +
+		`<ds:Transform Algorithm="http://www.w3.org/2000/09/xmldsig#enveloped-signature"></ds:Transform>` +
+		`<ds:Transform Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"></ds:Transform></ds:Transforms>` +
+		`<ds:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"></ds:DigestMethod>` +
+		`<ds:DigestValue>` + base64.StdEncoding.EncodeToString(digest[:]) + `</ds:DigestValue></ds:Reference></ds:SignedInfo>`
+	canonicalSignedInfo := strings.Replace(signedInfo, `<ds:SignedInfo>`, `<ds:SignedInfo `+ds+`>`, 1)
+	hashed := sha256.Sum256([]byte(canonicalSignedInfo))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := fmt.Sprintf(`%s<ds:Signature %s>%s<ds:SignatureValue>%s</ds:SignatureValue></ds:Signature>%s`,
+		head, ds, signedInfo, base64.StdEncoding.EncodeToString(signature), tail)
+	return base64.RawURLEncoding.EncodeToString([]byte(doc))
+}
@@ -0,0 +1,156 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// XML signature algorithm identifiers (https://www.w3.org/TR/xmldsig-core1/).
+// SHA-1 based algorithms are not accepted.
+const (
+	namespaceDSig = "http://www.w3.org/2000/09/xmldsig#"
+
+	algExcC14N           = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algEnvelopedSig      = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	algRSASHA256         = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algRSASHA512         = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	algSHA256            = "http://www.w3.org/2001/04/xmlenc#sha256"
+	algSHA512            = "http://www.w3.org/2001/04/xmlenc#sha512"
+	namespaceExcC14NList = "http://www.w3.org/2001/10/xml-exc-c14n#"
+)
+
+// verifyEnvelopedSignature checks the ds:Signature that is a direct child of e and
+// signs e itself, by reference to its ID attribute, with one of certs. Requiring
+// the signature to cover the very element that is later read rules out signature
+// wrapping attacks.
+func verifyEnvelopedSignature(e *element, certs []*x509.Certificate) error {
+	signatures := e.childElements(namespaceDSig, "Signature")
+	if len(signatures) != 1 {
+		return fmt.Errorf("assertion must carry exactly one signature")
+	}
+	signature := signatures[0]
+
+	signedInfo := signature.child(namespaceDSig, "SignedInfo")
+	if signedInfo == nil {
+		return fmt.Errorf("signature has no SignedInfo")
+	}
+	c14n := signedInfo.child(namespaceDSig, "CanonicalizationMethod")
+	if c14n == nil || c14n.attr("Algorithm") != algExcC14N {
+		return fmt.Errorf("unsupported canonicalization method")
+	}
+	method := signedInfo.child(namespaceDSig, "SignatureMethod")
+	if method == nil {
+		return fmt.Errorf("signature has no SignatureMethod")
+	}
+	var hash crypto.Hash
+	switch method.attr("Algorithm") {
+	case algRSASHA256:
+		hash = crypto.SHA256
+	case algRSASHA512:
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signature method %q", method.attr("Algorithm"))
+	}
+
+	references := signedInfo.childElements(namespaceDSig, "Reference")
+	if len(references) != 1 {
+		return fmt.Errorf("signature must have exactly one reference")
+	}
+	if err := verifyReference(e, signature, references[0]); err != nil {
+		return err
+	}
+
+	signatureValue, err := decodeBase64(signature.child(namespaceDSig, "SignatureValue"))
+	if err != nil {
+		return fmt.Errorf("invalid SignatureValue")
+	}
+	digest := hash.New()
+	digest.Write(canonicalize(signedInfo, nil, inclusivePrefixes(c14n)))
+	for _, cert := range certs {
+		key, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			continue
+		}
+		if rsa.VerifyPKCS1v15(key, hash, digest.Sum(nil), signatureValue) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature verification failed")
+}
+
+// verifyReference checks that the reference points at e, uses only the enveloped
+// signature and exclusive canonicalization transforms, and matches e's digest
+func verifyReference(e, signature, reference *element) error {
+	id := e.attr("ID")
+	if id == "" || reference.attr("URI") != "#"+id {
+		return fmt.Errorf("signature does not reference the assertion")
+	}
+
+	var prefixes []string
+	c14nApplied := false
+	if transforms := reference.child(namespaceDSig, "Transforms"); transforms != nil {
+		for _, transform := range transforms.childElements(namespaceDSig, "Transform") {
+			switch transform.attr("Algorithm") {
+			case algEnvelopedSig:
+			case algExcC14N:
+				c14nApplied = true
+				prefixes = inclusivePrefixes(transform)
+			default:
+				return fmt.Errorf("unsupported transform %q", transform.attr("Algorithm"))
+			}
+		}
+	}
+	if !c14nApplied {
+		return fmt.Errorf("reference must use exclusive canonicalization")
+	}
+
+	method := reference.child(namespaceDSig, "DigestMethod")
+	if method == nil {
+		return fmt.Errorf("reference has no DigestMethod")
+	}
+	canonical := canonicalize(e, signature, prefixes)
+	var computed []byte
+	switch method.attr("Algorithm") {
+	case algSHA256:
+		sum := sha256.Sum256(canonical)
+		computed = sum[:]
+	case algSHA512:
+		sum := sha512.Sum512(canonical)
+		computed = sum[:]
+	default:
+		return fmt.Errorf("unsupported digest method %q", method.attr("Algorithm"))
+	}
+
+	expected, err := decodeBase64(reference.child(namespaceDSig, "DigestValue"))
+	if err != nil {
+		return fmt.Errorf("invalid DigestValue")
+	}
+	if subtle.ConstantTimeCompare(computed, expected) != 1 {
+		return fmt.Errorf("assertion digest does not match; it was modified after signing")
+	}
+	return nil
+}
+
+// inclusivePrefixes returns the InclusiveNamespaces PrefixList of a canonicalization
+// method or transform
+func inclusivePrefixes(method *element) []string {
+	if list := method.child(namespaceExcC14NList, "InclusiveNamespaces"); list != nil {
+		return strings.Fields(list.attr("PrefixList"))
+	}
+	return nil
+}
+
+// decodeBase64 decodes the base64 content of an element, ignoring line breaks
+func decodeBase64(e *element) ([]byte, error) {
+	if e == nil {
+		return nil, fmt.Errorf("missing element")
+	}
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(e.text()), ""))
+}
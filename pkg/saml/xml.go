@@ -0,0 +1,262 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// element is a node of a parsed XML document. Prefixes are kept as written so the
+// element can be canonicalized; namespace URIs are resolved on demand.
+type element struct {
+	prefix   string
+	local    string
+	attrs    []xml.Attr        // Attributes other than namespace declarations; Name.Space holds the prefix
+	ns       map[string]string // Namespace declarations on this element, by prefix ("" = default)
+	children []interface{}     // *element or string
+	parent   *element
+}
+
+// parseDocument parses an XML document into a tree and returns its root element.
+// Documents with a DTD are rejected, so entity expansion cannot be abused.
+func parseDocument(data []byte) (*element, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var root, current *element
+	for {
+		token, err := decoder.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed XML: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if current == nil && root != nil {
+				return nil, fmt.Errorf("malformed XML: multiple root elements")
+			}
+			e := &element{prefix: t.Name.Space, local: t.Name.Local, ns: map[string]string{}, parent: current}
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "xmlns":
+					e.ns[a.Name.Local] = a.Value
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					e.ns[""] = a.Value
+				default:
+					e.attrs = append(e.attrs, a)
+				}
+			}
+			if current == nil {
+				root = e
+			} else {
+				current.children = append(current.children, e)
+			}
+			current = e
+		case xml.EndElement:
+			if current == nil || t.Name.Space != current.prefix || t.Name.Local != current.local {
+				return nil, fmt.Errorf("malformed XML: unexpected end element %s", t.Name.Local)
+			}
+			current = current.parent
+		case xml.CharData:
+			if current != nil {
+				current.children = append(current.children, string(t))
+			} else if len(bytes.TrimSpace(t)) > 0 {
+				return nil, fmt.Errorf("malformed XML: text outside the root element")
+			}
+		case xml.Directive:
+			return nil, fmt.Errorf("XML documents with a DTD are not accepted")
+		}
+	}
+	if root == nil || current != nil {
+		return nil, fmt.Errorf("malformed XML: incomplete document")
+	}
+	return root, nil
+}
+
+// lookupNamespace resolves a prefix in the scope of e
+func (e *element) lookupNamespace(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return xmlNamespace, true
+	}
+	for n := e; n != nil; n = n.parent {
+		if uri, ok := n.ns[prefix]; ok {
+			return uri, true
+		}
+	}
+	return "", false
+}
+
+// namespace returns the namespace URI of the element
+func (e *element) namespace() string {
+	uri, _ := e.lookupNamespace(e.prefix)
+	return uri
+}
+
+// is reports whether the element has the given namespace and local name
+func (e *element) is(namespace, local string) bool {
+	return e.local == local && e.namespace() == namespace
+}
+
+// attr returns the value of an unprefixed attribute
+func (e *element) attr(name string) string {
+	for _, a := range e.attrs {
+		if a.Name.Space == "" && a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// childElements returns the child elements with the given namespace and local name
+func (e *element) childElements(namespace, local string) []*element {
+	var found []*element
+	for _, child := range e.children {
+		if c, ok := child.(*element); ok && c.is(namespace, local) {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+// child returns the first child element with the given namespace and local name
+func (e *element) child(namespace, local string) *element {
+	if found := e.childElements(namespace, local); len(found) > 0 {
+		return found[0]
+	}
+	return nil
+}
+
+// text returns the concatenated character data of the element, trimmed
+func (e *element) text() string {
+	var b strings.Builder
+	for _, child := range e.children {
+		if s, ok := child.(string); ok {
+			b.WriteString(s)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// canonicalize serializes the subtree of e with Exclusive XML Canonicalization
+// without comments (https://www.w3.org/TR/xml-exc-c14n/). The excluded element,
+// if any, is left out, which implements the enveloped signature transform.
+// inclusivePrefixes is the InclusiveNamespaces PrefixList of the transform.
+func canonicalize(e *element, excluded *element, inclusivePrefixes []string) []byte {
+	var buf bytes.Buffer
+	writeCanonical(&buf, e, excluded, inclusivePrefixes, map[string]string{})
+	return buf.Bytes()
+}
+
+func writeCanonical(buf *bytes.Buffer, e *element, excluded *element, inclusive []string, rendered map[string]string) {
+	// Namespaces visibly utilized by the element and its attributes, plus the
+	// inclusive prefixes, are rendered unless an output ancestor already did so
+	utilized := map[string]bool{e.prefix: true}
+	for _, a := range e.attrs {
+		if a.Name.Space != "" {
+			utilized[a.Name.Space] = true
+		}
+	}
+	for _, prefix := range inclusive {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		if _, ok := e.lookupNamespace(prefix); ok {
+			utilized[prefix] = true
+		}
+	}
+	delete(utilized, "xml")
+
+	var declare []string
+	scope := rendered
+	for prefix := range utilized {
+		uri, _ := e.lookupNamespace(prefix)
+		previous, seen := rendered[prefix]
+		if seen && previous == uri || !seen && uri == "" {
+			continue
+		}
+		declare = append(declare, prefix)
+	}
+	sort.Strings(declare)
+	if len(declare) > 0 {
+		scope = make(map[string]string, len(rendered)+len(declare))
+		for k, v := range rendered {
+			scope[k] = v
+		}
+	}
+
+	buf.WriteByte('<')
+	buf.WriteString(qualifiedName(e.prefix, e.local))
+	for _, prefix := range declare {
+		uri, _ := e.lookupNamespace(prefix)
+		scope[prefix] = uri
+		if prefix == "" {
+			buf.WriteString(` xmlns="`)
+		} else {
+			buf.WriteString(" xmlns:" + prefix + `="`)
+		}
+		buf.WriteString(escapeAttr(uri))
+		buf.WriteByte('"')
+	}
+
+	attrs := make([]xml.Attr, len(e.attrs))
+	copy(attrs, e.attrs)
+	attrURI := func(a xml.Attr) string {
+		if a.Name.Space == "" {
+			return ""
+		}
+		uri, _ := e.lookupNamespace(a.Name.Space)
+		return uri
+	}
+	sort.SliceStable(attrs, func(i, k int) bool {
+		ui, uk := attrURI(attrs[i]), attrURI(attrs[k])
+		if ui != uk {
+			return ui < uk
+		}
+		return attrs[i].Name.Local < attrs[k].Name.Local
+	})
+	for _, a := range attrs {
+		buf.WriteByte(' ')
+		buf.WriteString(qualifiedName(a.Name.Space, a.Name.Local))
+		buf.WriteString(`="`)
+		buf.WriteString(escapeAttr(a.Value))
+		buf.WriteByte('"')
+	}
+	buf.WriteByte('>')
+
+	for _, child := range e.children {
+		switch c := child.(type) {
+		case *element:
+			if c != excluded {
+				writeCanonical(buf, c, excluded, inclusive, scope)
+			}
+		case string:
+			buf.WriteString(escapeText(c))
+		}
+	}
+
+	buf.WriteString("</")
+	buf.WriteString(qualifiedName(e.prefix, e.local))
+	buf.WriteByte('>')
+}
+
+func qualifiedName(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeText(s string) string { return textEscaper.Replace(s) }
+func escapeAttr(s string) string { return attrEscaper.Replace(s) }
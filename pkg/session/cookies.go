@@ -0,0 +1,194 @@
+package session
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+const (
+	// CookieKeyAlgorithm is recorded as the algorithm of cookie keys
+	CookieKeyAlgorithm = "A256GCM"
+	// CookieKeyGracePeriod keeps a retired cookie key opening cookies, so users
+	// who come back within it are moved to the new key instead of signed out
+	CookieKeyGracePeriod = 30 * 24 * time.Hour
+	// DefaultCookieKeyRefresh is how long a KeyringCodec caches the stored keys
+	DefaultCookieKeyRefresh = time.Minute
+)
+
+// CookieCodec seals the values of session cookies, for example by encrypting
+// them. Without one, cookies carry the session IDs as they are.
+type CookieCodec interface {
+	// Encode seals value for the cookie called name
+	Encode(name, value string) (string, error)
+	// Decode opens a sealed value. stale reports that it was sealed with a key
+	// that has since been replaced, and should be sealed again.
+	Decode(name, sealed string) (value string, stale bool, err error)
+}
+
+// KeyringCodec seals cookies with the active cookie key kept among the signing
+// keys, and opens them with any cookie key that has not expired. Cookies naming
+// a deleted key no longer open. Keys are cached for the refresh interval.
+type KeyringCodec struct {
+	store   storage.Storage
+	refresh time.Duration
+
+	mu       sync.Mutex
+	loadedAt time.Time
+	activeID string
+	keys     map[string][]byte
+}
+
+// NewKeyringCodec creates a codec using the cookie keys in store
+func NewKeyringCodec(store storage.Storage, refresh time.Duration) *KeyringCodec {
+	if refresh <= 0 {
+		refresh = DefaultCookieKeyRefresh
+	}
+	return &KeyringCodec{store: store, refresh: refresh}
+}
+
+// Encode seals value with the active cookie key
+func (k *KeyringCodec) Encode(name, value string) (string, error) {
+	activeID, keys, err := k.keyring()
+	if err != nil {
+		return "", err
+	}
+	if activeID == "" {
+		return "", fmt.Errorf("no active cookie key")
+	}
+	return crypto.SealCookie(activeID, keys[activeID], name, value)
+}
+
+// Decode opens a cookie sealed with any usable cookie key
+func (k *KeyringCodec) Decode(name, sealed string) (string, bool, error) {
+	kid, ok := crypto.CookieKeyID(sealed)
+	if !ok {
+		return "", false, fmt.Errorf("cookie is not sealed")
+	}
+	activeID, keys, err := k.keyring()
+	if err != nil {
+		return "", false, err
+	}
+	key, ok := keys[kid]
+	if !ok {
+		return "", false, fmt.Errorf("cookie key %s is not usable", kid)
+	}
+	value, err := crypto.OpenCookie(key, name, sealed)
+	if err != nil {
+		return "", false, err
+	}
+	return value, kid != activeID, nil
+}
+
+// Invalidate makes the next cookie reload the keys, after one was rotated or deleted
+func (k *KeyringCodec) Invalidate() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = nil
+}
+
+// keyring returns the active key ID and the usable keys by ID
+func (k *KeyringCodec) keyring() (string, map[string][]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.keys != nil && time.Since(k.loadedAt) < k.refresh {
+		return k.activeID, k.keys, nil
+	}
+
+	stored, err := k.store.GetAllSigningKeys()
+	if err != nil {
+		if k.keys != nil {
+			return k.activeID, k.keys, nil // Keep using the last keys while storage is unavailable
+		}
+		return "", nil, fmt.Errorf("failed to load cookie keys: %w", err)
+	}
+	activeID := ""
+	keys := make(map[string][]byte)
+	for _, key := range stored {
+		if !key.IsCookieKey() || !key.IsValid() {
+			continue
+		}
+		secret, err := base64.StdEncoding.DecodeString(key.PrivateKey)
+		if err != nil || len(secret) != crypto.CookieKeySize {
+			continue
+		}
+		keys[key.KID] = secret
+		if key.IsActive {
+			activeID = key.KID
+		}
+	}
+	k.activeID, k.keys, k.loadedAt = activeID, keys, time.Now()
+	return activeID, keys, nil
+}
+
+// RotateCookieKey generates a new cookie key and retires the current one, which
+// keeps opening cookies for CookieKeyGracePeriod
+func RotateCookieKey(store storage.Storage) (*models.SigningKey, error) {
+	secret, err := crypto.GenerateCookieKey()
+	if err != nil {
+		return nil, err
+	}
+	newKey := &models.SigningKey{
+		ID:         uuid.New().String(),
+		KID:        uuid.New().String(),
+		Algorithm:  CookieKeyAlgorithm,
+		PrivateKey: base64.StdEncoding.EncodeToString(secret),
+		IsActive:   true,
+		CreatedAt:  time.Now(),
+		Use:        models.KeyUseCookie,
+	}
+
+	err = store.RunInTransaction(func(tx storage.Storage) error {
+		keys, err := tx.GetAllSigningKeys()
+		if err != nil {
+			return fmt.Errorf("failed to get existing keys: %w", err)
+		}
+		for _, key := range keys {
+			if key.IsCookieKey() && key.IsActive {
+				key.IsActive = false
+				key.ExpiresAt = time.Now().Add(CookieKeyGracePeriod)
+				if err := tx.UpdateSigningKey(key); err != nil {
+					return fmt.Errorf("failed to retire cookie key: %w", err)
+				}
+			}
+		}
+		if err := tx.CreateSigningKey(newKey); err != nil {
+			return fmt.Errorf("failed to create cookie key: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newKey, nil
+}
+
+// ActiveCookieKey returns the cookie key new cookies are sealed with, or nil
+func ActiveCookieKey(keys []*models.SigningKey) *models.SigningKey {
+	for _, key := range keys {
+		if key.IsCookieKey() && key.IsActive {
+			return key
+		}
+	}
+	return nil
+}
+
+// EnsureCookieKey creates a cookie key if none is active
+func EnsureCookieKey(store storage.Storage) error {
+	keys, err := store.GetAllSigningKeys()
+	if err != nil {
+		return err
+	}
+	if ActiveCookieKey(keys) != nil {
+		return nil
+	}
+	_, err = RotateCookieKey(store)
+	return err
+}
@@ -3,8 +3,13 @@ package session
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -16,11 +21,23 @@ import (
 const (
 	// Session cookie names
 	UserSessionCookieName = "user_session"
-	AuthSessionCookieName = "auth_session"
+	// PersistentSessionCookieName holds "keep me signed in" sessions across browser restarts
+	PersistentSessionCookieName = "user_session_persistent"
+	AuthSessionCookieName       = "auth_session"
+	BrowserIDCookieName         = "browser_id"
 
 	// Default timeouts
 	DefaultUserSessionTimeout = 24 * time.Hour
 	DefaultAuthSessionTimeout = 10 * time.Minute
+	DefaultBrowserIDTimeout   = 365 * 24 * time.Hour
+
+	// DefaultPersistentSessionACR is asserted for remembered sessions once the regular
+	// session cookie has expired, since the user has not signed in for a while
+	DefaultPersistentSessionACR = "urn:mace:incommon:iap:bronze"
+
+	// Default limits for pending authorization sessions
+	DefaultMaxAuthSessionsPerOwner    = 5
+	DefaultAuthSessionCleanupInterval = 1 * time.Minute
 
 	// Context keys
 	UserSessionKey = "user_session"
@@ -38,6 +55,19 @@ type Config struct {
 	CookieDomain       string
 	CookiePath         string
 	CleanupInterval    time.Duration
+
+	// MaxAuthSessionsPerOwner caps pending authorization sessions per browser/user.
+	// The oldest sessions are evicted when the cap is reached. Zero disables the cap.
+	MaxAuthSessionsPerOwner int
+	// AuthSessionCleanupInterval controls how often expired authorization sessions are removed
+	AuthSessionCleanupInterval time.Duration
+
+	// PersistentSessionACR replaces the ACR of a remembered session when it is resumed
+	// from the persistent cookie
+	PersistentSessionACR string
+
+	// CookieCodec seals the cookie values; nil leaves them as plain session IDs
+	CookieCodec CookieCodec
 }
 
 // DefaultConfig returns default configuration
@@ -52,47 +82,133 @@ func DefaultConfig(storage storage.Storage) Config {
 		CookieDomain:       "",
 		CookiePath:         "/",
 		CleanupInterval:    1 * time.Hour,
+
+		MaxAuthSessionsPerOwner:    DefaultMaxAuthSessionsPerOwner,
+		AuthSessionCleanupInterval: DefaultAuthSessionCleanupInterval,
+		PersistentSessionACR:       DefaultPersistentSessionACR,
 	}
 }
 
+// AuthOutcome is how an authorization flow ended
+type AuthOutcome int
+
+const (
+	ConsentApproved AuthOutcome = iota // A code or tokens were issued to the client
+	ConsentDenied                      // Refused by the user, a policy or prompt=none
+	DeviceApproved                     // The user approved a device authorization
+	DeviceDenied                       // The user denied a device authorization
+	authOutcomes
+)
+
+// AuthOutcomeCounts counts the approved and denied flows of one kind
+type AuthOutcomeCounts struct {
+	Approved int64 `json:"approved"`
+	Denied   int64 `json:"denied"`
+}
+
+// AuthSessionStats holds counters about authorization flows since startup.
+// A flow counts as abandoned on the instance that started it once it expires
+// there without an outcome, so with several instances a flow finished on
+// another one is counted as abandoned too.
+type AuthSessionStats struct {
+	Created     int64             `json:"created"`
+	Completed   int64             `json:"completed"` // Approved and denied flows of both kinds
+	Consent     AuthOutcomeCounts `json:"consent"`   // Flows from the authorization endpoint
+	Device      AuthOutcomeCounts `json:"device"`    // Device authorizations
+	Evicted     int64             `json:"evicted"`
+	Abandoned   int64             `json:"abandoned"`
+	AbandonRate float64           `json:"abandon_rate"`
+}
+
 // Manager handles session operations
 type Manager struct {
 	config Config
 	store  Store
+
+	authCreated   atomic.Int64
+	authOutcomes  [authOutcomes]atomic.Int64
+	authEvicted   atomic.Int64
+	authAbandoned atomic.Int64
+
+	// pendingMu guards pending, the expiry of each flow started here that has no
+	// outcome yet. Storage may drop expired flows on its own, e.g. through a
+	// MongoDB TTL index, so abandoned flows are counted from this instead.
+	pendingMu sync.Mutex
+	pending   map[string]time.Time
 }
 
 // NewManager creates a new session manager
 func NewManager(config Config) *Manager {
 	mgr := &Manager{
-		config: config,
-		store:  NewStore(config.Storage),
+		config:  config,
+		store:   NewStore(config.Storage),
+		pending: make(map[string]time.Time),
 	}
 
 	// Start background cleanup if interval is set
 	if config.CleanupInterval > 0 {
 		go mgr.startCleanup()
 	}
+	if config.AuthSessionCleanupInterval > 0 {
+		go mgr.startAuthSessionCleanup()
+	}
 
 	return mgr
 }
 
+// AuthSessionStats returns counters about authorization flows.
+// Abandoned flows are sessions that expired without being approved or denied.
+func (m *Manager) AuthSessionStats() AuthSessionStats {
+	stats := AuthSessionStats{
+		Created: m.authCreated.Load(),
+		Consent: AuthOutcomeCounts{
+			Approved: m.authOutcomes[ConsentApproved].Load(),
+			Denied:   m.authOutcomes[ConsentDenied].Load(),
+		},
+		Device: AuthOutcomeCounts{
+			Approved: m.authOutcomes[DeviceApproved].Load(),
+			Denied:   m.authOutcomes[DeviceDenied].Load(),
+		},
+		Evicted:   m.authEvicted.Load(),
+		Abandoned: m.authAbandoned.Load(),
+	}
+	stats.Completed = stats.Consent.Approved + stats.Consent.Denied + stats.Device.Approved + stats.Device.Denied
+	if finished := stats.Completed + stats.Evicted + stats.Abandoned; finished > 0 {
+		stats.AbandonRate = float64(stats.Evicted+stats.Abandoned) / float64(finished)
+	}
+	return stats
+}
+
 // Middleware returns Echo middleware for session handling
 func (m *Manager) Middleware() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			// Try to load existing user session
-			if cookie, err := c.Cookie(UserSessionCookieName); err == nil {
-				if session, err := m.store.GetUserSession(cookie.Value); err == nil && session != nil {
+			if sessionID, stale, ok := m.readCookie(c, UserSessionCookieName); ok {
+				if session, err := m.store.GetUserSession(sessionID); err == nil && session != nil {
 					if session.IsAuthenticated() {
 						c.Set(UserSessionKey, session)
+						if stale {
+							m.resealCookie(c, UserSessionCookieName, sessionID, min(time.Until(session.ExpiresAt), m.config.UserSessionTimeout))
+						}
 					}
 				}
 			}
 
+			// Fall back to a remembered session
+			if GetUserSession(c) == nil {
+				if sessionID, stale, ok := m.readCookie(c, PersistentSessionCookieName); ok {
+					m.resumePersistentSession(c, sessionID, stale)
+				}
+			}
+
 			// Try to load existing auth session
-			if cookie, err := c.Cookie(AuthSessionCookieName); err == nil {
-				if session, err := m.store.GetAuthSession(cookie.Value); err == nil && session != nil {
+			if sessionID, stale, ok := m.readCookie(c, AuthSessionCookieName); ok {
+				if session, err := m.store.GetAuthSession(sessionID); err == nil && session != nil {
 					c.Set(AuthSessionKey, session)
+					if stale {
+						m.resealCookie(c, AuthSessionCookieName, sessionID, time.Until(session.ExpiresAt))
+					}
 				}
 			}
 
@@ -123,6 +239,17 @@ func GetAuthSession(c echo.Context) *models.AuthSession {
 
 // CreateUserSession creates a new user session and sets cookie
 func (m *Manager) CreateUserSession(c echo.Context, userID string, authMethod string, acr string, amr []string) (*models.UserSession, error) {
+	return m.createUserSession(c, userID, authMethod, acr, amr, 0)
+}
+
+// CreatePersistentUserSession creates a "keep me signed in" session that lasts for
+// lifetime. Besides the regular cookie it sets a persistent cookie that resumes the
+// session, with a lower ACR, after the regular cookie has expired.
+func (m *Manager) CreatePersistentUserSession(c echo.Context, userID string, authMethod string, acr string, amr []string, lifetime time.Duration) (*models.UserSession, error) {
+	return m.createUserSession(c, userID, authMethod, acr, amr, lifetime)
+}
+
+func (m *Manager) createUserSession(c echo.Context, userID string, authMethod string, acr string, amr []string, persistentLifetime time.Duration) (*models.UserSession, error) {
 	sessionID, err := generateSessionID()
 	if err != nil {
 		return nil, err
@@ -140,13 +267,24 @@ func (m *Manager) CreateUserSession(c echo.Context, userID string, authMethod st
 		ExpiresAt:            now.Add(m.config.UserSessionTimeout),
 		CreatedAt:            now,
 	}
+	if persistentLifetime > 0 {
+		session.Persistent = true
+		session.ExpiresAt = now.Add(persistentLifetime)
+	}
 
 	if err := m.store.CreateUserSession(session); err != nil {
 		return nil, err
 	}
 
 	// Set cookie
-	m.setSessionCookie(c, UserSessionCookieName, sessionID, m.config.UserSessionTimeout)
+	if err := m.setSessionCookie(c, UserSessionCookieName, sessionID, m.config.UserSessionTimeout); err != nil {
+		return nil, err
+	}
+	if session.Persistent {
+		if err := m.setSessionCookie(c, PersistentSessionCookieName, sessionID, persistentLifetime); err != nil {
+			return nil, err
+		}
+	}
 
 	// Store in context
 	c.Set(UserSessionKey, session)
@@ -161,6 +299,14 @@ func (m *Manager) CreateAuthSession(c echo.Context, clientID, redirectURI, respo
 		return nil, err
 	}
 
+	owner, err := m.authSessionOwner(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.evictAuthSessions(owner); err != nil {
+		return nil, err
+	}
+
 	now := time.Now()
 	session := &models.AuthSession{
 		ID:           sessionID,
@@ -169,6 +315,7 @@ func (m *Manager) CreateAuthSession(c echo.Context, clientID, redirectURI, respo
 		ResponseType: responseType,
 		Scope:        scope,
 		State:        state,
+		Owner:        owner,
 		ExpiresAt:    now.Add(m.config.AuthSessionTimeout),
 		CreatedAt:    now,
 	}
@@ -177,8 +324,24 @@ func (m *Manager) CreateAuthSession(c echo.Context, clientID, redirectURI, respo
 	session.Nonce = c.QueryParam("nonce")
 	session.CodeChallenge = c.QueryParam("code_challenge")
 	session.CodeChallengeMethod = c.QueryParam("code_challenge_method")
+	if session.CodeChallenge != "" && session.CodeChallengeMethod == "" {
+		session.CodeChallengeMethod = "plain" // RFC 7636 Section 4.3
+	}
 	session.Prompt = c.QueryParam("prompt")
 	session.Display = c.QueryParam("display")
+	session.UILocales = strings.Fields(c.QueryParam("ui_locales"))
+	session.ClaimsLocales = strings.Fields(c.QueryParam("claims_locales"))
+	session.ACRValues = strings.Fields(c.QueryParam("acr_values"))
+
+	// Parse the claims request (OpenID Connect Core Section 5.5); the authorization
+	// endpoint has already rejected malformed values
+	if claims := c.QueryParam("claims"); claims != "" {
+		_ = json.Unmarshal([]byte(claims), &session.Claims)
+	}
+	// An essential acr claim selects the sign-in flow as acr_values would
+	if values, essential := session.EssentialACR(); essential && len(session.ACRValues) == 0 {
+		session.ACRValues = values
+	}
 
 	// Parse max_age
 	if maxAgeStr := c.QueryParam("max_age"); maxAgeStr != "" {
@@ -188,9 +351,15 @@ func (m *Manager) CreateAuthSession(c echo.Context, clientID, redirectURI, respo
 	if err := m.store.CreateAuthSession(session); err != nil {
 		return nil, err
 	}
+	m.authCreated.Add(1)
+	m.pendingMu.Lock()
+	m.pending[session.ID] = session.ExpiresAt
+	m.pendingMu.Unlock()
 
 	// Set cookie
-	m.setSessionCookie(c, AuthSessionCookieName, sessionID, m.config.AuthSessionTimeout)
+	if err := m.setSessionCookie(c, AuthSessionCookieName, sessionID, m.config.AuthSessionTimeout); err != nil {
+		return nil, err
+	}
 
 	// Store in context
 	c.Set(AuthSessionKey, session)
@@ -222,15 +391,19 @@ func (m *Manager) DeleteUserSession(c echo.Context, sessionID string) error {
 		return err
 	}
 	m.clearSessionCookie(c, UserSessionCookieName)
+	m.clearSessionCookie(c, PersistentSessionCookieName)
 	c.Set(UserSessionKey, nil)
 	return nil
 }
 
-// DeleteAuthSession deletes an auth session and clears cookie
-func (m *Manager) DeleteAuthSession(c echo.Context, sessionID string) error {
+// FinishAuthSession deletes an auth session once its flow has ended with outcome,
+// and clears the cookie
+func (m *Manager) FinishAuthSession(c echo.Context, sessionID string, outcome AuthOutcome) error {
 	if err := m.store.DeleteAuthSession(sessionID); err != nil {
 		return err
 	}
+	m.authOutcomes[outcome].Add(1)
+	m.forgetPending(sessionID)
 	m.clearSessionCookie(c, AuthSessionCookieName)
 	c.Set(AuthSessionKey, nil)
 	return nil
@@ -249,9 +422,48 @@ func (m *Manager) RequireAuth() echo.MiddlewareFunc {
 	}
 }
 
+// ReloadCookieKeys makes the cookie codec pick up rotated or deleted keys right away
+func (m *Manager) ReloadCookieKeys() {
+	if codec, ok := m.config.CookieCodec.(interface{ Invalidate() }); ok {
+		codec.Invalidate()
+	}
+}
+
 // Helper methods
 
-func (m *Manager) setSessionCookie(c echo.Context, name, value string, maxAge time.Duration) {
+// readCookie returns the value of cookie name, opened by the cookie codec, and
+// whether it should be sealed again with the current key
+func (m *Manager) readCookie(c echo.Context, name string) (string, bool, bool) {
+	cookie, err := c.Cookie(name)
+	if err != nil || cookie.Value == "" {
+		return "", false, false
+	}
+	if m.config.CookieCodec == nil {
+		return cookie.Value, false, true
+	}
+	value, stale, err := m.config.CookieCodec.Decode(name, cookie.Value)
+	if err != nil {
+		return "", false, false
+	}
+	return value, stale, true
+}
+
+// resealCookie sets a cookie sealed with a retired key again with the current
+// key; failures leave the old cookie in place
+func (m *Manager) resealCookie(c echo.Context, name, value string, maxAge time.Duration) {
+	if maxAge > 0 {
+		_ = m.setSessionCookie(c, name, value, maxAge)
+	}
+}
+
+func (m *Manager) setSessionCookie(c echo.Context, name, value string, maxAge time.Duration) error {
+	if m.config.CookieCodec != nil {
+		sealed, err := m.config.CookieCodec.Encode(name, value)
+		if err != nil {
+			return fmt.Errorf("failed to seal %s cookie: %w", name, err)
+		}
+		value = sealed
+	}
 	cookie := &http.Cookie{
 		Name:     name,
 		Value:    value,
@@ -263,6 +475,7 @@ func (m *Manager) setSessionCookie(c echo.Context, name, value string, maxAge ti
 		SameSite: m.config.CookieSameSite,
 	}
 	c.SetCookie(cookie)
+	return nil
 }
 
 func (m *Manager) clearSessionCookie(c echo.Context, name string) {
@@ -279,15 +492,123 @@ func (m *Manager) clearSessionCookie(c echo.Context, name string) {
 	c.SetCookie(cookie)
 }
 
+// resumePersistentSession loads a remembered session from the persistent cookie.
+// The user has not actively signed in during this browser session, so the session's
+// ACR is lowered and the regular cookie is not re-issued.
+func (m *Manager) resumePersistentSession(c echo.Context, sessionID string, stale bool) {
+	session, err := m.store.GetUserSession(sessionID)
+	if err != nil || session == nil || !session.Persistent || !session.IsAuthenticated() {
+		return
+	}
+	if acr := m.config.PersistentSessionACR; acr != "" && session.ACR != acr {
+		session.ACR = acr
+		_ = m.store.UpdateUserSession(session) // Best effort, the lowered ACR also applies to this request
+	}
+	c.Set(UserSessionKey, session)
+	if stale {
+		m.resealCookie(c, PersistentSessionCookieName, sessionID, time.Until(session.ExpiresAt))
+	}
+}
+
+// authSessionOwner identifies who is starting an authorization flow: the
+// authenticated user if there is one, otherwise a long-lived browser cookie.
+func (m *Manager) authSessionOwner(c echo.Context) (string, error) {
+	if userSession := GetUserSession(c); userSession != nil {
+		return "user:" + userSession.UserID, nil
+	}
+
+	if browserID, stale, ok := m.readCookie(c, BrowserIDCookieName); ok {
+		if stale {
+			m.resealCookie(c, BrowserIDCookieName, browserID, DefaultBrowserIDTimeout)
+		}
+		return "browser:" + browserID, nil
+	}
+
+	browserID, err := generateSessionID()
+	if err != nil {
+		return "", err
+	}
+	if err := m.setSessionCookie(c, BrowserIDCookieName, browserID, DefaultBrowserIDTimeout); err != nil {
+		return "", err
+	}
+	return "browser:" + browserID, nil
+}
+
+// evictAuthSessions deletes the oldest pending sessions of owner so that a new
+// one can be created without exceeding MaxAuthSessionsPerOwner.
+func (m *Manager) evictAuthSessions(owner string) error {
+	if m.config.MaxAuthSessionsPerOwner <= 0 {
+		return nil
+	}
+
+	sessions, err := m.store.GetAuthSessionsByOwner(owner)
+	if err != nil {
+		return err
+	}
+
+	excess := len(sessions) - m.config.MaxAuthSessionsPerOwner + 1
+	if excess <= 0 {
+		return nil
+	}
+
+	sort.Slice(sessions, func(i, k int) bool {
+		return sessions[i].CreatedAt.Before(sessions[k].CreatedAt)
+	})
+	for _, session := range sessions[:excess] {
+		if err := m.store.DeleteAuthSession(session.ID); err != nil {
+			return err
+		}
+		m.authEvicted.Add(1)
+		m.forgetPending(session.ID)
+	}
+	return nil
+}
+
+// forgetPending stops tracking a flow that ended before it expired
+func (m *Manager) forgetPending(sessionID string) {
+	m.pendingMu.Lock()
+	delete(m.pending, sessionID)
+	m.pendingMu.Unlock()
+}
+
+// countAbandoned counts the flows started here that expired by now without an
+// outcome, whether or not storage still holds them
+func (m *Manager) countAbandoned(now time.Time) {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	for id, expiresAt := range m.pending {
+		if now.After(expiresAt) {
+			delete(m.pending, id)
+			m.authAbandoned.Add(1)
+		}
+	}
+}
+
+// cleanupAuthSessions counts expired authorization flows as abandoned and removes them
+func (m *Manager) cleanupAuthSessions() {
+	m.countAbandoned(time.Now())
+	_, _ = m.store.CleanupExpiredAuthSessions()
+}
+
 func (m *Manager) startCleanup() {
 	ticker := time.NewTicker(m.config.CleanupInterval)
 	defer ticker.Stop()
 
 	for range ticker.C {
+		m.cleanupAuthSessions()
 		_ = m.store.CleanupExpiredSessions()
 	}
 }
 
+func (m *Manager) startAuthSessionCleanup() {
+	ticker := time.NewTicker(m.config.AuthSessionCleanupInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.cleanupAuthSessions()
+	}
+}
+
 // generateSessionID generates a cryptographically secure random session ID
 func generateSessionID() (string, error) {
 	b := make([]byte, 32)
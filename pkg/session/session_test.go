@@ -1,10 +1,16 @@
 package session
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/labstack/echo/v4"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
 	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
 )
 
 func TestUserSession_IsAuthenticated(t *testing.T) {
@@ -107,3 +113,191 @@ func TestGenerateSessionID(t *testing.T) {
 		t.Error("generateSessionID() produced empty ID")
 	}
 }
+
+func TestManager_AuthSessionCapAndCleanup(t *testing.T) {
+	store, err := storage.NewJSONStorage(t.TempDir() + "/sessions.json")
+	if err != nil {
+		t.Fatalf("NewJSONStorage() error = %v", err)
+	}
+
+	cfg := DefaultConfig(store)
+	cfg.CleanupInterval = 0
+	cfg.AuthSessionCleanupInterval = 0
+	cfg.MaxAuthSessionsPerOwner = 2
+	mgr := NewManager(cfg)
+
+	e := echo.New()
+	browser := &http.Cookie{Name: BrowserIDCookieName, Value: "browser-1"}
+	var created []*models.AuthSession
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/authorize", nil)
+		req.AddCookie(browser)
+		c := e.NewContext(req, httptest.NewRecorder())
+		session, err := mgr.CreateAuthSession(c, "client", "https://app/cb", "code", "openid", "s")
+		if err != nil {
+			t.Fatalf("CreateAuthSession() error = %v", err)
+		}
+		created = append(created, session)
+		time.Sleep(time.Millisecond)
+	}
+
+	if got, _ := store.GetAuthSession(created[0].ID); got != nil {
+		t.Errorf("oldest auth session was not evicted")
+	}
+	pending, _ := store.GetAuthSessionsByOwner("browser:browser-1")
+	if len(pending) != 2 {
+		t.Errorf("pending auth sessions = %d, want 2", len(pending))
+	}
+
+	// One flow is approved. The other expires and is counted as abandoned even
+	// though storage removed it first, as a MongoDB TTL index does.
+	c := e.NewContext(httptest.NewRequest(http.MethodPost, "/consent", nil), httptest.NewRecorder())
+	if err := mgr.FinishAuthSession(c, created[2].ID, DeviceApproved); err != nil {
+		t.Fatalf("FinishAuthSession() error = %v", err)
+	}
+	_ = store.DeleteAuthSession(created[1].ID)
+	mgr.countAbandoned(time.Now())
+	if stats := mgr.AuthSessionStats(); stats.Abandoned != 0 {
+		t.Errorf("Abandoned = %d before the flow expired", stats.Abandoned)
+	}
+	mgr.countAbandoned(created[1].ExpiresAt.Add(time.Second))
+
+	stats := mgr.AuthSessionStats()
+	want := AuthSessionStats{Created: 3, Completed: 1, Device: AuthOutcomeCounts{Approved: 1}, Evicted: 1, Abandoned: 1, AbandonRate: 2.0 / 3}
+	if stats != want {
+		t.Errorf("AuthSessionStats() = %+v, want %+v", stats, want)
+	}
+}
+
+func TestManager_PersistentSessionResume(t *testing.T) {
+	store, err := storage.NewJSONStorage(t.TempDir() + "/sessions.json")
+	if err != nil {
+		t.Fatalf("NewJSONStorage() error = %v", err)
+	}
+
+	cfg := DefaultConfig(store)
+	cfg.CleanupInterval = 0
+	cfg.AuthSessionCleanupInterval = 0
+	mgr := NewManager(cfg)
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodPost, "/login", nil), rec)
+	created, err := mgr.CreatePersistentUserSession(c, "user-1", "password", "urn:mace:incommon:iap:silver", []string{"pwd"}, 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("CreatePersistentUserSession() error = %v", err)
+	}
+	if !created.Persistent {
+		t.Errorf("session is not marked persistent")
+	}
+
+	var persistent *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == PersistentSessionCookieName {
+			persistent = cookie
+		}
+	}
+	if persistent == nil || persistent.MaxAge <= 0 {
+		t.Fatalf("persistent cookie = %+v, want a cookie with Max-Age", persistent)
+	}
+
+	// A new browser session only presents the persistent cookie
+	req := httptest.NewRequest(http.MethodGet, "/authorize", nil)
+	req.AddCookie(&http.Cookie{Name: PersistentSessionCookieName, Value: persistent.Value})
+	c = e.NewContext(req, httptest.NewRecorder())
+	var resumed *models.UserSession
+	handler := mgr.Middleware()(func(c echo.Context) error {
+		resumed = GetUserSession(c)
+		return nil
+	})
+	if err := handler(c); err != nil {
+		t.Fatalf("middleware error = %v", err)
+	}
+	if resumed == nil || resumed.UserID != "user-1" {
+		t.Fatalf("resumed session = %+v, want user-1", resumed)
+	}
+	if resumed.ACR != DefaultPersistentSessionACR {
+		t.Errorf("resumed ACR = %q, want %q", resumed.ACR, DefaultPersistentSessionACR)
+	}
+}
+
+func TestManager_EncryptedCookieRotation(t *testing.T) {
+	store, err := storage.NewJSONStorage(t.TempDir() + "/sessions.json")
+	if err != nil {
+		t.Fatalf("NewJSONStorage() error = %v", err)
+	}
+	if err := EnsureCookieKey(store); err != nil {
+		t.Fatalf("EnsureCookieKey() error = %v", err)
+	}
+
+	codec := NewKeyringCodec(store, time.Hour)
+	cfg := DefaultConfig(store)
+	cfg.CleanupInterval = 0
+	cfg.AuthSessionCleanupInterval = 0
+	cfg.CookieCodec = codec
+	mgr := NewManager(cfg)
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	created, err := mgr.CreateUserSession(e.NewContext(httptest.NewRequest(http.MethodPost, "/login", nil), rec), "user-1", "password", "", nil)
+	if err != nil {
+		t.Fatalf("CreateUserSession() error = %v", err)
+	}
+	sealed := rec.Result().Cookies()[0]
+	if sealed.Value == created.ID {
+		t.Fatalf("cookie carries the plain session ID")
+	}
+
+	// load runs the middleware with cookie and returns the session it found and the cookies it set
+	load := func(cookie *http.Cookie) (*models.UserSession, []*http.Cookie) {
+		req := httptest.NewRequest(http.MethodGet, "/authorize", nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		var found *models.UserSession
+		handler := mgr.Middleware()(func(c echo.Context) error {
+			found = GetUserSession(c)
+			return nil
+		})
+		if err := handler(e.NewContext(req, rec)); err != nil {
+			t.Fatalf("middleware error = %v", err)
+		}
+		return found, rec.Result().Cookies()
+	}
+
+	if found, reissued := load(sealed); found == nil || found.ID != created.ID || len(reissued) != 0 {
+		t.Fatalf("session = %+v, reissued = %v; want the session without a new cookie", found, reissued)
+	}
+	if found, _ := load(&http.Cookie{Name: UserSessionCookieName, Value: created.ID}); found != nil {
+		t.Errorf("plain session ID was accepted")
+	}
+
+	// After a rotation the old cookie still works and is sealed again with the new key
+	oldKID, _ := crypto.CookieKeyID(sealed.Value)
+	newKey, err := RotateCookieKey(store)
+	if err != nil {
+		t.Fatalf("RotateCookieKey() error = %v", err)
+	}
+	mgr.ReloadCookieKeys()
+	found, reissued := load(sealed)
+	if found == nil || len(reissued) != 1 {
+		t.Fatalf("session = %+v, reissued = %v; want the session and a resealed cookie", found, reissued)
+	}
+	if kid, _ := crypto.CookieKeyID(reissued[0].Value); kid != newKey.KID {
+		t.Errorf("resealed cookie key = %s, want %s", kid, newKey.KID)
+	}
+
+	// Deleting the old key invalidates cookies sealed with it
+	keys, _ := store.GetAllSigningKeys()
+	for _, key := range keys {
+		if key.KID == oldKID {
+			_ = store.DeleteSigningKey(key.ID)
+		}
+	}
+	mgr.ReloadCookieKeys()
+	if found, _ := load(sealed); found != nil {
+		t.Errorf("cookie sealed with a deleted key was accepted")
+	}
+	if found, _ := load(reissued[0]); found == nil {
+		t.Errorf("resealed cookie was rejected")
+	}
+}
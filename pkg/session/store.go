@@ -12,6 +12,8 @@ type Store interface {
 	GetAuthSession(id string) (*models.AuthSession, error)
 	UpdateAuthSession(session *models.AuthSession) error
 	DeleteAuthSession(id string) error
+	GetAuthSessionsByOwner(owner string) ([]*models.AuthSession, error)
+	CleanupExpiredAuthSessions() (int, error)
 
 	// UserSession operations
 	CreateUserSession(session *models.UserSession) error
@@ -56,6 +58,16 @@ func (s *sessionStore) DeleteAuthSession(id string) error {
 	return s.storage.DeleteAuthSession(id)
 }
 
+// GetAuthSessionsByOwner retrieves the pending authorization sessions started by owner
+func (s *sessionStore) GetAuthSessionsByOwner(owner string) ([]*models.AuthSession, error) {
+	return s.storage.GetAuthSessionsByOwner(owner)
+}
+
+// CleanupExpiredAuthSessions removes expired authorization sessions and returns the count
+func (s *sessionStore) CleanupExpiredAuthSessions() (int, error) {
+	return s.storage.CleanupExpiredAuthSessions()
+}
+
 // CreateUserSession creates a new user session
 func (s *sessionStore) CreateUserSession(session *models.UserSession) error {
 	return s.storage.CreateUserSession(session)
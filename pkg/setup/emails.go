@@ -0,0 +1,109 @@
+package setup
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+// EmailDuplicate is a set of users whose email addresses have the same canonical form
+type EmailDuplicate struct {
+	Email    string   `json:"email"`    // Canonical address
+	Kept     string   `json:"kept"`     // ID of the user who keeps the address
+	Disabled []string `json:"disabled"` // IDs of the other users, disabled for review
+}
+
+// EmailNormalizationResult describes the changes NormalizeUserEmails made, or
+// would make in a dry run
+type EmailNormalizationResult struct {
+	Users      int              `json:"users"`      // Users examined
+	Normalized int              `json:"normalized"` // Addresses rewritten in canonical form
+	Duplicates []EmailDuplicate `json:"duplicates,omitempty"`
+}
+
+// NormalizeUserEmails rewrites every user's email address in canonical form and
+// resolves addresses shared by several users. Of each such group one user keeps
+// the address: one already holding it, else a verified one, else the oldest. The
+// others are disabled with their addresses unchanged, for an administrator to
+// merge, correct or delete. Nothing is written unless apply is set.
+func NormalizeUserEmails(store storage.Storage, stripPlusTag, apply bool) (*EmailNormalizationResult, error) {
+	users, err := store.GetAllUsers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	groups := make(map[string][]*models.User)
+	var emails []string
+	for _, user := range users {
+		if user.Email == "" {
+			continue
+		}
+		email := models.NormalizeEmail(user.Email, stripPlusTag)
+		if groups[email] == nil {
+			emails = append(emails, email)
+		}
+		groups[email] = append(groups[email], user)
+	}
+	sort.Strings(emails)
+
+	result := &EmailNormalizationResult{Users: len(users)}
+	for _, email := range emails {
+		group := groups[email]
+		sort.Slice(group, func(i, j int) bool { return keepsEmailBefore(group[i], group[j], email) })
+		kept, others := group[0], group[1:]
+
+		// Disable the others first, so the kept user's new address is free
+		if len(others) > 0 {
+			duplicate := EmailDuplicate{Email: email, Kept: kept.ID}
+			for _, user := range others {
+				duplicate.Disabled = append(duplicate.Disabled, user.ID)
+				if apply && !user.Disabled {
+					user.Disabled = true
+					if err := store.UpdateUser(user); err != nil {
+						return result, fmt.Errorf("failed to disable user %s: %w", user.ID, err)
+					}
+				}
+			}
+			result.Duplicates = append(result.Duplicates, duplicate)
+		}
+
+		if kept.Email != email {
+			result.Normalized++
+			if apply {
+				kept.Email = email
+				if err := store.UpdateUser(kept); err != nil {
+					return result, fmt.Errorf("failed to update user %s: %w", kept.ID, err)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// keepsEmailBefore orders users sharing the canonical address email by who
+// should keep it. Preferring an address equal to email, or differing only in
+// case, means the kept user's update never collides with another's address.
+func keepsEmailBefore(a, b *models.User, email string) bool {
+	rank := func(u *models.User) int {
+		switch {
+		case u.Email == email:
+			return 0
+		case strings.EqualFold(u.Email, email):
+			return 1
+		}
+		return 2
+	}
+	if ra, rb := rank(a), rank(b); ra != rb {
+		return ra < rb
+	}
+	if a.EmailVerified != b.EmailVerified {
+		return a.EmailVerified
+	}
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
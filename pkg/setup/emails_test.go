@@ -0,0 +1,69 @@
+package setup
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+func TestNormalizeUserEmails(t *testing.T) {
+	store, err := storage.NewJSONStorage(filepath.Join(t.TempDir(), "store.json"))
+	require.NoError(t, err)
+	for _, user := range []*models.User{
+		{ID: "alice", Username: "alice", Email: "Alice+Work@Example.com"},
+		{ID: "alice-new", Username: "alice-new", Email: "alice+home@example.com"},
+		{ID: "bob", Username: "bob", Email: "Bob@Example.com"},
+		{ID: "carol", Username: "carol", Email: "carol@example.com"},
+		{ID: "carol-verified", Username: "carol2", Email: "Carol+2@example.com", EmailVerified: true},
+		{ID: "service", Username: "service"},
+	} {
+		require.NoError(t, store.CreateUser(user))
+		time.Sleep(time.Millisecond) // Distinct creation times, so the first alice is the oldest
+	}
+
+	// A dry run changes nothing
+	want := &EmailNormalizationResult{
+		Users:      6,
+		Normalized: 2,
+		Duplicates: []EmailDuplicate{
+			{Email: "alice@example.com", Kept: "alice", Disabled: []string{"alice-new"}},
+			{Email: "carol@example.com", Kept: "carol", Disabled: []string{"carol-verified"}},
+		},
+	}
+	result, err := NormalizeUserEmails(store, true, false)
+	require.NoError(t, err)
+	assert.Equal(t, want, result)
+	user, err := store.GetUserByID("bob")
+	require.NoError(t, err)
+	assert.Equal(t, "Bob@Example.com", user.Email)
+
+	result, err = NormalizeUserEmails(store, true, true)
+	require.NoError(t, err)
+	assert.Equal(t, want, result)
+	for id, email := range map[string]string{
+		"alice":          "alice@example.com",
+		"alice-new":      "alice+home@example.com",
+		"bob":            "bob@example.com",
+		"carol":          "carol@example.com",
+		"carol-verified": "Carol+2@example.com",
+	} {
+		user, err := store.GetUserByID(id)
+		require.NoError(t, err)
+		assert.Equal(t, email, user.Email, id)
+	}
+	disabled, err := store.GetUserByID("alice-new")
+	require.NoError(t, err)
+	assert.True(t, disabled.Disabled)
+
+	// Running again only reports the duplicates left for review
+	result, err = NormalizeUserEmails(store, true, true)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Normalized)
+	assert.Len(t, result.Duplicates, 2)
+}
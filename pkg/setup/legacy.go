@@ -0,0 +1,337 @@
+package setup
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+// LegacySkip is a legacy record that MigrateLegacySQLite did not copy
+type LegacySkip struct {
+	Kind   string `json:"kind"` // "user" or "client"
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// LegacyMigrationResult describes what MigrateLegacySQLite copied, or would copy
+// in a dry run
+type LegacyMigrationResult struct {
+	Users           int          `json:"users"`            // Legacy users read
+	Clients         int          `json:"clients"`          // Legacy clients read
+	MigratedUsers   int          `json:"migrated_users"`   // Users created in the store
+	MigratedClients int          `json:"migrated_clients"` // Clients created in the store
+	Skipped         []LegacySkip `json:"skipped,omitempty"`
+}
+
+// OpenLegacySQLite opens, read-only, the SQLite database of a deployment created
+// by the old internal/ storage layer (DB_CONNECTION, ./openid.db by default). The
+// sqlite3 database/sql driver must be registered by the program, which the
+// openid-server command does in builds with cgo.
+func OpenLegacySQLite(path string) (*sql.DB, error) {
+	if !slices.Contains(sql.Drivers(), "sqlite3") {
+		return nil, errors.New("reading SQLite databases needs a build with cgo enabled (CGO_ENABLED=1)")
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// MigrateLegacySQLite copies the users and clients of a legacy internal/ SQLite
+// database into store. Users keep their IDs and bcrypt password hashes, so they
+// sign in with their old passwords, and get the user role. Clients keep their IDs
+// and secrets and get the defaults of clients created today. Authorization codes,
+// tokens and sessions are short-lived and not copied; users sign in again.
+//
+// Records whose ID is already in the store are skipped, so the migration can be
+// run again, as are users whose username or email address another user holds.
+// Everything is written in one transaction, and nothing unless apply is set.
+func MigrateLegacySQLite(db *sql.DB, store storage.Storage, apply bool) (*LegacyMigrationResult, error) {
+	users, err := readLegacyUsers(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read legacy users: %w", err)
+	}
+	clients, err := readLegacyClients(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read legacy clients: %w", err)
+	}
+
+	result := &LegacyMigrationResult{Users: len(users), Clients: len(clients)}
+	err = store.RunInTransaction(func(tx storage.Storage) error {
+		// Names taken by earlier legacy users, which a dry run does not write
+		taken := map[string]bool{}
+		for _, user := range users {
+			reason, err := legacyUserConflict(tx, user, taken)
+			if err != nil {
+				return err
+			}
+			if reason != "" {
+				result.Skipped = append(result.Skipped, LegacySkip{Kind: "user", ID: user.ID, Reason: reason})
+				continue
+			}
+			taken["username:"+user.Username] = true
+			taken["email:"+strings.ToLower(user.Email)] = true
+			if apply {
+				if err := tx.CreateUser(user); err != nil {
+					return fmt.Errorf("failed to create user %s: %w", user.ID, err)
+				}
+			}
+			result.MigratedUsers++
+		}
+
+		for _, client := range clients {
+			existing, err := tx.GetClientByID(client.ID)
+			if err != nil {
+				return fmt.Errorf("failed to look up client %s: %w", client.ID, err)
+			}
+			switch {
+			case existing != nil:
+				result.Skipped = append(result.Skipped, LegacySkip{Kind: "client", ID: client.ID, Reason: "already in the store"})
+				continue
+			case len(client.RedirectURIs) == 0:
+				result.Skipped = append(result.Skipped, LegacySkip{Kind: "client", ID: client.ID, Reason: "has no redirect URIs"})
+				continue
+			}
+			if apply {
+				if err := tx.CreateClient(client); err != nil {
+					return fmt.Errorf("failed to create client %s: %w", client.ID, err)
+				}
+			}
+			result.MigratedClients++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// legacyUserConflict returns why user cannot be migrated into store, or ""
+func legacyUserConflict(store storage.Storage, user *models.User, taken map[string]bool) (string, error) {
+	switch {
+	case user.Username == "":
+		return "has no username", nil
+	case taken["username:"+user.Username]:
+		return "username is taken by another legacy user", nil
+	case user.Email != "" && taken["email:"+strings.ToLower(user.Email)]:
+		return "email address is taken by another legacy user", nil
+	}
+
+	existing, err := store.GetUserByID(user.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up user %s: %w", user.ID, err)
+	}
+	if existing != nil {
+		return "already in the store", nil
+	}
+	if existing, err = store.GetUserByUsername(user.Username); err != nil {
+		return "", fmt.Errorf("failed to look up username %s: %w", user.Username, err)
+	}
+	if existing != nil {
+		return "username is taken by user " + existing.ID, nil
+	}
+	if user.Email != "" {
+		if existing, err = store.GetUserByEmail(user.Email); err != nil {
+			return "", fmt.Errorf("failed to look up email address of user %s: %w", user.ID, err)
+		}
+		if existing != nil {
+			return "email address is taken by user " + existing.ID, nil
+		}
+	}
+	return "", nil
+}
+
+// readLegacyUsers reads the users table of a legacy database
+func readLegacyUsers(db *sql.DB) ([]*models.User, error) {
+	rows, err := readLegacyTable(db, "users")
+	if err != nil {
+		return nil, err
+	}
+	users := make([]*models.User, 0, len(rows))
+	for _, row := range rows {
+		user := &models.User{
+			ID:           row.text("id"),
+			Username:     row.text("username"),
+			Email:        row.text("email"),
+			PasswordHash: row.text("password_hash"),
+			Role:         models.RoleUser,
+			Name:         row.text("name"),
+			GivenName:    row.text("given_name"),
+			FamilyName:   row.text("family_name"),
+			Picture:      row.text("picture"),
+			CreatedAt:    row.time("created_at"),
+			UpdatedAt:    row.time("updated_at"),
+		}
+		if user.CreatedAt.IsZero() {
+			user.CreatedAt = time.Now()
+		}
+		if user.UpdatedAt.IsZero() {
+			user.UpdatedAt = user.CreatedAt
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// readLegacyClients reads the clients table of a legacy database
+func readLegacyClients(db *sql.DB) ([]*models.Client, error) {
+	rows, err := readLegacyTable(db, "clients")
+	if err != nil {
+		return nil, err
+	}
+	clients := make([]*models.Client, 0, len(rows))
+	for _, row := range rows {
+		client := &models.Client{
+			ID:                       row.text("id", "client_id"),
+			Secret:                   row.text("secret", "client_secret"),
+			ClientName:               row.text("name", "client_name"),
+			RedirectURIs:             row.list("redirect_uris"),
+			GrantTypes:               row.list("grant_types"),
+			ResponseTypes:            row.list("response_types"),
+			Scope:                    strings.Join(row.list("scope"), " "),
+			ApplicationType:          "web",
+			SubjectType:              "public",
+			TokenEndpointAuthMethod:  "client_secret_basic",
+			IDTokenSignedResponseAlg: "RS256",
+			CreatedAt:                row.time("created_at"),
+		}
+		client.Name = client.ClientName // Legacy compatibility
+		if client.Secret == "" {
+			client.TokenEndpointAuthMethod = "none"
+		}
+		if len(client.GrantTypes) == 0 {
+			client.GrantTypes = []string{"authorization_code", "refresh_token"}
+		}
+		if len(client.ResponseTypes) == 0 {
+			client.ResponseTypes = []string{"code"}
+		}
+		if client.Scope == "" {
+			client.Scope = "openid profile email"
+		}
+		if client.CreatedAt.IsZero() {
+			client.CreatedAt = time.Now()
+		}
+		client.UpdatedAt = client.CreatedAt
+		client.ClientIDIssuedAt = client.CreatedAt.Unix()
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// legacyRow is one row of a legacy table, keyed by lowercased column name
+type legacyRow map[string]interface{}
+
+// readLegacyTable reads every row of table. Columns are discovered rather than
+// assumed, since databases created by different versions of the old storage layer
+// differ; a missing table reads as empty.
+func readLegacyTable(db *sql.DB, table string) ([]legacyRow, error) {
+	var exists int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, nil
+	}
+
+	rows, err := db.Query(`SELECT * FROM "` + table + `"`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []legacyRow
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make(legacyRow, len(columns))
+		for i, column := range columns {
+			row[strings.ToLower(column)] = values[i]
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// text returns the first of columns the row has a value for, as a string
+func (r legacyRow) text(columns ...string) string {
+	for _, column := range columns {
+		switch v := r[column].(type) {
+		case string:
+			return strings.TrimSpace(v)
+		case []byte:
+			return strings.TrimSpace(string(v))
+		case int64:
+			return fmt.Sprint(v)
+		}
+	}
+	return ""
+}
+
+// list reads a column holding several values: a JSON array, or values separated
+// by commas or whitespace
+func (r legacyRow) list(column string) []string {
+	s := r.text(column)
+	if strings.HasPrefix(s, "[") {
+		var values []string
+		if err := json.Unmarshal([]byte(s), &values); err == nil {
+			return values
+		}
+	}
+	return strings.FieldsFunc(s, func(c rune) bool {
+		return c == ',' || c == ' ' || c == '\t' || c == '\n'
+	})
+}
+
+// legacyTimeLayouts are the ways the old storage layer and SQLite itself wrote timestamps
+var legacyTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// time reads a timestamp column: a DATETIME the driver already parsed, text in one
+// of legacyTimeLayouts, or Unix seconds. It returns the zero time otherwise.
+func (r legacyRow) time(column string) time.Time {
+	switch v := r[column].(type) {
+	case time.Time:
+		return v.UTC()
+	case int64:
+		return time.Unix(v, 0).UTC()
+	}
+	s := r.text(column)
+	for _, layout := range legacyTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
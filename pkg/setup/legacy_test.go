@@ -0,0 +1,94 @@
+//go:build cgo
+
+package setup
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+func TestMigrateLegacySQLite(t *testing.T) {
+	db, err := OpenLegacySQLite(filepath.Join("testdata", "legacy.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	store, err := storage.NewJSONStorage(filepath.Join(t.TempDir(), "store.json"))
+	require.NoError(t, err)
+	require.NoError(t, store.CreateUser(&models.User{ID: "existing", Username: "erin", Email: "taken@example.com"}))
+
+	// A dry run changes nothing
+	want := &LegacyMigrationResult{
+		Users:           4,
+		Clients:         3,
+		MigratedUsers:   3,
+		MigratedClients: 2,
+		Skipped: []LegacySkip{
+			{Kind: "user", ID: "6f1c2a10-0000-4000-8000-000000000004", Reason: "email address is taken by user existing"},
+			{Kind: "client", ID: "no-redirects", Reason: "has no redirect URIs"},
+		},
+	}
+	result, err := MigrateLegacySQLite(db, store, false)
+	require.NoError(t, err)
+	assert.Equal(t, want, result)
+	user, err := store.GetUserByUsername("alice")
+	require.NoError(t, err)
+	assert.Nil(t, user)
+
+	result, err = MigrateLegacySQLite(db, store, true)
+	require.NoError(t, err)
+	assert.Equal(t, want, result)
+
+	alice, err := store.GetUserByUsername("alice")
+	require.NoError(t, err)
+	require.NotNil(t, alice)
+	assert.Equal(t, "6f1c2a10-0000-4000-8000-000000000001", alice.ID)
+	assert.Equal(t, models.RoleUser, alice.Role)
+	assert.Equal(t, "Alice Liddell", alice.Name)
+	assert.Equal(t, "Liddell", alice.FamilyName)
+	assert.Equal(t, "https://example.com/alice.png", alice.Picture)
+	assert.True(t, crypto.ValidatePassword("legacy-password", alice.PasswordHash), "users keep their passwords")
+
+	// Timestamps in each format the old storage layer left behind
+	for username, created := range map[string]time.Time{
+		"alice": time.Date(2024, 1, 15, 10, 30, 0, 5e8, time.UTC),
+		"bob":   time.Date(2024, 2, 1, 8, 0, 0, 0, time.UTC),
+		"carol": time.Unix(1709280000, 0).UTC(),
+	} {
+		user, err := store.GetUserByUsername(username)
+		require.NoError(t, err)
+		require.NotNil(t, user, username)
+		assert.True(t, created.Equal(user.CreatedAt), "%s created %v", username, user.CreatedAt)
+	}
+
+	client, err := store.GetClientByID("test-client")
+	require.NoError(t, err)
+	require.NotNil(t, client)
+	assert.Equal(t, "test-secret", client.Secret)
+	assert.Equal(t, "Test Client", client.ClientName)
+	assert.Equal(t, []string{"http://localhost:3000/callback"}, client.RedirectURIs)
+	assert.Equal(t, []string{"authorization_code", "refresh_token"}, client.GrantTypes)
+	assert.Equal(t, "openid profile email", client.Scope)
+	cli, err := store.GetClientByID("cli-app")
+	require.NoError(t, err)
+	require.NotNil(t, cli)
+	assert.Equal(t, []string{"http://127.0.0.1:8085/cb", "http://localhost:8085/cb"}, cli.RedirectURIs)
+	assert.Equal(t, []string{"authorization_code"}, cli.GrantTypes)
+	assert.Equal(t, []string{"code"}, cli.ResponseTypes)
+	assert.Equal(t, "client_secret_basic", cli.TokenEndpointAuthMethod)
+
+	// Running again skips what was already copied
+	result, err = MigrateLegacySQLite(db, store, true)
+	require.NoError(t, err)
+	assert.Zero(t, result.MigratedUsers)
+	assert.Zero(t, result.MigratedClients)
+	assert.Len(t, result.Skipped, 7)
+	assert.Contains(t, result.Skipped, LegacySkip{Kind: "client", ID: "test-client", Reason: "already in the store"})
+}
@@ -0,0 +1,249 @@
+package setup
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+// MaxSeedRecords caps each count of SeedOptions
+const MaxSeedRecords = 100000
+
+// DefaultSeed is used when SeedOptions.Seed is 0
+const DefaultSeed = 42
+
+// SeedOptions sizes the synthetic data made by Seed. The same options always
+// produce the same IDs, names and secrets, so a data set can be reproduced on
+// another machine; timestamps are spread over the 90 days before the run.
+type SeedOptions struct {
+	Users    int   `json:"users"`
+	Clients  int   `json:"clients"`
+	Consents int   `json:"consents"` // At most Users × Clients
+	Tokens   int   `json:"tokens"`
+	Seed     int64 `json:"seed,omitempty"`
+}
+
+// SeedResult counts the records Seed created; records left by an earlier run are not counted
+type SeedResult struct {
+	Users    int `json:"users"`
+	Clients  int `json:"clients"`
+	Consents int `json:"consents"`
+	Tokens   int `json:"tokens"`
+}
+
+// Empty reports whether no synthetic data was asked for
+func (o SeedOptions) Empty() bool {
+	return o.Users == 0 && o.Clients == 0 && o.Consents == 0 && o.Tokens == 0
+}
+
+// Validate checks the counts
+func (o SeedOptions) Validate() error {
+	for name, n := range map[string]int{"users": o.Users, "clients": o.Clients, "consents": o.Consents, "tokens": o.Tokens} {
+		if n < 0 || n > MaxSeedRecords {
+			return fmt.Errorf("%s must be between 0 and %d", name, MaxSeedRecords)
+		}
+	}
+	if (o.Consents > 0 || o.Tokens > 0) && (o.Users == 0 || o.Clients == 0) {
+		return fmt.Errorf("consents and tokens need at least one user and one client")
+	}
+	if o.Consents > o.Users*o.Clients {
+		return fmt.Errorf("consents must not exceed users × clients (%d)", o.Users*o.Clients)
+	}
+	return nil
+}
+
+var (
+	seedGivenNames  = []string{"Ava", "Ben", "Chloe", "Daniel", "Emma", "Farid", "Grace", "Hiro", "Isla", "Jonas", "Kavya", "Liam", "Maya", "Noah", "Olivia", "Priya", "Quinn", "Rosa", "Sven", "Tara", "Umar", "Vera", "Wei", "Yara", "Zoe"}
+	seedFamilyNames = []string{"Adams", "Bauer", "Chen", "Diaz", "Eriksen", "Fischer", "Garcia", "Haddad", "Ito", "Jensen", "Kowalski", "Lopez", "Martin", "Nguyen", "Okafor", "Patel", "Rossi", "Schmidt", "Tanaka", "Usman", "Varga", "Weber", "Yilmaz", "Zhang"}
+	seedLocales     = []string{"en-US", "en-GB", "de-DE", "fr-FR", "es-ES", "ja-JP", "hi-IN", "pt-BR"}
+	seedZones       = []string{"America/New_York", "Europe/London", "Europe/Berlin", "Europe/Paris", "Europe/Madrid", "Asia/Tokyo", "Asia/Kolkata", "America/Sao_Paulo"}
+	seedAppWords    = []string{"Acme", "Blue", "Cloud", "Data", "Echo", "Fleet", "Green", "Harbor", "Inventory", "Jet", "Kite", "Ledger", "Metro", "North", "Orbit", "Pixel"}
+	seedAppKinds    = []string{"Portal", "Dashboard", "Mobile", "CRM", "Analytics", "Billing", "Helpdesk", "Wiki"}
+	seedScopeSets   = [][]string{{"openid"}, {"openid", "profile"}, {"openid", "email"}, {"openid", "profile", "email"}}
+)
+
+// Seed fills store with synthetic users, clients, consents and tokens for load
+// testing and paging through the admin UI. Records that already exist are kept,
+// so running Seed twice with the same options creates nothing new. Every
+// synthetic user shares the DemoPassword so seeding does not hash one password
+// per user.
+func Seed(store storage.Storage, opts SeedOptions) (*SeedResult, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	seed := opts.Seed
+	if seed == 0 {
+		seed = DefaultSeed
+	}
+	rng := rand.New(rand.NewSource(seed)) // #nosec G404 -- reproducible test data, not secrets
+	now := time.Now()
+	result := &SeedResult{}
+
+	passwordHash := ""
+	if opts.Users > 0 {
+		var err error
+		if passwordHash, err = crypto.HashPassword(DemoPassword); err != nil {
+			return nil, fmt.Errorf("failed to hash password: %w", err)
+		}
+	}
+
+	users := make([]*models.User, opts.Users)
+	for i := range users {
+		user := seedUser(rng, i, passwordHash, now)
+		existing, err := store.GetUserByID(user.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up seed user: %w", err)
+		}
+		if existing == nil {
+			if err := store.CreateUser(user); err != nil {
+				return nil, fmt.Errorf("failed to create seed user: %w", err)
+			}
+			result.Users++
+		}
+		users[i] = user
+	}
+
+	clients := make([]*models.Client, opts.Clients)
+	for i := range clients {
+		client := seedClient(rng, i, now)
+		existing, err := store.GetClientByID(client.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up seed client: %w", err)
+		}
+		if existing == nil {
+			if err := store.CreateClient(client); err != nil {
+				return nil, fmt.Errorf("failed to create seed client: %w", err)
+			}
+			result.Clients++
+		}
+		clients[i] = client
+	}
+
+	// Walk the user × client grid so every consent is for a different pair
+	for i := 0; i < opts.Consents; i++ {
+		user := users[i%len(users)]
+		client := clients[(i/len(users)+i%len(users))%len(clients)]
+		consent := &models.Consent{
+			ID:        seedUUID(rng),
+			UserID:    user.ID,
+			ClientID:  client.ID,
+			Scopes:    seedScopeSets[rng.Intn(len(seedScopeSets))],
+			CreatedAt: seedTime(rng, now),
+		}
+		consent.UpdatedAt = consent.CreatedAt
+		existing, err := store.GetConsent(user.ID, client.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up seed consent: %w", err)
+		}
+		if existing == nil {
+			if err := store.CreateConsent(consent); err != nil {
+				return nil, fmt.Errorf("failed to create seed consent: %w", err)
+			}
+			result.Consents++
+		}
+	}
+
+	existingTokens := make(map[string]bool)
+	if opts.Tokens > 0 {
+		for _, client := range clients {
+			tokens, err := store.ListTokens(client.ID, "", false)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list seed tokens: %w", err)
+			}
+			for _, token := range tokens {
+				existingTokens[token.ID] = true
+			}
+		}
+	}
+	for i := 0; i < opts.Tokens; i++ {
+		token := seedToken(rng, users[rng.Intn(len(users))], clients[rng.Intn(len(clients))], now)
+		if existingTokens[token.ID] {
+			continue
+		}
+		if err := store.CreateToken(token); err != nil {
+			return nil, fmt.Errorf("failed to create seed token: %w", err)
+		}
+		result.Tokens++
+	}
+
+	return result, nil
+}
+
+func seedUser(rng *rand.Rand, i int, passwordHash string, now time.Time) *models.User {
+	given := seedGivenNames[rng.Intn(len(seedGivenNames))]
+	family := seedFamilyNames[rng.Intn(len(seedFamilyNames))]
+	locale := rng.Intn(len(seedLocales))
+	username := fmt.Sprintf("%s.%s%d", strings.ToLower(given), strings.ToLower(family), i+1)
+	createdAt := seedTime(rng, now)
+	return &models.User{
+		ID:                seedUUID(rng),
+		Username:          username,
+		Email:             username + "@example.com",
+		EmailVerified:     rng.Intn(4) != 0,
+		PasswordHash:      passwordHash,
+		Role:              models.RoleUser,
+		Name:              given + " " + family,
+		GivenName:         given,
+		FamilyName:        family,
+		PreferredUsername: username,
+		Locale:            seedLocales[locale],
+		Zoneinfo:          seedZones[locale],
+		CreatedAt:         createdAt,
+		UpdatedAt:         createdAt,
+	}
+}
+
+func seedClient(rng *rand.Rand, i int, now time.Time) *models.Client {
+	name := fmt.Sprintf("%s %s %d", seedAppWords[rng.Intn(len(seedAppWords))], seedAppKinds[rng.Intn(len(seedAppKinds))], i+1)
+	slug := strings.ToLower(strings.ReplaceAll(name, " ", "-"))
+	client := models.NewClient(name, []string{"https://" + slug + ".example.com/callback"})
+	client.ID = seedUUID(rng)
+	client.Secret = seedHex(rng, 32)
+	client.CreatedAt = seedTime(rng, now)
+	client.UpdatedAt = client.CreatedAt
+	client.ClientIDIssuedAt = client.CreatedAt.Unix()
+	return client
+}
+
+// seedToken issues an access token, most of them still valid, created some time in the last 90 days
+func seedToken(rng *rand.Rand, user *models.User, client *models.Client, now time.Time) *models.Token {
+	scopes := seedScopeSets[rng.Intn(len(seedScopeSets))]
+	expiresAt := now.Add(time.Duration(rng.Intn(24*60)) * time.Minute)
+	if rng.Intn(4) == 0 {
+		expiresAt = now.Add(-time.Duration(rng.Intn(90*24)) * time.Hour)
+	}
+	return &models.Token{
+		ID:           seedUUID(rng),
+		AccessToken:  seedHex(rng, 32),
+		RefreshToken: seedHex(rng, 32),
+		TokenType:    "Bearer",
+		ClientID:     client.ID,
+		UserID:       user.ID,
+		Scope:        strings.Join(scopes, " "),
+		ExpiresAt:    expiresAt,
+		CreatedAt:    expiresAt.Add(-time.Hour),
+	}
+}
+
+// seedTime returns a time in the 90 days before now
+func seedTime(rng *rand.Rand, now time.Time) time.Time {
+	return now.Add(-time.Duration(rng.Int63n(int64(90 * 24 * time.Hour)))).Truncate(time.Second)
+}
+
+func seedUUID(rng *rand.Rand) string {
+	return uuid.Must(uuid.NewRandomFromReader(rng)).String()
+}
+
+func seedHex(rng *rand.Rand, n int) string {
+	b := make([]byte, n)
+	_, _ = rng.Read(b)
+	return hex.EncodeToString(b)
+}
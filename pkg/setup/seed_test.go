@@ -0,0 +1,60 @@
+package setup
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+func seededUserIDs(t *testing.T, store storage.Storage) []string {
+	users, err := store.GetAllUsers()
+	require.NoError(t, err)
+	ids := make([]string, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestSeedIsDeterministic(t *testing.T) {
+	opts := SeedOptions{Users: 20, Clients: 4, Consents: 80, Tokens: 50, Seed: 7}
+
+	first, err := storage.NewJSONStorage(filepath.Join(t.TempDir(), "a.json"))
+	require.NoError(t, err)
+	result, err := Seed(first, opts)
+	require.NoError(t, err)
+	assert.Equal(t, &SeedResult{Users: 20, Clients: 4, Consents: 80, Tokens: 50}, result)
+
+	// Seeding again finds every record in place
+	result, err = Seed(first, opts)
+	require.NoError(t, err)
+	assert.Equal(t, &SeedResult{}, result)
+
+	// Another store seeded with the same options holds the same records
+	second, err := storage.NewJSONStorage(filepath.Join(t.TempDir(), "b.json"))
+	require.NoError(t, err)
+	_, err = Seed(second, opts)
+	require.NoError(t, err)
+	assert.Equal(t, seededUserIDs(t, first), seededUserIDs(t, second))
+
+	// A different seed gives different records
+	opts.Seed = 8
+	result, err = Seed(second, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 20, result.Users)
+}
+
+func TestSeedOptionsValidate(t *testing.T) {
+	assert.NoError(t, SeedOptions{}.Validate())
+	assert.Error(t, SeedOptions{Users: -1}.Validate())
+	assert.Error(t, SeedOptions{Users: MaxSeedRecords + 1}.Validate())
+	assert.Error(t, SeedOptions{Users: 10, Tokens: 5}.Validate())
+	assert.Error(t, SeedOptions{Users: 2, Clients: 2, Consents: 5}.Validate())
+	assert.NoError(t, SeedOptions{Users: 2, Clients: 2, Consents: 4, Tokens: 100}.Validate())
+}
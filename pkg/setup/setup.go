@@ -0,0 +1,340 @@
+// Package setup holds the installation steps shared by the setup command and the
+// setup wizard API. A Wizard builds the configuration in memory, one idempotent
+// step at a time, and only writes it to the config store on Complete.
+package setup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+const (
+	// jwtKeyBits matches the key size of configstore.InitializeMinimalConfig
+	jwtKeyBits = 4096
+
+	// MinPasswordLength is the shortest admin password accepted
+	MinPasswordLength = 6
+
+	// Demo data matching the defaults of examples/test-client.go
+	DemoClientID        = "demo-client"
+	DemoClientRedirect  = "http://localhost:9090/callback"
+	DemoUsername        = "testuser"
+	DemoPassword        = "password123"
+	demoClientName      = "Demo Client"
+	demoUserEmail       = "testuser@example.com"
+	demoUserDisplayName = "Test User"
+)
+
+// ErrUserExists is returned when the admin username belongs to a user who is not an administrator
+var ErrUserExists = errors.New("a non-admin user with this username already exists")
+
+// Status reports which steps have been completed
+type Status struct {
+	Initialized bool   `json:"initialized"` // A configuration has been written to the config store
+	Issuer      string `json:"issuer,omitempty"`
+	Keys        bool   `json:"keys"`
+	Storage     string `json:"storage"` // Storage type that will be used
+	Admin       bool   `json:"admin"`
+	DemoData    bool   `json:"demo_data"`
+}
+
+// DemoData describes the demo client and user created by CreateDemoData
+type DemoData struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RedirectURI  string `json:"redirect_uri"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+
+	Seeded *SeedResult `json:"seeded,omitempty"` // Synthetic records added alongside the demo data
+}
+
+// Wizard carries a configuration through the setup steps
+type Wizard struct {
+	configStore configstore.ConfigStore
+
+	mu       sync.Mutex
+	config   *configstore.ConfigData
+	admin    bool
+	demoData bool
+}
+
+// NewWizard starts from the default configuration. The storage backend follows the
+// config store: a MongoDB config store keeps its data in the same database.
+func NewWizard(configStore configstore.ConfigStore) *Wizard {
+	config := configstore.DefaultConfig()
+	if mongoStore, ok := configStore.(*configstore.MongoConfigStore); ok {
+		config.Storage = mongoStore.StorageBackend()
+	}
+	return &Wizard{configStore: configStore, config: config}
+}
+
+// Status returns the progress of the setup
+func (w *Wizard) Status(ctx context.Context) (Status, error) {
+	initialized, err := w.configStore.IsInitialized(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return Status{
+		Initialized: initialized,
+		Issuer:      w.config.Issuer,
+		Keys:        w.config.JWT.PrivateKey != "",
+		Storage:     w.config.Storage.Type,
+		Admin:       w.admin,
+		DemoData:    w.demoData,
+	}, nil
+}
+
+// SetIssuer sets the issuer URL
+func (w *Wizard) SetIssuer(issuer string) error {
+	u, err := url.Parse(issuer)
+	if issuer == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("issuer must be an absolute http or https URL")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.config.Issuer = issuer
+	return nil
+}
+
+// GenerateKeys creates the JWT signing key pair. Keys that were already generated
+// are kept unless rotate is set. It reports whether a new pair was made.
+func (w *Wizard) GenerateKeys(rotate bool) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.config.JWT.PrivateKey != "" && !rotate {
+		return false, nil
+	}
+	privateKey, publicKey, err := configstore.GenerateJWTKeyPair(jwtKeyBits)
+	if err != nil {
+		return false, fmt.Errorf("failed to generate JWT keys: %w", err)
+	}
+	w.config.JWT.PrivateKey = privateKey
+	w.config.JWT.PublicKey = publicKey
+	return true, nil
+}
+
+// PublicKey returns the PEM encoded public key, or "" before GenerateKeys
+func (w *Wizard) PublicKey() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.config.JWT.PublicKey
+}
+
+// ConfigureStorage selects the storage backend after checking that it can be opened
+func (w *Wizard) ConfigureStorage(backend configstore.StorageBackendConfig) error {
+	switch backend.Type {
+	case "json":
+		if backend.JSONFilePath == "" {
+			backend.JSONFilePath = configstore.DefaultConfig().Storage.JSONFilePath
+		}
+	case "mongodb":
+		if backend.MongoURI == "" {
+			return fmt.Errorf("mongo_uri is required for MongoDB storage")
+		}
+		if backend.MongoDatabase == "" {
+			backend.MongoDatabase = "openid"
+		}
+	case "etcd":
+		if len(backend.EtcdEndpoints) == 0 {
+			return fmt.Errorf("etcd_endpoints is required for etcd storage")
+		}
+	case "":
+		return fmt.Errorf("storage type is required")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	candidate := *w.config
+	candidate.Storage = backend
+	store, err := storage.NewStorage(&candidate)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	_ = store.Close()
+
+	w.config.Storage = backend
+	return nil
+}
+
+// CreateAdmin creates the first administrator. Running it again for the same
+// username resets that administrator's password. It reports whether a user was created.
+func (w *Wizard) CreateAdmin(username, password string) (bool, error) {
+	if username == "" || password == "" {
+		return false, fmt.Errorf("admin username and password are required")
+	}
+	if len(password) < MinPasswordLength {
+		return false, fmt.Errorf("admin password must be at least %d characters long", MinPasswordLength)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	created, err := w.withStorage(func(store storage.Storage) (bool, error) {
+		return CreateAdminUser(store, username, password)
+	})
+	if err == nil {
+		w.admin = true
+	}
+	return created, err
+}
+
+// CreateDemoData adds a demo OAuth client and a regular user for trying out sign-in,
+// plus the synthetic records sized by seed. Existing demo records are reused, but
+// the client secret is always returned.
+func (w *Wizard) CreateDemoData(seed SeedOptions) (*DemoData, error) {
+	if err := seed.Validate(); err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var demo *DemoData
+	_, err := w.withStorage(func(store storage.Storage) (bool, error) {
+		var err error
+		if demo, err = CreateDemoData(store); err != nil {
+			return false, err
+		}
+		if !seed.Empty() {
+			demo.Seeded, err = Seed(store, seed)
+		}
+		return true, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	w.demoData = true
+	return demo, nil
+}
+
+// Complete writes the configuration to the config store. The issuer must be set;
+// keys are generated if that step was skipped.
+func (w *Wizard) Complete(ctx context.Context) error {
+	w.mu.Lock()
+	if w.config.Issuer == "" {
+		w.mu.Unlock()
+		return fmt.Errorf("issuer URL is required")
+	}
+	w.mu.Unlock()
+
+	if _, err := w.GenerateKeys(false); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.config.UpdatedAt = time.Now()
+	if err := w.configStore.SaveConfig(ctx, w.config); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	return nil
+}
+
+// withStorage opens the configured storage for one step. It must be called with w.mu held.
+func (w *Wizard) withStorage(fn func(store storage.Storage) (bool, error)) (bool, error) {
+	store, err := storage.NewStorage(w.config)
+	if err != nil {
+		return false, fmt.Errorf("failed to initialize storage: %w", err)
+	}
+	defer func() {
+		_ = store.Close() // Best effort close
+	}()
+	return fn(store)
+}
+
+// CreateAdminUser creates an administrator in store, or resets the password of the
+// administrator with that username. It reports whether a user was created.
+func CreateAdminUser(store storage.Storage, username, password string) (bool, error) {
+	hashedPassword, err := crypto.HashPassword(password)
+	if err != nil {
+		return false, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	existing, err := store.GetUserByUsername(username)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up user: %w", err)
+	}
+	if existing != nil {
+		if existing.Role != models.RoleAdmin {
+			return false, ErrUserExists
+		}
+		existing.PasswordHash = hashedPassword
+		existing.UpdatedAt = time.Now()
+		if err := store.UpdateUser(existing); err != nil {
+			return false, fmt.Errorf("failed to update admin user: %w", err)
+		}
+		return false, nil
+	}
+
+	adminUser := &models.User{
+		ID:           uuid.New().String(),
+		Username:     username,
+		PasswordHash: hashedPassword,
+		Email:        username + "@local",
+		Role:         models.RoleAdmin,
+		Name:         "Administrator",
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+	if err := store.CreateUser(adminUser); err != nil {
+		return false, fmt.Errorf("failed to create admin user: %w", err)
+	}
+	return true, nil
+}
+
+// CreateDemoData creates the demo client and user in store unless they exist
+func CreateDemoData(store storage.Storage) (*DemoData, error) {
+	client, err := store.GetClientByID(DemoClientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up demo client: %w", err)
+	}
+	if client == nil {
+		client = models.NewClient(demoClientName, []string{DemoClientRedirect})
+		client.ID = DemoClientID
+		if err := store.CreateClient(client); err != nil {
+			return nil, fmt.Errorf("failed to create demo client: %w", err)
+		}
+	}
+
+	user, err := store.GetUserByUsername(DemoUsername)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up demo user: %w", err)
+	}
+	if user == nil {
+		hashedPassword, err := crypto.HashPassword(DemoPassword)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash password: %w", err)
+		}
+		user = models.NewRegularUser(DemoUsername, demoUserEmail, hashedPassword)
+		user.Name = demoUserDisplayName
+		if err := store.CreateUser(user); err != nil {
+			return nil, fmt.Errorf("failed to create demo user: %w", err)
+		}
+	}
+
+	return &DemoData{
+		ClientID:     client.ID,
+		ClientSecret: client.Secret,
+		RedirectURI:  DemoClientRedirect,
+		Username:     DemoUsername,
+		Password:     DemoPassword,
+	}, nil
+}
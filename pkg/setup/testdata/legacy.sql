@@ -0,0 +1,96 @@
+-- Fixture for legacy_test.go: a database created by the old internal/storage
+-- SQLite layer. Regenerate legacy.db with:
+--   rm -f legacy.db && sqlite3 legacy.db < legacy.sql
+-- Every user's password is "legacy-password".
+
+CREATE TABLE users (
+    id TEXT PRIMARY KEY,
+    username TEXT UNIQUE NOT NULL,
+    email TEXT UNIQUE NOT NULL,
+    password_hash TEXT NOT NULL,
+    name TEXT,
+    given_name TEXT,
+    family_name TEXT,
+    picture TEXT,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE clients (
+    id TEXT PRIMARY KEY,
+    secret TEXT NOT NULL,
+    name TEXT NOT NULL,
+    redirect_uris TEXT NOT NULL,
+    grant_types TEXT NOT NULL,
+    response_types TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+CREATE TABLE authorization_codes (
+    code TEXT PRIMARY KEY,
+    client_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    redirect_uri TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    nonce TEXT,
+    code_challenge TEXT,
+    code_challenge_method TEXT,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+CREATE TABLE tokens (
+    id TEXT PRIMARY KEY,
+    access_token TEXT UNIQUE NOT NULL,
+    refresh_token TEXT UNIQUE,
+    token_type TEXT NOT NULL,
+    client_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+CREATE TABLE sessions (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+-- Timestamps as written by the Go driver, by SQLite's CURRENT_TIMESTAMP and as Unix seconds
+INSERT INTO users VALUES
+    ('6f1c2a10-0000-4000-8000-000000000001', 'alice', 'alice@example.com',
+     '$2a$04$fVX0auVEQrIBYohPEn1gkenV0aRTX0FDhi.W1KprqqjZs6dqvsc4G',
+     'Alice Liddell', 'Alice', 'Liddell', 'https://example.com/alice.png',
+     '2024-01-15 10:30:00.5+00:00', '2024-03-01 09:00:00+00:00'),
+    ('6f1c2a10-0000-4000-8000-000000000002', 'bob', 'bob@example.com',
+     '$2a$04$fVX0auVEQrIBYohPEn1gkenV0aRTX0FDhi.W1KprqqjZs6dqvsc4G',
+     NULL, NULL, NULL, NULL,
+     '2024-02-01 08:00:00', '2024-02-01 08:00:00'),
+    ('6f1c2a10-0000-4000-8000-000000000003', 'carol', 'carol@example.com',
+     '$2a$04$fVX0auVEQrIBYohPEn1gkenV0aRTX0FDhi.W1KprqqjZs6dqvsc4G',
+     'Carol', NULL, NULL, '',
+     1709280000, 1709280000),
+    ('6f1c2a10-0000-4000-8000-000000000004', 'dave', 'taken@example.com',
+     '$2a$04$fVX0auVEQrIBYohPEn1gkenV0aRTX0FDhi.W1KprqqjZs6dqvsc4G',
+     'Dave', NULL, NULL, NULL,
+     '2024-02-02 08:00:00', '2024-02-02 08:00:00');
+
+-- List columns as JSON arrays and as separated values
+INSERT INTO clients VALUES
+    ('test-client', 'test-secret', 'Test Client',
+     '["http://localhost:3000/callback"]', '["authorization_code","refresh_token"]', '["code"]',
+     'openid profile email', '2024-01-10 12:00:00+00:00'),
+    ('cli-app', 'cli-secret', 'CLI',
+     'http://127.0.0.1:8085/cb, http://localhost:8085/cb', 'authorization_code', 'code',
+     'openid', '2024-01-11 12:00:00'),
+    ('no-redirects', 'secret', 'No Redirects',
+     '[]', '[]', '[]', '', '2024-01-12 12:00:00');
+
+INSERT INTO tokens VALUES
+    ('t1', 'legacy-access-token', 'legacy-refresh-token', 'Bearer', 'test-client',
+     '6f1c2a10-0000-4000-8000-000000000001', 'openid', '2024-03-01 10:00:00', '2024-03-01 09:00:00');
+INSERT INTO sessions VALUES
+    ('s1', '6f1c2a10-0000-4000-8000-000000000001', '2024-03-02 09:00:00', '2024-03-01 09:00:00');
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+)
+
+// Pinger is implemented by backends that can check their connection to the database
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Breaker states reported by BreakerStats
+const (
+	BreakerClosed = "closed"
+	BreakerOpen   = "open"
+)
+
+// Breaker is a circuit breaker for a storage backend. It probes the database in
+// the background and opens after a number of consecutive failed probes, so that
+// requests can be refused at once instead of each waiting for the database to
+// time out. The first successful probe closes it again.
+type Breaker struct {
+	pinger     Pinger
+	threshold  int
+	interval   time.Duration
+	timeout    time.Duration
+	retryAfter time.Duration
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+	trips    int
+	lastErr  string
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// BreakerStats is a snapshot of a Breaker for readiness probes and admin stats
+type BreakerStats struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Trips               int        `json:"trips"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+// NewBreaker creates a breaker for store as configured by cfg. It returns nil when
+// the breaker is disabled or the backend cannot be probed, as with JSON storage.
+func NewBreaker(store Storage, cfg configstore.StorageBreakerConfig) *Breaker {
+	pinger, ok := store.(Pinger)
+	if !cfg.Enabled || !ok {
+		return nil
+	}
+	b := &Breaker{
+		pinger:     pinger,
+		threshold:  cfg.FailureThreshold,
+		interval:   time.Duration(cfg.ProbeIntervalSeconds) * time.Second,
+		timeout:    time.Duration(cfg.ProbeTimeoutSeconds) * time.Second,
+		retryAfter: time.Duration(cfg.RetryAfterSeconds) * time.Second,
+		stop:       make(chan struct{}),
+	}
+	if b.threshold <= 0 {
+		b.threshold = 3
+	}
+	if b.interval <= 0 {
+		b.interval = 5 * time.Second
+	}
+	if b.timeout <= 0 {
+		b.timeout = 2 * time.Second
+	}
+	if b.retryAfter <= 0 {
+		b.retryAfter = b.interval
+	}
+	return b
+}
+
+// Start probes the database every probe interval until Stop is called
+func (b *Breaker) Start() {
+	go func() {
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				b.Probe()
+			case <-b.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends background probing
+func (b *Breaker) Stop() {
+	b.stopOnce.Do(func() { close(b.stop) })
+}
+
+// Probe pings the database once and updates the breaker state. Start calls it
+// every probe interval.
+func (b *Breaker) Probe() {
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	err := b.pinger.Ping(ctx)
+	cancel()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		if b.open {
+			log.Printf("Storage reachable again, closing circuit breaker after %s", time.Since(b.openedAt).Round(time.Second))
+		}
+		b.failures = 0
+		b.open = false
+		b.lastErr = ""
+		return
+	}
+
+	b.failures++
+	b.lastErr = err.Error()
+	if !b.open && b.failures >= b.threshold {
+		b.open = true
+		b.openedAt = time.Now()
+		b.trips++
+		log.Printf("Storage unreachable after %d probes, opening circuit breaker: %v", b.failures, err)
+	}
+}
+
+// Open reports whether requests needing storage should be refused. A nil breaker is never open.
+func (b *Breaker) Open() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
+
+// RetryAfter is how long clients are told to wait while the breaker is open
+func (b *Breaker) RetryAfter() time.Duration {
+	return b.retryAfter
+}
+
+// Stats returns the current state of the breaker
+func (b *Breaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := BreakerStats{
+		State:               BreakerClosed,
+		ConsecutiveFailures: b.failures,
+		Trips:               b.trips,
+		LastError:           b.lastErr,
+	}
+	if b.open {
+		stats.State = BreakerOpen
+		openedAt := b.openedAt
+		stats.OpenedAt = &openedAt
+	}
+	return stats
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+)
+
+// flakyStore is a storage backend whose database can be taken down
+type flakyStore struct {
+	*JSONStorage
+	down bool
+}
+
+func (s *flakyStore) Ping(context.Context) error {
+	if s.down {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestBreakerOpensAfterThresholdAndClosesOnRecovery(t *testing.T) {
+	store := &flakyStore{JSONStorage: newTestJSONStorage(t)}
+	b := NewBreaker(store, configstore.StorageBreakerConfig{Enabled: true, FailureThreshold: 2})
+	if b == nil {
+		t.Fatal("NewBreaker returned nil for a backend that can be pinged")
+	}
+
+	store.down = true
+	b.Probe()
+	if b.Open() {
+		t.Fatal("breaker opened before reaching the failure threshold")
+	}
+	b.Probe()
+	if !b.Open() {
+		t.Fatal("breaker still closed after reaching the failure threshold")
+	}
+	if stats := b.Stats(); stats.State != BreakerOpen || stats.Trips != 1 || stats.LastError == "" || stats.OpenedAt == nil {
+		t.Errorf("Stats() = %+v", stats)
+	}
+
+	store.down = false
+	b.Probe()
+	if b.Open() {
+		t.Error("breaker still open after a successful probe")
+	}
+	if stats := b.Stats(); stats.State != BreakerClosed || stats.ConsecutiveFailures != 0 {
+		t.Errorf("Stats() after recovery = %+v", stats)
+	}
+}
+
+func TestNewBreakerSkipsUnprobeableOrDisabled(t *testing.T) {
+	if b := NewBreaker(newTestJSONStorage(t), configstore.StorageBreakerConfig{Enabled: true}); b != nil {
+		t.Error("NewBreaker should return nil for JSON storage")
+	}
+	if b := NewBreaker(&flakyStore{JSONStorage: newTestJSONStorage(t)}, configstore.StorageBreakerConfig{}); b != nil {
+		t.Error("NewBreaker should return nil when disabled")
+	}
+	var b *Breaker
+	if b.Open() {
+		t.Error("a nil breaker should never be open")
+	}
+}
+
+func newTestJSONStorage(t *testing.T) *JSONStorage {
+	t.Helper()
+	store, err := NewJSONStorage(filepath.Join(t.TempDir(), "data.json"))
+	if err != nil {
+		t.Fatalf("NewJSONStorage failed: %v", err)
+	}
+	return store
+}
@@ -0,0 +1,1664 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// EtcdStorage implements Storage on etcd, for highly available deployments in
+// clusters that already run it. Each record is a key under the configured prefix,
+// such as /openid/clients/<id>, holding the record as MongoDB extended JSON so it
+// keeps the same fields as the MongoDB backend. The keyspace is mirrored in memory
+// and kept current by a watch, so reads are served locally and every replica sees
+// the others' writes as soon as etcd delivers them. Records that expire, such as
+// authorization codes and sessions, are attached to leases and removed by etcd.
+type EtcdStorage struct {
+	db *etcdDB
+	tx *etcdTx // Set on the copy of the storage a transaction runs against
+}
+
+// etcdDB is the connection and in-memory mirror shared by an EtcdStorage and the
+// copies of it handed to transactions
+type etcdDB struct {
+	client *etcdClient
+	prefix string
+
+	mu         sync.RWMutex
+	records    map[string]map[string]*etcdRecord // Collection → ID → record
+	rev        int64                             // Revision the watch has caught up to
+	tombstones map[string]int64                  // Key → revision of a local delete the watch has not caught up to
+
+	leaseMu sync.Mutex
+	leases  map[int64]etcdLease // Expiry bucket (Unix seconds) → lease
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// etcdRecord is a decoded record and the revision it was last written at
+type etcdRecord struct {
+	value  interface{}
+	modRev int64
+}
+
+type etcdLease struct {
+	id      int64
+	expires time.Time
+}
+
+// Collections, each a directory under the prefix. Usernames and emails map to
+// user IDs so that two replicas cannot create users with the same name.
+const (
+	etcdUsers               = "users"
+	etcdUsernames           = "usernames"
+	etcdEmails              = "emails"
+	etcdClients             = "clients"
+	etcdCodes               = "authorization_codes"
+	etcdTokens              = "tokens"
+	etcdSessions            = "sessions"
+	etcdAuthSessions        = "auth_sessions"
+	etcdUserSessions        = "user_sessions"
+	etcdConsents            = "consents"
+	etcdConsentReceipts     = "consent_receipts"
+	etcdInitialAccessTokens = "initial_access_tokens"
+	etcdAPIKeys             = "api_keys"
+	etcdClientInstances     = "client_instances"
+	etcdDeviceCodes         = "device_authorizations"
+	etcdErrorReports        = "error_reports"
+	etcdSigningKeys         = "signing_keys"
+	etcdAuditLogs           = "audit_logs"
+	etcdAuditCheckpoints    = "audit_checkpoints"
+	etcdUsedJTIs            = "used_jtis"
+)
+
+// etcdTypes creates the value each collection's records decode into
+var etcdTypes = map[string]func() interface{}{
+	etcdUsers:               func() interface{} { return new(models.User) },
+	etcdUsernames:           func() interface{} { return new(etcdIndexEntry) },
+	etcdEmails:              func() interface{} { return new(etcdIndexEntry) },
+	etcdClients:             func() interface{} { return new(models.Client) },
+	etcdCodes:               func() interface{} { return new(models.AuthorizationCode) },
+	etcdTokens:              func() interface{} { return new(models.Token) },
+	etcdSessions:            func() interface{} { return new(models.Session) },
+	etcdAuthSessions:        func() interface{} { return new(models.AuthSession) },
+	etcdUserSessions:        func() interface{} { return new(models.UserSession) },
+	etcdConsents:            func() interface{} { return new(models.Consent) },
+	etcdConsentReceipts:     func() interface{} { return new(models.ConsentReceipt) },
+	etcdInitialAccessTokens: func() interface{} { return new(models.InitialAccessToken) },
+	etcdAPIKeys:             func() interface{} { return new(models.APIKey) },
+	etcdClientInstances:     func() interface{} { return new(models.ClientInstance) },
+	etcdDeviceCodes:         func() interface{} { return new(models.DeviceAuthorization) },
+	etcdErrorReports:        func() interface{} { return new(models.ErrorReport) },
+	etcdSigningKeys:         func() interface{} { return new(models.SigningKey) },
+	etcdAuditLogs:           func() interface{} { return new(models.AuditLog) },
+	etcdAuditCheckpoints:    func() interface{} { return new(models.AuditCheckpoint) },
+	etcdUsedJTIs:            func() interface{} { return new(models.UsedJTI) },
+}
+
+// etcdIndexEntry points from a unique value to the record holding it
+type etcdIndexEntry struct {
+	ID string `bson:"id"`
+}
+
+// etcdExpiry returns when etcd should drop a record, or the zero time to keep it.
+// These are the records the MongoDB backend expires with TTL indexes.
+func etcdExpiry(value interface{}) time.Time {
+	switch v := value.(type) {
+	case *models.AuthorizationCode:
+		return v.ExpiresAt
+	case *models.Session:
+		return v.ExpiresAt
+	case *models.AuthSession:
+		return v.ExpiresAt
+	case *models.UserSession:
+		return v.ExpiresAt
+	case *models.DeviceAuthorization:
+		return v.ExpiresAt
+	case *models.ErrorReport:
+		return v.ExpiresAt
+	case *models.UsedJTI:
+		return v.ExpiresAt
+	}
+	return time.Time{}
+}
+
+const (
+	// etcdCASRetries bounds how often a compare-and-set is retried after losing a race
+	etcdCASRetries = 5
+	// etcdMaxTxnOps stays under etcd's default limit of 128 operations per transaction
+	etcdMaxTxnOps = 100
+	// etcdLeaseBucket groups records expiring close together onto one lease
+	etcdLeaseBucket = time.Minute
+	// etcdMinLeaseTTL is the shortest lease granted, for records already expired
+	etcdMinLeaseTTL = 10 * time.Second
+)
+
+// errEtcdCompacted ends a watch whose start revision etcd has already compacted
+var errEtcdCompacted = errors.New("etcd watch revision compacted")
+
+// EtcdOptions configures NewEtcdStorage
+type EtcdOptions struct {
+	Endpoints []string // e.g. https://etcd-0.etcd:2379
+	Prefix    string   // Keys are stored under this prefix (default: /openid/)
+	Username  string
+	Password  string
+	CAFile    string        // CA bundle to verify the servers with
+	CertFile  string        // Client certificate for mutual TLS
+	KeyFile   string        // Key of CertFile
+	Timeout   time.Duration // Per request (default: 5s)
+}
+
+// NewEtcdStorage connects to etcd, loads the keyspace under opts.Prefix and starts
+// watching it for changes
+func NewEtcdStorage(opts EtcdOptions) (*EtcdStorage, error) {
+	client, err := newEtcdClient(etcdClientConfig{
+		Endpoints: opts.Endpoints,
+		Username:  opts.Username,
+		Password:  opts.Password,
+		CAFile:    opts.CAFile,
+		CertFile:  opts.CertFile,
+		KeyFile:   opts.KeyFile,
+		Timeout:   opts.Timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := opts.Prefix
+	if prefix == "" {
+		prefix = "/openid/"
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	db := &etcdDB{
+		client:     client,
+		prefix:     prefix,
+		records:    make(map[string]map[string]*etcdRecord),
+		tombstones: make(map[string]int64),
+		leases:     make(map[int64]etcdLease),
+		done:       make(chan struct{}),
+	}
+	if err := db.load(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to load data from etcd: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	db.cancel = cancel
+	go db.watch(ctx)
+	return &EtcdStorage{db: db}, nil
+}
+
+// Close stops watching etcd
+func (s *EtcdStorage) Close() error {
+	if s.tx != nil {
+		return nil
+	}
+	s.db.cancel()
+	<-s.db.done
+	return nil
+}
+
+// key returns the etcd key of the record id in coll
+func (d *etcdDB) key(coll, id string) string {
+	return d.prefix + coll + "/" + id
+}
+
+// splitKey returns the collection and ID of a key under the prefix
+func (d *etcdDB) splitKey(key []byte) (coll, id string, ok bool) {
+	rest, found := strings.CutPrefix(string(key), d.prefix)
+	if !found {
+		return "", "", false
+	}
+	coll, id, ok = strings.Cut(rest, "/")
+	if _, known := etcdTypes[coll]; !known {
+		return "", "", false
+	}
+	return coll, id, ok
+}
+
+func encodeEtcdValue(value interface{}) ([]byte, error) {
+	return bson.MarshalExtJSON(value, false, false)
+}
+
+func decodeEtcdValue(coll string, data []byte) (interface{}, error) {
+	value := etcdTypes[coll]()
+	if err := bson.UnmarshalExtJSON(data, false, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// load replaces the mirror with the keyspace as it is now
+func (d *etcdDB) load(ctx context.Context) error {
+	prefix := []byte(d.prefix)
+	records := make(map[string]map[string]*etcdRecord)
+	req := etcdRangeRequest{Key: prefix, RangeEnd: prefixEnd(prefix), Limit: 1000}
+	for {
+		resp, err := d.client.Range(ctx, req)
+		if err != nil {
+			return err
+		}
+		for _, kv := range resp.KVs {
+			coll, id, ok := d.splitKey(kv.Key)
+			if !ok {
+				continue
+			}
+			value, err := decodeEtcdValue(coll, kv.Value)
+			if err != nil {
+				log.Printf("Skipping unreadable etcd record %s: %v", kv.Key, err)
+				continue
+			}
+			if records[coll] == nil {
+				records[coll] = make(map[string]*etcdRecord)
+			}
+			records[coll][id] = &etcdRecord{value: value, modRev: int64(kv.ModRevision)}
+		}
+		// Later pages are read at the first page's revision, so the mirror is a snapshot
+		if req.Revision == 0 {
+			req.Revision = resp.Header.Revision
+		}
+		if !resp.More || len(resp.KVs) == 0 {
+			break
+		}
+		req.Key = append(resp.KVs[len(resp.KVs)-1].Key, 0)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.records = records
+	d.rev = int64(req.Revision)
+	d.tombstones = make(map[string]int64)
+	return nil
+}
+
+// watch applies the changes etcd reports until the storage is closed, resuming
+// after the last revision seen when the stream breaks
+func (d *etcdDB) watch(ctx context.Context) {
+	defer close(d.done)
+	backoff := time.Second
+	for {
+		d.mu.RLock()
+		start := d.rev + 1
+		d.mu.RUnlock()
+
+		err := d.client.Watch(ctx, []byte(d.prefix), start, func(resp *etcdWatchResponse) error {
+			backoff = time.Second
+			return d.applyWatch(resp)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errEtcdCompacted) {
+			// Changes were missed, so start over from a fresh copy
+			log.Printf("etcd watch fell behind compaction, reloading storage")
+			if err = d.load(ctx); err == nil {
+				continue
+			}
+		}
+		log.Printf("etcd watch interrupted, resuming in %s: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+func (d *etcdDB) applyWatch(resp *etcdWatchResponse) error {
+	result := resp.Result
+	if result.Canceled {
+		if result.CompactRevision > 0 {
+			return errEtcdCompacted
+		}
+		return fmt.Errorf("etcd watch canceled: %s", result.CancelReason)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, ev := range result.Events {
+		if ev.KV == nil {
+			continue
+		}
+		coll, id, ok := d.splitKey(ev.KV.Key)
+		rev := int64(ev.KV.ModRevision)
+		if ok {
+			var value interface{}
+			if ev.Type != "DELETE" {
+				var err error
+				if value, err = decodeEtcdValue(coll, ev.KV.Value); err != nil {
+					log.Printf("Skipping unreadable etcd record %s: %v", ev.KV.Key, err)
+					continue
+				}
+			}
+			d.apply(coll, id, value, rev)
+		}
+		if rev > d.rev {
+			d.rev = rev
+		}
+	}
+	for key, rev := range d.tombstones {
+		if rev <= d.rev {
+			delete(d.tombstones, key)
+		}
+	}
+	return nil
+}
+
+// apply records that the record id in coll held value (nil once deleted) at rev,
+// unless the mirror already holds a later state of it. The caller must hold d.mu.
+func (d *etcdDB) apply(coll, id string, value interface{}, rev int64) {
+	current := d.records[coll][id]
+	if current != nil && current.modRev >= rev {
+		return
+	}
+	key := d.key(coll, id)
+	if deletedAt, ok := d.tombstones[key]; ok && deletedAt >= rev {
+		return
+	}
+	if value == nil {
+		if current != nil {
+			delete(d.records[coll], id)
+		}
+		if rev > d.rev {
+			d.tombstones[key] = rev
+		}
+		return
+	}
+	if d.records[coll] == nil {
+		d.records[coll] = make(map[string]*etcdRecord)
+	}
+	d.records[coll][id] = &etcdRecord{value: value, modRev: rev}
+}
+
+// refresh reads the given records from etcd into the mirror, for when a
+// compare-and-set found them changed before the watch reported it
+func (d *etcdDB) refresh(keys map[string]int64) error {
+	for key := range keys {
+		resp, err := d.client.Range(context.Background(), etcdRangeRequest{Key: []byte(key)})
+		if err != nil {
+			return err
+		}
+		coll, id, ok := d.splitKey([]byte(key))
+		if !ok {
+			continue
+		}
+		var value interface{}
+		rev := int64(resp.Header.Revision)
+		if len(resp.KVs) > 0 {
+			if value, err = decodeEtcdValue(coll, resp.KVs[0].Value); err != nil {
+				return err
+			}
+			rev = int64(resp.KVs[0].ModRevision)
+		}
+		d.mu.Lock()
+		d.apply(coll, id, value, rev)
+		d.mu.Unlock()
+	}
+	return nil
+}
+
+// leaseFor returns a lease ending at or shortly after expiresAt. Records expiring
+// within the same minute share a lease.
+func (d *etcdDB) leaseFor(ctx context.Context, expiresAt time.Time) (int64, error) {
+	end := expiresAt.Truncate(etcdLeaseBucket).Add(etcdLeaseBucket)
+	now := time.Now()
+	if end.Sub(now) < etcdMinLeaseTTL {
+		return d.client.Grant(ctx, etcdMinLeaseTTL)
+	}
+
+	d.leaseMu.Lock()
+	defer d.leaseMu.Unlock()
+	if lease, ok := d.leases[end.Unix()]; ok {
+		return lease.id, nil
+	}
+	id, err := d.client.Grant(ctx, end.Sub(now).Round(time.Second))
+	if err != nil {
+		return 0, err
+	}
+	for bucket, lease := range d.leases {
+		if lease.expires.Before(now.Add(etcdMinLeaseTTL)) {
+			delete(d.leases, bucket)
+		}
+	}
+	d.leases[end.Unix()] = etcdLease{id: id, expires: end}
+	return id, nil
+}
+
+// forgetLeases drops the cached leases after etcd reported one missing
+func (d *etcdDB) forgetLeases() {
+	d.leaseMu.Lock()
+	defer d.leaseMu.Unlock()
+	d.leases = make(map[int64]etcdLease)
+}
+
+// etcdWrite stores value (nil deletes the record) as the record id in coll
+type etcdWrite struct {
+	coll  string
+	id    string
+	value interface{}
+}
+
+// commit writes all of writes in one etcd transaction if the records named in
+// compares are still at the given revisions (0: do not exist). It returns false,
+// writing nothing, if one of them changed.
+func (d *etcdDB) commit(compares map[string]int64, writes []etcdWrite) (bool, error) {
+	writes = lastWritePerKey(d, writes)
+	ctx := context.Background()
+	for attempt := 0; ; attempt++ {
+		req := etcdTxnRequest{}
+		for key, rev := range compares {
+			req.Compare = append(req.Compare, etcdCompare{Key: []byte(key), Target: "MOD", Result: "EQUAL", ModRevision: etcdInt(rev)})
+		}
+		stored := make([]interface{}, len(writes))
+		for i, w := range writes {
+			key := []byte(d.key(w.coll, w.id))
+			if w.value == nil {
+				req.Success = append(req.Success, etcdOp{Delete: &etcdDeleteRequest{Key: key}})
+				continue
+			}
+			data, err := encodeEtcdValue(w.value)
+			if err != nil {
+				return false, err
+			}
+			// Keep a copy as stored, so later changes by the caller do not leak into the mirror
+			if stored[i], err = decodeEtcdValue(w.coll, data); err != nil {
+				return false, err
+			}
+			put := &etcdPutRequest{Key: key, Value: data}
+			if expiresAt := etcdExpiry(w.value); !expiresAt.IsZero() {
+				lease, err := d.leaseFor(ctx, expiresAt)
+				if err != nil {
+					return false, fmt.Errorf("failed to grant etcd lease: %w", err)
+				}
+				put.Lease = etcdInt(lease)
+			}
+			req.Success = append(req.Success, etcdOp{Put: put})
+		}
+
+		resp, err := d.client.Txn(ctx, req)
+		var etcdErr *etcdError
+		if attempt == 0 && errors.As(err, &etcdErr) && etcdErr.Code == etcdCodeNotFound {
+			// A cached lease was revoked or expired early
+			d.forgetLeases()
+			continue
+		}
+		if err != nil || !resp.Succeeded {
+			return false, err
+		}
+
+		rev := int64(resp.Header.Revision)
+		d.mu.Lock()
+		for i, w := range writes {
+			d.apply(w.coll, w.id, stored[i], rev)
+		}
+		d.mu.Unlock()
+		return true, nil
+	}
+}
+
+// lastWritePerKey drops all but the last write to each record, since etcd refuses
+// transactions that write a key twice
+func lastWritePerKey(d *etcdDB, writes []etcdWrite) []etcdWrite {
+	last := make(map[string]int, len(writes))
+	for i, w := range writes {
+		last[d.key(w.coll, w.id)] = i
+	}
+	if len(last) == len(writes) {
+		return writes
+	}
+	kept := make([]etcdWrite, 0, len(last))
+	for i, w := range writes {
+		if last[d.key(w.coll, w.id)] == i {
+			kept = append(kept, w)
+		}
+	}
+	return kept
+}
+
+// etcdTx collects the writes of a transaction until it commits. Records it reads
+// for a compare-and-set must be unchanged at commit.
+type etcdTx struct {
+	records  map[string]*etcdRecord // Key → record as written in the transaction; nil value once deleted
+	compares map[string]int64
+	writes   []etcdWrite
+}
+
+// RunInTransaction runs fn and writes its changes in a single etcd transaction
+// once it returns. fn reads its own writes. If a record fn changed with a
+// compare-and-set was changed by someone else in the meantime, fn is run again,
+// so it must not have side effects outside tx. Nested calls join the outer
+// transaction.
+func (s *EtcdStorage) RunInTransaction(fn func(tx Storage) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+	for attempt := 0; ; attempt++ {
+		tx := &EtcdStorage{db: s.db, tx: &etcdTx{records: make(map[string]*etcdRecord), compares: make(map[string]int64)}}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if len(tx.tx.writes) == 0 {
+			return nil
+		}
+		ok, err := s.db.commit(tx.tx.compares, tx.tx.writes)
+		if err != nil || ok {
+			return err
+		}
+		if attempt == etcdCASRetries {
+			return errors.New("etcd transaction kept conflicting with concurrent writes")
+		}
+		if err := s.db.refresh(tx.tx.compares); err != nil {
+			return err
+		}
+	}
+}
+
+// record returns the record id in coll as this storage sees it, or nil
+func (s *EtcdStorage) record(coll, id string) *etcdRecord {
+	if s.tx != nil {
+		if rec, ok := s.tx.records[s.db.key(coll, id)]; ok {
+			if rec.value == nil {
+				return nil
+			}
+			return rec
+		}
+	}
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	return s.db.records[coll][id]
+}
+
+// write stores writes, all or none, if the records in compares are unchanged. In
+// a transaction they are held back until it commits.
+func (s *EtcdStorage) write(compares map[string]int64, writes ...etcdWrite) (bool, error) {
+	if s.tx == nil {
+		return s.db.commit(compares, writes)
+	}
+	for key, rev := range compares {
+		if _, seen := s.tx.compares[key]; !seen {
+			s.tx.compares[key] = rev
+		}
+	}
+	for _, w := range writes {
+		key := s.db.key(w.coll, w.id)
+		modRev := int64(0)
+		if rec := s.record(w.coll, w.id); rec != nil {
+			modRev = rec.modRev
+		}
+		s.tx.records[key] = &etcdRecord{value: w.value, modRev: modRev}
+		s.tx.writes = append(s.tx.writes, w)
+	}
+	return true, nil
+}
+
+// put stores value as the record id in coll
+func (s *EtcdStorage) put(coll, id string, value interface{}) error {
+	_, err := s.write(nil, etcdWrite{coll: coll, id: id, value: value})
+	return err
+}
+
+// remove deletes the records ids in coll, in batches outside a transaction
+func (s *EtcdStorage) remove(coll string, ids ...string) error {
+	for len(ids) > 0 {
+		n := len(ids)
+		if s.tx == nil && n > etcdMaxTxnOps {
+			n = etcdMaxTxnOps
+		}
+		writes := make([]etcdWrite, n)
+		for i, id := range ids[:n] {
+			writes[i] = etcdWrite{coll: coll, id: id}
+		}
+		if _, err := s.write(nil, writes...); err != nil {
+			return err
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+// etcdGet returns a copy of the record id in coll, or nil
+func etcdGet[T any](s *EtcdStorage, coll, id string) *T {
+	rec := s.record(coll, id)
+	if rec == nil {
+		return nil
+	}
+	copied := *rec.value.(*T)
+	return &copied
+}
+
+// etcdFind returns copies of the records in coll that match
+func etcdFind[T any](s *EtcdStorage, coll string, match func(*T) bool) []*T {
+	var found []*T
+	visit := func(value interface{}) {
+		if v := value.(*T); match == nil || match(v) {
+			copied := *v
+			found = append(found, &copied)
+		}
+	}
+
+	s.db.mu.RLock()
+	for id, rec := range s.db.records[coll] {
+		if s.tx != nil {
+			if _, changed := s.tx.records[s.db.key(coll, id)]; changed {
+				continue
+			}
+		}
+		visit(rec.value)
+	}
+	s.db.mu.RUnlock()
+
+	if s.tx != nil {
+		collPrefix := s.db.key(coll, "")
+		for key, rec := range s.tx.records {
+			if rec.value != nil && strings.HasPrefix(key, collPrefix) {
+				visit(rec.value)
+			}
+		}
+	}
+	return found
+}
+
+// etcdFindIDs returns the IDs of the records in coll that match
+func etcdFindIDs[T any](s *EtcdStorage, coll string, id func(*T) string, match func(*T) bool) []string {
+	var ids []string
+	for _, v := range etcdFind(s, coll, match) {
+		ids = append(ids, id(v))
+	}
+	return ids
+}
+
+// etcdUpdate changes the record id in coll with fn and stores it if the record
+// was not changed by someone else in the meantime, trying again with the new
+// record if it was. It returns false if the record does not exist or fn returns
+// false to leave it as it is.
+func etcdUpdate[T any](s *EtcdStorage, coll, id string, fn func(*T) bool) (bool, error) {
+	key := s.db.key(coll, id)
+	for attempt := 0; ; attempt++ {
+		rec := s.record(coll, id)
+		if rec == nil {
+			return false, nil
+		}
+		v := *rec.value.(*T)
+		if !fn(&v) {
+			return false, nil
+		}
+		compares := map[string]int64{key: rec.modRev}
+		ok, err := s.write(compares, etcdWrite{coll: coll, id: id, value: &v})
+		if err != nil || ok {
+			return ok, err
+		}
+		if attempt == etcdCASRetries {
+			return false, fmt.Errorf("%s %s kept changing concurrently", coll, id)
+		}
+		if err := s.db.refresh(compares); err != nil {
+			return false, err
+		}
+	}
+}
+
+// Ping checks that etcd answers
+func (s *EtcdStorage) Ping(ctx context.Context) error {
+	_, err := s.db.client.Range(ctx, etcdRangeRequest{Key: []byte(s.db.prefix), CountOnly: true})
+	return err
+}
+
+// ============================================================================
+// User Operations
+// ============================================================================
+
+func (s *EtcdStorage) CreateUser(user *models.User) error {
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	if user.UpdatedAt.IsZero() {
+		user.UpdatedAt = user.CreatedAt
+	}
+	writes, compares := s.userIndexWrites(nil, user)
+	writes = append(writes, etcdWrite{coll: etcdUsers, id: user.ID, value: user})
+	return s.writeUser(compares, writes, user)
+}
+
+// userIndexWrites returns the writes that point the username and email of user at
+// it instead of those of previous, with compares making sure no other user holds
+// them. Emails are indexed in lower case, so a change of case alone is not checked.
+func (s *EtcdStorage) userIndexWrites(previous, user *models.User) ([]etcdWrite, map[string]int64) {
+	var writes []etcdWrite
+	compares := make(map[string]int64)
+	index := func(coll, old, value string) {
+		if old == value {
+			return
+		}
+		if old != "" {
+			writes = append(writes, etcdWrite{coll: coll, id: old})
+		}
+		if value != "" {
+			writes = append(writes, etcdWrite{coll: coll, id: value, value: &etcdIndexEntry{ID: user.ID}})
+			compares[s.db.key(coll, value)] = 0
+		}
+	}
+	var oldUsername, oldEmail string
+	if previous != nil {
+		oldUsername, oldEmail = previous.Username, strings.ToLower(previous.Email)
+	}
+	index(etcdUsernames, oldUsername, user.Username)
+	index(etcdEmails, oldEmail, strings.ToLower(user.Email))
+	return writes, compares
+}
+
+// errEtcdUserChanged reports that a user changed between reading and writing it
+var errEtcdUserChanged = errors.New("user was changed concurrently")
+
+// writeUser stores a user with its index entries, explaining a failed compare
+func (s *EtcdStorage) writeUser(compares map[string]int64, writes []etcdWrite, user *models.User) error {
+	ok, err := s.write(compares, writes...)
+	if err != nil || ok {
+		return err
+	}
+	if err := s.db.refresh(compares); err != nil {
+		return err
+	}
+	if owner := etcdGet[etcdIndexEntry](s, etcdUsernames, user.Username); owner != nil && owner.ID != user.ID {
+		return fmt.Errorf("username already exists")
+	}
+	if owner := etcdGet[etcdIndexEntry](s, etcdEmails, strings.ToLower(user.Email)); owner != nil && owner.ID != user.ID {
+		return fmt.Errorf("email already exists")
+	}
+	return errEtcdUserChanged
+}
+
+func (s *EtcdStorage) GetUserByID(id string) (*models.User, error) {
+	return etcdGet[models.User](s, etcdUsers, id), nil
+}
+
+func (s *EtcdStorage) GetUserByUsername(username string) (*models.User, error) {
+	if entry := etcdGet[etcdIndexEntry](s, etcdUsernames, username); entry != nil {
+		return etcdGet[models.User](s, etcdUsers, entry.ID), nil
+	}
+	return nil, nil
+}
+
+func (s *EtcdStorage) GetUserByEmail(email string) (*models.User, error) {
+	email = strings.TrimSpace(email)
+	var match *models.User
+	for _, user := range etcdFind(s, etcdUsers, func(u *models.User) bool { return strings.EqualFold(u.Email, email) }) {
+		if user.Email == email {
+			return user, nil
+		}
+		match = user
+	}
+	return match, nil
+}
+
+func (s *EtcdStorage) ResolveUserByLoginIdentifier(identifier string, fields []string) (*models.User, error) {
+	identifier = strings.TrimSpace(identifier)
+	if identifier == "" {
+		return nil, nil
+	}
+	users := etcdFind[models.User](s, etcdUsers, nil)
+	for _, field := range fields {
+		var match *models.User
+		matches := 0
+		for _, user := range users {
+			value := loginIdentifierValue(user, field)
+			if value == identifier {
+				return user, nil
+			}
+			if value != "" && strings.EqualFold(value, identifier) {
+				match = user
+				matches++
+			}
+		}
+		if matches == 1 {
+			return match, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *EtcdStorage) GetAllUsers() ([]*models.User, error) {
+	return etcdFind[models.User](s, etcdUsers, nil), nil
+}
+
+func (s *EtcdStorage) UpdateUser(user *models.User) error {
+	for attempt := 0; ; attempt++ {
+		rec := s.record(etcdUsers, user.ID)
+		if rec == nil {
+			return fmt.Errorf("user not found")
+		}
+		user.Version = rec.value.(*models.User).Version + 1
+		err := s.updateUser(rec, user)
+		if !errors.Is(err, errEtcdUserChanged) || attempt == etcdCASRetries {
+			return err
+		}
+	}
+}
+
+func (s *EtcdStorage) UpdateUserIfVersion(user *models.User, version int) (bool, error) {
+	rec := s.record(etcdUsers, user.ID)
+	if rec == nil || rec.value.(*models.User).Version != version {
+		return false, nil
+	}
+	user.Version = version + 1
+	err := s.updateUser(rec, user)
+	if errors.Is(err, errEtcdUserChanged) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *EtcdStorage) UpdatePasswordHash(id, oldHash, newHash string) (bool, error) {
+	return etcdUpdate(s, etcdUsers, id, func(user *models.User) bool {
+		if user.PasswordHash != oldHash {
+			return false
+		}
+		user.PasswordHash = newHash
+		user.Version++
+		user.UpdatedAt = time.Now()
+		return true
+	})
+}
+
+// updateUser replaces rec, the stored user, if it has not changed since it was read
+func (s *EtcdStorage) updateUser(rec *etcdRecord, user *models.User) error {
+	existing := rec.value.(*models.User)
+	user.UpdatedAt = time.Now()
+	user.CreatedAt = existing.CreatedAt // Preserve creation time
+
+	writes, compares := s.userIndexWrites(existing, user)
+	writes = append(writes, etcdWrite{coll: etcdUsers, id: user.ID, value: user})
+	compares[s.db.key(etcdUsers, user.ID)] = rec.modRev
+	return s.writeUser(compares, writes, user)
+}
+
+func (s *EtcdStorage) DeleteUser(id string) error {
+	user := etcdGet[models.User](s, etcdUsers, id)
+	if user == nil {
+		return fmt.Errorf("user not found")
+	}
+	writes := []etcdWrite{{coll: etcdUsers, id: id}}
+	if owner := etcdGet[etcdIndexEntry](s, etcdUsernames, user.Username); owner != nil && owner.ID == id {
+		writes = append(writes, etcdWrite{coll: etcdUsernames, id: user.Username})
+	}
+	if owner := etcdGet[etcdIndexEntry](s, etcdEmails, strings.ToLower(user.Email)); owner != nil && owner.ID == id {
+		writes = append(writes, etcdWrite{coll: etcdEmails, id: strings.ToLower(user.Email)})
+	}
+	_, err := s.write(nil, writes...)
+	return err
+}
+
+// ============================================================================
+// Client Operations
+// ============================================================================
+
+func (s *EtcdStorage) CreateClient(client *models.Client) error {
+	if client.CreatedAt.IsZero() {
+		client.CreatedAt = time.Now()
+	}
+	return s.put(etcdClients, client.ID, client)
+}
+
+func (s *EtcdStorage) GetClientByID(id string) (*models.Client, error) {
+	return etcdGet[models.Client](s, etcdClients, id), nil
+}
+
+func (s *EtcdStorage) GetAllClients() ([]*models.Client, error) {
+	return etcdFind[models.Client](s, etcdClients, nil), nil
+}
+
+func (s *EtcdStorage) UpdateClient(client *models.Client) error {
+	ok, err := etcdUpdate(s, etcdClients, client.ID, func(existing *models.Client) bool {
+		// Preserve creation time and last use, which TouchClient maintains
+		client.CreatedAt = existing.CreatedAt
+		client.LastUsedAt = existing.LastUsedAt
+		client.Version = existing.Version + 1
+		*existing = *client
+		return true
+	})
+	if err == nil && !ok {
+		return fmt.Errorf("client not found")
+	}
+	return err
+}
+
+func (s *EtcdStorage) UpdateClientIfVersion(client *models.Client, version int) (bool, error) {
+	rec := s.record(etcdClients, client.ID)
+	if rec == nil || rec.value.(*models.Client).Version != version {
+		return false, nil
+	}
+	client.CreatedAt = rec.value.(*models.Client).CreatedAt
+	client.LastUsedAt = rec.value.(*models.Client).LastUsedAt
+	client.Version = version + 1
+	return s.write(map[string]int64{s.db.key(etcdClients, client.ID): rec.modRev},
+		etcdWrite{coll: etcdClients, id: client.ID, value: client})
+}
+
+func (s *EtcdStorage) DeleteClient(id string) error {
+	if s.record(etcdClients, id) == nil {
+		return fmt.Errorf("client not found")
+	}
+	return s.remove(etcdClients, id)
+}
+
+func (s *EtcdStorage) ValidateClient(clientID, clientSecret string) (*models.Client, error) {
+	client := etcdGet[models.Client](s, etcdClients, clientID)
+	if client == nil || client.Secret != clientSecret {
+		return nil, nil
+	}
+	return client, nil
+}
+
+func (s *EtcdStorage) TouchClient(id string, usedAt time.Time) error {
+	ok, err := etcdUpdate(s, etcdClients, id, func(client *models.Client) bool {
+		client.LastUsedAt = &usedAt
+		client.Version++
+		return true
+	})
+	if err == nil && !ok {
+		return fmt.Errorf("client not found")
+	}
+	return err
+}
+
+// ============================================================================
+// Authorization Code Operations
+// ============================================================================
+
+func (s *EtcdStorage) CreateAuthorizationCode(code *models.AuthorizationCode) error {
+	code.CreatedAt = time.Now()
+	return s.put(etcdCodes, code.Code, code)
+}
+
+func (s *EtcdStorage) GetAuthorizationCode(code string) (*models.AuthorizationCode, error) {
+	return etcdGet[models.AuthorizationCode](s, etcdCodes, code), nil
+}
+
+func (s *EtcdStorage) UpdateAuthorizationCode(code *models.AuthorizationCode) error {
+	if s.record(etcdCodes, code.Code) == nil {
+		return nil
+	}
+	return s.put(etcdCodes, code.Code, code)
+}
+
+func (s *EtcdStorage) MarkAuthorizationCodeUsed(code string, usedAt time.Time) (bool, error) {
+	return etcdUpdate(s, etcdCodes, code, func(authCode *models.AuthorizationCode) bool {
+		if authCode.Used {
+			return false
+		}
+		authCode.Used = true
+		authCode.UsedAt = &usedAt
+		return true
+	})
+}
+
+func (s *EtcdStorage) DeleteAuthorizationCode(code string) error {
+	return s.remove(etcdCodes, code)
+}
+
+// ============================================================================
+// Token Operations
+// ============================================================================
+
+func tokenID(t *models.Token) string { return t.ID }
+
+func (s *EtcdStorage) CreateToken(token *models.Token) error {
+	token.CreatedAt = time.Now()
+	return s.put(etcdTokens, token.ID, token)
+}
+
+func (s *EtcdStorage) GetTokenByAccessToken(accessToken string) (*models.Token, error) {
+	now := time.Now()
+	for _, token := range etcdFind(s, etcdTokens, func(t *models.Token) bool { return t.AccessToken == accessToken }) {
+		if now.After(token.ExpiresAt) {
+			return nil, nil
+		}
+		return token, nil
+	}
+	return nil, nil
+}
+
+func (s *EtcdStorage) GetTokenByRefreshToken(refreshToken string) (*models.Token, error) {
+	for _, token := range etcdFind(s, etcdTokens, func(t *models.Token) bool { return t.RefreshToken == refreshToken }) {
+		return token, nil
+	}
+	return nil, nil
+}
+
+func (s *EtcdStorage) GetTokensByAuthCode(authCodeID string) ([]*models.Token, error) {
+	return etcdFind(s, etcdTokens, func(t *models.Token) bool { return t.AuthorizationCodeID == authCodeID }), nil
+}
+
+func (s *EtcdStorage) DeleteToken(tokenID string) error {
+	return s.remove(etcdTokens, tokenID)
+}
+
+func (s *EtcdStorage) RevokeTokensByAuthCode(authCodeID string) error {
+	return s.remove(etcdTokens, etcdFindIDs(s, etcdTokens, tokenID, func(t *models.Token) bool { return t.AuthorizationCodeID == authCodeID })...)
+}
+
+// RevokeTokensBySession deletes all tokens bound to a user session
+func (s *EtcdStorage) RevokeTokensBySession(sessionID string) error {
+	return s.remove(etcdTokens, etcdFindIDs(s, etcdTokens, tokenID, func(t *models.Token) bool { return t.SessionID == sessionID })...)
+}
+
+// ListTokens returns tokens optionally filtered by clientID, userID, and active status.
+func (s *EtcdStorage) ListTokens(clientID, userID string, activeOnly bool) ([]*models.Token, error) {
+	now := time.Now()
+	return etcdFind(s, etcdTokens, func(t *models.Token) bool {
+		return (clientID == "" || t.ClientID == clientID) &&
+			(userID == "" || t.UserID == userID) &&
+			(!activeOnly || t.ExpiresAt.After(now))
+	}), nil
+}
+
+func (s *EtcdStorage) MarkTokenReplaced(id, replacementID string, replacedAt, expiresAt time.Time) (bool, error) {
+	return etcdUpdate(s, etcdTokens, id, func(token *models.Token) bool {
+		if token.ReplacedBy != "" {
+			return false
+		}
+		token.ReplacedBy = replacementID
+		token.ReplacedAt = replacedAt
+		if expiresAt.Before(token.ExpiresAt) {
+			token.ExpiresAt = expiresAt
+		}
+		return true
+	})
+}
+
+// ============================================================================
+// Session Operations
+// ============================================================================
+
+func (s *EtcdStorage) CreateSession(session *models.Session) error {
+	session.CreatedAt = time.Now()
+	return s.put(etcdSessions, session.ID, session)
+}
+
+func (s *EtcdStorage) GetSession(id string) (*models.Session, error) {
+	session := etcdGet[models.Session](s, etcdSessions, id)
+	if session == nil || time.Now().After(session.ExpiresAt) {
+		return nil, nil
+	}
+	return session, nil
+}
+
+func (s *EtcdStorage) DeleteSession(id string) error {
+	return s.remove(etcdSessions, id)
+}
+
+func authSessionID(a *models.AuthSession) string { return a.ID }
+
+func (s *EtcdStorage) CreateAuthSession(session *models.AuthSession) error {
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = time.Now()
+	}
+	return s.put(etcdAuthSessions, session.ID, session)
+}
+
+func (s *EtcdStorage) GetAuthSession(id string) (*models.AuthSession, error) {
+	session := etcdGet[models.AuthSession](s, etcdAuthSessions, id)
+	if session == nil || time.Now().After(session.ExpiresAt) {
+		return nil, nil
+	}
+	return session, nil
+}
+
+func (s *EtcdStorage) UpdateAuthSession(session *models.AuthSession) error {
+	return s.put(etcdAuthSessions, session.ID, session)
+}
+
+func (s *EtcdStorage) DeleteAuthSession(id string) error {
+	return s.remove(etcdAuthSessions, id)
+}
+
+// GetAuthSessionsByOwner returns all non-expired auth sessions started by owner.
+func (s *EtcdStorage) GetAuthSessionsByOwner(owner string) ([]*models.AuthSession, error) {
+	now := time.Now()
+	return etcdFind(s, etcdAuthSessions, func(a *models.AuthSession) bool {
+		return a.Owner == owner && now.Before(a.ExpiresAt)
+	}), nil
+}
+
+// CleanupExpiredAuthSessions removes expired auth sessions and returns how many were deleted.
+func (s *EtcdStorage) CleanupExpiredAuthSessions() (int, error) {
+	now := time.Now()
+	ids := etcdFindIDs(s, etcdAuthSessions, authSessionID, func(a *models.AuthSession) bool { return now.After(a.ExpiresAt) })
+	return len(ids), s.remove(etcdAuthSessions, ids...)
+}
+
+func userSessionID(u *models.UserSession) string { return u.ID }
+
+func (s *EtcdStorage) CreateUserSession(session *models.UserSession) error {
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = time.Now()
+	}
+	if session.AuthTime.IsZero() {
+		session.AuthTime = time.Now()
+	}
+	session.LastActivityAt = time.Now()
+	return s.put(etcdUserSessions, session.ID, session)
+}
+
+func (s *EtcdStorage) GetUserSession(id string) (*models.UserSession, error) {
+	session := etcdGet[models.UserSession](s, etcdUserSessions, id)
+	if session == nil || time.Now().After(session.ExpiresAt) {
+		return nil, nil
+	}
+	return session, nil
+}
+
+func (s *EtcdStorage) GetUserSessionByUserID(userID string) (*models.UserSession, error) {
+	// Find the most recent session for the user
+	now := time.Now()
+	var latest *models.UserSession
+	for _, session := range etcdFind(s, etcdUserSessions, func(u *models.UserSession) bool {
+		return u.UserID == userID && now.Before(u.ExpiresAt)
+	}) {
+		if latest == nil || session.AuthTime.After(latest.AuthTime) {
+			latest = session
+		}
+	}
+	return latest, nil
+}
+
+// GetUserSessionsByUserID returns all stored sessions of a user, expired or not
+func (s *EtcdStorage) GetUserSessionsByUserID(userID string) ([]*models.UserSession, error) {
+	return etcdFind(s, etcdUserSessions, func(u *models.UserSession) bool { return u.UserID == userID }), nil
+}
+
+func (s *EtcdStorage) UpdateUserSession(session *models.UserSession) error {
+	session.LastActivityAt = time.Now()
+	return s.put(etcdUserSessions, session.ID, session)
+}
+
+func (s *EtcdStorage) DeleteUserSession(id string) error {
+	return s.remove(etcdUserSessions, id)
+}
+
+// CleanupExpiredSessions removes the expired sessions etcd has not dropped yet;
+// their leases run up to a minute past the expiry
+func (s *EtcdStorage) CleanupExpiredSessions() error {
+	now := time.Now()
+	if _, err := s.CleanupExpiredAuthSessions(); err != nil {
+		return err
+	}
+	if err := s.remove(etcdUserSessions, etcdFindIDs(s, etcdUserSessions, userSessionID, func(u *models.UserSession) bool {
+		return now.After(u.ExpiresAt)
+	})...); err != nil {
+		return err
+	}
+	if err := s.remove(etcdSessions, etcdFindIDs(s, etcdSessions, func(v *models.Session) string { return v.ID }, func(v *models.Session) bool {
+		return now.After(v.ExpiresAt)
+	})...); err != nil {
+		return err
+	}
+	return s.remove(etcdUsedJTIs, etcdFindIDs(s, etcdUsedJTIs, func(u *models.UsedJTI) string { return u.ID }, func(u *models.UsedJTI) bool {
+		return now.After(u.ExpiresAt)
+	})...)
+}
+
+// ============================================================================
+// Consent Operations
+// ============================================================================
+
+func consentKey(userID, clientID string) string {
+	return userID + ":" + clientID
+}
+
+func (s *EtcdStorage) CreateConsent(consent *models.Consent) error {
+	if consent.CreatedAt.IsZero() {
+		consent.CreatedAt = time.Now()
+	}
+	consent.UpdatedAt = time.Now()
+	return s.put(etcdConsents, consentKey(consent.UserID, consent.ClientID), consent)
+}
+
+func (s *EtcdStorage) GetConsent(userID, clientID string) (*models.Consent, error) {
+	return etcdGet[models.Consent](s, etcdConsents, consentKey(userID, clientID)), nil
+}
+
+func (s *EtcdStorage) UpdateConsent(consent *models.Consent) error {
+	consent.UpdatedAt = time.Now()
+	return s.put(etcdConsents, consentKey(consent.UserID, consent.ClientID), consent)
+}
+
+func (s *EtcdStorage) DeleteConsent(userID, clientID string) error {
+	return s.remove(etcdConsents, consentKey(userID, clientID))
+}
+
+func (s *EtcdStorage) DeleteConsentsForUser(userID string) error {
+	return s.remove(etcdConsents, etcdFindIDs(s, etcdConsents, func(c *models.Consent) string {
+		return consentKey(c.UserID, c.ClientID)
+	}, func(c *models.Consent) bool { return c.UserID == userID })...)
+}
+
+func (s *EtcdStorage) GetConsentsByUserID(userID string) ([]*models.Consent, error) {
+	return etcdFind(s, etcdConsents, func(c *models.Consent) bool { return c.UserID == userID }), nil
+}
+
+func (s *EtcdStorage) CreateConsentReceipt(receipt *models.ConsentReceipt) error {
+	if receipt.CreatedAt.IsZero() {
+		receipt.CreatedAt = time.Now()
+	}
+	return s.put(etcdConsentReceipts, receipt.ID, receipt)
+}
+
+// ListConsentReceipts returns receipts optionally filtered by userID and clientID, oldest first
+func (s *EtcdStorage) ListConsentReceipts(userID, clientID string) ([]*models.ConsentReceipt, error) {
+	receipts := etcdFind(s, etcdConsentReceipts, func(r *models.ConsentReceipt) bool {
+		return (userID == "" || r.UserID == userID) && (clientID == "" || r.ClientID == clientID)
+	})
+	sort.Slice(receipts, func(a, b int) bool { return receipts[a].CreatedAt.Before(receipts[b].CreatedAt) })
+	return receipts, nil
+}
+
+func (s *EtcdStorage) DeleteConsentReceiptsForUser(userID string) error {
+	return s.remove(etcdConsentReceipts, etcdFindIDs(s, etcdConsentReceipts, func(r *models.ConsentReceipt) string { return r.ID },
+		func(r *models.ConsentReceipt) bool { return r.UserID == userID })...)
+}
+
+// ============================================================================
+// Initial Access Token Operations
+// ============================================================================
+
+func (s *EtcdStorage) CreateInitialAccessToken(token *models.InitialAccessToken) error {
+	return s.put(etcdInitialAccessTokens, token.Token, token)
+}
+
+func (s *EtcdStorage) GetInitialAccessToken(token string) (*models.InitialAccessToken, error) {
+	return etcdGet[models.InitialAccessToken](s, etcdInitialAccessTokens, token), nil
+}
+
+func (s *EtcdStorage) UpdateInitialAccessToken(token *models.InitialAccessToken) error {
+	return s.put(etcdInitialAccessTokens, token.Token, token)
+}
+
+func (s *EtcdStorage) UseInitialAccessToken(token, clientID string, maxUses int, usedAt time.Time) (bool, error) {
+	return etcdUpdate(s, etcdInitialAccessTokens, token, func(t *models.InitialAccessToken) bool {
+		if t.Used || t.UseCount >= maxUses {
+			return false
+		}
+		t.UseCount++
+		t.UsedAt = &usedAt
+		t.UsedBy = clientID
+		t.Used = t.UseCount >= maxUses
+		return true
+	})
+}
+
+func (s *EtcdStorage) DeleteInitialAccessToken(token string) error {
+	return s.remove(etcdInitialAccessTokens, token)
+}
+
+func (s *EtcdStorage) GetAllInitialAccessTokens() ([]*models.InitialAccessToken, error) {
+	return etcdFind[models.InitialAccessToken](s, etcdInitialAccessTokens, nil), nil
+}
+
+// ============================================================================
+// API Key Operations
+// ============================================================================
+
+func (s *EtcdStorage) CreateAPIKey(key *models.APIKey) error {
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	return s.put(etcdAPIKeys, key.ID, key)
+}
+
+func (s *EtcdStorage) GetAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	for _, key := range etcdFind(s, etcdAPIKeys, func(k *models.APIKey) bool { return k.KeyHash == keyHash }) {
+		return key, nil
+	}
+	return nil, nil
+}
+
+// ListAPIKeys returns the keys of a service account, oldest first
+func (s *EtcdStorage) ListAPIKeys(userID string) ([]*models.APIKey, error) {
+	keys := etcdFind(s, etcdAPIKeys, func(k *models.APIKey) bool { return k.UserID == userID })
+	sort.Slice(keys, func(a, b int) bool { return keys[a].CreatedAt.Before(keys[b].CreatedAt) })
+	return keys, nil
+}
+
+func (s *EtcdStorage) UpdateAPIKey(key *models.APIKey) error {
+	return s.put(etcdAPIKeys, key.ID, key)
+}
+
+func (s *EtcdStorage) DeleteAPIKey(id string) error {
+	return s.remove(etcdAPIKeys, id)
+}
+
+func (s *EtcdStorage) DeleteAPIKeysForUser(userID string) error {
+	return s.remove(etcdAPIKeys, etcdFindIDs(s, etcdAPIKeys, func(k *models.APIKey) string { return k.ID },
+		func(k *models.APIKey) bool { return k.UserID == userID })...)
+}
+
+// ============================================================================
+// Client Instance Operations
+// ============================================================================
+
+func (s *EtcdStorage) CreateClientInstance(instance *models.ClientInstance) error {
+	if instance.CreatedAt.IsZero() {
+		instance.CreatedAt = time.Now()
+	}
+	return s.put(etcdClientInstances, instance.ID, instance)
+}
+
+func (s *EtcdStorage) GetClientInstance(id string) (*models.ClientInstance, error) {
+	return etcdGet[models.ClientInstance](s, etcdClientInstances, id), nil
+}
+
+// ListClientInstances returns the instances of a client, oldest first
+func (s *EtcdStorage) ListClientInstances(clientID string) ([]*models.ClientInstance, error) {
+	instances := etcdFind(s, etcdClientInstances, func(i *models.ClientInstance) bool { return i.ClientID == clientID })
+	sort.Slice(instances, func(a, b int) bool { return instances[a].CreatedAt.Before(instances[b].CreatedAt) })
+	return instances, nil
+}
+
+func (s *EtcdStorage) UpdateClientInstance(instance *models.ClientInstance) error {
+	return s.put(etcdClientInstances, instance.ID, instance)
+}
+
+func (s *EtcdStorage) DeleteClientInstance(id string) error {
+	return s.remove(etcdClientInstances, id)
+}
+
+// ============================================================================
+// Device Authorization Operations
+// ============================================================================
+
+func (s *EtcdStorage) CreateDeviceAuthorization(auth *models.DeviceAuthorization) error {
+	if auth.CreatedAt.IsZero() {
+		auth.CreatedAt = time.Now()
+	}
+	// User codes must be unique among pending requests
+	if existing, _ := s.GetDeviceAuthorizationByUserCode(auth.UserCode); existing != nil && !existing.IsExpired() {
+		return fmt.Errorf("user code already in use")
+	}
+	return s.put(etcdDeviceCodes, auth.DeviceCode, auth)
+}
+
+func (s *EtcdStorage) GetDeviceAuthorization(deviceCode string) (*models.DeviceAuthorization, error) {
+	return etcdGet[models.DeviceAuthorization](s, etcdDeviceCodes, deviceCode), nil
+}
+
+func (s *EtcdStorage) GetDeviceAuthorizationByUserCode(userCode string) (*models.DeviceAuthorization, error) {
+	for _, auth := range etcdFind(s, etcdDeviceCodes, func(d *models.DeviceAuthorization) bool { return d.UserCode == userCode }) {
+		return auth, nil
+	}
+	return nil, nil
+}
+
+func (s *EtcdStorage) UpdateDeviceAuthorization(auth *models.DeviceAuthorization) error {
+	return s.put(etcdDeviceCodes, auth.DeviceCode, auth)
+}
+
+func (s *EtcdStorage) DeleteDeviceAuthorization(deviceCode string) error {
+	return s.remove(etcdDeviceCodes, deviceCode)
+}
+
+// ============================================================================
+// Error Report Operations
+// ============================================================================
+
+func (s *EtcdStorage) CreateErrorReport(report *models.ErrorReport) error {
+	if report.CreatedAt.IsZero() {
+		report.CreatedAt = time.Now()
+	}
+	return s.put(etcdErrorReports, report.Reference, report)
+}
+
+func (s *EtcdStorage) GetErrorReport(reference string) (*models.ErrorReport, error) {
+	report := etcdGet[models.ErrorReport](s, etcdErrorReports, reference)
+	if report == nil || time.Now().After(report.ExpiresAt) {
+		return nil, nil
+	}
+	return report, nil
+}
+
+// ============================================================================
+// Signing Key Operations
+// ============================================================================
+
+func (s *EtcdStorage) CreateSigningKey(key *models.SigningKey) error {
+	return s.put(etcdSigningKeys, key.ID, key)
+}
+
+func (s *EtcdStorage) GetSigningKey(id string) (*models.SigningKey, error) {
+	return etcdGet[models.SigningKey](s, etcdSigningKeys, id), nil
+}
+
+func (s *EtcdStorage) GetSigningKeyByKID(kid string) (*models.SigningKey, error) {
+	for _, key := range etcdFind(s, etcdSigningKeys, func(k *models.SigningKey) bool { return k.KID == kid }) {
+		return key, nil
+	}
+	return nil, nil
+}
+
+func (s *EtcdStorage) GetAllSigningKeys() ([]*models.SigningKey, error) {
+	return etcdFind[models.SigningKey](s, etcdSigningKeys, nil), nil
+}
+
+func (s *EtcdStorage) GetActiveSigningKey() (*models.SigningKey, error) {
+	for _, key := range etcdFind(s, etcdSigningKeys, func(k *models.SigningKey) bool {
+		return k.IsActive && !k.IsExpired() && k.IsSigningKey()
+	}) {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no active signing key found")
+}
+
+func (s *EtcdStorage) UpdateSigningKey(key *models.SigningKey) error {
+	if s.record(etcdSigningKeys, key.ID) == nil {
+		return fmt.Errorf("signing key not found")
+	}
+	return s.put(etcdSigningKeys, key.ID, key)
+}
+
+func (s *EtcdStorage) DeleteSigningKey(id string) error {
+	return s.remove(etcdSigningKeys, id)
+}
+
+// RecordJTI stores a client assertion JWT ID, returning false if it was already used.
+// Creating the key only if it does not exist makes this safe across replicas.
+func (s *EtcdStorage) RecordJTI(clientID, jti string, expiresAt time.Time) (bool, error) {
+	id := clientID + ":" + jti
+	rev := int64(0)
+	if rec := s.record(etcdUsedJTIs, id); rec != nil {
+		if time.Now().Before(rec.value.(*models.UsedJTI).ExpiresAt) {
+			return false, nil
+		}
+		rev = rec.modRev // Expired but not yet dropped by its lease
+	}
+	return s.write(map[string]int64{s.db.key(etcdUsedJTIs, id): rev},
+		etcdWrite{coll: etcdUsedJTIs, id: id, value: &models.UsedJTI{ID: id, ClientID: clientID, JTI: jti, ExpiresAt: expiresAt}})
+}
+
+// GetActiveTokensCount returns the count of non-expired tokens
+func (s *EtcdStorage) GetActiveTokensCount() int {
+	now := time.Now()
+	return len(etcdFind(s, etcdTokens, func(t *models.Token) bool { return t.ExpiresAt.After(now) }))
+}
+
+// GetRecentUserSessionsCount returns the count of user sessions created in the last 24 hours
+func (s *EtcdStorage) GetRecentUserSessionsCount() int {
+	cutoff := time.Now().Add(-24 * time.Hour)
+	return len(etcdFind(s, etcdUserSessions, func(u *models.UserSession) bool { return u.CreatedAt.After(cutoff) }))
+}
+
+// ============================================================================
+// Audit Log Operations
+// ============================================================================
+
+// auditSeqID keys audit entries by sequence number, so the keys sort in chain order
+func auditSeqID(seq int64) string {
+	return fmt.Sprintf("%020d", seq)
+}
+
+// CreateAuditLog appends entry to the audit chain. Its key is derived from its
+// sequence number and must not exist yet, so of two replicas appending at once
+// one retries after the other's entry.
+func (s *EtcdStorage) CreateAuditLog(entry *models.AuditLog) error {
+	for attempt := 0; ; attempt++ {
+		head, err := s.GetAuditChainHead()
+		if err != nil {
+			return err
+		}
+		entry.ChainAfter(head)
+		id := auditSeqID(entry.Seq)
+		compares := map[string]int64{s.db.key(etcdAuditLogs, id): 0}
+		ok, err := s.write(compares, etcdWrite{coll: etcdAuditLogs, id: id, value: entry})
+		if err != nil || ok {
+			return err
+		}
+		if attempt == etcdCASRetries {
+			return fmt.Errorf("audit chain kept changing concurrently")
+		}
+		if err := s.db.refresh(compares); err != nil {
+			return err
+		}
+	}
+}
+
+// auditLogs returns the audit entries in chain order
+func (s *EtcdStorage) auditLogs() []*models.AuditLog {
+	entries := etcdFind[models.AuditLog](s, etcdAuditLogs, nil)
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Seq < entries[b].Seq })
+	return entries
+}
+
+func (s *EtcdStorage) GetAuditChain(afterSeq int64, limit int) ([]*models.AuditLog, error) {
+	entries := etcdFind(s, etcdAuditLogs, func(e *models.AuditLog) bool { return e.Seq > afterSeq })
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Seq < entries[b].Seq })
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+func (s *EtcdStorage) GetAuditChainHead() (*models.AuditLog, error) {
+	var head *models.AuditLog
+	for _, e := range etcdFind[models.AuditLog](s, etcdAuditLogs, nil) {
+		if head == nil || e.Seq > head.Seq {
+			head = e
+		}
+	}
+	return head, nil
+}
+
+func (s *EtcdStorage) CreateAuditCheckpoint(checkpoint *models.AuditCheckpoint) error {
+	return s.put(etcdAuditCheckpoints, checkpoint.ID, checkpoint)
+}
+
+func (s *EtcdStorage) ListAuditCheckpoints() ([]*models.AuditCheckpoint, error) {
+	checkpoints := etcdFind[models.AuditCheckpoint](s, etcdAuditCheckpoints, nil)
+	sort.Slice(checkpoints, func(a, b int) bool { return checkpoints[a].Seq < checkpoints[b].Seq })
+	return checkpoints, nil
+}
+
+// auditLogMatches reports whether e passes the filter's action, actor and subject
+func auditLogMatches(e *models.AuditLog, filter models.AuditFilter) bool {
+	return (filter.Action == "" || e.Action == filter.Action) &&
+		(filter.Actor == "" || e.Actor == filter.Actor) &&
+		(filter.Subject == "" || e.Actor == filter.Subject || e.ResourceID == filter.Subject)
+}
+
+// GetAuditLogs returns audit log entries in reverse-chronological order,
+// optionally filtered by Action, Actor and/or Subject. Pagination is via Limit/Offset.
+func (s *EtcdStorage) GetAuditLogs(filter models.AuditFilter) ([]*models.AuditLog, error) {
+	all := s.auditLogs()
+	var matched []*models.AuditLog
+	for i := len(all) - 1; i >= 0; i-- {
+		if auditLogMatches(all[i], filter) {
+			matched = append(matched, all[i])
+		}
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+	if filter.Offset >= len(matched) {
+		return []*models.AuditLog{}, nil
+	}
+	return matched[filter.Offset:min(filter.Offset+limit, len(matched))], nil
+}
+
+// GetAuditLogsCount returns the total number of entries matching the filter (without pagination).
+func (s *EtcdStorage) GetAuditLogsCount(filter models.AuditFilter) int {
+	return len(etcdFind(s, etcdAuditLogs, func(e *models.AuditLog) bool { return auditLogMatches(e, filter) }))
+}
+
+// AnonymizeAuditLogs pseudonymizes the entries that mention any of subjects.
+func (s *EtcdStorage) AnonymizeAuditLogs(subjects []string, pseudonym string) ([]models.AuditRedaction, error) {
+	var writes []etcdWrite
+	var redactions []models.AuditRedaction
+	for _, e := range s.auditLogs() {
+		details := make(map[string]interface{}, len(e.Details))
+		for k, v := range e.Details {
+			details[k] = v
+		}
+		e.Details = details
+		if r, ok := anonymizeAuditLog(e, subjects, pseudonym); ok {
+			writes = append(writes, etcdWrite{coll: etcdAuditLogs, id: auditSeqID(e.Seq), value: e})
+			redactions = append(redactions, r)
+		}
+	}
+	for i := 0; i < len(writes); i += etcdMaxTxnOps {
+		if _, err := s.write(nil, writes[i:min(i+etcdMaxTxnOps, len(writes))]...); err != nil {
+			return redactions[:i], err
+		}
+	}
+	return redactions, nil
+}
+
+// ============================================================================
+// Retention Operations
+// ============================================================================
+
+// DeleteAuditLogsBefore deletes audit entries logged before cutoff
+func (s *EtcdStorage) DeleteAuditLogsBefore(cutoff time.Time) (int, error) {
+	head, _ := s.GetAuditChainHead()
+	var ids []string
+	for _, e := range etcdFind(s, etcdAuditLogs, func(e *models.AuditLog) bool { return e.Timestamp.Before(cutoff) }) {
+		if head == nil || e.Seq != head.Seq {
+			ids = append(ids, auditSeqID(e.Seq))
+		}
+	}
+	return len(ids), s.remove(etcdAuditLogs, ids...)
+}
+
+// DeleteTokensExpiredBefore deletes tokens whose access token expired before cutoff
+func (s *EtcdStorage) DeleteTokensExpiredBefore(cutoff time.Time) (int, error) {
+	ids := etcdFindIDs(s, etcdTokens, tokenID, func(t *models.Token) bool { return t.ExpiresAt.Before(cutoff) })
+	return len(ids), s.remove(etcdTokens, ids...)
+}
+
+// DeleteAuthorizationCodesExpiredBefore deletes authorization codes that expired before cutoff
+func (s *EtcdStorage) DeleteAuthorizationCodesExpiredBefore(cutoff time.Time) (int, error) {
+	ids := etcdFindIDs(s, etcdCodes, func(c *models.AuthorizationCode) string { return c.Code },
+		func(c *models.AuthorizationCode) bool { return c.ExpiresAt.Before(cutoff) })
+	return len(ids), s.remove(etcdCodes, ids...)
+}
+
+// DeleteUserSessionsIdleSince deletes user sessions last used before cutoff
+func (s *EtcdStorage) DeleteUserSessionsIdleSince(cutoff time.Time) (int, error) {
+	ids := etcdFindIDs(s, etcdUserSessions, userSessionID, func(u *models.UserSession) bool { return u.LastActivityAt.Before(cutoff) })
+	return len(ids), s.remove(etcdUserSessions, ids...)
+}
+
+// DeleteAuthSessionsCreatedBefore deletes authorization sessions started before cutoff
+func (s *EtcdStorage) DeleteAuthSessionsCreatedBefore(cutoff time.Time) (int, error) {
+	ids := etcdFindIDs(s, etcdAuthSessions, authSessionID, func(a *models.AuthSession) bool { return a.CreatedAt.Before(cutoff) })
+	return len(ids), s.remove(etcdAuthSessions, ids...)
+}
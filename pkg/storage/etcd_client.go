@@ -0,0 +1,409 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// etcdClient talks to etcd through the JSON gateway every etcd v3 server serves
+// next to its gRPC API, so the backend needs no client library. Requests go to
+// the endpoint that last answered and move on to the next one when it cannot be
+// reached.
+type etcdClient struct {
+	endpoints []string
+	http      *http.Client
+	timeout   time.Duration
+	username  string
+	password  string
+
+	mu    sync.Mutex
+	next  int    // Index of the endpoint to try first
+	token string // Auth token when username is set
+}
+
+// etcdClientConfig holds the connection settings of an etcdClient
+type etcdClientConfig struct {
+	Endpoints []string
+	Username  string
+	Password  string
+	CAFile    string
+	CertFile  string
+	KeyFile   string
+	Timeout   time.Duration // Per request, except watches
+}
+
+func newEtcdClient(cfg etcdClientConfig) (*etcdClient, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, errors.New("no etcd endpoints configured")
+	}
+	endpoints := make([]string, len(cfg.Endpoints))
+	for i, e := range cfg.Endpoints {
+		e = strings.TrimRight(strings.TrimSpace(e), "/")
+		if !strings.Contains(e, "://") {
+			e = "http://" + e
+		}
+		endpoints[i] = e
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CAFile != "" || cfg.CertFile != "" {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if cfg.CAFile != "" {
+			pem, err := os.ReadFile(cfg.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read etcd CA file: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("etcd CA file %s holds no certificates", cfg.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if cfg.CertFile != "" {
+			cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load etcd client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &etcdClient{
+		endpoints: endpoints,
+		http:      &http.Client{Transport: transport},
+		timeout:   timeout,
+		username:  cfg.Username,
+		password:  cfg.Password,
+	}, nil
+}
+
+// etcdInt is an int64 as the gateway writes it: a JSON string
+type etcdInt int64
+
+func (n etcdInt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatInt(int64(n), 10))
+}
+
+func (n *etcdInt) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		*n = 0
+		return nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	*n = etcdInt(v)
+	return err
+}
+
+// Gateway messages. Keys and values are base64, which encoding/json does for []byte.
+
+type etcdHeader struct {
+	Revision etcdInt `json:"revision"`
+}
+
+type etcdKeyValue struct {
+	Key         []byte  `json:"key"`
+	Value       []byte  `json:"value,omitempty"`
+	ModRevision etcdInt `json:"mod_revision,omitempty"`
+	Lease       etcdInt `json:"lease,omitempty"`
+}
+
+type etcdRangeRequest struct {
+	Key       []byte  `json:"key"`
+	RangeEnd  []byte  `json:"range_end,omitempty"`
+	Limit     etcdInt `json:"limit,omitempty"`
+	CountOnly bool    `json:"count_only,omitempty"`
+	Revision  etcdInt `json:"revision,omitempty"`
+}
+
+type etcdRangeResponse struct {
+	Header etcdHeader      `json:"header"`
+	KVs    []*etcdKeyValue `json:"kvs"`
+	More   bool            `json:"more"`
+	Count  etcdInt         `json:"count"`
+}
+
+type etcdPutRequest struct {
+	Key   []byte  `json:"key"`
+	Value []byte  `json:"value"`
+	Lease etcdInt `json:"lease,omitempty"`
+}
+
+type etcdDeleteRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end,omitempty"`
+}
+
+// etcdCompare checks a key's mod revision; a revision of 0 means the key must not exist
+type etcdCompare struct {
+	Key         []byte  `json:"key"`
+	Target      string  `json:"target"`
+	Result      string  `json:"result"`
+	ModRevision etcdInt `json:"mod_revision"`
+}
+
+type etcdOp struct {
+	Put    *etcdPutRequest    `json:"request_put,omitempty"`
+	Delete *etcdDeleteRequest `json:"request_delete_range,omitempty"`
+}
+
+type etcdTxnRequest struct {
+	Compare []etcdCompare `json:"compare,omitempty"`
+	Success []etcdOp      `json:"success,omitempty"`
+}
+
+type etcdTxnResponse struct {
+	Header    etcdHeader `json:"header"`
+	Succeeded bool       `json:"succeeded"`
+}
+
+type etcdLeaseGrantRequest struct {
+	TTL etcdInt `json:"TTL"`
+}
+
+type etcdLeaseGrantResponse struct {
+	ID  etcdInt `json:"ID"`
+	TTL etcdInt `json:"TTL"`
+}
+
+type etcdWatchEvent struct {
+	Type string        `json:"type"` // "PUT" (or empty) or "DELETE"
+	KV   *etcdKeyValue `json:"kv"`
+}
+
+type etcdWatchResponse struct {
+	Result *struct {
+		Header          etcdHeader       `json:"header"`
+		Created         bool             `json:"created"`
+		Canceled        bool             `json:"canceled"`
+		CompactRevision etcdInt          `json:"compact_revision"`
+		CancelReason    string           `json:"cancel_reason"`
+		Events          []etcdWatchEvent `json:"events"`
+	} `json:"result"`
+	Error *etcdError `json:"error"`
+}
+
+// etcdError is an error answer from the gateway
+type etcdError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *etcdError) Error() string {
+	return "etcd: " + e.Message
+}
+
+// grpc status codes the gateway reports that the client acts on
+const (
+	etcdCodeNotFound        = 5
+	etcdCodeUnauthenticated = 16
+)
+
+// errEtcdUnreachable wraps the last transport error once every endpoint failed
+var errEtcdUnreachable = errors.New("etcd unreachable")
+
+// prefixEnd returns the range end that selects every key starting with prefix
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
+
+func (c *etcdClient) Range(ctx context.Context, req etcdRangeRequest) (*etcdRangeResponse, error) {
+	var resp etcdRangeResponse
+	return &resp, c.call(ctx, "/v3/kv/range", req, &resp)
+}
+
+func (c *etcdClient) Txn(ctx context.Context, req etcdTxnRequest) (*etcdTxnResponse, error) {
+	var resp etcdTxnResponse
+	return &resp, c.call(ctx, "/v3/kv/txn", req, &resp)
+}
+
+func (c *etcdClient) Grant(ctx context.Context, ttl time.Duration) (int64, error) {
+	var resp etcdLeaseGrantResponse
+	if err := c.call(ctx, "/v3/lease/grant", etcdLeaseGrantRequest{TTL: etcdInt(ttl / time.Second)}, &resp); err != nil {
+		return 0, err
+	}
+	return int64(resp.ID), nil
+}
+
+// call posts req to path and decodes the answer into resp, trying each endpoint
+// in turn and signing in again once if the auth token has expired
+func (c *etcdClient) call(ctx context.Context, path string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	for attempt := 0; ; attempt++ {
+		httpResp, err := c.post(ctx, path, body)
+		if err != nil {
+			return err
+		}
+		err = decodeEtcdResponse(httpResp, resp)
+		var etcdErr *etcdError
+		if attempt == 0 && c.username != "" && errors.As(err, &etcdErr) && etcdErr.Code == etcdCodeUnauthenticated {
+			c.mu.Lock()
+			c.token = ""
+			c.mu.Unlock()
+			continue
+		}
+		return err
+	}
+}
+
+// post sends body to the first endpoint that answers
+func (c *etcdClient) post(ctx context.Context, path string, body []byte) (*http.Response, error) {
+	c.mu.Lock()
+	first := c.next
+	c.mu.Unlock()
+
+	var lastErr error
+	for i := range c.endpoints {
+		n := (first + i) % len(c.endpoints)
+		resp, err := c.postTo(ctx, c.endpoints[n], path, body)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+		if n != first {
+			c.mu.Lock()
+			c.next = n
+			c.mu.Unlock()
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("%w: %v", errEtcdUnreachable, lastErr)
+}
+
+func (c *etcdClient) postTo(ctx context.Context, endpoint, path string, body []byte) (*http.Response, error) {
+	token, err := c.authToken(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	return c.http.Do(req)
+}
+
+// authToken signs in with the configured user the first time it is needed
+func (c *etcdClient) authToken(ctx context.Context, endpoint string) (string, error) {
+	if c.username == "" {
+		return "", nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" {
+		return c.token, nil
+	}
+
+	body, _ := json.Marshal(map[string]string{"name": c.username, "password": c.password})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/v3/auth/authenticate", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	httpResp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := decodeEtcdResponse(httpResp, &resp); err != nil {
+		return "", fmt.Errorf("etcd authentication failed: %w", err)
+	}
+	c.token = resp.Token
+	return c.token, nil
+}
+
+func decodeEtcdResponse(httpResp *http.Response, resp interface{}) error {
+	defer func() { _ = httpResp.Body.Close() }()
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		etcdErr := &etcdError{}
+		if json.Unmarshal(data, etcdErr) != nil || etcdErr.Message == "" {
+			etcdErr.Message = fmt.Sprintf("%s: %s", httpResp.Status, strings.TrimSpace(string(data)))
+		}
+		return etcdErr
+	}
+	return json.Unmarshal(data, resp)
+}
+
+// Watch streams the changes to keys under prefix from revision startRev on,
+// calling fn for each batch until ctx is done, fn fails or the stream breaks
+func (c *etcdClient) Watch(ctx context.Context, prefix []byte, startRev int64, fn func(*etcdWatchResponse) error) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            prefix,
+			"range_end":      prefixEnd(prefix),
+			"start_revision": etcdInt(startRev),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	httpResp, err := c.post(ctx, "/v3/watch", body)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+	if httpResp.StatusCode != http.StatusOK {
+		return decodeEtcdResponse(httpResp, nil)
+	}
+
+	// The gateway writes one JSON object per watch response
+	dec := json.NewDecoder(bufio.NewReader(httpResp.Body))
+	for {
+		var resp etcdWatchResponse
+		if err := dec.Decode(&resp); err != nil {
+			return err
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if resp.Result == nil {
+			continue
+		}
+		if err := fn(&resp); err != nil {
+			return err
+		}
+	}
+}
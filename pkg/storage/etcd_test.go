@@ -0,0 +1,479 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// fakeEtcd serves the parts of the etcd JSON gateway the backend uses from memory
+type fakeEtcd struct {
+	mu        sync.Mutex
+	rev       int64
+	kvs       map[string]*etcdKeyValue
+	leases    map[int64]bool
+	nextLease int64
+	history   []etcdWatchEvent // Every event, in revision order
+	compacted int64            // Watches starting at or below this revision are canceled
+	changed   chan struct{}    // Closed and replaced on every change, to wake watches
+	closing   chan struct{}    // Closed to break the open watch streams
+}
+
+func newFakeEtcd(t *testing.T) (*fakeEtcd, string) {
+	f := &fakeEtcd{
+		kvs:     make(map[string]*etcdKeyValue),
+		leases:  make(map[int64]bool),
+		changed: make(chan struct{}),
+		closing: make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v3/kv/range", f.handleRange)
+	mux.HandleFunc("/v3/kv/txn", f.handleTxn)
+	mux.HandleFunc("/v3/lease/grant", f.handleGrant)
+	mux.HandleFunc("/v3/watch", f.handleWatch)
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		f.breakWatches()
+		server.Close()
+	})
+	return f, server.URL
+}
+
+func (f *fakeEtcd) reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeEtcd) handleRange(w http.ResponseWriter, r *http.Request) {
+	var req etcdRangeRequest
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var keys []string
+	for key := range f.kvs {
+		if key == string(req.Key) || (req.RangeEnd != nil && key >= string(req.Key) && key < string(req.RangeEnd)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	resp := etcdRangeResponse{Header: etcdHeader{Revision: etcdInt(f.rev)}, Count: etcdInt(len(keys))}
+	if req.CountOnly {
+		f.reply(w, resp)
+		return
+	}
+	if req.Limit > 0 && len(keys) > int(req.Limit) {
+		keys, resp.More = keys[:req.Limit], true
+	}
+	for _, key := range keys {
+		resp.KVs = append(resp.KVs, f.kvs[key])
+	}
+	f.reply(w, resp)
+}
+
+func (f *fakeEtcd) handleTxn(w http.ResponseWriter, r *http.Request) {
+	var req etcdTxnRequest
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, c := range req.Compare {
+		modRev := etcdInt(0)
+		if kv := f.kvs[string(c.Key)]; kv != nil {
+			modRev = kv.ModRevision
+		}
+		if modRev != c.ModRevision {
+			f.reply(w, etcdTxnResponse{Header: etcdHeader{Revision: etcdInt(f.rev)}})
+			return
+		}
+	}
+	for _, op := range req.Success {
+		if op.Put != nil && op.Put.Lease != 0 && !f.leases[int64(op.Put.Lease)] {
+			w.WriteHeader(http.StatusNotFound)
+			f.reply(w, etcdError{Code: etcdCodeNotFound, Message: "etcdserver: requested lease not found"})
+			return
+		}
+	}
+
+	f.rev++
+	for _, op := range req.Success {
+		if op.Put != nil {
+			kv := &etcdKeyValue{Key: op.Put.Key, Value: op.Put.Value, ModRevision: etcdInt(f.rev), Lease: op.Put.Lease}
+			f.kvs[string(op.Put.Key)] = kv
+			f.history = append(f.history, etcdWatchEvent{KV: kv})
+		}
+		if op.Delete != nil {
+			f.deleteLocked(string(op.Delete.Key))
+		}
+	}
+	f.notifyLocked()
+	f.reply(w, etcdTxnResponse{Header: etcdHeader{Revision: etcdInt(f.rev)}, Succeeded: true})
+}
+
+func (f *fakeEtcd) deleteLocked(key string) {
+	if _, ok := f.kvs[key]; !ok {
+		return
+	}
+	delete(f.kvs, key)
+	f.history = append(f.history, etcdWatchEvent{Type: "DELETE", KV: &etcdKeyValue{Key: []byte(key), ModRevision: etcdInt(f.rev)}})
+}
+
+func (f *fakeEtcd) notifyLocked() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeEtcd) handleGrant(w http.ResponseWriter, r *http.Request) {
+	var req etcdLeaseGrantRequest
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextLease++
+	f.leases[f.nextLease] = true
+	f.reply(w, etcdLeaseGrantResponse{ID: etcdInt(f.nextLease), TTL: req.TTL})
+}
+
+func (f *fakeEtcd) handleWatch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Create struct {
+			StartRevision etcdInt `json:"start_revision"`
+		} `json:"create_request"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	flusher := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	next := int64(req.Create.StartRevision)
+
+	f.mu.Lock()
+	closing := f.closing
+	if next <= f.compacted {
+		f.mu.Unlock()
+		_ = enc.Encode(map[string]interface{}{"result": map[string]interface{}{"canceled": true, "compact_revision": etcdInt(f.compacted + 1)}})
+		return
+	}
+	f.mu.Unlock()
+	_ = enc.Encode(map[string]interface{}{"result": map[string]interface{}{"created": true}})
+	flusher.Flush()
+
+	for {
+		f.mu.Lock()
+		var events []etcdWatchEvent
+		for _, ev := range f.history {
+			if int64(ev.KV.ModRevision) >= next {
+				events = append(events, ev)
+			}
+		}
+		changed := f.changed
+		f.mu.Unlock()
+
+		if len(events) > 0 {
+			next = int64(events[len(events)-1].KV.ModRevision) + 1
+			_ = enc.Encode(map[string]interface{}{"result": map[string]interface{}{"events": events}})
+			flusher.Flush()
+		}
+		select {
+		case <-changed:
+		case <-closing:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// expireLease drops a lease and the keys attached to it, as etcd does once it runs out
+func (f *fakeEtcd) expireLease(id int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.leases, id)
+	f.rev++
+	for key, kv := range f.kvs {
+		if int64(kv.Lease) == id {
+			f.deleteLocked(key)
+		}
+	}
+	f.notifyLocked()
+}
+
+// breakWatches ends the open watch streams, as a restarting etcd member would
+func (f *fakeEtcd) breakWatches() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	close(f.closing)
+	f.closing = make(chan struct{})
+}
+
+// compact forgets the history up to the current revision
+func (f *fakeEtcd) compact() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.compacted = f.rev
+	f.history = nil
+}
+
+func (f *fakeEtcd) leaseOf(key string) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if kv := f.kvs[key]; kv != nil {
+		return int64(kv.Lease)
+	}
+	return 0
+}
+
+func newTestEtcdStorage(t *testing.T, endpoint string) *EtcdStorage {
+	t.Helper()
+	s, err := NewEtcdStorage(EtcdOptions{Endpoints: []string{endpoint}, Prefix: "/test"})
+	if err != nil {
+		t.Fatalf("NewEtcdStorage failed: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+// pauseWatch stops s from following changes made elsewhere
+func pauseWatch(s *EtcdStorage) {
+	s.db.cancel()
+	<-s.db.done
+}
+
+// resumeWatch restarts a watch stopped by pauseWatch
+func resumeWatch(s *EtcdStorage) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.db.cancel = cancel
+	s.db.done = make(chan struct{})
+	go s.db.watch(ctx)
+}
+
+// eventually waits for cond, which another replica's watch makes true
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestEtcdStorageReplicasShareWrites(t *testing.T) {
+	_, endpoint := newFakeEtcd(t)
+	a := newTestEtcdStorage(t, endpoint)
+	b := newTestEtcdStorage(t, endpoint)
+
+	client := &models.Client{ID: "billing", Secret: "s3cret", RegistrationAccessToken: "rat"}
+	if err := a.CreateClient(client); err != nil {
+		t.Fatalf("CreateClient failed: %v", err)
+	}
+	client.Secret = "changed by the caller"
+	if got, _ := a.GetClientByID("billing"); got == nil || got.Secret != "s3cret" {
+		t.Fatalf("own write not visible or not copied: %+v", got)
+	}
+	eventually(t, "the client on the other replica", func() bool {
+		got, _ := b.GetClientByID("billing")
+		// Fields the JSON encoding would drop survive
+		return got != nil && got.RegistrationAccessToken == "rat"
+	})
+
+	if err := b.DeleteClient("billing"); err != nil {
+		t.Fatalf("DeleteClient failed: %v", err)
+	}
+	eventually(t, "the delete on the first replica", func() bool {
+		got, _ := a.GetClientByID("billing")
+		return got == nil
+	})
+
+	// A third replica starting later loads what is there
+	if err := a.CreateUser(&models.User{ID: "u1", Username: "alice", Email: "Alice@example.com", PasswordHash: "hash"}); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	c := newTestEtcdStorage(t, endpoint)
+	if got, _ := c.GetUserByEmail("alice@example.com"); got == nil || got.PasswordHash != "hash" {
+		t.Fatalf("user loaded by a new replica = %+v", got)
+	}
+}
+
+func TestEtcdStorageUniqueUsersAcrossReplicas(t *testing.T) {
+	_, endpoint := newFakeEtcd(t)
+	a := newTestEtcdStorage(t, endpoint)
+	b := newTestEtcdStorage(t, endpoint)
+	pauseWatch(b) // b does not see a's writes, as if its watch lagged
+
+	if err := a.CreateUser(&models.User{ID: "u1", Username: "alice", Email: "alice@example.com"}); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	err := b.CreateUser(&models.User{ID: "u2", Username: "alice", Email: "other@example.com"})
+	if err == nil || err.Error() != "username already exists" {
+		t.Errorf("duplicate username on another replica: %v", err)
+	}
+	err = b.CreateUser(&models.User{ID: "u3", Username: "alicia", Email: "ALICE@example.com"})
+	if err == nil || err.Error() != "email already exists" {
+		t.Errorf("duplicate email on another replica: %v", err)
+	}
+
+	// Renaming frees the old name
+	user, _ := a.GetUserByUsername("alice")
+	user.Username = "alice2"
+	if err := a.UpdateUser(user); err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	if err := a.CreateUser(&models.User{ID: "u4", Username: "alice", Email: "new@example.com"}); err != nil {
+		t.Errorf("the old username was not freed: %v", err)
+	}
+	if err := a.DeleteUser("u4"); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+	if got, _ := a.GetUserByUsername("alice"); got != nil {
+		t.Errorf("deleted user still found by username: %+v", got)
+	}
+}
+
+func TestEtcdStorageExpiresRecordsWithLeases(t *testing.T) {
+	fake, endpoint := newFakeEtcd(t)
+	s := newTestEtcdStorage(t, endpoint)
+
+	code := &models.AuthorizationCode{Code: "abc", ClientID: "c", ExpiresAt: time.Now().Add(2 * time.Minute)}
+	if err := s.CreateAuthorizationCode(code); err != nil {
+		t.Fatalf("CreateAuthorizationCode failed: %v", err)
+	}
+	token := &models.Token{ID: "t1", AccessToken: "at", ExpiresAt: time.Now().Add(2 * time.Minute)}
+	if err := s.CreateToken(token); err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+
+	lease := fake.leaseOf("/test/authorization_codes/abc")
+	if lease == 0 {
+		t.Fatal("authorization code stored without a lease")
+	}
+	if fake.leaseOf("/test/tokens/t1") != 0 {
+		t.Error("tokens are kept for retention and should not expire with a lease")
+	}
+
+	fake.expireLease(lease)
+	eventually(t, "the expired code to be dropped", func() bool {
+		got, _ := s.GetAuthorizationCode("abc")
+		return got == nil
+	})
+
+	// A lease etcd no longer knows is replaced
+	if err := s.CreateAuthorizationCode(&models.AuthorizationCode{Code: "def", ExpiresAt: code.ExpiresAt}); err != nil {
+		t.Fatalf("CreateAuthorizationCode with an expired cached lease failed: %v", err)
+	}
+	if got := fake.leaseOf("/test/authorization_codes/def"); got == 0 || got == lease {
+		t.Errorf("lease = %d, want a new one", got)
+	}
+}
+
+func TestEtcdStorageTransactions(t *testing.T) {
+	_, endpoint := newFakeEtcd(t)
+	a := newTestEtcdStorage(t, endpoint)
+	b := newTestEtcdStorage(t, endpoint)
+
+	// Changes are invisible until the transaction commits and dropped if it fails
+	err := a.RunInTransaction(func(tx Storage) error {
+		if err := tx.CreateClient(&models.Client{ID: "draft"}); err != nil {
+			return err
+		}
+		if got, _ := tx.GetClientByID("draft"); got == nil {
+			t.Error("a transaction does not see its own write")
+		}
+		if got, _ := a.GetClientByID("draft"); got != nil {
+			t.Error("an uncommitted write is visible outside the transaction")
+		}
+		return fmt.Errorf("rolled back")
+	})
+	if err == nil || err.Error() != "rolled back" {
+		t.Fatalf("RunInTransaction error = %v", err)
+	}
+	if got, _ := a.GetClientByID("draft"); got != nil {
+		t.Error("a rolled back write was stored")
+	}
+
+	// Records changed with a compare-and-set must be unchanged at commit, so a
+	// transaction losing a race to another replica runs again and sees the winner
+	var runs, wins int
+	err = a.RunInTransaction(func(tx Storage) error {
+		runs++
+		recorded, err := tx.RecordJTI("client", "jti-1", time.Now().Add(time.Minute))
+		if err != nil || !recorded {
+			return err
+		}
+		if runs == 1 {
+			// The other replica sees the same assertion before this transaction commits
+			if won, _ := b.RecordJTI("client", "jti-1", time.Now().Add(time.Minute)); won {
+				wins++
+			}
+		}
+		return tx.CreateToken(&models.Token{ID: fmt.Sprintf("token-%d", runs), ClientID: "client"})
+	})
+	if err != nil {
+		t.Fatalf("RunInTransaction failed: %v", err)
+	}
+	if runs != 2 || wins != 1 {
+		t.Errorf("runs = %d, other replica wins = %d; want the transaction retried after losing", runs, wins)
+	}
+	if tokens, _ := a.ListTokens("client", "", false); len(tokens) != 0 {
+		t.Errorf("the losing transaction stored tokens: %d", len(tokens))
+	}
+}
+
+func TestEtcdStorageWatchRecovers(t *testing.T) {
+	fake, endpoint := newFakeEtcd(t)
+	a := newTestEtcdStorage(t, endpoint)
+	b := newTestEtcdStorage(t, endpoint)
+
+	// A broken stream is resumed from the last revision seen
+	fake.breakWatches()
+	if err := a.CreateClient(&models.Client{ID: "after-break"}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "a write made while the watch was down", func() bool {
+		got, _ := b.GetClientByID("after-break")
+		return got != nil
+	})
+
+	// Once the history it would resume from is compacted, the replica reloads
+	pauseWatch(b)
+	if err := a.DeleteClient("after-break"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.CreateClient(&models.Client{ID: "after-compaction"}); err != nil {
+		t.Fatal(err)
+	}
+	fake.compact()
+	resumeWatch(b)
+	eventually(t, "a reload after compaction", func() bool {
+		gone, _ := b.GetClientByID("after-break")
+		added, _ := b.GetClientByID("after-compaction")
+		return gone == nil && added != nil
+	})
+}
+
+func TestEtcdStorageAuditChainAcrossReplicas(t *testing.T) {
+	_, endpoint := newFakeEtcd(t)
+	a := newTestEtcdStorage(t, endpoint)
+	b := newTestEtcdStorage(t, endpoint)
+	pauseWatch(b)
+
+	// b appends without having seen a's entry and must chain after it
+	if err := a.CreateAuditLog(&models.AuditLog{ID: "e1", Action: "first", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.CreateAuditLog(&models.AuditLog{ID: "e2", Action: "second", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := b.GetAuditChain(0, 0)
+	if len(entries) != 2 || entries[0].Seq != 1 || entries[1].Seq != 2 || entries[1].PrevHash != entries[0].Hash {
+		t.Fatalf("audit chain = %+v", entries)
+	}
+	if entries[1].ComputeHash() != entries[1].Hash {
+		t.Error("stored entry no longer matches its hash")
+	}
+}
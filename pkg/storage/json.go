@@ -4,16 +4,21 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
 	"github.com/prasenjit-net/openid-golang/pkg/models"
 )
 
 // JSONStorage implements Storage interface using JSON file
 type JSONStorage struct {
 	filePath string
+	sealer   *jsonSealer // Encrypts the file at rest; nil keeps it plain JSON
 	mu       sync.RWMutex
+	txMu     sync.Mutex // Serializes transactions
 	data     *JSONData
 }
 
@@ -23,25 +28,56 @@ type JSONUser struct {
 	PasswordHash string `json:"password_hash"`
 }
 
+// withPasswordHash returns a copy of the user with the password hash restored. It
+// is a copy so that concurrent readers never write to the stored user.
+func (u *JSONUser) withPasswordHash() *models.User {
+	user := *u.User
+	user.PasswordHash = u.PasswordHash
+	return &user
+}
+
 // JSONData holds all the data
 type JSONData struct {
-	Users               map[string]*JSONUser                  `json:"users"`
-	Clients             map[string]*models.Client             `json:"clients"`
-	AuthorizationCodes  map[string]*models.AuthorizationCode  `json:"authorization_codes"`
-	Tokens              map[string]*models.Token              `json:"tokens"`
-	Sessions            map[string]*models.Session            `json:"sessions"`
-	AuthSessions        map[string]*models.AuthSession        `json:"auth_sessions"`
-	UserSessions        map[string]*models.UserSession        `json:"user_sessions"`
-	Consents            map[string]*models.Consent            `json:"consents"`              // Key: userID:clientID
-	InitialAccessTokens map[string]*models.InitialAccessToken `json:"initial_access_tokens"` // Key: token
-	SigningKeys         map[string]*models.SigningKey         `json:"signing_keys"`          // Key: key ID
-	AuditLogs           []*models.AuditLog                    `json:"audit_logs"`            // Ordered oldest→newest
+	Users               map[string]*JSONUser                   `json:"users"`
+	Clients             map[string]*models.Client              `json:"clients"`
+	AuthorizationCodes  map[string]*models.AuthorizationCode   `json:"authorization_codes"`
+	Tokens              map[string]*models.Token               `json:"tokens"`
+	Sessions            map[string]*models.Session             `json:"sessions"`
+	AuthSessions        map[string]*models.AuthSession         `json:"auth_sessions"`
+	UserSessions        map[string]*models.UserSession         `json:"user_sessions"`
+	Consents            map[string]*models.Consent             `json:"consents"`              // Key: userID:clientID
+	ConsentReceipts     map[string]*models.ConsentReceipt      `json:"consent_receipts"`      // Key: receipt ID
+	InitialAccessTokens map[string]*models.InitialAccessToken  `json:"initial_access_tokens"` // Key: token
+	APIKeys             map[string]*models.APIKey              `json:"api_keys"`              // Key: key ID
+	ClientInstances     map[string]*models.ClientInstance      `json:"client_instances"`      // Key: instance ID
+	DeviceCodes         map[string]*models.DeviceAuthorization `json:"device_codes"`          // Key: device code
+	ErrorReports        map[string]*models.ErrorReport         `json:"error_reports"`         // Key: reference
+	SigningKeys         map[string]*models.SigningKey          `json:"signing_keys"`          // Key: key ID
+	UsedJTIs            map[string]*models.UsedJTI             `json:"used_jtis"`             // Key: clientID:jti
+	AuditLogs           []*models.AuditLog                     `json:"audit_logs"`            // Ordered oldest→newest
+	AuditCheckpoints    []*models.AuditCheckpoint              `json:"audit_checkpoints"`     // Ordered oldest→newest
 }
 
 // NewJSONStorage creates a new JSON file storage
 func NewJSONStorage(filePath string) (*JSONStorage, error) {
+	return newJSONFileStorage(filePath, nil)
+}
+
+// NewEncryptedJSONStorage creates a JSON file storage whose file is encrypted at
+// rest with key. Files encrypted with one of previousKeys are read and written
+// back with key, and a plain file is encrypted the first time it is opened.
+func NewEncryptedJSONStorage(filePath string, key []byte, previousKeys ...[]byte) (*JSONStorage, error) {
+	sealer, err := newJSONSealer(key, previousKeys...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data file encryption key: %w", err)
+	}
+	return newJSONFileStorage(filePath, sealer)
+}
+
+func newJSONFileStorage(filePath string, sealer *jsonSealer) (*JSONStorage, error) {
 	storage := &JSONStorage{
 		filePath: filePath,
+		sealer:   sealer,
 		data: &JSONData{
 			Users:               make(map[string]*JSONUser),
 			Clients:             make(map[string]*models.Client),
@@ -51,16 +87,28 @@ func NewJSONStorage(filePath string) (*JSONStorage, error) {
 			AuthSessions:        make(map[string]*models.AuthSession),
 			UserSessions:        make(map[string]*models.UserSession),
 			Consents:            make(map[string]*models.Consent),
+			ConsentReceipts:     make(map[string]*models.ConsentReceipt),
 			InitialAccessTokens: make(map[string]*models.InitialAccessToken),
+			APIKeys:             make(map[string]*models.APIKey),
+			ClientInstances:     make(map[string]*models.ClientInstance),
+			DeviceCodes:         make(map[string]*models.DeviceAuthorization),
+			ErrorReports:        make(map[string]*models.ErrorReport),
 			SigningKeys:         make(map[string]*models.SigningKey),
+			UsedJTIs:            make(map[string]*models.UsedJTI),
 		},
 	}
 
 	// Load existing data if file exists
 	if _, err := os.Stat(filePath); err == nil {
-		if loadErr := storage.load(); loadErr != nil {
+		rewrite, loadErr := storage.load()
+		if loadErr != nil {
 			return nil, fmt.Errorf("failed to load existing data: %w", loadErr)
 		}
+		if rewrite {
+			if saveErr := storage.save(); saveErr != nil {
+				return nil, fmt.Errorf("failed to encrypt data file: %w", saveErr)
+			}
+		}
 	} else {
 		// Create new file
 		if saveErr := storage.save(); saveErr != nil {
@@ -71,16 +119,29 @@ func NewJSONStorage(filePath string) (*JSONStorage, error) {
 	return storage, nil
 }
 
-func (j *JSONStorage) load() error {
+// load reads the data file. It reports whether the file should be written again
+// to encrypt it with the current key.
+func (j *JSONStorage) load() (bool, error) {
 	j.mu.Lock()
 	defer j.mu.Unlock()
 
 	data, err := os.ReadFile(j.filePath)
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	rewrite := j.sealer != nil
+	if env := parseJSONEnvelope(data); env != nil {
+		if j.sealer == nil {
+			return false, fmt.Errorf("data file is encrypted; set storage.json_encryption_key")
+		}
+		if data, err = j.sealer.open(env); err != nil {
+			return false, err
+		}
+		rewrite = env.KeyID != j.sealer.current
 	}
 
-	return json.Unmarshal(data, j.data)
+	return rewrite, json.Unmarshal(data, j.data)
 }
 
 func (j *JSONStorage) save() error {
@@ -88,6 +149,11 @@ func (j *JSONStorage) save() error {
 	if err != nil {
 		return err
 	}
+	if j.sealer != nil {
+		if data, err = j.sealer.seal(data); err != nil {
+			return err
+		}
+	}
 
 	return os.WriteFile(j.filePath, data, 0600)
 }
@@ -96,6 +162,76 @@ func (j *JSONStorage) Close() error {
 	return j.save()
 }
 
+// RunInTransaction runs fn and restores the previous data if it returns an error.
+// This is best-effort: transactions are serialized with each other, but writes made
+// outside a transaction while one is running are discarded if it rolls back.
+func (j *JSONStorage) RunInTransaction(fn func(tx Storage) error) error {
+	j.txMu.Lock()
+	defer j.txMu.Unlock()
+
+	j.mu.RLock()
+	snapshot := j.data.clone()
+	j.mu.RUnlock()
+
+	if err := fn(jsonTx{j}); err != nil {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.data = snapshot
+		if saveErr := j.save(); saveErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, saveErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// jsonTx is the storage handed to a transaction; nested transactions join it
+type jsonTx struct {
+	*JSONStorage
+}
+
+func (t jsonTx) RunInTransaction(fn func(tx Storage) error) error {
+	return fn(t)
+}
+
+// clone copies the data deeply enough to undo any update made through the storage API
+func (d *JSONData) clone() *JSONData {
+	users := make(map[string]*JSONUser, len(d.Users))
+	for id, u := range d.Users {
+		user := *u.User
+		users[id] = &JSONUser{User: &user, PasswordHash: u.PasswordHash}
+	}
+	return &JSONData{
+		Users:               users,
+		Clients:             cloneEntities(d.Clients),
+		AuthorizationCodes:  cloneEntities(d.AuthorizationCodes),
+		Tokens:              cloneEntities(d.Tokens),
+		Sessions:            cloneEntities(d.Sessions),
+		AuthSessions:        cloneEntities(d.AuthSessions),
+		UserSessions:        cloneEntities(d.UserSessions),
+		Consents:            cloneEntities(d.Consents),
+		ConsentReceipts:     cloneEntities(d.ConsentReceipts),
+		InitialAccessTokens: cloneEntities(d.InitialAccessTokens),
+		APIKeys:             cloneEntities(d.APIKeys),
+		ClientInstances:     cloneEntities(d.ClientInstances),
+		DeviceCodes:         cloneEntities(d.DeviceCodes),
+		ErrorReports:        cloneEntities(d.ErrorReports),
+		SigningKeys:         cloneEntities(d.SigningKeys),
+		UsedJTIs:            cloneEntities(d.UsedJTIs),
+		AuditLogs:           append([]*models.AuditLog(nil), d.AuditLogs...),
+		AuditCheckpoints:    append([]*models.AuditCheckpoint(nil), d.AuditCheckpoints...),
+	}
+}
+
+func cloneEntities[T any](m map[string]*T) map[string]*T {
+	out := make(map[string]*T, len(m))
+	for k, v := range m {
+		c := *v
+		out[k] = &c
+	}
+	return out
+}
+
 // User operations
 func (j *JSONStorage) CreateUser(user *models.User) error {
 	j.mu.Lock()
@@ -106,13 +242,17 @@ func (j *JSONStorage) CreateUser(user *models.User) error {
 		if u.Username == user.Username {
 			return fmt.Errorf("username already exists")
 		}
-		if u.Email == user.Email {
+		if strings.EqualFold(u.Email, user.Email) {
 			return fmt.Errorf("email already exists")
 		}
 	}
 
-	user.CreatedAt = time.Now()
-	user.UpdatedAt = time.Now()
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	if user.UpdatedAt.IsZero() {
+		user.UpdatedAt = user.CreatedAt
+	}
 
 	// Store as JSONUser with password hash
 	jsonUser := &JSONUser{
@@ -129,10 +269,7 @@ func (j *JSONStorage) GetUserByUsername(username string) (*models.User, error) {
 
 	for _, jsonUser := range j.data.Users {
 		if jsonUser.Username == username {
-			// Restore password hash from JSON storage
-			user := jsonUser.User
-			user.PasswordHash = jsonUser.PasswordHash
-			return user, nil
+			return jsonUser.withPasswordHash(), nil
 		}
 	}
 	return nil, nil
@@ -146,36 +283,78 @@ func (j *JSONStorage) GetUserByID(id string) (*models.User, error) {
 	if !exists {
 		return nil, nil
 	}
-	// Restore password hash from JSON storage
-	user := jsonUser.User
-	user.PasswordHash = jsonUser.PasswordHash
-	return user, nil
+	return jsonUser.withPasswordHash(), nil
 }
 
 func (j *JSONStorage) GetUserByEmail(email string) (*models.User, error) {
 	j.mu.RLock()
 	defer j.mu.RUnlock()
 
+	email = strings.TrimSpace(email)
+	var match *JSONUser
 	for _, jsonUser := range j.data.Users {
 		if jsonUser.Email == email {
-			// Restore password hash from JSON storage
-			user := jsonUser.User
-			user.PasswordHash = jsonUser.PasswordHash
-			return user, nil
+			return jsonUser.withPasswordHash(), nil
+		}
+		if strings.EqualFold(jsonUser.Email, email) {
+			match = jsonUser
+		}
+	}
+	if match != nil {
+		return match.withPasswordHash(), nil
+	}
+	return nil, nil
+}
+
+func (j *JSONStorage) ResolveUserByLoginIdentifier(identifier string, fields []string) (*models.User, error) {
+	identifier = strings.TrimSpace(identifier)
+	if identifier == "" {
+		return nil, nil
+	}
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	for _, field := range fields {
+		var match *JSONUser
+		matches := 0
+		for _, jsonUser := range j.data.Users {
+			value := loginIdentifierValue(jsonUser.User, field)
+			if value == identifier {
+				return jsonUser.withPasswordHash(), nil
+			}
+			if value != "" && strings.EqualFold(value, identifier) {
+				match = jsonUser
+				matches++
+			}
+		}
+		if matches == 1 {
+			return match.withPasswordHash(), nil
 		}
 	}
 	return nil, nil
 }
 
+// loginIdentifierValue returns the user's value of a login identifier field
+func loginIdentifierValue(user *models.User, field string) string {
+	switch field {
+	case configstore.LoginIdentifierUsername:
+		return user.Username
+	case configstore.LoginIdentifierEmail:
+		return user.Email
+	case configstore.LoginIdentifierPhone:
+		return user.PhoneNumber
+	}
+	return ""
+}
+
 func (j *JSONStorage) GetAllUsers() ([]*models.User, error) {
 	j.mu.RLock()
 	defer j.mu.RUnlock()
 
 	users := make([]*models.User, 0, len(j.data.Users))
 	for _, jsonUser := range j.data.Users {
-		user := jsonUser.User
-		user.PasswordHash = jsonUser.PasswordHash
-		users = append(users, user)
+		users = append(users, jsonUser.withPasswordHash())
 	}
 	return users, nil
 }
@@ -188,6 +367,59 @@ func (j *JSONStorage) UpdateUser(user *models.User) error {
 	if !exists {
 		return fmt.Errorf("user not found")
 	}
+	user.Version = jsonUser.Version + 1
+	return j.updateUser(jsonUser, user)
+}
+
+func (j *JSONStorage) UpdateUserIfVersion(user *models.User, version int) (bool, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	jsonUser, exists := j.data.Users[user.ID]
+	if !exists || jsonUser.Version != version {
+		return false, nil
+	}
+	user.Version = version + 1
+	if err := j.updateUser(jsonUser, user); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (j *JSONStorage) UpdatePasswordHash(id, oldHash, newHash string) (bool, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	jsonUser, exists := j.data.Users[id]
+	if !exists || jsonUser.PasswordHash != oldHash {
+		return false, nil
+	}
+	user := *jsonUser.User
+	user.Version++
+	user.UpdatedAt = time.Now()
+	jsonUser.User = &user
+	jsonUser.PasswordHash = newHash
+	if err := j.save(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// updateUser replaces the stored user after checking that its username and email
+// stay unique. The caller must hold the write lock.
+func (j *JSONStorage) updateUser(jsonUser *JSONUser, user *models.User) error {
+	for id, u := range j.data.Users {
+		if id == user.ID {
+			continue
+		}
+		if u.Username == user.Username {
+			return fmt.Errorf("username already exists")
+		}
+		// Only a new address is checked, so a change of case alone is always allowed
+		if user.Email != "" && !strings.EqualFold(user.Email, jsonUser.Email) && strings.EqualFold(u.Email, user.Email) {
+			return fmt.Errorf("email already exists")
+		}
+	}
 
 	user.UpdatedAt = time.Now()
 	user.CreatedAt = jsonUser.CreatedAt // Preserve creation time
@@ -216,7 +448,9 @@ func (j *JSONStorage) CreateClient(client *models.Client) error {
 	j.mu.Lock()
 	defer j.mu.Unlock()
 
-	client.CreatedAt = time.Now()
+	if client.CreatedAt.IsZero() {
+		client.CreatedAt = time.Now()
+	}
 	j.data.Clients[client.ID] = client
 	return j.save()
 }
@@ -229,7 +463,10 @@ func (j *JSONStorage) GetClientByID(id string) (*models.Client, error) {
 	if !exists {
 		return nil, nil
 	}
-	return client, nil
+
+	// A copy, so changes are only stored by an update, which may refuse them
+	copied := *client
+	return &copied, nil
 }
 
 func (j *JSONStorage) GetAllClients() ([]*models.Client, error) {
@@ -252,12 +489,33 @@ func (j *JSONStorage) UpdateClient(client *models.Client) error {
 		return fmt.Errorf("client not found")
 	}
 
-	// Preserve creation time
+	// Preserve creation time and last use, which TouchClient maintains
 	client.CreatedAt = existing.CreatedAt
+	client.LastUsedAt = existing.LastUsedAt
+	client.Version = existing.Version + 1
 	j.data.Clients[client.ID] = client
 	return j.save()
 }
 
+func (j *JSONStorage) UpdateClientIfVersion(client *models.Client, version int) (bool, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	existing, exists := j.data.Clients[client.ID]
+	if !exists || existing.Version != version {
+		return false, nil
+	}
+
+	client.CreatedAt = existing.CreatedAt
+	client.LastUsedAt = existing.LastUsedAt
+	client.Version = version + 1
+	j.data.Clients[client.ID] = client
+	if err := j.save(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (j *JSONStorage) DeleteClient(id string) error {
 	j.mu.Lock()
 	defer j.mu.Unlock()
@@ -281,7 +539,23 @@ func (j *JSONStorage) ValidateClient(clientID, clientSecret string) (*models.Cli
 	if client.Secret != clientSecret {
 		return nil, nil
 	}
-	return client, nil
+	copied := *client
+	return &copied, nil
+}
+
+func (j *JSONStorage) TouchClient(id string, usedAt time.Time) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	client, exists := j.data.Clients[id]
+	if !exists {
+		return fmt.Errorf("client not found")
+	}
+	touched := *client
+	touched.LastUsedAt = &usedAt
+	touched.Version++
+	j.data.Clients[id] = &touched
+	return j.save()
 }
 
 // Authorization code operations
@@ -298,17 +572,16 @@ func (j *JSONStorage) GetAuthorizationCode(code string) (*models.AuthorizationCo
 	j.mu.RLock()
 	defer j.mu.RUnlock()
 
+	// Expired codes are returned too, so the token endpoint can tell a late
+	// exchange from an unknown code
 	authCode, exists := j.data.AuthorizationCodes[code]
 	if !exists {
 		return nil, nil
 	}
 
-	// Check if expired
-	if time.Now().After(authCode.ExpiresAt) {
-		return nil, nil
-	}
-
-	return authCode, nil
+	// A copy, so callers can change it without racing other requests
+	copied := *authCode
+	return &copied, nil
 }
 
 func (j *JSONStorage) UpdateAuthorizationCode(code *models.AuthorizationCode) error {
@@ -323,6 +596,21 @@ func (j *JSONStorage) UpdateAuthorizationCode(code *models.AuthorizationCode) er
 	return j.save()
 }
 
+func (j *JSONStorage) MarkAuthorizationCodeUsed(code string, usedAt time.Time) (bool, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	authCode, exists := j.data.AuthorizationCodes[code]
+	if !exists || authCode.Used {
+		return false, nil
+	}
+	used := *authCode
+	used.Used = true
+	used.UsedAt = &usedAt
+	j.data.AuthorizationCodes[code] = &used
+	return true, j.save()
+}
+
 func (j *JSONStorage) DeleteAuthorizationCode(code string) error {
 	j.mu.Lock()
 	defer j.mu.Unlock()
@@ -403,6 +691,19 @@ func (j *JSONStorage) RevokeTokensByAuthCode(authCodeID string) error {
 	return j.save()
 }
 
+// RevokeTokensBySession deletes all tokens bound to a user session
+func (j *JSONStorage) RevokeTokensBySession(sessionID string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for tokenID, token := range j.data.Tokens {
+		if token.SessionID == sessionID {
+			delete(j.data.Tokens, tokenID)
+		}
+	}
+	return j.save()
+}
+
 // ListTokens returns tokens optionally filtered by clientID, userID, and active status.
 func (j *JSONStorage) ListTokens(clientID, userID string, activeOnly bool) ([]*models.Token, error) {
 	j.mu.RLock()
@@ -425,6 +726,24 @@ func (j *JSONStorage) ListTokens(clientID, userID string, activeOnly bool) ([]*m
 	return tokens, nil
 }
 
+func (j *JSONStorage) MarkTokenReplaced(id, replacementID string, replacedAt, expiresAt time.Time) (bool, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	token, exists := j.data.Tokens[id]
+	if !exists || token.ReplacedBy != "" {
+		return false, nil
+	}
+	replaced := *token
+	replaced.ReplacedBy = replacementID
+	replaced.ReplacedAt = replacedAt
+	if expiresAt.Before(replaced.ExpiresAt) {
+		replaced.ExpiresAt = expiresAt
+	}
+	j.data.Tokens[id] = &replaced
+	return true, j.save()
+}
+
 // Session operations
 func (j *JSONStorage) CreateSession(session *models.Session) error {
 	j.mu.Lock()
@@ -505,6 +824,41 @@ func (j *JSONStorage) DeleteAuthSession(sessionID string) error {
 	return j.save()
 }
 
+// GetAuthSessionsByOwner returns all non-expired auth sessions started by owner.
+func (j *JSONStorage) GetAuthSessionsByOwner(owner string) ([]*models.AuthSession, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	now := time.Now()
+	var sessions []*models.AuthSession
+	for _, session := range j.data.AuthSessions {
+		if session.Owner == owner && now.Before(session.ExpiresAt) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+// CleanupExpiredAuthSessions removes expired auth sessions and returns how many were deleted.
+func (j *JSONStorage) CleanupExpiredAuthSessions() (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	deleted := 0
+	for id, session := range j.data.AuthSessions {
+		if now.After(session.ExpiresAt) {
+			delete(j.data.AuthSessions, id)
+			deleted++
+		}
+	}
+
+	if deleted > 0 {
+		return deleted, j.save()
+	}
+	return 0, nil
+}
+
 // UserSession operations
 func (j *JSONStorage) CreateUserSession(session *models.UserSession) error {
 	j.mu.Lock()
@@ -555,6 +909,20 @@ func (j *JSONStorage) GetUserSessionByUserID(userID string) (*models.UserSession
 	return latestSession, nil
 }
 
+// GetUserSessionsByUserID returns all stored sessions of a user, expired or not
+func (j *JSONStorage) GetUserSessionsByUserID(userID string) ([]*models.UserSession, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	var sessions []*models.UserSession
+	for _, session := range j.data.UserSessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
 func (j *JSONStorage) UpdateUserSession(session *models.UserSession) error {
 	j.mu.Lock()
 	defer j.mu.Unlock()
@@ -603,6 +971,14 @@ func (j *JSONStorage) CleanupExpiredSessions() error {
 		}
 	}
 
+	// Clean up expired client assertion JTIs
+	for id, used := range j.data.UsedJTIs {
+		if now.After(used.ExpiresAt) {
+			delete(j.data.UsedJTIs, id)
+			deleted++
+		}
+	}
+
 	if deleted > 0 {
 		return j.save()
 	}
@@ -674,6 +1050,73 @@ func (j *JSONStorage) DeleteConsentsForUser(userID string) error {
 	return nil
 }
 
+func (j *JSONStorage) GetConsentsByUserID(userID string) ([]*models.Consent, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	var consents []*models.Consent
+	for _, consent := range j.data.Consents {
+		if consent.UserID == userID {
+			consents = append(consents, consent)
+		}
+	}
+	return consents, nil
+}
+
+// ConsentReceipt operations
+func (j *JSONStorage) CreateConsentReceipt(receipt *models.ConsentReceipt) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if receipt.CreatedAt.IsZero() {
+		receipt.CreatedAt = time.Now()
+	}
+	if j.data.ConsentReceipts == nil {
+		j.data.ConsentReceipts = make(map[string]*models.ConsentReceipt)
+	}
+	j.data.ConsentReceipts[receipt.ID] = receipt
+	return j.save()
+}
+
+// ListConsentReceipts returns receipts optionally filtered by userID and clientID, oldest first
+func (j *JSONStorage) ListConsentReceipts(userID, clientID string) ([]*models.ConsentReceipt, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	var receipts []*models.ConsentReceipt
+	for _, receipt := range j.data.ConsentReceipts {
+		if userID != "" && receipt.UserID != userID {
+			continue
+		}
+		if clientID != "" && receipt.ClientID != clientID {
+			continue
+		}
+		receipts = append(receipts, receipt)
+	}
+	sort.Slice(receipts, func(a, b int) bool {
+		return receipts[a].CreatedAt.Before(receipts[b].CreatedAt)
+	})
+	return receipts, nil
+}
+
+func (j *JSONStorage) DeleteConsentReceiptsForUser(userID string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	deleted := 0
+	for id, receipt := range j.data.ConsentReceipts {
+		if receipt.UserID == userID {
+			delete(j.data.ConsentReceipts, id)
+			deleted++
+		}
+	}
+
+	if deleted > 0 {
+		return j.save()
+	}
+	return nil
+}
+
 // ============================================================================
 // Initial Access Token Operations
 // ============================================================================
@@ -705,6 +1148,23 @@ func (j *JSONStorage) UpdateInitialAccessToken(token *models.InitialAccessToken)
 	return j.save()
 }
 
+func (j *JSONStorage) UseInitialAccessToken(token, clientID string, maxUses int, usedAt time.Time) (bool, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	t, exists := j.data.InitialAccessTokens[token]
+	if !exists || t.Used || t.UseCount >= maxUses {
+		return false, nil
+	}
+	used := *t
+	used.UseCount++
+	used.UsedAt = &usedAt
+	used.UsedBy = clientID
+	used.Used = used.UseCount >= maxUses
+	j.data.InitialAccessTokens[token] = &used
+	return true, j.save()
+}
+
 func (j *JSONStorage) DeleteInitialAccessToken(token string) error {
 	j.mu.Lock()
 	defer j.mu.Unlock()
@@ -724,6 +1184,238 @@ func (j *JSONStorage) GetAllInitialAccessTokens() ([]*models.InitialAccessToken,
 	return tokens, nil
 }
 
+// ============================================================================
+// API Key Operations
+// ============================================================================
+
+func (j *JSONStorage) CreateAPIKey(key *models.APIKey) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	if j.data.APIKeys == nil {
+		j.data.APIKeys = make(map[string]*models.APIKey)
+	}
+	j.data.APIKeys[key.ID] = key
+	return j.save()
+}
+
+func (j *JSONStorage) GetAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	for _, key := range j.data.APIKeys {
+		if key.KeyHash == keyHash {
+			return key, nil
+		}
+	}
+	return nil, nil
+}
+
+// ListAPIKeys returns the keys of a service account, oldest first
+func (j *JSONStorage) ListAPIKeys(userID string) ([]*models.APIKey, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	var keys []*models.APIKey
+	for _, key := range j.data.APIKeys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		return keys[a].CreatedAt.Before(keys[b].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (j *JSONStorage) UpdateAPIKey(key *models.APIKey) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.data.APIKeys[key.ID] = key
+	return j.save()
+}
+
+func (j *JSONStorage) DeleteAPIKey(id string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	delete(j.data.APIKeys, id)
+	return j.save()
+}
+
+func (j *JSONStorage) DeleteAPIKeysForUser(userID string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	deleted := 0
+	for id, key := range j.data.APIKeys {
+		if key.UserID == userID {
+			delete(j.data.APIKeys, id)
+			deleted++
+		}
+	}
+
+	if deleted > 0 {
+		return j.save()
+	}
+	return nil
+}
+
+// ============================================================================
+// Client Instance Operations
+// ============================================================================
+
+func (j *JSONStorage) CreateClientInstance(instance *models.ClientInstance) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if instance.CreatedAt.IsZero() {
+		instance.CreatedAt = time.Now()
+	}
+	if j.data.ClientInstances == nil {
+		j.data.ClientInstances = make(map[string]*models.ClientInstance)
+	}
+	j.data.ClientInstances[instance.ID] = instance
+	return j.save()
+}
+
+func (j *JSONStorage) GetClientInstance(id string) (*models.ClientInstance, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	return j.data.ClientInstances[id], nil
+}
+
+// ListClientInstances returns the instances of a client, oldest first
+func (j *JSONStorage) ListClientInstances(clientID string) ([]*models.ClientInstance, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	var instances []*models.ClientInstance
+	for _, instance := range j.data.ClientInstances {
+		if instance.ClientID == clientID {
+			instances = append(instances, instance)
+		}
+	}
+	sort.Slice(instances, func(a, b int) bool {
+		return instances[a].CreatedAt.Before(instances[b].CreatedAt)
+	})
+	return instances, nil
+}
+
+func (j *JSONStorage) UpdateClientInstance(instance *models.ClientInstance) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.data.ClientInstances[instance.ID] = instance
+	return j.save()
+}
+
+func (j *JSONStorage) DeleteClientInstance(id string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	delete(j.data.ClientInstances, id)
+	return j.save()
+}
+
+// ============================================================================
+// Device Authorization Operations
+// ============================================================================
+
+func (j *JSONStorage) CreateDeviceAuthorization(auth *models.DeviceAuthorization) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if auth.CreatedAt.IsZero() {
+		auth.CreatedAt = time.Now()
+	}
+	if j.data.DeviceCodes == nil {
+		j.data.DeviceCodes = make(map[string]*models.DeviceAuthorization)
+	}
+	// Expired requests are dropped here, since nothing else polls for them
+	for code, existing := range j.data.DeviceCodes {
+		if existing.IsExpired() {
+			delete(j.data.DeviceCodes, code)
+		}
+	}
+	j.data.DeviceCodes[auth.DeviceCode] = auth
+	return j.save()
+}
+
+func (j *JSONStorage) GetDeviceAuthorization(deviceCode string) (*models.DeviceAuthorization, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	return j.data.DeviceCodes[deviceCode], nil
+}
+
+func (j *JSONStorage) GetDeviceAuthorizationByUserCode(userCode string) (*models.DeviceAuthorization, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	for _, auth := range j.data.DeviceCodes {
+		if auth.UserCode == userCode {
+			return auth, nil
+		}
+	}
+	return nil, nil
+}
+
+func (j *JSONStorage) UpdateDeviceAuthorization(auth *models.DeviceAuthorization) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.data.DeviceCodes[auth.DeviceCode] = auth
+	return j.save()
+}
+
+func (j *JSONStorage) DeleteDeviceAuthorization(deviceCode string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	delete(j.data.DeviceCodes, deviceCode)
+	return j.save()
+}
+
+// ============================================================================
+// Error Report Operations
+// ============================================================================
+
+func (j *JSONStorage) CreateErrorReport(report *models.ErrorReport) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if report.CreatedAt.IsZero() {
+		report.CreatedAt = time.Now()
+	}
+	if j.data.ErrorReports == nil {
+		j.data.ErrorReports = make(map[string]*models.ErrorReport)
+	}
+	for reference, existing := range j.data.ErrorReports {
+		if time.Now().After(existing.ExpiresAt) {
+			delete(j.data.ErrorReports, reference)
+		}
+	}
+	j.data.ErrorReports[report.Reference] = report
+	return j.save()
+}
+
+func (j *JSONStorage) GetErrorReport(reference string) (*models.ErrorReport, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	report, exists := j.data.ErrorReports[reference]
+	if !exists || time.Now().After(report.ExpiresAt) {
+		return nil, nil
+	}
+	return report, nil
+}
+
 // SigningKey operations
 
 func (j *JSONStorage) CreateSigningKey(key *models.SigningKey) error {
@@ -773,7 +1465,7 @@ func (j *JSONStorage) GetActiveSigningKey() (*models.SigningKey, error) {
 	defer j.mu.RUnlock()
 
 	for _, key := range j.data.SigningKeys {
-		if key.IsActive && !key.IsExpired() {
+		if key.IsActive && !key.IsExpired() && key.IsSigningKey() {
 			return key, nil
 		}
 	}
@@ -800,6 +1492,24 @@ func (j *JSONStorage) DeleteSigningKey(id string) error {
 	return j.save()
 }
 
+// RecordJTI stores a client assertion JWT ID, returning false if it was already used
+func (j *JSONStorage) RecordJTI(clientID, jti string, expiresAt time.Time) (bool, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.data.UsedJTIs == nil {
+		j.data.UsedJTIs = make(map[string]*models.UsedJTI)
+	}
+
+	id := clientID + ":" + jti
+	if used, exists := j.data.UsedJTIs[id]; exists && time.Now().Before(used.ExpiresAt) {
+		return false, nil
+	}
+
+	j.data.UsedJTIs[id] = &models.UsedJTI{ID: id, ClientID: clientID, JTI: jti, ExpiresAt: expiresAt}
+	return true, j.save()
+}
+
 // GetActiveTokensCount returns the count of non-expired tokens
 func (j *JSONStorage) GetActiveTokensCount() int {
 	j.mu.RLock()
@@ -836,12 +1546,60 @@ func (j *JSONStorage) GetRecentUserSessionsCount() int {
 func (j *JSONStorage) CreateAuditLog(entry *models.AuditLog) error {
 	j.mu.Lock()
 	defer j.mu.Unlock()
+	entry.ChainAfter(j.auditChainHead())
 	j.data.AuditLogs = append(j.data.AuditLogs, entry)
 	return j.save()
 }
 
+// auditChainHead returns the latest chained entry; entries written before chaining
+// was introduced have no sequence number. Callers must hold j.mu.
+func (j *JSONStorage) auditChainHead() *models.AuditLog {
+	for i := len(j.data.AuditLogs) - 1; i >= 0; i-- {
+		if e := j.data.AuditLogs[i]; e.Seq > 0 {
+			return e
+		}
+	}
+	return nil
+}
+
+func (j *JSONStorage) GetAuditChain(afterSeq int64, limit int) ([]*models.AuditLog, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	var entries []*models.AuditLog
+	for _, e := range j.data.AuditLogs {
+		if e.Seq > afterSeq {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Seq < entries[b].Seq })
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+func (j *JSONStorage) GetAuditChainHead() (*models.AuditLog, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.auditChainHead(), nil
+}
+
+func (j *JSONStorage) CreateAuditCheckpoint(checkpoint *models.AuditCheckpoint) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.data.AuditCheckpoints = append(j.data.AuditCheckpoints, checkpoint)
+	return j.save()
+}
+
+func (j *JSONStorage) ListAuditCheckpoints() ([]*models.AuditCheckpoint, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return append([]*models.AuditCheckpoint(nil), j.data.AuditCheckpoints...), nil
+}
+
 // GetAuditLogs returns audit log entries in reverse-chronological order,
-// optionally filtered by Action and/or Actor. Pagination is via Limit/Offset.
+// optionally filtered by Action, Actor and/or Subject. Pagination is via Limit/Offset.
 func (j *JSONStorage) GetAuditLogs(filter models.AuditFilter) ([]*models.AuditLog, error) {
 	j.mu.RLock()
 	defer j.mu.RUnlock()
@@ -857,6 +1615,9 @@ func (j *JSONStorage) GetAuditLogs(filter models.AuditFilter) ([]*models.AuditLo
 		if filter.Actor != "" && e.Actor != filter.Actor {
 			continue
 		}
+		if filter.Subject != "" && e.Actor != filter.Subject && e.ResourceID != filter.Subject {
+			continue
+		}
 		matched = append(matched, e)
 	}
 
@@ -888,7 +1649,126 @@ func (j *JSONStorage) GetAuditLogsCount(filter models.AuditFilter) int {
 		if filter.Actor != "" && e.Actor != filter.Actor {
 			continue
 		}
+		if filter.Subject != "" && e.Actor != filter.Subject && e.ResourceID != filter.Subject {
+			continue
+		}
 		count++
 	}
 	return count
 }
+
+// AnonymizeAuditLogs pseudonymizes the entries that mention any of subjects.
+func (j *JSONStorage) AnonymizeAuditLogs(subjects []string, pseudonym string) ([]models.AuditRedaction, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var redactions []models.AuditRedaction
+	for i, e := range j.data.AuditLogs {
+		// Rewrite a copy so a transaction snapshot still holds the original entry
+		entry := *e
+		entry.Details = make(map[string]interface{}, len(e.Details))
+		for k, v := range e.Details {
+			entry.Details[k] = v
+		}
+		if r, ok := anonymizeAuditLog(&entry, subjects, pseudonym); ok {
+			j.data.AuditLogs[i] = &entry
+			redactions = append(redactions, r)
+		}
+	}
+	if len(redactions) > 0 {
+		return redactions, j.save()
+	}
+	return nil, nil
+}
+
+// DeleteAuditLogsBefore deletes audit entries logged before cutoff
+func (j *JSONStorage) DeleteAuditLogsBefore(cutoff time.Time) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	head := j.auditChainHead()
+	kept := j.data.AuditLogs[:0:0]
+	for _, e := range j.data.AuditLogs {
+		if !e.Timestamp.Before(cutoff) || e == head {
+			kept = append(kept, e)
+		}
+	}
+	deleted := len(j.data.AuditLogs) - len(kept)
+	if deleted == 0 {
+		return 0, nil
+	}
+	j.data.AuditLogs = kept
+	return deleted, j.save()
+}
+
+// DeleteTokensExpiredBefore deletes tokens whose access token expired before cutoff
+func (j *JSONStorage) DeleteTokensExpiredBefore(cutoff time.Time) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	deleted := 0
+	for id, token := range j.data.Tokens {
+		if token.ExpiresAt.Before(cutoff) {
+			delete(j.data.Tokens, id)
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		return deleted, j.save()
+	}
+	return 0, nil
+}
+
+// DeleteAuthorizationCodesExpiredBefore deletes authorization codes that expired before cutoff
+func (j *JSONStorage) DeleteAuthorizationCodesExpiredBefore(cutoff time.Time) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	deleted := 0
+	for code, authCode := range j.data.AuthorizationCodes {
+		if authCode.ExpiresAt.Before(cutoff) {
+			delete(j.data.AuthorizationCodes, code)
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		return deleted, j.save()
+	}
+	return 0, nil
+}
+
+// DeleteUserSessionsIdleSince deletes user sessions last used before cutoff
+func (j *JSONStorage) DeleteUserSessionsIdleSince(cutoff time.Time) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	deleted := 0
+	for id, session := range j.data.UserSessions {
+		if session.LastActivityAt.Before(cutoff) {
+			delete(j.data.UserSessions, id)
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		return deleted, j.save()
+	}
+	return 0, nil
+}
+
+// DeleteAuthSessionsCreatedBefore deletes authorization sessions started before cutoff
+func (j *JSONStorage) DeleteAuthSessionsCreatedBefore(cutoff time.Time) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	deleted := 0
+	for id, session := range j.data.AuthSessions {
+		if session.CreatedAt.Before(cutoff) {
+			delete(j.data.AuthSessions, id)
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		return deleted, j.save()
+	}
+	return 0, nil
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The JSON data file can be encrypted at rest, so that a copied or backed-up file
+// does not expose client secrets, tokens and sessions. The whole file is sealed
+// with AES-256-GCM and written as a small JSON envelope naming the key it was
+// sealed with. Keys are given as references such as "env:OPENID_DATA_KEY" or
+// "file:/run/secrets/data-key", each holding 32 base64-encoded bytes; other
+// schemes, such as a cloud KMS, can be added with RegisterKeySource.
+
+// jsonEncryptionAlg is the only algorithm the data file is sealed with
+const jsonEncryptionAlg = "A256GCM"
+
+// jsonEnvelope is the on-disk form of an encrypted data file
+type jsonEnvelope struct {
+	Encrypted  string `json:"encrypted"` // Algorithm, always A256GCM
+	KeyID      string `json:"kid"`       // Identifies the key without revealing it
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// KeySource resolves the part of a key reference after its scheme to the raw key
+// bytes
+type KeySource func(ref string) ([]byte, error)
+
+var (
+	keySourcesMu sync.RWMutex
+	keySources   = map[string]KeySource{
+		"env":  envKeySource,
+		"file": fileKeySource,
+	}
+)
+
+// RegisterKeySource makes scheme:ref key references resolve through source, for
+// example to decrypt a data key with a cloud KMS. Sources built outside this
+// package call it from an init function. It panics if scheme is empty or already
+// registered, or source is nil.
+func RegisterKeySource(scheme string, source KeySource) {
+	keySourcesMu.Lock()
+	defer keySourcesMu.Unlock()
+	if scheme == "" {
+		panic("storage: RegisterKeySource called with an empty scheme")
+	}
+	if source == nil {
+		panic("storage: RegisterKeySource source is nil for scheme " + scheme)
+	}
+	if _, dup := keySources[scheme]; dup {
+		panic("storage: RegisterKeySource called twice for scheme " + scheme)
+	}
+	keySources[scheme] = source
+}
+
+// ResolveEncryptionKey returns the AES-256 key a reference such as
+// "env:OPENID_DATA_KEY" points to
+func ResolveEncryptionKey(reference string) ([]byte, error) {
+	scheme, ref, ok := strings.Cut(reference, ":")
+	if !ok || ref == "" {
+		return nil, fmt.Errorf("invalid key reference %q (expected scheme:reference, e.g. env:OPENID_DATA_KEY)", reference)
+	}
+	keySourcesMu.RLock()
+	source, found := keySources[scheme]
+	keySourcesMu.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("unsupported key source %q", scheme)
+	}
+	key, err := source(ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %s: %w", reference, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key %s must be 32 bytes, got %d", reference, len(key))
+	}
+	return key, nil
+}
+
+// envKeySource reads a base64-encoded key from an environment variable
+func envKeySource(name string) ([]byte, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil, fmt.Errorf("environment variable %s is not set", name)
+	}
+	return decodeKey(value)
+}
+
+// fileKeySource reads a base64-encoded key from a file, such as a mounted secret
+func fileKeySource(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeKey(string(data))
+}
+
+// decodeKey accepts standard and URL-safe base64, with or without padding
+func decodeKey(value string) ([]byte, error) {
+	value = strings.TrimRight(strings.TrimSpace(value), "=")
+	if key, err := base64.RawStdEncoding.DecodeString(value); err == nil {
+		return key, nil
+	}
+	key, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("key is not base64")
+	}
+	return key, nil
+}
+
+// jsonSealer encrypts the data file with its current key and decrypts files
+// sealed with the current or a previous key
+type jsonSealer struct {
+	current string // Key ID new files are sealed with
+	aeads   map[string]cipher.AEAD
+}
+
+func newJSONSealer(key []byte, previousKeys ...[]byte) (*jsonSealer, error) {
+	s := &jsonSealer{aeads: make(map[string]cipher.AEAD)}
+	for i, k := range append([][]byte{key}, previousKeys...) {
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		id := encryptionKeyID(k)
+		if i == 0 {
+			s.current = id
+		}
+		if _, dup := s.aeads[id]; !dup {
+			s.aeads[id] = aead
+		}
+	}
+	return s, nil
+}
+
+// encryptionKeyID names a key by a short digest, so a file can say which key
+// sealed it
+func encryptionKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// seal wraps plaintext in an envelope sealed with the current key
+func (s *jsonSealer) seal(plaintext []byte) ([]byte, error) {
+	aead := s.aeads[s.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ciphertext := aead.Seal(nil, nonce, plaintext, envelopeAAD(s.current))
+	return json.MarshalIndent(jsonEnvelope{
+		Encrypted:  jsonEncryptionAlg,
+		KeyID:      s.current,
+		Nonce:      base64.RawStdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.RawStdEncoding.EncodeToString(ciphertext),
+	}, "", "  ")
+}
+
+// open decrypts an envelope sealed with any of the sealer's keys
+func (s *jsonSealer) open(env *jsonEnvelope) ([]byte, error) {
+	if env.Encrypted != jsonEncryptionAlg {
+		return nil, fmt.Errorf("unsupported data file encryption %q", env.Encrypted)
+	}
+	aead, ok := s.aeads[env.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("data file is encrypted with key %s, which is not configured", env.KeyID)
+	}
+	nonce, err := base64.RawStdEncoding.DecodeString(env.Nonce)
+	if err != nil || len(nonce) != aead.NonceSize() {
+		return nil, errors.New("malformed data file nonce")
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(env.Ciphertext)
+	if err != nil {
+		return nil, errors.New("malformed data file ciphertext")
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, envelopeAAD(env.KeyID))
+	if err != nil {
+		return nil, errors.New("data file failed to decrypt; it is corrupt or was modified")
+	}
+	return plaintext, nil
+}
+
+// envelopeAAD binds the ciphertext to the algorithm and key named in the envelope
+func envelopeAAD(keyID string) []byte {
+	return []byte(jsonEncryptionAlg + "." + keyID)
+}
+
+// parseJSONEnvelope returns the envelope of an encrypted data file, or nil for a
+// plain one
+func parseJSONEnvelope(data []byte) *jsonEnvelope {
+	var env jsonEnvelope
+	if err := json.Unmarshal(data, &env); err != nil || env.Encrypted == "" {
+		return nil
+	}
+	return &env
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func testEncryptionKey(fill byte) []byte {
+	return bytes.Repeat([]byte{fill}, 32)
+}
+
+func TestEncryptedJSONStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	plain, err := NewJSONStorage(path)
+	if err != nil {
+		t.Fatalf("NewJSONStorage failed: %v", err)
+	}
+	if err := plain.CreateClient(&models.Client{ID: "billing", Secret: "top-secret-value"}); err != nil {
+		t.Fatalf("CreateClient failed: %v", err)
+	}
+
+	// A plain file is encrypted when it is first opened with a key
+	oldKey, newKey := testEncryptionKey(1), testEncryptionKey(2)
+	store, err := NewEncryptedJSONStorage(path, oldKey)
+	if err != nil {
+		t.Fatalf("NewEncryptedJSONStorage failed: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if bytes.Contains(data, []byte("top-secret-value")) || bytes.Contains(data, []byte("billing")) {
+		t.Fatalf("data file is not encrypted: %s", data)
+	}
+	if env := parseJSONEnvelope(data); env == nil || env.KeyID != encryptionKeyID(oldKey) {
+		t.Fatalf("data file envelope = %+v", env)
+	}
+	if client, _ := store.GetClientByID("billing"); client == nil || client.Secret != "top-secret-value" {
+		t.Fatalf("client after encryption = %+v", client)
+	}
+
+	// Without the key, or with another one, the file cannot be read
+	if _, err := NewJSONStorage(path); err == nil || !strings.Contains(err.Error(), "json_encryption_key") {
+		t.Errorf("NewJSONStorage of an encrypted file: %v", err)
+	}
+	if _, err := NewEncryptedJSONStorage(path, newKey); err == nil || !strings.Contains(err.Error(), encryptionKeyID(oldKey)) {
+		t.Errorf("NewEncryptedJSONStorage with the wrong key: %v", err)
+	}
+
+	// Rotation reads the file with the previous key and writes it with the new one
+	if _, err := NewEncryptedJSONStorage(path, newKey, oldKey); err != nil {
+		t.Fatalf("NewEncryptedJSONStorage with a previous key failed: %v", err)
+	}
+	data, _ = os.ReadFile(path)
+	if env := parseJSONEnvelope(data); env == nil || env.KeyID != encryptionKeyID(newKey) {
+		t.Fatalf("data file envelope after rotation = %+v", env)
+	}
+	rotated, err := NewEncryptedJSONStorage(path, newKey)
+	if err != nil {
+		t.Fatalf("NewEncryptedJSONStorage after rotation failed: %v", err)
+	}
+	if client, _ := rotated.GetClientByID("billing"); client == nil {
+		t.Fatal("client lost in rotation")
+	}
+
+	// Tampering is detected
+	tampered := bytes.Replace(data, []byte(`"ciphertext": "`), []byte(`"ciphertext": "AAAA`), 1)
+	if err := os.WriteFile(path, tampered, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewEncryptedJSONStorage(path, newKey); err == nil {
+		t.Error("a modified data file was accepted")
+	}
+}
+
+func TestNewStorageEncryptionKeyReferences(t *testing.T) {
+	dir := t.TempDir()
+	key := testEncryptionKey(3)
+	t.Setenv("TEST_OPENID_DATA_KEY", base64.StdEncoding.EncodeToString(key))
+	keyFile := filepath.Join(dir, "data-key")
+	if err := os.WriteFile(keyFile, []byte(base64.URLEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	RegisterKeySource("test-kms", func(ref string) ([]byte, error) {
+		if ref != "projects/p/keys/data" {
+			t.Errorf("key source got %q", ref)
+		}
+		return key, nil
+	})
+
+	for _, reference := range []string{"env:TEST_OPENID_DATA_KEY", "file:" + keyFile, "test-kms:projects/p/keys/data"} {
+		cfg := &configstore.ConfigData{Storage: configstore.StorageBackendConfig{
+			Type:              "json",
+			JSONFilePath:      filepath.Join(dir, "data.json"),
+			JSONEncryptionKey: reference,
+		}}
+		store, err := NewStorage(cfg)
+		if err != nil {
+			t.Fatalf("NewStorage with %s failed: %v", reference, err)
+		}
+		_ = store.Close()
+	}
+
+	for _, reference := range []string{"OPENID_DATA_KEY", "env:TEST_OPENID_UNSET_KEY", "vault:secret/data", "file:" + filepath.Join(dir, "missing")} {
+		if _, err := ResolveEncryptionKey(reference); err == nil {
+			t.Errorf("ResolveEncryptionKey(%q) succeeded", reference)
+		}
+	}
+	t.Setenv("TEST_OPENID_SHORT_KEY", base64.StdEncoding.EncodeToString(key[:16]))
+	if _, err := ResolveEncryptionKey("env:TEST_OPENID_SHORT_KEY"); err == nil || !strings.Contains(err.Error(), "32 bytes") {
+		t.Errorf("a 16-byte key was accepted: %v", err)
+	}
+}
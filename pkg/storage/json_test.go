@@ -0,0 +1,310 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+func TestJSONStorageRunInTransaction(t *testing.T) {
+	store, err := NewJSONStorage(filepath.Join(t.TempDir(), "data.json"))
+	if err != nil {
+		t.Fatalf("NewJSONStorage failed: %v", err)
+	}
+
+	code := &models.AuthorizationCode{Code: "code-1", ClientID: "client", ExpiresAt: time.Now().Add(time.Minute)}
+	if err := store.CreateAuthorizationCode(code); err != nil {
+		t.Fatalf("CreateAuthorizationCode failed: %v", err)
+	}
+
+	token := &models.Token{ID: "token-1", AccessToken: "access-1", ExpiresAt: time.Now().Add(time.Hour)}
+	errAbort := errors.New("abort")
+	err = store.RunInTransaction(func(tx Storage) error {
+		if err := tx.CreateToken(token); err != nil {
+			return err
+		}
+		if err := tx.DeleteAuthorizationCode(code.Code); err != nil {
+			return err
+		}
+		// Nested transactions join the outer one
+		return tx.RunInTransaction(func(Storage) error { return errAbort })
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("Expected the transaction error, got %v", err)
+	}
+
+	if got, _ := store.GetTokenByAccessToken(token.AccessToken); got != nil {
+		t.Error("Token created in a failed transaction should be rolled back")
+	}
+	if got, _ := store.GetAuthorizationCode(code.Code); got == nil {
+		t.Error("Authorization code deleted in a failed transaction should be restored")
+	}
+
+	// A successful transaction keeps its changes, including on disk
+	if err := store.RunInTransaction(func(tx Storage) error { return tx.CreateToken(token) }); err != nil {
+		t.Fatalf("RunInTransaction failed: %v", err)
+	}
+	reloaded, err := NewJSONStorage(store.filePath)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if got, _ := reloaded.GetTokenByAccessToken(token.AccessToken); got == nil {
+		t.Error("Token from a committed transaction should be persisted")
+	}
+}
+
+func TestJSONStorageMarkAuthorizationCodeUsedOnce(t *testing.T) {
+	store, err := NewJSONStorage(filepath.Join(t.TempDir(), "data.json"))
+	if err != nil {
+		t.Fatalf("NewJSONStorage failed: %v", err)
+	}
+	code := &models.AuthorizationCode{Code: "code-1", ClientID: "client", ExpiresAt: time.Now().Add(time.Minute)}
+	if err := store.CreateAuthorizationCode(code); err != nil {
+		t.Fatalf("CreateAuthorizationCode failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var marked atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Readers change their copy of the code while others mark it
+			if got, err := store.GetAuthorizationCode(code.Code); err == nil && got != nil {
+				got.Scope = "changed"
+			}
+			ok, err := store.MarkAuthorizationCodeUsed(code.Code, time.Now())
+			if err != nil {
+				t.Errorf("MarkAuthorizationCodeUsed failed: %v", err)
+			}
+			if ok {
+				marked.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := marked.Load(); n != 1 {
+		t.Fatalf("code marked used %d times, want 1", n)
+	}
+	got, err := store.GetAuthorizationCode(code.Code)
+	if err != nil || got == nil || !got.Used || got.UsedAt == nil || got.Scope != "" {
+		t.Fatalf("stored code = %+v, %v", got, err)
+	}
+}
+
+func TestJSONStorageUseInitialAccessTokenUpToLimit(t *testing.T) {
+	store, err := NewJSONStorage(filepath.Join(t.TempDir(), "data.json"))
+	if err != nil {
+		t.Fatalf("NewJSONStorage failed: %v", err)
+	}
+	if err := store.CreateInitialAccessToken(&models.InitialAccessToken{Token: "iat", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("CreateInitialAccessToken failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var used atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := store.UseInitialAccessToken("iat", "client", 3, time.Now())
+			if err != nil {
+				t.Errorf("UseInitialAccessToken failed: %v", err)
+			}
+			if ok {
+				used.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := used.Load(); n != 3 {
+		t.Fatalf("token used %d times, want 3", n)
+	}
+	got, err := store.GetInitialAccessToken("iat")
+	if err != nil || got == nil || !got.Used || got.UseCount != 3 || got.UsedBy != "client" {
+		t.Fatalf("stored token = %+v, %v", got, err)
+	}
+}
+
+func TestJSONStorageTouchClientKeepsOtherFields(t *testing.T) {
+	store, err := NewJSONStorage(filepath.Join(t.TempDir(), "data.json"))
+	if err != nil {
+		t.Fatalf("NewJSONStorage failed: %v", err)
+	}
+	if err := store.CreateClient(&models.Client{ID: "client", Secret: "secret", Name: "Before"}); err != nil {
+		t.Fatalf("CreateClient failed: %v", err)
+	}
+
+	// A request authenticated the client before an administrator renamed it
+	validated, err := store.ValidateClient("client", "secret")
+	if err != nil || validated == nil {
+		t.Fatalf("ValidateClient = %+v, %v", validated, err)
+	}
+	validated.Name = "Changed by caller"
+	renamed, _ := store.GetClientByID("client")
+	renamed.Name = "After"
+	if err := store.UpdateClient(renamed); err != nil {
+		t.Fatalf("UpdateClient failed: %v", err)
+	}
+
+	usedAt := time.Now()
+	if err := store.TouchClient("client", usedAt); err != nil {
+		t.Fatalf("TouchClient failed: %v", err)
+	}
+	got, _ := store.GetClientByID("client")
+	if got.Name != "After" || got.LastUsedAt == nil || !got.LastUsedAt.Equal(usedAt) || got.Version != 2 {
+		t.Fatalf("stored client = %+v", got)
+	}
+
+	// An update from a copy read before the client was used keeps the last use
+	renamed.Name = "Later"
+	if err := store.UpdateClient(renamed); err != nil {
+		t.Fatalf("UpdateClient failed: %v", err)
+	}
+	if got, _ = store.GetClientByID("client"); got.LastUsedAt == nil || !got.LastUsedAt.Equal(usedAt) {
+		t.Fatalf("UpdateClient dropped LastUsedAt: %+v", got)
+	}
+	if err := store.TouchClient("missing", usedAt); err == nil {
+		t.Error("TouchClient of an unknown client should fail")
+	}
+}
+
+func TestJSONStorageAuditChainSurvivesReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	store, err := NewJSONStorage(path)
+	if err != nil {
+		t.Fatalf("NewJSONStorage failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		entry := &models.AuditLog{ID: string(rune('a' + i)), Timestamp: time.Now(), Action: models.AuditActionLogin,
+			Details: map[string]interface{}{"attempt": i, "amr": []string{"pwd"}}}
+		if err := store.CreateAuditLog(entry); err != nil {
+			t.Fatalf("CreateAuditLog failed: %v", err)
+		}
+	}
+
+	reloaded, err := NewJSONStorage(path)
+	if err != nil {
+		t.Fatalf("NewJSONStorage failed: %v", err)
+	}
+	entries, err := reloaded.GetAuditChain(0, 0)
+	if err != nil {
+		t.Fatalf("GetAuditChain failed: %v", err)
+	}
+	if len(entries) != 2 || entries[1].PrevHash != entries[0].Hash {
+		t.Fatalf("Expected two linked entries, got %+v", entries)
+	}
+	for _, e := range entries {
+		if e.ComputeHash() != e.Hash {
+			t.Errorf("Entry %d no longer matches its hash after reload", e.Seq)
+		}
+	}
+}
+
+func TestJSONStorageResolveUserByLoginIdentifier(t *testing.T) {
+	store, err := NewJSONStorage(filepath.Join(t.TempDir(), "data.json"))
+	if err != nil {
+		t.Fatalf("NewJSONStorage failed: %v", err)
+	}
+	for _, user := range []*models.User{
+		{ID: "u1", Username: "Alice", Email: "alice@example.com", PhoneNumber: "+15550100"},
+		{ID: "u2", Username: "bob@example.com", Email: "robert@example.com"},
+		{ID: "u3", Username: "carol", Email: "Carol@Example.com"},
+		{ID: "u4", Username: "CAROL", Email: "carol2@example.com"},
+	} {
+		if err := store.CreateUser(user); err != nil {
+			t.Fatalf("CreateUser failed: %v", err)
+		}
+	}
+
+	all := []string{configstore.LoginIdentifierUsername, configstore.LoginIdentifierEmail, configstore.LoginIdentifierPhone}
+	tests := []struct {
+		identifier string
+		fields     []string
+		want       string
+	}{
+		{"alice", all, "u1"},
+		{" ALICE@example.COM ", all, "u1"},
+		{"+15550100", all, "u1"},
+		{"+15550100", all[:2], ""},
+		{"alice@example.com", all[:1], ""},
+		// Field order decides between users
+		{"bob@example.com", all, "u2"},
+		// An exact match wins; otherwise case-only matches are ambiguous
+		{"carol", all, "u3"},
+		{"Carol", all[:1], ""},
+		{"Carol", all, ""},
+		{"carol@example.com", all, "u3"},
+		{"", all, ""},
+	}
+	for _, tt := range tests {
+		user, err := store.ResolveUserByLoginIdentifier(tt.identifier, tt.fields)
+		if err != nil {
+			t.Fatalf("ResolveUserByLoginIdentifier(%q) failed: %v", tt.identifier, err)
+		}
+		got := ""
+		if user != nil {
+			got = user.ID
+		}
+		if got != tt.want {
+			t.Errorf("ResolveUserByLoginIdentifier(%q, %v) = %q, want %q", tt.identifier, tt.fields, got, tt.want)
+		}
+	}
+}
+
+func TestJSONStorageUpdateIfVersion(t *testing.T) {
+	store, err := NewJSONStorage(filepath.Join(t.TempDir(), "data.json"))
+	if err != nil {
+		t.Fatalf("NewJSONStorage failed: %v", err)
+	}
+	if err := store.CreateClient(&models.Client{ID: "client", Name: "Original"}); err != nil {
+		t.Fatalf("CreateClient failed: %v", err)
+	}
+
+	// Two administrators edit the same version; only the first update is stored
+	first, _ := store.GetClientByID("client")
+	second, _ := store.GetClientByID("client")
+	first.Name = "First"
+	second.Name = "Second"
+	if ok, err := store.UpdateClientIfVersion(first, 0); !ok || err != nil {
+		t.Fatalf("UpdateClientIfVersion = %v, %v; want true", ok, err)
+	}
+	if ok, err := store.UpdateClientIfVersion(second, 0); ok || err != nil {
+		t.Fatalf("UpdateClientIfVersion of a stale client = %v, %v; want false", ok, err)
+	}
+	got, _ := store.GetClientByID("client")
+	if got.Name != "First" || got.Version != 1 {
+		t.Fatalf("stored client = %q at version %d, want \"First\" at 1", got.Name, got.Version)
+	}
+
+	// Other writes move the version on too
+	got.Name = "Renamed"
+	if err := store.UpdateClient(got); err != nil {
+		t.Fatalf("UpdateClient failed: %v", err)
+	}
+	if got, _ = store.GetClientByID("client"); got.Version != 2 {
+		t.Fatalf("version after UpdateClient = %d, want 2", got.Version)
+	}
+
+	if err := store.CreateUser(&models.User{ID: "user", Username: "user"}); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	user, _ := store.GetUserByID("user")
+	if ok, err := store.UpdateUserIfVersion(user, 0); !ok || err != nil || user.Version != 1 {
+		t.Fatalf("UpdateUserIfVersion = %v, %v at version %d; want true at 1", ok, err, user.Version)
+	}
+	if ok, err := store.UpdateUserIfVersion(user, 0); ok || err != nil {
+		t.Fatalf("UpdateUserIfVersion of a stale user = %v, %v; want false", ok, err)
+	}
+	if ok, _ := store.UpdateUserIfVersion(&models.User{ID: "missing"}, 0); ok {
+		t.Fatal("UpdateUserIfVersion stored a user that does not exist")
+	}
+}
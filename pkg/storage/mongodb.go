@@ -3,12 +3,17 @@ package storage
 import (
 	"context"
 	"fmt"
+	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
 	"github.com/prasenjit-net/openid-golang/pkg/models"
 )
 
@@ -24,30 +29,55 @@ type MongoDBStorage struct {
 	authSessions        *mongo.Collection
 	userSessions        *mongo.Collection
 	consents            *mongo.Collection
+	consentReceipts     *mongo.Collection
 	initialAccessTokens *mongo.Collection
+	apiKeys             *mongo.Collection
+	clientInstances     *mongo.Collection
+	deviceCodes         *mongo.Collection
+	errorReports        *mongo.Collection
 	signingKeys         *mongo.Collection
 	auditLogs           *mongo.Collection
+	auditCheckpoints    *mongo.Collection
+	usedJTIs            *mongo.Collection
+
+	tuning configstore.StorageTuningConfig
+
+	// replicaClient and replicaDB serve hot-path lookups when a read replica is configured
+	replicaClient *mongo.Client
+	replicaDB     *mongo.Database
+
+	// supportsTransactions is false on standalone servers, which cannot run multi-document transactions
+	supportsTransactions bool
+	// txCtx carries the session of the transaction this copy of the storage runs in
+	txCtx context.Context
 }
 
-// NewMongoDBStorage creates a new MongoDB storage
+// NewMongoDBStorage creates a new MongoDB storage with the driver's default pool and timeouts
 func NewMongoDBStorage(connectionString, database string) (*MongoDBStorage, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	return NewMongoDBStorageWithTuning(connectionString, database, configstore.StorageTuningConfig{})
+}
+
+// NewMongoDBStorageWithTuning creates a new MongoDB storage whose connection pool,
+// timeouts and startup connection retries follow tuning
+func NewMongoDBStorageWithTuning(connectionString, database string, tuning configstore.StorageTuningConfig) (*MongoDBStorage, error) {
+	tuning = mongoTuningDefaults(tuning)
+	log.Printf("MongoDB storage tuning: max_pool_size=%d min_pool_size=%d max_idle=%s connect_timeout=%s operation_timeout=%s connect_attempts=%d retry_backoff=%s",
+		tuning.MaxPoolSize, tuning.MinPoolSize, limitString(tuning.MaxIdleSeconds), seconds(tuning.ConnectTimeoutSeconds),
+		limitString(tuning.OperationTimeoutSeconds), tuning.ConnectAttempts, time.Duration(tuning.RetryBackoffMillis)*time.Millisecond)
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(connectionString))
+	client, err := connectMongo(options.Client().ApplyURI(connectionString), tuning)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+		return nil, err
 	}
 
-	// Ping to verify connection
-	if err := client.Ping(ctx, nil); err != nil {
-		return nil, fmt.Errorf("failed to ping MongoDB: %w", err)
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), seconds(tuning.ConnectTimeoutSeconds))
+	defer cancel()
 
 	db := client.Database(database)
 	storage := &MongoDBStorage{
 		client:              client,
 		db:                  db,
+		tuning:              tuning,
 		users:               db.Collection("users"),
 		clients:             db.Collection("clients"),
 		codes:               db.Collection("authorization_codes"),
@@ -56,9 +86,16 @@ func NewMongoDBStorage(connectionString, database string) (*MongoDBStorage, erro
 		authSessions:        db.Collection("auth_sessions"),
 		userSessions:        db.Collection("user_sessions"),
 		consents:            db.Collection("consents"),
+		consentReceipts:     db.Collection("consent_receipts"),
 		initialAccessTokens: db.Collection("initial_access_tokens"),
+		apiKeys:             db.Collection("api_keys"),
+		clientInstances:     db.Collection("client_instances"),
+		deviceCodes:         db.Collection("device_authorizations"),
+		errorReports:        db.Collection("error_reports"),
 		signingKeys:         db.Collection("signing_keys"),
 		auditLogs:           db.Collection("audit_logs"),
+		auditCheckpoints:    db.Collection("audit_checkpoints"),
+		usedJTIs:            db.Collection("used_jtis"),
 	}
 
 	// Create indexes
@@ -66,22 +103,188 @@ func NewMongoDBStorage(connectionString, database string) (*MongoDBStorage, erro
 		return nil, fmt.Errorf("failed to create indexes: %w", err)
 	}
 
+	// Transactions need a replica set member or a mongos router
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := db.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err == nil {
+		storage.supportsTransactions = hello.SetName != "" || hello.Msg == "isdbgrid"
+	}
+	if !storage.supportsTransactions {
+		log.Printf("MongoDB server does not support transactions; multi-entity operations will not be atomic")
+	}
+
 	return storage, nil
 }
 
+// UseReadReplica routes client, user and signing key lookups to the members
+// reached through uri with a secondaryPreferred read preference. Replicas that
+// lag the primary by more than maxStaleness are skipped when it is non-zero;
+// MongoDB requires it to be at least 90 seconds. Lookups that find nothing on
+// a replica are retried on the primary, so newly created records resolve
+// before replication catches up.
+func (m *MongoDBStorage) UseReadReplica(uri string, maxStaleness time.Duration) error {
+	var prefOpts []readpref.Option
+	if maxStaleness > 0 {
+		if maxStaleness < 90*time.Second {
+			return fmt.Errorf("read replica max staleness must be at least 90 seconds, got %s", maxStaleness)
+		}
+		prefOpts = append(prefOpts, readpref.WithMaxStaleness(maxStaleness))
+	}
+
+	client, err := connectMongo(options.Client().ApplyURI(uri).SetReadPreference(readpref.SecondaryPreferred(prefOpts...)), m.tuning)
+	if err != nil {
+		return fmt.Errorf("read replica: %w", err)
+	}
+
+	m.replicaClient = client
+	m.replicaDB = client.Database(m.db.Name())
+	return nil
+}
+
+// readCollection returns the replica copy of coll for lookups that tolerate
+// replication lag, or coll itself without a replica or inside a transaction
+func (m *MongoDBStorage) readCollection(coll *mongo.Collection) *mongo.Collection {
+	if m.replicaDB == nil || m.txCtx != nil {
+		return coll
+	}
+	return m.replicaDB.Collection(coll.Name())
+}
+
+// findOneFromReplica decodes the document matching filter into v, reading from
+// the replica first and falling back to the primary when it has no match
+func (m *MongoDBStorage) findOneFromReplica(coll *mongo.Collection, filter interface{}, v interface{}) error {
+	ctx := m.baseContext()
+	replica := m.readCollection(coll)
+	err := replica.FindOne(ctx, filter).Decode(v)
+	if err == mongo.ErrNoDocuments && replica != coll {
+		err = coll.FindOne(ctx, filter).Decode(v)
+	}
+	return err
+}
+
+// mongoTuningDefaults fills in the values the MongoDB backend uses for unset settings
+func mongoTuningDefaults(t configstore.StorageTuningConfig) configstore.StorageTuningConfig {
+	if t.MaxPoolSize <= 0 {
+		t.MaxPoolSize = 100
+	}
+	if t.MinPoolSize < 0 {
+		t.MinPoolSize = 0
+	}
+	if t.MinPoolSize > t.MaxPoolSize {
+		t.MinPoolSize = t.MaxPoolSize
+	}
+	if t.ConnectTimeoutSeconds <= 0 {
+		t.ConnectTimeoutSeconds = 10
+	}
+	if t.ConnectAttempts <= 0 {
+		t.ConnectAttempts = 1
+	}
+	if t.RetryBackoffMillis <= 0 {
+		t.RetryBackoffMillis = 500
+	}
+	return t
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// limitString formats an optional limit in seconds for logging
+func limitString(n int) string {
+	if n <= 0 {
+		return "none"
+	}
+	return seconds(n).String()
+}
+
+// connectMongo applies tuning to opts and connects, retrying failed pings with
+// exponential backoff up to tuning.ConnectAttempts times
+func connectMongo(opts *options.ClientOptions, tuning configstore.StorageTuningConfig) (*mongo.Client, error) {
+	opts.SetMaxPoolSize(uint64(tuning.MaxPoolSize)).
+		SetMinPoolSize(uint64(tuning.MinPoolSize)).
+		SetConnectTimeout(seconds(tuning.ConnectTimeoutSeconds))
+	if tuning.MaxIdleSeconds > 0 {
+		opts.SetMaxConnIdleTime(seconds(tuning.MaxIdleSeconds))
+	}
+	if tuning.OperationTimeoutSeconds > 0 {
+		opts.SetTimeout(seconds(tuning.OperationTimeoutSeconds))
+	}
+
+	client, err := mongo.Connect(context.Background(), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+
+	backoff := time.Duration(tuning.RetryBackoffMillis) * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), seconds(tuning.ConnectTimeoutSeconds))
+		err = client.Ping(ctx, nil)
+		cancel()
+		if err == nil {
+			return client, nil
+		}
+		if attempt >= tuning.ConnectAttempts {
+			break
+		}
+		log.Printf("MongoDB not reachable (attempt %d of %d), retrying in %s: %v", attempt, tuning.ConnectAttempts, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	_ = client.Disconnect(context.Background())
+	return nil, fmt.Errorf("failed to ping MongoDB: %w", err)
+}
+
+// baseContext returns the context operations derive their timeouts from
+func (m *MongoDBStorage) baseContext() context.Context {
+	if m.txCtx != nil {
+		return m.txCtx
+	}
+	return context.Background()
+}
+
+// RunInTransaction runs fn in a multi-document transaction. The transaction is
+// retried on transient errors, so fn must not have side effects outside tx.
+// Nested calls join the outer transaction.
+func (m *MongoDBStorage) RunInTransaction(fn func(tx Storage) error) error {
+	if m.txCtx != nil || !m.supportsTransactions {
+		return fn(m)
+	}
+
+	session, err := m.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %w", err)
+	}
+	defer session.EndSession(context.Background())
+
+	_, err = session.WithTransaction(context.Background(), func(sc mongo.SessionContext) (interface{}, error) {
+		tx := *m
+		tx.txCtx = sc
+		return nil, fn(&tx)
+	})
+	return err
+}
+
 func (m *MongoDBStorage) createIndexes() error {
-	ctx := context.Background()
+	ctx := m.baseContext()
 
 	// Users indexes
 	_, _ = m.users.Indexes().CreateMany(ctx, []mongo.IndexModel{
 		{Keys: bson.D{{Key: "username", Value: 1}}, Options: options.Index().SetUnique(true)},
 		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		// Case-insensitive lookups of login identifiers
+		{Keys: bson.D{{Key: "username", Value: 1}}, Options: options.Index().SetName("username_ci").SetCollation(caseInsensitive)},
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetName("email_ci").SetCollation(caseInsensitive)},
+		{Keys: bson.D{{Key: "phonenumber", Value: 1}}, Options: options.Index().SetName("phonenumber_ci").SetCollation(caseInsensitive).SetSparse(true)},
 	})
 
 	// Tokens indexes
 	_, _ = m.tokens.Indexes().CreateMany(ctx, []mongo.IndexModel{
 		{Keys: bson.D{{Key: "access_token", Value: 1}}, Options: options.Index().SetUnique(true)},
 		{Keys: bson.D{{Key: "refresh_token", Value: 1}}},
+		{Keys: bson.D{{Key: "session_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}},
 	})
 
 	// Codes index
@@ -100,12 +303,15 @@ func (m *MongoDBStorage) createIndexes() error {
 	_, _ = m.authSessions.Indexes().CreateMany(ctx, []mongo.IndexModel{
 		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
 		{Keys: bson.D{{Key: "client_id", Value: 1}}},
+		{Keys: bson.D{{Key: "owner", Value: 1}}},
+		{Keys: bson.D{{Key: "created_at", Value: 1}}},
 	})
 
 	// UserSessions indexes
 	_, _ = m.userSessions.Indexes().CreateMany(ctx, []mongo.IndexModel{
 		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
 		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "last_activity_at", Value: 1}}},
 	})
 
 	// Consents indexes
@@ -114,33 +320,82 @@ func (m *MongoDBStorage) createIndexes() error {
 		Options: options.Index().SetUnique(true),
 	})
 
+	// ConsentReceipts indexes
+	_, _ = m.consentReceipts.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "client_id", Value: 1}}},
+	})
+
+	// APIKeys indexes — looked up by hash at the token endpoint
+	_, _ = m.apiKeys.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	})
+
+	_, _ = m.clientInstances.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "client_id", Value: 1}},
+	})
+
+	// Device authorizations are looked up by user code and expire on their own
+	_, _ = m.deviceCodes.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_code", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+
+	_, _ = m.errorReports.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0),
+	})
+
 	// AuditLogs indexes — timestamp for range queries, action/actor for filters
 	_, _ = m.auditLogs.Indexes().CreateMany(ctx, []mongo.IndexModel{
 		{Keys: bson.D{{Key: "timestamp", Value: -1}}},
 		{Keys: bson.D{{Key: "action", Value: 1}}},
 		{Keys: bson.D{{Key: "actor", Value: 1}}},
+		{Keys: bson.D{{Key: "seq", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+	})
+
+	_, _ = m.auditCheckpoints.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "seq", Value: 1}},
+	})
+
+	// Used JTIs expire with the assertion they came from
+	_, _ = m.usedJTIs.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
 	})
 
 	return nil
 }
 
+// Ping checks that the primary can be reached
+func (m *MongoDBStorage) Ping(ctx context.Context) error {
+	return m.client.Ping(ctx, readpref.Primary())
+}
+
 func (m *MongoDBStorage) Close() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
+	if m.replicaClient != nil {
+		_ = m.replicaClient.Disconnect(ctx) // Best effort, the primary error matters more
+	}
 	return m.client.Disconnect(ctx)
 }
 
 // User operations
 func (m *MongoDBStorage) CreateUser(user *models.User) error {
-	ctx := context.Background()
-	user.CreatedAt = time.Now()
-	user.UpdatedAt = time.Now()
+	ctx := m.baseContext()
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	if user.UpdatedAt.IsZero() {
+		user.UpdatedAt = user.CreatedAt
+	}
 	_, err := m.users.InsertOne(ctx, user)
 	return err
 }
 
 func (m *MongoDBStorage) GetUserByUsername(username string) (*models.User, error) {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	var user models.User
 	err := m.users.FindOne(ctx, bson.M{"username": username}).Decode(&user)
 	if err == mongo.ErrNoDocuments {
@@ -150,9 +405,8 @@ func (m *MongoDBStorage) GetUserByUsername(username string) (*models.User, error
 }
 
 func (m *MongoDBStorage) GetUserByID(id string) (*models.User, error) {
-	ctx := context.Background()
 	var user models.User
-	err := m.users.FindOne(ctx, bson.M{"id": id}).Decode(&user)
+	err := m.findOneFromReplica(m.users, bson.M{"id": id}, &user)
 	if err == mongo.ErrNoDocuments {
 		return nil, nil
 	}
@@ -160,17 +414,71 @@ func (m *MongoDBStorage) GetUserByID(id string) (*models.User, error) {
 }
 
 func (m *MongoDBStorage) GetUserByEmail(email string) (*models.User, error) {
-	ctx := context.Background()
+	ctx := m.baseContext()
+	email = strings.TrimSpace(email)
 	var user models.User
 	err := m.users.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		// Addresses stored before normalization may differ in case
+		err = m.users.FindOne(ctx, bson.M{"email": email}, options.FindOne().SetCollation(caseInsensitive)).Decode(&user)
+	}
 	if err == mongo.ErrNoDocuments {
 		return nil, nil
 	}
 	return &user, err
 }
 
+// caseInsensitive compares strings ignoring case, as login identifiers are matched
+var caseInsensitive = &options.Collation{Locale: "en", Strength: 2}
+
+// loginIdentifierKeys maps login identifier fields to user document keys
+var loginIdentifierKeys = map[string]string{
+	configstore.LoginIdentifierUsername: "username",
+	configstore.LoginIdentifierEmail:    "email",
+	configstore.LoginIdentifierPhone:    "phonenumber",
+}
+
+func (m *MongoDBStorage) ResolveUserByLoginIdentifier(identifier string, fields []string) (*models.User, error) {
+	identifier = strings.TrimSpace(identifier)
+	if identifier == "" {
+		return nil, nil
+	}
+	ctx := m.baseContext()
+	for _, field := range fields {
+		key, ok := loginIdentifierKeys[field]
+		if !ok {
+			continue
+		}
+		// Two matches are enough to tell an exact or unique match from an ambiguous one
+		cursor, err := m.users.Find(ctx, bson.M{key: identifier},
+			options.Find().SetCollation(caseInsensitive).SetLimit(2))
+		if err != nil {
+			return nil, err
+		}
+		var users []*models.User
+		err = cursor.All(ctx, &users)
+		if err != nil {
+			return nil, err
+		}
+		if len(users) == 1 {
+			return users[0], nil
+		}
+		if len(users) > 1 {
+			var user models.User
+			err := m.users.FindOne(ctx, bson.M{key: identifier}).Decode(&user)
+			if err == nil {
+				return &user, nil
+			}
+			if err != mongo.ErrNoDocuments {
+				return nil, err
+			}
+		}
+	}
+	return nil, nil
+}
+
 func (m *MongoDBStorage) GetAllUsers() ([]*models.User, error) {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	cursor, err := m.users.Find(ctx, bson.M{})
 	if err != nil {
 		return nil, err
@@ -187,34 +495,63 @@ func (m *MongoDBStorage) GetAllUsers() ([]*models.User, error) {
 }
 
 func (m *MongoDBStorage) UpdateUser(user *models.User) error {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	user.UpdatedAt = time.Now()
-	_, err := m.users.UpdateOne(
+	fields, err := withoutFields(user, "version")
+	if err != nil {
+		return err
+	}
+	_, err = m.users.UpdateOne(
 		ctx,
 		bson.M{"id": user.ID},
-		bson.M{"$set": user},
+		bson.M{"$set": fields, "$inc": bson.M{"version": 1}},
 	)
 	return err
 }
 
+func (m *MongoDBStorage) UpdateUserIfVersion(user *models.User, version int) (bool, error) {
+	ctx := m.baseContext()
+	user.UpdatedAt = time.Now()
+	user.Version = version + 1
+	// Matching the expected version makes the update a compare-and-set across replicas;
+	// documents written before versioning have no version field and count as 0
+	result, err := m.users.UpdateOne(ctx, versionFilter("id", user.ID, version), bson.M{"$set": user})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func (m *MongoDBStorage) UpdatePasswordHash(id, oldHash, newHash string) (bool, error) {
+	ctx := m.baseContext()
+	result, err := m.users.UpdateOne(ctx,
+		bson.M{"id": id, "passwordhash": oldHash},
+		bson.M{"$set": bson.M{"passwordhash": newHash, "updatedat": time.Now()}, "$inc": bson.M{"version": 1}})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
 func (m *MongoDBStorage) DeleteUser(id string) error {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	_, err := m.users.DeleteOne(ctx, bson.M{"id": id})
 	return err
 }
 
 // Client operations
 func (m *MongoDBStorage) CreateClient(client *models.Client) error {
-	ctx := context.Background()
-	client.CreatedAt = time.Now()
+	ctx := m.baseContext()
+	if client.CreatedAt.IsZero() {
+		client.CreatedAt = time.Now()
+	}
 	_, err := m.clients.InsertOne(ctx, client)
 	return err
 }
 
 func (m *MongoDBStorage) GetClientByID(id string) (*models.Client, error) {
-	ctx := context.Background()
 	var client models.Client
-	err := m.clients.FindOne(ctx, bson.M{"id": id}).Decode(&client)
+	err := m.findOneFromReplica(m.clients, bson.M{"id": id}, &client)
 	if err == mongo.ErrNoDocuments {
 		return nil, nil
 	}
@@ -222,7 +559,7 @@ func (m *MongoDBStorage) GetClientByID(id string) (*models.Client, error) {
 }
 
 func (m *MongoDBStorage) GetAllClients() ([]*models.Client, error) {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	cursor, err := m.clients.Find(ctx, bson.M{})
 	if err != nil {
 		return nil, err
@@ -239,23 +576,66 @@ func (m *MongoDBStorage) GetAllClients() ([]*models.Client, error) {
 }
 
 func (m *MongoDBStorage) UpdateClient(client *models.Client) error {
-	ctx := context.Background()
-	_, err := m.clients.UpdateOne(
+	ctx := m.baseContext()
+	fields, err := withoutFields(client, "version", "last_used_at")
+	if err != nil {
+		return err
+	}
+	_, err = m.clients.UpdateOne(
 		ctx,
 		bson.M{"id": client.ID},
-		bson.M{"$set": client},
+		bson.M{"$set": fields, "$inc": bson.M{"version": 1}},
 	)
 	return err
 }
 
+func (m *MongoDBStorage) UpdateClientIfVersion(client *models.Client, version int) (bool, error) {
+	ctx := m.baseContext()
+	client.Version = version + 1
+	fields, err := withoutFields(client, "last_used_at")
+	if err != nil {
+		return false, err
+	}
+	result, err := m.clients.UpdateOne(ctx, versionFilter("id", client.ID, version), bson.M{"$set": fields})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// versionFilter matches the document with the given key at version
+func versionFilter(key, id string, version int) bson.M {
+	if version == 0 {
+		return bson.M{key: id, "version": bson.M{"$in": bson.A{nil, 0}}}
+	}
+	return bson.M{key: id, "version": version}
+}
+
+// withoutFields encodes a document for $set without the given fields, such as
+// the version that the update increments instead of overwriting
+func withoutFields(doc interface{}, keys ...string) (bson.M, error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var fields bson.M
+	if err := bson.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		delete(fields, key)
+	}
+	return fields, nil
+}
+
 func (m *MongoDBStorage) DeleteClient(id string) error {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	_, err := m.clients.DeleteOne(ctx, bson.M{"id": id})
 	return err
 }
 
 func (m *MongoDBStorage) ValidateClient(clientID, clientSecret string) (*models.Client, error) {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	var client models.Client
 	err := m.clients.FindOne(ctx, bson.M{
 		"id":     clientID,
@@ -267,16 +647,22 @@ func (m *MongoDBStorage) ValidateClient(clientID, clientSecret string) (*models.
 	return &client, err
 }
 
+func (m *MongoDBStorage) TouchClient(id string, usedAt time.Time) error {
+	ctx := m.baseContext()
+	_, err := m.clients.UpdateOne(ctx, bson.M{"id": id}, bson.M{"$set": bson.M{"last_used_at": usedAt}, "$inc": bson.M{"version": 1}})
+	return err
+}
+
 // Authorization code operations
 func (m *MongoDBStorage) CreateAuthorizationCode(code *models.AuthorizationCode) error {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	code.CreatedAt = time.Now()
 	_, err := m.codes.InsertOne(ctx, code)
 	return err
 }
 
 func (m *MongoDBStorage) GetAuthorizationCode(code string) (*models.AuthorizationCode, error) {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	var authCode models.AuthorizationCode
 	err := m.codes.FindOne(ctx, bson.M{"code": code}).Decode(&authCode)
 	if err == mongo.ErrNoDocuments {
@@ -286,33 +672,46 @@ func (m *MongoDBStorage) GetAuthorizationCode(code string) (*models.Authorizatio
 }
 
 func (m *MongoDBStorage) UpdateAuthorizationCode(code *models.AuthorizationCode) error {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	update := bson.M{
 		"$set": bson.M{
-			"used":    code.Used,
-			"used_at": code.UsedAt,
+			"used":        code.Used,
+			"used_at":     code.UsedAt,
+			"reuse_count": code.ReuseCount,
 		},
 	}
 	_, err := m.codes.UpdateOne(ctx, bson.M{"code": code.Code}, update)
 	return err
 }
 
+func (m *MongoDBStorage) MarkAuthorizationCodeUsed(code string, usedAt time.Time) (bool, error) {
+	ctx := m.baseContext()
+	// Matching only unused codes makes the update a compare-and-set across replicas
+	result, err := m.codes.UpdateOne(ctx,
+		bson.M{"code": code, "used": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"used": true, "used_at": usedAt}})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
 func (m *MongoDBStorage) DeleteAuthorizationCode(code string) error {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	_, err := m.codes.DeleteOne(ctx, bson.M{"code": code})
 	return err
 }
 
 // Token operations
 func (m *MongoDBStorage) CreateToken(token *models.Token) error {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	token.CreatedAt = time.Now()
 	_, err := m.tokens.InsertOne(ctx, token)
 	return err
 }
 
 func (m *MongoDBStorage) GetTokenByAccessToken(accessToken string) (*models.Token, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	var token models.Token
@@ -324,7 +723,7 @@ func (m *MongoDBStorage) GetTokenByAccessToken(accessToken string) (*models.Toke
 }
 
 func (m *MongoDBStorage) GetTokenByRefreshToken(refreshToken string) (*models.Token, error) {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	var token models.Token
 	err := m.tokens.FindOne(ctx, bson.M{"refresh_token": refreshToken}).Decode(&token)
 	if err == mongo.ErrNoDocuments {
@@ -334,13 +733,30 @@ func (m *MongoDBStorage) GetTokenByRefreshToken(refreshToken string) (*models.To
 }
 
 func (m *MongoDBStorage) DeleteToken(tokenID string) error {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	_, err := m.tokens.DeleteOne(ctx, bson.M{"id": tokenID})
 	return err
 }
 
+func (m *MongoDBStorage) MarkTokenReplaced(id, replacementID string, replacedAt, expiresAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	// Matching only unreplaced tokens makes the update a compare-and-set across replicas
+	result, err := m.tokens.UpdateOne(ctx,
+		bson.M{"id": id, "replaced_by": bson.M{"$in": bson.A{nil, ""}}},
+		bson.M{
+			"$set": bson.M{"replaced_by": replacementID, "replaced_at": replacedAt},
+			"$min": bson.M{"expires_at": expiresAt},
+		})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
 func (m *MongoDBStorage) GetTokensByAuthCode(authCodeID string) ([]*models.Token, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	cursor, err := m.tokens.Find(ctx, bson.M{"authorization_code_id": authCodeID})
@@ -359,7 +775,7 @@ func (m *MongoDBStorage) GetTokensByAuthCode(authCodeID string) ([]*models.Token
 }
 
 func (m *MongoDBStorage) RevokeTokensByAuthCode(authCodeID string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	// Delete all tokens associated with this authorization code
@@ -367,9 +783,18 @@ func (m *MongoDBStorage) RevokeTokensByAuthCode(authCodeID string) error {
 	return err
 }
 
+// RevokeTokensBySession deletes all tokens bound to a user session
+func (m *MongoDBStorage) RevokeTokensBySession(sessionID string) error {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.tokens.DeleteMany(ctx, bson.M{"session_id": sessionID})
+	return err
+}
+
 // ListTokens returns tokens optionally filtered by clientID, userID, and active status.
 func (m *MongoDBStorage) ListTokens(clientID, userID string, activeOnly bool) ([]*models.Token, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 10*time.Second)
 	defer cancel()
 
 	filter := bson.M{}
@@ -398,14 +823,14 @@ func (m *MongoDBStorage) ListTokens(clientID, userID string, activeOnly bool) ([
 
 // Session operations
 func (m *MongoDBStorage) CreateSession(session *models.Session) error {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	session.CreatedAt = time.Now()
 	_, err := m.sessions.InsertOne(ctx, session)
 	return err
 }
 
 func (m *MongoDBStorage) GetSession(sessionID string) (*models.Session, error) {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	var session models.Session
 	err := m.sessions.FindOne(ctx, bson.M{"id": sessionID}).Decode(&session)
 	if err == mongo.ErrNoDocuments {
@@ -415,14 +840,14 @@ func (m *MongoDBStorage) GetSession(sessionID string) (*models.Session, error) {
 }
 
 func (m *MongoDBStorage) DeleteSession(sessionID string) error {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	_, err := m.sessions.DeleteOne(ctx, bson.M{"id": sessionID})
 	return err
 }
 
 // AuthSession operations
 func (m *MongoDBStorage) CreateAuthSession(session *models.AuthSession) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	if session.CreatedAt.IsZero() {
@@ -433,7 +858,7 @@ func (m *MongoDBStorage) CreateAuthSession(session *models.AuthSession) error {
 }
 
 func (m *MongoDBStorage) GetAuthSession(sessionID string) (*models.AuthSession, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	var session models.AuthSession
@@ -454,7 +879,7 @@ func (m *MongoDBStorage) GetAuthSession(sessionID string) (*models.AuthSession,
 }
 
 func (m *MongoDBStorage) UpdateAuthSession(session *models.AuthSession) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	_, err := m.authSessions.ReplaceOne(
@@ -467,16 +892,52 @@ func (m *MongoDBStorage) UpdateAuthSession(session *models.AuthSession) error {
 }
 
 func (m *MongoDBStorage) DeleteAuthSession(sessionID string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	_, err := m.authSessions.DeleteOne(ctx, bson.M{"_id": sessionID})
 	return err
 }
 
+// GetAuthSessionsByOwner returns all non-expired auth sessions started by owner.
+func (m *MongoDBStorage) GetAuthSessionsByOwner(owner string) ([]*models.AuthSession, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"owner":      owner,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+	cursor, err := m.authSessions.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = cursor.Close(ctx) // Best effort close
+	}()
+
+	var sessions []*models.AuthSession
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// CleanupExpiredAuthSessions removes expired auth sessions and returns how many were deleted.
+func (m *MongoDBStorage) CleanupExpiredAuthSessions() (int, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 10*time.Second)
+	defer cancel()
+
+	result, err := m.authSessions.DeleteMany(ctx, bson.M{"expires_at": bson.M{"$lt": time.Now()}})
+	if err != nil {
+		return 0, err
+	}
+	return int(result.DeletedCount), nil
+}
+
 // UserSession operations
 func (m *MongoDBStorage) CreateUserSession(session *models.UserSession) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	if session.CreatedAt.IsZero() {
@@ -492,7 +953,7 @@ func (m *MongoDBStorage) CreateUserSession(session *models.UserSession) error {
 }
 
 func (m *MongoDBStorage) GetUserSession(sessionID string) (*models.UserSession, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	var session models.UserSession
@@ -513,7 +974,7 @@ func (m *MongoDBStorage) GetUserSession(sessionID string) (*models.UserSession,
 }
 
 func (m *MongoDBStorage) GetUserSessionByUserID(userID string) (*models.UserSession, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	// Find the most recent non-expired session for the user
@@ -535,8 +996,26 @@ func (m *MongoDBStorage) GetUserSessionByUserID(userID string) (*models.UserSess
 	return &session, nil
 }
 
+// GetUserSessionsByUserID returns all stored sessions of a user, expired or not
+func (m *MongoDBStorage) GetUserSessionsByUserID(userID string) ([]*models.UserSession, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := m.userSessions.Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = cursor.Close(ctx) }()
+
+	var sessions []*models.UserSession
+	if err = cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
 func (m *MongoDBStorage) UpdateUserSession(session *models.UserSession) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	session.LastActivityAt = time.Now()
@@ -550,7 +1029,7 @@ func (m *MongoDBStorage) UpdateUserSession(session *models.UserSession) error {
 }
 
 func (m *MongoDBStorage) DeleteUserSession(sessionID string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	_, err := m.userSessions.DeleteOne(ctx, bson.M{"_id": sessionID})
@@ -558,7 +1037,7 @@ func (m *MongoDBStorage) DeleteUserSession(sessionID string) error {
 }
 
 func (m *MongoDBStorage) CleanupExpiredSessions() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 10*time.Second)
 	defer cancel()
 
 	now := time.Now()
@@ -574,7 +1053,7 @@ func (m *MongoDBStorage) CleanupExpiredSessions() error {
 
 // Consent operations
 func (m *MongoDBStorage) CreateConsent(consent *models.Consent) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	if consent.CreatedAt.IsZero() {
@@ -587,7 +1066,7 @@ func (m *MongoDBStorage) CreateConsent(consent *models.Consent) error {
 }
 
 func (m *MongoDBStorage) GetConsent(userID, clientID string) (*models.Consent, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	var consent models.Consent
@@ -603,7 +1082,7 @@ func (m *MongoDBStorage) GetConsent(userID, clientID string) (*models.Consent, e
 }
 
 func (m *MongoDBStorage) UpdateConsent(consent *models.Consent) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	consent.UpdatedAt = time.Now()
@@ -617,7 +1096,7 @@ func (m *MongoDBStorage) UpdateConsent(consent *models.Consent) error {
 }
 
 func (m *MongoDBStorage) DeleteConsent(userID, clientID string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	_, err := m.consents.DeleteOne(ctx, bson.M{"user_id": userID, "client_id": clientID})
@@ -625,19 +1104,82 @@ func (m *MongoDBStorage) DeleteConsent(userID, clientID string) error {
 }
 
 func (m *MongoDBStorage) DeleteConsentsForUser(userID string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	_, err := m.consents.DeleteMany(ctx, bson.M{"user_id": userID})
 	return err
 }
 
+func (m *MongoDBStorage) GetConsentsByUserID(userID string) ([]*models.Consent, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := m.consents.Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = cursor.Close(ctx) }()
+
+	var consents []*models.Consent
+	if err = cursor.All(ctx, &consents); err != nil {
+		return nil, err
+	}
+	return consents, nil
+}
+
+// ConsentReceipt operations
+func (m *MongoDBStorage) CreateConsentReceipt(receipt *models.ConsentReceipt) error {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	if receipt.CreatedAt.IsZero() {
+		receipt.CreatedAt = time.Now()
+	}
+	_, err := m.consentReceipts.InsertOne(ctx, receipt)
+	return err
+}
+
+// ListConsentReceipts returns receipts optionally filtered by userID and clientID, oldest first
+func (m *MongoDBStorage) ListConsentReceipts(userID, clientID string) ([]*models.ConsentReceipt, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if userID != "" {
+		filter["user_id"] = userID
+	}
+	if clientID != "" {
+		filter["client_id"] = clientID
+	}
+
+	cursor, err := m.consentReceipts.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = cursor.Close(ctx) }()
+
+	var receipts []*models.ConsentReceipt
+	if err = cursor.All(ctx, &receipts); err != nil {
+		return nil, err
+	}
+	return receipts, nil
+}
+
+func (m *MongoDBStorage) DeleteConsentReceiptsForUser(userID string) error {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.consentReceipts.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
 // ============================================================================
 // Initial Access Token Operations
 // ============================================================================
 
 func (m *MongoDBStorage) CreateInitialAccessToken(token *models.InitialAccessToken) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	_, err := m.initialAccessTokens.InsertOne(ctx, token)
@@ -645,7 +1187,7 @@ func (m *MongoDBStorage) CreateInitialAccessToken(token *models.InitialAccessTok
 }
 
 func (m *MongoDBStorage) GetInitialAccessToken(token string) (*models.InitialAccessToken, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	var t models.InitialAccessToken
@@ -660,15 +1202,39 @@ func (m *MongoDBStorage) GetInitialAccessToken(token string) (*models.InitialAcc
 }
 
 func (m *MongoDBStorage) UpdateInitialAccessToken(token *models.InitialAccessToken) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	_, err := m.initialAccessTokens.ReplaceOne(ctx, bson.M{"_id": token.Token}, token)
 	return err
 }
 
+func (m *MongoDBStorage) UseInitialAccessToken(token, clientID string, maxUses int, usedAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	// Matching only tokens with uses left makes the increment a compare-and-set across
+	// replicas; the pipeline marks the token used in the same write as its last use.
+	useCount := bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$use_count", 0}}, 1}}
+	result, err := m.initialAccessTokens.UpdateOne(ctx,
+		bson.M{"_id": token, "used": bson.M{"$ne": true}, "$or": bson.A{
+			bson.M{"use_count": bson.M{"$lt": maxUses}},
+			bson.M{"use_count": bson.M{"$exists": false}},
+		}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"use_count": useCount,
+			"used_at":   usedAt,
+			"used_by":   clientID,
+			"used":      bson.M{"$gte": bson.A{useCount, maxUses}},
+		}}}})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
 func (m *MongoDBStorage) DeleteInitialAccessToken(token string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	_, err := m.initialAccessTokens.DeleteOne(ctx, bson.M{"_id": token})
@@ -676,7 +1242,7 @@ func (m *MongoDBStorage) DeleteInitialAccessToken(token string) error {
 }
 
 func (m *MongoDBStorage) GetAllInitialAccessTokens() ([]*models.InitialAccessToken, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
 	defer cancel()
 
 	cursor, err := m.initialAccessTokens.Find(ctx, bson.M{})
@@ -694,16 +1260,238 @@ func (m *MongoDBStorage) GetAllInitialAccessTokens() ([]*models.InitialAccessTok
 	return tokens, nil
 }
 
+// ============================================================================
+// API Key Operations
+// ============================================================================
+
+func (m *MongoDBStorage) CreateAPIKey(key *models.APIKey) error {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	_, err := m.apiKeys.InsertOne(ctx, key)
+	return err
+}
+
+func (m *MongoDBStorage) GetAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	var key models.APIKey
+	err := m.apiKeys.FindOne(ctx, bson.M{"key_hash": keyHash}).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// ListAPIKeys returns the keys of a service account, oldest first
+func (m *MongoDBStorage) ListAPIKeys(userID string) ([]*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := m.apiKeys.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = cursor.Close(ctx) }()
+
+	var keys []*models.APIKey
+	if err = cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (m *MongoDBStorage) UpdateAPIKey(key *models.APIKey) error {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.apiKeys.ReplaceOne(ctx, bson.M{"_id": key.ID}, key)
+	return err
+}
+
+func (m *MongoDBStorage) DeleteAPIKey(id string) error {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.apiKeys.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (m *MongoDBStorage) DeleteAPIKeysForUser(userID string) error {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.apiKeys.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+// ============================================================================
+// Client Instance Operations
+// ============================================================================
+
+func (m *MongoDBStorage) CreateClientInstance(instance *models.ClientInstance) error {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	if instance.CreatedAt.IsZero() {
+		instance.CreatedAt = time.Now()
+	}
+	_, err := m.clientInstances.InsertOne(ctx, instance)
+	return err
+}
+
+func (m *MongoDBStorage) GetClientInstance(id string) (*models.ClientInstance, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	var instance models.ClientInstance
+	err := m.clientInstances.FindOne(ctx, bson.M{"_id": id}).Decode(&instance)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &instance, nil
+}
+
+// ListClientInstances returns the instances of a client, oldest first
+func (m *MongoDBStorage) ListClientInstances(clientID string) ([]*models.ClientInstance, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := m.clientInstances.Find(ctx, bson.M{"client_id": clientID}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = cursor.Close(ctx)
+	}()
+
+	var instances []*models.ClientInstance
+	if err = cursor.All(ctx, &instances); err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (m *MongoDBStorage) UpdateClientInstance(instance *models.ClientInstance) error {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.clientInstances.ReplaceOne(ctx, bson.M{"_id": instance.ID}, instance)
+	return err
+}
+
+func (m *MongoDBStorage) DeleteClientInstance(id string) error {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.clientInstances.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// ============================================================================
+// Device Authorization Operations
+// ============================================================================
+
+func (m *MongoDBStorage) CreateDeviceAuthorization(auth *models.DeviceAuthorization) error {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	if auth.CreatedAt.IsZero() {
+		auth.CreatedAt = time.Now()
+	}
+	// An expired request the TTL monitor has not removed yet still holds its user code
+	_, _ = m.deviceCodes.DeleteOne(ctx, bson.M{"user_code": auth.UserCode, "expires_at": bson.M{"$lte": time.Now()}})
+	_, err := m.deviceCodes.InsertOne(ctx, auth)
+	return err
+}
+
+func (m *MongoDBStorage) GetDeviceAuthorization(deviceCode string) (*models.DeviceAuthorization, error) {
+	return m.findDeviceAuthorization(bson.M{"_id": deviceCode})
+}
+
+func (m *MongoDBStorage) GetDeviceAuthorizationByUserCode(userCode string) (*models.DeviceAuthorization, error) {
+	return m.findDeviceAuthorization(bson.M{"user_code": userCode})
+}
+
+func (m *MongoDBStorage) findDeviceAuthorization(filter bson.M) (*models.DeviceAuthorization, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	var auth models.DeviceAuthorization
+	err := m.deviceCodes.FindOne(ctx, filter).Decode(&auth)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &auth, nil
+}
+
+func (m *MongoDBStorage) UpdateDeviceAuthorization(auth *models.DeviceAuthorization) error {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.deviceCodes.ReplaceOne(ctx, bson.M{"_id": auth.DeviceCode}, auth)
+	return err
+}
+
+func (m *MongoDBStorage) DeleteDeviceAuthorization(deviceCode string) error {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.deviceCodes.DeleteOne(ctx, bson.M{"_id": deviceCode})
+	return err
+}
+
+// ErrorReport operations
+
+func (m *MongoDBStorage) CreateErrorReport(report *models.ErrorReport) error {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	if report.CreatedAt.IsZero() {
+		report.CreatedAt = time.Now()
+	}
+	_, err := m.errorReports.InsertOne(ctx, report)
+	return err
+}
+
+func (m *MongoDBStorage) GetErrorReport(reference string) (*models.ErrorReport, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	var report models.ErrorReport
+	err := m.errorReports.FindOne(ctx, bson.M{"_id": reference, "expires_at": bson.M{"$gt": time.Now()}}).Decode(&report)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
 // SigningKey operations
 
 func (m *MongoDBStorage) CreateSigningKey(key *models.SigningKey) error {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	_, err := m.signingKeys.InsertOne(ctx, key)
 	return err
 }
 
 func (m *MongoDBStorage) GetSigningKey(id string) (*models.SigningKey, error) {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	var key models.SigningKey
 	err := m.signingKeys.FindOne(ctx, bson.M{"_id": id}).Decode(&key)
 	if err == mongo.ErrNoDocuments {
@@ -716,9 +1504,8 @@ func (m *MongoDBStorage) GetSigningKey(id string) (*models.SigningKey, error) {
 }
 
 func (m *MongoDBStorage) GetSigningKeyByKID(kid string) (*models.SigningKey, error) {
-	ctx := context.Background()
 	var key models.SigningKey
-	err := m.signingKeys.FindOne(ctx, bson.M{"kid": kid}).Decode(&key)
+	err := m.findOneFromReplica(m.signingKeys, bson.M{"kid": kid}, &key)
 	if err == mongo.ErrNoDocuments {
 		return nil, nil
 	}
@@ -728,9 +1515,13 @@ func (m *MongoDBStorage) GetSigningKeyByKID(kid string) (*models.SigningKey, err
 	return &key, nil
 }
 
+// GetAllSigningKeys reads from the replica when one is configured, since it
+// backs the JWKS endpoint. A freshly rotated key can appear there up to the
+// configured staleness late; key rotation reads the key set inside a
+// transaction and so always sees the primary.
 func (m *MongoDBStorage) GetAllSigningKeys() ([]*models.SigningKey, error) {
-	ctx := context.Background()
-	cursor, err := m.signingKeys.Find(ctx, bson.M{})
+	ctx := m.baseContext()
+	cursor, err := m.readCollection(m.signingKeys).Find(ctx, bson.M{})
 	if err != nil {
 		return nil, err
 	}
@@ -746,9 +1537,9 @@ func (m *MongoDBStorage) GetAllSigningKeys() ([]*models.SigningKey, error) {
 }
 
 func (m *MongoDBStorage) GetActiveSigningKey() (*models.SigningKey, error) {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	var key models.SigningKey
-	err := m.signingKeys.FindOne(ctx, bson.M{"is_active": true}).Decode(&key)
+	err := m.signingKeys.FindOne(ctx, bson.M{"is_active": true, "use": bson.M{"$nin": []string{models.KeyUseEncryption, models.KeyUseCookie}}}).Decode(&key)
 	if err == mongo.ErrNoDocuments {
 		return nil, fmt.Errorf("no active signing key found")
 	}
@@ -759,20 +1550,40 @@ func (m *MongoDBStorage) GetActiveSigningKey() (*models.SigningKey, error) {
 }
 
 func (m *MongoDBStorage) UpdateSigningKey(key *models.SigningKey) error {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	_, err := m.signingKeys.ReplaceOne(ctx, bson.M{"_id": key.ID}, key)
 	return err
 }
 
 func (m *MongoDBStorage) DeleteSigningKey(id string) error {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	_, err := m.signingKeys.DeleteOne(ctx, bson.M{"_id": id})
 	return err
 }
 
+// RecordJTI stores a client assertion JWT ID, returning false if it was already used.
+// The unique _id makes the check atomic across replicas.
+func (m *MongoDBStorage) RecordJTI(clientID, jti string, expiresAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	id := clientID + ":" + jti
+	// The TTL monitor runs periodically, so drop an expired entry before inserting
+	_, _ = m.usedJTIs.DeleteOne(ctx, bson.M{"_id": id, "expires_at": bson.M{"$lte": time.Now()}})
+
+	_, err := m.usedJTIs.InsertOne(ctx, &models.UsedJTI{ID: id, ClientID: clientID, JTI: jti, ExpiresAt: expiresAt})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // GetActiveTokensCount returns the count of non-expired tokens
 func (m *MongoDBStorage) GetActiveTokensCount() int {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	count, err := m.tokens.CountDocuments(ctx, bson.M{
 		"expires_at": bson.M{"$gt": time.Now()},
 	})
@@ -784,7 +1595,7 @@ func (m *MongoDBStorage) GetActiveTokensCount() int {
 
 // GetRecentUserSessionsCount returns the count of user sessions created in the last 24 hours
 func (m *MongoDBStorage) GetRecentUserSessionsCount() int {
-	ctx := context.Background()
+	ctx := m.baseContext()
 	cutoff := time.Now().Add(-24 * time.Hour)
 	count, err := m.userSessions.CountDocuments(ctx, bson.M{
 		"created_at": bson.M{"$gt": cutoff},
@@ -798,16 +1609,97 @@ func (m *MongoDBStorage) GetRecentUserSessionsCount() int {
 // ─── Audit log operations ─────────────────────────────────────────────────────
 
 // CreateAuditLog inserts a new audit log entry.
+// auditChainMu serializes appends to the audit chain within this process. Writers in
+// other processes are caught by the unique seq index and retried.
+var auditChainMu sync.Mutex
+
+// auditChainRetries bounds how often an append is retried after losing a race for a seq
+const auditChainRetries = 5
+
 func (m *MongoDBStorage) CreateAuditLog(entry *models.AuditLog) error {
-	ctx := context.Background()
-	_, err := m.auditLogs.InsertOne(ctx, entry)
+	auditChainMu.Lock()
+	defer auditChainMu.Unlock()
+
+	ctx := m.baseContext()
+	for attempt := 0; ; attempt++ {
+		head, err := m.GetAuditChainHead()
+		if err != nil {
+			return err
+		}
+		entry.ChainAfter(head)
+		_, err = m.auditLogs.InsertOne(ctx, entry)
+		if err == nil || !mongo.IsDuplicateKeyError(err) || attempt == auditChainRetries {
+			return err
+		}
+	}
+}
+
+func (m *MongoDBStorage) GetAuditChain(afterSeq int64, limit int) ([]*models.AuditLog, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 30*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := m.auditLogs.Find(ctx, bson.M{"seq": bson.M{"$gt": afterSeq}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = cursor.Close(ctx) }()
+
+	var entries []*models.AuditLog
+	if err = cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (m *MongoDBStorage) GetAuditChainHead() (*models.AuditLog, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	var head models.AuditLog
+	err := m.auditLogs.FindOne(ctx, bson.M{"seq": bson.M{"$gt": 0}},
+		options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})).Decode(&head)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &head, nil
+}
+
+func (m *MongoDBStorage) CreateAuditCheckpoint(checkpoint *models.AuditCheckpoint) error {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.auditCheckpoints.InsertOne(ctx, checkpoint)
 	return err
 }
 
+func (m *MongoDBStorage) ListAuditCheckpoints() ([]*models.AuditCheckpoint, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := m.auditCheckpoints.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = cursor.Close(ctx) }()
+
+	var checkpoints []*models.AuditCheckpoint
+	if err = cursor.All(ctx, &checkpoints); err != nil {
+		return nil, err
+	}
+	return checkpoints, nil
+}
+
 // GetAuditLogs returns audit log entries ordered newest-first with optional
-// filtering by Action and/or Actor, plus limit/offset pagination.
+// filtering by Action, Actor and/or Subject, plus limit/offset pagination.
 func (m *MongoDBStorage) GetAuditLogs(filter models.AuditFilter) ([]*models.AuditLog, error) {
-	ctx := context.Background()
+	ctx := m.baseContext()
 
 	q := bson.M{}
 	if filter.Action != "" {
@@ -816,6 +1708,9 @@ func (m *MongoDBStorage) GetAuditLogs(filter models.AuditFilter) ([]*models.Audi
 	if filter.Actor != "" {
 		q["actor"] = filter.Actor
 	}
+	if filter.Subject != "" {
+		q["$or"] = bson.A{bson.M{"actor": filter.Subject}, bson.M{"resource_id": filter.Subject}}
+	}
 
 	limit := int64(filter.Limit)
 	if limit <= 0 {
@@ -843,7 +1738,7 @@ func (m *MongoDBStorage) GetAuditLogs(filter models.AuditFilter) ([]*models.Audi
 
 // GetAuditLogsCount returns the total count of entries matching the filter.
 func (m *MongoDBStorage) GetAuditLogsCount(filter models.AuditFilter) int {
-	ctx := context.Background()
+	ctx := m.baseContext()
 
 	q := bson.M{}
 	if filter.Action != "" {
@@ -852,6 +1747,9 @@ func (m *MongoDBStorage) GetAuditLogsCount(filter models.AuditFilter) int {
 	if filter.Actor != "" {
 		q["actor"] = filter.Actor
 	}
+	if filter.Subject != "" {
+		q["$or"] = bson.A{bson.M{"actor": filter.Subject}, bson.M{"resource_id": filter.Subject}}
+	}
 
 	count, err := m.auditLogs.CountDocuments(ctx, q)
 	if err != nil {
@@ -859,3 +1757,107 @@ func (m *MongoDBStorage) GetAuditLogsCount(filter models.AuditFilter) int {
 	}
 	return int(count)
 }
+
+// AnonymizeAuditLogs pseudonymizes the entries that mention any of subjects.
+func (m *MongoDBStorage) AnonymizeAuditLogs(subjects []string, pseudonym string) ([]models.AuditRedaction, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 30*time.Second)
+	defer cancel()
+
+	// Details are free-form, so matching entries are rewritten one by one
+	q := bson.M{"$or": bson.A{
+		bson.M{"actor": bson.M{"$in": subjects}},
+		bson.M{"resource_id": bson.M{"$in": subjects}},
+	}}
+	cursor, err := m.auditLogs.Find(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = cursor.Close(ctx) }()
+
+	var entries []*models.AuditLog
+	if err = cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	var redactions []models.AuditRedaction
+	for _, e := range entries {
+		r, ok := anonymizeAuditLog(e, subjects, pseudonym)
+		if !ok {
+			continue
+		}
+		if _, err := m.auditLogs.ReplaceOne(ctx, bson.M{"_id": e.ID}, e); err != nil {
+			return redactions, err
+		}
+		redactions = append(redactions, r)
+	}
+	return redactions, nil
+}
+
+// DeleteAuditLogsBefore deletes audit entries logged before cutoff
+func (m *MongoDBStorage) DeleteAuditLogsBefore(cutoff time.Time) (int, error) {
+	head, err := m.GetAuditChainHead()
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(m.baseContext(), 30*time.Second)
+	defer cancel()
+
+	q := bson.M{"timestamp": bson.M{"$lt": cutoff}}
+	if head != nil {
+		q["_id"] = bson.M{"$ne": head.ID}
+	}
+	result, err := m.auditLogs.DeleteMany(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+	return int(result.DeletedCount), nil
+}
+
+// DeleteTokensExpiredBefore deletes tokens whose access token expired before cutoff
+func (m *MongoDBStorage) DeleteTokensExpiredBefore(cutoff time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.tokens.DeleteMany(ctx, bson.M{"expires_at": bson.M{"$lt": cutoff}})
+	if err != nil {
+		return 0, err
+	}
+	return int(result.DeletedCount), nil
+}
+
+// DeleteAuthorizationCodesExpiredBefore deletes authorization codes that expired before
+// cutoff. The TTL index usually removes them first.
+func (m *MongoDBStorage) DeleteAuthorizationCodesExpiredBefore(cutoff time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.codes.DeleteMany(ctx, bson.M{"expires_at": bson.M{"$lt": cutoff}})
+	if err != nil {
+		return 0, err
+	}
+	return int(result.DeletedCount), nil
+}
+
+// DeleteUserSessionsIdleSince deletes user sessions last used before cutoff
+func (m *MongoDBStorage) DeleteUserSessionsIdleSince(cutoff time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.userSessions.DeleteMany(ctx, bson.M{"last_activity_at": bson.M{"$lt": cutoff}})
+	if err != nil {
+		return 0, err
+	}
+	return int(result.DeletedCount), nil
+}
+
+// DeleteAuthSessionsCreatedBefore deletes authorization sessions started before cutoff
+func (m *MongoDBStorage) DeleteAuthSessionsCreatedBefore(cutoff time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.authSessions.DeleteMany(ctx, bson.M{"created_at": bson.M{"$lt": cutoff}})
+	if err != nil {
+		return 0, err
+	}
+	return int(result.DeletedCount), nil
+}
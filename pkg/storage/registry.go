@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+)
+
+// Factory opens a storage backend from the server configuration. Drivers read
+// their settings from cfg.Storage, usually from cfg.Storage.Options.
+type Factory func(cfg *configstore.ConfigData) (Storage, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Factory)
+)
+
+func init() {
+	Register("json", newJSONFromConfig)
+	Register("mongodb", newMongoDBFromConfig)
+	Register("etcd", newEtcdFromConfig)
+}
+
+// Register makes a storage backend available as storage.type name. Backends built
+// outside this package call it from an init function and are compiled in with a
+// blank import. It panics if name is empty or already registered, or factory is nil.
+func Register(name string, factory Factory) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if name == "" {
+		panic("storage: Register called with an empty driver name")
+	}
+	if factory == nil {
+		panic("storage: Register factory is nil for driver " + name)
+	}
+	if _, dup := drivers[name]; dup {
+		panic("storage: Register called twice for driver " + name)
+	}
+	drivers[name] = factory
+}
+
+// Drivers returns the names of the registered storage backends, sorted
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewStorage creates the storage backend named by cfg.Storage.Type
+func NewStorage(cfg *configstore.ConfigData) (Storage, error) {
+	if cfg.Storage.Type == "" {
+		// Default to JSON storage for backward compatibility
+		return newJSONStorage("data.json")
+	}
+
+	driversMu.RLock()
+	factory, ok := drivers[cfg.Storage.Type]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported storage type %q (available: %s)", cfg.Storage.Type, strings.Join(Drivers(), ", "))
+	}
+	return factory(cfg)
+}
+
+func newJSONFromConfig(cfg *configstore.ConfigData) (Storage, error) {
+	if cfg.Storage.JSONEncryptionKey == "" {
+		return newJSONStorage(cfg.Storage.JSONFilePath)
+	}
+	key, err := ResolveEncryptionKey(cfg.Storage.JSONEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	previousKeys := make([][]byte, 0, len(cfg.Storage.JSONEncryptionPreviousKeys))
+	for _, reference := range cfg.Storage.JSONEncryptionPreviousKeys {
+		previous, err := ResolveEncryptionKey(reference)
+		if err != nil {
+			return nil, err
+		}
+		previousKeys = append(previousKeys, previous)
+	}
+	s, err := NewEncryptedJSONStorage(cfg.Storage.JSONFilePath, key, previousKeys...)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// newJSONStorage avoids wrapping a nil *JSONStorage in a non-nil Storage on error
+func newJSONStorage(filePath string) (Storage, error) {
+	s, err := NewJSONStorage(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func newMongoDBFromConfig(cfg *configstore.ConfigData) (Storage, error) {
+	// Parse database name from MongoDB URI
+	// Expected format: mongodb://host:port/database
+	uri := cfg.Storage.MongoURI
+	dbName := "openid" // default
+	if idx := strings.LastIndex(uri, "/"); idx != -1 && idx < len(uri)-1 {
+		dbName = uri[idx+1:]
+		// Remove query parameters if present
+		if qIdx := strings.Index(dbName, "?"); qIdx != -1 {
+			dbName = dbName[:qIdx]
+		}
+	}
+	s, err := NewMongoDBStorageWithTuning(uri, dbName, cfg.Storage.Tuning)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Storage.MongoReadURI != "" {
+		maxStaleness := time.Duration(cfg.Storage.ReadMaxStalenessSeconds) * time.Second
+		if err := s.UseReadReplica(cfg.Storage.MongoReadURI, maxStaleness); err != nil {
+			_ = s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func newEtcdFromConfig(cfg *configstore.ConfigData) (Storage, error) {
+	if len(cfg.Storage.EtcdEndpoints) == 0 {
+		return nil, fmt.Errorf("storage.etcd_endpoints is required for etcd storage")
+	}
+	s, err := NewEtcdStorage(EtcdOptions{
+		Endpoints: cfg.Storage.EtcdEndpoints,
+		Prefix:    cfg.Storage.EtcdPrefix,
+		Username:  cfg.Storage.EtcdUsername,
+		Password:  cfg.Storage.EtcdPassword,
+		CAFile:    cfg.Storage.EtcdCAFile,
+		CertFile:  cfg.Storage.EtcdCertFile,
+		KeyFile:   cfg.Storage.EtcdKeyFile,
+		Timeout:   seconds(cfg.Storage.Tuning.OperationTimeoutSeconds),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/prasenjit-net/openid-golang/pkg/configstore"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+)
+
+// memoryDriver stands in for a third-party backend by reusing the JSON store
+type memoryDriver struct {
+	*JSONStorage
+}
+
+func TestNewStorageUsesRegisteredDriver(t *testing.T) {
+	Register("test-memory", func(cfg *configstore.ConfigData) (Storage, error) {
+		base, err := NewJSONStorage(cfg.Storage.Options["path"])
+		if err != nil {
+			return nil, err
+		}
+		return &memoryDriver{JSONStorage: base}, nil
+	})
+
+	cfg := &configstore.ConfigData{Storage: configstore.StorageBackendConfig{
+		Type:    "test-memory",
+		Options: map[string]string{"path": filepath.Join(t.TempDir(), "data.json")},
+	}}
+	store, err := NewStorage(cfg)
+	if err != nil {
+		t.Fatalf("NewStorage failed: %v", err)
+	}
+	if _, ok := store.(*memoryDriver); !ok {
+		t.Fatalf("NewStorage returned %T, want the registered driver", store)
+	}
+
+	names := strings.Join(Drivers(), ",")
+	if names != "etcd,json,mongodb,test-memory" {
+		t.Errorf("Drivers() = %s", names)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a driver twice should panic")
+		}
+	}()
+	Register("test-memory", func(*configstore.ConfigData) (Storage, error) { return nil, nil })
+}
+
+func TestNewStorageRejectsUnknownDriver(t *testing.T) {
+	_, err := NewStorage(&configstore.ConfigData{Storage: configstore.StorageBackendConfig{Type: "spanner"}})
+	if err == nil || !strings.Contains(err.Error(), "etcd, json, mongodb") {
+		t.Errorf("NewStorage error = %v, want the available drivers listed", err)
+	}
+}
+
+func TestUseReadReplicaRejectsShortStaleness(t *testing.T) {
+	m := &MongoDBStorage{}
+	err := m.UseReadReplica("mongodb://replica:27017", 30*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "90 seconds") {
+		t.Errorf("UseReadReplica error = %v, want the 90 second minimum reported", err)
+	}
+}
+
+func TestMongoTuningDefaults(t *testing.T) {
+	got := mongoTuningDefaults(configstore.StorageTuningConfig{MaxPoolSize: 20, MinPoolSize: 50})
+	if got.MaxPoolSize != 20 || got.MinPoolSize != 20 {
+		t.Errorf("pool sizes = %d/%d, want min capped at max 20", got.MinPoolSize, got.MaxPoolSize)
+	}
+	if got.ConnectTimeoutSeconds != 10 || got.ConnectAttempts != 1 || got.RetryBackoffMillis != 500 {
+		t.Errorf("defaults not applied: %+v", got)
+	}
+	if got.OperationTimeoutSeconds != 0 || got.MaxIdleSeconds != 0 {
+		t.Errorf("optional limits should stay unset: %+v", got)
+	}
+}
+
+func TestMongoTokenFieldNames(t *testing.T) {
+	// The MongoDB backend filters and updates tokens by these names, including
+	// the $min on expires_at when a refresh token is replaced
+	raw, err := bson.Marshal(&models.Token{
+		ID: "t", AccessToken: "a", RefreshToken: "r", ClientID: "c", UserID: "u",
+		ReplacedBy: "n", ReplacedAt: time.Now(), ExpiresAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"id", "access_token", "refresh_token", "client_id", "user_id", "replaced_by", "replaced_at", "expires_at"} {
+		if _, ok := doc[field]; !ok {
+			t.Errorf("stored token has no %q field: %v", field, doc)
+		}
+	}
+}
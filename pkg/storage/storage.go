@@ -1,35 +1,63 @@
 package storage
 
 import (
-	"strings"
+	"time"
 
-	"github.com/prasenjit-net/openid-golang/pkg/configstore"
 	"github.com/prasenjit-net/openid-golang/pkg/models"
 )
 
 // Storage defines the interface for data persistence
 type Storage interface {
 	// User operations
+	// CreateUser stores a new user, setting CreatedAt and UpdatedAt unless already set
+	// (e.g. by a migration keeping the original creation time)
 	CreateUser(user *models.User) error
 	GetUserByID(id string) (*models.User, error)
 	GetUserByUsername(username string) (*models.User, error)
 	GetUserByEmail(email string) (*models.User, error)
+	// ResolveUserByLoginIdentifier finds the user whose username, email or phone_number
+	// equals identifier, ignoring case, trying fields in order. An exact match wins over
+	// one differing in case; an identifier matching several users only by case matches
+	// none of them in that field. It returns nil if no field matches.
+	ResolveUserByLoginIdentifier(identifier string, fields []string) (*models.User, error)
 	GetAllUsers() ([]*models.User, error)
+	// UpdateUser stores user as the next version, whatever version it was read at
 	UpdateUser(user *models.User) error
+	// UpdateUserIfVersion stores user as the next version, setting its Version, if the
+	// stored user is still at version. It returns false if the user does not exist or
+	// has changed since.
+	UpdateUserIfVersion(user *models.User, version int) (bool, error)
+	// UpdatePasswordHash replaces the user's password hash with newHash, moving its
+	// version on, if the stored hash is still oldHash. It returns false if the user
+	// does not exist or its password has changed since.
+	UpdatePasswordHash(id, oldHash, newHash string) (bool, error)
 	DeleteUser(id string) error
 
 	// Client operations
+	// CreateClient stores a new client, setting CreatedAt unless already set
 	CreateClient(client *models.Client) error
 	GetClientByID(id string) (*models.Client, error)
 	GetAllClients() ([]*models.Client, error)
+	// UpdateClient stores client as the next version, whatever version it was read at.
+	// The stored LastUsedAt is kept, since only TouchClient maintains it.
 	UpdateClient(client *models.Client) error
+	// UpdateClientIfVersion stores client as the next version, setting its Version, if
+	// the stored client is still at version. It returns false if the client does not
+	// exist or has changed since. As with UpdateClient, the stored LastUsedAt is kept.
+	UpdateClientIfVersion(client *models.Client, version int) (bool, error)
 	DeleteClient(id string) error
 	ValidateClient(clientID, clientSecret string) (*models.Client, error)
+	// TouchClient sets the client's LastUsedAt to usedAt and moves its version on,
+	// leaving the rest of the record alone
+	TouchClient(id string, usedAt time.Time) error
 
 	// Authorization code operations
 	CreateAuthorizationCode(code *models.AuthorizationCode) error
 	GetAuthorizationCode(code string) (*models.AuthorizationCode, error)
 	UpdateAuthorizationCode(code *models.AuthorizationCode) error
+	// MarkAuthorizationCodeUsed records that code was exchanged at usedAt. It returns false
+	// if the code does not exist or was already used, so only one exchange can win.
+	MarkAuthorizationCodeUsed(code string, usedAt time.Time) (bool, error)
 	DeleteAuthorizationCode(code string) error
 
 	// Token operations
@@ -39,7 +67,12 @@ type Storage interface {
 	GetTokensByAuthCode(authCodeID string) ([]*models.Token, error)
 	DeleteToken(accessToken string) error
 	RevokeTokensByAuthCode(authCodeID string) error
+	RevokeTokensBySession(sessionID string) error
 	ListTokens(clientID, userID string, activeOnly bool) ([]*models.Token, error)
+	// MarkTokenReplaced records that the token with ID id was refreshed into replacementID
+	// at replacedAt, cutting its expiry to expiresAt if that is sooner. It returns false if
+	// the token does not exist or was already replaced, so only one refresh can win.
+	MarkTokenReplaced(id, replacementID string, replacedAt, expiresAt time.Time) (bool, error)
 
 	// Session operations
 	CreateSession(session *models.Session) error
@@ -51,11 +84,14 @@ type Storage interface {
 	GetAuthSession(id string) (*models.AuthSession, error)
 	UpdateAuthSession(session *models.AuthSession) error
 	DeleteAuthSession(id string) error
+	GetAuthSessionsByOwner(owner string) ([]*models.AuthSession, error)
+	CleanupExpiredAuthSessions() (int, error)
 
 	// UserSession operations (authenticated user sessions)
 	CreateUserSession(session *models.UserSession) error
 	GetUserSession(id string) (*models.UserSession, error)
 	GetUserSessionByUserID(userID string) (*models.UserSession, error)
+	GetUserSessionsByUserID(userID string) ([]*models.UserSession, error)
 	UpdateUserSession(session *models.UserSession) error
 	DeleteUserSession(id string) error
 	CleanupExpiredSessions() error
@@ -66,14 +102,50 @@ type Storage interface {
 	UpdateConsent(consent *models.Consent) error
 	DeleteConsent(userID, clientID string) error
 	DeleteConsentsForUser(userID string) error
+	GetConsentsByUserID(userID string) ([]*models.Consent, error)
+
+	// ConsentReceipt operations (kept as a record of every consent grant)
+	CreateConsentReceipt(receipt *models.ConsentReceipt) error
+	ListConsentReceipts(userID, clientID string) ([]*models.ConsentReceipt, error)
+	DeleteConsentReceiptsForUser(userID string) error
 
 	// InitialAccessToken operations (for dynamic client registration)
 	CreateInitialAccessToken(token *models.InitialAccessToken) error
 	GetInitialAccessToken(token string) (*models.InitialAccessToken, error)
 	UpdateInitialAccessToken(token *models.InitialAccessToken) error
+	// UseInitialAccessToken counts the registration of clientID against token and marks
+	// the token used once maxUses clients were registered with it. It returns false if the
+	// token does not exist or is used up, so concurrent registrations cannot exceed maxUses.
+	UseInitialAccessToken(token, clientID string, maxUses int, usedAt time.Time) (bool, error)
 	DeleteInitialAccessToken(token string) error
 	GetAllInitialAccessTokens() ([]*models.InitialAccessToken, error)
 
+	// APIKey operations (long-lived credentials of service accounts)
+	CreateAPIKey(key *models.APIKey) error
+	GetAPIKeyByHash(keyHash string) (*models.APIKey, error)
+	ListAPIKeys(userID string) ([]*models.APIKey, error)
+	UpdateAPIKey(key *models.APIKey) error
+	DeleteAPIKey(id string) error
+	DeleteAPIKeysForUser(userID string) error
+
+	// ClientInstance operations (installs of public native clients)
+	CreateClientInstance(instance *models.ClientInstance) error
+	GetClientInstance(id string) (*models.ClientInstance, error)
+	ListClientInstances(clientID string) ([]*models.ClientInstance, error)
+	UpdateClientInstance(instance *models.ClientInstance) error
+	DeleteClientInstance(id string) error
+
+	// DeviceAuthorization operations (device authorization grant)
+	CreateDeviceAuthorization(auth *models.DeviceAuthorization) error
+	GetDeviceAuthorization(deviceCode string) (*models.DeviceAuthorization, error)
+	GetDeviceAuthorizationByUserCode(userCode string) (*models.DeviceAuthorization, error)
+	UpdateDeviceAuthorization(auth *models.DeviceAuthorization) error
+	DeleteDeviceAuthorization(deviceCode string) error
+
+	// ErrorReport operations (references handed out in error_uri)
+	CreateErrorReport(report *models.ErrorReport) error
+	GetErrorReport(reference string) (*models.ErrorReport, error)
+
 	// SigningKey operations (for key rotation)
 	CreateSigningKey(key *models.SigningKey) error
 	GetSigningKey(id string) (*models.SigningKey, error)
@@ -83,6 +155,10 @@ type Storage interface {
 	UpdateSigningKey(key *models.SigningKey) error
 	DeleteSigningKey(id string) error
 
+	// Client assertion replay protection
+	// RecordJTI stores a JWT ID until it expires. It returns false if the ID was already recorded.
+	RecordJTI(clientID, jti string, expiresAt time.Time) (bool, error)
+
 	// Statistics operations
 	GetActiveTokensCount() int
 	GetRecentUserSessionsCount() int
@@ -91,30 +167,63 @@ type Storage interface {
 	CreateAuditLog(entry *models.AuditLog) error
 	GetAuditLogs(filter models.AuditFilter) ([]*models.AuditLog, error)
 	GetAuditLogsCount(filter models.AuditFilter) int
+	// GetAuditChain returns up to limit chained audit entries with a sequence number
+	// above afterSeq, in chain order. GetAuditChainHead returns the latest one, or nil.
+	GetAuditChain(afterSeq int64, limit int) ([]*models.AuditLog, error)
+	GetAuditChainHead() (*models.AuditLog, error)
+	CreateAuditCheckpoint(checkpoint *models.AuditCheckpoint) error
+	ListAuditCheckpoints() ([]*models.AuditCheckpoint, error)
+	// AnonymizeAuditLogs rewrites the entries whose actor or resource ID is one of subjects
+	// (e.g. a user's ID and username): subjects in the actor, resource ID and detail values
+	// become pseudonym, the IP address and user agent are cleared and the entry is marked
+	// Redacted since it no longer matches its chain hash. It returns a redaction for each
+	// entry changed, which the caller must record on a new chained entry for the chain
+	// to verify (see models.AuditRedaction).
+	AnonymizeAuditLogs(subjects []string, pseudonym string) ([]models.AuditRedaction, error)
+
+	// Retention operations, each returning the number of records deleted. The head of
+	// the audit chain is never deleted so later entries keep chaining from it.
+	DeleteAuditLogsBefore(cutoff time.Time) (int, error)
+	DeleteTokensExpiredBefore(cutoff time.Time) (int, error)
+	DeleteAuthorizationCodesExpiredBefore(cutoff time.Time) (int, error)
+	DeleteUserSessionsIdleSince(cutoff time.Time) (int, error)
+	DeleteAuthSessionsCreatedBefore(cutoff time.Time) (int, error)
+
+	// RunInTransaction runs fn as a single unit of work: if fn returns an error, none of
+	// the changes it made through tx are kept. Use tx, not the outer storage, inside fn.
+	RunInTransaction(fn func(tx Storage) error) error
 
 	Close() error
 }
 
-// NewStorage creates a new storage instance based on configuration
-func NewStorage(cfg *configstore.ConfigData) (Storage, error) {
-	switch cfg.Storage.Type {
-	case "mongodb":
-		// Parse database name from MongoDB URI
-		// Expected format: mongodb://host:port/database
-		uri := cfg.Storage.MongoURI
-		dbName := "openid" // default
-		if idx := strings.LastIndex(uri, "/"); idx != -1 && idx < len(uri)-1 {
-			dbName = uri[idx+1:]
-			// Remove query parameters if present
-			if qIdx := strings.Index(dbName, "?"); qIdx != -1 {
-				dbName = dbName[:qIdx]
+// anonymizeAuditLog rewrites e as described by Storage.AnonymizeAuditLogs and reports
+// whether e concerned any of subjects. e keeps its original chain hash.
+func anonymizeAuditLog(e *models.AuditLog, subjects []string, pseudonym string) (models.AuditRedaction, bool) {
+	isSubject := func(v string) bool {
+		for _, s := range subjects {
+			if s != "" && v == s {
+				return true
 			}
 		}
-		return NewMongoDBStorage(uri, dbName)
-	case "json":
-		return NewJSONStorage(cfg.Storage.JSONFilePath)
-	default:
-		// Default to JSON storage for backward compatibility
-		return NewJSONStorage("data.json")
+		return false
+	}
+
+	if !isSubject(e.Actor) && !isSubject(e.ResourceID) {
+		return models.AuditRedaction{}, false
+	}
+	if isSubject(e.Actor) {
+		e.Actor = pseudonym
+	}
+	if isSubject(e.ResourceID) {
+		e.ResourceID = pseudonym
+	}
+	for k, v := range e.Details {
+		if s, ok := v.(string); ok && isSubject(s) {
+			e.Details[k] = pseudonym
+		}
 	}
+	e.IPAddress = ""
+	e.UserAgent = ""
+	e.Redacted = true
+	return models.AuditRedaction{Seq: e.Seq, Hash: e.Hash, RedactedHash: e.ComputeHash()}, true
 }
@@ -0,0 +1,890 @@
+package storagetest
+
+import (
+	"time"
+
+	"github.com/stretchr/testify/mock"
+
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+// MockStorage is a storage.Storage built on testify's mock.Mock. Methods with
+// an expectation set through On answer as the expectation says; every other
+// method succeeds without doing anything, returning nothing found, zero counts
+// and, for the compare-and-set methods MarkAuthorizationCodeUsed,
+// MarkTokenReplaced, RecordJTI, UpdatePasswordHash and UseInitialAccessToken,
+// true. Set
+// expectations before the code under test runs.
+//
+//	store := new(storagetest.MockStorage)
+//	store.On("GetUserByID", "u1").Return(storagetest.User(), nil)
+type MockStorage struct {
+	mock.Mock
+}
+
+var _ storage.Storage = (*MockStorage)(nil)
+
+// expects reports whether an expectation was set for method
+func (m *MockStorage) expects(method string) bool {
+	for _, call := range m.ExpectedCalls {
+		if call.Method == method {
+			return true
+		}
+	}
+	return false
+}
+
+// value returns argument i as a T, or T's zero value when it is nil
+func value[T any](args mock.Arguments, i int) T {
+	v, _ := args.Get(i).(T)
+	return v
+}
+
+// RunInTransaction runs fn against the mock itself unless an expectation is set
+func (m *MockStorage) RunInTransaction(fn func(tx storage.Storage) error) error {
+	if !m.expects("RunInTransaction") {
+		return fn(m)
+	}
+	args := m.Called(fn)
+	return args.Error(0)
+}
+
+func (m *MockStorage) Close() error {
+	if !m.expects("Close") {
+		return nil
+	}
+	return m.Called().Error(0)
+}
+
+func (m *MockStorage) CreateUser(user *models.User) error {
+	if !m.expects("CreateUser") {
+		return nil
+	}
+	args := m.Called(user)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetUserByID(id string) (*models.User, error) {
+	if !m.expects("GetUserByID") {
+		return nil, nil
+	}
+	args := m.Called(id)
+	return value[*models.User](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) GetUserByUsername(username string) (*models.User, error) {
+	if !m.expects("GetUserByUsername") {
+		return nil, nil
+	}
+	args := m.Called(username)
+	return value[*models.User](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) GetUserByEmail(email string) (*models.User, error) {
+	if !m.expects("GetUserByEmail") {
+		return nil, nil
+	}
+	args := m.Called(email)
+	return value[*models.User](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) ResolveUserByLoginIdentifier(identifier string, fields []string) (*models.User, error) {
+	if !m.expects("ResolveUserByLoginIdentifier") {
+		return nil, nil
+	}
+	args := m.Called(identifier, fields)
+	return value[*models.User](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) GetAllUsers() ([]*models.User, error) {
+	if !m.expects("GetAllUsers") {
+		return nil, nil
+	}
+	args := m.Called()
+	return value[[]*models.User](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) UpdateUser(user *models.User) error {
+	if !m.expects("UpdateUser") {
+		return nil
+	}
+	args := m.Called(user)
+	return args.Error(0)
+}
+
+func (m *MockStorage) UpdateUserIfVersion(user *models.User, version int) (bool, error) {
+	if !m.expects("UpdateUserIfVersion") {
+		return true, nil
+	}
+	args := m.Called(user, version)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStorage) UpdatePasswordHash(id, oldHash, newHash string) (bool, error) {
+	if !m.expects("UpdatePasswordHash") {
+		return true, nil
+	}
+	args := m.Called(id, oldHash, newHash)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStorage) DeleteUser(id string) error {
+	if !m.expects("DeleteUser") {
+		return nil
+	}
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) CreateClient(client *models.Client) error {
+	if !m.expects("CreateClient") {
+		return nil
+	}
+	args := m.Called(client)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetClientByID(id string) (*models.Client, error) {
+	if !m.expects("GetClientByID") {
+		return nil, nil
+	}
+	args := m.Called(id)
+	return value[*models.Client](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) GetAllClients() ([]*models.Client, error) {
+	if !m.expects("GetAllClients") {
+		return nil, nil
+	}
+	args := m.Called()
+	return value[[]*models.Client](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) UpdateClient(client *models.Client) error {
+	if !m.expects("UpdateClient") {
+		return nil
+	}
+	args := m.Called(client)
+	return args.Error(0)
+}
+
+func (m *MockStorage) UpdateClientIfVersion(client *models.Client, version int) (bool, error) {
+	if !m.expects("UpdateClientIfVersion") {
+		return true, nil
+	}
+	args := m.Called(client, version)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStorage) DeleteClient(id string) error {
+	if !m.expects("DeleteClient") {
+		return nil
+	}
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) ValidateClient(clientID, clientSecret string) (*models.Client, error) {
+	if !m.expects("ValidateClient") {
+		return nil, nil
+	}
+	args := m.Called(clientID, clientSecret)
+	return value[*models.Client](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) TouchClient(id string, usedAt time.Time) error {
+	if !m.expects("TouchClient") {
+		return nil
+	}
+	args := m.Called(id, usedAt)
+	return args.Error(0)
+}
+
+func (m *MockStorage) CreateAuthorizationCode(code *models.AuthorizationCode) error {
+	if !m.expects("CreateAuthorizationCode") {
+		return nil
+	}
+	args := m.Called(code)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetAuthorizationCode(code string) (*models.AuthorizationCode, error) {
+	if !m.expects("GetAuthorizationCode") {
+		return nil, nil
+	}
+	args := m.Called(code)
+	return value[*models.AuthorizationCode](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) UpdateAuthorizationCode(code *models.AuthorizationCode) error {
+	if !m.expects("UpdateAuthorizationCode") {
+		return nil
+	}
+	args := m.Called(code)
+	return args.Error(0)
+}
+
+func (m *MockStorage) MarkAuthorizationCodeUsed(code string, usedAt time.Time) (bool, error) {
+	if !m.expects("MarkAuthorizationCodeUsed") {
+		return true, nil
+	}
+	args := m.Called(code, usedAt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStorage) DeleteAuthorizationCode(code string) error {
+	if !m.expects("DeleteAuthorizationCode") {
+		return nil
+	}
+	args := m.Called(code)
+	return args.Error(0)
+}
+
+func (m *MockStorage) CreateToken(token *models.Token) error {
+	if !m.expects("CreateToken") {
+		return nil
+	}
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetTokenByAccessToken(accessToken string) (*models.Token, error) {
+	if !m.expects("GetTokenByAccessToken") {
+		return nil, nil
+	}
+	args := m.Called(accessToken)
+	return value[*models.Token](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) GetTokenByRefreshToken(refreshToken string) (*models.Token, error) {
+	if !m.expects("GetTokenByRefreshToken") {
+		return nil, nil
+	}
+	args := m.Called(refreshToken)
+	return value[*models.Token](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) GetTokensByAuthCode(authCodeID string) ([]*models.Token, error) {
+	if !m.expects("GetTokensByAuthCode") {
+		return nil, nil
+	}
+	args := m.Called(authCodeID)
+	return value[[]*models.Token](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) DeleteToken(accessToken string) error {
+	if !m.expects("DeleteToken") {
+		return nil
+	}
+	args := m.Called(accessToken)
+	return args.Error(0)
+}
+
+func (m *MockStorage) RevokeTokensByAuthCode(authCodeID string) error {
+	if !m.expects("RevokeTokensByAuthCode") {
+		return nil
+	}
+	args := m.Called(authCodeID)
+	return args.Error(0)
+}
+
+func (m *MockStorage) RevokeTokensBySession(sessionID string) error {
+	if !m.expects("RevokeTokensBySession") {
+		return nil
+	}
+	args := m.Called(sessionID)
+	return args.Error(0)
+}
+
+func (m *MockStorage) ListTokens(clientID, userID string, activeOnly bool) ([]*models.Token, error) {
+	if !m.expects("ListTokens") {
+		return nil, nil
+	}
+	args := m.Called(clientID, userID, activeOnly)
+	return value[[]*models.Token](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) MarkTokenReplaced(id, replacementID string, replacedAt, expiresAt time.Time) (bool, error) {
+	if !m.expects("MarkTokenReplaced") {
+		return true, nil
+	}
+	args := m.Called(id, replacementID, replacedAt, expiresAt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStorage) CreateSession(session *models.Session) error {
+	if !m.expects("CreateSession") {
+		return nil
+	}
+	args := m.Called(session)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetSession(id string) (*models.Session, error) {
+	if !m.expects("GetSession") {
+		return nil, nil
+	}
+	args := m.Called(id)
+	return value[*models.Session](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) DeleteSession(id string) error {
+	if !m.expects("DeleteSession") {
+		return nil
+	}
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) CreateAuthSession(session *models.AuthSession) error {
+	if !m.expects("CreateAuthSession") {
+		return nil
+	}
+	args := m.Called(session)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetAuthSession(id string) (*models.AuthSession, error) {
+	if !m.expects("GetAuthSession") {
+		return nil, nil
+	}
+	args := m.Called(id)
+	return value[*models.AuthSession](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) UpdateAuthSession(session *models.AuthSession) error {
+	if !m.expects("UpdateAuthSession") {
+		return nil
+	}
+	args := m.Called(session)
+	return args.Error(0)
+}
+
+func (m *MockStorage) DeleteAuthSession(id string) error {
+	if !m.expects("DeleteAuthSession") {
+		return nil
+	}
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetAuthSessionsByOwner(owner string) ([]*models.AuthSession, error) {
+	if !m.expects("GetAuthSessionsByOwner") {
+		return nil, nil
+	}
+	args := m.Called(owner)
+	return value[[]*models.AuthSession](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) CleanupExpiredAuthSessions() (int, error) {
+	if !m.expects("CleanupExpiredAuthSessions") {
+		return 0, nil
+	}
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockStorage) CreateUserSession(session *models.UserSession) error {
+	if !m.expects("CreateUserSession") {
+		return nil
+	}
+	args := m.Called(session)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetUserSession(id string) (*models.UserSession, error) {
+	if !m.expects("GetUserSession") {
+		return nil, nil
+	}
+	args := m.Called(id)
+	return value[*models.UserSession](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) GetUserSessionByUserID(userID string) (*models.UserSession, error) {
+	if !m.expects("GetUserSessionByUserID") {
+		return nil, nil
+	}
+	args := m.Called(userID)
+	return value[*models.UserSession](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) GetUserSessionsByUserID(userID string) ([]*models.UserSession, error) {
+	if !m.expects("GetUserSessionsByUserID") {
+		return nil, nil
+	}
+	args := m.Called(userID)
+	return value[[]*models.UserSession](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) UpdateUserSession(session *models.UserSession) error {
+	if !m.expects("UpdateUserSession") {
+		return nil
+	}
+	args := m.Called(session)
+	return args.Error(0)
+}
+
+func (m *MockStorage) DeleteUserSession(id string) error {
+	if !m.expects("DeleteUserSession") {
+		return nil
+	}
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) CleanupExpiredSessions() error {
+	if !m.expects("CleanupExpiredSessions") {
+		return nil
+	}
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockStorage) CreateConsent(consent *models.Consent) error {
+	if !m.expects("CreateConsent") {
+		return nil
+	}
+	args := m.Called(consent)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetConsent(userID, clientID string) (*models.Consent, error) {
+	if !m.expects("GetConsent") {
+		return nil, nil
+	}
+	args := m.Called(userID, clientID)
+	return value[*models.Consent](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) UpdateConsent(consent *models.Consent) error {
+	if !m.expects("UpdateConsent") {
+		return nil
+	}
+	args := m.Called(consent)
+	return args.Error(0)
+}
+
+func (m *MockStorage) DeleteConsent(userID, clientID string) error {
+	if !m.expects("DeleteConsent") {
+		return nil
+	}
+	args := m.Called(userID, clientID)
+	return args.Error(0)
+}
+
+func (m *MockStorage) DeleteConsentsForUser(userID string) error {
+	if !m.expects("DeleteConsentsForUser") {
+		return nil
+	}
+	args := m.Called(userID)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetConsentsByUserID(userID string) ([]*models.Consent, error) {
+	if !m.expects("GetConsentsByUserID") {
+		return nil, nil
+	}
+	args := m.Called(userID)
+	return value[[]*models.Consent](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) CreateConsentReceipt(receipt *models.ConsentReceipt) error {
+	if !m.expects("CreateConsentReceipt") {
+		return nil
+	}
+	args := m.Called(receipt)
+	return args.Error(0)
+}
+
+func (m *MockStorage) ListConsentReceipts(userID, clientID string) ([]*models.ConsentReceipt, error) {
+	if !m.expects("ListConsentReceipts") {
+		return nil, nil
+	}
+	args := m.Called(userID, clientID)
+	return value[[]*models.ConsentReceipt](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) DeleteConsentReceiptsForUser(userID string) error {
+	if !m.expects("DeleteConsentReceiptsForUser") {
+		return nil
+	}
+	args := m.Called(userID)
+	return args.Error(0)
+}
+
+func (m *MockStorage) CreateInitialAccessToken(token *models.InitialAccessToken) error {
+	if !m.expects("CreateInitialAccessToken") {
+		return nil
+	}
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetInitialAccessToken(token string) (*models.InitialAccessToken, error) {
+	if !m.expects("GetInitialAccessToken") {
+		return nil, nil
+	}
+	args := m.Called(token)
+	return value[*models.InitialAccessToken](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) UpdateInitialAccessToken(token *models.InitialAccessToken) error {
+	if !m.expects("UpdateInitialAccessToken") {
+		return nil
+	}
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockStorage) UseInitialAccessToken(token, clientID string, maxUses int, usedAt time.Time) (bool, error) {
+	if !m.expects("UseInitialAccessToken") {
+		return true, nil
+	}
+	args := m.Called(token, clientID, maxUses, usedAt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStorage) DeleteInitialAccessToken(token string) error {
+	if !m.expects("DeleteInitialAccessToken") {
+		return nil
+	}
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetAllInitialAccessTokens() ([]*models.InitialAccessToken, error) {
+	if !m.expects("GetAllInitialAccessTokens") {
+		return nil, nil
+	}
+	args := m.Called()
+	return value[[]*models.InitialAccessToken](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) CreateAPIKey(key *models.APIKey) error {
+	if !m.expects("CreateAPIKey") {
+		return nil
+	}
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	if !m.expects("GetAPIKeyByHash") {
+		return nil, nil
+	}
+	args := m.Called(keyHash)
+	return value[*models.APIKey](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) ListAPIKeys(userID string) ([]*models.APIKey, error) {
+	if !m.expects("ListAPIKeys") {
+		return nil, nil
+	}
+	args := m.Called(userID)
+	return value[[]*models.APIKey](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) UpdateAPIKey(key *models.APIKey) error {
+	if !m.expects("UpdateAPIKey") {
+		return nil
+	}
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+func (m *MockStorage) DeleteAPIKey(id string) error {
+	if !m.expects("DeleteAPIKey") {
+		return nil
+	}
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) DeleteAPIKeysForUser(userID string) error {
+	if !m.expects("DeleteAPIKeysForUser") {
+		return nil
+	}
+	args := m.Called(userID)
+	return args.Error(0)
+}
+
+func (m *MockStorage) CreateClientInstance(instance *models.ClientInstance) error {
+	if !m.expects("CreateClientInstance") {
+		return nil
+	}
+	args := m.Called(instance)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetClientInstance(id string) (*models.ClientInstance, error) {
+	if !m.expects("GetClientInstance") {
+		return nil, nil
+	}
+	args := m.Called(id)
+	return value[*models.ClientInstance](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) ListClientInstances(clientID string) ([]*models.ClientInstance, error) {
+	if !m.expects("ListClientInstances") {
+		return nil, nil
+	}
+	args := m.Called(clientID)
+	return value[[]*models.ClientInstance](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) UpdateClientInstance(instance *models.ClientInstance) error {
+	if !m.expects("UpdateClientInstance") {
+		return nil
+	}
+	args := m.Called(instance)
+	return args.Error(0)
+}
+
+func (m *MockStorage) DeleteClientInstance(id string) error {
+	if !m.expects("DeleteClientInstance") {
+		return nil
+	}
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) CreateDeviceAuthorization(auth *models.DeviceAuthorization) error {
+	if !m.expects("CreateDeviceAuthorization") {
+		return nil
+	}
+	args := m.Called(auth)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetDeviceAuthorization(deviceCode string) (*models.DeviceAuthorization, error) {
+	if !m.expects("GetDeviceAuthorization") {
+		return nil, nil
+	}
+	args := m.Called(deviceCode)
+	return value[*models.DeviceAuthorization](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) GetDeviceAuthorizationByUserCode(userCode string) (*models.DeviceAuthorization, error) {
+	if !m.expects("GetDeviceAuthorizationByUserCode") {
+		return nil, nil
+	}
+	args := m.Called(userCode)
+	return value[*models.DeviceAuthorization](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) UpdateDeviceAuthorization(auth *models.DeviceAuthorization) error {
+	if !m.expects("UpdateDeviceAuthorization") {
+		return nil
+	}
+	args := m.Called(auth)
+	return args.Error(0)
+}
+
+func (m *MockStorage) DeleteDeviceAuthorization(deviceCode string) error {
+	if !m.expects("DeleteDeviceAuthorization") {
+		return nil
+	}
+	args := m.Called(deviceCode)
+	return args.Error(0)
+}
+
+func (m *MockStorage) CreateErrorReport(report *models.ErrorReport) error {
+	if !m.expects("CreateErrorReport") {
+		return nil
+	}
+	args := m.Called(report)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetErrorReport(reference string) (*models.ErrorReport, error) {
+	if !m.expects("GetErrorReport") {
+		return nil, nil
+	}
+	args := m.Called(reference)
+	return value[*models.ErrorReport](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) CreateSigningKey(key *models.SigningKey) error {
+	if !m.expects("CreateSigningKey") {
+		return nil
+	}
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetSigningKey(id string) (*models.SigningKey, error) {
+	if !m.expects("GetSigningKey") {
+		return nil, nil
+	}
+	args := m.Called(id)
+	return value[*models.SigningKey](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) GetSigningKeyByKID(kid string) (*models.SigningKey, error) {
+	if !m.expects("GetSigningKeyByKID") {
+		return nil, nil
+	}
+	args := m.Called(kid)
+	return value[*models.SigningKey](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) GetAllSigningKeys() ([]*models.SigningKey, error) {
+	if !m.expects("GetAllSigningKeys") {
+		return nil, nil
+	}
+	args := m.Called()
+	return value[[]*models.SigningKey](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) GetActiveSigningKey() (*models.SigningKey, error) {
+	if !m.expects("GetActiveSigningKey") {
+		return nil, nil
+	}
+	args := m.Called()
+	return value[*models.SigningKey](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) UpdateSigningKey(key *models.SigningKey) error {
+	if !m.expects("UpdateSigningKey") {
+		return nil
+	}
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+func (m *MockStorage) DeleteSigningKey(id string) error {
+	if !m.expects("DeleteSigningKey") {
+		return nil
+	}
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorage) RecordJTI(clientID, jti string, expiresAt time.Time) (bool, error) {
+	if !m.expects("RecordJTI") {
+		return true, nil
+	}
+	args := m.Called(clientID, jti, expiresAt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStorage) GetActiveTokensCount() int {
+	if !m.expects("GetActiveTokensCount") {
+		return 0
+	}
+	args := m.Called()
+	return args.Int(0)
+}
+
+func (m *MockStorage) GetRecentUserSessionsCount() int {
+	if !m.expects("GetRecentUserSessionsCount") {
+		return 0
+	}
+	args := m.Called()
+	return args.Int(0)
+}
+
+func (m *MockStorage) CreateAuditLog(entry *models.AuditLog) error {
+	if !m.expects("CreateAuditLog") {
+		return nil
+	}
+	args := m.Called(entry)
+	return args.Error(0)
+}
+
+func (m *MockStorage) GetAuditLogs(filter models.AuditFilter) ([]*models.AuditLog, error) {
+	if !m.expects("GetAuditLogs") {
+		return nil, nil
+	}
+	args := m.Called(filter)
+	return value[[]*models.AuditLog](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) GetAuditLogsCount(filter models.AuditFilter) int {
+	if !m.expects("GetAuditLogsCount") {
+		return 0
+	}
+	args := m.Called(filter)
+	return args.Int(0)
+}
+
+func (m *MockStorage) GetAuditChain(afterSeq int64, limit int) ([]*models.AuditLog, error) {
+	if !m.expects("GetAuditChain") {
+		return nil, nil
+	}
+	args := m.Called(afterSeq, limit)
+	return value[[]*models.AuditLog](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) GetAuditChainHead() (*models.AuditLog, error) {
+	if !m.expects("GetAuditChainHead") {
+		return nil, nil
+	}
+	args := m.Called()
+	return value[*models.AuditLog](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) CreateAuditCheckpoint(checkpoint *models.AuditCheckpoint) error {
+	if !m.expects("CreateAuditCheckpoint") {
+		return nil
+	}
+	args := m.Called(checkpoint)
+	return args.Error(0)
+}
+
+func (m *MockStorage) ListAuditCheckpoints() ([]*models.AuditCheckpoint, error) {
+	if !m.expects("ListAuditCheckpoints") {
+		return nil, nil
+	}
+	args := m.Called()
+	return value[[]*models.AuditCheckpoint](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) AnonymizeAuditLogs(subjects []string, pseudonym string) ([]models.AuditRedaction, error) {
+	if !m.expects("AnonymizeAuditLogs") {
+		return nil, nil
+	}
+	args := m.Called(subjects, pseudonym)
+	return value[[]models.AuditRedaction](args, 0), args.Error(1)
+}
+
+func (m *MockStorage) DeleteAuditLogsBefore(cutoff time.Time) (int, error) {
+	if !m.expects("DeleteAuditLogsBefore") {
+		return 0, nil
+	}
+	args := m.Called(cutoff)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockStorage) DeleteTokensExpiredBefore(cutoff time.Time) (int, error) {
+	if !m.expects("DeleteTokensExpiredBefore") {
+		return 0, nil
+	}
+	args := m.Called(cutoff)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockStorage) DeleteAuthorizationCodesExpiredBefore(cutoff time.Time) (int, error) {
+	if !m.expects("DeleteAuthorizationCodesExpiredBefore") {
+		return 0, nil
+	}
+	args := m.Called(cutoff)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockStorage) DeleteUserSessionsIdleSince(cutoff time.Time) (int, error) {
+	if !m.expects("DeleteUserSessionsIdleSince") {
+		return 0, nil
+	}
+	args := m.Called(cutoff)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockStorage) DeleteAuthSessionsCreatedBefore(cutoff time.Time) (int, error) {
+	if !m.expects("DeleteAuthSessionsCreatedBefore") {
+		return 0, nil
+	}
+	args := m.Called(cutoff)
+	return args.Int(0), args.Error(1)
+}
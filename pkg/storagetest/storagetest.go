@@ -0,0 +1,188 @@
+// Package storagetest helps test code written against storage.Storage, such as
+// handlers, extensions and custom storage backends.
+//
+// It offers a real store in a temporary directory (NewJSONStorage), a testify
+// mock (MockStorage), and builders that return valid users, clients, tokens and
+// sessions with unique IDs, to be adjusted with options:
+//
+//	store := storagetest.NewJSONStorage(t)
+//	user := storagetest.User(func(u *models.User) { u.Username = "alice" })
+//	client := storagetest.Client()
+//	storagetest.Create(t, store, user, client, storagetest.Token(user, client))
+package storagetest
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+// Password is the password of every user made by User
+const Password = "password123"
+
+var (
+	sequence atomic.Int64
+
+	// passwordHash is computed once; hashing is slow by design
+	passwordHash = func() string {
+		hash, err := crypto.HashPassword(Password)
+		if err != nil {
+			panic(err)
+		}
+		return hash
+	}()
+)
+
+// next returns a number unique within the test binary, for IDs and names
+func next() int64 {
+	return sequence.Add(1)
+}
+
+// NewJSONStorage returns an empty JSON file store in a temporary directory,
+// closed when the test ends
+func NewJSONStorage(t testing.TB) storage.Storage {
+	t.Helper()
+	store, err := storage.NewJSONStorage(filepath.Join(t.TempDir(), "storage.json"))
+	if err != nil {
+		t.Fatalf("storagetest: failed to create JSON storage: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+// Create stores each record with the matching Create method of store, failing
+// the test on the first error. Records can be users, clients, tokens, user
+// sessions, auth sessions, authorization codes, consents and audit log entries.
+func Create(t testing.TB, store storage.Storage, records ...interface{}) {
+	t.Helper()
+	for _, record := range records {
+		var err error
+		switch r := record.(type) {
+		case *models.User:
+			err = store.CreateUser(r)
+		case *models.Client:
+			err = store.CreateClient(r)
+		case *models.Token:
+			err = store.CreateToken(r)
+		case *models.UserSession:
+			err = store.CreateUserSession(r)
+		case *models.AuthSession:
+			err = store.CreateAuthSession(r)
+		case *models.AuthorizationCode:
+			err = store.CreateAuthorizationCode(r)
+		case *models.Consent:
+			err = store.CreateConsent(r)
+		case *models.AuditLog:
+			err = store.CreateAuditLog(r)
+		default:
+			t.Fatalf("storagetest: cannot create a %T", record)
+		}
+		if err != nil {
+			t.Fatalf("storagetest: failed to create %T: %v", record, err)
+		}
+	}
+}
+
+// User returns an active user who signs in with Password
+func User(opts ...func(*models.User)) *models.User {
+	n := next()
+	now := time.Now()
+	user := &models.User{
+		ID:                fmt.Sprintf("user-%d", n),
+		Username:          fmt.Sprintf("user%d", n),
+		Email:             fmt.Sprintf("user%d@example.com", n),
+		EmailVerified:     true,
+		PasswordHash:      passwordHash,
+		Role:              models.RoleUser,
+		Name:              fmt.Sprintf("Test User %d", n),
+		PreferredUsername: fmt.Sprintf("user%d", n),
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+	for _, opt := range opts {
+		opt(user)
+	}
+	return user
+}
+
+// Client returns a confidential client for the authorization code and refresh
+// token grants, redirecting to https://client<n>.example.com/callback
+func Client(opts ...func(*models.Client)) *models.Client {
+	n := next()
+	client := models.NewClient(fmt.Sprintf("Test Client %d", n),
+		[]string{fmt.Sprintf("https://client%d.example.com/callback", n)})
+	client.ID = fmt.Sprintf("client-%d", n)
+	client.Secret = fmt.Sprintf("secret-%d", n)
+	for _, opt := range opts {
+		opt(client)
+	}
+	return client
+}
+
+// Token returns an unexpired access and refresh token issued to user through client
+func Token(user *models.User, client *models.Client, opts ...func(*models.Token)) *models.Token {
+	n := next()
+	now := time.Now()
+	token := &models.Token{
+		ID:           fmt.Sprintf("token-%d", n),
+		AccessToken:  fmt.Sprintf("access-token-%d", n),
+		RefreshToken: fmt.Sprintf("refresh-token-%d", n),
+		TokenType:    "Bearer",
+		ClientID:     client.ID,
+		UserID:       user.ID,
+		Scope:        "openid profile email",
+		ExpiresAt:    now.Add(time.Hour),
+		CreatedAt:    now,
+	}
+	for _, opt := range opts {
+		opt(token)
+	}
+	return token
+}
+
+// UserSession returns a signed-in session of user that authenticated with a password just now
+func UserSession(user *models.User, opts ...func(*models.UserSession)) *models.UserSession {
+	now := time.Now()
+	session := &models.UserSession{
+		ID:                   fmt.Sprintf("user-session-%d", next()),
+		UserID:               user.ID,
+		AuthTime:             now,
+		AuthenticationMethod: "password",
+		AMR:                  []string{"pwd"},
+		LastActivityAt:       now,
+		ExpiresAt:            now.Add(24 * time.Hour),
+		CreatedAt:            now,
+	}
+	for _, opt := range opts {
+		opt(session)
+	}
+	return session
+}
+
+// AuthSession returns a pending authorization request by client for the code
+// flow with the openid scope
+func AuthSession(client *models.Client, opts ...func(*models.AuthSession)) *models.AuthSession {
+	n := next()
+	now := time.Now()
+	session := &models.AuthSession{
+		ID:           fmt.Sprintf("auth-session-%d", n),
+		ClientID:     client.ID,
+		RedirectURI:  client.RedirectURIs[0],
+		ResponseType: "code",
+		Scope:        "openid",
+		State:        fmt.Sprintf("state-%d", n),
+		Nonce:        fmt.Sprintf("nonce-%d", n),
+		ExpiresAt:    now.Add(10 * time.Minute),
+		CreatedAt:    now,
+	}
+	for _, opt := range opts {
+		opt(session)
+	}
+	return session
+}
@@ -0,0 +1,79 @@
+package storagetest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/prasenjit-net/openid-golang/pkg/crypto"
+	"github.com/prasenjit-net/openid-golang/pkg/models"
+	"github.com/prasenjit-net/openid-golang/pkg/storage"
+)
+
+func TestBuildersCreateValidRecords(t *testing.T) {
+	store := NewJSONStorage(t)
+	user := User(func(u *models.User) { u.Username = "alice" })
+	client := Client()
+	token := Token(user, client)
+	userSession := UserSession(user)
+	authSession := AuthSession(client, func(s *models.AuthSession) { s.UserID = user.ID })
+	Create(t, store, user, client, token, userSession, authSession)
+
+	gotUser, err := store.GetUserByUsername("alice")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, gotUser.ID)
+	assert.True(t, crypto.ValidatePassword(Password, gotUser.PasswordHash))
+
+	gotClient, err := store.ValidateClient(client.ID, client.Secret)
+	require.NoError(t, err)
+	assert.Equal(t, client.RedirectURIs, gotClient.RedirectURIs)
+
+	gotToken, err := store.GetTokenByAccessToken(token.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, gotToken.UserID)
+	assert.False(t, gotToken.IsExpired())
+
+	gotSession, err := store.GetUserSession(userSession.ID)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, gotSession.UserID)
+
+	gotAuth, err := store.GetAuthSession(authSession.ID)
+	require.NoError(t, err)
+	assert.Equal(t, client.RedirectURIs[0], gotAuth.RedirectURI)
+
+	// Every build gets fresh identifiers
+	assert.NotEqual(t, user.ID, User().ID)
+	assert.NotEqual(t, client.ID, Client().ID)
+}
+
+func TestMockStorage(t *testing.T) {
+	m := new(MockStorage)
+
+	// Methods without expectations return zero values
+	user, err := m.GetUserByID("u1")
+	assert.Nil(t, user)
+	assert.NoError(t, err)
+	used, err := m.MarkAuthorizationCodeUsed("code", time.Now())
+	assert.True(t, used)
+	assert.NoError(t, err)
+
+	// Expectations are matched and recorded as usual
+	want := User()
+	m.On("GetUserByID", want.ID).Return(want, nil)
+	m.On("DeleteUser", want.ID).Return(errors.New("boom"))
+	user, err = m.GetUserByID(want.ID)
+	require.NoError(t, err)
+	assert.Same(t, want, user)
+	assert.EqualError(t, m.DeleteUser(want.ID), "boom")
+
+	// Transactions run against the mock itself
+	require.NoError(t, m.RunInTransaction(func(tx storage.Storage) error {
+		_, err := tx.GetUserByID(want.ID)
+		return err
+	}))
+	m.AssertNumberOfCalls(t, "GetUserByID", 2)
+	m.AssertExpectations(t)
+}